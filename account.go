@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/channel"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/mail"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/urlfetch"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DELETE_ACCOUNT_FUNCTION_NAME = "processAccountDeletion"
+
+	// A deletion request has to be confirmed with the token sent to the user within ACCOUNT_DELETION_REQUEST_VALIDITY
+	ACCOUNT_DELETION_REQUEST_VALIDITY = time.Duration(1) * time.Hour
+	ACCOUNT_DELETION_MESSAGE_TYPE     = "accountDeletion"
+	FORM_FIELD_ACCOUNT_DELETION_TOKEN = "token"
+
+	GOOGLE_TOKEN_REVOCATION_URL = "https://accounts.google.com/o/oauth2/revoke"
+)
+
+var deleteAccount = delay.Func(DELETE_ACCOUNT_FUNCTION_NAME, processAccountDeletion)
+
+// accountDeletionMessage is the message sent to the connected client with the token confirming the deletion request
+type accountDeletionMessage struct {
+	Type      string    `json:"type"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// requestAccountDeletion is the endpoint for the logged in user to ask for the deletion of their account. Nothing is
+// deleted until the request is confirmed with the token sent to the user by email and over the channel.
+func requestAccountDeletion(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	token, deletionRequest, err := model.NewAccountDeletionRequest(time.Now(), ACCOUNT_DELETION_REQUEST_VALIDITY)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if err := store.StoreAccountDeletionRequest(context, store.GetUserKey(context, user.Email), deletionRequest); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "User [%s] requested the deletion of their account", user.Email)
+
+	message := &mail.Message{
+		Sender:  fmt.Sprintf("Glukit <noreply@%s.appspotmail.com>", appengine.AppID(context)),
+		To:      []string{user.Email},
+		Subject: "Confirm the deletion of your Glukit account",
+		Body: fmt.Sprintf("Someone, hopefully you, asked to delete your Glukit account and all of its data. To go ahead, enter "+
+			"this confirmation code on your profile page at https://%s/settings/profile before %s:\n\n%s\n\n"+
+			"If you didn't ask for this, ignore this email and nothing will be deleted.", request.Host,
+			deletionRequest.ExpiresAt.Format(time.RFC1123), token),
+	}
+	if err := mail.Send(context, message); err != nil {
+		log.Warningf(context, "Error sending account deletion confirmation to user [%s]: %v", user.Email, err)
+	}
+
+	if err := channel.SendJSON(context, user.Email, accountDeletionMessage{ACCOUNT_DELETION_MESSAGE_TYPE, token, deletionRequest.ExpiresAt}); err != nil {
+		log.Debugf(context, "Error sending account deletion confirmation to the client of user [%s]: %v", user.Email, err)
+	}
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// confirmAccountDeletion is the endpoint confirming the pending deletion request of the logged in user with its token.
+// The deletion itself runs in a task and the user is logged out right away.
+func confirmAccountDeletion(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	currentUser := currentGlukitUser(context)
+
+	token := strings.TrimSpace(request.FormValue(FORM_FIELD_ACCOUNT_DELETION_TOKEN))
+	if token == "" {
+		http.Error(writer, fmt.Sprintf("Missing value for %s.", FORM_FIELD_ACCOUNT_DELETION_TOKEN), 400)
+		return
+	}
+
+	err := store.ConfirmAccountDeletion(context, store.GetUserKey(context, currentUser.Email), token, time.Now())
+	if err == store.ErrAccountDeletionNotConfirmed {
+		http.Error(writer, "Invalid or expired confirmation code, ask for the deletion of your account again.", http.StatusForbidden)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	task, err := deleteAccount.Task(currentUser.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if _, err := taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "User [%s] confirmed the deletion of their account", currentUser.Email)
+
+	logoutUrl, err := user.LogoutURL(context, "/")
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	http.Redirect(writer, request, logoutUrl, http.StatusSeeOther)
+}
+
+// processAccountDeletion deletes the account of the user: the Google token is revoked, the profile is deleted so that
+// refreshes and imports still queued for the user stop, then the accounts linked to it, the grants and pending
+// invitations of other users to the user and everything stored under the profile, api keys, grants, invitations sent by
+// the user, snapshots and imported data included. It's safe to run again after a failure, the profile being gone only
+// means that its token was already revoked, so failures are returned for the task queue to retry it.
+func processAccountDeletion(context context.Context, userEmail string) (err error) {
+	defer recordFinalFailure(context, DELETE_ACCOUNT_FUNCTION_NAME, userEmail, "Deletion of account", nil, &err)
+
+	glukitUser, err := store.GetUserProfile(context, store.GetUserKey(context, userEmail))
+	if err == datastore.ErrNoSuchEntity {
+		log.Infof(context, "Profile of user [%s] was already deleted, finishing the deletion of their data", userEmail)
+	} else if err != nil {
+		return err
+	} else {
+		revokeGoogleToken(context, userEmail, glukitUser.RefreshToken, glukitUser.Token.AccessToken)
+
+		if err := store.DeleteUserProfile(context, userEmail); err != nil {
+			return err
+		}
+	}
+
+	aliases, err := store.GetAccountAliases(context, userEmail)
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range aliases {
+		revokeGoogleToken(context, alias.Email, alias.Token.RefreshToken, alias.Token.AccessToken)
+	}
+
+	if err := store.DeleteAccountAliases(context, userEmail); err != nil {
+		return err
+	}
+
+	grantCount, err := store.DeleteGrantsTo(context, userEmail)
+	if err != nil {
+		return err
+	}
+
+	invitationCount, err := store.DeleteShareInvitationsTo(context, userEmail)
+	if err != nil {
+		return err
+	}
+
+	count, err := store.DeleteUserData(context, userEmail)
+	if err != nil {
+		return err
+	}
+
+	log.Infof(context, "Deleted account of user [%s] with [%d] entities, [%d] grants and [%d] invitations to them", userEmail, count,
+		grantCount, invitationCount)
+	return nil
+}
+
+// revokeGoogleToken revokes the access of glukit to the Google account. Revoking the refresh token also revokes the
+// access tokens issued with it. Failures are only logged, the token is deleted with the profile anyway.
+func revokeGoogleToken(context context.Context, email string, refreshToken string, accessToken string) {
+	token := refreshToken
+	if token == "" {
+		token = accessToken
+	}
+
+	if token == "" {
+		return
+	}
+
+	response, err := urlfetch.Client(context).PostForm(GOOGLE_TOKEN_REVOCATION_URL, url.Values{"token": {token}})
+	if err != nil {
+		log.Warningf(context, "Error revoking google token of user [%s]: %v", email, err)
+		return
+	}
+	defer response.Body.Close()
+
+	// Google answers with a bad request for tokens that were already revoked or expired
+	if response.StatusCode != http.StatusOK {
+		log.Warningf(context, "Revocation of google token of user [%s] got status [%s]", email, response.Status)
+		return
+	}
+
+	log.Infof(context, "Revoked google token of user [%s]", email)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/datastore"
+	"testing"
+	"time"
+)
+
+const (
+	DELETED_TEST_USER = "deleted@glukit.com"
+	FRIEND_TEST_USER  = "friend@glukit.com"
+)
+
+func TestAccountDeletionLeavesNothingOfTheUser(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	now := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	userProfileKey, err := store.StoreUserProfile(c, now, model.GlukitUser{Email: DELETED_TEST_USER, AccountCreated: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	friendProfileKey, err := store.StoreUserProfile(c, now, model.GlukitUser{Email: FRIEND_TEST_USER, AccountCreated: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.StoreAccountAlias(c, model.AccountAlias{Email: "other-" + DELETED_TEST_USER, PrimaryEmail: DELETED_TEST_USER, LinkedOn: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A grant of the friend to the user, one of the user to the friend and a pending invitation each way
+	inviteAndAccept(t, c, friendProfileKey, DELETED_TEST_USER, now)
+	inviteAndAccept(t, c, userProfileKey, FRIEND_TEST_USER, now)
+	for _, invitation := range []struct {
+		ownerKey     *datastore.Key
+		inviteeEmail string
+	}{{friendProfileKey, DELETED_TEST_USER}, {userProfileKey, FRIEND_TEST_USER}} {
+		_, pending, err := model.NewShareInvitation(invitation.inviteeEmail, model.SHARE_PERMISSION_READ_ONLY, time.Time{}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.StoreShareInvitation(c, invitation.ownerKey, pending); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, apiKey, err := model.NewApiKey("reports", []string{model.API_KEY_SCOPE_READ_GLUCOSE}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.StoreApiKey(c, userProfileKey, apiKey); err != nil {
+		t.Fatal(err)
+	}
+
+	reads := []apimodel.GlucoseRead{{Time: apimodel.Time{Timestamp: apimodel.GetTimeMillis(now), TimeZoneId: "UTC"}, Unit: apimodel.MG_PER_DL, Value: 100}}
+	if _, _, err := store.StoreDaysOfReads(c, userProfileKey, apimodel.DEFAULT_DEVICE_ID, []apimodel.DayOfGlucoseReads{apimodel.NewDayOfGlucoseReads(reads)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := processAccountDeletion(c, DELETED_TEST_USER); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetUserProfile(c, userProfileKey); err != datastore.ErrNoSuchEntity {
+		t.Errorf("Expected profile of [%s] to be deleted but got [%v]", DELETED_TEST_USER, err)
+	}
+
+	if count, err := datastore.NewQuery("").Ancestor(userProfileKey).KeysOnly().Count(c); err != nil || count != 0 {
+		t.Errorf("Expected no entity left under the profile of [%s] but got [%d] with [%v]", DELETED_TEST_USER, count, err)
+	}
+
+	if aliases, err := store.GetAccountAliases(c, DELETED_TEST_USER); err != nil || len(aliases) != 0 {
+		t.Errorf("Expected no account linked to [%s] but got %v with [%v]", DELETED_TEST_USER, aliases, err)
+	}
+
+	if grants, err := store.GetGrantsTo(c, DELETED_TEST_USER, now); err != nil || len(grants) != 0 {
+		t.Errorf("Expected no grant to [%s] but got %v with [%v]", DELETED_TEST_USER, grants, err)
+	}
+
+	query := datastore.NewQuery("ShareInvitation").Filter("inviteeEmail =", DELETED_TEST_USER).KeysOnly()
+	if count, err := query.Count(c); err != nil || count != 0 {
+		t.Errorf("Expected no invitation to [%s] but got [%d] with [%v]", DELETED_TEST_USER, count, err)
+	}
+
+	if _, err := store.GetUserProfile(c, friendProfileKey); err != nil {
+		t.Errorf("Expected profile of [%s] to be kept but got [%v]", FRIEND_TEST_USER, err)
+	}
+
+	// Running the deletion again, as the task queue does after a failure, finds nothing left to delete
+	if err := processAccountDeletion(c, DELETED_TEST_USER); err != nil {
+		t.Errorf("Expected deletion of a deleted account to succeed but got [%v]", err)
+	}
+}
+
+// inviteAndAccept grants the invitee access to the data of the owner as if they had accepted an invitation
+func inviteAndAccept(t *testing.T, c aetest.Context, ownerKey *datastore.Key, inviteeEmail string, now time.Time) {
+	token, invitation, err := model.NewShareInvitation(inviteeEmail, model.SHARE_PERMISSION_READ_ONLY, time.Time{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.StoreShareInvitation(c, ownerKey, invitation); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.AcceptShareInvitation(c, model.HashShareInvitationToken(token), inviteeEmail, now); err != nil {
+		t.Fatal(err)
+	}
+}
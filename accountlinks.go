@@ -0,0 +1,240 @@
+package main
+
+import (
+	"code.google.com/p/gorilla/mux"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// A link request has to be confirmed by signing in with the other account within ACCOUNT_LINK_REQUEST_VALIDITY
+	ACCOUNT_LINK_REQUEST_VALIDITY = time.Duration(15) * time.Minute
+
+	// Fields of the confirmation of a link, the primary account being the one that asked for it, and path variable of
+	// the email address of an unlinked account
+	FORM_FIELD_LINK_PRIMARY = "primary"
+	FORM_FIELD_LINK_TOKEN   = "token"
+	PATH_VAR_LINKED_EMAIL   = "email"
+)
+
+// AccountLinkVariables are the variables of the page confirming the link of the signed in account to the primary one
+type AccountLinkVariables struct {
+	PrimaryEmail string
+	Email        string
+	Token        string
+}
+
+// currentGlukitUser returns the signed in user with the email address of the GlukitUser they sign in to, the primary
+// account if the one they signed in with is linked to it (see store.ResolveUser). Nil is returned if no user is signed
+// in. The signed in user is returned as is if their account can't be resolved, the store failures that cause it also
+// failing the handler's own store calls.
+func currentGlukitUser(context context.Context) *user.User {
+	signedIn := user.Current(context)
+	if signedIn == nil {
+		return nil
+	}
+
+	primaryEmail, _, err := store.ResolveUser(context, signedIn.Email)
+	if err != nil {
+		log.Errorf(context, "Error resolving the account of user [%s]: %v", signedIn.Email, err)
+		return signedIn
+	}
+
+	resolved := *signedIn
+	resolved.Email = primaryEmail
+	return &resolved
+}
+
+// requestAccountLink is the endpoint for the logged in user to link another Google account to their profile. The user
+// is signed out and asked to sign in with the other account, which confirms the link.
+func requestAccountLink(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	glukitAccount := currentGlukitUser(context)
+
+	token, linkRequest, err := model.NewAccountLinkRequest(time.Now(), ACCOUNT_LINK_REQUEST_VALIDITY)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if err := store.StoreAccountLinkRequest(context, store.GetUserKey(context, glukitAccount.Email), linkRequest); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "User [%s] asked to link another account", glukitAccount.Email)
+
+	confirmation := url.Values{}
+	confirmation.Set(FORM_FIELD_LINK_PRIMARY, glukitAccount.Email)
+	confirmation.Set(FORM_FIELD_LINK_TOKEN, token)
+	loginUrl, err := user.LoginURL(context, "/settings/accounts/link/confirm?"+confirmation.Encode())
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	logoutUrl, err := user.LogoutURL(context, loginUrl)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	http.Redirect(writer, request, logoutUrl, http.StatusSeeOther)
+}
+
+// showAccountLink is the endpoint showing the account the user signed in with the primary account it would be linked to
+// for the user to confirm it
+func showAccountLink(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	signedIn := user.Current(context)
+
+	primaryEmail, token := request.FormValue(FORM_FIELD_LINK_PRIMARY), request.FormValue(FORM_FIELD_LINK_TOKEN)
+	err := store.CheckAccountLinkRequest(context, store.GetUserKey(context, primaryEmail), token, time.Now())
+	if err == store.ErrAccountLinkNotConfirmed {
+		http.Error(writer, "Invalid or expired link request, ask to link your account again.", http.StatusForbidden)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	writer.Header().Set("Content-Security-Policy", CONTENT_SECURITY_POLICY)
+	if err := accountLinkTemplate.Execute(writer, AccountLinkVariables{primaryEmail, signedIn.Email, token}); err != nil {
+		log.Criticalf(context, "Error executing template [%s]", accountLinkTemplate.Name())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// confirmAccountLink is the endpoint linking the account the user signed in with to the primary account that asked for
+// it, see linkAccount
+func confirmAccountLink(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	signedIn := user.Current(context)
+
+	primaryEmail, token := request.FormValue(FORM_FIELD_LINK_PRIMARY), request.FormValue(FORM_FIELD_LINK_TOKEN)
+	now := time.Now()
+	if err := linkAccount(context, primaryEmail, signedIn.Email, token, now); err != nil {
+		if apiError, ok := err.(ApiError); ok {
+			http.Error(writer, apiError.Message, apiError.Status)
+		} else {
+			writeError(context, writer, err)
+		}
+		return
+	}
+	log.Infof(context, "Linked account [%s] to [%s]", signedIn.Email, primaryEmail)
+
+	if err := enqueueRefresh(context, primaryEmail, false, now, REFRESH_TRIGGER_MANUAL); err != nil {
+		log.Warningf(context, "Error enqueuing refresh of user [%s] after linking [%s]: %v", primaryEmail, signedIn.Email, err)
+	}
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// linkAccount links the account with the given email address to the primary account if the token confirms the link
+// request of the primary account as of now. Data is never moved between accounts so linking is refused if the account
+// has imported data, the user being asked to link the accounts the other way around if the primary one doesn't. The
+// profile of the account is deleted and its token kept on the alias to keep searching its Google Drive. Links that are
+// refused are reported as ApiError values.
+func linkAccount(context context.Context, primaryEmail string, email string, token string, now time.Time) (err error) {
+	if strings.EqualFold(primaryEmail, email) {
+		return ApiError{Status: http.StatusBadRequest, Message: "Sign in with the account to link, not the one it's linked to."}
+	}
+
+	if alias, err := store.GetAccountAlias(context, email); err == nil {
+		return ApiError{Status: http.StatusConflict, Message: fmt.Sprintf("[%s] is already linked to [%s], unlink it first.", email,
+			alias.PrimaryEmail)}
+	} else if err != datastore.ErrNoSuchEntity {
+		return err
+	}
+
+	if _, err := store.GetAccountAlias(context, primaryEmail); err == nil {
+		return ApiError{Status: http.StatusConflict, Message: fmt.Sprintf("[%s] is linked to another account, link from that one instead.",
+			primaryEmail)}
+	} else if err != datastore.ErrNoSuchEntity {
+		return err
+	}
+
+	aliases, err := store.GetAccountAliases(context, email)
+	if err != nil {
+		return err
+	}
+
+	if len(aliases) > 0 {
+		return ApiError{Status: http.StatusConflict, Message: fmt.Sprintf("[%s] has accounts linked to it, it can't be linked to another one.",
+			email)}
+	}
+
+	primaryUser, err := store.GetUserProfile(context, store.GetUserKey(context, primaryEmail))
+	if err != nil {
+		return err
+	}
+
+	signedInUser, err := store.GetUserProfile(context, store.GetUserKey(context, email))
+	if err == datastore.ErrNoSuchEntity {
+		return ApiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Sign in to glukit with [%s] once before linking it so that its Google Drive can be searched.",
+			email)}
+	} else if err != nil {
+		return err
+	}
+
+	if signedInUser.HasImportedData() && primaryUser.HasImportedData() {
+		return ApiError{Status: http.StatusConflict, Message: fmt.Sprintf("Both [%s] and [%s] already have data, accounts that both have data can't be merged.",
+			primaryEmail, email)}
+	} else if signedInUser.HasImportedData() {
+		return ApiError{Status: http.StatusConflict, Message: fmt.Sprintf("[%s] already has data, sign in with it and link [%s] to it instead.",
+			email, primaryEmail)}
+	}
+
+	err = store.ConsumeAccountLinkRequest(context, store.GetUserKey(context, primaryEmail), token, now)
+	if err == store.ErrAccountLinkNotConfirmed {
+		return ApiError{Status: http.StatusForbidden, Message: "Invalid or expired link request, ask to link your account again."}
+	} else if err != nil {
+		return err
+	}
+
+	aliasToken := signedInUser.Token
+	aliasToken.RefreshToken = signedInUser.RefreshToken
+	alias := model.AccountAlias{Email: email, PrimaryEmail: primaryEmail, Token: aliasToken, LinkedOn: now}
+	if err := store.StoreAccountAlias(context, alias); err != nil {
+		return err
+	}
+
+	if err := store.DeleteUserProfile(context, email); err != nil {
+		return err
+	}
+
+	if _, err := store.DeleteUserData(context, email); err != nil {
+		log.Warningf(context, "Error deleting the settings of [%s] after linking it to [%s]: %v", email, primaryEmail, err)
+	}
+
+	return nil
+}
+
+// unlinkAccount is the endpoint to unlink an account from the profile of the logged in user. Data imported from its
+// Google Drive is kept, signing in with the account starts a new profile.
+func unlinkAccount(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	email := mux.Vars(request)[PATH_VAR_LINKED_EMAIL]
+	err := store.DeleteAccountAlias(context, user.Email, email)
+	if err == store.ErrAccountAliasNotFound {
+		http.Error(writer, fmt.Sprintf("No account [%s] linked to yours.", email), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Unlinked account [%s] from [%s]", email, user.Email)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const (
+	PRIMARY_TEST_USER = "primary@glukit.com"
+	LINKED_TEST_USER  = "linked@glukit.com"
+)
+
+func TestCurrentGlukitUserResolvesAliases(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Login(&user.User{Email: LINKED_TEST_USER, AuthDomain: "glukit.com"})
+	if signedIn := currentGlukitUser(c); signedIn == nil || signedIn.Email != LINKED_TEST_USER {
+		t.Errorf("Expected an account that isn't linked to be [%s] but got [%v]", LINKED_TEST_USER, signedIn)
+	}
+
+	alias := model.AccountAlias{Email: LINKED_TEST_USER, PrimaryEmail: PRIMARY_TEST_USER, LinkedOn: time.Now()}
+	if err := store.StoreAccountAlias(c, alias); err != nil {
+		t.Fatal(err)
+	}
+
+	if signedIn := currentGlukitUser(c); signedIn == nil || signedIn.Email != PRIMARY_TEST_USER {
+		t.Errorf("Expected [%s] to sign in to [%s] but got [%v]", LINKED_TEST_USER, PRIMARY_TEST_USER, signedIn)
+	}
+}
+
+func TestLinkAccountIsRefusedWhenBothAccountsHaveData(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	now := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	read := apimodel.GlucoseRead{Time: apimodel.Time{Timestamp: apimodel.GetTimeMillis(now), TimeZoneId: "UTC"}, Unit: apimodel.MG_PER_DL, Value: 100}
+	storeLinkTestProfile(t, c, PRIMARY_TEST_USER, read, now)
+	storeLinkTestProfile(t, c, LINKED_TEST_USER, read, now)
+	token := storeLinkTestRequest(t, c, now)
+
+	if err := linkAccount(c, PRIMARY_TEST_USER, LINKED_TEST_USER, token, now); err == nil || err.(ApiError).Status != http.StatusConflict {
+		t.Errorf("Expected a [%d] error linking accounts that both have data but got [%v]", http.StatusConflict, err)
+	}
+
+	if _, err := store.GetAccountAlias(c, LINKED_TEST_USER); err == nil {
+		t.Errorf("Expected [%s] not to be linked", LINKED_TEST_USER)
+	}
+}
+
+func TestLinkAccountTokenIsUsedOnce(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	now := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	storeLinkTestProfile(t, c, PRIMARY_TEST_USER, apimodel.UNDEFINED_GLUCOSE_READ, now)
+	storeLinkTestProfile(t, c, LINKED_TEST_USER, apimodel.UNDEFINED_GLUCOSE_READ, now)
+	storeLinkTestProfile(t, c, "other@glukit.com", apimodel.UNDEFINED_GLUCOSE_READ, now)
+	token := storeLinkTestRequest(t, c, now)
+
+	if err := linkAccount(c, PRIMARY_TEST_USER, LINKED_TEST_USER, token, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if alias, err := store.GetAccountAlias(c, LINKED_TEST_USER); err != nil || alias.PrimaryEmail != PRIMARY_TEST_USER {
+		t.Errorf("Expected [%s] to be linked to [%s] but got [%v] with [%v]", LINKED_TEST_USER, PRIMARY_TEST_USER, alias, err)
+	}
+
+	if err := linkAccount(c, PRIMARY_TEST_USER, "other@glukit.com", token, now); err == nil || err.(ApiError).Status != http.StatusForbidden {
+		t.Errorf("Expected a [%d] error reusing the link token but got [%v]", http.StatusForbidden, err)
+	}
+}
+
+func TestLinkAccountTokenExpires(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	now := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	storeLinkTestProfile(t, c, PRIMARY_TEST_USER, apimodel.UNDEFINED_GLUCOSE_READ, now)
+	storeLinkTestProfile(t, c, LINKED_TEST_USER, apimodel.UNDEFINED_GLUCOSE_READ, now)
+	token := storeLinkTestRequest(t, c, now)
+
+	expired := now.Add(ACCOUNT_LINK_REQUEST_VALIDITY)
+	if err := linkAccount(c, PRIMARY_TEST_USER, LINKED_TEST_USER, token, expired); err == nil || err.(ApiError).Status != http.StatusForbidden {
+		t.Errorf("Expected a [%d] error with an expired link token but got [%v]", http.StatusForbidden, err)
+	}
+}
+
+// storeLinkTestProfile stores the profile of the user with the given most recent read
+func storeLinkTestProfile(t *testing.T, c aetest.Context, email string, mostRecentRead apimodel.GlucoseRead, now time.Time) {
+	user := model.GlukitUser{Email: email, AccountCreated: now, MostRecentRead: mostRecentRead}
+	if _, err := store.StoreUserProfile(c, now, user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storeLinkTestRequest stores a request of the primary test user to link another account and returns its token
+func storeLinkTestRequest(t *testing.T, c aetest.Context, now time.Time) (token string) {
+	token, linkRequest, err := model.NewAccountLinkRequest(now, ACCOUNT_LINK_REQUEST_VALIDITY)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.StoreAccountLinkRequest(c, store.GetUserKey(c, PRIMARY_TEST_USER), linkRequest); err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
@@ -1,26 +1,52 @@
 package main
 
 import (
+	"code.google.com/p/gorilla/mux"
 	"encoding/json"
 	"fmt"
 	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/auth"
 	"github.com/alexandre-normand/glukit/app/bufio"
 	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
 	"github.com/alexandre-normand/glukit/app/store"
 	"github.com/alexandre-normand/glukit/app/streaming"
+	"golang.org/x/net/context"
 	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	GLUCOSEREADS_V1_ROUTE = "v1_glucosereads"
-	CALIBRATIONS_V1_ROUTE = "v1_calibrations"
-	EXERCISES_V1_ROUTE    = "v1_exercises"
-	MEALS_V1_ROUTE        = "v1_meals"
-	INJECTIONS_V1_ROUTE   = "v1_injections"
+	GLUCOSEREADS_V1_ROUTE   = "v1_glucosereads"
+	CALIBRATIONS_V1_ROUTE   = "v1_calibrations"
+	EXERCISES_V1_ROUTE      = "v1_exercises"
+	MEALS_V1_ROUTE          = "v1_meals"
+	INJECTIONS_V1_ROUTE     = "v1_injections"
+	API_V1_DATA_ROUTE       = "api_v1_data"
+	API_V1_ONBOARDING_ROUTE = "api_v1_onboarding"
+
+	// Path variable of the kind of data read with the v1 api and its values
+	PATH_VAR_API_KIND   = "kind"
+	API_KIND_READS      = "reads"
+	API_KIND_MEALS      = "meals"
+	API_KIND_INJECTIONS = "injections"
+	API_KIND_EXERCISES  = "exercises"
+
+	// Query parameter of the cursor of the page of data read with the v1 api. Pages have DEFAULT_API_PAGE_LIMIT elements
+	// unless a limit of at most MAX_API_PAGE_LIMIT is requested and the range of a request spans at most MAX_API_RANGE,
+	// the last DEFAULT_API_RANGE by default.
+	QUERY_PARAM_CURSOR     = "cursor"
+	DEFAULT_API_PAGE_LIMIT = 500
+	MAX_API_PAGE_LIMIT     = 5000
+	DEFAULT_API_RANGE      = time.Duration(24) * time.Hour
+	MAX_API_RANGE          = time.Duration(31*24) * time.Hour
 )
 
 // Represents the logging of a file import
@@ -41,9 +67,25 @@ func CurrentApiUser(request *http.Request) (user *ApiUser) {
 		return nil
 	}
 
-	// load access data
+	if auth.IsIdToken(accessCode) {
+		email, err := authenticateIdToken(appengine.NewContext(request), accessCode)
+		if err != nil {
+			return nil
+		}
+
+		return &ApiUser{email}
+	}
+
+	// load access data, the token of an account linked to another one since it was issued acting for the primary account
 	if accessData, err := server.Storage.LoadAccess(accessCode, request); err == nil {
-		return &ApiUser{accessData.UserData.(string)}
+		context := appengine.NewContext(request)
+		email, _, err := store.ResolveUser(context, accessData.UserData.(string))
+		if err != nil {
+			log.Errorf(context, "Error resolving user of access token for [%s]: %v", accessData.UserData, err)
+			return nil
+		}
+
+		return &ApiUser{email}
 	}
 
 	return nil
@@ -55,6 +97,281 @@ func initApiEndpoints(writer http.ResponseWriter, request *http.Request) {
 	muxRouter.Get(MEALS_V1_ROUTE).Handler(newOauthAuthenticationHandler(http.HandlerFunc(processNewMealData)))
 	muxRouter.Get(GLUCOSEREADS_V1_ROUTE).Handler(newOauthAuthenticationHandler(http.HandlerFunc(processNewGlucoseReadData)))
 	muxRouter.Get(EXERCISES_V1_ROUTE).Handler(newOauthAuthenticationHandler(http.HandlerFunc(processNewExerciseData)))
+	muxRouter.Get(API_V1_DATA_ROUTE).Handler(http.HandlerFunc(apiData))
+	muxRouter.Get(API_V1_ONBOARDING_ROUTE).Handler(http.HandlerFunc(apiOnboarding))
+}
+
+// ApiPage is a page of data read with the v1 api. Data is the array of elements of the page, sorted by time, whose
+// json representation is the same as the one used to send data to the v1 api. NextCursor is the value of the cursor
+// parameter that gets the next page and is omitted from the last page.
+type ApiPage struct {
+	Kind       string      `json:"kind"`
+	From       time.Time   `json:"from"`
+	To         time.Time   `json:"to"`
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// ApiError is the body of the error responses of the v1 api, Status being the http status of the response. Code tells
+// apart errors of the same status that clients handle differently, like an expired id token.
+type ApiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+func (e ApiError) Error() string {
+	return e.Message
+}
+
+// apiData is the v1 api endpoint to read the data of a user, one page at a time:
+//
+//	GET /api/v1/{reads|meals|injections|exercises}?from=&to=&limit=&cursor=
+//
+// The from and to parameters are the bounds, both inclusive, of the range of data read as RFC3339 times or yyyy-mm-dd
+// dates in UTC, see model.ParseTimeRange. They default to the last DEFAULT_API_RANGE and can't be more than MAX_API_RANGE apart. The limit is the maximum number of elements of
+// the page and the cursor the nextCursor of the previous page, if any. The user is authenticated either by its session
+// or by an api key, access token or Google id token given as a bearer token in the Authorization header. Api keys need
+// the read:glucose scope to read reads and the read:events one for the other kinds. Errors are ApiError values with a
+// status of 400 for invalid parameters, 401 for requests that aren't authenticated, with the id_token_expired or
+// id_token_invalid code for id tokens that expired or are invalid, 403 for api keys without the required scope and 404
+// for unknown users.
+func apiData(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	request.ParseForm()
+
+	kind := mux.Vars(request)[PATH_VAR_API_KIND]
+	scope, known := apiKindScope(kind)
+	if !known {
+		writeApiError(writer, ApiError{Status: http.StatusNotFound, Message: fmt.Sprintf("Unknown kind of data [%s].", kind)})
+		return
+	}
+
+	email, err := authenticateApiRequest(context, request, scope)
+	if apiError, ok := err.(ApiError); ok {
+		writeApiError(writer, apiError)
+		return
+	} else if err != nil {
+		log.Errorf(context, "Error authenticating api request: %v", err)
+		writeApiError(writer, ApiError{Status: http.StatusInternalServerError, Message: fmt.Sprintf("Error authenticating request: %v", err)})
+		return
+	}
+
+	// Data of another user is only ever read through a grant of theirs
+	ownerEmail, err := resolveDataOwner(context, request, email)
+	if err == store.ErrShareGrantNotFound {
+		writeApiError(writer, ApiError{Status: http.StatusForbidden, Message: fmt.Sprintf("No access to the data of [%s].", request.Form.Get(QUERY_PARAM_ON_BEHALF_OF))})
+		return
+	} else if err != nil {
+		log.Errorf(context, "Error checking the access of [%s] to the data of [%s]: %v", email, request.Form.Get(QUERY_PARAM_ON_BEHALF_OF), err)
+		writeApiError(writer, ApiError{Status: http.StatusInternalServerError, Message: fmt.Sprintf("Error checking access: %v", err)})
+		return
+	}
+
+	page, err := getApiPage(context, ownerEmail, kind, request.Form)
+	if apiError, ok := err.(ApiError); ok {
+		writeApiError(writer, apiError)
+		return
+	} else if err != nil {
+		log.Errorf(context, "Error reading data of user [%s] with the api: %v", ownerEmail, err)
+		writeApiError(writer, ApiError{Status: http.StatusInternalServerError, Message: fmt.Sprintf("Error reading data: %v", err)})
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(page)
+}
+
+// apiOnboarding is the v1 api endpoint returning the model.OnboardingState of the user, the frontend showing the
+// progress of the first import of a new user with it until the user is ready. Later transitions are also sent to the
+// connected client of the user. The user is authenticated the same way as for apiData, api keys needing the
+// read:glucose scope.
+func apiOnboarding(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+
+	email, err := authenticateApiRequest(context, request, model.API_KEY_SCOPE_READ_GLUCOSE)
+	if apiError, ok := err.(ApiError); ok {
+		writeApiError(writer, apiError)
+		return
+	} else if err != nil {
+		log.Errorf(context, "Error authenticating api request: %v", err)
+		writeApiError(writer, ApiError{Status: http.StatusInternalServerError, Message: fmt.Sprintf("Error authenticating request: %v", err)})
+		return
+	}
+
+	glukitUser, err := store.GetUserProfile(context, store.GetUserKey(context, email))
+	if err == datastore.ErrNoSuchEntity {
+		writeApiError(writer, ApiError{Status: http.StatusNotFound, Message: fmt.Sprintf("No user [%s].", email)})
+		return
+	} else if err != nil {
+		log.Errorf(context, "Error getting onboarding state of user [%s]: %v", email, err)
+		writeApiError(writer, ApiError{Status: http.StatusInternalServerError, Message: fmt.Sprintf("Error getting onboarding state: %v", err)})
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(glukitUser.GetOnboardingState())
+}
+
+// apiKindScope returns the api key scope needed to read the given kind of data with the v1 api
+func apiKindScope(kind string) (scope string, known bool) {
+	switch kind {
+	case API_KIND_READS:
+		return model.API_KEY_SCOPE_READ_GLUCOSE, true
+	case API_KIND_MEALS, API_KIND_INJECTIONS, API_KIND_EXERCISES:
+		return model.API_KEY_SCOPE_READ_EVENTS, true
+	default:
+		return "", false
+	}
+}
+
+// authenticateApiRequest returns the email of the user authenticated by the session of a request to the v1 api or by
+// its bearer token. Bearer tokens are either api keys, which must grant the given scope, Google id tokens of native
+// clients, see authenticateIdToken, or oauth access tokens. The use of api keys is recorded at most once per
+// model.API_KEY_USE_RECORDING_INTERVAL. Requests that aren't authenticated or allowed are reported as ApiError values.
+func authenticateApiRequest(context context.Context, request *http.Request, scope string) (email string, err error) {
+	if sessionUser := currentGlukitUser(context); sessionUser != nil {
+		return sessionUser.Email, nil
+	}
+
+	token := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if auth.IsIdToken(token) {
+		return authenticateIdToken(context, token)
+	}
+
+	if !model.IsApiKey(token) {
+		if apiUser := CurrentApiUser(request); apiUser != nil {
+			return apiUser.Email, nil
+		}
+
+		return "", ApiError{Status: http.StatusUnauthorized, Message: "Authentication required, either by session or bearer token."}
+	}
+
+	key, apiKey, err := store.FindApiKey(context, model.HashApiKey(token))
+	if err == store.ErrApiKeyNotFound {
+		return "", ApiError{Status: http.StatusUnauthorized, Message: "Invalid or revoked api key."}
+	} else if err != nil {
+		return "", err
+	}
+
+	if !apiKey.HasScope(scope) {
+		return "", ApiError{Status: http.StatusForbidden, Message: fmt.Sprintf("Api key [%s] doesn't grant the [%s] scope.", apiKey.Prefix, scope)}
+	}
+
+	if now := time.Now(); apiKey.IsUseRecordingDue(now) {
+		if err := store.RecordApiKeyUse(context, key, now); err != nil {
+			log.Warningf(context, "Error recording the use of api key [%s]: %v", apiKey.Prefix, err)
+		}
+	}
+
+	return key.Parent().StringID(), nil
+}
+
+func writeApiError(writer http.ResponseWriter, apiError ApiError) {
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+	writer.WriteHeader(apiError.Status)
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(apiError)
+}
+
+// getApiPage returns the page of data of the given kind of the user selected by the parameters of a v1 api request.
+// Invalid parameters and unknown users are reported as ApiError values.
+func getApiPage(context context.Context, email string, kind string, params url.Values) (page *ApiPage, err error) {
+	switch kind {
+	case API_KIND_READS, API_KIND_MEALS, API_KIND_INJECTIONS, API_KIND_EXERCISES:
+	default:
+		return nil, ApiError{Status: http.StatusNotFound, Message: fmt.Sprintf("Unknown kind of data [%s].", kind)}
+	}
+
+	now := time.Now()
+	timeRange, err := model.ParseTimeRangeOr(params.Get(QUERY_PARAM_FROM), params.Get(QUERY_PARAM_TO), time.UTC,
+		model.TimeRange{now.Add(-DEFAULT_API_RANGE), now})
+	if err == nil {
+		err = timeRange.Validate(MAX_API_RANGE)
+	}
+	if err != nil {
+		return nil, ApiError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	lowerBound, upperBound := timeRange.LowerBound, timeRange.UpperBound
+
+	limit := DEFAULT_API_PAGE_LIMIT
+	if param := params.Get(QUERY_PARAM_LIMIT); len(param) > 0 {
+		value, err := strconv.Atoi(param)
+		if err != nil || value < 1 || value > MAX_API_PAGE_LIMIT {
+			return nil, ApiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for %s: [%s], expected a number from 1 to %d.",
+				QUERY_PARAM_LIMIT, param, MAX_API_PAGE_LIMIT)}
+		}
+		limit = value
+	}
+
+	// Pages after the first are read starting at the time of their cursor
+	var cursor *apimodel.PageCursor
+	queryStart := lowerBound
+	if param := params.Get(QUERY_PARAM_CURSOR); len(param) > 0 {
+		value, err := apimodel.ParsePageCursor(param)
+		if err != nil {
+			return nil, ApiError{Status: http.StatusBadRequest, Message: err.Error()}
+		}
+
+		if value.GetTime().Before(lowerBound) || value.GetTime().After(upperBound) {
+			return nil, ApiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Cursor [%s] is outside of the range from %s to %s.",
+				param, QUERY_PARAM_FROM, QUERY_PARAM_TO)}
+		}
+		cursor, queryStart = &value, value.GetTime()
+	}
+
+	if _, _, err := store.GetGlukitUser(context, email); err == datastore.ErrNoSuchEntity {
+		return nil, ApiError{Status: http.StatusNotFound, Message: fmt.Sprintf("No user [%s].", email)}
+	} else if err != nil {
+		return nil, err
+	}
+
+	page = &ApiPage{Kind: kind, From: lowerBound, To: upperBound}
+	var next *apimodel.PageCursor
+	switch kind {
+	case API_KIND_READS:
+		reads, err := store.GetGlucoseReads(context, email, queryStart, upperBound)
+		if err != nil {
+			return nil, err
+		}
+		startIndex, endIndex, nextPage := apimodel.GetPage(apimodel.GlucoseReadSlice(reads), cursor, limit)
+		page.Data, next = reads[startIndex:endIndex], nextPage
+	case API_KIND_MEALS:
+		meals, err := store.GetMeals(context, email, queryStart, upperBound)
+		if err != nil {
+			return nil, err
+		}
+		startIndex, endIndex, nextPage := apimodel.GetPage(apimodel.MealSlice(meals), cursor, limit)
+		page.Data, next = meals[startIndex:endIndex], nextPage
+	case API_KIND_INJECTIONS:
+		injections, err := store.GetInjections(context, email, queryStart, upperBound)
+		if err != nil {
+			return nil, err
+		}
+		startIndex, endIndex, nextPage := apimodel.GetPage(apimodel.InjectionSlice(injections), cursor, limit)
+		page.Data, next = injections[startIndex:endIndex], nextPage
+	case API_KIND_EXERCISES:
+		exercises, err := store.GetExercises(context, email, queryStart, upperBound)
+		if err != nil {
+			return nil, err
+		}
+		startIndex, endIndex, nextPage := apimodel.GetPage(apimodel.ExerciseSlice(exercises), cursor, limit)
+		page.Data, next = exercises[startIndex:endIndex], nextPage
+	}
+
+	if next != nil {
+		page.NextCursor = next.Encode()
+	}
+
+	return page, nil
 }
 
 // processNewCalibrationData Handles a Post to the calibration endpoint and
@@ -163,6 +480,13 @@ func processNewGlucoseReadData(writer http.ResponseWriter, request *http.Request
 		return
 	}
 
+	if mostRecentRead := dataStoreWriter.MostRecentRead(); mostRecentRead != apimodel.UNDEFINED_GLUCOSE_READ {
+		if err = store.UpdateMostRecentRead(context, userProfileKey, mostRecentRead); err != nil {
+			http.Error(writer, fmt.Sprintf("Error storing data: %v", err), 502)
+			return
+		}
+	}
+
 	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
 	if err != nil {
 		log.Warningf(context, "Couldn't get glukit user profile [%s] to recalculate score: %v", user.Email, err)
@@ -178,6 +502,11 @@ func processNewGlucoseReadData(writer http.ResponseWriter, request *http.Request
 		log.Warningf(context, "Error starting a1c calculation batch for user [%s]: %v", user.Email, err)
 	}
 
+	err = engine.StartHypoDetectionBatch(context, glukitUser)
+	if err != nil {
+		log.Warningf(context, "Error starting hypo detection batch for user [%s]: %v", user.Email, err)
+	}
+
 	log.Infof(context, "Wrote glucose reads to the datastore for user [%s]", user.Email)
 	writer.WriteHeader(200)
 }
@@ -266,6 +595,10 @@ func processNewMealData(writer http.ResponseWriter, request *http.Request) {
 			break
 		}
 
+		for i := range meals {
+			meals[i].Description = strings.TrimSpace(meals[i].Description)
+		}
+
 		log.Debugf(context, "Writing [%d] new meals", len(meals))
 		mealStreamer, err = mealStreamer.WriteMeals(meals)
 		if err != nil {
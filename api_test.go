@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine/aetest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const API_TEST_USER = "api@glukit.com"
+
+func setupApiTestData(t *testing.T, start time.Time, count int) aetest.Context {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := model.GlukitUser{Email: API_TEST_USER, DateOfBirth: start, LastUpdated: util.GLUKIT_EPOCH_TIME,
+		MostRecentRead: apimodel.UNDEFINED_GLUCOSE_READ, BestScore: model.UNDEFINED_SCORE,
+		MostRecentScore: model.UNDEFINED_SCORE, AccountCreated: start, MostRecentA1C: model.UNDEFINED_A1C_ESTIMATE,
+		TargetLow: apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD, TargetHigh: apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD,
+		GlucoseUnit: apimodel.MG_PER_DL, TherapyMode: model.THERAPY_MODE_UNKNOWN}
+
+	key, err := store.StoreUserProfile(c, start, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reads := make([]apimodel.GlucoseRead, count)
+	for i := range reads {
+		reads[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(start.Add(time.Duration(i*5) * time.Minute)), "UTC"}, apimodel.MG_PER_DL, float32(100 + i)}
+	}
+
+	if _, _, err := store.StoreDaysOfReads(c, key, apimodel.DEFAULT_DEVICE_ID, []apimodel.DayOfGlucoseReads{apimodel.NewDayOfGlucoseReads(reads)}); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestApiPagesThroughReads(t *testing.T) {
+	start := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	c := setupApiTestData(t, start, 5)
+	defer c.Close()
+
+	params := url.Values{QUERY_PARAM_FROM: {start.Format(time.RFC3339)}, QUERY_PARAM_TO: {start.Add(time.Hour).Format(time.RFC3339)},
+		QUERY_PARAM_LIMIT: {"3"}}
+
+	values := make([]float32, 0)
+	for pages := 0; ; pages++ {
+		if pages > 2 {
+			t.Fatalf("Expected reads in [2] pages but got more")
+		}
+
+		page, err := getApiPage(c, API_TEST_USER, API_KIND_READS, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, read := range page.Data.([]apimodel.GlucoseRead) {
+			values = append(values, read.Value)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		params.Set(QUERY_PARAM_CURSOR, page.NextCursor)
+	}
+
+	if len(values) != 5 || values[0] != 100 || values[4] != 104 {
+		t.Errorf("Expected the [5] reads in order but got %v", values)
+	}
+}
+
+func TestApiRejectsInvalidRequests(t *testing.T) {
+	start := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	c := setupApiTestData(t, start, 1)
+	defer c.Close()
+
+	tooLong := url.Values{QUERY_PARAM_FROM: {start.Format(time.RFC3339)}, QUERY_PARAM_TO: {start.Add(MAX_API_RANGE + time.Hour).Format(time.RFC3339)}}
+	if _, err := getApiPage(c, API_TEST_USER, API_KIND_READS, tooLong); err == nil || err.(ApiError).Status != http.StatusBadRequest {
+		t.Errorf("Expected a [%d] error for a range longer than [%s] but got [%v]", http.StatusBadRequest, MAX_API_RANGE, err)
+	}
+
+	reversed := url.Values{QUERY_PARAM_FROM: {start.Format(time.RFC3339)}, QUERY_PARAM_TO: {start.Add(-time.Hour).Format(time.RFC3339)}}
+	if _, err := getApiPage(c, API_TEST_USER, API_KIND_MEALS, reversed); err == nil || err.(ApiError).Status != http.StatusBadRequest {
+		t.Errorf("Expected a [%d] error for a reversed range but got [%v]", http.StatusBadRequest, err)
+	}
+
+	if _, err := getApiPage(c, "unknown@glukit.com", API_KIND_READS, url.Values{}); err == nil || err.(ApiError).Status != http.StatusNotFound {
+		t.Errorf("Expected a [%d] error for an unknown user but got [%v]", http.StatusNotFound, err)
+	}
+}
+
+func TestApiErrorsAreJson(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeApiError(recorder, ApiError{Status: http.StatusUnauthorized, Message: "Authentication required."})
+
+	var apiError ApiError
+	if err := json.Unmarshal(recorder.Body.Bytes(), &apiError); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Code != http.StatusUnauthorized || apiError.Status != http.StatusUnauthorized || apiError.Message != "Authentication required." {
+		t.Errorf("Expected a [%d] json error but got [%d] with [%s]", http.StatusUnauthorized, recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestReadsOnBehalfOfAnotherUserRequireAGrant(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	own, _ := http.NewRequest("GET", "/api/v1/reads?"+QUERY_PARAM_ON_BEHALF_OF+"=API@glukit.com", nil)
+	if email, err := resolveDataOwner(c, own, API_TEST_USER); err != nil || email != API_TEST_USER {
+		t.Errorf("Expected reads of the user's own data to be for [%s] but got [%s] with [%v]", API_TEST_USER, email, err)
+	}
+
+	other, _ := http.NewRequest("GET", "/api/v1/reads?"+QUERY_PARAM_ON_BEHALF_OF+"=owner@glukit.com", nil)
+	if _, err := resolveDataOwner(c, other, API_TEST_USER); err != store.ErrShareGrantNotFound {
+		t.Errorf("Expected [%v] reading data of another user without a grant but got [%v]", store.ErrShareGrantNotFound, err)
+	}
+}
+
+func TestApiKeyAuthentication(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	userProfileKey := store.GetUserKey(c, API_TEST_USER)
+	key, apiKey, err := model.NewApiKey("reports", []string{model.API_KEY_SCOPE_READ_GLUCOSE}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := store.StoreApiKey(c, userProfileKey, apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, _ := http.NewRequest("GET", "/api/v1/reads", nil)
+	request.Header.Set("Authorization", "Bearer "+key)
+	if email, err := authenticateApiRequest(c, request, model.API_KEY_SCOPE_READ_GLUCOSE); err != nil || email != API_TEST_USER {
+		t.Errorf("Expected api key to authenticate [%s] but got [%s] with [%v]", API_TEST_USER, email, err)
+	}
+
+	if _, err := authenticateApiRequest(c, request, model.API_KEY_SCOPE_READ_EVENTS); err == nil || err.(ApiError).Status != http.StatusForbidden {
+		t.Errorf("Expected a [%d] error for a scope the key doesn't grant but got [%v]", http.StatusForbidden, err)
+	}
+
+	if err := store.DeleteApiKey(c, userProfileKey, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authenticateApiRequest(c, request, model.API_KEY_SCOPE_READ_GLUCOSE); err == nil || err.(ApiError).Status != http.StatusUnauthorized {
+		t.Errorf("Expected a [%d] error for a revoked key but got [%v]", http.StatusUnauthorized, err)
+	}
+}
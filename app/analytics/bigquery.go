@@ -0,0 +1,237 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/config"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+	"net/http"
+	"time"
+)
+
+const (
+	INSERT_ROWS_FUNCTION_NAME = "insertAnalyticsRows"
+
+	// Insertions are background work that can lag behind imports
+	ANALYTICS_QUEUE_NAME = "batch-calculation"
+
+	// Rows are inserted by batches of at most ROWS_PER_INSERT, the size BigQuery recommends for streaming inserts. An
+	// insertion that fails with a transient error is retried by the task queue until it's been attempted
+	// MAX_INSERT_ATTEMPTS times.
+	ROWS_PER_INSERT     = 500
+	MAX_INSERT_ATTEMPTS = 5
+
+	BIGQUERY_API_URL = "https://www.googleapis.com/bigquery/v2"
+	BIGQUERY_SCOPE   = "https://www.googleapis.com/auth/bigquery"
+)
+
+var insertRowsTask = delay.Func(INSERT_ROWS_FUNCTION_NAME, insertRows)
+
+// Reasons of row errors of a streaming insert that are worth retrying
+var transientInsertErrorReasons = map[string]bool{"backendError": true, "internalError": true, "rateLimitExceeded": true}
+
+// BigQueryError is the failure of a call to BigQuery, StatusCode being zero if it didn't get a response or if some rows
+// of a streaming insert failed. Transient errors are worth retrying.
+type BigQueryError struct {
+	StatusCode int
+	Message    string
+	Transient  bool
+}
+
+func (err BigQueryError) Error() string {
+	return err.Message
+}
+
+// insertAllRequest is the body of a streaming insert
+type insertAllRequest struct {
+	Kind            string         `json:"kind"`
+	SkipInvalidRows bool           `json:"skipInvalidRows"`
+	Rows            []insertAllRow `json:"rows"`
+}
+
+type insertAllRow struct {
+	InsertId string `json:"insertId"`
+	Json     Row    `json:"json"`
+}
+
+// insertAllResponse is the response of a streaming insert, listing the errors of the rows that weren't inserted
+type insertAllResponse struct {
+	InsertErrors []struct {
+		Index  int `json:"index"`
+		Errors []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"insertErrors"`
+}
+
+// exportSettings returns the settings of the export and whether it's enabled, which requires both the app configuration
+// and the kill switch to allow it. The export is considered disabled if its settings can't be read.
+func exportSettings(context context.Context) (settings *model.AnalyticsExportSettings, enabled bool) {
+	if !config.NewAppConfig().AnalyticsExportEnabled {
+		return nil, false
+	}
+
+	defaultSettings, err := model.NewAnalyticsExportSettings(time.Now())
+	if err != nil {
+		log.Warningf(context, "Error creating default analytics export settings, not exporting: %v", err)
+		return nil, false
+	}
+
+	settings, err = store.GetAnalyticsExportSettings(context, defaultSettings)
+	if err != nil {
+		log.Warningf(context, "Error reading analytics export settings, not exporting: %v", err)
+		return nil, false
+	}
+
+	return settings, !settings.Disabled
+}
+
+// SetDisabled flips the kill switch of the export. Rows of imports already done that are waiting to be inserted are
+// dropped while the export is disabled.
+func SetDisabled(context context.Context, disabled bool) (settings *model.AnalyticsExportSettings, err error) {
+	now := time.Now()
+	defaultSettings, err := model.NewAnalyticsExportSettings(now)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.SetAnalyticsExportDisabled(context, disabled, defaultSettings, now)
+}
+
+// insertRows is the task that inserts rows in the configured BigQuery table. Rows are dropped if the export was
+// disabled since they were enqueued, if the insert fails with a permanent error or if it failed MAX_INSERT_ATTEMPTS
+// times. Other failures are returned for the task queue to retry.
+func insertRows(context context.Context, rows []Row) error {
+	if _, enabled := exportSettings(context); !enabled {
+		log.Infof(context, "Analytics export disabled, dropping [%d] rows", len(rows))
+		return nil
+	}
+
+	appConfig := config.NewAppConfig()
+	token, _, err := appengine.AccessToken(context, BIGQUERY_SCOPE)
+	if err == nil {
+		url := fmt.Sprintf("%s/projects/%s/datasets/%s/tables/%s/insertAll", BIGQUERY_API_URL, appConfig.BigQueryProjectId,
+			appConfig.BigQueryDatasetId, appConfig.BigQueryTableId)
+		err = insertAll(urlfetch.Client(context), url, token, rows)
+	}
+
+	if err == nil {
+		log.Infof(context, "Inserted [%d] analytics rows", len(rows))
+		return nil
+	}
+
+	if bigQueryErr, ok := err.(BigQueryError); ok && !bigQueryErr.Transient {
+		log.Errorf(context, "Permanent error inserting [%d] analytics rows, dropping them: %v", len(rows), err)
+		return nil
+	}
+
+	// The execution count is the number of previous failed executions of the task
+	if headers, headersErr := delay.RequestHeaders(context); headersErr == nil && headers.TaskExecutionCount+1 >= MAX_INSERT_ATTEMPTS {
+		log.Errorf(context, "Error inserting [%d] analytics rows on the last attempt, dropping them: %v", len(rows), err)
+		return nil
+	}
+
+	log.Warningf(context, "Transient error inserting [%d] analytics rows, retrying: %v", len(rows), err)
+	return err
+}
+
+// insertAll streams the rows to the BigQuery table of the insertAll url. Invalid rows are skipped and reported as a
+// permanent error after the valid ones are inserted. Rows are deduplicated on their insert id so that retrying a
+// partially failed insert doesn't count rows twice.
+func insertAll(client *http.Client, url string, token string, rows []Row) error {
+	request := insertAllRequest{Kind: "bigquery#tableDataInsertAllRequest", SkipInvalidRows: true, Rows: make([]insertAllRow, len(rows))}
+	for i, row := range rows {
+		request.Rows[i] = insertAllRow{row.insertId(), row}
+	}
+
+	var response insertAllResponse
+	if err := callBigQuery(client, url, token, request, &response); err != nil {
+		return err
+	}
+
+	if len(response.InsertErrors) == 0 {
+		return nil
+	}
+
+	transient := false
+	for _, insertErr := range response.InsertErrors {
+		for _, rowErr := range insertErr.Errors {
+			transient = transient || transientInsertErrorReasons[rowErr.Reason]
+		}
+	}
+
+	first := response.InsertErrors[0]
+	return BigQueryError{0, fmt.Sprintf("[%d] of [%d] rows not inserted, first at index [%d]: %v", len(response.InsertErrors),
+		len(rows), first.Index, first.Errors), transient}
+}
+
+// CreateTable creates the configured BigQuery table with the TableSchema. A table that already exists is left as is.
+func CreateTable(context context.Context) error {
+	appConfig := config.NewAppConfig()
+	token, _, err := appengine.AccessToken(context, BIGQUERY_SCOPE)
+	if err != nil {
+		return err
+	}
+
+	table := map[string]interface{}{
+		"tableReference": map[string]string{"projectId": appConfig.BigQueryProjectId, "datasetId": appConfig.BigQueryDatasetId,
+			"tableId": appConfig.BigQueryTableId},
+		"schema": map[string]interface{}{"fields": TableSchema},
+	}
+
+	url := fmt.Sprintf("%s/projects/%s/datasets/%s/tables", BIGQUERY_API_URL, appConfig.BigQueryProjectId, appConfig.BigQueryDatasetId)
+	err = callBigQuery(urlfetch.Client(context), url, token, table, nil)
+	if bigQueryErr, ok := err.(BigQueryError); ok && bigQueryErr.StatusCode == http.StatusConflict {
+		log.Infof(context, "Analytics table [%s] already exists", appConfig.BigQueryTableId)
+		return nil
+	}
+
+	return err
+}
+
+// callBigQuery posts the body as JSON to the BigQuery url and decodes the response into result, if not nil. Network
+// errors, throttling and server errors are transient BigQueryErrors while other error statuses are permanent ones.
+func callBigQuery(client *http.Client, url string, token string, body interface{}, result interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return BigQueryError{0, err.Error(), false}
+	}
+
+	request, err := http.NewRequest("POST", url, bytes.NewReader(encoded))
+	if err != nil {
+		return BigQueryError{0, err.Error(), false}
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return BigQueryError{0, err.Error(), true}
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+		return BigQueryError{response.StatusCode, http.StatusText(response.StatusCode), true}
+	case response.StatusCode >= 300:
+		return BigQueryError{response.StatusCode, http.StatusText(response.StatusCode), false}
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return BigQueryError{response.StatusCode, fmt.Sprintf("Error decoding response: %v", err), false}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package analytics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInsertAllSendsRowsWithInsertIds(t *testing.T) {
+	var received insertAllRequest
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if auth := request.Header.Get("Authorization"); auth != "Bearer token" {
+			t.Errorf("Expected bearer token but got [%s]", auth)
+		}
+		json.NewDecoder(request.Body).Decode(&received)
+		writer.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	rows := []Row{newRow("user", KIND_GLUCOSE_READ, time.Unix(1000, 0), 100), newRow("user", KIND_MEAL, time.Unix(1000, 0), 30)}
+	if err := insertAll(http.DefaultClient, server.URL, "token", rows); err != nil {
+		t.Fatalf("Unexpected error inserting rows: %v", err)
+	}
+
+	if len(received.Rows) != 2 {
+		t.Fatalf("Expected 2 rows but got [%d]", len(received.Rows))
+	}
+
+	if received.Rows[0].Json != rows[0] || received.Rows[0].InsertId != rows[0].insertId() {
+		t.Errorf("Expected row [%v] with insert id [%s] but got [%v]", rows[0], rows[0].insertId(), received.Rows[0])
+	}
+
+	if received.Rows[0].InsertId == received.Rows[1].InsertId {
+		t.Errorf("Expected distinct insert ids but got [%s] twice", received.Rows[0].InsertId)
+	}
+}
+
+func TestInsertAllReportsTransientRowErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(`{"insertErrors": [{"index": 0, "errors": [{"reason": "backendError"}]}]}`))
+	}))
+	defer server.Close()
+
+	err := insertAll(http.DefaultClient, server.URL, "token", []Row{newRow("user", KIND_GLUCOSE_READ, time.Unix(1000, 0), 100)})
+	if bigQueryErr, ok := err.(BigQueryError); !ok || !bigQueryErr.Transient {
+		t.Errorf("Expected a transient BigQueryError but got [%v]", err)
+	}
+}
+
+func TestInsertAllReportsPermanentErrorsOnInvalidRows(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(`{"insertErrors": [{"index": 0, "errors": [{"reason": "invalid"}]}]}`))
+	}))
+	defer server.Close()
+
+	err := insertAll(http.DefaultClient, server.URL, "token", []Row{newRow("user", KIND_GLUCOSE_READ, time.Unix(1000, 0), 100)})
+	if bigQueryErr, ok := err.(BigQueryError); !ok || bigQueryErr.Transient {
+		t.Errorf("Expected a permanent BigQueryError but got [%v]", err)
+	}
+}
+
+func TestCallBigQueryClassifiesErrorStatuses(t *testing.T) {
+	statuses := map[int]bool{http.StatusServiceUnavailable: true, http.StatusTooManyRequests: true, http.StatusBadRequest: false,
+		http.StatusForbidden: false}
+
+	for status, transient := range statuses {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.WriteHeader(status)
+		}))
+
+		err := callBigQuery(http.DefaultClient, server.URL, "token", struct{}{}, nil)
+		if bigQueryErr, ok := err.(BigQueryError); !ok || bigQueryErr.StatusCode != status || bigQueryErr.Transient != transient {
+			t.Errorf("Expected BigQueryError with status [%d] and transient [%t] but got [%v]", status, transient, err)
+		}
+		server.Close()
+	}
+}
+
+func TestTableSchemaMatchesRowFields(t *testing.T) {
+	encoded, _ := json.Marshal(newRow("user", KIND_GLUCOSE_READ, time.Unix(1000, 0), 100))
+	var fields map[string]interface{}
+	json.Unmarshal(encoded, &fields)
+
+	if len(fields) != len(TableSchema) {
+		t.Errorf("Expected [%d] fields but got [%d]", len(TableSchema), len(fields))
+	}
+
+	for _, field := range TableSchema {
+		if _, ok := fields[field.Name]; !ok {
+			t.Errorf("Expected field [%s] in row [%s]", field.Name, string(encoded))
+		}
+	}
+}
+
+func TestHashUserIdDependsOnSalt(t *testing.T) {
+	if HashUserId("salt", "user@glukit.com") != HashUserId("salt", "user@glukit.com") {
+		t.Errorf("Expected the same hash for the same salt and email")
+	}
+
+	if HashUserId("salt", "user@glukit.com") == HashUserId("other", "user@glukit.com") {
+		t.Errorf("Expected different hashes for different salts")
+	}
+}
@@ -0,0 +1,62 @@
+// analytics package exports anonymized imported records to BigQuery for population-level analytics
+package analytics
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Kinds of exported records
+const (
+	KIND_GLUCOSE_READ = "glucoseRead"
+	KIND_CALIBRATION  = "calibration"
+	KIND_INJECTION    = "injection"
+	KIND_BASAL_RATE   = "basalRate"
+	KIND_MEAL         = "meal"
+	KIND_EXERCISE     = "exercise"
+)
+
+// Row is an exported record. UserId is the salted hash of the email of the user so that records of a user can be
+// grouped without telling who the user is. Timestamp is in seconds since the epoch and Value is in the unit of the
+// kind: mg/dL for reads and calibrations, units for injections, units per hour for basal rates, grams of
+// carbohydrates for meals and minutes for exercises.
+type Row struct {
+	UserId    string  `json:"user_id"`
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+	Kind      string  `json:"kind"`
+}
+
+// FieldSchema is the schema of a field of the BigQuery table of rows
+type FieldSchema struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+}
+
+// TableSchema is the schema of the BigQuery table of rows, in the order of the fields of Row
+var TableSchema = []FieldSchema{
+	{"user_id", "STRING", "REQUIRED"},
+	{"timestamp", "TIMESTAMP", "REQUIRED"},
+	{"value", "FLOAT", "REQUIRED"},
+	{"kind", "STRING", "REQUIRED"},
+}
+
+// HashUserId returns the anonymized id of the user with the given email
+func HashUserId(salt string, userEmail string) string {
+	hash := sha256.Sum256([]byte(salt + userEmail))
+	return hex.EncodeToString(hash[:])
+}
+
+// newRow returns the row of a record of the given kind
+func newRow(userId string, kind string, timestamp time.Time, value float64) Row {
+	return Row{userId, timestamp.Unix(), value, kind}
+}
+
+// insertId returns the id BigQuery deduplicates the row on so that rows inserted again by a retry aren't counted twice
+func (row Row) insertId() string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("%s-%s-%d-%f", row.UserId, row.Kind, row.Timestamp, row.Value))))
+}
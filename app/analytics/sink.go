@@ -0,0 +1,264 @@
+package analytics
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/glukitio"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+)
+
+// Sink collects the rows of the records of an import and enqueues their insertion in BigQuery by batches of
+// ROWS_PER_INSERT. Its writers never fail so that teeing the datastore writers to them can't fail an import: rows that
+// can't be enqueued are logged and dropped.
+type Sink struct {
+	context  context.Context
+	userId   string
+	rows     []Row
+	enqueue  func(context context.Context, rows []Row) error
+	enqueued int
+	dropped  int
+}
+
+// NewSink returns the sink of the records imported for the user, nil if the export is disabled
+func NewSink(context context.Context, userEmail string) *Sink {
+	settings, enabled := exportSettings(context)
+	if !enabled {
+		return nil
+	}
+
+	return newSink(context, HashUserId(settings.Salt, userEmail), enqueueInsert)
+}
+
+func newSink(context context.Context, userId string, enqueue func(context context.Context, rows []Row) error) *Sink {
+	return &Sink{context: context, userId: userId, rows: make([]Row, 0, ROWS_PER_INSERT), enqueue: enqueue}
+}
+
+// add adds a row and enqueues a batch once there are ROWS_PER_INSERT of them
+func (s *Sink) add(row Row) {
+	s.rows = append(s.rows, row)
+	if len(s.rows) >= ROWS_PER_INSERT {
+		s.flush()
+	}
+}
+
+// flush enqueues the insertion of the rows collected so far
+func (s *Sink) flush() {
+	if len(s.rows) == 0 {
+		return
+	}
+
+	if err := s.enqueue(s.context, s.rows); err != nil {
+		log.Warningf(s.context, "Error enqueuing the insertion of [%d] analytics rows, dropping them: %v", len(s.rows), err)
+		s.dropped += len(s.rows)
+	} else {
+		s.enqueued += len(s.rows)
+	}
+
+	s.rows = make([]Row, 0, ROWS_PER_INSERT)
+}
+
+// Close enqueues the insertion of the remaining rows. It must be called once the import is done.
+func (s *Sink) Close() {
+	s.flush()
+	log.Infof(s.context, "Enqueued [%d] analytics rows, dropped [%d]", s.enqueued, s.dropped)
+}
+
+// enqueueInsert enqueues the task inserting the rows in BigQuery
+func enqueueInsert(context context.Context, rows []Row) error {
+	task, err := insertRowsTask.Task(rows)
+	if err != nil {
+		return err
+	}
+
+	_, err = taskqueue.Add(context, task, ANALYTICS_QUEUE_NAME)
+	return err
+}
+
+// GlucoseReadWriter returns the writer of reads to the sink
+func (s *Sink) GlucoseReadWriter() glukitio.GlucoseReadBatchWriter {
+	return glucoseReadWriter{s}
+}
+
+// CalibrationWriter returns the writer of calibrations to the sink
+func (s *Sink) CalibrationWriter() glukitio.CalibrationBatchWriter {
+	return calibrationWriter{s}
+}
+
+// InjectionWriter returns the writer of injections to the sink
+func (s *Sink) InjectionWriter() glukitio.InjectionBatchWriter {
+	return injectionWriter{s}
+}
+
+// BasalRateWriter returns the writer of basal rates to the sink
+func (s *Sink) BasalRateWriter() glukitio.BasalRateBatchWriter {
+	return basalRateWriter{s}
+}
+
+// MealWriter returns the writer of meals to the sink
+func (s *Sink) MealWriter() glukitio.MealBatchWriter {
+	return mealWriter{s}
+}
+
+// ExerciseWriter returns the writer of exercises to the sink
+func (s *Sink) ExerciseWriter() glukitio.ExerciseBatchWriter {
+	return exerciseWriter{s}
+}
+
+type glucoseReadWriter struct {
+	sink *Sink
+}
+
+func (w glucoseReadWriter) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (glukitio.GlucoseReadBatchWriter, error) {
+	for _, read := range p {
+		value, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			continue
+		}
+		w.sink.add(newRow(w.sink.userId, KIND_GLUCOSE_READ, read.GetTime(), float64(value)))
+	}
+
+	return w, nil
+}
+
+func (w glucoseReadWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (glukitio.GlucoseReadBatchWriter, error) {
+	for _, day := range p {
+		w.WriteGlucoseReadBatch(day.Reads)
+	}
+
+	return w, nil
+}
+
+func (w glucoseReadWriter) Flush() (glukitio.GlucoseReadBatchWriter, error) {
+	return w, nil
+}
+
+type calibrationWriter struct {
+	sink *Sink
+}
+
+func (w calibrationWriter) WriteCalibrationBatch(p []apimodel.CalibrationRead) (glukitio.CalibrationBatchWriter, error) {
+	for _, calibration := range p {
+		value, err := calibration.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			continue
+		}
+		w.sink.add(newRow(w.sink.userId, KIND_CALIBRATION, calibration.GetTime(), float64(value)))
+	}
+
+	return w, nil
+}
+
+func (w calibrationWriter) WriteCalibrationBatches(p []apimodel.DayOfCalibrationReads) (glukitio.CalibrationBatchWriter, error) {
+	for _, day := range p {
+		w.WriteCalibrationBatch(day.Reads)
+	}
+
+	return w, nil
+}
+
+func (w calibrationWriter) Flush() (glukitio.CalibrationBatchWriter, error) {
+	return w, nil
+}
+
+type injectionWriter struct {
+	sink *Sink
+}
+
+func (w injectionWriter) WriteInjectionBatch(p []apimodel.Injection) (glukitio.InjectionBatchWriter, error) {
+	for _, injection := range p {
+		if !injection.Deleted {
+			w.sink.add(newRow(w.sink.userId, KIND_INJECTION, injection.Time.GetTime(), float64(injection.Units)))
+		}
+	}
+
+	return w, nil
+}
+
+func (w injectionWriter) WriteInjectionBatches(p []apimodel.DayOfInjections) (glukitio.InjectionBatchWriter, error) {
+	for _, day := range p {
+		w.WriteInjectionBatch(day.Injections)
+	}
+
+	return w, nil
+}
+
+func (w injectionWriter) Flush() (glukitio.InjectionBatchWriter, error) {
+	return w, nil
+}
+
+type basalRateWriter struct {
+	sink *Sink
+}
+
+func (w basalRateWriter) WriteBasalRateBatch(p []apimodel.BasalRate) (glukitio.BasalRateBatchWriter, error) {
+	for _, basalRate := range p {
+		w.sink.add(newRow(w.sink.userId, KIND_BASAL_RATE, basalRate.Time.GetTime(), float64(basalRate.Rate)))
+	}
+
+	return w, nil
+}
+
+func (w basalRateWriter) WriteBasalRateBatches(p []apimodel.DayOfBasalRates) (glukitio.BasalRateBatchWriter, error) {
+	for _, day := range p {
+		w.WriteBasalRateBatch(day.BasalRates)
+	}
+
+	return w, nil
+}
+
+func (w basalRateWriter) Flush() (glukitio.BasalRateBatchWriter, error) {
+	return w, nil
+}
+
+type mealWriter struct {
+	sink *Sink
+}
+
+func (w mealWriter) WriteMealBatch(p []apimodel.Meal) (glukitio.MealBatchWriter, error) {
+	for _, meal := range p {
+		if !meal.Deleted {
+			w.sink.add(newRow(w.sink.userId, KIND_MEAL, meal.Time.GetTime(), float64(meal.Carbs)))
+		}
+	}
+
+	return w, nil
+}
+
+func (w mealWriter) WriteMealBatches(p []apimodel.DayOfMeals) (glukitio.MealBatchWriter, error) {
+	for _, day := range p {
+		w.WriteMealBatch(day.Meals)
+	}
+
+	return w, nil
+}
+
+func (w mealWriter) Flush() (glukitio.MealBatchWriter, error) {
+	return w, nil
+}
+
+type exerciseWriter struct {
+	sink *Sink
+}
+
+func (w exerciseWriter) WriteExerciseBatch(p []apimodel.Exercise) (glukitio.ExerciseBatchWriter, error) {
+	for _, exercise := range p {
+		if !exercise.Deleted {
+			w.sink.add(newRow(w.sink.userId, KIND_EXERCISE, exercise.Time.GetTime(), float64(exercise.DurationMinutes)))
+		}
+	}
+
+	return w, nil
+}
+
+func (w exerciseWriter) WriteExerciseBatches(p []apimodel.DayOfExercises) (glukitio.ExerciseBatchWriter, error) {
+	for _, day := range p {
+		w.WriteExerciseBatch(day.Exercises)
+	}
+
+	return w, nil
+}
+
+func (w exerciseWriter) Flush() (glukitio.ExerciseBatchWriter, error) {
+	return w, nil
+}
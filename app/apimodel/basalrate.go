@@ -0,0 +1,90 @@
+package apimodel
+
+import (
+	"time"
+)
+
+const (
+	BASAL_TAG = "Basal"
+)
+
+// BasalRate represents insulin delivered continuously by a pump at Rate units per hour for DurationMinutes. Temp is
+// true for temporary basal rates set by the user, false for rates of the scheduled basal profile.
+type BasalRate struct {
+	Time            Time    `json:"time" datastore:"time,noindex"`
+	Rate            float32 `json:"rate" datastore:"rate,noindex"`
+	DurationMinutes int     `json:"durationInMinutes" datastore:"durationInMinutes,noindex"`
+	Temp            bool    `json:"temp" datastore:"temp,noindex"`
+}
+
+// This holds an array of basal rates for a whole day
+type DayOfBasalRates struct {
+	BasalRates []BasalRate `datastore:"basalRates,noindex"`
+	StartTime  time.Time   `datastore:"startTime"`
+	EndTime    time.Time   `datastore:"endTime"`
+}
+
+func NewDayOfBasalRates(basalRates []BasalRate) DayOfBasalRates {
+	return DayOfBasalRates{basalRates, basalRates[0].GetTime().Truncate(DAY_OF_DATA_DURATION), basalRates[len(basalRates)-1].GetTime()}
+}
+
+// GetTime gets the time of a Timestamp value
+func (element BasalRate) GetTime() time.Time {
+	return element.Time.GetTime()
+}
+
+// GetEndTime returns the time at which the basal rate stops being delivered
+func (element BasalRate) GetEndTime() time.Time {
+	return element.GetTime().Add(time.Duration(element.DurationMinutes) * time.Minute)
+}
+
+// Units returns the insulin units delivered over the whole duration of the basal rate
+func (element BasalRate) Units() float32 {
+	return element.Rate * float32(element.DurationMinutes) / 60.
+}
+
+type BasalRateSlice []BasalRate
+
+func (slice BasalRateSlice) Len() int {
+	return len(slice)
+}
+
+func (slice BasalRateSlice) Less(i, j int) bool {
+	return slice[i].Time.Timestamp < slice[j].Time.Timestamp
+}
+
+func (slice BasalRateSlice) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+func (slice BasalRateSlice) GetEpochTime(i int) (epochTime int64) {
+	return slice[i].Time.Timestamp / 1000
+}
+
+func (slice BasalRateSlice) GetTimestamp(i int) (timestamp int64) {
+	return slice[i].Time.Timestamp
+}
+
+// ToDataPointSlice converts a BasalRateSlice into a generic DataPoint array. The value of a data point is the rate
+// in units per hour.
+func (slice BasalRateSlice) ToDataPointSlice(matchingReads []GlucoseRead, glucoseUnit GlucoseUnit) (dataPoints []DataPoint, err error) {
+	dataPoints = make([]DataPoint, len(slice))
+
+	for i := range slice {
+		localTime, err := slice[i].Time.Format()
+		if err != nil {
+			return nil, err
+		}
+
+		yValue, err := linearInterpolateY(matchingReads, slice[i].Time, glucoseUnit)
+		if err != nil {
+			return nil, err
+		}
+
+		dataPoint := DataPoint{localTime, slice.GetEpochTime(i),
+			yValue, slice[i].Rate, BASAL_TAG, "units/hour", ""}
+		dataPoints[i] = dataPoint
+	}
+
+	return dataPoints, nil
+}
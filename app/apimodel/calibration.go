@@ -3,7 +3,6 @@ package apimodel
 import (
 	"errors"
 	"fmt"
-	"github.com/alexandre-normand/glukit/app/util"
 	"time"
 )
 
@@ -19,11 +18,13 @@ type CalibrationRead struct {
 	Value float32     `json:"value" datastore:"value,noindex"`
 }
 
-// This holds an array of reads for a whole day
+// This holds an array of reads for a whole day. The DeviceId identifies the device the calibrations were entered in,
+// an empty value meaning the default device.
 type DayOfCalibrationReads struct {
 	Reads     []CalibrationRead `datastore:"calibrations,noindex"`
 	StartTime time.Time         `datastore:"startTime"`
 	EndTime   time.Time         `datastore:"endTime"`
+	DeviceId  string            `datastore:"deviceId,noindex"`
 }
 
 // GetTime gets the time of a Timestamp value
@@ -32,7 +33,12 @@ func (element CalibrationRead) GetTime() time.Time {
 }
 
 func NewDayOfCalibrationReads(reads []CalibrationRead) DayOfCalibrationReads {
-	return DayOfCalibrationReads{reads, reads[0].GetTime().Truncate(DAY_OF_DATA_DURATION), reads[len(reads)-1].GetTime()}
+	return NewDayOfCalibrationReadsForDevice(reads, DEFAULT_DEVICE_ID)
+}
+
+// NewDayOfCalibrationReadsForDevice creates a DayOfCalibrationReads for calibrations entered in the given device
+func NewDayOfCalibrationReadsForDevice(reads []CalibrationRead, deviceId string) DayOfCalibrationReads {
+	return DayOfCalibrationReads{reads, reads[0].GetTime().Truncate(DAY_OF_DATA_DURATION), reads[len(reads)-1].GetTime(), deviceId}
 }
 
 type CalibrationReadSlice []CalibrationRead
@@ -57,6 +63,10 @@ func (slice CalibrationReadSlice) GetEpochTime(i int) (epochTime int64) {
 	return slice[i].Time.Timestamp / 1000
 }
 
+func (slice CalibrationReadSlice) GetTimestamp(i int) (timestamp int64) {
+	return slice[i].Time.Timestamp
+}
+
 // GetNormalizedValue gets the normalized value to the requested unit
 func (element CalibrationRead) GetNormalizedValue(unit GlucoseUnit) (float32, error) {
 	if unit == element.Unit {
@@ -80,24 +90,23 @@ func (element CalibrationRead) GetNormalizedValue(unit GlucoseUnit) (float32, er
 }
 
 // ToDataPointSlice converts a CalibrationReadSlice into a generic DataPoint array
-func (slice CalibrationReadSlice) ToDataPointSlice() (dataPoints []DataPoint) {
+func (slice CalibrationReadSlice) ToDataPointSlice() (dataPoints []DataPoint, err error) {
 	dataPoints = make([]DataPoint, len(slice))
 	for i := range slice {
 		localTime, err := slice[i].Time.Format()
 		if err != nil {
-			util.Propagate(err)
+			return nil, err
 		}
 
-		// It's pretty terrible if this happens and we crash the app but this is a coding error and I want to know early
 		mgPerDlValue, err := slice[i].GetNormalizedValue(MG_PER_DL)
 		if err != nil {
-			util.Propagate(err)
+			return nil, err
 		}
 
-		dataPoint := DataPoint{localTime, slice.GetEpochTime(i), mgPerDlValue, float32(slice[i].Value), CALIBRATION_READ_TAG, MG_PER_DL}
+		dataPoint := DataPoint{localTime, slice.GetEpochTime(i), mgPerDlValue, float32(slice[i].Value), CALIBRATION_READ_TAG, MG_PER_DL, ""}
 		dataPoints[i] = dataPoint
 	}
-	return dataPoints
+	return dataPoints, nil
 }
 
 var UNDEFINED_CALIBRATION_READ = CalibrationRead{Time{0, "UTC"}, "NONE", -1.}
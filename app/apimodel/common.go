@@ -7,4 +7,7 @@ import (
 const (
 	UNDEFINED_READ       = -1
 	DAY_OF_DATA_DURATION = time.Duration(24) * time.Hour
+
+	// Device id of data that isn't associated with a specific device
+	DEFAULT_DEVICE_ID = ""
 )
@@ -8,6 +8,7 @@ type DataPoint struct {
 	Value     float32     `json:"value"`
 	Tag       string      `json:"tag"`
 	Unit      GlucoseUnit `json:"unit"`
+	Text      string      `json:"text,omitempty"`
 }
 
 type DataPointSlice []DataPoint
@@ -0,0 +1,109 @@
+package apimodel
+
+import (
+	"time"
+)
+
+const (
+	// Default thresholds, in mg/dL, below and above which time is counted in a DayOfStats
+	DEFAULT_LOW_GLUCOSE_THRESHOLD  = 70
+	DEFAULT_HIGH_GLUCOSE_THRESHOLD = 180
+
+	// Longest time a read stands for when counting the time spent below or above thresholds. Anything longer is
+	// considered a gap in the reads.
+	MAX_READ_INTERVAL = 15 * time.Minute
+	// Time the last read of a day stands for since there's no read after it
+	DEFAULT_READ_INTERVAL = 5 * time.Minute
+)
+
+// DayOfStats holds the aggregates of the reads of a day, in mg/dL. Each read stands for the time until the next read,
+// up to MAX_READ_INTERVAL, when counting the time spent below the low threshold and above the high threshold.
+type DayOfStats struct {
+	StartTime     time.Time     `datastore:"startTime"`
+	EndTime       time.Time     `datastore:"endTime"`
+	FirstReadTime time.Time     `datastore:"firstReadTime,noindex"`
+	DeviceId      string        `datastore:"deviceId,noindex"`
+	Count         int           `datastore:"count,noindex"`
+	Mean          float64       `datastore:"mean,noindex"`
+	Min           float32       `datastore:"min,noindex"`
+	Max           float32       `datastore:"max,noindex"`
+	LowThreshold  float32       `datastore:"lowThreshold,noindex"`
+	HighThreshold float32       `datastore:"highThreshold,noindex"`
+	TimeBelow     time.Duration `datastore:"timeBelow,noindex"`
+	TimeAbove     time.Duration `datastore:"timeAbove,noindex"`
+}
+
+// NewDayOfStats computes the stats of a day of reads. Reads are expected in chronological order.
+func NewDayOfStats(day DayOfGlucoseReads, lowThreshold, highThreshold float32) DayOfStats {
+	stats := DayOfStats{StartTime: day.StartTime, EndTime: day.EndTime, DeviceId: day.DeviceId, LowThreshold: lowThreshold,
+		HighThreshold: highThreshold}
+
+	sum := 0.
+	for i, read := range day.Reads {
+		value, err := read.GetNormalizedValue(MG_PER_DL)
+		if err != nil {
+			continue
+		}
+
+		readTime := read.GetTime()
+		if stats.Count == 0 {
+			stats.FirstReadTime = readTime
+			stats.Min, stats.Max = value, value
+		} else if value < stats.Min {
+			stats.Min = value
+		} else if value > stats.Max {
+			stats.Max = value
+		}
+		stats.Count++
+		sum += float64(value)
+
+		interval := DEFAULT_READ_INTERVAL
+		if i+1 < len(day.Reads) {
+			interval = day.Reads[i+1].GetTime().Sub(readTime)
+		}
+		if interval > MAX_READ_INTERVAL {
+			interval = MAX_READ_INTERVAL
+		}
+
+		if value < lowThreshold {
+			stats.TimeBelow += interval
+		} else if value > highThreshold {
+			stats.TimeAbove += interval
+		}
+	}
+
+	if stats.Count > 0 {
+		stats.Mean = sum / float64(stats.Count)
+	}
+
+	return stats
+}
+
+// Merge returns the stats of a day combined with the stats of other reads of the same day
+func (stats DayOfStats) Merge(other DayOfStats) DayOfStats {
+	if other.Count == 0 {
+		return stats
+	} else if stats.Count == 0 {
+		return other
+	}
+
+	merged := stats
+	merged.Count = stats.Count + other.Count
+	merged.Mean = (stats.Mean*float64(stats.Count) + other.Mean*float64(other.Count)) / float64(merged.Count)
+	merged.TimeBelow += other.TimeBelow
+	merged.TimeAbove += other.TimeAbove
+	if other.Min < merged.Min {
+		merged.Min = other.Min
+	}
+	if other.Max > merged.Max {
+		merged.Max = other.Max
+	}
+	if other.FirstReadTime.Before(merged.FirstReadTime) {
+		merged.FirstReadTime = other.FirstReadTime
+	}
+	if other.EndTime.After(merged.EndTime) {
+		merged.EndTime = other.EndTime
+	}
+
+	return merged
+}
@@ -0,0 +1,74 @@
+package apimodel
+
+import (
+	"google.golang.org/appengine/datastore"
+)
+
+// The stored types have the fields of the day entities without their Load and Save methods so that they can be loaded
+// and saved as plain structs
+type storedDayOfCalibrationReads DayOfCalibrationReads
+type storedDayOfBasalRates DayOfBasalRates
+type storedDayOfMeals DayOfMeals
+type storedDayOfExercises DayOfExercises
+type storedDayOfNotes DayOfNotes
+type storedDayOfStats DayOfStats
+
+// Load implements datastore.PropertyLoadSaver
+func (dayOfCalibrationReads *DayOfCalibrationReads) Load(properties []datastore.Property) error {
+	return loadVersioned(DAY_OF_CALIBRATION_READS_KIND, (*storedDayOfCalibrationReads)(dayOfCalibrationReads), properties)
+}
+
+// Save implements datastore.PropertyLoadSaver
+func (dayOfCalibrationReads *DayOfCalibrationReads) Save() (properties []datastore.Property, err error) {
+	return saveVersioned(DAY_OF_CALIBRATION_READS_KIND, (*storedDayOfCalibrationReads)(dayOfCalibrationReads))
+}
+
+// Load implements datastore.PropertyLoadSaver
+func (dayOfBasalRates *DayOfBasalRates) Load(properties []datastore.Property) error {
+	return loadVersioned(DAY_OF_BASAL_RATES_KIND, (*storedDayOfBasalRates)(dayOfBasalRates), properties)
+}
+
+// Save implements datastore.PropertyLoadSaver
+func (dayOfBasalRates *DayOfBasalRates) Save() (properties []datastore.Property, err error) {
+	return saveVersioned(DAY_OF_BASAL_RATES_KIND, (*storedDayOfBasalRates)(dayOfBasalRates))
+}
+
+// Load implements datastore.PropertyLoadSaver
+func (dayOfMeals *DayOfMeals) Load(properties []datastore.Property) error {
+	return loadVersioned(DAY_OF_MEALS_KIND, (*storedDayOfMeals)(dayOfMeals), properties)
+}
+
+// Save implements datastore.PropertyLoadSaver
+func (dayOfMeals *DayOfMeals) Save() (properties []datastore.Property, err error) {
+	return saveVersioned(DAY_OF_MEALS_KIND, (*storedDayOfMeals)(dayOfMeals))
+}
+
+// Load implements datastore.PropertyLoadSaver
+func (dayOfExercises *DayOfExercises) Load(properties []datastore.Property) error {
+	return loadVersioned(DAY_OF_EXERCISES_KIND, (*storedDayOfExercises)(dayOfExercises), properties)
+}
+
+// Save implements datastore.PropertyLoadSaver
+func (dayOfExercises *DayOfExercises) Save() (properties []datastore.Property, err error) {
+	return saveVersioned(DAY_OF_EXERCISES_KIND, (*storedDayOfExercises)(dayOfExercises))
+}
+
+// Load implements datastore.PropertyLoadSaver
+func (dayOfNotes *DayOfNotes) Load(properties []datastore.Property) error {
+	return loadVersioned(DAY_OF_NOTES_KIND, (*storedDayOfNotes)(dayOfNotes), properties)
+}
+
+// Save implements datastore.PropertyLoadSaver
+func (dayOfNotes *DayOfNotes) Save() (properties []datastore.Property, err error) {
+	return saveVersioned(DAY_OF_NOTES_KIND, (*storedDayOfNotes)(dayOfNotes))
+}
+
+// Load implements datastore.PropertyLoadSaver
+func (dayOfStats *DayOfStats) Load(properties []datastore.Property) error {
+	return loadVersioned(DAY_OF_STATS_KIND, (*storedDayOfStats)(dayOfStats), properties)
+}
+
+// Save implements datastore.PropertyLoadSaver
+func (dayOfStats *DayOfStats) Save() (properties []datastore.Property, err error) {
+	return saveVersioned(DAY_OF_STATS_KIND, (*storedDayOfStats)(dayOfStats))
+}
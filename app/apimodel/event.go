@@ -0,0 +1,30 @@
+package apimodel
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// NewEventId returns the id of the meal, injection or exercise at the given timestamp. Events of a kind are unique by
+// timestamp within their day so the id of an event is the same every time it's imported, which keeps edits and
+// deletions of the user from being undone by a new import of the same data.
+func NewEventId(timestamp int64) string {
+	return strconv.FormatInt(timestamp, 10)
+}
+
+// ParseEventId returns the time of the event with the given id
+func ParseEventId(id string) (eventTime time.Time, err error) {
+	timestamp, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return eventTime, errors.New(fmt.Sprintf("Invalid event id [%s]: %v", id, err))
+	}
+
+	return time.Unix(0, timestamp*int64(time.Millisecond)), nil
+}
+
+// DayOfEventStart returns the start of the day of events that holds events at t
+func DayOfEventStart(t time.Time) time.Time {
+	return t.Truncate(DAY_OF_DATA_DURATION)
+}
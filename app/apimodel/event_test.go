@@ -0,0 +1,97 @@
+package apimodel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventIdRoundTrip(t *testing.T) {
+	eventTime := time.Date(2014, 4, 18, 12, 30, 15, 250*int(time.Millisecond), time.UTC)
+	id := NewEventId(GetTimeMillis(eventTime))
+
+	parsed, err := ParseEventId(id)
+	if err != nil {
+		t.Fatalf("TestEventIdRoundTrip failed: %v", err)
+	}
+
+	if !parsed.Equal(eventTime) {
+		t.Errorf("TestEventIdRoundTrip failed: got [%s] but expected [%s]", parsed, eventTime)
+	}
+
+	if _, err := ParseEventId("not-an-id"); err == nil {
+		t.Errorf("TestEventIdRoundTrip failed: expected an error for an invalid id")
+	}
+}
+
+func TestNewDayOfMealsAssignsIds(t *testing.T) {
+	mealTime := time.Date(2014, 4, 18, 12, 0, 0, 0, time.UTC)
+	day := NewDayOfMeals([]Meal{Meal{Time: Time{GetTimeMillis(mealTime), "UTC"}, Carbs: 45}})
+
+	if id := day.Meals[0].Id; id != NewEventId(GetTimeMillis(mealTime)) {
+		t.Errorf("TestNewDayOfMealsAssignsIds failed: got id [%s]", id)
+	}
+
+	if !day.StartTime.Equal(DayOfEventStart(mealTime)) {
+		t.Errorf("TestNewDayOfMealsAssignsIds failed: got day start [%s] but expected [%s]", day.StartTime, DayOfEventStart(mealTime))
+	}
+}
+
+func TestDayOfMealsUpdate(t *testing.T) {
+	mealTime := time.Date(2014, 4, 18, 12, 0, 0, 0, time.UTC)
+	day := NewDayOfMeals([]Meal{Meal{Time: Time{GetTimeMillis(mealTime), "UTC"}, Carbs: 150}})
+	id := day.Meals[0].Id
+
+	if !day.Update(Meal{Id: id, Carbs: 15, Description: "Toast"}) {
+		t.Fatalf("TestDayOfMealsUpdate failed: meal [%s] not found", id)
+	}
+
+	meal := day.Meals[0]
+	if meal.Carbs != 15 || meal.Description != "Toast" || !meal.Edited || !meal.GetTime().Equal(mealTime) {
+		t.Errorf("TestDayOfMealsUpdate failed: got [%v]", meal)
+	}
+
+	if day.Update(Meal{Id: "1", Carbs: 15}) {
+		t.Errorf("TestDayOfMealsUpdate failed: expected no meal with id [1]")
+	}
+}
+
+func TestDayOfMealsDelete(t *testing.T) {
+	mealTime := time.Date(2014, 4, 18, 12, 0, 0, 0, time.UTC)
+	day := NewDayOfMeals([]Meal{Meal{Time: Time{GetTimeMillis(mealTime), "UTC"}, Carbs: 150},
+		Meal{Time: Time{GetTimeMillis(mealTime.Add(time.Hour)), "UTC"}, Carbs: 30}})
+	id := day.Meals[0].Id
+
+	if !day.Delete(id) {
+		t.Fatalf("TestDayOfMealsDelete failed: meal [%s] not found", id)
+	}
+
+	if len(day.Meals) != 2 || !day.Meals[0].Deleted {
+		t.Errorf("TestDayOfMealsDelete failed: expected the meal to be kept and flagged as deleted but got [%v]", day.Meals)
+	}
+
+	if day.Delete(id) || day.Update(Meal{Id: id, Carbs: 15}) {
+		t.Errorf("TestDayOfMealsDelete failed: expected a deleted meal to be neither deleted nor updated again")
+	}
+
+	if meals := MealSlice(day.Meals).WithoutDeleted(); len(meals) != 1 || meals[0].Carbs != 30 {
+		t.Errorf("TestDayOfMealsDelete failed: expected only the meal of 30g to be left but got [%v]", meals)
+	}
+}
+
+func TestDayOfInjectionsDelete(t *testing.T) {
+	injectionTime := time.Date(2014, 4, 18, 12, 0, 0, 0, time.UTC)
+	day := NewDayOfInjections([]Injection{Injection{Time: Time{GetTimeMillis(injectionTime), "UTC"}, Units: 4}})
+
+	if !day.Delete(day.Injections[0].Id) || len(InjectionSlice(day.Injections).WithoutDeleted()) != 0 {
+		t.Errorf("TestDayOfInjectionsDelete failed: expected the injection to be deleted but got [%v]", day.Injections)
+	}
+}
+
+func TestDayOfExercisesUpdate(t *testing.T) {
+	exerciseTime := time.Date(2014, 4, 18, 12, 0, 0, 0, time.UTC)
+	day := NewDayOfExercises([]Exercise{Exercise{Time: Time{GetTimeMillis(exerciseTime), "UTC"}, DurationMinutes: 300}})
+
+	if !day.Update(Exercise{Id: day.Exercises[0].Id, DurationMinutes: 30}) || day.Exercises[0].DurationMinutes != 30 {
+		t.Errorf("TestDayOfExercisesUpdate failed: got [%v]", day.Exercises)
+	}
+}
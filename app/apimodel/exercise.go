@@ -1,7 +1,6 @@
 package apimodel
 
 import (
-	"github.com/alexandre-normand/glukit/app/util"
 	"time"
 )
 
@@ -9,11 +8,37 @@ const (
 	EXERCISE_TAG = "Exercise"
 )
 
+// ExerciseIntensity is the canonical intensity of an exercise, regardless of the device that recorded it
+type ExerciseIntensity string
+
+const (
+	EXERCISE_INTENSITY_LIGHT  ExerciseIntensity = "Light"
+	EXERCISE_INTENSITY_MEDIUM ExerciseIntensity = "Medium"
+	EXERCISE_INTENSITY_HEAVY  ExerciseIntensity = "Heavy"
+
+	// Intensity of exercises recorded without one or with a device-specific intensity that isn't known. The
+	// device-specific intensity is then kept as RawIntensity.
+	EXERCISE_INTENSITY_UNKNOWN ExerciseIntensity = ""
+)
+
+const (
+	// Source of exercises imported from files, the dexcom or nightscout, or entered through the api
+	EXERCISE_SOURCE_DEVICE = ""
+
+	// Source of exercises imported from Google Fit sessions
+	EXERCISE_SOURCE_GOOGLE_FIT = "googleFit"
+)
+
 type Exercise struct {
-	Time            Time   `json:"time" datastore:"time,noindex"`
-	DurationMinutes int    `json:"durationInMinutes" datastore:"durationInMinutes,noindex"`
-	Intensity       string `json:"intensity" datastore:"intensity,noindex"`
-	Description     string `json:"description" datastore:"description,noindex"`
+	Time            Time              `json:"time" datastore:"time,noindex"`
+	DurationMinutes int               `json:"durationInMinutes" datastore:"durationInMinutes,noindex"`
+	Intensity       ExerciseIntensity `json:"intensity" datastore:"intensity,noindex"`
+	Description     string            `json:"description" datastore:"description,noindex"`
+	RawIntensity    string            `json:"rawIntensity,omitempty" datastore:"rawIntensity,noindex"`
+	Id              string            `json:"id" datastore:"id,noindex"`
+	Edited          bool              `json:"edited" datastore:"edited,noindex"`
+	Deleted         bool              `json:"-" datastore:"deleted,noindex"`
+	Source          string            `json:"source,omitempty" datastore:"source,noindex"`
 }
 
 // This holds an array of exercise events for a whole day
@@ -24,9 +49,37 @@ type DayOfExercises struct {
 }
 
 func NewDayOfExercises(exercises []Exercise) DayOfExercises {
+	ExerciseSlice(exercises).AssignIds()
 	return DayOfExercises{exercises, exercises[0].GetTime().Truncate(DAY_OF_DATA_DURATION), exercises[len(exercises)-1].GetTime()}
 }
 
+// Update replaces the exercise with the same id, keeping its time, and flags it as edited so that a new import of the same
+// data doesn't undo the change. It returns false if the day has no such exercise or if it was deleted.
+func (dayOfExercises *DayOfExercises) Update(exercise Exercise) bool {
+	for i := range dayOfExercises.Exercises {
+		if !dayOfExercises.Exercises[i].Deleted && dayOfExercises.Exercises[i].Id == exercise.Id {
+			exercise.Time, exercise.Edited = dayOfExercises.Exercises[i].Time, true
+			dayOfExercises.Exercises[i] = exercise
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delete flags the exercise with the given id as deleted. Deleted exercises are kept, rather than removed, so that a new import
+// of the same data doesn't bring them back. It returns false if the day has no such exercise or if it was already deleted.
+func (dayOfExercises *DayOfExercises) Delete(id string) bool {
+	for i := range dayOfExercises.Exercises {
+		if !dayOfExercises.Exercises[i].Deleted && dayOfExercises.Exercises[i].Id == id {
+			dayOfExercises.Exercises[i].Deleted = true
+			return true
+		}
+	}
+
+	return false
+}
+
 // GetTime gets the time of a Timestamp value
 func (element Exercise) GetTime() time.Time {
 	return element.Time.GetTime()
@@ -50,19 +103,55 @@ func (slice ExerciseSlice) GetEpochTime(i int) (epochTime int64) {
 	return slice[i].Time.Timestamp
 }
 
+func (slice ExerciseSlice) GetTimestamp(i int) (timestamp int64) {
+	return slice[i].Time.Timestamp
+}
+
+// AssignIds gives their id to exercises that don't have one yet, as is the case of new exercises and of exercises stored before
+// they had ids
+func (slice ExerciseSlice) AssignIds() {
+	for i := range slice {
+		if len(slice[i].Id) == 0 {
+			slice[i].Id = NewEventId(slice[i].Time.Timestamp)
+		}
+	}
+}
+
+// WithoutDeleted returns the exercises that weren't deleted by the user
+func (slice ExerciseSlice) WithoutDeleted() (exercises []Exercise) {
+	exercises = make([]Exercise, 0, len(slice))
+	for _, exercise := range slice {
+		if !exercise.Deleted {
+			exercises = append(exercises, exercise)
+		}
+	}
+
+	return exercises
+}
+
+// IsFromGoogleFit returns true if the exercise was imported from a Google Fit session
+func (element Exercise) IsFromGoogleFit() bool {
+	return element.Source == EXERCISE_SOURCE_GOOGLE_FIT
+}
+
 // ToDataPointSlice converts an ExerciseSlice into a generic DataPoint array
-func (slice ExerciseSlice) ToDataPointSlice(matchingReads []GlucoseRead, glucoseUnit GlucoseUnit) (dataPoints []DataPoint) {
+func (slice ExerciseSlice) ToDataPointSlice(matchingReads []GlucoseRead, glucoseUnit GlucoseUnit) (dataPoints []DataPoint, err error) {
 	dataPoints = make([]DataPoint, len(slice))
 	for i := range slice {
 		localTime, err := slice[i].Time.Format()
 		if err != nil {
-			util.Propagate(err)
+			return nil, err
+		}
+
+		yValue, err := linearInterpolateY(matchingReads, slice[i].Time, glucoseUnit)
+		if err != nil {
+			return nil, err
 		}
 
 		dataPoint := DataPoint{localTime, slice.GetEpochTime(i),
-			linearInterpolateY(matchingReads, slice[i].Time, glucoseUnit), float32(slice[i].DurationMinutes), EXERCISE_TAG, "minutes"}
+			yValue, float32(slice[i].DurationMinutes), EXERCISE_TAG, "minutes", ""}
 		dataPoints[i] = dataPoint
 	}
 
-	return dataPoints
+	return dataPoints, nil
 }
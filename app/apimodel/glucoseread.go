@@ -26,15 +26,22 @@ type GlucoseRead struct {
 	Value float32     `json:"value" datastore:"value,noindex"`
 }
 
-// This holds an array of reads for a whole day
+// This holds an array of reads for a whole day. The DeviceId identifies the device the reads come from, an empty
+// value meaning the default device.
 type DayOfGlucoseReads struct {
 	Reads     []GlucoseRead `datastore:"reads,noindex"`
 	StartTime time.Time     `datastore:"startTime"`
 	EndTime   time.Time     `datastore:"endTime"`
+	DeviceId  string        `datastore:"deviceId,noindex"`
 }
 
 func NewDayOfGlucoseReads(reads []GlucoseRead) DayOfGlucoseReads {
-	return DayOfGlucoseReads{reads, reads[0].GetTime().Truncate(DAY_OF_DATA_DURATION), reads[len(reads)-1].GetTime()}
+	return NewDayOfGlucoseReadsForDevice(reads, DEFAULT_DEVICE_ID)
+}
+
+// NewDayOfGlucoseReadsForDevice creates a DayOfGlucoseReads for reads coming from the given device
+func NewDayOfGlucoseReadsForDevice(reads []GlucoseRead, deviceId string) DayOfGlucoseReads {
+	return DayOfGlucoseReads{reads, reads[0].GetTime().Truncate(DAY_OF_DATA_DURATION), reads[len(reads)-1].GetTime(), deviceId}
 }
 
 // GetTime gets the time of a Timestamp value
@@ -64,6 +71,13 @@ func (element GlucoseRead) GetNormalizedValue(unit GlucoseUnit) (float32, error)
 	}
 }
 
+// GetMgPerDlValue returns the value of the read in mg/dL. Unlike GetNormalizedValue, it never fails since reads of any
+// unit convert to mg/dL.
+func (element GlucoseRead) GetMgPerDlValue() float32 {
+	value, _ := element.GetNormalizedValue(MG_PER_DL)
+	return value
+}
+
 type GlucoseReadSlice []GlucoseRead
 
 func (slice GlucoseReadSlice) Len() int {
@@ -86,25 +100,28 @@ func (slice GlucoseReadSlice) GetEpochTime(i int) (epochTime int64) {
 	return slice[i].Time.Timestamp / 1000
 }
 
+func (slice GlucoseReadSlice) GetTimestamp(i int) (timestamp int64) {
+	return slice[i].Time.Timestamp
+}
+
 // ToDataPointSlice converts a GlucoseReadSlice into a generic DataPoint array
-func (slice GlucoseReadSlice) ToDataPointSlice(glucoseUnit GlucoseUnit) (dataPoints []DataPoint) {
+func (slice GlucoseReadSlice) ToDataPointSlice(glucoseUnit GlucoseUnit) (dataPoints []DataPoint, err error) {
 	dataPoints = make([]DataPoint, len(slice))
 	for i := range slice {
 		localTime, err := slice[i].Time.Format()
 		if err != nil {
-			util.Propagate(err)
+			return nil, err
 		}
 
-		// It's pretty terrible if this happens and we crash the app but this is a coding error and I want to know early
 		convertedValue, err := slice[i].GetNormalizedValue(glucoseUnit)
 		if err != nil {
-			util.Propagate(err)
+			return nil, err
 		}
 
-		dataPoint := DataPoint{localTime, slice.GetEpochTime(i), convertedValue, convertedValue, GLUCOSE_READ_TAG, glucoseUnit}
+		dataPoint := DataPoint{localTime, slice.GetEpochTime(i), convertedValue, convertedValue, GLUCOSE_READ_TAG, glucoseUnit, ""}
 		dataPoints[i] = dataPoint
 	}
-	return dataPoints
+	return dataPoints, nil
 }
 
 var UNDEFINED_GLUCOSE_READ = GlucoseRead{Time{GetTimeMillis(util.GLUKIT_EPOCH_TIME), "UTC"}, "NONE", UNDEFINED_READ}
@@ -0,0 +1,308 @@
+package apimodel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"google.golang.org/appengine/datastore"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+const (
+	// Version of the compressed reads encoding, written as the first byte of the blob
+	COMPRESSED_READS_VERSION = 2
+
+	// Version of the compressed reads encoding with offsets in seconds. It's still decoded so that entities stored
+	// before reads had millisecond precision keep loading.
+	SECONDS_COMPRESSED_READS_VERSION = 1
+
+	compressedReadsProperty = "compressedReads"
+	startTimeProperty       = "startTime"
+	endTimeProperty         = "endTime"
+	deviceIdProperty        = "deviceId"
+)
+
+// Names of the multi-valued properties of the original representation of a day of reads where each field of each
+// read was stored individually. They're only read to migrate entities stored before compression was introduced.
+const (
+	legacyReadTimestampsProperty = "reads.time.timestamp"
+	legacyReadTimezonesProperty  = "reads.time.timezone"
+	legacyReadUnitsProperty      = "reads.unit"
+	legacyReadValuesProperty     = "reads.value"
+)
+
+// Load implements datastore.PropertyLoadSaver. Entities stored with an older schema version are upgraded first so
+// that only the current compressed representation needs to be decoded.
+func (dayOfReads *DayOfGlucoseReads) Load(properties []datastore.Property) error {
+	properties, err := UpgradeProperties(DAY_OF_READS_KIND, properties)
+	if err != nil {
+		return err
+	}
+
+	var compressedReads []byte
+	for _, property := range properties {
+		switch property.Name {
+		case startTimeProperty:
+			dayOfReads.StartTime = property.Value.(time.Time)
+		case endTimeProperty:
+			dayOfReads.EndTime = property.Value.(time.Time)
+		case deviceIdProperty:
+			dayOfReads.DeviceId = property.Value.(string)
+		case compressedReadsProperty:
+			compressedReads = property.Value.([]byte)
+		}
+	}
+
+	if compressedReads == nil {
+		return errors.New(fmt.Sprintf("Missing property [%s] in day of reads starting at [%s]", compressedReadsProperty, dayOfReads.StartTime))
+	}
+
+	reads, err := decompressReads(compressedReads)
+	if err != nil {
+		return err
+	}
+
+	dayOfReads.Reads = reads
+	return nil
+}
+
+// Save implements datastore.PropertyLoadSaver. The reads are stored as a single gzipped blob while the start and end
+// times are kept as indexed properties to support the queries on day boundaries.
+func (dayOfReads *DayOfGlucoseReads) Save() (properties []datastore.Property, err error) {
+	compressedReads, err := compressReads(dayOfReads.Reads)
+	if err != nil {
+		return nil, err
+	}
+
+	properties = []datastore.Property{
+		datastore.Property{Name: startTimeProperty, Value: dayOfReads.StartTime},
+		datastore.Property{Name: endTimeProperty, Value: dayOfReads.EndTime},
+		datastore.Property{Name: deviceIdProperty, Value: dayOfReads.DeviceId, NoIndex: true},
+		datastore.Property{Name: compressedReadsProperty, Value: compressedReads, NoIndex: true},
+	}
+
+	return StampSchemaVersion(DAY_OF_READS_KIND, properties), nil
+}
+
+// migrateUnversionedReads upgrades days of reads stored before schema versions. Reads of the legacy representation are
+// compressed and reads compressed with offsets in seconds are compressed again with offsets in milliseconds.
+func migrateUnversionedReads(properties []datastore.Property) (upgraded []datastore.Property, err error) {
+	var reads []GlucoseRead
+	var timestamps []int64
+	var timezones, units []string
+	var values []float64
+	compressed := false
+
+	upgraded = make([]datastore.Property, 0, len(properties))
+	for _, property := range properties {
+		switch property.Name {
+		case compressedReadsProperty:
+			if reads, err = decompressReads(property.Value.([]byte)); err != nil {
+				return nil, err
+			}
+			compressed = true
+		case legacyReadTimestampsProperty:
+			timestamps = append(timestamps, property.Value.(int64))
+		case legacyReadTimezonesProperty:
+			timezones = append(timezones, property.Value.(string))
+		case legacyReadUnitsProperty:
+			units = append(units, property.Value.(string))
+		case legacyReadValuesProperty:
+			values = append(values, property.Value.(float64))
+		default:
+			upgraded = append(upgraded, property)
+		}
+	}
+
+	if !compressed {
+		if len(timezones) != len(timestamps) || len(units) != len(timestamps) || len(values) != len(timestamps) {
+			return nil, errors.New(fmt.Sprintf("Inconsistent legacy reads with [%d] timestamps, [%d] timezones, [%d] units and [%d] values",
+				len(timestamps), len(timezones), len(units), len(values)))
+		}
+
+		reads = make([]GlucoseRead, len(timestamps))
+		for i := range timestamps {
+			reads[i] = GlucoseRead{Time{timestamps[i], timezones[i]}, GlucoseUnit(units[i]), float32(values[i])}
+		}
+	}
+
+	compressedReads, err := compressReads(reads)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(upgraded, datastore.Property{Name: compressedReadsProperty, Value: compressedReads, NoIndex: true}), nil
+}
+
+// compressReads encodes reads in a compact form and gzips the result. The layout is:
+//    version | read count | dictionary of timezones and units | first timestamp (millis)
+// followed by, for each read:
+//    offset in milliseconds from the previous read | timezone index | unit index | value (float32 bits)
+func compressReads(reads []GlucoseRead) (compressed []byte, err error) {
+	var encoded bytes.Buffer
+	scratch := make([]byte, binary.MaxVarintLen64)
+
+	writeUvarint := func(value uint64) {
+		n := binary.PutUvarint(scratch, value)
+		encoded.Write(scratch[:n])
+	}
+	writeVarint := func(value int64) {
+		n := binary.PutVarint(scratch, value)
+		encoded.Write(scratch[:n])
+	}
+
+	dictionary := make([]string, 0)
+	dictionaryIndexes := make(map[string]uint64)
+	indexOf := func(value string) uint64 {
+		if index, exists := dictionaryIndexes[value]; exists {
+			return index
+		}
+		index := uint64(len(dictionary))
+		dictionary = append(dictionary, value)
+		dictionaryIndexes[value] = index
+		return index
+	}
+
+	for i := range reads {
+		indexOf(reads[i].Time.TimeZoneId)
+		indexOf(string(reads[i].Unit))
+	}
+
+	encoded.WriteByte(COMPRESSED_READS_VERSION)
+	writeUvarint(uint64(len(reads)))
+	writeUvarint(uint64(len(dictionary)))
+	for _, value := range dictionary {
+		writeUvarint(uint64(len(value)))
+		encoded.WriteString(value)
+	}
+
+	previousTimestamp := int64(0)
+	if len(reads) > 0 {
+		previousTimestamp = reads[0].Time.Timestamp
+		writeVarint(previousTimestamp)
+	}
+
+	for i := range reads {
+		writeVarint(reads[i].Time.Timestamp - previousTimestamp)
+		previousTimestamp = reads[i].Time.Timestamp
+
+		writeUvarint(dictionaryIndexes[reads[i].Time.TimeZoneId])
+		writeUvarint(dictionaryIndexes[string(reads[i].Unit)])
+		binary.LittleEndian.PutUint32(scratch, math.Float32bits(reads[i].Value))
+		encoded.Write(scratch[:4])
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err = writer.Write(encoded.Bytes()); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// decompressReads decodes a blob produced by compressReads. Blobs of SECONDS_COMPRESSED_READS_VERSION are decoded with
+// offsets in seconds.
+func decompressReads(compressed []byte) (reads []GlucoseRead, err error) {
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := bytes.NewReader(content)
+	version, err := encoded.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var offsetUnit int64
+	switch version {
+	case COMPRESSED_READS_VERSION:
+		offsetUnit = 1
+	case SECONDS_COMPRESSED_READS_VERSION:
+		offsetUnit = 1000
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported compressed reads version [%d], expected [%d]", version, COMPRESSED_READS_VERSION))
+	}
+
+	count, err := binary.ReadUvarint(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	dictionarySize, err := binary.ReadUvarint(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	dictionary := make([]string, dictionarySize)
+	for i := range dictionary {
+		length, err := binary.ReadUvarint(encoded)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, length)
+		if _, err = io.ReadFull(encoded, value); err != nil {
+			return nil, err
+		}
+		dictionary[i] = string(value)
+	}
+
+	reads = make([]GlucoseRead, count)
+	if count == 0 {
+		return reads, nil
+	}
+
+	firstTimestamp, err := binary.ReadVarint(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	// Offsets in seconds are relative to the second of the first read
+	previous := firstTimestamp / offsetUnit
+	valueBytes := make([]byte, 4)
+	for i := range reads {
+		offset, err := binary.ReadVarint(encoded)
+		if err != nil {
+			return nil, err
+		}
+		previous = previous + offset
+
+		timezoneIndex, err := binary.ReadUvarint(encoded)
+		if err != nil {
+			return nil, err
+		}
+		unitIndex, err := binary.ReadUvarint(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if timezoneIndex >= dictionarySize || unitIndex >= dictionarySize {
+			return nil, errors.New(fmt.Sprintf("Corrupted compressed reads, dictionary index out of range [%d]", dictionarySize))
+		}
+
+		if _, err = io.ReadFull(encoded, valueBytes); err != nil {
+			return nil, err
+		}
+
+		timestamp := previous * offsetUnit
+		if i == 0 {
+			timestamp = firstTimestamp
+		}
+		reads[i] = GlucoseRead{Time{timestamp, dictionary[timezoneIndex]}, GlucoseUnit(dictionary[unitIndex]), math.Float32frombits(binary.LittleEndian.Uint32(valueBytes))}
+	}
+
+	return reads, nil
+}
@@ -0,0 +1,131 @@
+package apimodel_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	. "github.com/alexandre-normand/glukit/app/apimodel"
+	"google.golang.org/appengine/datastore"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCompressedDayOfReadsRoundTrip(t *testing.T) {
+	reads := make([]GlucoseRead, 288)
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	for i := range reads {
+		readTime := ct.Add(time.Duration(i*5) * time.Minute)
+		timezone := "America/Los_Angeles"
+		if i%2 == 0 {
+			timezone = "America/Montreal"
+		}
+		reads[i] = GlucoseRead{Time{GetTimeMillis(readTime), timezone}, MG_PER_DL, float32(80 + i%100)}
+	}
+
+	day := NewDayOfGlucoseReads(reads)
+	properties, err := day.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := new(DayOfGlucoseReads)
+	if err = loaded.Load(properties); err != nil {
+		t.Fatal(err)
+	}
+
+	if !loaded.StartTime.Equal(day.StartTime) || !loaded.EndTime.Equal(day.EndTime) {
+		t.Errorf("Boundaries don't match, expected [%v, %v] but got [%v, %v]", day.StartTime, day.EndTime, loaded.StartTime, loaded.EndTime)
+	}
+
+	if len(loaded.Reads) != len(reads) {
+		t.Fatalf("Expected [%d] reads but got [%d]", len(reads), len(loaded.Reads))
+	}
+
+	for i := range reads {
+		if loaded.Reads[i] != reads[i] {
+			t.Errorf("Read at index [%d] doesn't match, expected [%v] but got [%v]", i, reads[i], loaded.Reads[i])
+		}
+	}
+}
+
+func TestCompressedDayOfReadsKeepsMilliseconds(t *testing.T) {
+	ct := time.Date(2014, 4, 18, 0, 0, 0, 0, time.UTC)
+	offsets := []time.Duration{250 * time.Millisecond, 900 * time.Millisecond, time.Second, 5*time.Minute + 1*time.Millisecond}
+	reads := make([]GlucoseRead, len(offsets))
+	for i, offset := range offsets {
+		reads[i] = GlucoseRead{Time{GetTimeMillis(ct.Add(offset)), "UTC"}, MG_PER_DL, float32(100 + i)}
+	}
+
+	day := NewDayOfGlucoseReads(reads)
+	properties, err := day.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := new(DayOfGlucoseReads)
+	if err = loaded.Load(properties); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range reads {
+		if loaded.Reads[i] != reads[i] {
+			t.Errorf("Read at index [%d] doesn't match, expected [%v] but got [%v]", i, reads[i], loaded.Reads[i])
+		}
+	}
+}
+
+func TestLoadOfReadsCompressedWithSecondOffsets(t *testing.T) {
+	var encoded bytes.Buffer
+	scratch := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(value uint64) { encoded.Write(scratch[:binary.PutUvarint(scratch, value)]) }
+	writeVarint := func(value int64) { encoded.Write(scratch[:binary.PutVarint(scratch, value)]) }
+
+	encoded.WriteByte(SECONDS_COMPRESSED_READS_VERSION)
+	writeUvarint(2)
+	writeUvarint(2)
+	for _, value := range []string{"UTC", string(MG_PER_DL)} {
+		writeUvarint(uint64(len(value)))
+		encoded.WriteString(value)
+	}
+	writeVarint(1397779200000)
+	for i, offset := range []int64{0, 300} {
+		writeVarint(offset)
+		writeUvarint(0)
+		writeUvarint(1)
+		binary.LittleEndian.PutUint32(scratch, math.Float32bits(float32(100+i)))
+		encoded.Write(scratch[:4])
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(encoded.Bytes())
+	writer.Close()
+
+	ct := time.Unix(1397779200, 0)
+	properties := []datastore.Property{
+		datastore.Property{Name: "startTime", Value: ct},
+		datastore.Property{Name: "endTime", Value: ct.Add(5 * time.Minute)},
+		datastore.Property{Name: "deviceId", Value: DEFAULT_DEVICE_ID},
+		datastore.Property{Name: "compressedReads", Value: compressed.Bytes()},
+	}
+
+	loaded := new(DayOfGlucoseReads)
+	if err := loaded.Load(properties); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedReads := []GlucoseRead{
+		GlucoseRead{Time{1397779200000, "UTC"}, MG_PER_DL, 100},
+		GlucoseRead{Time{1397779500000, "UTC"}, MG_PER_DL, 101},
+	}
+	if len(loaded.Reads) != len(expectedReads) {
+		t.Fatalf("Expected [%d] reads but got [%d]", len(expectedReads), len(loaded.Reads))
+	}
+
+	for i := range expectedReads {
+		if loaded.Reads[i] != expectedReads[i] {
+			t.Errorf("Read at index [%d] doesn't match, expected [%v] but got [%v]", i, expectedReads[i], loaded.Reads[i])
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package apimodel
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// Conversion factor from mmol/L to mg/dL
+	MG_PER_DL_PER_MMOL_PER_L = 18.0182
+)
+
+// GlucoseValue is a glucose value in mg/dL, the unit everything is stored and calculated with. Values are converted
+// from and to the unit of the user only when they are read from or shown to the user.
+type GlucoseValue float32
+
+// GlucoseValueIn returns the GlucoseValue of a value given in unit. Values in mmol/L are rounded to the closest
+// mg/dL so that a value converted back and forth stays the same (i.e. 3.9 mmol/L is 70 mg/dL).
+func GlucoseValueIn(value float32, unit GlucoseUnit) GlucoseValue {
+	if unit != MMOL_PER_L {
+		return GlucoseValue(value)
+	}
+
+	return GlucoseValue(math.Floor(float64(value)*MG_PER_DL_PER_MMOL_PER_L + 0.5))
+}
+
+// In returns the value in unit. Values in mmol/L are rounded to one decimal, the precision meters show them with.
+// Unknown units are treated as mg/dL.
+func (value GlucoseValue) In(unit GlucoseUnit) float32 {
+	if unit != MMOL_PER_L {
+		return float32(value)
+	}
+
+	return float32(math.Floor(float64(value)/MG_PER_DL_PER_MMOL_PER_L*10.+0.5) / 10.)
+}
+
+// ParseGlucoseUnit returns the GlucoseUnit of the given value or an error if it isn't one of the units a user can
+// choose to see values in
+func ParseGlucoseUnit(value string) (unit GlucoseUnit, err error) {
+	switch value {
+	case MG_PER_DL, MMOL_PER_L:
+		return GlucoseUnit(value), nil
+	default:
+		return UNKNOWN_GLUCOSE_MEASUREMENT_UNIT, errors.New(fmt.Sprintf("Bad unit [%s] is not one of [%s, %s]", value, MG_PER_DL, MMOL_PER_L))
+	}
+}
@@ -0,0 +1,55 @@
+package apimodel
+
+import (
+	"testing"
+)
+
+func TestGlucoseValueInMmolPerL(t *testing.T) {
+	if value := GlucoseValueIn(3.9, MMOL_PER_L); value != 70 {
+		t.Errorf("TestGlucoseValueInMmolPerL failed: got %f but expected 70 mg/dL for 3.9 mmol/L", value)
+	}
+
+	if value := GlucoseValueIn(10., MMOL_PER_L); value != 180 {
+		t.Errorf("TestGlucoseValueInMmolPerL failed: got %f but expected 180 mg/dL for 10.0 mmol/L", value)
+	}
+}
+
+func TestGlucoseValueInMgPerDl(t *testing.T) {
+	if value := GlucoseValueIn(70, MG_PER_DL); value != 70 {
+		t.Errorf("TestGlucoseValueInMgPerDl failed: got %f but expected 70", value)
+	}
+}
+
+func TestGlucoseValueToMmolPerL(t *testing.T) {
+	if value := GlucoseValue(70).In(MMOL_PER_L); value != 3.9 {
+		t.Errorf("TestGlucoseValueToMmolPerL failed: got %f but expected 3.9 mmol/L for 70 mg/dL", value)
+	}
+
+	if value := GlucoseValue(180).In(MMOL_PER_L); value != 10. {
+		t.Errorf("TestGlucoseValueToMmolPerL failed: got %f but expected 10.0 mmol/L for 180 mg/dL", value)
+	}
+}
+
+func TestGlucoseValueToMgPerDl(t *testing.T) {
+	if value := GlucoseValue(70).In(MG_PER_DL); value != 70 {
+		t.Errorf("TestGlucoseValueToMgPerDl failed: got %f but expected 70", value)
+	}
+}
+
+func TestGlucoseValueRoundTrip(t *testing.T) {
+	for _, mmolPerL := range []float32{2.2, 3.9, 5.5, 7.8, 10., 13.9, 22.2} {
+		if value := GlucoseValueIn(mmolPerL, MMOL_PER_L).In(MMOL_PER_L); value != mmolPerL {
+			t.Errorf("TestGlucoseValueRoundTrip failed: got %f but expected %f", value, mmolPerL)
+		}
+	}
+}
+
+func TestParseGlucoseUnit(t *testing.T) {
+	if unit, err := ParseGlucoseUnit(MMOL_PER_L); err != nil || unit != MMOL_PER_L {
+		t.Errorf("TestParseGlucoseUnit failed: got [%s] and error [%v] for [%s]", unit, err, MMOL_PER_L)
+	}
+
+	if _, err := ParseGlucoseUnit("mg"); err == nil {
+		t.Errorf("TestParseGlucoseUnit failed: expected an error for an unknown unit")
+	}
+}
@@ -1,7 +1,6 @@
 package apimodel
 
 import (
-	"github.com/alexandre-normand/glukit/app/util"
 	"time"
 )
 
@@ -9,12 +8,28 @@ const (
 	INSULIN_TAG = "Insulin"
 )
 
-// Injection represents an insulin injection
+// InsulinCategory classifies the insulin of an injection by how long it acts
+type InsulinCategory string
+
+const (
+	INSULIN_CATEGORY_UNKNOWN      InsulinCategory = "unknown"
+	INSULIN_CATEGORY_RAPID_ACTING InsulinCategory = "rapid-acting"
+	INSULIN_CATEGORY_LONG_ACTING  InsulinCategory = "long-acting"
+	INSULIN_CATEGORY_MIXED        InsulinCategory = "mixed"
+)
+
+// Injection represents an insulin injection. InsulinName is the brand name of the insulin and InsulinType the type
+// as described by the source of the injection (i.e. "Bolus" or "Fast-Acting"), both being empty if unknown. Category
+// is the classification of the insulin, INSULIN_CATEGORY_UNKNOWN if it couldn't be determined.
 type Injection struct {
-	Time        Time    `json:"time" datastore:"time,noindex"`
-	Units       float32 `json:"units" datastore:"units,noindex"`
-	InsulinName string  `json:"insulinName" datastore:"insulinName,noindex"`
-	InsulinType string  `json:"insulinType" datastore:"insulinType,noindex"`
+	Time        Time            `json:"time" datastore:"time,noindex"`
+	Units       float32         `json:"units" datastore:"units,noindex"`
+	InsulinName string          `json:"insulinName" datastore:"insulinName,noindex"`
+	InsulinType string          `json:"insulinType" datastore:"insulinType,noindex"`
+	Category    InsulinCategory `json:"category" datastore:"category,noindex"`
+	Id          string          `json:"id" datastore:"id,noindex"`
+	Edited      bool            `json:"edited" datastore:"edited,noindex"`
+	Deleted     bool            `json:"-" datastore:"deleted,noindex"`
 }
 
 // This holds an array of injections for a whole day
@@ -25,9 +40,37 @@ type DayOfInjections struct {
 }
 
 func NewDayOfInjections(injections []Injection) DayOfInjections {
+	InjectionSlice(injections).AssignIds()
 	return DayOfInjections{injections, injections[0].GetTime().Truncate(DAY_OF_DATA_DURATION), injections[len(injections)-1].GetTime()}
 }
 
+// Update replaces the injection with the same id, keeping its time, and flags it as edited so that a new import of the same
+// data doesn't undo the change. It returns false if the day has no such injection or if it was deleted.
+func (dayOfInjections *DayOfInjections) Update(injection Injection) bool {
+	for i := range dayOfInjections.Injections {
+		if !dayOfInjections.Injections[i].Deleted && dayOfInjections.Injections[i].Id == injection.Id {
+			injection.Time, injection.Edited = dayOfInjections.Injections[i].Time, true
+			dayOfInjections.Injections[i] = injection
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delete flags the injection with the given id as deleted. Deleted injections are kept, rather than removed, so that a new import
+// of the same data doesn't bring them back. It returns false if the day has no such injection or if it was already deleted.
+func (dayOfInjections *DayOfInjections) Delete(id string) bool {
+	for i := range dayOfInjections.Injections {
+		if !dayOfInjections.Injections[i].Deleted && dayOfInjections.Injections[i].Id == id {
+			dayOfInjections.Injections[i].Deleted = true
+			return true
+		}
+	}
+
+	return false
+}
+
 // GetTime gets the time of a Timestamp value
 func (element Injection) GetTime() time.Time {
 	return element.Time.GetTime()
@@ -51,20 +94,51 @@ func (slice InjectionSlice) GetEpochTime(i int) (epochTime int64) {
 	return slice[i].Time.Timestamp / 1000
 }
 
+func (slice InjectionSlice) GetTimestamp(i int) (timestamp int64) {
+	return slice[i].Time.Timestamp
+}
+
+// AssignIds gives their id to injections that don't have one yet, as is the case of new injections and of injections stored before
+// they had ids
+func (slice InjectionSlice) AssignIds() {
+	for i := range slice {
+		if len(slice[i].Id) == 0 {
+			slice[i].Id = NewEventId(slice[i].Time.Timestamp)
+		}
+	}
+}
+
+// WithoutDeleted returns the injections that weren't deleted by the user
+func (slice InjectionSlice) WithoutDeleted() (injections []Injection) {
+	injections = make([]Injection, 0, len(slice))
+	for _, injection := range slice {
+		if !injection.Deleted {
+			injections = append(injections, injection)
+		}
+	}
+
+	return injections
+}
+
 // ToDataPointSlice converts an InjectionSlice into a generic DataPoint array
-func (slice InjectionSlice) ToDataPointSlice(matchingReads []GlucoseRead, glucoseUnit GlucoseUnit) (dataPoints []DataPoint) {
+func (slice InjectionSlice) ToDataPointSlice(matchingReads []GlucoseRead, glucoseUnit GlucoseUnit) (dataPoints []DataPoint, err error) {
 	dataPoints = make([]DataPoint, len(slice))
 
 	for i := range slice {
 		localTime, err := slice[i].Time.Format()
 		if err != nil {
-			util.Propagate(err)
+			return nil, err
+		}
+
+		yValue, err := linearInterpolateY(matchingReads, slice[i].Time, glucoseUnit)
+		if err != nil {
+			return nil, err
 		}
 
 		dataPoint := DataPoint{localTime, slice.GetEpochTime(i),
-			linearInterpolateY(matchingReads, slice[i].Time, glucoseUnit), slice[i].Units, INSULIN_TAG, "units"}
+			yValue, slice[i].Units, INSULIN_TAG, "units", ""}
 		dataPoints[i] = dataPoint
 	}
 
-	return dataPoints
+	return dataPoints, nil
 }
@@ -0,0 +1,46 @@
+package apimodel
+
+import (
+	"google.golang.org/appengine/datastore"
+)
+
+// Names of the multi-valued properties of the injections of a day
+const (
+	injectionUnitsProperty      = "injections.units"
+	injectionCategoriesProperty = "injections.category"
+)
+
+// storedDayOfInjections has the fields of DayOfInjections without its Load and Save methods so that it can be loaded
+// and saved as a plain struct
+type storedDayOfInjections DayOfInjections
+
+// Load implements datastore.PropertyLoadSaver
+func (dayOfInjections *DayOfInjections) Load(properties []datastore.Property) error {
+	return loadVersioned(DAY_OF_INJECTIONS_KIND, (*storedDayOfInjections)(dayOfInjections), properties)
+}
+
+// Save implements datastore.PropertyLoadSaver
+func (dayOfInjections *DayOfInjections) Save() (properties []datastore.Property, err error) {
+	return saveVersioned(DAY_OF_INJECTIONS_KIND, (*storedDayOfInjections)(dayOfInjections))
+}
+
+// migrateUnversionedInjections upgrades days of injections stored before schema versions. Injections stored before
+// they had a category get INSULIN_CATEGORY_UNKNOWN.
+func migrateUnversionedInjections(properties []datastore.Property) (upgraded []datastore.Property, err error) {
+	injections, categories := 0, 0
+	for _, property := range properties {
+		switch property.Name {
+		case injectionUnitsProperty:
+			injections++
+		case injectionCategoriesProperty:
+			categories++
+		}
+	}
+
+	upgraded = properties
+	for ; categories < injections; categories++ {
+		upgraded = append(upgraded, datastore.Property{Name: injectionCategoriesProperty, Value: string(INSULIN_CATEGORY_UNKNOWN), NoIndex: true, Multiple: true})
+	}
+
+	return upgraded, nil
+}
@@ -1,7 +1,6 @@
 package apimodel
 
 import (
-	"github.com/alexandre-normand/glukit/app/util"
 	"time"
 )
 
@@ -9,14 +8,21 @@ const (
 	CARB_TAG = "Carbs"
 )
 
-// Meal is the data structure that represents a meal of food intake. Only carbohydrates
-// are fully supported at the moment.
+// Meal is the data structure that represents a meal of food intake. Nutritional values are in grams, zero meaning
+// unknown for everything but carbohydrates, and Description is an optional free-text description of the meal (i.e.
+// "pizza"). Property names of fields that predate Fiber and Description are kept as they were stored so that existing
+// entities load as before.
 type Meal struct {
-	Time          Time    `json:"time" datastore:"time,noindex"`
-	Carbohydrates float32 `json:"carbohydrates" datastore:"carbohydrates,noindex"`
-	Proteins      float32 `json:"proteins" datastore:"proteins,noindex"`
-	Fat           float32 `json:"fat" datastore:"fat,noindex"`
-	SaturatedFat  float32 `json:"saturatedFat" datastore:"saturatedFat,noindex"`
+	Time         Time    `json:"time" datastore:"time,noindex"`
+	Carbs        float32 `json:"carbohydrates" datastore:"carbohydrates,noindex"`
+	Protein      float32 `json:"proteins" datastore:"proteins,noindex"`
+	Fat          float32 `json:"fat" datastore:"fat,noindex"`
+	SaturatedFat float32 `json:"saturatedFat" datastore:"saturatedFat,noindex"`
+	Fiber        float32 `json:"fiber" datastore:"fiber,noindex"`
+	Description  string  `json:"description" datastore:"description,noindex"`
+	Id           string  `json:"id" datastore:"id,noindex"`
+	Edited       bool    `json:"edited" datastore:"edited,noindex"`
+	Deleted      bool    `json:"-" datastore:"deleted,noindex"`
 }
 
 // This holds an array of injections for a whole day
@@ -27,9 +33,37 @@ type DayOfMeals struct {
 }
 
 func NewDayOfMeals(meals []Meal) DayOfMeals {
+	MealSlice(meals).AssignIds()
 	return DayOfMeals{meals, meals[0].GetTime().Truncate(DAY_OF_DATA_DURATION), meals[len(meals)-1].GetTime()}
 }
 
+// Update replaces the meal with the same id, keeping its time, and flags it as edited so that a new import of the same
+// data doesn't undo the change. It returns false if the day has no such meal or if it was deleted.
+func (dayOfMeals *DayOfMeals) Update(meal Meal) bool {
+	for i := range dayOfMeals.Meals {
+		if !dayOfMeals.Meals[i].Deleted && dayOfMeals.Meals[i].Id == meal.Id {
+			meal.Time, meal.Edited = dayOfMeals.Meals[i].Time, true
+			dayOfMeals.Meals[i] = meal
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delete flags the meal with the given id as deleted. Deleted meals are kept, rather than removed, so that a new import
+// of the same data doesn't bring them back. It returns false if the day has no such meal or if it was already deleted.
+func (dayOfMeals *DayOfMeals) Delete(id string) bool {
+	for i := range dayOfMeals.Meals {
+		if !dayOfMeals.Meals[i].Deleted && dayOfMeals.Meals[i].Id == id {
+			dayOfMeals.Meals[i].Deleted = true
+			return true
+		}
+	}
+
+	return false
+}
+
 // GetTime gets the time of a Timestamp value
 func (element Meal) GetTime() time.Time {
 	return element.Time.GetTime()
@@ -53,19 +87,51 @@ func (slice MealSlice) GetEpochTime(i int) (epochTime int64) {
 	return slice[i].Time.Timestamp / 1000
 }
 
-// ToDataPointSlice converts an MealSlice into a generic DataPoint array
-func (slice MealSlice) ToDataPointSlice(matchingReads []GlucoseRead, glucoseUnit GlucoseUnit) (dataPoints []DataPoint) {
+func (slice MealSlice) GetTimestamp(i int) (timestamp int64) {
+	return slice[i].Time.Timestamp
+}
+
+// AssignIds gives their id to meals that don't have one yet, as is the case of new meals and of meals stored before
+// they had ids
+func (slice MealSlice) AssignIds() {
+	for i := range slice {
+		if len(slice[i].Id) == 0 {
+			slice[i].Id = NewEventId(slice[i].Time.Timestamp)
+		}
+	}
+}
+
+// WithoutDeleted returns the meals that weren't deleted by the user
+func (slice MealSlice) WithoutDeleted() (meals []Meal) {
+	meals = make([]Meal, 0, len(slice))
+	for _, meal := range slice {
+		if !meal.Deleted {
+			meals = append(meals, meal)
+		}
+	}
+
+	return meals
+}
+
+// ToDataPointSlice converts an MealSlice into a generic DataPoint array. The description of a meal is kept as the text
+// of its data point.
+func (slice MealSlice) ToDataPointSlice(matchingReads []GlucoseRead, glucoseUnit GlucoseUnit) (dataPoints []DataPoint, err error) {
 	dataPoints = make([]DataPoint, len(slice))
 	for i := range slice {
 		localTime, err := slice[i].Time.Format()
 		if err != nil {
-			util.Propagate(err)
+			return nil, err
+		}
+
+		yValue, err := linearInterpolateY(matchingReads, slice[i].Time, glucoseUnit)
+		if err != nil {
+			return nil, err
 		}
 
 		dataPoint := DataPoint{localTime, slice.GetEpochTime(i),
-			linearInterpolateY(matchingReads, slice[i].Time, glucoseUnit), slice[i].Carbohydrates, CARB_TAG, "grams"}
+			yValue, slice[i].Carbs, CARB_TAG, "grams", slice[i].Description}
 		dataPoints[i] = dataPoint
 	}
 
-	return dataPoints
+	return dataPoints, nil
 }
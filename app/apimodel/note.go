@@ -0,0 +1,176 @@
+package apimodel
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NOTE_TAG = "Note"
+)
+
+// NoteTag is an optional category of a note
+type NoteTag string
+
+const (
+	NOTE_TAG_NONE         NoteTag = ""
+	NOTE_TAG_ILLNESS      NoteTag = "illness"
+	NOTE_TAG_SITE_CHANGE  NoteTag = "site-change"
+	NOTE_TAG_STRESS       NoteTag = "stress"
+	NOTE_TAG_ALCOHOL      NoteTag = "alcohol"
+	NOTE_TAG_MENSTRUATION NoteTag = "menstruation"
+)
+
+var noteTags = []NoteTag{NOTE_TAG_NONE, NOTE_TAG_ILLNESS, NOTE_TAG_SITE_CHANGE, NOTE_TAG_STRESS, NOTE_TAG_ALCOHOL, NOTE_TAG_MENSTRUATION}
+
+// ParseNoteTag returns the NoteTag of the given value or an error if it isn't a known tag
+func ParseNoteTag(value string) (tag NoteTag, err error) {
+	for _, tag := range noteTags {
+		if string(tag) == value {
+			return tag, nil
+		}
+	}
+
+	return NOTE_TAG_NONE, errors.New(fmt.Sprintf("Unknown note tag [%s]", value))
+}
+
+// Note is a free-text annotation of the user's data at a point in time (i.e. "pizza night"). Unlike other events, notes
+// can be edited so they have an Id that is stable within their day of notes.
+type Note struct {
+	Id   string  `json:"id" datastore:"id,noindex"`
+	Time Time    `json:"time" datastore:"time,noindex"`
+	Text string  `json:"text" datastore:"text,noindex"`
+	Tag  NoteTag `json:"tag" datastore:"tag,noindex"`
+}
+
+// This holds an array of notes for a whole day. NextId is the sequence of the id of the next note added to the day.
+type DayOfNotes struct {
+	Notes     []Note    `datastore:"notes,noindex"`
+	StartTime time.Time `datastore:"startTime"`
+	NextId    int64     `datastore:"nextId,noindex"`
+}
+
+// NewDayOfNotes returns an empty day of notes starting at dayStart
+func NewDayOfNotes(dayStart time.Time) DayOfNotes {
+	return DayOfNotes{make([]Note, 0), dayStart, 0}
+}
+
+// Add gives the next id of the day to note and adds it to the day, in chronological order. The note is returned with
+// its id.
+func (dayOfNotes *DayOfNotes) Add(note Note) Note {
+	note.Id = NewNoteId(dayOfNotes.StartTime, dayOfNotes.NextId)
+	dayOfNotes.NextId++
+	dayOfNotes.Notes = append(dayOfNotes.Notes, note)
+	sort.Sort(NoteSlice(dayOfNotes.Notes))
+
+	return note
+}
+
+// Update replaces the text and tag of the note with the same id. It returns false if the day has no such note.
+func (dayOfNotes *DayOfNotes) Update(note Note) bool {
+	for i := range dayOfNotes.Notes {
+		if dayOfNotes.Notes[i].Id == note.Id {
+			dayOfNotes.Notes[i].Text, dayOfNotes.Notes[i].Tag = note.Text, note.Tag
+			return true
+		}
+	}
+
+	return false
+}
+
+// Remove removes the note with the given id. It returns false if the day has no such note. Ids of removed notes are
+// never given again.
+func (dayOfNotes *DayOfNotes) Remove(id string) bool {
+	for i := range dayOfNotes.Notes {
+		if dayOfNotes.Notes[i].Id == id {
+			dayOfNotes.Notes = append(dayOfNotes.Notes[:i], dayOfNotes.Notes[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetTime gets the time of a Timestamp value
+func (element Note) GetTime() time.Time {
+	return element.Time.GetTime()
+}
+
+// DayOfNoteStart returns the start of the day of notes that holds notes at t
+func DayOfNoteStart(t time.Time) time.Time {
+	return t.Truncate(DAY_OF_DATA_DURATION)
+}
+
+// NewNoteId returns the id of the note with the given sequence in the day of notes starting at dayStart
+func NewNoteId(dayStart time.Time, sequence int64) string {
+	return fmt.Sprintf("%d-%d", dayStart.Unix(), sequence)
+}
+
+// ParseNoteId returns the start of the day of notes of the note with the given id
+func ParseNoteId(id string) (dayStart time.Time, err error) {
+	parts := strings.Split(id, "-")
+	if len(parts) != 2 {
+		return dayStart, errors.New(fmt.Sprintf("Invalid note id [%s]", id))
+	}
+
+	if _, err := strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return dayStart, errors.New(fmt.Sprintf("Invalid note id [%s]: %v", id, err))
+	}
+
+	dayStartUnix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return dayStart, errors.New(fmt.Sprintf("Invalid note id [%s]: %v", id, err))
+	}
+
+	return time.Unix(dayStartUnix, 0), nil
+}
+
+type NoteSlice []Note
+
+func (slice NoteSlice) Len() int {
+	return len(slice)
+}
+
+func (slice NoteSlice) Less(i, j int) bool {
+	return slice[i].Time.Timestamp < slice[j].Time.Timestamp
+}
+
+func (slice NoteSlice) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+func (slice NoteSlice) GetEpochTime(i int) (epochTime int64) {
+	return slice[i].Time.Timestamp / 1000
+}
+
+func (slice NoteSlice) GetTimestamp(i int) (timestamp int64) {
+	return slice[i].Time.Timestamp
+}
+
+// ToDataPointSlice converts a NoteSlice into a generic DataPoint array. The text of the note is kept as the text of the
+// data point.
+func (slice NoteSlice) ToDataPointSlice(matchingReads []GlucoseRead, glucoseUnit GlucoseUnit) (dataPoints []DataPoint, err error) {
+	dataPoints = make([]DataPoint, len(slice))
+
+	for i := range slice {
+		localTime, err := slice[i].Time.Format()
+		if err != nil {
+			return nil, err
+		}
+
+		yValue, err := linearInterpolateY(matchingReads, slice[i].Time, glucoseUnit)
+		if err != nil {
+			return nil, err
+		}
+
+		dataPoint := DataPoint{localTime, slice.GetEpochTime(i),
+			yValue, 0., NOTE_TAG, "", slice[i].Text}
+		dataPoints[i] = dataPoint
+	}
+
+	return dataPoints, nil
+}
@@ -0,0 +1,68 @@
+package apimodel_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/apimodel"
+	"testing"
+	"time"
+)
+
+func TestNoteIdsAreStableWithinTheirDay(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 19:00")
+	dayStart := DayOfNoteStart(ct)
+	dayOfNotes := NewDayOfNotes(dayStart)
+
+	texts := []string{"pizza night", "site change", "stress"}
+	notes := make([]Note, len(texts))
+	for i, text := range texts {
+		notes[i] = dayOfNotes.Add(Note{"", Time{GetTimeMillis(ct.Add(time.Duration(-i) * time.Minute)), "America/Los_Angeles"}, text, NOTE_TAG_NONE})
+		if expectedId := NewNoteId(dayStart, int64(i)); notes[i].Id != expectedId {
+			t.Errorf("TestNoteIdsAreStableWithinTheirDay failed: got id [%s] for note [%d] but expected [%s]", notes[i].Id, i, expectedId)
+		}
+	}
+
+	if dayOfNotes.Notes[0].Text != "stress" {
+		t.Errorf("TestNoteIdsAreStableWithinTheirDay failed: expected notes in chronological order but got [%v]", dayOfNotes.Notes)
+	}
+
+	if !dayOfNotes.Remove(notes[0].Id) || dayOfNotes.Remove(notes[0].Id) {
+		t.Errorf("TestNoteIdsAreStableWithinTheirDay failed: expected note [%s] to be removed once", notes[0].Id)
+	}
+
+	if !dayOfNotes.Update(Note{notes[1].Id, notes[1].Time, "infusion site change", NOTE_TAG_SITE_CHANGE}) {
+		t.Errorf("TestNoteIdsAreStableWithinTheirDay failed: expected note [%s] to be updated", notes[1].Id)
+	}
+
+	// The next note of the day doesn't reuse the id of the removed one
+	if note := dayOfNotes.Add(Note{"", Time{GetTimeMillis(ct.Add(time.Hour)), "America/Los_Angeles"}, "sick day", NOTE_TAG_ILLNESS}); note.Id != NewNoteId(dayStart, 3) {
+		t.Errorf("TestNoteIdsAreStableWithinTheirDay failed: got id [%s] but expected [%s]", note.Id, NewNoteId(dayStart, 3))
+	}
+
+	parsedDayStart, err := ParseNoteId(notes[1].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !parsedDayStart.Equal(dayStart) {
+		t.Errorf("TestNoteIdsAreStableWithinTheirDay failed: got day start [%v] from id [%s] but expected [%v]", parsedDayStart, notes[1].Id, dayStart)
+	}
+}
+
+func TestParseNoteTag(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected NoteTag
+		valid    bool
+	}{
+		{"", NOTE_TAG_NONE, true},
+		{"illness", NOTE_TAG_ILLNESS, true},
+		{"site-change", NOTE_TAG_SITE_CHANGE, true},
+		{"pizza", NOTE_TAG_NONE, false},
+	}
+
+	for _, test := range tests {
+		tag, err := ParseNoteTag(test.value)
+		if tag != test.expected || (err == nil) != test.valid {
+			t.Errorf("TestParseNoteTag failed for [%s]: got [%s], [%v] but expected [%s] and valid [%t]", test.value, tag, err, test.expected, test.valid)
+		}
+	}
+}
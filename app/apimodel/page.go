@@ -0,0 +1,86 @@
+package apimodel
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PageCursor is the position, in a time series, right after the last element of a page. Elements sharing a timestamp
+// can be split across pages so the cursor keeps how many of the ones at Timestamp were already returned.
+type PageCursor struct {
+	Timestamp int64
+	Skip      int
+}
+
+const PAGE_CURSOR_SEPARATOR = ":"
+
+// Encode returns the opaque form of the cursor given to clients
+func (cursor PageCursor) Encode() string {
+	value := strconv.FormatInt(cursor.Timestamp, 10) + PAGE_CURSOR_SEPARATOR + strconv.Itoa(cursor.Skip)
+	return base64.URLEncoding.EncodeToString([]byte(value))
+}
+
+// GetTime returns the time of the elements the cursor is at
+func (cursor PageCursor) GetTime() time.Time {
+	return time.Unix(0, cursor.Timestamp*int64(time.Millisecond))
+}
+
+// ParsePageCursor parses a cursor encoded by PageCursor.Encode
+func ParsePageCursor(value string) (cursor PageCursor, err error) {
+	decoded, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, errors.New(fmt.Sprintf("Invalid cursor [%s]: %v", value, err))
+	}
+
+	parts := strings.Split(string(decoded), PAGE_CURSOR_SEPARATOR)
+	if len(parts) != 2 {
+		return cursor, errors.New(fmt.Sprintf("Invalid cursor [%s]", value))
+	}
+
+	if cursor.Timestamp, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return cursor, errors.New(fmt.Sprintf("Invalid cursor [%s]: %v", value, err))
+	}
+
+	if cursor.Skip, err = strconv.Atoi(parts[1]); err != nil || cursor.Skip < 0 {
+		return cursor, errors.New(fmt.Sprintf("Invalid cursor [%s]", value))
+	}
+
+	return cursor, nil
+}
+
+// GetPage returns the boundaries, start inclusive and end exclusive, of the page of up to limit elements of the sorted
+// slice that follows cursor, starting with the first element if cursor is nil. The cursor of the next page is nil if
+// the page ends the slice.
+func GetPage(slice Interface, cursor *PageCursor, limit int) (startIndex, endIndex int, next *PageCursor) {
+	if cursor != nil {
+		skipped := 0
+		for startIndex < slice.Len() {
+			timestamp := slice.GetTimestamp(startIndex)
+			if timestamp > cursor.Timestamp || timestamp == cursor.Timestamp && skipped >= cursor.Skip {
+				break
+			}
+
+			if timestamp == cursor.Timestamp {
+				skipped++
+			}
+			startIndex++
+		}
+	}
+
+	endIndex = startIndex + limit
+	if endIndex >= slice.Len() {
+		return startIndex, slice.Len(), nil
+	}
+
+	last := slice.GetTimestamp(endIndex - 1)
+	next = &PageCursor{last, 0}
+	for i := endIndex - 1; i >= 0 && slice.GetTimestamp(i) == last; i-- {
+		next.Skip++
+	}
+
+	return startIndex, endIndex, next
+}
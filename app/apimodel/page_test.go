@@ -0,0 +1,46 @@
+package apimodel_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/apimodel"
+	"testing"
+	"time"
+)
+
+func TestPageCursorEncoding(t *testing.T) {
+	cursor := PageCursor{1397824215250, 2}
+
+	parsed, err := ParsePageCursor(cursor.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed != cursor {
+		t.Errorf("Expected cursor [%v] but got [%v]", cursor, parsed)
+	}
+
+	if _, err := ParsePageCursor("not a cursor"); err == nil {
+		t.Errorf("Expected an error for an invalid cursor")
+	}
+}
+
+func TestPagesSplitElementsOfTheSameTimestamp(t *testing.T) {
+	ct := time.Date(2014, 4, 18, 12, 0, 0, 0, time.UTC)
+	reads := GlucoseReadSlice{
+		GlucoseRead{Time{GetTimeMillis(ct), "UTC"}, MG_PER_DL, 100},
+		GlucoseRead{Time{GetTimeMillis(ct.Add(5 * time.Minute)), "UTC"}, MG_PER_DL, 110},
+		GlucoseRead{Time{GetTimeMillis(ct.Add(5 * time.Minute)), "UTC"}, MG_PER_DL, 111},
+		GlucoseRead{Time{GetTimeMillis(ct.Add(10 * time.Minute)), "UTC"}, MG_PER_DL, 120},
+	}
+
+	startIndex, endIndex, next := GetPage(reads, nil, 2)
+	if startIndex != 0 || endIndex != 2 || next == nil || next.Skip != 1 || !next.GetTime().Equal(ct.Add(5*time.Minute)) {
+		t.Fatalf("Expected first page [0, 2) with a cursor after the first read at [%s] but got [%d, %d) and [%v]",
+			ct.Add(5*time.Minute), startIndex, endIndex, next)
+	}
+
+	// The next page is fetched again from the time of the cursor so it starts with the reads already returned
+	startIndex, endIndex, next = GetPage(reads[1:], next, 2)
+	if startIndex != 1 || endIndex != 3 || next != nil {
+		t.Errorf("Expected last page [1, 3) without a cursor but got [%d, %d) and [%v]", startIndex, endIndex, next)
+	}
+}
@@ -0,0 +1,29 @@
+package apimodel
+
+import (
+	"time"
+)
+
+// ReadGap is a period without glucose reads, typically when the sensor was off. Start and End are the times of the
+// reads on each side of the gap.
+type ReadGap struct {
+	Start     Time      `json:"start" datastore:"start,noindex"`
+	End       Time      `json:"end" datastore:"end,noindex"`
+	StartTime time.Time `json:"-" datastore:"startTime"`
+	EndTime   time.Time `json:"-" datastore:"endTime"`
+}
+
+// NewReadGap returns the gap between two consecutive reads
+func NewReadGap(previous, next GlucoseRead) ReadGap {
+	return ReadGap{previous.Time, next.Time, previous.GetTime(), next.GetTime()}
+}
+
+// GetTime gets the time at which the gap starts
+func (gap ReadGap) GetTime() time.Time {
+	return gap.Start.GetTime()
+}
+
+// Duration returns how long the gap lasts
+func (gap ReadGap) Duration() time.Duration {
+	return gap.End.GetTime().Sub(gap.Start.GetTime())
+}
@@ -0,0 +1,149 @@
+package apimodel
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/appengine/datastore"
+	"sort"
+)
+
+const (
+	// Property written with every day entity to record the version of the schema it was stored with
+	SCHEMA_VERSION_PROPERTY = "schemaVersion"
+
+	// Version of entities stored before they were stamped with a schema version
+	UNVERSIONED_SCHEMA_VERSION = 0
+
+	// Datastore kinds of the day entities
+	DAY_OF_READS_KIND             = "DayOfReads"
+	DAY_OF_CALIBRATION_READS_KIND = "DayOfCalibrationReads"
+	DAY_OF_INJECTIONS_KIND        = "DayOfInjections"
+	DAY_OF_BASAL_RATES_KIND       = "DayOfBasalRates"
+	DAY_OF_MEALS_KIND             = "DayOfMeals"
+	DAY_OF_EXERCISES_KIND         = "DayOfExercises"
+	DAY_OF_NOTES_KIND             = "DayOfNotes"
+	DAY_OF_STATS_KIND             = "DayOfStats"
+)
+
+// Migration upgrades the properties of an entity stored with a given schema version to the ones of the next version
+type Migration func(properties []datastore.Property) (upgraded []datastore.Property, err error)
+
+// migrations holds, for each kind, the migrations in version order: the migration at index i upgrades entities of
+// version i to version i+1
+var migrations = make(map[string][]Migration)
+
+func init() {
+	RegisterMigration(DAY_OF_READS_KIND, UNVERSIONED_SCHEMA_VERSION, migrateUnversionedReads)
+	RegisterMigration(DAY_OF_INJECTIONS_KIND, UNVERSIONED_SCHEMA_VERSION, migrateUnversionedInjections)
+
+	// Nothing changed for these other than getting a schema version
+	for _, kind := range []string{DAY_OF_CALIBRATION_READS_KIND, DAY_OF_BASAL_RATES_KIND, DAY_OF_MEALS_KIND,
+		DAY_OF_EXERCISES_KIND, DAY_OF_NOTES_KIND, DAY_OF_STATS_KIND} {
+		RegisterMigration(kind, UNVERSIONED_SCHEMA_VERSION, unchanged)
+	}
+}
+
+// RegisterMigration registers the migration of entities of kind from version to the next one. Migrations of a kind
+// must be registered in version order, starting at UNVERSIONED_SCHEMA_VERSION. The current schema version of the kind
+// becomes version + 1.
+func RegisterMigration(kind string, version int64, migration Migration) {
+	if expected := CurrentSchemaVersion(kind); version != expected {
+		panic(fmt.Sprintf("Migration of [%s] registered from version [%d] but expected one from version [%d]", kind, version, expected))
+	}
+
+	migrations[kind] = append(migrations[kind], migration)
+}
+
+// CurrentSchemaVersion returns the version of the schema entities of kind are stored with
+func CurrentSchemaVersion(kind string) int64 {
+	return int64(len(migrations[kind]))
+}
+
+// VersionedKinds returns the kinds that have a schema version, sorted by name
+func VersionedKinds() []string {
+	kinds := make([]string, 0, len(migrations))
+	for kind := range migrations {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	return kinds
+}
+
+// SchemaVersionOf returns the schema version properties were stored with or UNVERSIONED_SCHEMA_VERSION if they don't
+// have one
+func SchemaVersionOf(properties []datastore.Property) int64 {
+	for _, property := range properties {
+		if property.Name == SCHEMA_VERSION_PROPERTY {
+			if version, ok := property.Value.(int64); ok {
+				return version
+			}
+		}
+	}
+
+	return UNVERSIONED_SCHEMA_VERSION
+}
+
+// UpgradeProperties applies, in order, the migrations of kind from the version properties were stored with up to the
+// current one. The returned properties don't include the schema version. Properties of a version more recent than the
+// current one (i.e. stored by a newer release) are returned as they are and loaded on a best effort basis.
+func UpgradeProperties(kind string, properties []datastore.Property) (upgraded []datastore.Property, err error) {
+	version := SchemaVersionOf(properties)
+	upgraded = withoutSchemaVersion(properties)
+
+	kindMigrations := migrations[kind]
+	for ; version < int64(len(kindMigrations)); version++ {
+		if upgraded, err = kindMigrations[version](upgraded); err != nil {
+			return nil, errors.New(fmt.Sprintf("Error migrating [%s] from version [%d]: %v", kind, version, err))
+		}
+	}
+
+	return upgraded, nil
+}
+
+// StampSchemaVersion returns the properties with the current schema version of kind
+func StampSchemaVersion(kind string, properties []datastore.Property) []datastore.Property {
+	return append(withoutSchemaVersion(properties), datastore.Property{Name: SCHEMA_VERSION_PROPERTY, Value: CurrentSchemaVersion(kind), NoIndex: true})
+}
+
+func withoutSchemaVersion(properties []datastore.Property) []datastore.Property {
+	filtered := make([]datastore.Property, 0, len(properties))
+	for _, property := range properties {
+		if property.Name != SCHEMA_VERSION_PROPERTY {
+			filtered = append(filtered, property)
+		}
+	}
+
+	return filtered
+}
+
+// loadVersioned upgrades properties of kind to the current schema version and loads them into dst, a pointer to a
+// struct without Load and Save methods
+func loadVersioned(kind string, dst interface{}, properties []datastore.Property) error {
+	properties, err := UpgradeProperties(kind, properties)
+	if err != nil {
+		return err
+	}
+
+	if err := datastore.LoadStruct(dst, properties); err != nil {
+		if _, mismatch := err.(*datastore.ErrFieldMismatch); !mismatch {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveVersioned saves src, a pointer to a struct without Load and Save methods, stamped with the current schema version
+// of kind
+func saveVersioned(kind string, src interface{}) (properties []datastore.Property, err error) {
+	if properties, err = datastore.SaveStruct(src); err != nil {
+		return nil, err
+	}
+
+	return StampSchemaVersion(kind, properties), nil
+}
+
+func unchanged(properties []datastore.Property) ([]datastore.Property, error) {
+	return properties, nil
+}
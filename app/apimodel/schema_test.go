@@ -0,0 +1,219 @@
+package apimodel_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/apimodel"
+	"google.golang.org/appengine/datastore"
+	"testing"
+	"time"
+)
+
+// legacyDayOfReadsProperties returns the properties of a day of reads as stored before compression, with each field of
+// each read as a multi-valued property
+func legacyDayOfReadsProperties(ct time.Time) []datastore.Property {
+	return []datastore.Property{
+		datastore.Property{Name: "startTime", Value: ct},
+		datastore.Property{Name: "endTime", Value: ct.Add(5 * time.Minute)},
+		datastore.Property{Name: "deviceId", Value: DEFAULT_DEVICE_ID, NoIndex: true},
+		datastore.Property{Name: "reads.time.timestamp", Value: int64(1397779200000), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "reads.time.timezone", Value: "America/Montreal", NoIndex: true, Multiple: true},
+		datastore.Property{Name: "reads.unit", Value: string(MG_PER_DL), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "reads.value", Value: float64(100), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "reads.time.timestamp", Value: int64(1397779500000), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "reads.time.timezone", Value: "America/Montreal", NoIndex: true, Multiple: true},
+		datastore.Property{Name: "reads.unit", Value: string(MG_PER_DL), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "reads.value", Value: float64(101), NoIndex: true, Multiple: true},
+	}
+}
+
+func TestLoadOfUnversionedLegacyDayOfReads(t *testing.T) {
+	ct := time.Unix(1397779200, 0)
+	loaded := new(DayOfGlucoseReads)
+	if err := loaded.Load(legacyDayOfReadsProperties(ct)); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedReads := []GlucoseRead{
+		GlucoseRead{Time{1397779200000, "America/Montreal"}, MG_PER_DL, 100},
+		GlucoseRead{Time{1397779500000, "America/Montreal"}, MG_PER_DL, 101},
+	}
+	if len(loaded.Reads) != len(expectedReads) {
+		t.Fatalf("Expected [%d] reads but got [%d]", len(expectedReads), len(loaded.Reads))
+	}
+
+	for i := range expectedReads {
+		if loaded.Reads[i] != expectedReads[i] {
+			t.Errorf("Read at index [%d] doesn't match, expected [%v] but got [%v]", i, expectedReads[i], loaded.Reads[i])
+		}
+	}
+
+	if !loaded.StartTime.Equal(ct) || loaded.DeviceId != DEFAULT_DEVICE_ID {
+		t.Errorf("Expected start time [%v] and device [%s] but got [%v] and [%s]", ct, DEFAULT_DEVICE_ID, loaded.StartTime, loaded.DeviceId)
+	}
+}
+
+func TestUpgradeOfLegacyDayOfReadsCompressesReads(t *testing.T) {
+	upgraded, err := UpgradeProperties(DAY_OF_READS_KIND, legacyDayOfReadsProperties(time.Unix(1397779200, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressed := 0
+	for _, property := range upgraded {
+		switch property.Name {
+		case "compressedReads":
+			compressed++
+		case "reads.time.timestamp", "reads.time.timezone", "reads.unit", "reads.value":
+			t.Errorf("Expected legacy property [%s] to be removed by the upgrade", property.Name)
+		}
+	}
+
+	if compressed != 1 {
+		t.Errorf("Expected a single compressedReads property but got [%d]", compressed)
+	}
+}
+
+func TestLoadOfUnversionedCompressedDayOfReads(t *testing.T) {
+	ct := time.Unix(1397779200, 0)
+	reads := []GlucoseRead{
+		GlucoseRead{Time{GetTimeMillis(ct), "UTC"}, MG_PER_DL, 100},
+		GlucoseRead{Time{GetTimeMillis(ct.Add(5 * time.Minute)), "UTC"}, MG_PER_DL, 101},
+	}
+	day := NewDayOfGlucoseReads(reads)
+	properties, err := day.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entities compressed with millisecond offsets were stored as they are now, without a schema version
+	unversioned := make([]datastore.Property, 0)
+	for _, property := range properties {
+		if property.Name != SCHEMA_VERSION_PROPERTY {
+			unversioned = append(unversioned, property)
+		}
+	}
+
+	loaded := new(DayOfGlucoseReads)
+	if err := loaded.Load(unversioned); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.Reads) != len(reads) {
+		t.Fatalf("Expected [%d] reads but got [%d]", len(reads), len(loaded.Reads))
+	}
+
+	for i := range reads {
+		if loaded.Reads[i] != reads[i] {
+			t.Errorf("Read at index [%d] doesn't match, expected [%v] but got [%v]", i, reads[i], loaded.Reads[i])
+		}
+	}
+}
+
+func TestSaveOfDayOfReadsStampsSchemaVersion(t *testing.T) {
+	ct := time.Unix(1397779200, 0)
+	day := NewDayOfGlucoseReads([]GlucoseRead{GlucoseRead{Time{GetTimeMillis(ct), "UTC"}, MG_PER_DL, 100}})
+	properties, err := day.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version := SchemaVersionOf(properties); version != CurrentSchemaVersion(DAY_OF_READS_KIND) {
+		t.Errorf("Expected schema version [%d] but got [%d]", CurrentSchemaVersion(DAY_OF_READS_KIND), version)
+	}
+}
+
+func TestUpgradeOfInjectionsWithoutCategory(t *testing.T) {
+	properties := []datastore.Property{
+		datastore.Property{Name: "startTime", Value: time.Unix(1397779200, 0)},
+		datastore.Property{Name: "endTime", Value: time.Unix(1397782800, 0)},
+		datastore.Property{Name: "injections.time.timestamp", Value: int64(1397779200000), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "injections.time.timezone", Value: "UTC", NoIndex: true, Multiple: true},
+		datastore.Property{Name: "injections.units", Value: float64(1.5), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "injections.insulinName", Value: "", NoIndex: true, Multiple: true},
+		datastore.Property{Name: "injections.insulinType", Value: "Humalog", NoIndex: true, Multiple: true},
+		datastore.Property{Name: "injections.time.timestamp", Value: int64(1397782800000), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "injections.time.timezone", Value: "UTC", NoIndex: true, Multiple: true},
+		datastore.Property{Name: "injections.units", Value: float64(12), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "injections.insulinName", Value: "", NoIndex: true, Multiple: true},
+		datastore.Property{Name: "injections.insulinType", Value: "Lantus", NoIndex: true, Multiple: true},
+	}
+
+	upgraded, err := UpgradeProperties(DAY_OF_INJECTIONS_KIND, properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	categories := 0
+	for _, property := range upgraded {
+		if property.Name == "injections.category" {
+			categories++
+			if property.Value != string(INSULIN_CATEGORY_UNKNOWN) {
+				t.Errorf("Expected category [%s] but got [%v]", INSULIN_CATEGORY_UNKNOWN, property.Value)
+			}
+		}
+	}
+
+	if categories != 2 {
+		t.Errorf("Expected [2] categories but got [%d]", categories)
+	}
+}
+
+func TestUpgradeOfCurrentVersionIsUnchanged(t *testing.T) {
+	properties := []datastore.Property{
+		datastore.Property{Name: "startTime", Value: time.Unix(1397779200, 0)},
+		datastore.Property{Name: "injections.units", Value: float64(1.5), NoIndex: true, Multiple: true},
+		datastore.Property{Name: SCHEMA_VERSION_PROPERTY, Value: CurrentSchemaVersion(DAY_OF_INJECTIONS_KIND), NoIndex: true},
+	}
+
+	upgraded, err := UpgradeProperties(DAY_OF_INJECTIONS_KIND, properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(upgraded) != 2 || upgraded[0] != properties[0] || upgraded[1] != properties[1] {
+		t.Errorf("Expected properties of the current version to be unchanged but got [%v]", upgraded)
+	}
+}
+
+func TestUpgradeOfUnversionedMeals(t *testing.T) {
+	properties := []datastore.Property{
+		datastore.Property{Name: "startTime", Value: time.Unix(1397779200, 0)},
+		datastore.Property{Name: "endTime", Value: time.Unix(1397782800, 0)},
+		datastore.Property{Name: "meals.time.timestamp", Value: int64(1397779200000), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "meals.time.timezone", Value: "UTC", NoIndex: true, Multiple: true},
+		datastore.Property{Name: "meals.carbohydrates", Value: float64(45), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "meals.proteins", Value: float64(20), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "meals.fat", Value: float64(10), NoIndex: true, Multiple: true},
+		datastore.Property{Name: "meals.saturatedFat", Value: float64(2), NoIndex: true, Multiple: true},
+	}
+
+	upgraded, err := UpgradeProperties(DAY_OF_MEALS_KIND, properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(upgraded) != len(properties) {
+		t.Fatalf("Expected [%d] properties but got [%d]", len(properties), len(upgraded))
+	}
+
+	for i := range properties {
+		if upgraded[i] != properties[i] {
+			t.Errorf("Property at index [%d] doesn't match, expected [%v] but got [%v]", i, properties[i], upgraded[i])
+		}
+	}
+
+	if version := SchemaVersionOf(StampSchemaVersion(DAY_OF_MEALS_KIND, upgraded)); version != CurrentSchemaVersion(DAY_OF_MEALS_KIND) {
+		t.Errorf("Expected schema version [%d] but got [%d]", CurrentSchemaVersion(DAY_OF_MEALS_KIND), version)
+	}
+}
+
+func TestRegisterMigrationOutOfOrder(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic registering a migration from a version other than the current one")
+		}
+	}()
+
+	RegisterMigration(DAY_OF_MEALS_KIND, CurrentSchemaVersion(DAY_OF_MEALS_KIND)+1, func(properties []datastore.Property) ([]datastore.Property, error) {
+		return properties, nil
+	})
+}
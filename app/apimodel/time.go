@@ -6,22 +6,18 @@ import (
 	"time"
 )
 
+// Time is a point in time with millisecond precision along with the timezone it was recorded in. Timestamp is in
+// milliseconds since the unix epoch.
 type Time struct {
 	Timestamp  int64  `json:"timestamp" datastore:"timestamp,noindex"`
 	TimeZoneId string `json:"timezone" datastore:"timezone,noindex"`
 }
 
-// GetTime gets the time of a Timestamp value
+// GetTime gets the time of a Timestamp value, in UTC if its TimeZoneId isn't a valid location. Format returns the error
+// of an invalid TimeZoneId instead.
 func (element Time) GetTime() (timeValue time.Time) {
-	rawValue := time.Unix(element.Timestamp/1000, 0)
-	if location, err := util.GetOrLoadLocationForName(element.TimeZoneId); err != nil {
-		util.Propagate(err)
-		return time.Unix(0, 0)
-	} else {
-		return rawValue.In(location)
-	}
-
-	return time.Unix(0, 0)
+	rawValue := time.Unix(element.Timestamp/1000, (element.Timestamp%1000)*int64(time.Millisecond))
+	return rawValue.In(util.LocationOrUTC(element.TimeZoneId))
 }
 
 func (element Time) Format() (formatted string, err error) {
@@ -33,8 +29,9 @@ func (element Time) Format() (formatted string, err error) {
 	}
 }
 
-func GetTimeMillis(time time.Time) int64 {
-	return time.Unix() * 1000
+// GetTimeMillis returns the milliseconds since the unix epoch of a time. Sub-millisecond precision is dropped.
+func GetTimeMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
 }
 
 type TimeSlice []Time
@@ -55,9 +52,15 @@ func (slice TimeSlice) GetEpochTime(i int) (epochTime int64) {
 	return slice[i].Timestamp / 1000
 }
 
+func (slice TimeSlice) GetTimestamp(i int) (timestamp int64) {
+	return slice[i].Timestamp
+}
+
 type Interface interface {
 	sort.Interface
 	GetEpochTime(i int) (epochTime int64)
+	// GetTimestamp returns the time of the element at index i in milliseconds since the unix epoch
+	GetTimestamp(i int) (timestamp int64)
 }
 
 // filter filters out any value that is outside of the lower and upper bounds. The two bounds are inclusive and the returned
@@ -73,20 +76,20 @@ func GetBoundariesOfElementsInRange(slice Interface, lowerBound, upperBound time
 	endIndex = arraySize - 1
 
 	// Sort might not be strictly needed depending on the ordering of the datastore loading but since there doesn't
-	// seem to be any warranty, sorting seems like a good idea
-	sort.Sort(slice)
+	// seem to be any warranty, sorting seems like a good idea. The sort is stable so that elements of the same
+	// millisecond keep the order they were loaded in.
+	sort.Stable(slice)
 
+	lowerTimestamp, upperTimestamp := GetTimeMillis(lowerBound), GetTimeMillis(upperBound)
 	for i := arraySize - 1; i > 0; i-- {
-		elementTime := time.Unix(slice.GetEpochTime(i), 0)
-		if !elementTime.After(upperBound) {
+		if slice.GetTimestamp(i) <= upperTimestamp {
 			endIndex = i
 			break
 		}
 	}
 
 	for i := 0; i < arraySize; i++ {
-		elementTime := time.Unix(slice.GetEpochTime(i), 0)
-		if !elementTime.Before(lowerBound) {
+		if slice.GetTimestamp(i) >= lowerTimestamp {
 			startIndex = i
 			break
 		}
@@ -0,0 +1,86 @@
+package apimodel_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/apimodel"
+	"testing"
+	"time"
+)
+
+func TestTimeKeepsMilliseconds(t *testing.T) {
+	ct := time.Date(2014, 4, 18, 12, 30, 15, 250*int(time.Millisecond), time.UTC)
+	value := Time{GetTimeMillis(ct), "UTC"}
+
+	if value.Timestamp != 1397824215250 {
+		t.Errorf("TestTimeKeepsMilliseconds failed: got timestamp [%d] but expected [%d]", value.Timestamp, 1397824215250)
+	}
+
+	if !value.GetTime().Equal(ct) {
+		t.Errorf("TestTimeKeepsMilliseconds failed: got time [%v] but expected [%v]", value.GetTime(), ct)
+	}
+}
+
+func TestBoundariesOfElementsOfTheSameSecond(t *testing.T) {
+	ct := time.Date(2014, 4, 18, 12, 0, 0, 0, time.UTC)
+	calibration := CalibrationRead{Time{GetTimeMillis(ct.Add(100 * time.Millisecond)), "UTC"}, MG_PER_DL, 110}
+	reads := GlucoseReadSlice{
+		GlucoseRead{Time{GetTimeMillis(ct.Add(-5 * time.Minute)), "UTC"}, MG_PER_DL, 100},
+		GlucoseRead{Time{GetTimeMillis(ct.Add(600 * time.Millisecond)), "UTC"}, MG_PER_DL, 112},
+		GlucoseRead{Time{GetTimeMillis(ct.Add(600 * time.Millisecond)), "UTC"}, MG_PER_DL, 113},
+		GlucoseRead{Time{GetTimeMillis(ct.Add(300 * time.Millisecond)), "UTC"}, MG_PER_DL, 111},
+		GlucoseRead{Time{GetTimeMillis(ct.Add(5 * time.Minute)), "UTC"}, MG_PER_DL, 120},
+	}
+
+	// Reads strictly after the calibration, up to the end of its second
+	startIndex, endIndex := GetBoundariesOfElementsInRange(reads, calibration.GetTime().Add(time.Millisecond), ct.Add(999*time.Millisecond))
+	expectedValues := []float32{111, 112, 113}
+	if endIndex-startIndex+1 != len(expectedValues) {
+		t.Fatalf("TestBoundariesOfElementsOfTheSameSecond failed: got boundaries [%d, %d] of %v", startIndex, endIndex, reads)
+	}
+
+	for i, value := range expectedValues {
+		if reads[startIndex+i].Value != value {
+			t.Errorf("TestBoundariesOfElementsOfTheSameSecond failed: got read [%v] at index [%d] but expected value [%f]", reads[startIndex+i], startIndex+i, value)
+		}
+	}
+}
+
+func TestTimeOfInvalidTimezoneIsInUTC(t *testing.T) {
+	ct := time.Date(2014, 4, 18, 12, 0, 0, 0, time.UTC)
+	value := Time{GetTimeMillis(ct), "Not/A_Timezone"}
+
+	if timeValue := value.GetTime(); !timeValue.Equal(ct) || timeValue.Location() != time.UTC {
+		t.Errorf("TestTimeOfInvalidTimezoneIsInUTC failed: got time [%v] but expected [%v]", timeValue, ct)
+	}
+
+	if _, err := value.Format(); err == nil {
+		t.Errorf("TestTimeOfInvalidTimezoneIsInUTC failed: expected an error formatting a time of timezone [%s]", value.TimeZoneId)
+	}
+}
+
+func TestDataPointsOfInvalidElementsAreErrors(t *testing.T) {
+	ct := time.Date(2014, 4, 18, 12, 0, 0, 0, time.UTC)
+	reads := GlucoseReadSlice{GlucoseRead{Time{GetTimeMillis(ct), "UTC"}, MG_PER_DL, 100}}
+
+	if _, err := reads.ToDataPointSlice(GlucoseUnit("furlongs")); err == nil {
+		t.Errorf("TestDataPointsOfInvalidElementsAreErrors failed: expected an error converting reads to an unknown unit")
+	}
+
+	meals := MealSlice{Meal{Time: Time{GetTimeMillis(ct), "Not/A_Timezone"}, Carbs: 30}}
+	if _, err := meals.ToDataPointSlice(reads, MG_PER_DL); err == nil {
+		t.Errorf("TestDataPointsOfInvalidElementsAreErrors failed: expected an error converting a meal of an invalid timezone")
+	}
+}
+
+func TestDataPointsOfEventsWithoutReads(t *testing.T) {
+	ct := time.Date(2014, 4, 18, 12, 0, 0, 0, time.UTC)
+	meals := MealSlice{Meal{Time: Time{GetTimeMillis(ct), "UTC"}, Carbs: 30}}
+
+	dataPoints, err := meals.ToDataPointSlice(nil, MG_PER_DL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dataPoints) != 1 || dataPoints[0].Y != 0 {
+		t.Errorf("TestDataPointsOfEventsWithoutReads failed: expected a single data point at [0] but got %v", dataPoints)
+	}
+}
@@ -1,12 +1,12 @@
 package apimodel
 
-import (
-	"github.com/alexandre-normand/glukit/app/util"
-)
-
 // linearInterpolateY does a linear interpolation of the Y value of a given GlucoseRead for a given
-// time value
-func linearInterpolateY(reads []GlucoseRead, timeValue Time, unit GlucoseUnit) (yValue float32) {
+// time value. The Y value is 0 if there are no reads to interpolate from.
+func linearInterpolateY(reads []GlucoseRead, timeValue Time, unit GlucoseUnit) (yValue float32, err error) {
+	if len(reads) == 0 {
+		return 0., nil
+	}
+
 	lowerIndex := 0
 	upperIndex := len(reads) - 1
 
@@ -19,11 +19,7 @@ func linearInterpolateY(reads []GlucoseRead, timeValue Time, unit GlucoseUnit) (
 	// Handle the case where the timestamp is before the first read we have.
 	// In such as case, we don't interpolate and return the Y value of that read
 	if upperIndex == 0 {
-		value, err := reads[upperIndex].GetNormalizedValue(MG_PER_DL)
-		if err != nil {
-			util.Propagate(err)
-		}
-		return value
+		return reads[upperIndex].GetNormalizedValue(unit)
 	} else {
 		lowerIndex = upperIndex - 1
 	}
@@ -32,17 +28,17 @@ func linearInterpolateY(reads []GlucoseRead, timeValue Time, unit GlucoseUnit) (
 	upperTimeValue := reads[upperIndex].Time
 	lowerYValue, err := reads[lowerIndex].GetNormalizedValue(unit)
 	if err != nil {
-		util.Propagate(err)
+		return 0., err
 	}
 	upperYValue, err := reads[upperIndex].GetNormalizedValue(unit)
 	if err != nil {
-		util.Propagate(err)
+		return 0., err
 	}
 
 	relativeTimePosition := float32((timeValue.Timestamp - lowerTimeValue.Timestamp)) / float32((upperTimeValue.Timestamp - lowerTimeValue.Timestamp))
 	yValue = relativeTimePosition*float32(upperYValue-lowerYValue) + float32(lowerYValue)
 
-	return yValue
+	return yValue, nil
 }
 
 func MergeDataPointArrays(first, second []DataPoint) []DataPoint {
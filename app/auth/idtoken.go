@@ -0,0 +1,211 @@
+// auth package verifies the Google ID tokens native clients authenticate with instead of a session
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Keys Google signs ID tokens with, rotated regularly. The response says how long they can be cached for, keys are
+	// cached for DEFAULT_GOOGLE_KEYS_MAX_AGE if it doesn't.
+	GOOGLE_KEYS_URL             = "https://www.googleapis.com/oauth2/v3/certs"
+	DEFAULT_GOOGLE_KEYS_MAX_AGE = time.Duration(1) * time.Hour
+
+	// Tokens are accepted up to ID_TOKEN_CLOCK_SKEW after they expire to allow for clocks that are slightly off
+	ID_TOKEN_CLOCK_SKEW = time.Duration(30) * time.Second
+)
+
+var (
+	// Issuers of Google ID tokens
+	GoogleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+	// ErrIdTokenExpired is returned when verifying a token that's valid but expired
+	ErrIdTokenExpired = errors.New("auth: id token expired")
+
+	// ErrIdTokenUnknownKey is returned when verifying a token signed with a key that isn't one of the known keys, most
+	// likely because Google rotated its keys since they were fetched
+	ErrIdTokenUnknownKey = errors.New("auth: id token signed with an unknown key")
+
+	maxAgePattern = regexp.MustCompile(`max-age=(\d+)`)
+)
+
+// IdTokenClaims are the claims of a Google ID token used to authenticate its user
+type IdTokenClaims struct {
+	Issuer        string `json:"iss"`
+	Audience      string `json:"aud"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	IssuedAt      int64  `json:"iat"`
+	ExpiresAt     int64  `json:"exp"`
+}
+
+// GetExpiry returns the time the token expires at
+func (claims IdTokenClaims) GetExpiry() time.Time {
+	return time.Unix(claims.ExpiresAt, 0)
+}
+
+type idTokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+}
+
+type jsonWebKeys struct {
+	Keys []struct {
+		KeyId    string `json:"kid"`
+		KeyType  string `json:"kty"`
+		Modulus  string `json:"n"`
+		Exponent string `json:"e"`
+	} `json:"keys"`
+}
+
+// IsIdToken returns true if the bearer token looks like an ID token, a JWT of three dot separated parts, as opposed to
+// an api key or an access token
+func IsIdToken(token string) bool {
+	return strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
+
+// VerifyIdToken verifies the signature of the ID token with the keys, by id, and that it was issued by Google for one
+// of the audiences to a user whose email address was verified. ErrIdTokenExpired is returned for tokens that expired
+// as of now, ErrIdTokenUnknownKey for ones signed with a key that isn't in keys and another error for invalid tokens.
+func VerifyIdToken(token string, keys map[string]*rsa.PublicKey, audiences []string, now time.Time) (claims IdTokenClaims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("Id token isn't made of a header, claims and a signature")
+	}
+
+	var header idTokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, err
+	}
+
+	if header.Algorithm != "RS256" {
+		return claims, errors.New(fmt.Sprintf("Id token signed with unsupported algorithm [%s]", header.Algorithm))
+	}
+
+	key, ok := keys[header.KeyId]
+	if !ok {
+		return claims, ErrIdTokenUnknownKey
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, err
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return claims, errors.New("Id token signature doesn't match")
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, err
+	}
+
+	if !contains(GoogleIssuers, claims.Issuer) {
+		return claims, errors.New(fmt.Sprintf("Id token issued by [%s] and not by Google", claims.Issuer))
+	}
+
+	if len(claims.Audience) == 0 || !contains(audiences, claims.Audience) {
+		return claims, errors.New(fmt.Sprintf("Id token issued for another audience [%s]", claims.Audience))
+	}
+
+	if len(claims.Email) == 0 || !claims.EmailVerified {
+		return claims, errors.New("Id token doesn't have a verified email address")
+	}
+
+	if now.After(claims.GetExpiry().Add(ID_TOKEN_CLOCK_SKEW)) {
+		return claims, ErrIdTokenExpired
+	}
+
+	return claims, nil
+}
+
+// FetchGoogleKeys fetches the keys Google currently signs ID tokens with and returns them along with how long they can
+// be cached for
+func FetchGoogleKeys(client *http.Client) (keys []byte, maxAge time.Duration, err error) {
+	response, err := client.Get(GOOGLE_KEYS_URL)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, 0, errors.New(fmt.Sprintf("Error fetching google keys, got status [%s]", response.Status))
+	}
+
+	keys, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	maxAge = DEFAULT_GOOGLE_KEYS_MAX_AGE
+	if match := maxAgePattern.FindStringSubmatch(response.Header.Get("Cache-Control")); match != nil {
+		if seconds, err := strconv.Atoi(match[1]); err == nil {
+			maxAge = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return keys, maxAge, nil
+}
+
+// ParseGoogleKeys parses the RSA public keys, by id, of the JSON Web Key Set fetched by FetchGoogleKeys
+func ParseGoogleKeys(value []byte) (keys map[string]*rsa.PublicKey, err error) {
+	var keySet jsonWebKeys
+	if err := json.Unmarshal(value, &keySet); err != nil {
+		return nil, err
+	}
+
+	keys = make(map[string]*rsa.PublicKey)
+	for _, key := range keySet.Keys {
+		if key.KeyType != "RSA" {
+			continue
+		}
+
+		modulus, err := base64.RawURLEncoding.DecodeString(key.Modulus)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent, err := base64.RawURLEncoding.DecodeString(key.Exponent)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[key.KeyId] = &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}
+	}
+
+	return keys, nil
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(decoded, value)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
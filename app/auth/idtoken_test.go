@@ -0,0 +1,120 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	. "github.com/alexandre-normand/glukit/app/auth"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const (
+	TEST_KEY_ID    = "test-key"
+	TEST_CLIENT_ID = "client.apps.googleusercontent.com"
+)
+
+var testNow = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, keyId string, claims IdTokenClaims) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": keyId, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestClaims() IdTokenClaims {
+	return IdTokenClaims{Issuer: "https://accounts.google.com", Audience: TEST_CLIENT_ID, Subject: "1234", Email: "user@glukit.com",
+		EmailVerified: true, IssuedAt: testNow.Add(-time.Minute).Unix(), ExpiresAt: testNow.Add(time.Hour).Unix()}
+}
+
+func TestVerifyValidIdToken(t *testing.T) {
+	key := newTestKey(t)
+	token := signTestToken(t, key, TEST_KEY_ID, newTestClaims())
+
+	if !IsIdToken(token) {
+		t.Errorf("Expected [%s] to be an id token", token)
+	}
+
+	claims, err := VerifyIdToken(token, map[string]*rsa.PublicKey{TEST_KEY_ID: &key.PublicKey}, []string{"other", TEST_CLIENT_ID}, testNow)
+	if err != nil || claims.Email != "user@glukit.com" {
+		t.Errorf("Expected a valid token of [user@glukit.com] but got [%s]: %v", claims.Email, err)
+	}
+}
+
+func TestVerifyExpiredIdToken(t *testing.T) {
+	key := newTestKey(t)
+	token := signTestToken(t, key, TEST_KEY_ID, newTestClaims())
+
+	if _, err := VerifyIdToken(token, map[string]*rsa.PublicKey{TEST_KEY_ID: &key.PublicKey}, []string{TEST_CLIENT_ID}, testNow.Add(2*time.Hour)); err != ErrIdTokenExpired {
+		t.Errorf("Expected [%v] but got [%v]", ErrIdTokenExpired, err)
+	}
+}
+
+func TestVerifyInvalidIdTokens(t *testing.T) {
+	key, otherKey := newTestKey(t), newTestKey(t)
+	keys := map[string]*rsa.PublicKey{TEST_KEY_ID: &key.PublicKey}
+
+	if _, err := VerifyIdToken(signTestToken(t, key, "rotated", newTestClaims()), keys, []string{TEST_CLIENT_ID}, testNow); err != ErrIdTokenUnknownKey {
+		t.Errorf("Expected [%v] but got [%v]", ErrIdTokenUnknownKey, err)
+	}
+
+	if _, err := VerifyIdToken(signTestToken(t, otherKey, TEST_KEY_ID, newTestClaims()), keys, []string{TEST_CLIENT_ID}, testNow); err == nil {
+		t.Errorf("Expected an error verifying a token signed with another key")
+	}
+
+	otherAudience := newTestClaims()
+	otherAudience.Audience = "other.apps.googleusercontent.com"
+	if _, err := VerifyIdToken(signTestToken(t, key, TEST_KEY_ID, otherAudience), keys, []string{TEST_CLIENT_ID}, testNow); err == nil {
+		t.Errorf("Expected an error verifying a token issued for another audience")
+	}
+
+	otherIssuer := newTestClaims()
+	otherIssuer.Issuer = "https://example.com"
+	if _, err := VerifyIdToken(signTestToken(t, key, TEST_KEY_ID, otherIssuer), keys, []string{TEST_CLIENT_ID}, testNow); err == nil {
+		t.Errorf("Expected an error verifying a token not issued by google")
+	}
+
+	unverified := newTestClaims()
+	unverified.EmailVerified = false
+	if _, err := VerifyIdToken(signTestToken(t, key, TEST_KEY_ID, unverified), keys, []string{TEST_CLIENT_ID}, testNow); err == nil {
+		t.Errorf("Expected an error verifying a token without a verified email address")
+	}
+}
+
+func TestParseGoogleKeys(t *testing.T) {
+	key := newTestKey(t)
+	keySet := fmt.Sprintf(`{"keys": [{"kid": "%s", "kty": "RSA", "alg": "RS256", "use": "sig", "n": "%s", "e": "%s"}]}`, TEST_KEY_ID,
+		base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()))
+
+	keys, err := ParseGoogleKeys([]byte(keySet))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed, ok := keys[TEST_KEY_ID]; !ok || parsed.N.Cmp(key.PublicKey.N) != 0 || parsed.E != key.PublicKey.E {
+		t.Errorf("Expected key [%s] to be parsed but got %v", TEST_KEY_ID, keys)
+	}
+}
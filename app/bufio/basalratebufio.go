@@ -0,0 +1,91 @@
+package bufio
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/container"
+	"github.com/alexandre-normand/glukit/app/glukitio"
+)
+
+type BufferedBasalRateBatchWriter struct {
+	head      *container.ImmutableList
+	size      int
+	flushSize int
+	wr        glukitio.BasalRateBatchWriter
+}
+
+// NewBasalRateWriterSize returns a new Writer whose buffer has the specified size.
+func NewBasalRateWriterSize(wr glukitio.BasalRateBatchWriter, flushSize int) *BufferedBasalRateBatchWriter {
+	return newBasalRateWriterSize(wr, nil, 0, flushSize)
+}
+
+func newBasalRateWriterSize(wr glukitio.BasalRateBatchWriter, head *container.ImmutableList, size int, flushSize int) *BufferedBasalRateBatchWriter {
+	// Is it already a Writer?
+	b, ok := wr.(*BufferedBasalRateBatchWriter)
+	if ok && b.flushSize >= flushSize {
+		return b
+	}
+
+	w := new(BufferedBasalRateBatchWriter)
+	w.size = size
+	w.flushSize = flushSize
+	w.wr = wr
+	w.head = head
+
+	return w
+}
+
+// WriteBasalRate writes a single apimodel.DayOfBasalRates
+func (b *BufferedBasalRateBatchWriter) WriteBasalRateBatch(p []apimodel.BasalRate) (glukitio.BasalRateBatchWriter, error) {
+	return b.WriteBasalRateBatches([]apimodel.DayOfBasalRates{apimodel.NewDayOfBasalRates(p)})
+}
+
+// WriteBasalRateBatches writes the contents of p into the buffer.
+// It returns the number of batches written.
+// If nn < len(p), it also returns an error explaining
+// why the write is short.
+func (b *BufferedBasalRateBatchWriter) WriteBasalRateBatches(p []apimodel.DayOfBasalRates) (glukitio.BasalRateBatchWriter, error) {
+	w := b
+	for _, batch := range p {
+		if w.size >= w.flushSize {
+			fw, err := w.Flush()
+			if err != nil {
+				return fw, err
+			}
+			w = fw.(*BufferedBasalRateBatchWriter)
+		}
+
+		w = newBasalRateWriterSize(w.wr, container.NewImmutableList(w.head, batch), w.size+1, w.flushSize)
+	}
+
+	return w, nil
+}
+
+// Flush writes any buffered data to the underlying glukitio.Writer.
+func (b *BufferedBasalRateBatchWriter) Flush() (glukitio.BasalRateBatchWriter, error) {
+	if b.size == 0 {
+		return newBasalRateWriterSize(b.wr, nil, 0, b.flushSize), nil
+	}
+	batch := ListToArrayOfBasalRateBatch(b.head)
+
+	if len(batch) > 0 {
+		innerWriter, err := b.wr.WriteBasalRateBatches(batch)
+		if err != nil {
+			return nil, err
+		}
+
+		return newBasalRateWriterSize(innerWriter, nil, 0, b.flushSize), nil
+	}
+
+	return newBasalRateWriterSize(b.wr, nil, 0, b.flushSize), nil
+}
+
+// ListToArrayOfBasalRateBatch returns the batches of the list, whose head is the most recent one,
+// in the order they were written
+func ListToArrayOfBasalRateBatch(head *container.ImmutableList) []apimodel.DayOfBasalRates {
+	r := make([]apimodel.DayOfBasalRates, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.DayOfBasalRates)
+	})
+
+	return r
+}
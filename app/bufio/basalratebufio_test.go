@@ -0,0 +1,198 @@
+package bufio_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/bufio"
+	"github.com/alexandre-normand/glukit/app/glukitio"
+	"log"
+	"testing"
+)
+
+type basalRateWriterState struct {
+	total      int
+	batchCount int
+	writeCount int
+	batches    map[int64][]apimodel.BasalRate
+}
+
+type statsBasalRateWriter struct {
+	state *basalRateWriterState
+}
+
+func NewBasalRateWriterState() *basalRateWriterState {
+	s := new(basalRateWriterState)
+	s.batches = make(map[int64][]apimodel.BasalRate)
+
+	return s
+}
+
+func NewStatsBasalRateWriter(s *basalRateWriterState) *statsBasalRateWriter {
+	w := new(statsBasalRateWriter)
+	w.state = s
+
+	return w
+}
+
+func (w *statsBasalRateWriter) WriteBasalRateBatch(p []apimodel.BasalRate) (glukitio.BasalRateBatchWriter, error) {
+	log.Printf("WriteBasalRateBatch with [%d] elements: %v", len(p), p)
+
+	return w.WriteBasalRateBatches([]apimodel.DayOfBasalRates{apimodel.NewDayOfBasalRates(p)})
+}
+
+func (w *statsBasalRateWriter) WriteBasalRateBatches(p []apimodel.DayOfBasalRates) (glukitio.BasalRateBatchWriter, error) {
+	log.Printf("WriteBasalRateBatch with [%d] batches: %v", len(p), p)
+	for i := range p {
+		dayOfData := p[i]
+		w.state.total += len(dayOfData.BasalRates)
+		w.state.batches[dayOfData.BasalRates[0].GetTime().Unix()] = dayOfData.BasalRates
+	}
+	log.Printf("WriteBasalRateBatch with total of %d", w.state.total)
+	w.state.batchCount += len(p)
+	w.state.writeCount++
+
+	return w, nil
+}
+
+func (w *statsBasalRateWriter) Flush() (glukitio.BasalRateBatchWriter, error) {
+	return w, nil
+}
+
+func TestSimpleWriteOfSingleBasalRateBatch(t *testing.T) {
+	state := NewBasalRateWriterState()
+	w := NewBasalRateWriterSize(NewStatsBasalRateWriter(state), 10)
+	batches := make([]apimodel.DayOfBasalRates, 10)
+	for i := 0; i < 10; i++ {
+		basalRates := make([]apimodel.BasalRate, 24)
+		for j := 0; j < 24; j++ {
+			basalRates[j] = apimodel.BasalRate{apimodel.Time{0, "America/Montreal"}, float32(j), 60, false}
+		}
+		batches[i] = apimodel.NewDayOfBasalRates(basalRates)
+	}
+	newWriter, _ := w.WriteBasalRateBatches(batches)
+	w = newWriter.(*BufferedBasalRateBatchWriter)
+	newWriter, _ = w.Flush()
+	w = newWriter.(*BufferedBasalRateBatchWriter)
+
+	if state.total != 240 {
+		t.Errorf("TestSimpleWriteOfSingleBasalRateBatch failed: got a total of %d but expected %d", state.total, 240)
+	}
+
+	if state.batchCount != 10 {
+		t.Errorf("TestSimpleWriteOfSingleBasalRateBatch failed: got a batchCount of %d but expected %d", state.total, 10)
+	}
+
+	if state.writeCount != 1 {
+		t.Errorf("TestSimpleWriteOfSingleBasalRateBatch failed: got a writeCount of %d but expected %d", state.writeCount, 1)
+	}
+}
+
+func TestIndividualBasalRateWrite(t *testing.T) {
+	state := NewBasalRateWriterState()
+	w := NewBasalRateWriterSize(NewStatsBasalRateWriter(state), 10)
+	basalRates := make([]apimodel.BasalRate, 24)
+	for j := 0; j < 24; j++ {
+		basalRates[j] = apimodel.BasalRate{apimodel.Time{0, "America/Montreal"}, float32(j), 60, false}
+	}
+	newWriter, _ := w.WriteBasalRateBatch(basalRates)
+	w = newWriter.(*BufferedBasalRateBatchWriter)
+	newWriter, _ = w.Flush()
+	w = newWriter.(*BufferedBasalRateBatchWriter)
+
+	if state.total != 24 {
+		t.Errorf("TestIndividualBasalRateWrite failed: got a total of %d but expected %d", state.total, 24)
+	}
+
+	if state.batchCount != 1 {
+		t.Errorf("TestIndividualBasalRateWrite failed: got a batchCount of %d but expected %d", state.total, 1)
+	}
+
+	if state.writeCount != 1 {
+		t.Errorf("TestIndividualBasalRateWrite failed: got a writeCount of %d but expected %d", state.batchCount, 1)
+	}
+}
+
+func TestSimpleWriteLargerThanOneBasalRateBatch(t *testing.T) {
+	state := NewBasalRateWriterState()
+	w := NewBasalRateWriterSize(NewStatsBasalRateWriter(state), 10)
+	batches := make([]apimodel.DayOfBasalRates, 11)
+	for i := 0; i < 11; i++ {
+		basalRates := make([]apimodel.BasalRate, 24)
+		for j := 0; j < 24; j++ {
+			basalRates[j] = apimodel.BasalRate{apimodel.Time{0, "America/Montreal"}, float32(j), 60, false}
+		}
+		batches[i] = apimodel.NewDayOfBasalRates(basalRates)
+	}
+	newWriter, _ := w.WriteBasalRateBatches(batches)
+	w = newWriter.(*BufferedBasalRateBatchWriter)
+
+	if state.total != 240 {
+		t.Errorf("TestSimpleWriteLargerThanOneBasalRateBatch test failed: got a total of %d but expected %d", state.total, 240)
+	}
+
+	if state.batchCount != 10 {
+		t.Errorf("TestSimpleWriteLargerThanOneBasalRateBatch test: got a batchCount of %d but expected %d", state.batchCount, 10)
+	}
+
+	if state.writeCount != 1 {
+		t.Errorf("TestSimpleWriteLargerThanOneBasalRateBatch test failed: got a writeCount of %d but expected %d", state.total, 1)
+	}
+
+	// Flushing should cause the extra BasalRate to be written
+	newWriter, _ = w.Flush()
+	w = newWriter.(*BufferedBasalRateBatchWriter)
+
+	if state.total != 264 {
+		t.Errorf("TestSimpleWriteLargerThanOneBasalRateBatch test failed: got a total of %d but expected %d", state.total, 264)
+	}
+
+	if state.batchCount != 11 {
+		t.Errorf("TestSimpleWriteLargerThanOneBasalRateBatch test: got a batchCount of %d but expected %d", state.batchCount, 11)
+	}
+
+	if state.writeCount != 2 {
+		t.Errorf("TestSimpleWriteLargerThanOneBasalRateBatch test failed: got a writeCount of %d but expected %d", state.total, 2)
+	}
+}
+
+func TestWriteTwoFullBasalRateBatches(t *testing.T) {
+	state := NewBasalRateWriterState()
+	w := NewBasalRateWriterSize(NewStatsBasalRateWriter(state), 10)
+	batches := make([]apimodel.DayOfBasalRates, 20)
+	for i := 0; i < 20; i++ {
+		basalRates := make([]apimodel.BasalRate, 24)
+		for j := 0; j < 24; j++ {
+			basalRates[j] = apimodel.BasalRate{apimodel.Time{0, "America/Montreal"}, float32(j), 60, false}
+		}
+		batches[i] = apimodel.NewDayOfBasalRates(basalRates)
+	}
+	newWriter, _ := w.WriteBasalRateBatches(batches)
+	w = newWriter.(*BufferedBasalRateBatchWriter)
+
+	if state.total != 240 {
+		t.Errorf("TestWriteTwoFullBasalRateBatches test failed: got a total of %d but expected %d", state.total, 240)
+	}
+
+	if state.batchCount != 10 {
+		t.Errorf("TestWriteTwoFullBasalRateBatches test: got a batchCount of %d but expected %d", state.batchCount, 10)
+	}
+
+	if state.writeCount != 1 {
+		t.Errorf("TestWriteTwoFullBasalRateBatches test failed: got a writeCount of %d but expected %d", state.total, 1)
+	}
+
+	// Flushing should cause the extra batch to be written
+	newWriter, _ = w.Flush()
+	w = newWriter.(*BufferedBasalRateBatchWriter)
+
+	if state.total != 480 {
+		t.Errorf("TestWriteTwoFullBasalRateBatches test failed: got a total of %d but expected %d", state.total, 240)
+	}
+
+	if state.batchCount != 20 {
+		t.Errorf("TestWriteTwoFullBasalRateBatches test: got a batchCount of %d but expected %d", state.batchCount, 20)
+	}
+
+	if state.writeCount != 2 {
+		t.Errorf("TestWriteTwoFullBasalRateBatches test failed: got a writeCount of %d but expected %d", state.total, 2)
+	}
+}
@@ -11,14 +11,16 @@ type BufferedCalibrationBatchWriter struct {
 	size      int
 	flushSize int
 	wr        glukitio.CalibrationBatchWriter
+	// Pooled copies of the batches written with WriteCalibrationBatch, given back to the pool once flushed
+	pooled *container.ImmutableList
 }
 
 // NewCalibrationWriterSize returns a new Writer whose Buffer has the specified size.
 func NewCalibrationWriterSize(wr glukitio.CalibrationBatchWriter, flushSize int) *BufferedCalibrationBatchWriter {
-	return newCalibrationWriterSize(wr, nil, 0, flushSize)
+	return newCalibrationWriterSize(wr, nil, nil, 0, flushSize)
 }
 
-func newCalibrationWriterSize(wr glukitio.CalibrationBatchWriter, head *container.ImmutableList, size int, flushSize int) *BufferedCalibrationBatchWriter {
+func newCalibrationWriterSize(wr glukitio.CalibrationBatchWriter, head *container.ImmutableList, pooled *container.ImmutableList, size int, flushSize int) *BufferedCalibrationBatchWriter {
 	// Is it already a Writer?
 	b, ok := wr.(*BufferedCalibrationBatchWriter)
 	if ok && b.flushSize >= flushSize {
@@ -30,13 +32,18 @@ func newCalibrationWriterSize(wr glukitio.CalibrationBatchWriter, head *containe
 	w.flushSize = flushSize
 	w.wr = wr
 	w.head = head
+	w.pooled = pooled
 
 	return w
 }
 
 // WriteCalibration writes a single apimodel.DayOfCalibrationReads
 func (b *BufferedCalibrationBatchWriter) WriteCalibrationBatch(p []apimodel.CalibrationRead) (glukitio.CalibrationBatchWriter, error) {
-	return b.WriteCalibrationBatches([]apimodel.DayOfCalibrationReads{apimodel.NewDayOfCalibrationReads(p)})
+	// p is buffered until the next flush so it's copied, the caller being free to reuse it once this returns. The copy
+	// comes from the pool of batches and is given back once flushed since the underlying writer doesn't retain it.
+	buffer := glukitio.GetCalibrationBatch()
+	*buffer = append(*buffer, p...)
+	return b.write(apimodel.NewDayOfCalibrationReads(*buffer), buffer)
 }
 
 // WriteCalibrationBatches writes the contents of p into the buffer.
@@ -46,28 +53,43 @@ func (b *BufferedCalibrationBatchWriter) WriteCalibrationBatch(p []apimodel.Cali
 func (b *BufferedCalibrationBatchWriter) WriteCalibrationBatches(p []apimodel.DayOfCalibrationReads) (glukitio.CalibrationBatchWriter, error) {
 	w := b
 	for i := range p {
-		batch := p[i]
-		if w.size >= w.flushSize {
-			fw, err := w.Flush()
-			if err != nil {
-				return fw, err
-			}
-			w = fw.(*BufferedCalibrationBatchWriter)
+		fw, err := w.write(p[i], nil)
+		if err != nil {
+			return fw, err
 		}
-
-		w = newCalibrationWriterSize(w.wr, container.NewImmutableList(w.head, batch), w.size+1, w.flushSize)
+		w = fw.(*BufferedCalibrationBatchWriter)
 	}
 
 	return w, nil
 }
 
-// Flush writes any buffered data to the underlying glukitio.Writer.
+// write buffers the batch, flushing the buffer first if it's full. The pooled buffer of the batch, if any, is given
+// back to the pool once the batch is flushed.
+func (b *BufferedCalibrationBatchWriter) write(batch apimodel.DayOfCalibrationReads, buffer *[]apimodel.CalibrationRead) (glukitio.CalibrationBatchWriter, error) {
+	w := b
+	if w.size >= w.flushSize {
+		fw, err := w.Flush()
+		if err != nil {
+			return fw, err
+		}
+		w = fw.(*BufferedCalibrationBatchWriter)
+	}
+
+	pooled := w.pooled
+	if buffer != nil {
+		pooled = container.NewImmutableList(pooled, buffer)
+	}
+
+	return newCalibrationWriterSize(w.wr, container.NewImmutableList(w.head, batch), pooled, w.size+1, w.flushSize), nil
+}
+
+// Flush writes any buffered data to the underlying glukitio.Writer. Batches are given back to the pool once written so
+// only the returned writer must be used after a flush.
 func (b *BufferedCalibrationBatchWriter) Flush() (w glukitio.CalibrationBatchWriter, err error) {
 	if b.size == 0 {
-		return newCalibrationWriterSize(b.wr, nil, 0, b.flushSize), nil
+		return newCalibrationWriterSize(b.wr, nil, nil, 0, b.flushSize), nil
 	}
-	r, size := b.head.ReverseList()
-	batch := ListToArrayOfCalibrationReadBatch(r, size)
+	batch := ListToArrayOfCalibrationReadBatch(b.head)
 
 	if len(batch) > 0 {
 		innerWriter, err := b.wr.WriteCalibrationBatches(batch)
@@ -75,19 +97,23 @@ func (b *BufferedCalibrationBatchWriter) Flush() (w glukitio.CalibrationBatchWri
 			return nil, err
 		}
 
-		return newCalibrationWriterSize(innerWriter, nil, 0, b.flushSize), nil
+		b.pooled.ForEach(func(value interface{}) {
+			glukitio.PutCalibrationBatch(value.(*[]apimodel.CalibrationRead))
+		})
+
+		return newCalibrationWriterSize(innerWriter, nil, nil, 0, b.flushSize), nil
 	}
 
-	return newCalibrationWriterSize(b.wr, nil, 0, b.flushSize), nil
+	return newCalibrationWriterSize(b.wr, nil, nil, 0, b.flushSize), nil
 }
 
-func ListToArrayOfCalibrationReadBatch(head *container.ImmutableList, size int) []apimodel.DayOfCalibrationReads {
-	r := make([]apimodel.DayOfCalibrationReads, size)
-	cursor := head
-	for i := 0; i < size; i++ {
-		r[i] = cursor.Value().(apimodel.DayOfCalibrationReads)
-		cursor = cursor.Next()
-	}
+// ListToArrayOfCalibrationReadBatch returns the batches of the list, whose head is the most recent one,
+// in the order they were written
+func ListToArrayOfCalibrationReadBatch(head *container.ImmutableList) []apimodel.DayOfCalibrationReads {
+	r := make([]apimodel.DayOfCalibrationReads, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.DayOfCalibrationReads)
+	})
 
 	return r
 }
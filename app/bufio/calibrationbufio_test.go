@@ -43,7 +43,7 @@ func (w *statsCalibrationWriter) WriteCalibrationBatches(p []apimodel.DayOfCalib
 	log.Printf("WriteCalibrationBatch with [%d] batches: %v", len(p), p)
 	for _, dayOfData := range p {
 		w.state.total += len(dayOfData.Reads)
-		w.state.batches[dayOfData.Reads[0].GetTime().Unix()] = dayOfData.Reads
+		w.state.batches[dayOfData.Reads[0].GetTime().Unix()] = append([]apimodel.CalibrationRead(nil), dayOfData.Reads...)
 	}
 	log.Printf("WriteCalibrationBatch with total of %d", w.state.total)
 	w.state.batchCount += len(p)
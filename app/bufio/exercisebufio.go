@@ -65,8 +65,7 @@ func (b *BufferedExerciseBatchWriter) Flush() (glukitio.ExerciseBatchWriter, err
 	if b.size == 0 {
 		return newExerciseWriterSize(b.wr, nil, 0, b.flushSize), nil
 	}
-	r, size := b.head.ReverseList()
-	batch := ListToArrayOfExerciseBatch(r, size)
+	batch := ListToArrayOfExerciseBatch(b.head)
 
 	if len(batch) > 0 {
 		innerWriter, err := b.wr.WriteExerciseBatches(batch)
@@ -80,13 +79,13 @@ func (b *BufferedExerciseBatchWriter) Flush() (glukitio.ExerciseBatchWriter, err
 	return newExerciseWriterSize(b.wr, nil, 0, b.flushSize), nil
 }
 
-func ListToArrayOfExerciseBatch(head *container.ImmutableList, size int) []apimodel.DayOfExercises {
-	r := make([]apimodel.DayOfExercises, size)
-	cursor := head
-	for i := 0; i < size; i++ {
-		r[i] = cursor.Value().(apimodel.DayOfExercises)
-		cursor = cursor.Next()
-	}
+// ListToArrayOfExerciseBatch returns the batches of the list, whose head is the most recent one,
+// in the order they were written
+func ListToArrayOfExerciseBatch(head *container.ImmutableList) []apimodel.DayOfExercises {
+	r := make([]apimodel.DayOfExercises, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.DayOfExercises)
+	})
 
 	return r
 }
@@ -64,7 +64,7 @@ func TestSimpleWriteOfSingleExerciseBatch(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		exercises := make([]apimodel.Exercise, 24)
 		for j := 0; j < 24; j++ {
-			exercises[j] = apimodel.Exercise{apimodel.Time{0, "America/Montreal"}, j, "Light", "details"}
+			exercises[j] = apimodel.Exercise{apimodel.Time{0, "America/Montreal"}, j, "Light", "details", "", "", false, false, ""}
 		}
 		batches[i] = apimodel.NewDayOfExercises(exercises)
 	}
@@ -91,7 +91,7 @@ func TestIndividualExerciseWrite(t *testing.T) {
 	w := NewExerciseWriterSize(NewStatsExerciseWriter(state), 10)
 	exercises := make([]apimodel.Exercise, 24)
 	for j := 0; j < 24; j++ {
-		exercises[j] = apimodel.Exercise{apimodel.Time{0, "America/Montreal"}, j, "Light", "details"}
+		exercises[j] = apimodel.Exercise{apimodel.Time{0, "America/Montreal"}, j, "Light", "details", "", "", false, false, ""}
 	}
 	newWriter, _ := w.WriteExerciseBatch(exercises)
 	w = newWriter.(*BufferedExerciseBatchWriter)
@@ -118,7 +118,7 @@ func TestSimpleWriteLargerThanOneExerciseBatch(t *testing.T) {
 	for i := 0; i < 11; i++ {
 		exercises := make([]apimodel.Exercise, 24)
 		for j := 0; j < 24; j++ {
-			exercises[j] = apimodel.Exercise{apimodel.Time{0, "America/Montreal"}, j, "Light", "details"}
+			exercises[j] = apimodel.Exercise{apimodel.Time{0, "America/Montreal"}, j, "Light", "details", "", "", false, false, ""}
 		}
 		batches[i] = apimodel.NewDayOfExercises(exercises)
 	}
@@ -161,7 +161,7 @@ func TestWriteTwoFullExerciseBatches(t *testing.T) {
 	for i := 0; i < 20; i++ {
 		exercises := make([]apimodel.Exercise, 24)
 		for j := 0; j < 24; j++ {
-			exercises[j] = apimodel.Exercise{apimodel.Time{0, "America/Montreal"}, j, "Light", "details"}
+			exercises[j] = apimodel.Exercise{apimodel.Time{0, "America/Montreal"}, j, "Light", "details", "", "", false, false, ""}
 		}
 		batches[i] = apimodel.NewDayOfExercises(exercises)
 	}
@@ -11,14 +11,16 @@ type BufferedGlucoseReadBatchWriter struct {
 	size      int
 	flushSize int
 	wr        glukitio.GlucoseReadBatchWriter
+	// Pooled copies of the batches written with WriteGlucoseReadBatch, given back to the pool once flushed
+	pooled *container.ImmutableList
 }
 
 // NewGlucoseReadWriterSize returns a new Writer whose Buffer has the specified size.
 func NewGlucoseReadWriterSize(wr glukitio.GlucoseReadBatchWriter, flushSize int) *BufferedGlucoseReadBatchWriter {
-	return newGlucoseReadWriterSize(wr, nil, 0, flushSize)
+	return newGlucoseReadWriterSize(wr, nil, nil, 0, flushSize)
 }
 
-func newGlucoseReadWriterSize(wr glukitio.GlucoseReadBatchWriter, head *container.ImmutableList, size int, flushSize int) *BufferedGlucoseReadBatchWriter {
+func newGlucoseReadWriterSize(wr glukitio.GlucoseReadBatchWriter, head *container.ImmutableList, pooled *container.ImmutableList, size int, flushSize int) *BufferedGlucoseReadBatchWriter {
 	// Is it already a Writer?
 	b, ok := wr.(*BufferedGlucoseReadBatchWriter)
 	if ok && b.flushSize >= flushSize {
@@ -30,13 +32,18 @@ func newGlucoseReadWriterSize(wr glukitio.GlucoseReadBatchWriter, head *containe
 	w.flushSize = flushSize
 	w.wr = wr
 	w.head = head
+	w.pooled = pooled
 
 	return w
 }
 
 // WriteGlucose writes a single apimodel.DayOfGlucoseReads
 func (b *BufferedGlucoseReadBatchWriter) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (glukitio.GlucoseReadBatchWriter, error) {
-	return b.WriteGlucoseReadBatches([]apimodel.DayOfGlucoseReads{apimodel.NewDayOfGlucoseReads(p)})
+	// p is buffered until the next flush so it's copied, the caller being free to reuse it once this returns. The copy
+	// comes from the pool of batches and is given back once flushed since the underlying writer doesn't retain it.
+	buffer := glukitio.GetGlucoseReadBatch()
+	*buffer = append(*buffer, p...)
+	return b.write(apimodel.NewDayOfGlucoseReads(*buffer), buffer)
 }
 
 // WriteGlucoseReadBatches writes the contents of p into the Buffer.
@@ -46,28 +53,43 @@ func (b *BufferedGlucoseReadBatchWriter) WriteGlucoseReadBatch(p []apimodel.Gluc
 func (b *BufferedGlucoseReadBatchWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (glukitio.GlucoseReadBatchWriter, error) {
 	w := b
 	for i := range p {
-		batch := p[i]
-		if w.size >= w.flushSize {
-			fw, err := w.Flush()
-			if err != nil {
-				return fw, err
-			}
-			w = fw.(*BufferedGlucoseReadBatchWriter)
+		fw, err := w.write(p[i], nil)
+		if err != nil {
+			return fw, err
 		}
-
-		w = newGlucoseReadWriterSize(w.wr, container.NewImmutableList(w.head, batch), w.size+1, w.flushSize)
+		w = fw.(*BufferedGlucoseReadBatchWriter)
 	}
 
 	return w, nil
 }
 
-// Flush writes any Buffered data to the underlying glukitio.Writer.
+// write buffers the batch, flushing the buffer first if it's full. The pooled buffer of the batch, if any, is given
+// back to the pool once the batch is flushed.
+func (b *BufferedGlucoseReadBatchWriter) write(batch apimodel.DayOfGlucoseReads, buffer *[]apimodel.GlucoseRead) (glukitio.GlucoseReadBatchWriter, error) {
+	w := b
+	if w.size >= w.flushSize {
+		fw, err := w.Flush()
+		if err != nil {
+			return fw, err
+		}
+		w = fw.(*BufferedGlucoseReadBatchWriter)
+	}
+
+	pooled := w.pooled
+	if buffer != nil {
+		pooled = container.NewImmutableList(pooled, buffer)
+	}
+
+	return newGlucoseReadWriterSize(w.wr, container.NewImmutableList(w.head, batch), pooled, w.size+1, w.flushSize), nil
+}
+
+// Flush writes any Buffered data to the underlying glukitio.Writer. Batches are given back to the pool once written so
+// only the returned writer must be used after a flush.
 func (b *BufferedGlucoseReadBatchWriter) Flush() (w glukitio.GlucoseReadBatchWriter, err error) {
 	if b.size == 0 {
-		return newGlucoseReadWriterSize(b.wr, nil, 0, b.flushSize), nil
+		return newGlucoseReadWriterSize(b.wr, nil, nil, 0, b.flushSize), nil
 	}
-	r, size := b.head.ReverseList()
-	batch := ListToArrayOfGlucoseReadBatch(r, size)
+	batch := ListToArrayOfGlucoseReadBatch(b.head)
 
 	if len(batch) > 0 {
 		innerWriter, err := b.wr.WriteGlucoseReadBatches(batch)
@@ -75,19 +97,23 @@ func (b *BufferedGlucoseReadBatchWriter) Flush() (w glukitio.GlucoseReadBatchWri
 			return nil, err
 		}
 
-		return newGlucoseReadWriterSize(innerWriter, nil, 0, b.flushSize), nil
+		b.pooled.ForEach(func(value interface{}) {
+			glukitio.PutGlucoseReadBatch(value.(*[]apimodel.GlucoseRead))
+		})
+
+		return newGlucoseReadWriterSize(innerWriter, nil, nil, 0, b.flushSize), nil
 	}
 
-	return newGlucoseReadWriterSize(b.wr, nil, 0, b.flushSize), nil
+	return newGlucoseReadWriterSize(b.wr, nil, nil, 0, b.flushSize), nil
 }
 
-func ListToArrayOfGlucoseReadBatch(head *container.ImmutableList, size int) []apimodel.DayOfGlucoseReads {
-	r := make([]apimodel.DayOfGlucoseReads, size)
-	cursor := head
-	for i := 0; i < size; i++ {
-		r[i] = cursor.Value().(apimodel.DayOfGlucoseReads)
-		cursor = cursor.Next()
-	}
+// ListToArrayOfGlucoseReadBatch returns the batches of the list, whose head is the most recent one,
+// in the order they were written
+func ListToArrayOfGlucoseReadBatch(head *container.ImmutableList) []apimodel.DayOfGlucoseReads {
+	r := make([]apimodel.DayOfGlucoseReads, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.DayOfGlucoseReads)
+	})
 
 	return r
 }
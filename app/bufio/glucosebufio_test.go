@@ -45,7 +45,7 @@ func (w *statsGlucoseReadWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGluco
 	for _, dayOfData := range p {
 		w.state.total += len(dayOfData.Reads)
 		log.Printf("Adding batch with time [%v]", dayOfData.Reads[0].GetTime())
-		w.state.batches[dayOfData.Reads[0].GetTime().Unix()] = dayOfData.Reads
+		w.state.batches[dayOfData.Reads[0].GetTime().Unix()] = append([]apimodel.GlucoseRead(nil), dayOfData.Reads...)
 	}
 
 	log.Printf("WriteGlucoseReadBatch with total of %d", w.state.total)
@@ -65,8 +65,7 @@ func (b *BufferedInjectionBatchWriter) Flush() (glukitio.InjectionBatchWriter, e
 	if b.size == 0 {
 		return newInjectionWriterSize(b.wr, nil, 0, b.flushSize), nil
 	}
-	r, size := b.head.ReverseList()
-	batch := ListToArrayOfInjectionBatch(r, size)
+	batch := ListToArrayOfInjectionBatch(b.head)
 
 	if len(batch) > 0 {
 		innerWriter, err := b.wr.WriteInjectionBatches(batch)
@@ -80,13 +79,13 @@ func (b *BufferedInjectionBatchWriter) Flush() (glukitio.InjectionBatchWriter, e
 	return newInjectionWriterSize(b.wr, nil, 0, b.flushSize), nil
 }
 
-func ListToArrayOfInjectionBatch(head *container.ImmutableList, size int) []apimodel.DayOfInjections {
-	r := make([]apimodel.DayOfInjections, size)
-	cursor := head
-	for i := 0; i < size; i++ {
-		r[i] = cursor.Value().(apimodel.DayOfInjections)
-		cursor = cursor.Next()
-	}
+// ListToArrayOfInjectionBatch returns the batches of the list, whose head is the most recent one,
+// in the order they were written
+func ListToArrayOfInjectionBatch(head *container.ImmutableList) []apimodel.DayOfInjections {
+	r := make([]apimodel.DayOfInjections, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.DayOfInjections)
+	})
 
 	return r
 }
@@ -64,7 +64,7 @@ func TestSimpleWriteOfSingleInjectionBatch(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		injections := make([]apimodel.Injection, 24)
 		for j := 0; j < 24; j++ {
-			injections[j] = apimodel.Injection{apimodel.Time{0, "America/Montreal"}, float32(j), "Humalog", "Bolus"}
+			injections[j] = apimodel.Injection{apimodel.Time{0, "America/Montreal"}, float32(j), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false}
 		}
 		batches[i] = apimodel.NewDayOfInjections(injections)
 	}
@@ -91,7 +91,7 @@ func TestIndividualInjectionWrite(t *testing.T) {
 	w := NewInjectionWriterSize(NewStatsInjectionWriter(state), 10)
 	injections := make([]apimodel.Injection, 24)
 	for j := 0; j < 24; j++ {
-		injections[j] = apimodel.Injection{apimodel.Time{0, "America/Montreal"}, float32(j), "Humalog", "Bolus"}
+		injections[j] = apimodel.Injection{apimodel.Time{0, "America/Montreal"}, float32(j), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false}
 	}
 	newWriter, _ := w.WriteInjectionBatch(injections)
 	w = newWriter.(*BufferedInjectionBatchWriter)
@@ -118,7 +118,7 @@ func TestSimpleWriteLargerThanOneInjectionBatch(t *testing.T) {
 	for i := 0; i < 11; i++ {
 		injections := make([]apimodel.Injection, 24)
 		for j := 0; j < 24; j++ {
-			injections[j] = apimodel.Injection{apimodel.Time{0, "America/Montreal"}, float32(j), "Humalog", "Bolus"}
+			injections[j] = apimodel.Injection{apimodel.Time{0, "America/Montreal"}, float32(j), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false}
 		}
 		batches[i] = apimodel.NewDayOfInjections(injections)
 	}
@@ -161,7 +161,7 @@ func TestWriteTwoFullInjectionBatches(t *testing.T) {
 	for i := 0; i < 20; i++ {
 		injections := make([]apimodel.Injection, 24)
 		for j := 0; j < 24; j++ {
-			injections[j] = apimodel.Injection{apimodel.Time{0, "America/Montreal"}, float32(j), "Humalog", "Bolus"}
+			injections[j] = apimodel.Injection{apimodel.Time{0, "America/Montreal"}, float32(j), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false}
 		}
 		batches[i] = apimodel.NewDayOfInjections(injections)
 	}
@@ -65,8 +65,7 @@ func (b *BufferedMealBatchWriter) Flush() (glukitio.MealBatchWriter, error) {
 	if b.size == 0 {
 		return newMealWriterSize(b.wr, nil, 0, b.flushSize), nil
 	}
-	r, size := b.head.ReverseList()
-	batch := ListToArrayOfMealBatch(r, size)
+	batch := ListToArrayOfMealBatch(b.head)
 
 	if len(batch) > 0 {
 		innerWriter, err := b.wr.WriteMealBatches(batch)
@@ -80,13 +79,13 @@ func (b *BufferedMealBatchWriter) Flush() (glukitio.MealBatchWriter, error) {
 	return newMealWriterSize(b.wr, nil, 0, b.flushSize), nil
 }
 
-func ListToArrayOfMealBatch(head *container.ImmutableList, size int) []apimodel.DayOfMeals {
-	r := make([]apimodel.DayOfMeals, size)
-	cursor := head
-	for i := 0; i < size; i++ {
-		r[i] = cursor.Value().(apimodel.DayOfMeals)
-		cursor = cursor.Next()
-	}
+// ListToArrayOfMealBatch returns the batches of the list, whose head is the most recent one,
+// in the order they were written
+func ListToArrayOfMealBatch(head *container.ImmutableList) []apimodel.DayOfMeals {
+	r := make([]apimodel.DayOfMeals, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.DayOfMeals)
+	})
 
 	return r
 }
@@ -63,7 +63,7 @@ func TestSimpleWriteOfSingleMealBatch(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		meals := make([]apimodel.Meal, 24)
 		for j := 0; j < 24; j++ {
-			meals[j] = apimodel.Meal{apimodel.Time{0, "America/Montreal"}, float32(j), float32(j + 1), float32(j + 2), float32(j + 3)}
+			meals[j] = apimodel.Meal{apimodel.Time{0, "America/Montreal"}, float32(j), float32(j + 1), float32(j + 2), float32(j + 3), float32(j + 4), "", "", false, false}
 		}
 		batches[i] = apimodel.NewDayOfMeals(meals)
 	}
@@ -90,7 +90,7 @@ func TestIndividualMealWrite(t *testing.T) {
 	w := NewMealWriterSize(NewStatsMealWriter(state), 10)
 	meals := make([]apimodel.Meal, 24)
 	for j := 0; j < 24; j++ {
-		meals[j] = apimodel.Meal{apimodel.Time{0, "America/Montreal"}, float32(j), float32(j + 1), float32(j + 2), float32(j + 3)}
+		meals[j] = apimodel.Meal{apimodel.Time{0, "America/Montreal"}, float32(j), float32(j + 1), float32(j + 2), float32(j + 3), float32(j + 4), "", "", false, false}
 	}
 	newWriter, _ := w.WriteMealBatch(meals)
 	w = newWriter.(*BufferedMealBatchWriter)
@@ -117,7 +117,7 @@ func TestSimpleWriteLargerThanOneMealBatch(t *testing.T) {
 	for i := 0; i < 11; i++ {
 		meals := make([]apimodel.Meal, 24)
 		for j := 0; j < 24; j++ {
-			meals[j] = apimodel.Meal{apimodel.Time{0, "America/Montreal"}, float32(j), float32(j + 1), float32(j + 2), float32(j + 3)}
+			meals[j] = apimodel.Meal{apimodel.Time{0, "America/Montreal"}, float32(j), float32(j + 1), float32(j + 2), float32(j + 3), float32(j + 4), "", "", false, false}
 		}
 		batches[i] = apimodel.NewDayOfMeals(meals)
 	}
@@ -160,7 +160,7 @@ func TestWriteTwoFullMealBatches(t *testing.T) {
 	for i := 0; i < 20; i++ {
 		meals := make([]apimodel.Meal, 24)
 		for j := 0; j < 24; j++ {
-			meals[j] = apimodel.Meal{apimodel.Time{0, "America/Montreal"}, float32(j), float32(j + 1), float32(j + 2), float32(j + 3)}
+			meals[j] = apimodel.Meal{apimodel.Time{0, "America/Montreal"}, float32(j), float32(j + 1), float32(j + 2), float32(j + 3), float32(j + 4), "", "", false, false}
 		}
 		batches[i] = apimodel.NewDayOfMeals(meals)
 	}
@@ -3,6 +3,7 @@ package config
 
 import (
 	"github.com/alexandre-normand/glukit/app/secrets"
+	"github.com/alexandre-normand/glukit/app/util"
 	"google.golang.org/appengine"
 )
 
@@ -16,6 +17,24 @@ type AppConfig struct {
 	SSLHost              string
 	StripeKey            string
 	StripePublishableKey string
+	// Export of anonymized imported records to a BigQuery table, see the analytics package. Admins can still turn off
+	// an enabled export at runtime with its kill switch.
+	AnalyticsExportEnabled bool
+	BigQueryProjectId      string
+	BigQueryDatasetId      string
+	BigQueryTableId        string
+	// Key and secret of the Dropbox app that users connect as a source of data files. The app has access to its own
+	// folder only.
+	DropboxAppKey    string
+	DropboxAppSecret string
+	// Key third-party credentials users give us, like their Tidepool password, are encrypted with before being stored
+	CredentialsEncryptionKey string
+	// Keys the OAuth tokens of users are encrypted with before being stored. The previous key, if any, is only used to
+	// decrypt tokens stored before the current one replaced it, until they are all encrypted again.
+	TokenKeyRing util.KeyRing
+	// Client ids Google id tokens authenticating api requests must be issued for, the web app's and the ones of the
+	// native clients
+	IdTokenAudiences []string
 }
 
 // newTestAppConfig returns the AppConfig for a test environment
@@ -27,6 +46,12 @@ func newTestAppConfig(appSecrets *secrets.AppSecrets) *AppConfig {
 	appConfig.SSLHost = "http://localhost:8080"
 	appConfig.StripeKey = appSecrets.LocalStripeKey
 	appConfig.StripePublishableKey = appSecrets.LocalStripePublishableKey
+	appConfig.AnalyticsExportEnabled = false
+	appConfig.DropboxAppKey = appSecrets.DropboxAppKey
+	appConfig.DropboxAppSecret = appSecrets.DropboxAppSecret
+	appConfig.CredentialsEncryptionKey = appSecrets.CredentialsEncryptionKey
+	appConfig.TokenKeyRing = newTokenKeyRing(appSecrets)
+	appConfig.IdTokenAudiences = []string{appConfig.GoogleClientId, appSecrets.IosGoogleClientId, appSecrets.AndroidGoogleClientId}
 
 	return appConfig
 }
@@ -40,10 +65,29 @@ func newProdAppConfig(appSecrets *secrets.AppSecrets) *AppConfig {
 	appConfig.SSLHost = "https://glukit.appspot.com"
 	appConfig.StripeKey = appSecrets.ProdStripeKey
 	appConfig.StripePublishableKey = appSecrets.ProdStripePublishableKey
+	appConfig.AnalyticsExportEnabled = false
+	appConfig.BigQueryProjectId = "glukit"
+	appConfig.BigQueryDatasetId = "analytics"
+	appConfig.BigQueryTableId = "imported_records"
+	appConfig.DropboxAppKey = appSecrets.DropboxAppKey
+	appConfig.DropboxAppSecret = appSecrets.DropboxAppSecret
+	appConfig.CredentialsEncryptionKey = appSecrets.CredentialsEncryptionKey
+	appConfig.TokenKeyRing = newTokenKeyRing(appSecrets)
+	appConfig.IdTokenAudiences = []string{appConfig.GoogleClientId, appSecrets.IosGoogleClientId, appSecrets.AndroidGoogleClientId}
 
 	return appConfig
 }
 
+// newTokenKeyRing returns the key ring of the current token encryption key and of the previous one, if any
+func newTokenKeyRing(appSecrets *secrets.AppSecrets) util.KeyRing {
+	keyRing := util.KeyRing{appSecrets.TokenEncryptionKeyId, map[string]string{appSecrets.TokenEncryptionKeyId: appSecrets.TokenEncryptionKey}}
+	if len(appSecrets.PreviousTokenEncryptionKeyId) > 0 && len(appSecrets.PreviousTokenEncryptionKey) > 0 {
+		keyRing.Keys[appSecrets.PreviousTokenEncryptionKeyId] = appSecrets.PreviousTokenEncryptionKey
+	}
+
+	return keyRing
+}
+
 // NewAppConfig returns the AppConfig that matches the current environment (test or prod)
 // as returned by appengine.IsDevAppServer()
 func NewAppConfig() *AppConfig {
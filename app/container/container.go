@@ -1,18 +1,28 @@
 /*
 Package container provides a functional-style compatible immutable list. Users can only append to a list by creating a new list that points to an existing one.
 Therefore, any existing list remains immuted. It is, in other words, a prepend-only list.
+
+Each node knows the length of the list it heads so that Len is constant time and values can be copied in the order they
+were appended, oldest first, without reversing the list:
+
+	values := make([]interface{}, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		values[i] = value
+	})
 */
 package container
 
 type ImmutableList struct {
 	next  *ImmutableList
 	value interface{}
+	size  int
 }
 
 func NewImmutableList(next *ImmutableList, value interface{}) *ImmutableList {
 	l := new(ImmutableList)
 	l.next = next
 	l.value = value
+	l.size = next.Len() + 1
 
 	return l
 }
@@ -25,15 +35,39 @@ func (head *ImmutableList) Value() (value interface{}) {
 	return head.value
 }
 
-func (head *ImmutableList) ReverseList() (r *ImmutableList, size int) {
+// Len returns the number of values of the list, 0 for a nil list
+func (head *ImmutableList) Len() int {
 	if head == nil {
-		return nil, 0
+		return 0
+	}
+
+	return head.size
+}
+
+// ForEach calls fn with every value of the list, starting with the head which is the most recently appended one
+func (head *ImmutableList) ForEach(fn func(value interface{})) {
+	for cursor := head; cursor != nil; cursor = cursor.next {
+		fn(cursor.value)
+	}
+}
+
+// ForEachIndexed calls fn with every value of the list, starting with the head, along with its index in the order
+// values were appended. The oldest value is at index 0 and the head at Len()-1 so filling a slice of Len() values at
+// those indexes copies the list in append order without reversing it.
+func (head *ImmutableList) ForEachIndexed(fn func(i int, value interface{})) {
+	i := head.Len() - 1
+	for cursor := head; cursor != nil; cursor = cursor.next {
+		fn(i, cursor.value)
+		i--
 	}
+}
 
-	r = &ImmutableList{nil, head.Value()}
-	for cursor := head; cursor != nil; size, cursor = size+1, cursor.Next() {
-		r = NewImmutableList(r, cursor.Value())
+// ReverseList returns a copy of the list in reverse order, the oldest value being its head, along with its length.
+// ForEachIndexed is cheaper when the values only need to be visited in append order.
+func (head *ImmutableList) ReverseList() (r *ImmutableList, size int) {
+	for cursor := head; cursor != nil; cursor = cursor.next {
+		r = NewImmutableList(r, cursor.value)
 	}
 
-	return r, size
+	return r, head.Len()
 }
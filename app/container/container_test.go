@@ -1,27 +1,113 @@
 package container_test
 
 import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
 	. "github.com/alexandre-normand/glukit/app/container"
-	"github.com/alexandre-normand/glukit/app/model"
 	"testing"
 	"time"
 )
 
+func newRead(readTime time.Time, value float32) apimodel.GlucoseRead {
+	return apimodel.GlucoseRead{Time: apimodel.Time{Timestamp: apimodel.GetTimeMillis(readTime), TimeZoneId: "America/Montreal"},
+		Unit: apimodel.MG_PER_DL, Value: value}
+}
+
 func TestListReversal(t *testing.T) {
 	ct, _ := time.Parse("02/01/2006 00:15", "18/04/2014 00:00")
-	current := NewImmutableList(nil, model.GlucoseRead{model.Timestamp{"", ct.Unix()}, 0})
+	current := NewImmutableList(nil, newRead(ct, 0))
 
 	for i := 0; i < 10; i++ {
 		readTime := ct.Add(time.Duration(i+1) * 30 * time.Minute)
-		current = NewImmutableList(current, model.GlucoseRead{model.Timestamp{"", readTime.Unix()}, i + 1})
+		current = NewImmutableList(current, newRead(readTime, float32(i+1)))
 	}
 
 	r, _ := current.ReverseList()
 
-	for previous, cursor := r.Next(), r.Next().Next(); cursor.Next() != nil; previous, cursor = previous.Next(), cursor.Next() {
-		t.Logf("Current is %d and previous is %d", cursor.Value().(model.GlucoseRead).Value, previous.Value().(model.GlucoseRead).Value)
-		if cursor.Value().(model.GlucoseRead).Value <= previous.Value().(model.GlucoseRead).Value {
-			t.Errorf("TestListReversal test failed: list in incorrect order: %s", r)
+	for previous, cursor := r, r.Next(); cursor != nil; previous, cursor = previous.Next(), cursor.Next() {
+		t.Logf("Current is %f and previous is %f", cursor.Value().(apimodel.GlucoseRead).Value, previous.Value().(apimodel.GlucoseRead).Value)
+		if cursor.Value().(apimodel.GlucoseRead).Value <= previous.Value().(apimodel.GlucoseRead).Value {
+			t.Errorf("TestListReversal test failed: list in incorrect order: %v", r)
 		}
 	}
 }
+
+func TestLen(t *testing.T) {
+	var head *ImmutableList
+	if head.Len() != 0 {
+		t.Errorf("Expected length of nil list to be [0] but got [%d]", head.Len())
+	}
+
+	for i := 0; i < 10; i++ {
+		head = NewImmutableList(head, i)
+	}
+
+	if head.Len() != 10 || head.Next().Len() != 9 {
+		t.Errorf("Expected lengths of [10] and [9] but got [%d] and [%d]", head.Len(), head.Next().Len())
+	}
+
+	if r, size := head.ReverseList(); size != 10 || r.Len() != 10 || r.Value().(int) != 0 {
+		t.Errorf("Expected reversed list of [10] values starting with [0] but got [%d] values starting with [%v]", size, r.Value())
+	}
+}
+
+func TestForEachIndexedInAppendOrder(t *testing.T) {
+	var head *ImmutableList
+	for i := 0; i < 10; i++ {
+		head = NewImmutableList(head, i)
+	}
+
+	values := make([]int, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		values[i] = value.(int)
+	})
+
+	for i, value := range values {
+		if value != i {
+			t.Errorf("Expected values in append order but got %v", values)
+			break
+		}
+	}
+
+	visited := 0
+	head.ForEach(func(value interface{}) {
+		if value.(int) != 9-visited {
+			t.Errorf("Expected value [%d] to be visited next but got [%v]", 9-visited, value)
+		}
+		visited++
+	})
+}
+
+func newBenchmarkList(size int) (head *ImmutableList) {
+	for i := 0; i < size; i++ {
+		head = NewImmutableList(head, i)
+	}
+
+	return head
+}
+
+func BenchmarkReverseListToSlice(b *testing.B) {
+	head := newBenchmarkList(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		r, size := head.ReverseList()
+		values := make([]int, size)
+		cursor := r
+		for i := 0; i < size; i++ {
+			values[i] = cursor.Value().(int)
+			cursor = cursor.Next()
+		}
+	}
+}
+
+func BenchmarkForEachIndexedToSlice(b *testing.B) {
+	head := newBenchmarkList(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		values := make([]int, head.Len())
+		head.ForEachIndexed(func(i int, value interface{}) {
+			values[i] = value.(int)
+		})
+	}
+}
@@ -1,10 +1,12 @@
 package dexcomimporter
 
 import (
+	"encoding/xml"
 	"github.com/alexandre-normand/glukit/app/apimodel"
 	"github.com/alexandre-normand/glukit/app/util"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 type Glucose struct {
@@ -35,15 +37,33 @@ type EventTimestamp struct {
 	EventTime    string `json:"eventTime"`
 }
 
+// GetSerialNumber returns the serial number of the receiver found in the attributes of the Patient element or an empty
+// string if there's none
+func GetSerialNumber(patient xml.StartElement) string {
+	for _, attr := range patient.Attr {
+		if attr.Name.Local == "SerialNumber" {
+			return strings.TrimSpace(attr.Value)
+		}
+	}
+
+	return ""
+}
+
 var mmolValueRegExp = regexp.MustCompile("\\d\\.\\d\\d")
 var mgValueRegExp = regexp.MustCompile("\\d+")
 
+// ConvertXmlGlucoseRead converts a Glucose element to a GlucoseRead. The time of the read is the internal time of the
+// receiver, which is UTC and always increases, and its timezone is the offset of the display time at the time of the
+// read. A user that travels across timezones gets reads with different offsets but never overlapping or reordered reads.
 func ConvertXmlGlucoseRead(read Glucose) (*apimodel.GlucoseRead, error) {
 	// Convert display/internal to timestamp with timezone extracted
 	if timeUTC, err := util.GetTimeUTC(read.InternalTime); err != nil {
 		return nil, err
 	} else {
-		timeLocation := util.GetLocaltimeOffset(read.DisplayTime, timeUTC)
+		timeLocation, err := util.ParseLocaltimeOffset(read.DisplayTime, timeUTC)
+		if err != nil {
+			return nil, err
+		}
 
 		unit := getUnitFromValue(read.Value)
 
@@ -72,12 +92,17 @@ func getUnitFromValue(value string) (unit apimodel.GlucoseUnit) {
 	return unit
 }
 
+// ConvertXmlCalibrationRead converts a Meter element to a CalibrationRead with its time derived the same way as for
+// glucose reads
 func ConvertXmlCalibrationRead(calibration Calibration) (*apimodel.CalibrationRead, error) {
 	// Convert display/internal to timestamp with timezone extracted
 	if timeUTC, err := util.GetTimeUTC(calibration.InternalTime); err != nil {
 		return nil, err
 	} else {
-		timeLocation := util.GetLocaltimeOffset(calibration.DisplayTime, timeUTC)
+		timeLocation, err := util.ParseLocaltimeOffset(calibration.DisplayTime, timeUTC)
+		if err != nil {
+			return nil, err
+		}
 
 		unit := getUnitFromValue(calibration.Value)
 		if value, err := strconv.ParseFloat(calibration.Value, 32); err != nil {
@@ -7,7 +7,6 @@ import (
 	"github.com/alexandre-normand/glukit/app/model"
 	"github.com/alexandre-normand/glukit/app/store"
 	"github.com/alexandre-normand/glukit/app/util"
-	"github.com/alexandre-normand/glukit/lib/github.com/grd/stat"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/log"
 	"sort"
@@ -21,39 +20,82 @@ const (
 	A1C_ESTIMATION_SCORE_PERIOD = 95
 
 	A1C_SCORING_VERSION = 3
+
+	// Name of the formula used to estimate the a1c from the median of reads
+	A1C_MEDIAN_FORMULA = "(median + 77.3) / 35.6"
+	// Name of the formula used to estimate the a1c from the inverted ADAG eAG regression
+	A1C_ADAG_FORMULA = "adag"
+	// Name of the Glucose Management Indicator formula
+	A1C_GMI_FORMULA = "gmi"
 )
 
-// CalculateA1CEstimate calculates an estimate of a a1c given the last 3 months of data. The current algo is naively assuming that the average of the last
-// 3 months will be an approximation of the a1c.
+// CalculateA1CEstimate calculates an estimate of a a1c given the last 3 months of data. The value of the estimate is the one of
+// the primary formula, the first of A1CFormulas, and the values of all A1CFormulas are kept in its Estimates.
 func CalculateA1CEstimate(context context.Context, reads []apimodel.GlucoseRead) (a1c *model.A1CEstimate, err error) {
+	return CalculateA1CEstimateWithFormulas(context, reads, A1CFormulas)
+}
+
+// CalculateA1CEstimateWithFormulas calculates an estimate of a a1c given the last 3 months of data with each of the formulas.
+// The first formula is the primary one that gives its value to the estimate.
+func CalculateA1CEstimateWithFormulas(context context.Context, reads []apimodel.GlucoseRead, formulas []A1CFormula) (a1c *model.A1CEstimate, err error) {
+	if len(reads) > 0 {
+		log.Debugf(context, "Estimating a1c from [%d] reads from [%s] to [%s]", len(reads), reads[0].GetTime(), reads[len(reads)-1].GetTime())
+	}
+
+	if a1c, err = A1CEstimateOfReads(reads, formulas); err != nil {
+		return nil, err
+	}
+
+	a1c.CalculatedOn = time.Now()
+	for _, estimate := range a1c.Estimates {
+		log.Debugf(context, "Estimated a1c with formula [%s] is [%f]", estimate.Formula, estimate.Value)
+	}
+
+	return a1c, nil
+}
+
+// A1CEstimateOfReads estimates the a1c from reads in chronological order covering at least
+// A1C_READ_COVERAGE_REQUIREMENT_IN_DAYS with each of the formulas, the first one giving its value to the estimate.
+// CalculatedOn is left for the caller to set.
+func A1CEstimateOfReads(reads []apimodel.GlucoseRead, formulas []A1CFormula) (a1c *model.A1CEstimate, err error) {
 	if len(reads) == 0 {
 		return nil, errors.New(fmt.Sprintf("Insufficient read coverage to estimate a1c, got no reads"))
 	}
+	if len(formulas) == 0 {
+		return nil, errors.New("No formula to estimate a1c with")
+	}
 	lowerBound := reads[0].GetTime()
 	upperBound := reads[len(reads)-1].GetTime()
 
-	log.Debugf(context, "Estimating a1c from [%d] reads from [%s] to [%s]", len(reads), lowerBound, upperBound)
-
 	coverage := upperBound.Sub(lowerBound)
 	days := coverage / (time.Hour * 24)
-	log.Debugf(context, "Coverage is [%d]", days)
 
 	if days < A1C_READ_COVERAGE_REQUIREMENT_IN_DAYS {
 		return nil, errors.New(fmt.Sprintf("Insufficient read coverage to estimate a1c, got [%d] days but requires [%d]", days, A1C_READ_COVERAGE_REQUIREMENT_IN_DAYS))
-	} else {
-		sortedReads := model.ReadStatsSlice(reads)
-		sort.Sort(sortedReads)
-		median := stat.MedianFromSortedData(sortedReads)
-		//a1c := (average + 77.3) / 35.6
-		a1c := (median + 77.3) / 35.6
-		log.Debugf(context, "Estimated a1c is [%f]", a1c)
-		return &model.A1CEstimate{
-			Value:          a1c,
-			LowerBound:     lowerBound,
-			UpperBound:     upperBound,
-			CalculatedOn:   time.Now(),
-			ScoringVersion: A1C_SCORING_VERSION}, nil
 	}
+
+	mean, err := meanGlucose(reads)
+	if err != nil {
+		return nil, err
+	}
+
+	sortedReads := make(model.ReadStatsSlice, len(reads))
+	copy(sortedReads, reads)
+	sort.Sort(sortedReads)
+
+	estimates := make([]model.FormulaEstimate, len(formulas))
+	for i, formula := range formulas {
+		estimates[i] = model.FormulaEstimate{Formula: formula.Name(), Value: formula.Estimate(mean, sortedReads)}
+	}
+
+	return &model.A1CEstimate{
+		Value:          estimates[0].Value,
+		LowerBound:     lowerBound,
+		UpperBound:     upperBound,
+		ScoringVersion: A1C_SCORING_VERSION,
+		Formula:        estimates[0].Formula,
+		ReadCount:      len(reads),
+		Estimates:      estimates}, nil
 }
 
 func EstimateA1C(context context.Context, glukitUser *model.GlukitUser, endOfPeriod time.Time) (a1c *model.A1CEstimate, err error) {
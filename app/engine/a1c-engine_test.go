@@ -8,7 +8,6 @@ import (
 	"github.com/alexandre-normand/glukit/app/store"
 	"github.com/alexandre-normand/glukit/app/streaming"
 	"github.com/alexandre-normand/glukit/app/util"
-	"github.com/alexandre-normand/glukit/lib/goauth2/oauth"
 	"google.golang.org/appengine/aetest"
 	"google.golang.org/appengine/datastore"
 	"log"
@@ -86,10 +85,11 @@ func setupTestData(t *testing.T, average float32, upperDate time.Time) (c aetest
 		t.Fatal(err)
 	}
 
-	var oauthToken oauth.Token
-	user := model.GlukitUser{TEST_USER, "", "", upperDate,
-		"", "", util.GLUKIT_EPOCH_TIME, apimodel.UNDEFINED_GLUCOSE_READ, oauthToken, oauthToken.RefreshToken,
-		model.UNDEFINED_SCORE, model.UNDEFINED_SCORE, false, "", upperDate, model.UNDEFINED_A1C_ESTIMATE}
+	user := model.GlukitUser{Email: TEST_USER, DateOfBirth: upperDate, LastUpdated: util.GLUKIT_EPOCH_TIME,
+		MostRecentRead: apimodel.UNDEFINED_GLUCOSE_READ, BestScore: model.UNDEFINED_SCORE,
+		MostRecentScore: model.UNDEFINED_SCORE, AccountCreated: upperDate, MostRecentA1C: model.UNDEFINED_A1C_ESTIMATE,
+		TargetLow: apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD, TargetHigh: apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD,
+		GlucoseUnit: apimodel.MG_PER_DL, TherapyMode: model.THERAPY_MODE_UNKNOWN}
 
 	key, err = store.StoreUserProfile(c, upperDate, user)
 	if err != nil {
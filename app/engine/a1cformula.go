@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/lib/github.com/grd/stat"
+)
+
+// A1CFormula estimates an a1c from glucose reads. The reads are given as read statistics, sorted by value.
+type A1CFormula interface {
+	// Name identifies the formula in stored estimates
+	Name() string
+	// Estimate returns the a1c, in percent, for reads of the given mean glucose (in mg/dL)
+	Estimate(meanGlucose float64, reads model.ReadStatsSlice) float64
+}
+
+// MedianA1CFormula is the original glukit estimate, the ADAG regression applied to the median of reads rather than their mean
+type MedianA1CFormula struct{}
+
+func (f MedianA1CFormula) Name() string {
+	return A1C_MEDIAN_FORMULA
+}
+
+func (f MedianA1CFormula) Estimate(meanGlucose float64, reads model.ReadStatsSlice) float64 {
+	median := stat.MedianFromSortedData(reads)
+	return (median + 77.3) / 35.6
+}
+
+// ADAGA1CFormula inverts the estimated average glucose (eAG) regression of the ADAG study: eAG = 28.7 * a1c - 46.7
+type ADAGA1CFormula struct{}
+
+func (f ADAGA1CFormula) Name() string {
+	return A1C_ADAG_FORMULA
+}
+
+func (f ADAGA1CFormula) Estimate(meanGlucose float64, reads model.ReadStatsSlice) float64 {
+	return (meanGlucose + 46.7) / 28.7
+}
+
+// GMIA1CFormula is the Glucose Management Indicator (Bergenstal et al., 2018), meant for CGM data of at least 14 days
+type GMIA1CFormula struct{}
+
+func (f GMIA1CFormula) Name() string {
+	return A1C_GMI_FORMULA
+}
+
+func (f GMIA1CFormula) Estimate(meanGlucose float64, reads model.ReadStatsSlice) float64 {
+	return 3.31 + 0.02392*meanGlucose
+}
+
+// A1CFormulas are the formulas every a1c estimate is calculated with. The first one is the primary formula whose value
+// is the one of the estimate, the others are kept alongside so that a range can be shown.
+var A1CFormulas = []A1CFormula{MedianA1CFormula{}, ADAGA1CFormula{}, GMIA1CFormula{}}
@@ -0,0 +1,41 @@
+package engine_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/engine"
+	"testing"
+)
+
+func TestA1CFormulasFromKnownMeans(t *testing.T) {
+	tests := []struct {
+		formula     A1CFormula
+		mean        float64
+		expectedA1C float64
+	}{
+		{ADAGA1CFormula{}, 97, 5.0},
+		{ADAGA1CFormula{}, 154, 7.0},
+		{ADAGA1CFormula{}, 212, 9.0},
+		{GMIA1CFormula{}, 100, 5.7},
+		{GMIA1CFormula{}, 154, 7.0},
+		{GMIA1CFormula{}, 250, 9.3},
+	}
+
+	for _, test := range tests {
+		if a1c := test.formula.Estimate(test.mean, nil); roundToOneDecimal(a1c) != test.expectedA1C {
+			t.Errorf("Expected a1c of [%f] with formula [%s] for mean [%f] but got [%f]", test.expectedA1C, test.formula.Name(), test.mean, a1c)
+		}
+	}
+}
+
+func TestA1CFormulasHaveDistinctNames(t *testing.T) {
+	names := make(map[string]bool)
+	for _, formula := range A1CFormulas {
+		if names[formula.Name()] {
+			t.Errorf("Formula name [%s] is used more than once", formula.Name())
+		}
+		names[formula.Name()] = true
+	}
+
+	if A1CFormulas[0].Name() != A1C_MEDIAN_FORMULA {
+		t.Errorf("Expected the primary formula to be [%s] but got [%s]", A1C_MEDIAN_FORMULA, A1CFormulas[0].Name())
+	}
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// Default size of the time of day buckets of an AGP
+	DEFAULT_AGP_BUCKET = 30 * time.Minute
+	// Minimum number of reads in a bucket for its percentiles to be meaningful
+	AGP_MIN_BUCKET_SAMPLES = 5
+	// Number of days of reads fetched at a time when calculating an AGP
+	AGP_FETCH_DAYS = 7
+)
+
+// CalculateAGP calculates the Ambulatory Glucose Profile of the last days of reads of a user. Reads are fetched a few
+// days at a time and only their values are kept, by bucket, so that 90 days of reads fit in a single task.
+func CalculateAGP(context context.Context, email string, days int, bucket time.Duration) (agp *model.AGP, err error) {
+	profile, err := newAGPAccumulator(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	upperBound := time.Now().Truncate(time.Second)
+	lowerBound := upperBound.AddDate(0, 0, -days)
+	for chunkStart := lowerBound; chunkStart.Before(upperBound); chunkStart = chunkStart.AddDate(0, 0, AGP_FETCH_DAYS) {
+		chunkEnd := chunkStart.AddDate(0, 0, AGP_FETCH_DAYS)
+		if chunkEnd.After(upperBound) {
+			chunkEnd = upperBound
+		}
+
+		// Bounds are inclusive so stop short of the start of the next chunk
+		reads, err := store.GetGlucoseReads(context, email, chunkStart, chunkEnd.Add(-time.Second))
+		if err != nil {
+			return nil, err
+		}
+		profile.add(reads)
+	}
+
+	log.Infof(context, "Calculated AGP of [%s] from [%s] to [%s]", email, lowerBound, upperBound)
+	return profile.agp(lowerBound, upperBound), nil
+}
+
+// AGPOfReads calculates the Ambulatory Glucose Profile of reads with buckets of the given duration
+func AGPOfReads(reads []apimodel.GlucoseRead, bucket time.Duration) (agp *model.AGP, err error) {
+	profile, err := newAGPAccumulator(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.add(reads)
+	if len(reads) == 0 {
+		return profile.agp(time.Time{}, time.Time{}), nil
+	}
+	return profile.agp(reads[0].GetTime(), reads[len(reads)-1].GetTime()), nil
+}
+
+// agpAccumulator keeps the values of reads by time of day bucket
+type agpAccumulator struct {
+	bucketMinutes int
+	values        [][]float64
+}
+
+func newAGPAccumulator(bucket time.Duration) (*agpAccumulator, error) {
+	bucketMinutes := int(bucket / time.Minute)
+	if bucket%time.Minute != 0 || bucketMinutes <= 0 || (24*60)%bucketMinutes != 0 {
+		return nil, errors.New(fmt.Sprintf("AGP buckets must be a whole number of minutes that divides a day, got [%v]", bucket))
+	}
+
+	return &agpAccumulator{bucketMinutes, make([][]float64, 24*60/bucketMinutes)}, nil
+}
+
+// add adds the values of reads to the bucket of their local time of day
+func (a *agpAccumulator) add(reads []apimodel.GlucoseRead) {
+	for _, read := range reads {
+		value, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			continue
+		}
+
+		readTime := read.GetTime()
+		i := (readTime.Hour()*60 + readTime.Minute()) / a.bucketMinutes
+		a.values[i] = append(a.values[i], float64(value))
+	}
+}
+
+// agp returns the profile of the values added so far
+func (a *agpAccumulator) agp(lowerBound, upperBound time.Time) *model.AGP {
+	agp := &model.AGP{LowerBound: lowerBound, UpperBound: upperBound, BucketMinutes: a.bucketMinutes,
+		Buckets: make([]model.AGPBucket, len(a.values))}
+	for i, values := range a.values {
+		sort.Float64s(values)
+		agp.Buckets[i] = model.AGPBucket{
+			StartMinute:  i * a.bucketMinutes,
+			SampleCount:  len(values),
+			Insufficient: len(values) < AGP_MIN_BUCKET_SAMPLES,
+			P10:          percentileOfSorted(values, 10),
+			P25:          percentileOfSorted(values, 25),
+			Median:       percentileOfSorted(values, 50),
+			P75:          percentileOfSorted(values, 75),
+			P90:          percentileOfSorted(values, 90)}
+	}
+
+	return agp
+}
+
+// percentileOfSorted returns the percentile of sorted values, interpolating between the two closest ranks. Zero is
+// returned if there are no values.
+func percentileOfSorted(values []float64, percentile float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	rank := percentile / 100 * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return values[lower] + (rank-float64(lower))*(values[upper]-values[lower])
+}
@@ -0,0 +1,57 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"testing"
+	"time"
+)
+
+func TestAGPOfReads(t *testing.T) {
+	location, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reads []apimodel.GlucoseRead
+	for day := 0; day < 10; day++ {
+		readTime := time.Date(2014, 4, 18+day, 8, 10, 0, 0, location)
+		reads = append(reads, apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, apimodel.MG_PER_DL, float32(100 + day*10)})
+		if day < 2 {
+			nightTime := time.Date(2014, 4, 18+day, 3, 0, 0, 0, location)
+			reads = append(reads, apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(nightTime), "America/Montreal"}, apimodel.MG_PER_DL, 90})
+		}
+	}
+
+	agp, err := engine.AGPOfReads(reads, engine.DEFAULT_AGP_BUCKET)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(agp.Buckets) != 48 {
+		t.Fatalf("TestAGPOfReads failed: got [%d] buckets but expected [48]", len(agp.Buckets))
+	}
+
+	morning := agp.Buckets[16]
+	if morning.StartMinute != 8*60 || morning.SampleCount != 10 || morning.Insufficient {
+		t.Errorf("TestAGPOfReads failed: expected 10 samples in the bucket of 08:00 but got [%v]", morning)
+	}
+
+	if !isClose(morning.P10, 109) || !isClose(morning.P25, 122.5) || !isClose(morning.Median, 145) || !isClose(morning.P75, 167.5) || !isClose(morning.P90, 181) {
+		t.Errorf("TestAGPOfReads failed: unexpected percentiles [%v]", morning)
+	}
+
+	if night := agp.Buckets[6]; night.SampleCount != 2 || !night.Insufficient || night.Median != 90 {
+		t.Errorf("TestAGPOfReads failed: expected the bucket of 03:00 to be flagged with 2 samples but got [%v]", night)
+	}
+
+	if empty := agp.Buckets[0]; empty.SampleCount != 0 || !empty.Insufficient {
+		t.Errorf("TestAGPOfReads failed: expected the bucket of midnight to be empty but got [%v]", empty)
+	}
+}
+
+func TestAGPWithInvalidBucket(t *testing.T) {
+	if _, err := engine.AGPOfReads(nil, 7*time.Minute); err == nil {
+		t.Errorf("TestAGPWithInvalidBucket failed: expected an error for buckets that don't divide a day")
+	}
+}
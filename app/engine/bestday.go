@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"math"
+	"time"
+)
+
+const (
+	// Minimum share, in percent, of a day covered by reads for it to be the best day or count in a streak
+	MIN_DAY_COVERAGE = 70.
+	// Default minimum time in range, in percent, of a day for it to count in a steady streak
+	DEFAULT_STEADY_DAY_TIME_IN_RANGE = 70.
+	// Most days looked back when counting a steady streak
+	MAX_STREAK_DAYS_TO_LOOK_BACK = 90
+)
+
+// FindBestDay returns the summary of the best complete day, in the user's timezone, of the period or nil if no day had
+// enough coverage
+func FindBestDay(context context.Context, email string, period model.TimeRange) (bestDay *model.DaySummary, err error) {
+	lowerBound, upperBound := period.LowerBound, period.UpperBound
+	days, err := summarizeDays(context, email, lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	bestDay = BestDayOf(days)
+	if bestDay != nil {
+		log.Infof(context, "Best day of user [%s] between [%s] and [%s] is [%s] with score [%f]", email, lowerBound, upperBound, bestDay.Date, bestDay.Score)
+	}
+
+	return bestDay, nil
+}
+
+// CurrentSteadyStreak returns the number of consecutive days, ending with the most recent complete day in the user's
+// timezone, with at least minTimeInRange percent of time in range
+func CurrentSteadyStreak(context context.Context, email string, minTimeInRange float64) (streak int, err error) {
+	now := time.Now()
+	days, err := summarizeDays(context, email, now.AddDate(0, 0, -MAX_STREAK_DAYS_TO_LOOK_BACK), now)
+	if err != nil {
+		return 0, err
+	}
+
+	streak = SteadyStreakOf(days, minTimeInRange)
+	log.Infof(context, "Current steady streak of user [%s] is [%d] days", email, streak)
+	return streak, nil
+}
+
+// BestDayOf returns the day with the highest score among days with at least MIN_DAY_COVERAGE or nil if there isn't any
+func BestDayOf(days []model.DaySummary) (bestDay *model.DaySummary) {
+	for i := range days {
+		if days[i].Coverage < MIN_DAY_COVERAGE {
+			continue
+		}
+
+		if bestDay == nil || days[i].Score > bestDay.Score {
+			bestDay = &days[i]
+		}
+	}
+
+	return bestDay
+}
+
+// SteadyStreakOf returns the number of consecutive days at the end of days, in chronological order, with at least
+// MIN_DAY_COVERAGE and minTimeInRange percent of time in range
+func SteadyStreakOf(days []model.DaySummary, minTimeInRange float64) (streak int) {
+	for i := len(days) - 1; i >= 0; i-- {
+		if days[i].Coverage < MIN_DAY_COVERAGE || days[i].TimeInRange < minTimeInRange {
+			break
+		}
+		streak++
+	}
+
+	return streak
+}
+
+// DaySummaryOf calculates the summary of the day starting at the given local midnight from reads in chronological
+// order. The score is the time in range divided by the GVI so that a steady day ranks above one that bounced around
+// the range.
+func DaySummaryOf(reads []apimodel.GlucoseRead, midnight time.Time) (day model.DaySummary) {
+	end := midnight.AddDate(0, 0, 1)
+	day = model.DaySummary{Date: midnight, CalculatedOn: time.Now()}
+
+	dayReads := readsBetween(reads, midnight, end)
+	day.ReadCount = len(dayReads)
+	if len(dayReads) == 0 {
+		return day
+	}
+
+	timeInRange := TimeInRangeOfReads(dayReads, DEFAULT_TARGET_LOW, DEFAULT_TARGET_HIGH)
+	day.Coverage = math.Min(100, 100*float64(timeInRange.Covered)/float64(end.Sub(midnight)))
+	day.TimeInRange = timeInRange.InRange
+	day.MeanGlucose, _ = meanGlucose(dayReads)
+	day.GVI, _ = variabilityOf(dayReads, DEFAULT_TARGET_LOW, DEFAULT_TARGET_HIGH)
+
+	if day.GVI > 0 {
+		day.Score = day.TimeInRange / day.GVI
+	}
+
+	return day
+}
+
+// summarizeDays returns the summaries of the complete days, in the user's timezone, starting between the lower and
+// upper bounds. Stored summaries are used when available and the reads of the missing days are loaded to calculate
+// theirs otherwise. Only summaries of days with at least MIN_DAY_COVERAGE are stored so that days missing reads are
+// summarized again once their reads are imported.
+func summarizeDays(context context.Context, email string, lowerBound, upperBound time.Time) (days []model.DaySummary, err error) {
+	_, glukitUser, err := store.GetGlukitUser(context, email)
+	if err != nil {
+		return nil, err
+	}
+
+	location := UserLocation(glukitUser)
+	firstDay := midnightOf(lowerBound.In(location))
+	if firstDay.Before(lowerBound) {
+		firstDay = firstDay.AddDate(0, 0, 1)
+	}
+
+	// Only complete days are summarized
+	lastDay := midnightOf(upperBound.In(location)).AddDate(0, 0, -1)
+	if now := time.Now(); upperBound.After(now) {
+		lastDay = midnightOf(now.In(location)).AddDate(0, 0, -1)
+	}
+
+	if lastDay.Before(firstDay) {
+		return []model.DaySummary{}, nil
+	}
+
+	stored, err := store.GetDaySummaries(context, email, firstDay, lastDay)
+	if err != nil {
+		return nil, err
+	}
+
+	storedByDate := make(map[int64]model.DaySummary)
+	for _, day := range stored {
+		storedByDate[day.Date.Unix()] = day
+	}
+
+	missingDays := make([]time.Time, 0)
+	for day := firstDay; !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+		if _, ok := storedByDate[day.Unix()]; !ok {
+			missingDays = append(missingDays, day)
+		}
+	}
+
+	if len(missingDays) > 0 {
+		reads, err := store.GetGlucoseReads(context, email, missingDays[0], missingDays[len(missingDays)-1].AddDate(0, 0, 1))
+		if err != nil {
+			return nil, err
+		}
+
+		calculated := make([]model.DaySummary, 0, len(missingDays))
+		for _, day := range missingDays {
+			summary := DaySummaryOf(reads, day)
+			storedByDate[day.Unix()] = summary
+			if summary.Coverage >= MIN_DAY_COVERAGE {
+				calculated = append(calculated, summary)
+			}
+		}
+
+		if _, err := store.StoreDaySummaries(context, store.GetUserKey(context, email), calculated); err != nil {
+			return nil, err
+		}
+	}
+
+	days = make([]model.DaySummary, 0, len(storedByDate))
+	for day := firstDay; !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+		days = append(days, storedByDate[day.Unix()])
+	}
+
+	log.Infof(context, "Summarized [%d] days of user [%s] with [%d] calculated from reads", len(days), email, len(missingDays))
+	return days, nil
+}
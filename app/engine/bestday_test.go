@@ -0,0 +1,75 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"testing"
+	"time"
+)
+
+// generateDayValues generates count values alternating between low and high
+func generateDayValues(count int, low, high float32) []float32 {
+	values := make([]float32, count)
+	for i := range values {
+		values[i] = low
+		if i%2 == 1 {
+			values[i] = high
+		}
+	}
+
+	return values
+}
+
+func TestDaySummaryOf(t *testing.T) {
+	midnight, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := generateReads(midnight, generateDayValues(288, 100, 100)...)
+
+	day := engine.DaySummaryOf(reads, midnight)
+	if day.ReadCount != 288 || !isClose(day.Coverage, 100) || !isClose(day.TimeInRange, 100) {
+		t.Errorf("TestDaySummaryOf failed: got [%d] reads covering [%f]%% with [%f]%% in range", day.ReadCount, day.Coverage, day.TimeInRange)
+	}
+
+	if !isClose(day.GVI, 1) || !isClose(day.Score, 100) {
+		t.Errorf("TestDaySummaryOf failed: got gvi of [%f] and score of [%f]", day.GVI, day.Score)
+	}
+}
+
+func TestBestDayOfIgnoresPartialDays(t *testing.T) {
+	midnight, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	nextMidnight := midnight.AddDate(0, 0, 1)
+
+	reads := generateReads(midnight, generateDayValues(288, 100, 130)...)
+	// 3 hours of perfectly steady reads
+	reads = append(reads, generateReads(nextMidnight, generateDayValues(36, 100, 100)...)...)
+
+	days := []model.DaySummary{engine.DaySummaryOf(reads, midnight), engine.DaySummaryOf(reads, nextMidnight)}
+	if days[1].Score <= days[0].Score {
+		t.Fatalf("TestBestDayOfIgnoresPartialDays failed: expected the partial day to have the higher score but got [%f] and [%f]", days[0].Score, days[1].Score)
+	}
+
+	bestDay := engine.BestDayOf(days)
+	if bestDay == nil || !bestDay.Date.Equal(midnight) {
+		t.Errorf("TestBestDayOfIgnoresPartialDays failed: expected best day of [%s] but got [%v]", midnight, bestDay)
+	}
+
+	if bestDay := engine.BestDayOf(days[1:]); bestDay != nil {
+		t.Errorf("TestBestDayOfIgnoresPartialDays failed: expected no best day but got [%v]", bestDay)
+	}
+}
+
+func TestSteadyStreakOf(t *testing.T) {
+	days := []model.DaySummary{
+		model.DaySummary{Coverage: 100, TimeInRange: 90},
+		model.DaySummary{Coverage: 20, TimeInRange: 100},
+		model.DaySummary{Coverage: 90, TimeInRange: 75},
+		model.DaySummary{Coverage: 100, TimeInRange: 80},
+		model.DaySummary{Coverage: 95, TimeInRange: 70}}
+
+	if streak := engine.SteadyStreakOf(days, engine.DEFAULT_STEADY_DAY_TIME_IN_RANGE); streak != 3 {
+		t.Errorf("TestSteadyStreakOf failed: got streak of [%d] but expected [%d]", streak, 3)
+	}
+
+	if streak := engine.SteadyStreakOf(days, 75); streak != 0 {
+		t.Errorf("TestSteadyStreakOf failed: got streak of [%d] but expected [%d]", streak, 0)
+	}
+}
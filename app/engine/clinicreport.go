@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"math"
+	"time"
+)
+
+// CalculateClinicReport assembles the clinic report of the last days of the user, today included
+func CalculateClinicReport(context context.Context, glukitUser *model.GlukitUser, days int, now time.Time) (report *model.ClinicReport, err error) {
+	if days <= 0 {
+		return nil, errors.New(fmt.Sprintf("A clinic report must cover at least a day, got [%d]", days))
+	}
+
+	today := midnightOf(now.In(UserLocation(glukitUser)))
+	period := model.TimeRange{today.AddDate(0, 0, 1-days), today.AddDate(0, 0, 1)}
+
+	// Bounds are inclusive so stop short of the start of the next day
+	reads, err := store.GetGlucoseReads(context, glukitUser.Email, period.LowerBound, period.UpperBound.Add(-time.Nanosecond))
+	if err != nil {
+		return nil, err
+	}
+
+	if report, err = ClinicReportOfReads(reads, period, glukitUser.TargetLow, glukitUser.TargetHigh); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Assembled clinic report of [%s] from [%s] to [%s] with [%d] reads", glukitUser.Email, period.LowerBound,
+		period.UpperBound, len(reads))
+	return report, nil
+}
+
+// ClinicReportOfReads assembles the clinic report of reads in chronological order over the period, which must start at
+// a midnight in the timezone the days of the report are in
+func ClinicReportOfReads(reads []apimodel.GlucoseRead, period model.TimeRange, targetLow, targetHigh float32) (report *model.ClinicReport, err error) {
+	report = &model.ClinicReport{Period: period, Days: make([]model.DaySummary, 0)}
+
+	timeInRange := TimeInRangeOfReads(reads, targetLow, targetHigh)
+	timeInRange.LowerBound, timeInRange.UpperBound = period.LowerBound, period.UpperBound
+	report.Summary.TimeInRange = *timeInRange
+	report.Summary.ReadCount = len(reads)
+	report.Summary.Coverage = math.Min(100, 100*float64(timeInRange.Covered)/float64(period.Duration()))
+	report.Summary.HypoCount = len(DetectHypoEvents(reads, DEFAULT_HYPO_THRESHOLD, DEFAULT_HYPO_MIN_DURATION))
+
+	if len(reads) > 0 {
+		if report.Summary.MeanGlucose, err = meanGlucose(reads); err != nil {
+			return nil, err
+		}
+		report.Summary.GMI = GMIA1CFormula{}.Estimate(report.Summary.MeanGlucose, nil)
+	}
+
+	if report.AGP, err = AGPOfReads(reads, DEFAULT_AGP_BUCKET); err != nil {
+		return nil, err
+	}
+
+	for day := period.LowerBound; day.Before(period.UpperBound); day = day.AddDate(0, 0, 1) {
+		report.Days = append(report.Days, DaySummaryOf(reads, day))
+	}
+
+	return report, nil
+}
@@ -0,0 +1,83 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"testing"
+	"time"
+)
+
+func TestClinicReportOfReads(t *testing.T) {
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2014, 4, 18, 0, 0, 0, 0, location)
+	period := model.TimeRange{start, start.AddDate(0, 0, 14)}
+
+	// Three days of reads every 5 minutes at 120 with a 30 minutes dip at 60 on the second day
+	values := make([]float32, 3*288)
+	total := float64(0)
+	for i := range values {
+		values[i] = 120
+		if i >= 288+100 && i < 288+106 {
+			values[i] = 60
+		}
+		total += float64(values[i])
+	}
+	reads := generateReads(start, values...)
+
+	report, err := engine.ClinicReportOfReads(reads, period, engine.DEFAULT_TARGET_LOW, engine.DEFAULT_TARGET_HIGH)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary := report.Summary
+	mean := total / float64(len(values))
+	if summary.ReadCount != len(values) || !isClose(summary.MeanGlucose, mean) {
+		t.Errorf("TestClinicReportOfReads failed: expected [%d] reads with a mean of [%f] but got [%v]", len(values), mean, summary)
+	}
+
+	if !isClose(summary.GMI, 3.31+0.02392*mean) {
+		t.Errorf("TestClinicReportOfReads failed: expected a GMI of [%f] but got [%f]", 3.31+0.02392*mean, summary.GMI)
+	}
+
+	if summary.TimeInRange.Below <= 0 || summary.TimeInRange.InRange <= 0 || summary.TimeInRange.LowerBound != period.LowerBound {
+		t.Errorf("TestClinicReportOfReads failed: unexpected time in range [%v]", summary.TimeInRange)
+	}
+
+	if summary.HypoCount != 1 {
+		t.Errorf("TestClinicReportOfReads failed: expected [1] hypo but got [%d]", summary.HypoCount)
+	}
+
+	if summary.Coverage <= 0 || summary.Coverage > 25 {
+		t.Errorf("TestClinicReportOfReads failed: expected a coverage of 3 days out of 14 but got [%f]", summary.Coverage)
+	}
+
+	if report.AGP == nil || len(report.AGP.Buckets) != 48 {
+		t.Fatalf("TestClinicReportOfReads failed: expected an AGP of [48] buckets but got [%v]", report.AGP)
+	}
+
+	if len(report.Days) != 14 {
+		t.Fatalf("TestClinicReportOfReads failed: expected [14] days but got [%d]", len(report.Days))
+	}
+
+	if !report.Days[0].Date.Equal(start) || report.Days[1].ReadCount != 288 || report.Days[5].ReadCount != 0 {
+		t.Errorf("TestClinicReportOfReads failed: unexpected days [%v]", report.Days)
+	}
+}
+
+func TestClinicReportOfNoReads(t *testing.T) {
+	start := time.Date(2014, 4, 18, 0, 0, 0, 0, time.UTC)
+	report, err := engine.ClinicReportOfReads([]apimodel.GlucoseRead{}, model.TimeRange{start, start.AddDate(0, 0, 14)},
+		engine.DEFAULT_TARGET_LOW, engine.DEFAULT_TARGET_HIGH)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Summary.ReadCount != 0 || report.Summary.GMI != 0 || len(report.Days) != 14 {
+		t.Errorf("TestClinicReportOfNoReads failed: unexpected report [%v]", report)
+	}
+}
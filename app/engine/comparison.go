@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"math"
+	"time"
+)
+
+// Minimum share, in percent, of the reads expected over a period for its summary not to be marked as low confidence
+var MinPeriodCoverage = 70.
+
+// ComparePeriods summarizes two periods of the user and compares period B with period A
+func ComparePeriods(context context.Context, email string, periodA, periodB model.TimeRange) (comparison *model.PeriodComparison, err error) {
+	_, glukitUser, err := store.GetGlukitUser(context, email)
+	if err != nil {
+		return nil, err
+	}
+
+	summaryA, err := SummarizePeriod(context, glukitUser, periodA)
+	if err != nil {
+		return nil, err
+	}
+
+	summaryB, err := SummarizePeriod(context, glukitUser, periodB)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas := model.PeriodDeltas{
+		MeanGlucose: summaryB.MeanGlucose - summaryA.MeanGlucose,
+		TimeInRange: summaryB.TimeInRange - summaryA.TimeInRange,
+		GVI:         summaryB.GVI - summaryA.GVI,
+		Coverage:    summaryB.Coverage - summaryA.Coverage}
+	if summaryA.Score != nil && summaryB.Score != nil {
+		scoreDelta := *summaryB.Score - *summaryA.Score
+		deltas.Score = &scoreDelta
+	}
+
+	return &model.PeriodComparison{*summaryA, *summaryB, deltas, apimodel.MG_PER_DL}, nil
+}
+
+// SummarizePeriod calculates the aggregates of a period. Stored stats of the days of the period and stored scores are used
+// when available, the reads of the period being loaded to calculate whatever is missing otherwise. Days of stats are
+// taken whole so a period should start at the beginning of a day for its stats to be exact.
+func SummarizePeriod(context context.Context, glukitUser *model.GlukitUser, period model.TimeRange) (summary *model.PeriodSummary, err error) {
+	summary = &model.PeriodSummary{Period: period}
+
+	daysOfStats, err := store.GetDaysOfStats(context, glukitUser.Email, period.LowerBound, period.UpperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	var reads []apimodel.GlucoseRead
+	if len(daysOfStats) > 0 && haveThresholds(daysOfStats, glukitUser.TargetLow, glukitUser.TargetHigh) {
+		summarizeDaysOfStats(summary, daysOfStats)
+		summary.FromHistory = true
+	} else {
+		if reads, err = store.GetGlucoseReads(context, glukitUser.Email, period.LowerBound, period.UpperBound); err != nil {
+			return nil, err
+		}
+		summarizeReads(summary, reads, glukitUser.TargetLow, glukitUser.TargetHigh)
+	}
+
+	scores, err := store.GetGlukitScoreHistory(context, glukitUser.Email, period.LowerBound, period.UpperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	score := model.UNDEFINED_SCORE
+	if len(scores) > 0 {
+		score = scores[len(scores)-1]
+	} else if calculated, err := CalculateGlukitScore(context, glukitUser, period.UpperBound); err != nil {
+		return nil, err
+	} else {
+		score = *calculated
+	}
+	summary.Score = CalculateUserFacingScore(score)
+
+	// Scores stored before variability was calculated don't have it
+	if score.GVI != 0 {
+		summary.GVI = score.GVI
+	} else {
+		if reads == nil {
+			if reads, err = store.GetGlucoseReads(context, glukitUser.Email, period.LowerBound, period.UpperBound); err != nil {
+				return nil, err
+			}
+		}
+		summary.GVI, _ = variabilityOf(reads, glukitUser.TargetLow, glukitUser.TargetHigh)
+	}
+
+	expectedReads := float64(period.Duration()) / float64(apimodel.DEFAULT_READ_INTERVAL)
+	if expectedReads > 0 {
+		summary.Coverage = math.Min(100, 100*float64(summary.ReadCount)/expectedReads)
+	}
+	summary.LowConfidence = summary.Coverage < MinPeriodCoverage
+
+	log.Infof(context, "Summarized period [%v] of user [%s]: [%v]", period, glukitUser.Email, summary)
+	return summary, nil
+}
+
+// summarizeDaysOfStats sets the mean glucose, time in range and read count of the summary from stats. Stats don't keep
+// the time covered by reads so each read is taken to stand for apimodel.DEFAULT_READ_INTERVAL.
+func summarizeDaysOfStats(summary *model.PeriodSummary, daysOfStats []apimodel.DayOfStats) {
+	sum := 0.
+	var outOfRange time.Duration
+	for _, stats := range daysOfStats {
+		summary.ReadCount += stats.Count
+		sum += stats.Mean * float64(stats.Count)
+		outOfRange += stats.TimeBelow + stats.TimeAbove
+	}
+
+	if summary.ReadCount > 0 {
+		summary.MeanGlucose = sum / float64(summary.ReadCount)
+		covered := time.Duration(summary.ReadCount) * apimodel.DEFAULT_READ_INTERVAL
+		summary.TimeInRange = math.Max(0, 100*(1-float64(outOfRange)/float64(covered)))
+	}
+}
+
+// haveThresholds returns true if all stats were computed with the given thresholds. Stats computed before the user
+// changed their target range are stale until refreshed by a recalculation.
+func haveThresholds(daysOfStats []apimodel.DayOfStats, lowThreshold, highThreshold float32) bool {
+	for _, stats := range daysOfStats {
+		if stats.LowThreshold != lowThreshold || stats.HighThreshold != highThreshold {
+			return false
+		}
+	}
+
+	return true
+}
+
+// summarizeReads sets the mean glucose, time in range and read count of the summary from reads
+func summarizeReads(summary *model.PeriodSummary, reads []apimodel.GlucoseRead, targetLow, targetHigh float32) {
+	summary.ReadCount = len(reads)
+	if len(reads) == 0 {
+		return
+	}
+
+	summary.MeanGlucose, _ = meanGlucose(reads)
+	summary.TimeInRange = TimeInRangeOfReads(reads, targetLow, targetHigh).InRange
+}
@@ -0,0 +1,36 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/util"
+	"testing"
+	"time"
+)
+
+func TestComparePeriodsMarksPeriodsWithLowCoverage(t *testing.T) {
+	upperDate, _ := time.Parse(util.TIMEFORMAT_NO_TZ, "2014-04-18 00:00:00")
+
+	c, _, _ := setupTestData(t, 79, upperDate)
+	defer c.Close()
+
+	periodA := model.TimeRange{upperDate.AddDate(0, 0, -1), upperDate.Add(-time.Nanosecond)}
+	periodB := model.TimeRange{upperDate.Add(time.Hour), upperDate.AddDate(0, 0, 1)}
+	comparison, err := engine.ComparePeriods(c, TEST_USER, periodA, periodB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if comparison.A.LowConfidence || comparison.A.Coverage < engine.MinPeriodCoverage || roundToOneDecimal(comparison.A.MeanGlucose) != 79 {
+		t.Errorf("Expected period A to be fully covered with a mean of [79] but got [%v]", comparison.A)
+	}
+
+	if !comparison.B.LowConfidence || comparison.B.ReadCount != 0 {
+		t.Errorf("Expected period B without reads to be low confidence but got [%v]", comparison.B)
+	}
+
+	if comparison.Deltas.MeanGlucose != comparison.B.MeanGlucose-comparison.A.MeanGlucose ||
+		comparison.Deltas.Coverage != comparison.B.Coverage-comparison.A.Coverage {
+		t.Errorf("Expected deltas to be the changes from A to B but got [%v]", comparison.Deltas)
+	}
+}
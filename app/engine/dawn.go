@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+const (
+	// Mornings analyzed for the dawn phenomenon, as offsets from local midnight. Carbs or insulin logged in that
+	// window exclude the morning.
+	DAWN_WINDOW_START = 3 * time.Hour
+	DAWN_WINDOW_END   = 8 * time.Hour
+	// Part of the morning the rise is fitted on
+	DAWN_RISE_START = 4 * time.Hour
+	DAWN_RISE_END   = 7 * time.Hour
+	// Minimum rise, in mg/dL, over the fitted part of a morning for it to count as a dawn rise
+	DAWN_RISE_THRESHOLD = 20.
+	// Minimum share, in percent, of analyzed mornings with a rise for it to be consistent
+	DAWN_CONSISTENCY_THRESHOLD = 50.
+	// Minimum number of analyzed mornings for a rise to be consistent
+	DAWN_MIN_MORNINGS = 7
+	// Minimum share, in percent, of the fitted part of a morning covered by reads for it to be analyzed
+	DAWN_MIN_COVERAGE = 50.
+	// Default number of days analyzed
+	DEFAULT_DAWN_ANALYSIS_DAYS = 30
+)
+
+// dawnMorning is the rise of a single morning
+type dawnMorning struct {
+	rise  float64
+	onset time.Duration
+}
+
+// AnalyzeDawnPhenomenon looks for a dawn rise over the mornings of the last days of the user, in the user's timezone,
+// and stores the analysis so that it can be retrieved with store.GetMostRecentDawnAnalysis
+func AnalyzeDawnPhenomenon(context context.Context, email string, days int) (analysis *model.DawnAnalysis, err error) {
+	_, glukitUser, err := store.GetGlukitUser(context, email)
+	if err != nil {
+		return nil, err
+	}
+
+	location := UserLocation(glukitUser)
+	upperBound := midnightOf(time.Now().In(location))
+	lowerBound := upperBound.AddDate(0, 0, -days)
+
+	reads, err := store.GetGlucoseReads(context, email, lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	meals, err := store.GetMeals(context, email, lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	injections, err := store.GetInjections(context, email, lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis = DawnAnalysisOf(reads, meals, injections, lowerBound, upperBound)
+	analysis.CalculatedOn = time.Now()
+
+	if _, err := store.StoreDawnAnalysis(context, store.GetUserKey(context, email), *analysis); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Dawn analysis of user [%s] from [%s] to [%s]: [%s]", email, lowerBound, upperBound, analysis.Insight())
+	return analysis, nil
+}
+
+// DawnAnalysisOf looks for a dawn rise over the mornings of the days between the local midnights lowerBound and
+// upperBound. All elements are in chronological order.
+func DawnAnalysisOf(reads []apimodel.GlucoseRead, meals []apimodel.Meal, injections []apimodel.Injection, lowerBound, upperBound time.Time) (analysis *model.DawnAnalysis) {
+	analysis = &model.DawnAnalysis{LowerBound: lowerBound, UpperBound: upperBound}
+
+	var riseSum float64
+	var onsetSum time.Duration
+	for day := lowerBound; day.Before(upperBound); day = day.AddDate(0, 0, 1) {
+		if isMorningConfounded(meals, injections, day) {
+			analysis.Excluded++
+			continue
+		}
+
+		morning, ok := dawnMorningOf(reads, day)
+		if !ok {
+			analysis.Excluded++
+			continue
+		}
+
+		analysis.Analyzed++
+		if morning.rise >= DAWN_RISE_THRESHOLD {
+			analysis.RiseCount++
+			riseSum += morning.rise
+			onsetSum += morning.onset
+		}
+	}
+
+	if analysis.Analyzed > 0 {
+		analysis.Frequency = 100 * float64(analysis.RiseCount) / float64(analysis.Analyzed)
+	}
+
+	if analysis.RiseCount > 0 {
+		analysis.Magnitude = riseSum / float64(analysis.RiseCount)
+		analysis.Onset = (onsetSum / time.Duration(analysis.RiseCount)).Truncate(time.Minute)
+	}
+
+	analysis.Consistent = analysis.Analyzed >= DAWN_MIN_MORNINGS && analysis.Frequency >= DAWN_CONSISTENCY_THRESHOLD
+	return analysis
+}
+
+// dawnMorningOf returns the rise of the morning of the day of the given local midnight. The rise is the one of the
+// least squares line of the reads between DAWN_RISE_START and DAWN_RISE_END and its onset is the time of the lowest
+// read between DAWN_WINDOW_START and DAWN_RISE_END, the latest one if it was reached more than once. Mornings with too few
+// reads aren't analyzed.
+func dawnMorningOf(reads []apimodel.GlucoseRead, midnight time.Time) (morning dawnMorning, ok bool) {
+	riseReads := readsBetween(reads, midnight.Add(DAWN_RISE_START), midnight.Add(DAWN_RISE_END))
+	timeInRange := TimeInRangeOfReads(riseReads, DEFAULT_TARGET_LOW, DEFAULT_TARGET_HIGH)
+	if 100*float64(timeInRange.Covered)/float64(DAWN_RISE_END-DAWN_RISE_START) < DAWN_MIN_COVERAGE {
+		return morning, false
+	}
+
+	slope, ok := slopeOf(riseReads)
+	if !ok {
+		return morning, false
+	}
+	morning.rise = slope * (DAWN_RISE_END - DAWN_RISE_START).Minutes()
+
+	earlyReads := readsBetween(reads, midnight.Add(DAWN_WINDOW_START), midnight.Add(DAWN_RISE_END))
+	lowest := earlyReads[0]
+	for _, read := range earlyReads {
+		if read.GetMgPerDlValue() <= lowest.GetMgPerDlValue() {
+			lowest = read
+		}
+	}
+	morning.onset = lowest.GetTime().Sub(midnight)
+
+	return morning, true
+}
+
+// isMorningConfounded returns true if carbs or insulin were logged on the morning of the day of the given local midnight
+func isMorningConfounded(meals []apimodel.Meal, injections []apimodel.Injection, midnight time.Time) bool {
+	start, end := midnight.Add(DAWN_WINDOW_START), midnight.Add(DAWN_WINDOW_END)
+	inWindow := func(t time.Time) bool {
+		return !t.Before(start) && t.Before(end)
+	}
+
+	for _, meal := range meals {
+		if meal.Carbs > 0 && inWindow(meal.GetTime()) {
+			return true
+		}
+	}
+
+	for _, injection := range injections {
+		if injection.Units > 0 && inWindow(injection.GetTime()) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,73 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"testing"
+	"time"
+)
+
+// generateMorningReads generates reads from 03:00 to 08:00 flat at 100 mg/dL until 04:00 and then rising by rise
+// mg/dL every 5 minutes until 07:00
+func generateMorningReads(midnight time.Time, rise float32) []apimodel.GlucoseRead {
+	values := make([]float32, 60)
+	for i := range values {
+		switch {
+		case i <= 12:
+			values[i] = 100
+		case i <= 48:
+			values[i] = 100 + rise*float32(i-12)
+		default:
+			values[i] = values[48]
+		}
+	}
+
+	return generateReads(midnight.Add(3*time.Hour), values...)
+}
+
+func TestDawnAnalysisOf(t *testing.T) {
+	lowerBound, _ := time.Parse("02/01/2006 15:04", "01/04/2014 00:00")
+
+	reads := make([]apimodel.GlucoseRead, 0)
+	for i := 0; i < 10; i++ {
+		day := lowerBound.AddDate(0, 0, i)
+		switch i {
+		case 0, 1:
+			reads = append(reads, generateMorningReads(day, 0)...)
+		case 2:
+			// No reads, the morning is excluded
+		default:
+			reads = append(reads, generateMorningReads(day, 2)...)
+		}
+	}
+
+	// Carbs on the last morning exclude it
+	meals := []apimodel.Meal{generateMeal(lowerBound.AddDate(0, 0, 9).Add(5*time.Hour), 15)}
+
+	analysis := engine.DawnAnalysisOf(reads, meals, nil, lowerBound, lowerBound.AddDate(0, 0, 10))
+	if analysis.Analyzed != 8 || analysis.Excluded != 2 || analysis.RiseCount != 6 {
+		t.Fatalf("TestDawnAnalysisOf failed: got [%d] analyzed, [%d] excluded and [%d] rises", analysis.Analyzed, analysis.Excluded, analysis.RiseCount)
+	}
+
+	if !isClose(analysis.Frequency, 75) || !isClose(analysis.Magnitude, 72) || analysis.Onset != 4*time.Hour {
+		t.Errorf("TestDawnAnalysisOf failed: got rise of [%f] on [%f]%% of mornings with onset at [%v]", analysis.Magnitude, analysis.Frequency, analysis.Onset)
+	}
+
+	if !analysis.Consistent {
+		t.Errorf("TestDawnAnalysisOf failed: expected a consistent dawn rise")
+	}
+
+	if insight := analysis.Insight(); insight != "You rise ~72 mg/dL on 75% of mornings starting around 4:00am" {
+		t.Errorf("TestDawnAnalysisOf failed: got insight [%s]", insight)
+	}
+}
+
+func TestDawnAnalysisOfTooFewMornings(t *testing.T) {
+	lowerBound, _ := time.Parse("02/01/2006 15:04", "01/04/2014 00:00")
+	reads := generateMorningReads(lowerBound, 2)
+
+	analysis := engine.DawnAnalysisOf(reads, nil, nil, lowerBound, lowerBound.AddDate(0, 0, 1))
+	if analysis.RiseCount != 1 || analysis.Consistent {
+		t.Errorf("TestDawnAnalysisOfTooFewMornings failed: expected an inconsistent rise but got [%v]", analysis)
+	}
+}
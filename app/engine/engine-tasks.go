@@ -12,38 +12,69 @@ import (
 )
 
 var RunGlukitScoreCalculationChunk = delay.Func(GLUKIT_SCORE_BATCH_CALCULATION_FUNCTION_NAME, func(context context.Context, userEmail string,
-	lowerBound time.Time) {
+	lowerBound time.Time) error {
 	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
 		"shows up because the function calls itself. This implementation defines the same signature as the "+
 		"real one which we define in init() to override this implementation!")
+	return nil
 })
 
 var RunA1CCalculationChunk = delay.Func(A1C_BATCH_CALCULATION_FUNCTION_NAME, func(context context.Context, userEmail string,
-	lowerBound time.Time) {
+	lowerBound time.Time) error {
 	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
 		"shows up because the function calls itself. This implementation defines the same signature as the "+
 		"real one which we define in init() to override this implementation!")
+	return nil
 })
 
+var RunHypoDetectionChunk = delay.Func(HYPO_DETECTION_FUNCTION_NAME, func(context context.Context, userEmail string,
+	lowerBound time.Time) error {
+	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
+		"shows up because the function calls itself. This implementation defines the same signature as the "+
+		"real one which we define in init() to override this implementation!")
+	return nil
+})
+
+var RunWeeklySummaryCalculation = delay.Func(WEEKLY_SUMMARY_FUNCTION_NAME, CalculateWeeklySummary)
+
 const (
 	PERIODS_PER_BATCH                            = 6
 	BATCH_CALCULATION_QUEUE_NAME                 = "batch-calculation"
 	GLUKIT_SCORE_BATCH_CALCULATION_FUNCTION_NAME = "runGlukitScoreCalculationChunk"
 	A1C_BATCH_CALCULATION_FUNCTION_NAME          = "runA1CCalculationChunk"
+	HYPO_DETECTION_FUNCTION_NAME                 = "runHypoDetectionChunk"
+	WEEKLY_SUMMARY_FUNCTION_NAME                 = "runWeeklySummaryCalculation"
+
+	// How long a chunk of glukit score calculation runs before queuing up the next chunk, well within the 10 minutes
+	// deadline of tasks
+	GLUKIT_SCORE_BATCH_DEADLINE = 8 * time.Minute
 )
 
-func RunGlukitScoreBatchCalculation(context context.Context, userEmail string, lowerBound time.Time) {
+// RunGlukitScoreBatchCalculation calculates the GlukitScores of the periods ending after lowerBound, one day at a time. Scores
+// are stored every GLUKIT_SCORE_PERIOD days along with a checkpoint so that a retry of the task resumes after the last
+// scores stored. The next chunk of calculation is queued up once PERIODS_PER_BATCH periods are scored or when getting
+// close to the task deadline. Store failures are returned for the task queue to retry the chunk from its checkpoint.
+func RunGlukitScoreBatchCalculation(context context.Context, userEmail string, lowerBound time.Time) error {
+	startedAt := time.Now()
 	glukitUser, _, _, err := store.GetUserData(context, userEmail)
 	if _, ok := err.(store.StoreError); err != nil && !ok {
 		log.Errorf(context, "We're trying to run a batch glukit score calculation for user [%s] that doesn't exist. "+
 			"Got error: %v", userEmail, err)
-		return
+		return nil
+	}
+
+	// The lower bound stays the identity of the task, periods are scored from the checkpoint when it's a retry
+	scoredUpTo := lowerBound
+	if checkpoint, err := store.GetScoreCheckpoint(context, userEmail); err != nil {
+		log.Warningf(context, "Error getting score checkpoint for user [%s], starting from [%s]: %v", userEmail, lowerBound, err)
+	} else if scoredUpTo = checkpoint.ResumePoint(lowerBound); scoredUpTo.After(lowerBound) {
+		log.Infof(context, "Resuming glukit score calculation for user [%s] from checkpoint [%s]", userEmail, scoredUpTo.Format(util.TIMEFORMAT))
 	}
+	checkpointed := scoredUpTo
 
 	bestScore := glukitUser.BestScore
 	mostRecentScore := glukitUser.MostRecentScore
 	glukitScoreBatch := make([]model.GlukitScore, 0)
-	var periodUpperBound time.Time
 
 	log.Debugf(context, "Calculating batch of GlukitScores for user [%s] with current best of [%v] and most recent score of [%v]",
 		userEmail, glukitUser.BestScore, mostRecentScore)
@@ -52,13 +83,14 @@ func RunGlukitScoreBatchCalculation(context context.Context, userEmail string, l
 	// Calculate the GlukitScore for every period until now by increment of 1 day. This is a moving score over the last GLUKIT_SCORE_PERIOD that gets a new value every day.
 	// This will likely go through a few calculations for which we don't have data yet but this seems like the fair
 	// price to pay for making sure we don't stop processing glukit scores because someone might have stopped using their CGM for a week or so.
-	for periodUpperBound = lowerBound.AddDate(0, 0, 1); periodUpperBound.Before(time.Now()) && periodUpperBound.Before(upperBound); periodUpperBound = periodUpperBound.AddDate(0, 0, 1) {
+	periodUpperBound := scoredUpTo.AddDate(0, 0, 1)
+	for periodCount := 1; periodUpperBound.Before(time.Now()) && !periodUpperBound.After(upperBound); periodCount++ {
 		glukitScore, err := CalculateGlukitScore(context, glukitUser, periodUpperBound)
 		if err != nil {
-			util.Propagate(err)
+			return err
 		}
 
-		if glukitScore.IsBetterThan(glukitUser.BestScore) {
+		if glukitScore.IsBetterThan(bestScore) {
 			bestScore = *glukitScore
 		}
 
@@ -69,47 +101,89 @@ func RunGlukitScoreBatchCalculation(context context.Context, userEmail string, l
 
 			glukitScoreBatch = append(glukitScoreBatch, *glukitScore)
 		}
-	}
 
-	// Store the batch
-	store.StoreGlukitScoreBatch(context, userEmail, glukitScoreBatch)
+		scoredUpTo = periodUpperBound
+		periodUpperBound = periodUpperBound.AddDate(0, 0, 1)
 
-	// Update the bestScore/LastScoredRead if one of them is different than what was already there
-	if bestScore != glukitUser.BestScore || mostRecentScore != glukitUser.MostRecentScore {
-		glukitUser.BestScore = bestScore
-		glukitUser.MostRecentScore = mostRecentScore
-		if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
-			util.Propagate(err)
-		} else {
-			log.Debugf(context, "Updated glukit user [%s] with an improved GlukitScore of [%v] and most recent score of [%v]",
-				glukitUser.Email, bestScore, mostRecentScore)
+		if time.Since(startedAt) > GLUKIT_SCORE_BATCH_DEADLINE {
+			log.Infof(context, "Getting close to the deadline after scoring up to [%s] for user [%s]", scoredUpTo.Format(util.TIMEFORMAT), userEmail)
+			break
+		}
+
+		if periodCount%GLUKIT_SCORE_PERIOD == 0 {
+			if err := storeGlukitScoreProgress(context, glukitUser, glukitScoreBatch, bestScore, mostRecentScore, lowerBound, scoredUpTo); err != nil {
+				return err
+			}
+			glukitScoreBatch = glukitScoreBatch[:0]
+			checkpointed = scoredUpTo
+		}
+	}
+
+	if !scoredUpTo.Equal(checkpointed) {
+		if err := storeGlukitScoreProgress(context, glukitUser, glukitScoreBatch, bestScore, mostRecentScore, lowerBound, scoredUpTo); err != nil {
+			return err
 		}
 	}
 
 	// Kick off the next chunk of glukit score calculation
-	if !periodUpperBound.Before(upperBound) {
-		task, err := RunGlukitScoreCalculationChunk.Task(userEmail, periodUpperBound)
+	if periodUpperBound.Before(time.Now()) {
+		task, err := RunGlukitScoreCalculationChunk.Task(userEmail, scoredUpTo)
 		if err != nil {
 			log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
 				"This breaks batch calculation of glukit scores for that user!: %v", GLUKIT_SCORE_BATCH_CALCULATION_FUNCTION_NAME, userEmail, err)
 		}
 		taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME)
 
-		log.Infof(context, "Queued up next chunk of glukit score calculation for user [%s] and lowerBound [%s]", userEmail, periodUpperBound.Format(util.TIMEFORMAT))
+		log.Infof(context, "Queued up next chunk of glukit score calculation for user [%s] and lowerBound [%s]", userEmail, scoredUpTo.Format(util.TIMEFORMAT))
+		reportRecalculationProgress(context, userEmail, scoredUpTo, false)
 	} else {
 		log.Infof(context, "Done with glukit score calculation for user [%s]", userEmail)
+		reportRecalculationProgress(context, userEmail, scoredUpTo, true)
+		finishOnboardingScoring(context, userEmail)
+	}
+
+	return nil
+}
+
+// storeGlukitScoreProgress stores a batch of scores, the best and most recent scores of the user if they changed and then
+// the checkpoint of the task. The checkpoint is stored last so that a retry after a failure in between scores the
+// periods again rather than leaving them out.
+func storeGlukitScoreProgress(context context.Context, glukitUser *model.GlukitUser, glukitScoreBatch []model.GlukitScore,
+	bestScore, mostRecentScore model.GlukitScore, taskLowerBound, scoredUpTo time.Time) error {
+	if err := store.StoreGlukitScoreBatch(context, glukitUser.Email, glukitScoreBatch); err != nil {
+		log.Errorf(context, "Error storing batch of [%d] glukit scores for user [%s]: %v", len(glukitScoreBatch), glukitUser.Email, err)
+		return err
+	}
+
+	// Update the bestScore/LastScoredRead if one of them is different than what was already there
+	if bestScore != glukitUser.BestScore || mostRecentScore != glukitUser.MostRecentScore {
+		glukitUser.BestScore = bestScore
+		glukitUser.MostRecentScore = mostRecentScore
+		if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+			return err
+		} else {
+			log.Debugf(context, "Updated glukit user [%s] with an improved GlukitScore of [%v] and most recent score of [%v]",
+				glukitUser.Email, bestScore, mostRecentScore)
+		}
+	}
+
+	if _, err := store.StoreScoreCheckpoint(context, glukitUser.Email, model.ScoreCheckpoint{taskLowerBound, scoredUpTo, time.Now()}); err != nil {
+		log.Warningf(context, "Error storing score checkpoint at [%s] for user [%s]: %v", scoredUpTo, glukitUser.Email, err)
 	}
+
+	return nil
 }
 
-func RunA1CBatchCalculation(context context.Context, userEmail string, lowerBound time.Time) {
+func RunA1CBatchCalculation(context context.Context, userEmail string, lowerBound time.Time) error {
 	glukitUser, _, _, err := store.GetUserData(context, userEmail)
 	if _, ok := err.(store.StoreError); err != nil && !ok {
 		log.Errorf(context, "We're trying to run a batch of a1c estimates for user [%s] that doesn't exist. "+
 			"Got error: %v", userEmail, err)
-		return
+		return nil
 	}
 
 	mostRecentA1C := glukitUser.MostRecentA1C
+	mostRecentA1CUpdated := false
 	a1cBatch := make([]model.A1CEstimate, 0)
 	var periodUpperBound time.Time
 
@@ -127,6 +201,7 @@ func RunA1CBatchCalculation(context context.Context, userEmail string, lowerBoun
 			a1cBatch = append(a1cBatch, *a1cEstimate)
 			if periodUpperBound.After(mostRecentA1C.UpperBound) {
 				mostRecentA1C = *a1cEstimate
+				mostRecentA1CUpdated = true
 			}
 		}
 	}
@@ -134,11 +209,11 @@ func RunA1CBatchCalculation(context context.Context, userEmail string, lowerBoun
 	// Store the batch
 	store.StoreA1CBatch(context, userEmail, a1cBatch)
 
-	if mostRecentA1C != glukitUser.MostRecentA1C {
+	if mostRecentA1CUpdated {
 		glukitUser.MostRecentA1C = mostRecentA1C
 
 		if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
-			util.Propagate(err)
+			return err
 		} else {
 			log.Debugf(context, "Updated glukit user [%s] with a most recent a1c [%v]",
 				glukitUser.Email, mostRecentA1C)
@@ -157,5 +232,57 @@ func RunA1CBatchCalculation(context context.Context, userEmail string, lowerBoun
 		log.Infof(context, "Queued up next chunk of a1c calculation for user [%s] and lowerBound [%s]", userEmail, periodUpperBound.Format(util.TIMEFORMAT))
 	} else {
 		log.Infof(context, "Done with a1c estimation for user [%s]", userEmail)
+
+		if mostRecentA1C.Value != model.UNDEFINED_A1C_VALUE {
+			if _, err := store.StoreA1CEstimate(context, userEmail, mostRecentA1C); err != nil {
+				log.Errorf(context, "Error appending most recent a1c estimate [%v] to history of user [%s]: %v", mostRecentA1C, userEmail, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func RunHypoDetectionBatch(context context.Context, userEmail string, lowerBound time.Time) error {
+	upperBound := lowerBound.AddDate(0, 0, HYPO_DETECTION_DAYS_PER_BATCH)
+	if now := time.Now(); upperBound.After(now) {
+		upperBound = now
 	}
+
+	_, glukitUser, err := store.GetGlukitUser(context, userEmail)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf(context, "Detecting hypo events for user [%s] from [%s] to [%s]", userEmail, lowerBound, upperBound)
+	reads, err := store.GetGlucoseReads(context, userEmail, lowerBound, upperBound)
+	if err != nil {
+		return err
+	}
+
+	events := DetectHypoEvents(reads, glukitUser.TargetLow, DEFAULT_HYPO_MIN_DURATION)
+	if _, err := store.StoreHypoEvents(context, store.GetUserKey(context, userEmail), events); err != nil {
+		log.Errorf(context, "Error storing batch of [%d] hypo events for user [%s]: %v", len(events), userEmail, err)
+	}
+
+	if upperBound.Before(time.Now()) {
+		// An event still going on at the end of the chunk is detected again, whole, by the next chunk
+		nextLowerBound := upperBound
+		if last := len(events) - 1; last >= 0 && upperBound.Sub(events[last].EndTime) < HYPO_MERGE_GAP {
+			nextLowerBound = events[last].StartTime
+		}
+
+		task, err := RunHypoDetectionChunk.Task(userEmail, nextLowerBound)
+		if err != nil {
+			log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
+				"This breaks batch detection of hypo events for that user!: %v", HYPO_DETECTION_FUNCTION_NAME, userEmail, err)
+		}
+		taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME)
+
+		log.Infof(context, "Queued up next chunk of hypo detection for user [%s] and lowerBound [%s]", userEmail, nextLowerBound.Format(util.TIMEFORMAT))
+	} else {
+		log.Infof(context, "Done with hypo detection for user [%s]", userEmail)
+	}
+
+	return nil
 }
@@ -34,60 +34,74 @@ var A1C_CALCULATION_START = time.Unix(1388534400, 0)
 
 // CalculateGlukitScore computes the GlukitScore for a given user. This is done in a few steps:
 //   1. Get the latest GLUKIT_SCORE_PERIOD days of reads
-//   2. For the most recent reads up to READS_REQUIREMENT, calculate the individual score
-//      contribution and add it to the GlukitScore.
-//   3. If we had enough reads to satisfy the requirements, we return the sum of
-//      all individual score contributions.
+//   2. Score the reads with GlukitScoreOfReads
 func CalculateGlukitScore(context context.Context, glukitUser *model.GlukitUser, endOfPeriod time.Time) (glukitScore *model.GlukitScore, err error) {
 	// Get the last period's worth of reads
 	upperBound := util.GetMidnightUTCBefore(endOfPeriod)
 	lowerBound := upperBound.AddDate(0, 0, -1*GLUKIT_SCORE_PERIOD)
-	score := model.UNDEFINED_SCORE_VALUE
 
 	log.Debugf(context, "Getting reads for glukit score calculation from [%s] to [%s]", lowerBound, upperBound)
-	if reads, err := store.GetGlucoseReads(context, glukitUser.Email, lowerBound, upperBound); err != nil {
+	reads, err := store.GetGlucoseReads(context, glukitUser.Email, lowerBound, upperBound)
+	if err != nil {
 		return &model.UNDEFINED_SCORE, err
-	} else {
-		// We might want to do some interpolation of missing reads at some point but for now, we'll only use
-		// actual values. Since we know we'll have gaps in a 2 weeks window because of sensor warm-ups, let's
-		// just normalize by stopping after the equivalent of full 14 days of reads (assuming most people won't have
-		// more than 2 days worth of missing data)
-		readCount := 0
-		score = 0
-
-		for i := 0; i < len(reads) && i < READS_REQUIREMENT; i++ {
-			score = score + int64(CalculateIndividualReadScoreWeight(context, reads[i]))
-			readCount = readCount + 1
-		}
+	}
 
-		log.Infof(context, "Readcount of [%d] used for glukit score calculation of [%d]", readCount, score)
-		if readCount < READS_REQUIREMENT {
-			log.Infof(context, "Received only [%d] but required [%d] to calculate valid GlukitScore", readCount, READS_REQUIREMENT)
-			return &model.UNDEFINED_SCORE, nil
-		}
+	glukitScore, err = GlukitScoreOfReads(reads, lowerBound, upperBound, glukitUser.TargetLow, glukitUser.TargetHigh)
+	if err != nil {
+		return &model.UNDEFINED_SCORE, err
 	}
 
-	if score == model.UNDEFINED_SCORE_VALUE {
-		glukitScore = &model.UNDEFINED_SCORE
-	} else {
-		glukitScore = &model.GlukitScore{
-			Value:          score,
-			LowerBound:     lowerBound,
-			UpperBound:     upperBound,
-			CalculatedOn:   time.Now(),
-			ScoringVersion: SCORING_VERSION}
+	if glukitScore.Value == model.UNDEFINED_SCORE_VALUE {
+		log.Infof(context, "Received only [%d] but required [%d] to calculate valid GlukitScore", len(reads), READS_REQUIREMENT)
+		return &model.UNDEFINED_SCORE, nil
 	}
 
+	glukitScore.CalculatedOn = time.Now()
+	log.Infof(context, "Readcount of [%d] used for glukit score calculation of [%d]", READS_REQUIREMENT, glukitScore.Value)
 	return glukitScore, nil
 }
 
-// An individual score is either 0 if it's straight on perfection (83) or it's the deviation from 83 weighted
-// by whether it's high (multiplier of 2) or lower (multiplier of 1)
-func CalculateIndividualReadScoreWeight(context context.Context, read apimodel.GlucoseRead) (weightedScoreContribution float64) {
-	weightedScoreContribution = 0.
+// GlukitScoreOfReads computes the GlukitScore of the period between the lower and upper bounds from its reads in
+// chronological order:
+//   1. For the reads up to READS_REQUIREMENT, calculate the individual score contribution and add it to the GlukitScore.
+//   2. If we had enough reads to satisfy the requirements, we return the sum of all individual score contributions
+//      along with the GVI and PGS, for the target range, of the same reads.
+// The score is model.UNDEFINED_SCORE if there aren't enough reads. CalculatedOn is left for the caller to set.
+func GlukitScoreOfReads(reads []apimodel.GlucoseRead, lowerBound, upperBound time.Time, targetLow, targetHigh float32) (glukitScore *model.GlukitScore, err error) {
+	// We might want to do some interpolation of missing reads at some point but for now, we'll only use
+	// actual values. Since we know we'll have gaps in a 2 weeks window because of sensor warm-ups, let's
+	// just normalize by stopping after the equivalent of full 14 days of reads (assuming most people won't have
+	// more than 2 days worth of missing data)
+	if len(reads) < READS_REQUIREMENT {
+		return &model.UNDEFINED_SCORE, nil
+	}
+
+	score := int64(0)
+	for i := 0; i < READS_REQUIREMENT; i++ {
+		weight, err := IndividualReadScoreWeight(reads[i])
+		if err != nil {
+			return &model.UNDEFINED_SCORE, err
+		}
+		score = score + int64(weight)
+	}
+	gvi, pgs := variabilityOf(reads[:READS_REQUIREMENT], targetLow, targetHigh)
+
+	return &model.GlukitScore{
+		Value:          score,
+		LowerBound:     lowerBound,
+		UpperBound:     upperBound,
+		ScoringVersion: SCORING_VERSION,
+		GVI:            gvi,
+		PGS:            pgs}, nil
+}
+
+// IndividualReadScoreWeight returns the contribution of a read to the GlukitScore. An individual score is either 0 if
+// it's straight on perfection (83) or it's the deviation from 83 weighted by whether it's high (multiplier of 2) or
+// lower (multiplier of 1)
+func IndividualReadScoreWeight(read apimodel.GlucoseRead) (weightedScoreContribution float64, err error) {
 	convertedValue, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
 	if err != nil {
-		util.Propagate(err)
+		return 0, err
 	}
 	value := float64(convertedValue)
 
@@ -97,7 +111,7 @@ func CalculateIndividualReadScoreWeight(context context.Context, read apimodel.G
 		weightedScoreContribution = -(value - model.TARGET_GLUCOSE_VALUE) * LOW_MULTIPLIER
 	}
 
-	return weightedScoreContribution
+	return weightedScoreContribution, nil
 }
 
 // CalculateUserFacingScore maps an internal GlukitScore to a user facing value (should be between 0 and 100)
@@ -127,14 +141,24 @@ func StartGlukitScoreBatch(context context.Context, glukitUser *model.GlukitUser
 		lowerBound = minLowerBound
 	}
 
-	// Kick off the first chunk of glukit score calculation
-	task, err := RunGlukitScoreCalculationChunk.Task(glukitUser.Email, lowerBound)
+	return startGlukitScoreBatchFrom(context, glukitUser.Email, lowerBound)
+}
+
+// startGlukitScoreBatchFrom kicks off the first chunk of glukit score calculation. The first score calculated is the one
+// of the period ending one day after lowerBound. The checkpoint of any previous batch is cleared.
+func startGlukitScoreBatchFrom(context context.Context, userEmail string, lowerBound time.Time) (err error) {
+	// A new batch scores all of its periods again even if a previous one started from the same lower bound
+	if err := store.ClearScoreCheckpoint(context, userEmail); err != nil {
+		return err
+	}
+
+	task, err := RunGlukitScoreCalculationChunk.Task(userEmail, lowerBound)
 	if err != nil {
 		log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
-			"This breaks batch calculation of glukit scores for that user!: %v", GLUKIT_SCORE_BATCH_CALCULATION_FUNCTION_NAME, glukitUser.Email, err)
+			"This breaks batch calculation of glukit scores for that user!: %v", GLUKIT_SCORE_BATCH_CALCULATION_FUNCTION_NAME, userEmail, err)
 	}
 	taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME)
-	log.Infof(context, "Queued up first chunk of glukit score calculation for user [%s] and lowerBound [%s]", glukitUser.Email, lowerBound.Format(util.TIMEFORMAT))
+	log.Infof(context, "Queued up first chunk of glukit score calculation for user [%s] and lowerBound [%s]", userEmail, lowerBound.Format(util.TIMEFORMAT))
 
 	return nil
 }
@@ -160,14 +184,19 @@ func StartA1CCalculationBatch(context context.Context, glukitUser *model.GlukitU
 		lowerBound = minLowerBound
 	}
 
-	// Kick off the first chunk of glukit score calculation
-	task, err := RunA1CCalculationChunk.Task(glukitUser.Email, lowerBound)
+	return startA1CCalculationBatchFrom(context, glukitUser.Email, lowerBound)
+}
+
+// startA1CCalculationBatchFrom kicks off the first chunk of a1c calculation. The first estimate calculated is the one
+// of the period ending one day after lowerBound.
+func startA1CCalculationBatchFrom(context context.Context, userEmail string, lowerBound time.Time) (err error) {
+	task, err := RunA1CCalculationChunk.Task(userEmail, lowerBound)
 	if err != nil {
 		log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
-			"This breaks batch calculation of a1c estimates scores for that user!: %v", A1C_BATCH_CALCULATION_FUNCTION_NAME, glukitUser.Email, err)
+			"This breaks batch calculation of a1c estimates scores for that user!: %v", A1C_BATCH_CALCULATION_FUNCTION_NAME, userEmail, err)
 	}
 	taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME)
-	log.Infof(context, "Queued up first chunk of a1c calculation for user [%s] and lowerBound [%s]", glukitUser.Email, lowerBound.Format(util.TIMEFORMAT))
+	log.Infof(context, "Queued up first chunk of a1c calculation for user [%s] and lowerBound [%s]", userEmail, lowerBound.Format(util.TIMEFORMAT))
 
 	return nil
 }
@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"sort"
+	"time"
+)
+
+const (
+	// How long before an exercise session its baseline read can be
+	EXERCISE_BASELINE_WINDOW = 30 * time.Minute
+	// How long after the end of an exercise session reads are part of its impact
+	EXERCISE_AFTER_WINDOW = 4 * time.Hour
+
+	// Duration categories of exercise sessions
+	EXERCISE_DURATION_SHORT  = "short"
+	EXERCISE_DURATION_MEDIUM = "medium"
+	EXERCISE_DURATION_LONG   = "long"
+)
+
+// AnalyzeExerciseImpacts calculates the impacts of the exercise sessions of the user in the period and stores them so
+// that GetExerciseImpactSummary doesn't need to calculate them again
+func AnalyzeExerciseImpacts(context context.Context, email string, period model.TimeRange) (impacts []model.ExerciseImpact, err error) {
+	lowerBound, upperBound := period.LowerBound, period.UpperBound
+	exercises, err := store.GetExercises(context, email, lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	readsUpperBound := upperBound
+	for _, exercise := range exercises {
+		if end := exerciseEnd(exercise).Add(EXERCISE_AFTER_WINDOW); end.After(readsUpperBound) {
+			readsUpperBound = end
+		}
+	}
+
+	reads, err := store.GetGlucoseReads(context, email, lowerBound.Add(-1*EXERCISE_BASELINE_WINDOW), readsUpperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	impacts = ExerciseImpactsOf(exercises, reads)
+	log.Infof(context, "Analyzed [%d] exercise impacts from [%d] sessions of user [%s] between [%s] and [%s]", len(impacts), len(exercises),
+		email, lowerBound, upperBound)
+
+	if _, err := store.StoreExerciseImpacts(context, store.GetUserKey(context, email), impacts); err != nil {
+		return nil, err
+	}
+
+	return impacts, nil
+}
+
+// GetExerciseImpactSummary summarizes the stored impacts of the exercise sessions of the user in the period
+func GetExerciseImpactSummary(context context.Context, email string, period model.TimeRange) (summary *model.ExerciseImpactSummary, err error) {
+	lowerBound, upperBound := period.LowerBound, period.UpperBound
+	impacts, err := store.GetExerciseImpacts(context, email, lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	summary = SummarizeExerciseImpacts(impacts)
+	summary.LowerBound, summary.UpperBound = lowerBound, upperBound
+	return summary, nil
+}
+
+// ExerciseImpactsOf calculates the impacts of exercise sessions from reads, both in chronological order. The baseline
+// of a session is the last read at or before its start, no earlier than EXERCISE_BASELINE_WINDOW. Sessions without a
+// baseline or without a read within apimodel.MAX_READ_INTERVAL of their end are left out. A session is followed by a
+// hypo if a hypo event is detected between its start and EXERCISE_AFTER_WINDOW after its end. Sessions that are also
+// in Google Fit only count once, see WithoutGoogleFitDuplicates.
+func ExerciseImpactsOf(exercises []apimodel.Exercise, reads []apimodel.GlucoseRead) (impacts []model.ExerciseImpact) {
+	impacts = make([]model.ExerciseImpact, 0)
+
+	for _, exercise := range WithoutGoogleFitDuplicates(exercises) {
+		if impact, ok := exerciseImpactOf(exercise, reads); ok {
+			impacts = append(impacts, impact)
+		}
+	}
+
+	return impacts
+}
+
+// WithoutGoogleFitDuplicates returns the exercises without the ones from other sources that overlap a Google Fit
+// session. The same workout is often logged on the receiver and tracked by Fit and the Fit session, with its measured
+// duration and intensity, is the one kept.
+func WithoutGoogleFitDuplicates(exercises []apimodel.Exercise) []apimodel.Exercise {
+	fitExercises := make([]apimodel.Exercise, 0)
+	for _, exercise := range exercises {
+		if exercise.IsFromGoogleFit() {
+			fitExercises = append(fitExercises, exercise)
+		}
+	}
+
+	if len(fitExercises) == 0 {
+		return exercises
+	}
+
+	deduplicated := make([]apimodel.Exercise, 0, len(exercises))
+	for _, exercise := range exercises {
+		if exercise.IsFromGoogleFit() || !overlapsAnyExercise(exercise, fitExercises) {
+			deduplicated = append(deduplicated, exercise)
+		}
+	}
+
+	return deduplicated
+}
+
+// overlapsAnyExercise returns true if the exercise, from its start to its end, both inclusive, overlaps one of others
+func overlapsAnyExercise(exercise apimodel.Exercise, others []apimodel.Exercise) bool {
+	start, end := exercise.GetTime(), exerciseEnd(exercise)
+	for _, other := range others {
+		if !start.After(exerciseEnd(other)) && !end.Before(other.GetTime()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func exerciseImpactOf(exercise apimodel.Exercise, reads []apimodel.GlucoseRead) (impact model.ExerciseImpact, ok bool) {
+	start := exercise.GetTime()
+	end := exerciseEnd(exercise)
+	afterEnd := end.Add(EXERCISE_AFTER_WINDOW)
+
+	baselineIndex, endIndex := -1, -1
+	sessionReads := make([]apimodel.GlucoseRead, 0)
+	for i, read := range reads {
+		readTime := read.GetTime()
+		if readTime.After(afterEnd) {
+			break
+		} else if readTime.Before(start.Add(-1 * EXERCISE_BASELINE_WINDOW)) {
+			continue
+		}
+
+		if !readTime.After(start) {
+			baselineIndex = i
+		} else {
+			sessionReads = append(sessionReads, read)
+		}
+
+		if !readTime.After(end) {
+			endIndex = i
+		}
+	}
+
+	if baselineIndex == -1 || endIndex == -1 || end.Sub(reads[endIndex].GetTime()) > apimodel.MAX_READ_INTERVAL {
+		return impact, false
+	}
+
+	impact = model.ExerciseImpact{StartTime: start, DurationMinutes: exercise.DurationMinutes, Intensity: exercise.Intensity}
+	impact.Baseline = reads[baselineIndex].GetMgPerDlValue()
+	impact.AtEnd = reads[endIndex].GetMgPerDlValue()
+	impact.AfterNadir = impact.AtEnd
+	impact.ReadCount = len(sessionReads) + 1
+
+	for _, read := range sessionReads {
+		if value := read.GetMgPerDlValue(); read.GetTime().After(end) && value < impact.AfterNadir {
+			impact.AfterNadir = value
+		}
+	}
+
+	impact.DeltaDuring = impact.AtEnd - impact.Baseline
+	impact.DeltaAfter = impact.AfterNadir - impact.AtEnd
+	impact.FollowedByHypo = len(DetectHypoEvents(sessionReads, DEFAULT_HYPO_THRESHOLD, DEFAULT_HYPO_MIN_DURATION)) > 0
+
+	return impact, true
+}
+
+// SummarizeExerciseImpacts aggregates impacts by intensity and by intensity and duration category. Groups are sorted by
+// intensity and then by duration category.
+func SummarizeExerciseImpacts(impacts []model.ExerciseImpact) (summary *model.ExerciseImpactSummary) {
+	byIntensity := make(map[string]*model.ExerciseImpactGroup)
+	byIntensityAndDuration := make(map[string]*model.ExerciseImpactGroup)
+
+	for _, impact := range impacts {
+		category := ExerciseDurationCategory(impact.DurationMinutes)
+		addToExerciseImpactGroup(byIntensity, impact, "")
+		addToExerciseImpactGroup(byIntensityAndDuration, impact, category)
+	}
+
+	return &model.ExerciseImpactSummary{Sessions: impacts, ByIntensity: sortedExerciseImpactGroups(byIntensity),
+		ByIntensityAndDuration: sortedExerciseImpactGroups(byIntensityAndDuration)}
+}
+
+// ExerciseDurationCategory returns the duration category of an exercise session: short under 30 minutes, medium under
+// an hour and long otherwise
+func ExerciseDurationCategory(durationMinutes int) string {
+	switch {
+	case durationMinutes < 30:
+		return EXERCISE_DURATION_SHORT
+	case durationMinutes < 60:
+		return EXERCISE_DURATION_MEDIUM
+	default:
+		return EXERCISE_DURATION_LONG
+	}
+}
+
+func addToExerciseImpactGroup(groups map[string]*model.ExerciseImpactGroup, impact model.ExerciseImpact, durationCategory string) {
+	key := string(impact.Intensity) + "/" + durationCategory
+	group, exists := groups[key]
+	if !exists {
+		group = &model.ExerciseImpactGroup{Intensity: impact.Intensity, DurationCategory: durationCategory}
+		groups[key] = group
+	}
+
+	// Means are accumulated as sums until the groups are sorted
+	group.SessionCount++
+	group.MeanDeltaDuring += float64(impact.DeltaDuring)
+	group.MeanDeltaAfter += float64(impact.DeltaAfter)
+	if impact.FollowedByHypo {
+		group.HypoCount++
+	}
+}
+
+func sortedExerciseImpactGroups(groups map[string]*model.ExerciseImpactGroup) []model.ExerciseImpactGroup {
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sorted := make([]model.ExerciseImpactGroup, len(keys))
+	for i, key := range keys {
+		sorted[i] = *groups[key]
+		sorted[i].MeanDeltaDuring /= float64(sorted[i].SessionCount)
+		sorted[i].MeanDeltaAfter /= float64(sorted[i].SessionCount)
+	}
+
+	return sorted
+}
+
+func exerciseEnd(exercise apimodel.Exercise) time.Time {
+	return exercise.GetTime().Add(time.Duration(exercise.DurationMinutes) * time.Minute)
+}
@@ -0,0 +1,91 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"testing"
+	"time"
+)
+
+func generateExercise(start time.Time, durationMinutes int, intensity apimodel.ExerciseIntensity) apimodel.Exercise {
+	return apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(start), "America/Los_Angeles"}, durationMinutes, intensity, "", "", "", false, false, ""}
+}
+
+func TestExerciseImpactsOf(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 17:00")
+	// Baseline of 150 at the start, 110 at the end of the 30 minutes session and down to 75 after
+	reads := generateReads(ct.Add(-10*time.Minute), 155, 152, 150, 140, 130, 125, 120, 115, 110, 100, 90, 75, 80, 95)
+	exercises := []apimodel.Exercise{generateExercise(ct, 30, apimodel.EXERCISE_INTENSITY_MEDIUM)}
+
+	impacts := engine.ExerciseImpactsOf(exercises, reads)
+	if len(impacts) != 1 {
+		t.Fatalf("TestExerciseImpactsOf failed: expected 1 impact but got [%v]", impacts)
+	}
+
+	impact := impacts[0]
+	if impact.Baseline != 150 || impact.AtEnd != 110 || impact.AfterNadir != 75 {
+		t.Errorf("TestExerciseImpactsOf failed: expected baseline of 150, 110 at the end and nadir of 75 but got [%v]", impact)
+	}
+
+	if impact.DeltaDuring != -40 || impact.DeltaAfter != -35 || impact.FollowedByHypo {
+		t.Errorf("TestExerciseImpactsOf failed: expected deltas of -40 and -35 without hypo but got [%v]", impact)
+	}
+}
+
+func TestExerciseImpactsOfFlagsSessionsFollowedByHypo(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 17:00")
+	reads := generateReads(ct, 100, 90, 80, 70, 65, 60, 58, 62, 68, 75)
+	exercises := []apimodel.Exercise{generateExercise(ct, 15, apimodel.EXERCISE_INTENSITY_HEAVY)}
+
+	impacts := engine.ExerciseImpactsOf(exercises, reads)
+	if len(impacts) != 1 || !impacts[0].FollowedByHypo {
+		t.Errorf("TestExerciseImpactsOfFlagsSessionsFollowedByHypo failed: expected 1 impact followed by a hypo but got [%v]", impacts)
+	}
+}
+
+func TestExerciseImpactsOfSkipsSessionsWithoutReads(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 17:00")
+	reads := generateReads(ct.Add(-2*time.Hour), 100, 100, 100)
+	exercises := []apimodel.Exercise{generateExercise(ct, 45, apimodel.EXERCISE_INTENSITY_LIGHT)}
+
+	if impacts := engine.ExerciseImpactsOf(exercises, reads); len(impacts) != 0 {
+		t.Errorf("TestExerciseImpactsOfSkipsSessionsWithoutReads failed: expected no impact but got [%v]", impacts)
+	}
+}
+
+func TestWithoutGoogleFitDuplicatesPrefersFitSessions(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 17:00")
+	logged := generateExercise(ct.Add(10*time.Minute), 30, apimodel.EXERCISE_INTENSITY_UNKNOWN)
+	fit := generateExercise(ct, 45, apimodel.EXERCISE_INTENSITY_HEAVY)
+	fit.Source = apimodel.EXERCISE_SOURCE_GOOGLE_FIT
+	later := generateExercise(ct.Add(2*time.Hour), 20, apimodel.EXERCISE_INTENSITY_LIGHT)
+
+	exercises := engine.WithoutGoogleFitDuplicates([]apimodel.Exercise{fit, logged, later})
+	if len(exercises) != 2 || exercises[0] != fit || exercises[1] != later {
+		t.Errorf("TestWithoutGoogleFitDuplicatesPrefersFitSessions failed: expected the fit session and the later one but got [%v]", exercises)
+	}
+}
+
+func TestSummarizeExerciseImpacts(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 17:00")
+	reads := generateReads(ct, 150, 140, 130, 120)
+	reads = append(reads, generateReads(ct.AddDate(0, 0, 1), 150, 130, 110, 90, 80, 70, 60)...)
+	reads = append(reads, generateReads(ct.AddDate(0, 0, 2), 150, 145)...)
+	exercises := []apimodel.Exercise{generateExercise(ct, 15, apimodel.EXERCISE_INTENSITY_HEAVY),
+		generateExercise(ct.AddDate(0, 0, 1), 15, apimodel.EXERCISE_INTENSITY_HEAVY),
+		generateExercise(ct.AddDate(0, 0, 2), 5, apimodel.EXERCISE_INTENSITY_LIGHT)}
+
+	summary := engine.SummarizeExerciseImpacts(engine.ExerciseImpactsOf(exercises, reads))
+	if len(summary.ByIntensity) != 2 || len(summary.ByIntensityAndDuration) != 2 {
+		t.Fatalf("TestSummarizeExerciseImpacts failed: expected 2 groups of each but got [%v]", summary)
+	}
+
+	heavy := summary.ByIntensity[0]
+	if heavy.Intensity != apimodel.EXERCISE_INTENSITY_HEAVY || heavy.SessionCount != 2 || !isClose(heavy.MeanDeltaDuring, -45) || !isClose(heavy.MeanDeltaAfter, -15) {
+		t.Errorf("TestSummarizeExerciseImpacts failed: expected 2 heavy sessions with mean drops of 45 and 15 but got [%v]", heavy)
+	}
+
+	if summary.ByIntensityAndDuration[0].DurationCategory != engine.EXERCISE_DURATION_SHORT {
+		t.Errorf("TestSummarizeExerciseImpacts failed: expected short sessions but got [%v]", summary.ByIntensityAndDuration[0])
+	}
+}
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"time"
+)
+
+const (
+	// Default threshold, in mg/dL, below which reads are part of a hypo event
+	DEFAULT_HYPO_THRESHOLD = apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD
+	// Default minimum duration of a hypo event, shorter dips are ignored
+	DEFAULT_HYPO_MIN_DURATION = 15 * time.Minute
+	// Hypo events separated by less than this are merged into a single event
+	HYPO_MERGE_GAP = 15 * time.Minute
+	// Number of days of reads scanned by a single chunk of hypo detection
+	HYPO_DETECTION_DAYS_PER_BATCH = 14
+)
+
+// DetectHypoEvents scans reads in chronological order and returns the periods spent below the threshold, in mg/dL.
+// Consecutive reads below the threshold that are more than apimodel.MAX_READ_INTERVAL apart are considered to be on
+// each side of a gap in the reads rather than part of the same event. Events separated by less than HYPO_MERGE_GAP
+// are merged and events shorter than minDuration are then ignored.
+func DetectHypoEvents(reads []apimodel.GlucoseRead, threshold float32, minDuration time.Duration) (events []model.HypoEvent) {
+	events = make([]model.HypoEvent, 0)
+	var current *model.HypoEvent
+
+	closeCurrent := func() {
+		if current == nil {
+			return
+		}
+
+		if last := len(events) - 1; last >= 0 && current.StartTime.Sub(events[last].EndTime) < HYPO_MERGE_GAP {
+			events[last].EndTime = current.EndTime
+			if current.Nadir < events[last].Nadir {
+				events[last].Nadir = current.Nadir
+			}
+		} else {
+			events = append(events, *current)
+		}
+		current = nil
+	}
+
+	for _, read := range reads {
+		value := read.GetMgPerDlValue()
+		readTime := read.GetTime()
+
+		if value >= threshold {
+			closeCurrent()
+			continue
+		}
+
+		if current != nil && readTime.Sub(current.EndTime) > apimodel.MAX_READ_INTERVAL {
+			closeCurrent()
+		}
+
+		if current == nil {
+			current = &model.HypoEvent{StartTime: readTime, EndTime: readTime, Nadir: value, Threshold: threshold}
+		} else {
+			current.EndTime = readTime
+			if value < current.Nadir {
+				current.Nadir = value
+			}
+		}
+	}
+	closeCurrent()
+
+	filtered := events[:0]
+	for _, event := range events {
+		event.Duration = event.EndTime.Sub(event.StartTime)
+		if event.Duration >= minDuration {
+			filtered = append(filtered, event)
+		}
+	}
+
+	return filtered
+}
+
+// StartHypoDetectionBatch detects hypo events in the reads following the most recent hypo event stored for the user,
+// going back at most MAX_CALCULATION_DAYS_TO_LOOK_BACK days
+func StartHypoDetectionBatch(context context.Context, glukitUser *model.GlukitUser) (err error) {
+	lowerBound := time.Now().AddDate(0, 0, -1*MAX_CALCULATION_DAYS_TO_LOOK_BACK)
+
+	// Start over from the most recent event so that it gets extended if the new reads continue it
+	if mostRecentEvent, err := store.GetMostRecentHypoEvent(context, glukitUser.Email); err != nil {
+		return err
+	} else if mostRecentEvent != nil && mostRecentEvent.StartTime.After(lowerBound) {
+		lowerBound = mostRecentEvent.StartTime
+	}
+
+	task, err := RunHypoDetectionChunk.Task(glukitUser.Email, lowerBound)
+	if err != nil {
+		log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
+			"This breaks batch detection of hypo events for that user!: %v", HYPO_DETECTION_FUNCTION_NAME, glukitUser.Email, err)
+	}
+	taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME)
+	log.Infof(context, "Queued up first chunk of hypo detection for user [%s] and lowerBound [%s]", glukitUser.Email, lowerBound.Format(util.TIMEFORMAT))
+
+	return nil
+}
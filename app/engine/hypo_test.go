@@ -0,0 +1,65 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/engine"
+	"testing"
+	"time"
+)
+
+func TestDetectHypoEvents(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := generateReads(ct, 100, 68, 60, 52, 58, 65, 90, 100)
+
+	events := engine.DetectHypoEvents(reads, engine.DEFAULT_HYPO_THRESHOLD, engine.DEFAULT_HYPO_MIN_DURATION)
+	if len(events) != 1 {
+		t.Fatalf("TestDetectHypoEvents failed: expected 1 event but got [%v]", events)
+	}
+
+	event := events[0]
+	if !event.StartTime.Equal(ct.Add(5*time.Minute)) || !event.EndTime.Equal(ct.Add(25*time.Minute)) {
+		t.Errorf("TestDetectHypoEvents failed: got event from [%s] to [%s]", event.StartTime, event.EndTime)
+	}
+
+	if event.Nadir != 52 || event.Duration != 20*time.Minute {
+		t.Errorf("TestDetectHypoEvents failed: expected nadir of 52 for 20m but got [%f] for [%v]", event.Nadir, event.Duration)
+	}
+}
+
+func TestDetectHypoEventsIgnoresShortDips(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := generateReads(ct, 100, 65, 60, 80, 100)
+
+	if events := engine.DetectHypoEvents(reads, engine.DEFAULT_HYPO_THRESHOLD, engine.DEFAULT_HYPO_MIN_DURATION); len(events) != 0 {
+		t.Errorf("TestDetectHypoEventsIgnoresShortDips failed: expected no event but got [%v]", events)
+	}
+}
+
+func TestDetectHypoEventsMergesCloseEvents(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	// Two short dips 10 minutes apart make a single 25 minutes event
+	reads := generateReads(ct, 65, 60, 65, 75, 66, 55)
+
+	events := engine.DetectHypoEvents(reads, engine.DEFAULT_HYPO_THRESHOLD, engine.DEFAULT_HYPO_MIN_DURATION)
+	if len(events) != 1 {
+		t.Fatalf("TestDetectHypoEventsMergesCloseEvents failed: expected 1 event but got [%v]", events)
+	}
+
+	if events[0].Duration != 25*time.Minute || events[0].Nadir != 55 {
+		t.Errorf("TestDetectHypoEventsMergesCloseEvents failed: expected nadir of 55 for 25m but got [%f] for [%v]", events[0].Nadir, events[0].Duration)
+	}
+}
+
+func TestDetectHypoEventsSplitsOnSensorGap(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := generateReads(ct, 60, 60, 60, 60)
+	reads = append(reads, generateReads(ct.Add(3*time.Hour), 50, 50, 50, 50)...)
+
+	events := engine.DetectHypoEvents(reads, engine.DEFAULT_HYPO_THRESHOLD, engine.DEFAULT_HYPO_MIN_DURATION)
+	if len(events) != 2 {
+		t.Fatalf("TestDetectHypoEventsSplitsOnSensorGap failed: expected 2 events but got [%v]", events)
+	}
+
+	if events[0].Nadir != 60 || events[1].Nadir != 50 {
+		t.Errorf("TestDetectHypoEventsSplitsOnSensorGap failed: got events [%v]", events)
+	}
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+// CalculateDailyInsulinTotals returns the basal and bolus totals of the days, in the user's timezone, of the period
+func CalculateDailyInsulinTotals(context context.Context, email string, period model.TimeRange) (totals []model.DailyInsulinTotals, err error) {
+	lowerBound, upperBound := period.LowerBound, period.UpperBound
+	_, glukitUser, err := store.GetGlukitUser(context, email)
+	if err != nil {
+		return nil, err
+	}
+
+	location := UserLocation(glukitUser)
+	firstDay := midnightOf(lowerBound.In(location))
+	lastDay := midnightOf(upperBound.In(location))
+	end := lastDay.AddDate(0, 0, 1)
+
+	injections, err := store.GetInjections(context, email, firstDay, end)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rates started before the first day can still be in effect at its start
+	basalRates, err := store.GetBasalRates(context, email, firstDay.Add(-MAX_BASAL_RATE_DURATION), end)
+	if err != nil {
+		return nil, err
+	}
+
+	totals = DailyInsulinTotalsOf(injections, basalRates, firstDay, lastDay)
+	log.Infof(context, "Calculated insulin totals of [%d] days for user [%s] from [%d] injections and [%d] basal rates", len(totals), email,
+		len(injections), len(basalRates))
+
+	return totals, nil
+}
+
+// DailyInsulinTotalsOf returns the insulin totals of each day from the local midnights firstDay to lastDay, inclusively.
+// Injections are totaled by the category of their insulin and basal rates are all basal insulin.
+func DailyInsulinTotalsOf(injections []apimodel.Injection, basalRates []apimodel.BasalRate, firstDay, lastDay time.Time) (totals []model.DailyInsulinTotals) {
+	totals = make([]model.DailyInsulinTotals, 0)
+	dayIndexes := make(map[int64]int)
+	for day := firstDay; !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+		dayIndexes[day.Unix()] = len(totals)
+		totals = append(totals, model.DailyInsulinTotals{Date: day})
+	}
+
+	dayOf := func(injection apimodel.Injection) (dayTotals *model.DailyInsulinTotals, ok bool) {
+		index, ok := dayIndexes[midnightOf(injection.GetTime().In(firstDay.Location())).Unix()]
+		if !ok {
+			return nil, false
+		}
+
+		return &totals[index], true
+	}
+
+	for _, injection := range injections {
+		dayTotals, ok := dayOf(injection)
+		if !ok {
+			continue
+		}
+
+		switch injection.Category {
+		case apimodel.INSULIN_CATEGORY_LONG_ACTING:
+			dayTotals.Basal += float64(injection.Units)
+		case apimodel.INSULIN_CATEGORY_RAPID_ACTING:
+			dayTotals.Bolus += float64(injection.Units)
+		case apimodel.INSULIN_CATEGORY_MIXED:
+			dayTotals.Mixed += float64(injection.Units)
+		default:
+			dayTotals.Unknown += float64(injection.Units)
+		}
+	}
+
+	for _, pulse := range BasalPulses(basalRates, firstDay, lastDay.AddDate(0, 0, 1)) {
+		if dayTotals, ok := dayOf(pulse); ok {
+			dayTotals.Basal += float64(pulse.Units)
+		}
+	}
+
+	return totals
+}
@@ -0,0 +1,46 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"testing"
+	"time"
+)
+
+func TestDailyInsulinTotalsOf(t *testing.T) {
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	firstDay := time.Date(2014, 4, 18, 0, 0, 0, 0, location)
+	lastDay := firstDay.AddDate(0, 0, 1)
+
+	injection := func(t time.Time, units float32, category apimodel.InsulinCategory) apimodel.Injection {
+		return apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(t), location.String()}, units, "", "", category, "", false, false}
+	}
+
+	injections := []apimodel.Injection{
+		injection(firstDay.Add(8*time.Hour), 4, apimodel.INSULIN_CATEGORY_RAPID_ACTING),
+		injection(firstDay.Add(12*time.Hour), 6, apimodel.INSULIN_CATEGORY_RAPID_ACTING),
+		injection(firstDay.Add(22*time.Hour), 20, apimodel.INSULIN_CATEGORY_LONG_ACTING),
+		injection(lastDay.Add(8*time.Hour), 10, apimodel.INSULIN_CATEGORY_MIXED),
+		injection(lastDay.Add(9*time.Hour), 2, apimodel.INSULIN_CATEGORY_UNKNOWN),
+		// Outside of the days
+		injection(lastDay.AddDate(0, 0, 1).Add(time.Hour), 5, apimodel.INSULIN_CATEGORY_RAPID_ACTING),
+	}
+
+	// 1 unit/h for 3 hours on the second day
+	basalRates := []apimodel.BasalRate{
+		apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(lastDay.Add(time.Hour)), location.String()}, 1, 180, false},
+	}
+
+	totals := engine.DailyInsulinTotalsOf(injections, basalRates, firstDay, lastDay)
+	if len(totals) != 2 {
+		t.Fatalf("TestDailyInsulinTotalsOf failed: got [%d] days but expected 2", len(totals))
+	}
+
+	if !totals[0].Date.Equal(firstDay) || totals[0].Bolus != 10 || totals[0].Basal != 20 || totals[0].Mixed != 0 || totals[0].Unknown != 0 {
+		t.Errorf("TestDailyInsulinTotalsOf failed: got [%v] for the first day", totals[0])
+	}
+
+	if !isClose(totals[1].Basal, 3) || totals[1].Bolus != 0 || totals[1].Mixed != 10 || totals[1].Unknown != 2 || !isClose(totals[1].Total(), 15) {
+		t.Errorf("TestDailyInsulinTotalsOf failed: got [%v] for the second day", totals[1])
+	}
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"math"
+	"time"
+)
+
+const (
+	// Default duration of insulin action, suited to rapid acting insulin
+	DEFAULT_DURATION_OF_INSULIN_ACTION = 4 * time.Hour
+	// Time of peak activity of rapid acting insulin
+	RAPID_ACTING_INSULIN_PEAK = 75 * time.Minute
+	// Interval of the pulses basal rates are split into to estimate their insulin on board
+	BASAL_PULSE_INTERVAL = 5 * time.Minute
+	// Longest a basal rate can last, to look back far enough for the basal rate in effect at the start of a period
+	MAX_BASAL_RATE_DURATION = 24 * time.Hour
+)
+
+// InsulinCurve models how insulin from an injection is used up over time
+type InsulinCurve interface {
+	// Name identifies the curve
+	Name() string
+	// Remaining returns the fraction, between 0 and 1, of an injection still active after elapsed for the duration of
+	// insulin action
+	Remaining(elapsed, durationOfInsulinAction time.Duration) float64
+}
+
+// BilinearInsulinCurve models the activity of insulin as rising linearly to its peak and then falling linearly to
+// nothing at the end of the duration of insulin action. The peak is at 75 minutes for a duration of 3 hours and
+// scales with the duration.
+type BilinearInsulinCurve struct{}
+
+func (c BilinearInsulinCurve) Name() string {
+	return "bilinear"
+}
+
+func (c BilinearInsulinCurve) Remaining(elapsed, durationOfInsulinAction time.Duration) float64 {
+	if elapsed <= 0 {
+		return 1
+	}
+	if elapsed >= durationOfInsulinAction {
+		return 0
+	}
+
+	t, td := elapsed.Minutes(), durationOfInsulinAction.Minutes()
+	tp := td * 75 / 180
+	// The activity is a triangle of area 1 peaking at 2/td
+	if t < tp {
+		return 1 - t*t/(td*tp)
+	}
+
+	return (td - t) * (td - t) / (td * (td - tp))
+}
+
+// ExponentialInsulinCurve models the activity of insulin as an exponential decay that peaks at Peak, after the
+// model published by OpenAPS
+type ExponentialInsulinCurve struct {
+	Peak time.Duration
+}
+
+func (c ExponentialInsulinCurve) Name() string {
+	return "exponential"
+}
+
+func (c ExponentialInsulinCurve) Remaining(elapsed, durationOfInsulinAction time.Duration) float64 {
+	if elapsed <= 0 {
+		return 1
+	}
+	if elapsed >= durationOfInsulinAction {
+		return 0
+	}
+
+	t, td, tp := elapsed.Minutes(), durationOfInsulinAction.Minutes(), c.Peak.Minutes()
+	tau := tp * (1 - tp/td) / (1 - 2*tp/td)
+	a := 2 * tau / td
+	s := 1 / (1 - a + (1+a)*math.Exp(-td/tau))
+
+	return math.Max(0, 1-s*(1-a)*((t*t/(tau*td*(1-a))-t/tau-1)*math.Exp(-t/tau)+1))
+}
+
+// DefaultInsulinCurve is the curve used when none is specified
+var DefaultInsulinCurve InsulinCurve = ExponentialInsulinCurve{RAPID_ACTING_INSULIN_PEAK}
+
+// CalculateIOB returns the insulin on board, in units, at the given time from injections using the default insulin curve
+func CalculateIOB(injections []apimodel.Injection, at time.Time, durationOfInsulinAction time.Duration) (iob float64) {
+	return CalculateIOBWithCurve(injections, at, durationOfInsulinAction, DefaultInsulinCurve)
+}
+
+// CalculateIOBWithCurve returns the insulin on board, in units, at the given time from injections. Injections after
+// that time aren't counted.
+func CalculateIOBWithCurve(injections []apimodel.Injection, at time.Time, durationOfInsulinAction time.Duration, curve InsulinCurve) (iob float64) {
+	for _, injection := range injections {
+		elapsed := at.Sub(injection.GetTime())
+		if elapsed < 0 {
+			continue
+		}
+		iob += float64(injection.Units) * curve.Remaining(elapsed, durationOfInsulinAction)
+	}
+
+	return iob
+}
+
+// CalculateCurrentIOB returns the insulin on board of the user right now from the injections and basal rates of the
+// last duration of insulin action
+func CalculateCurrentIOB(context context.Context, email string, durationOfInsulinAction time.Duration, curve InsulinCurve) (iob float64, err error) {
+	now := time.Now()
+	injections, err := store.GetInjections(context, email, now.Add(-durationOfInsulinAction), now)
+	if err != nil {
+		return 0, err
+	}
+
+	basalRates, err := store.GetBasalRates(context, email, now.Add(-durationOfInsulinAction-MAX_BASAL_RATE_DURATION), now)
+	if err != nil {
+		return 0, err
+	}
+
+	basalPulses := BasalPulses(basalRates, now.Add(-durationOfInsulinAction), now)
+	iob = CalculateIOBWithCurve(append(injections, basalPulses...), now, durationOfInsulinAction, curve)
+	log.Infof(context, "Insulin on board of user [%s] is [%f] units from [%d] injections and [%d] basal pulses with [%s] curve", email, iob,
+		len(injections), len(basalPulses), curve.Name())
+	return iob, nil
+}
+
+// BasalPulses converts the insulin delivered by basal rates between start and end into injections of BASAL_PULSE_INTERVAL
+// so that their insulin on board can be estimated like the one of boluses. Basal rates are expected in chronological
+// order. A temp basal overrides the scheduled rate for its duration.
+func BasalPulses(basalRates []apimodel.BasalRate, start, end time.Time) (pulses []apimodel.Injection) {
+	for pulseTime := start; pulseTime.Before(end); pulseTime = pulseTime.Add(BASAL_PULSE_INTERVAL) {
+		rate, ok := basalRateAt(basalRates, pulseTime)
+		if !ok || rate == 0 {
+			continue
+		}
+
+		units := rate * float32(BASAL_PULSE_INTERVAL) / float32(time.Hour)
+		pulses = append(pulses, apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(pulseTime), pulseTime.Location().String()}, units, "", apimodel.BASAL_TAG, apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false})
+	}
+
+	return pulses
+}
+
+// basalRateAt returns the rate, in units per hour, in effect at t. The most recent temp basal covering t wins over the
+// most recent scheduled rate covering t.
+func basalRateAt(basalRates []apimodel.BasalRate, t time.Time) (rate float32, ok bool) {
+	var scheduled, temp *apimodel.BasalRate
+	for i := range basalRates {
+		if basalRates[i].GetTime().After(t) {
+			break
+		}
+
+		if !basalRates[i].GetEndTime().After(t) {
+			continue
+		}
+
+		if basalRates[i].Temp {
+			temp = &basalRates[i]
+		} else {
+			scheduled = &basalRates[i]
+		}
+	}
+
+	switch {
+	case temp != nil:
+		return temp.Rate, true
+	case scheduled != nil:
+		return scheduled.Rate, true
+	}
+
+	return 0., false
+}
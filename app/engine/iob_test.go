@@ -0,0 +1,76 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"testing"
+	"time"
+)
+
+func TestInsulinCurves(t *testing.T) {
+	var tests = []struct {
+		curve    engine.InsulinCurve
+		dia      time.Duration
+		elapsed  time.Duration
+		expected float64
+	}{
+		{engine.BilinearInsulinCurve{}, 3 * time.Hour, 0, 1},
+		{engine.BilinearInsulinCurve{}, 3 * time.Hour, 75 * time.Minute, 1 - 75./180},
+		{engine.BilinearInsulinCurve{}, 3 * time.Hour, 2 * time.Hour, 3600. / 18900},
+		{engine.BilinearInsulinCurve{}, 3 * time.Hour, 3 * time.Hour, 0},
+		{engine.ExponentialInsulinCurve{75 * time.Minute}, 5 * time.Hour, 0, 1},
+		{engine.ExponentialInsulinCurve{75 * time.Minute}, 5 * time.Hour, time.Hour, 0.764},
+		{engine.ExponentialInsulinCurve{75 * time.Minute}, 5 * time.Hour, 2 * time.Hour, 0.411},
+		{engine.ExponentialInsulinCurve{75 * time.Minute}, 5 * time.Hour, 3 * time.Hour, 0.159},
+		{engine.ExponentialInsulinCurve{75 * time.Minute}, 5 * time.Hour, 6 * time.Hour, 0},
+	}
+
+	for _, test := range tests {
+		if remaining := test.curve.Remaining(test.elapsed, test.dia); !isClose(remaining, test.expected) {
+			t.Errorf("TestInsulinCurves failed for [%s] curve after [%v]: got [%f] remaining but expected [%f]", test.curve.Name(), test.elapsed, remaining, test.expected)
+		}
+	}
+}
+
+func TestCalculateIOB(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 12:00")
+	injections := []apimodel.Injection{
+		apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(ct), "America/Los_Angeles"}, 4, "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false},
+		apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(ct.Add(75 * time.Minute)), "America/Los_Angeles"}, 2, "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false},
+		apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(ct.Add(3 * time.Hour)), "America/Los_Angeles"}, 10, "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false},
+	}
+
+	// The last injection is after the time of calculation and isn't counted
+	iob := engine.CalculateIOBWithCurve(injections, ct.Add(75*time.Minute), 3*time.Hour, engine.BilinearInsulinCurve{})
+	if expected := 4*(1-75./180) + 2; !isClose(iob, expected) {
+		t.Errorf("TestCalculateIOB failed: got [%f] units on board but expected [%f]", iob, expected)
+	}
+}
+
+func TestBasalPulses(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 12:00")
+	basalRates := []apimodel.BasalRate{
+		apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(ct.Add(-time.Hour)), "America/Los_Angeles"}, 1.2, 180, false},
+		apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(ct.Add(30 * time.Minute)), "America/Los_Angeles"}, 0, 15, true},
+	}
+
+	pulses := engine.BasalPulses(basalRates, ct, ct.Add(90*time.Minute))
+
+	// 75 minutes of scheduled basal at 1.2 U/h and 15 minutes of suspension with a zero temp basal
+	if len(pulses) != 15 {
+		t.Fatalf("TestBasalPulses failed: got [%d] pulses but expected [%d]: %v", len(pulses), 15, pulses)
+	}
+
+	var total float64
+	for _, pulse := range pulses {
+		total += float64(pulse.Units)
+	}
+
+	if !isClose(total, 1.5) {
+		t.Errorf("TestBasalPulses failed: got a total of [%f] units but expected [%f]", total, 1.5)
+	}
+
+	if iob := engine.CalculateIOB(pulses, ct.Add(90*time.Minute), engine.DEFAULT_DURATION_OF_INSULIN_ACTION); iob <= 0 || iob >= total {
+		t.Errorf("TestBasalPulses failed: got an insulin on board of [%f] units but expected it between 0 and [%f]", iob, total)
+	}
+}
@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+const (
+	// How long after a meal reads are part of its response
+	MEAL_RESPONSE_WINDOW = 3 * time.Hour
+	// Meals eaten less than this after a previous meal are merged into the response of that previous meal
+	MEAL_CONFOUNDING_WINDOW = 2 * time.Hour
+	// Meals with at least this much fat, in grams, are high fat meals. Fat slows down the absorption of carbohydrates.
+	HIGH_FAT_MEAL_THRESHOLD = 20
+)
+
+// AnalyzeMealResponses calculates the responses to the meals of the user in the period, stores them and returns them
+// along with their aggregates by hour of the day and by fat content
+func AnalyzeMealResponses(context context.Context, email string, period model.TimeRange) (analysis *model.MealResponseAnalysis, err error) {
+	lowerBound, upperBound := period.LowerBound, period.UpperBound
+	meals, err := store.GetMeals(context, email, lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reads start a bit before the first meal so that its baseline can be found
+	reads, err := store.GetGlucoseReads(context, email, lowerBound.Add(-1*apimodel.MAX_READ_INTERVAL), upperBound.Add(MEAL_RESPONSE_WINDOW))
+	if err != nil {
+		return nil, err
+	}
+
+	responses := MealResponsesOf(meals, reads)
+	log.Infof(context, "Analyzed [%d] meal responses from [%d] meals of user [%s] between [%s] and [%s]", len(responses), len(meals),
+		email, lowerBound, upperBound)
+
+	if _, err := store.StoreMealResponses(context, store.GetUserKey(context, email), responses); err != nil {
+		return nil, err
+	}
+
+	return &model.MealResponseAnalysis{lowerBound, upperBound, responses, MealResponsesByHour(responses), MealResponsesByFat(responses)}, nil
+}
+
+// MealResponsesOf calculates the responses to meals from reads, both in chronological order. The baseline of a response
+// is the last read at or before the meal, or the first one after it, no further than apimodel.MAX_READ_INTERVAL. Meals
+// without a baseline are left out. Meals eaten within MEAL_CONFOUNDING_WINDOW of a previous meal are merged with it and
+// the response window then extends to MEAL_RESPONSE_WINDOW after the last of them. Meals deleted by the user are skipped.
+func MealResponsesOf(meals []apimodel.Meal, reads []apimodel.GlucoseRead) (responses []model.MealResponse) {
+	responses = make([]model.MealResponse, 0)
+	meals = apimodel.MealSlice(meals).WithoutDeleted()
+
+	for i := 0; i < len(meals); {
+		mealTime := meals[i].GetTime()
+		lastMealTime := mealTime
+		carbohydrates, fat := meals[i].Carbs, meals[i].Fat
+
+		j := i + 1
+		for ; j < len(meals) && meals[j].GetTime().Sub(mealTime) < MEAL_CONFOUNDING_WINDOW; j++ {
+			lastMealTime = meals[j].GetTime()
+			carbohydrates += meals[j].Carbs
+			fat += meals[j].Fat
+		}
+
+		if response, ok := mealResponseOf(mealTime, lastMealTime.Add(MEAL_RESPONSE_WINDOW), reads); ok {
+			response.Carbohydrates, response.Fat = carbohydrates, fat
+			response.MealCount = j - i
+			responses = append(responses, response)
+		}
+		i = j
+	}
+
+	return responses
+}
+
+// mealResponseOf calculates the response to a meal eaten at mealTime from the reads up to end
+func mealResponseOf(mealTime, end time.Time, reads []apimodel.GlucoseRead) (response model.MealResponse, ok bool) {
+	baselineIndex := -1
+	for i, read := range reads {
+		readTime := read.GetTime()
+		if !readTime.After(mealTime) {
+			baselineIndex = i
+		} else {
+			if baselineIndex == -1 || mealTime.Sub(reads[baselineIndex].GetTime()) > apimodel.MAX_READ_INTERVAL {
+				baselineIndex = i
+			}
+			break
+		}
+	}
+
+	if baselineIndex == -1 {
+		return response, false
+	}
+	if distance := reads[baselineIndex].GetTime().Sub(mealTime); distance > apimodel.MAX_READ_INTERVAL || distance < -1*apimodel.MAX_READ_INTERVAL {
+		return response, false
+	}
+
+	baseline := reads[baselineIndex].GetMgPerDlValue()
+	response = model.MealResponse{MealTime: mealTime, Baseline: baseline, Peak: baseline, ReadCount: 1}
+
+	previousTime, previousExcursion := reads[baselineIndex].GetTime(), float32(0)
+	for _, read := range reads[baselineIndex+1:] {
+		readTime := read.GetTime()
+		if readTime.After(end) {
+			break
+		}
+
+		value := read.GetMgPerDlValue()
+		if value > response.Peak {
+			response.Peak = value
+			response.TimeToPeak = readTime.Sub(mealTime)
+		}
+
+		excursion := value - baseline
+		if excursion < 0 {
+			excursion = 0
+		}
+
+		// Gaps in the reads don't count toward the area
+		if interval := readTime.Sub(previousTime); interval <= apimodel.MAX_READ_INTERVAL {
+			response.AreaAboveBaseline += float64(previousExcursion+excursion) / 2 * interval.Minutes()
+		}
+
+		previousTime, previousExcursion = readTime, excursion
+		response.ReadCount++
+	}
+
+	response.Excursion = response.Peak - baseline
+	return response, true
+}
+
+// MealResponsesByHour aggregates responses by the hour of the day, in the local time of the meals
+func MealResponsesByHour(responses []model.MealResponse) (byHour []model.HourlyMealResponse) {
+	var hours [24]model.HourlyMealResponse
+	var timesToPeak [24]time.Duration
+
+	for _, response := range responses {
+		hour := response.MealTime.Hour()
+		hours[hour].MealCount++
+		hours[hour].MeanExcursion += float64(response.Excursion)
+		hours[hour].MeanAreaAboveBaseline += response.AreaAboveBaseline
+		timesToPeak[hour] += response.TimeToPeak
+	}
+
+	byHour = make([]model.HourlyMealResponse, 0)
+	for hour := range hours {
+		if count := hours[hour].MealCount; count > 0 {
+			hours[hour].Hour = hour
+			hours[hour].MeanExcursion /= float64(count)
+			hours[hour].MeanAreaAboveBaseline /= float64(count)
+			hours[hour].MeanTimeToPeak = timesToPeak[hour] / time.Duration(count)
+			byHour = append(byHour, hours[hour])
+		}
+	}
+
+	return byHour
+}
+
+// MealResponsesByFat aggregates responses by fat content, the low fat group coming first. Meals without a known fat
+// content are in the low fat group.
+func MealResponsesByFat(responses []model.MealResponse) (byFat []model.FatMealResponse) {
+	var groups [2]model.FatMealResponse
+	var timesToPeak [2]time.Duration
+
+	for _, response := range responses {
+		group := 0
+		if response.Fat >= HIGH_FAT_MEAL_THRESHOLD {
+			group = 1
+		}
+
+		groups[group].MealCount++
+		groups[group].MeanExcursion += float64(response.Excursion)
+		groups[group].MeanAreaAboveBaseline += response.AreaAboveBaseline
+		timesToPeak[group] += response.TimeToPeak
+	}
+
+	byFat = make([]model.FatMealResponse, 0)
+	for group := range groups {
+		if count := groups[group].MealCount; count > 0 {
+			groups[group].HighFat = group == 1
+			groups[group].MeanExcursion /= float64(count)
+			groups[group].MeanAreaAboveBaseline /= float64(count)
+			groups[group].MeanTimeToPeak = timesToPeak[group] / time.Duration(count)
+			byFat = append(byFat, groups[group])
+		}
+	}
+
+	return byFat
+}
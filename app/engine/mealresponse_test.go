@@ -0,0 +1,118 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"testing"
+	"time"
+)
+
+func generateMeal(mealTime time.Time, carbohydrates float32) apimodel.Meal {
+	return apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(mealTime), "America/Los_Angeles"}, carbohydrates, 0, 0, 0, 0, "", "", false, false}
+}
+
+func TestMealResponsesOf(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 07:00")
+	reads := generateReads(ct.Add(-5*time.Minute), 98, 100, 120, 160, 190, 170, 130, 100, 90)
+	meals := []apimodel.Meal{generateMeal(ct, 45)}
+
+	responses := engine.MealResponsesOf(meals, reads)
+	if len(responses) != 1 {
+		t.Fatalf("TestMealResponsesOf failed: expected 1 response but got [%v]", responses)
+	}
+
+	response := responses[0]
+	if response.Baseline != 100 || response.Peak != 190 || response.Excursion != 90 {
+		t.Errorf("TestMealResponsesOf failed: expected baseline of 100 and peak of 190 but got [%v]", response)
+	}
+
+	if response.TimeToPeak != 15*time.Minute {
+		t.Errorf("TestMealResponsesOf failed: expected time to peak of 15m but got [%v]", response.TimeToPeak)
+	}
+
+	// Trapezoids of excursions 0, 20, 60, 90, 70, 30, 0, 0 over 5 minutes each
+	if expected := 5. * (10 + 40 + 75 + 80 + 50 + 15); !isClose(response.AreaAboveBaseline, expected) {
+		t.Errorf("TestMealResponsesOf failed: expected area of [%f] but got [%f]", expected, response.AreaAboveBaseline)
+	}
+
+	if response.Carbohydrates != 45 || response.MealCount != 1 || response.IsConfounded() {
+		t.Errorf("TestMealResponsesOf failed: expected a single meal of 45g but got [%v]", response)
+	}
+}
+
+func TestMealResponsesOfMergesConfoundedMeals(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 07:00")
+	reads := generateReads(ct, 100, 150, 200, 150, 120)
+	meals := []apimodel.Meal{generateMeal(ct, 45), generateMeal(ct.Add(time.Hour), 20), generateMeal(ct.Add(3*time.Hour), 30)}
+
+	responses := engine.MealResponsesOf(meals, reads)
+	if len(responses) != 1 {
+		t.Fatalf("TestMealResponsesOfMergesConfoundedMeals failed: expected 1 response but got [%v]", responses)
+	}
+
+	if !responses[0].IsConfounded() || responses[0].MealCount != 2 || responses[0].Carbohydrates != 65 {
+		t.Errorf("TestMealResponsesOfMergesConfoundedMeals failed: expected 2 merged meals of 65g but got [%v]", responses[0])
+	}
+}
+
+func TestMealResponsesOfSkipsMealsWithoutBaseline(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 07:00")
+	reads := generateReads(ct.Add(30*time.Minute), 100, 150, 200)
+	meals := []apimodel.Meal{generateMeal(ct, 45)}
+
+	if responses := engine.MealResponsesOf(meals, reads); len(responses) != 0 {
+		t.Errorf("TestMealResponsesOfSkipsMealsWithoutBaseline failed: expected no response but got [%v]", responses)
+	}
+}
+
+func TestMealResponsesByHour(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 07:00")
+	reads := generateReads(ct, 100, 150, 110)
+	reads = append(reads, generateReads(ct.AddDate(0, 0, 1), 100, 200, 120)...)
+	meals := []apimodel.Meal{generateMeal(ct, 30), generateMeal(ct.AddDate(0, 0, 1), 60)}
+
+	byHour := engine.MealResponsesByHour(engine.MealResponsesOf(meals, reads))
+	if len(byHour) != 1 {
+		t.Fatalf("TestMealResponsesByHour failed: expected a single hour but got [%v]", byHour)
+	}
+
+	if byHour[0].MealCount != 2 || !isClose(byHour[0].MeanExcursion, 75) || byHour[0].MeanTimeToPeak != 5*time.Minute {
+		t.Errorf("TestMealResponsesByHour failed: expected 2 meals with a mean excursion of 75 at 5m but got [%v]", byHour[0])
+	}
+}
+
+func TestMealResponsesByFat(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 07:00")
+	reads := generateReads(ct, 100, 150, 110)
+	reads = append(reads, generateReads(ct.AddDate(0, 0, 1), 100, 200, 120)...)
+	reads = append(reads, generateReads(ct.AddDate(0, 0, 2), 100, 120, 180)...)
+
+	highFatMeal := generateMeal(ct.AddDate(0, 0, 2), 60)
+	highFatMeal.Fat = 35
+	meals := []apimodel.Meal{generateMeal(ct, 30), generateMeal(ct.AddDate(0, 0, 1), 60), highFatMeal}
+
+	byFat := engine.MealResponsesByFat(engine.MealResponsesOf(meals, reads))
+	if len(byFat) != 2 {
+		t.Fatalf("TestMealResponsesByFat failed: expected two groups but got [%v]", byFat)
+	}
+
+	if byFat[0].HighFat || byFat[0].MealCount != 2 || !isClose(byFat[0].MeanExcursion, 75) {
+		t.Errorf("TestMealResponsesByFat failed: expected 2 low fat meals with a mean excursion of 75 but got [%v]", byFat[0])
+	}
+
+	if !byFat[1].HighFat || byFat[1].MealCount != 1 || !isClose(byFat[1].MeanExcursion, 80) || byFat[1].MeanTimeToPeak != 10*time.Minute {
+		t.Errorf("TestMealResponsesByFat failed: expected a high fat meal with an excursion of 80 at 10m but got [%v]", byFat[1])
+	}
+}
+
+func TestMealResponsesOfSkipsDeletedMeals(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 07:00")
+	reads := generateReads(ct, 100, 150, 110)
+
+	deletedMeal := generateMeal(ct, 150)
+	deletedMeal.Deleted = true
+
+	if responses := engine.MealResponsesOf([]apimodel.Meal{deletedMeal}, reads); len(responses) != 0 {
+		t.Errorf("TestMealResponsesOfSkipsDeletedMeals failed: expected no response but got [%v]", responses)
+	}
+}
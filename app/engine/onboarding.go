@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/channel"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+// Type of the channel message sent to the user on each transition of their onboarding
+const ONBOARDING_MESSAGE_TYPE = "onboarding"
+
+type onboardingMessage struct {
+	Type  string                `json:"type"`
+	State model.OnboardingState `json:"state"`
+}
+
+// AdvanceOnboarding applies the transition to the onboarding state of the user and sends the new state to the user's
+// connected client if it changed. Users who are done onboarding are left alone. Failures are only logged, onboarding
+// being informational and catching up with the next transition.
+func AdvanceOnboarding(context context.Context, userEmail string, transition func(state *model.OnboardingState) bool) {
+	userProfileKey := store.GetUserKey(context, userEmail)
+	if glukitUser, err := store.GetUserProfileCached(context, userProfileKey); err != nil || !glukitUser.GetOnboardingState().IsOnboarding() {
+		return
+	}
+
+	state, changed, err := store.UpdateOnboarding(context, userProfileKey, transition)
+	if err != nil {
+		log.Warningf(context, "Error updating onboarding state of user [%s]: %v", userEmail, err)
+		return
+	} else if !changed {
+		return
+	}
+
+	log.Infof(context, "Onboarding of user [%s] is now [%s] with [%d/%d] files imported and [%d] failed", userEmail, state.Step,
+		state.FilesImported, state.FilesFound, state.FilesFailed)
+	message := onboardingMessage{ONBOARDING_MESSAGE_TYPE, state}
+	if err := channel.SendJSON(context, userEmail, message); err != nil {
+		log.Debugf(context, "Error sending onboarding state [%v] to user [%s]: %v", message, userEmail, err)
+	}
+}
+
+// finishOnboardingScoring moves the user to ready if they were waiting on the scoring of their first imports
+func finishOnboardingScoring(context context.Context, userEmail string) {
+	AdvanceOnboarding(context, userEmail, func(state *model.OnboardingState) bool {
+		return state.FinishScoring(time.Now())
+	})
+}
@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"math"
+	"time"
+)
+
+const (
+	// Minimum share, in percent, of a night covered by reads for it to be classified
+	MIN_NIGHT_COVERAGE = 50.
+	// Minimum time spent above the high threshold without interruption for a night to count as a sustained high
+	SUSTAINED_HIGH_DURATION = time.Hour
+)
+
+// OvernightWindow is the part of the night analyzed, as offsets from local midnight. A start after the end makes
+// the window start on the evening before.
+type OvernightWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// DEFAULT_OVERNIGHT_WINDOW is from midnight to 6am
+var DEFAULT_OVERNIGHT_WINDOW = OvernightWindow{0, 6 * time.Hour}
+
+// bounds returns the start and end of the night ending on the day of the given local midnight
+func (window OvernightWindow) bounds(midnight time.Time) (start, end time.Time) {
+	end = midnight.Add(window.End)
+	if window.Start >= window.End {
+		return midnight.AddDate(0, 0, -1).Add(window.Start), end
+	}
+
+	return midnight.Add(window.Start), end
+}
+
+// AnalyzeNights analyzes the nights of the user ending in the period, in the user's timezone, stores them so that they
+// can be listed with store.GetNights and returns their summary
+func AnalyzeNights(context context.Context, email string, period model.TimeRange, window OvernightWindow) (summary *model.OvernightSummary, err error) {
+	lowerBound, upperBound := period.LowerBound, period.UpperBound
+	_, glukitUser, err := store.GetGlukitUser(context, email)
+	if err != nil {
+		return nil, err
+	}
+
+	location := UserLocation(glukitUser)
+	firstDay := midnightOf(lowerBound.In(location))
+	firstStart, _ := window.bounds(firstDay)
+
+	reads, err := store.GetGlucoseReads(context, email, firstStart, upperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	nights := make([]model.Night, 0)
+	for day := firstDay; !day.After(upperBound); day = day.AddDate(0, 0, 1) {
+		if _, end := window.bounds(day); end.After(upperBound) {
+			break
+		}
+		nights = append(nights, NightOf(reads, day, window))
+	}
+
+	if _, err := store.StoreNights(context, store.GetUserKey(context, email), nights); err != nil {
+		return nil, err
+	}
+
+	summary = SummarizeNights(nights)
+	log.Infof(context, "Analyzed [%d] nights of user [%s] between [%s] and [%s]: [%d] good, [%d] high, [%d] low, [%d] mixed and [%d] excluded",
+		len(nights), email, lowerBound, upperBound, summary.Good, summary.High, summary.Low, summary.Mixed, summary.Excluded)
+	return summary, nil
+}
+
+// NightOf calculates the aggregates of the night ending on the day of the given local midnight from reads in
+// chronological order
+func NightOf(reads []apimodel.GlucoseRead, midnight time.Time, window OvernightWindow) (night model.Night) {
+	start, end := window.bounds(midnight)
+	night = model.Night{Date: midnight, Start: start, End: end}
+
+	nightReads := readsBetween(reads, start, end)
+	night.ReadCount = len(nightReads)
+	if len(nightReads) == 0 {
+		night.Excluded = true
+		return night
+	}
+
+	timeInRange := TimeInRangeOfReads(nightReads, DEFAULT_TARGET_LOW, DEFAULT_TARGET_HIGH)
+	night.Coverage = math.Min(100, 100*float64(timeInRange.Covered)/float64(end.Sub(start)))
+	if night.Coverage < MIN_NIGHT_COVERAGE {
+		night.Excluded = true
+		return night
+	}
+
+	night.Mean, _ = meanGlucose(nightReads)
+	night.Min, night.Max = nightReads[0].GetMgPerDlValue(), nightReads[0].GetMgPerDlValue()
+	for _, read := range nightReads {
+		value := read.GetMgPerDlValue()
+		night.Min = float32(math.Min(float64(night.Min), float64(value)))
+		night.Max = float32(math.Max(float64(night.Max), float64(value)))
+	}
+
+	night.HadHypo = len(DetectHypoEvents(nightReads, DEFAULT_HYPO_THRESHOLD, DEFAULT_HYPO_MIN_DURATION)) > 0
+	night.SustainedHigh = longestTimeAbove(nightReads, DEFAULT_TARGET_HIGH) >= SUSTAINED_HIGH_DURATION
+
+	switch {
+	case night.HadHypo && night.SustainedHigh:
+		night.Class = model.NIGHT_MIXED
+	case night.HadHypo:
+		night.Class = model.NIGHT_LOW
+	case night.SustainedHigh:
+		night.Class = model.NIGHT_HIGH
+	default:
+		night.Class = model.NIGHT_GOOD
+	}
+
+	return night
+}
+
+// SummarizeNights counts nights by class
+func SummarizeNights(nights []model.Night) (summary *model.OvernightSummary) {
+	summary = &model.OvernightSummary{Nights: nights, Unit: apimodel.MG_PER_DL}
+	for _, night := range nights {
+		if night.Excluded {
+			summary.Excluded++
+			continue
+		}
+
+		switch night.Class {
+		case model.NIGHT_GOOD:
+			summary.Good++
+		case model.NIGHT_HIGH:
+			summary.High++
+		case model.NIGHT_LOW:
+			summary.Low++
+		case model.NIGHT_MIXED:
+			summary.Mixed++
+		}
+	}
+
+	return summary
+}
+
+// longestTimeAbove returns the longest time spent above the threshold, in mg/dL, by reads in chronological order. A
+// gap longer than apimodel.MAX_READ_INTERVAL interrupts the time spent above.
+func longestTimeAbove(reads []apimodel.GlucoseRead, threshold float32) (longest time.Duration) {
+	var runStart, previous time.Time
+	inRun := false
+
+	for _, read := range reads {
+		readTime := read.GetTime()
+		above := read.GetMgPerDlValue() > threshold
+
+		if inRun && (!above || readTime.Sub(previous) > apimodel.MAX_READ_INTERVAL) {
+			inRun = false
+		}
+
+		if above {
+			if !inRun {
+				runStart, inRun = readTime, true
+			}
+			if runLength := readTime.Sub(runStart); runLength > longest {
+				longest = runLength
+			}
+		}
+		previous = readTime
+	}
+
+	return longest
+}
+
+// midnightOf returns the midnight of the day of t, in the location of t
+func midnightOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
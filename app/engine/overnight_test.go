@@ -0,0 +1,94 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"testing"
+	"time"
+)
+
+// generateNightReads generates count reads of the given value followed by reads of 100 mg/dL to fill the rest of
+// a night of 6 hours starting at start
+func generateNightReads(start time.Time, value float32, count int) []apimodel.GlucoseRead {
+	values := make([]float32, 72)
+	for i := range values {
+		values[i] = 100
+		if i < count {
+			values[i] = value
+		}
+	}
+
+	return generateReads(start, values...)
+}
+
+func TestNightOf(t *testing.T) {
+	midnight, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+
+	var tests = []struct {
+		name     string
+		reads    []apimodel.GlucoseRead
+		expected model.NightClass
+	}{
+		{"good", generateNightReads(midnight, 150, 12), model.NIGHT_GOOD},
+		{"high", generateNightReads(midnight, 220, 13), model.NIGHT_HIGH},
+		{"low", generateNightReads(midnight, 55, 6), model.NIGHT_LOW},
+		{"mixed", append(generateNightReads(midnight, 55, 6)[:36], generateNightReads(midnight.Add(3*time.Hour), 220, 13)[:36]...), model.NIGHT_MIXED},
+	}
+
+	for _, test := range tests {
+		night := engine.NightOf(test.reads, midnight, engine.DEFAULT_OVERNIGHT_WINDOW)
+		if night.Excluded || night.Class != test.expected {
+			t.Errorf("TestNightOf failed for [%s] night: got class [%s] (excluded: %t) but expected [%s]", test.name, night.Class, night.Excluded, test.expected)
+		}
+	}
+}
+
+func TestNightOfAggregates(t *testing.T) {
+	midnight, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := generateNightReads(midnight, 190, 36)
+
+	night := engine.NightOf(reads, midnight, engine.DEFAULT_OVERNIGHT_WINDOW)
+	if night.ReadCount != 72 || !isClose(night.Coverage, 100) {
+		t.Errorf("TestNightOfAggregates failed: got [%d] reads covering [%f]%%", night.ReadCount, night.Coverage)
+	}
+
+	if !isClose(night.Mean, 145) || night.Min != 100 || night.Max != 190 {
+		t.Errorf("TestNightOfAggregates failed: got mean [%f], min [%f] and max [%f]", night.Mean, night.Min, night.Max)
+	}
+}
+
+func TestNightOfExcludesInsufficientCoverage(t *testing.T) {
+	midnight, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := generateNightReads(midnight, 100, 0)[:30]
+
+	night := engine.NightOf(reads, midnight, engine.DEFAULT_OVERNIGHT_WINDOW)
+	if !night.Excluded {
+		t.Errorf("TestNightOfExcludesInsufficientCoverage failed: expected night covered at [%f]%% to be excluded", night.Coverage)
+	}
+}
+
+func TestNightOfWindowStartingTheEveningBefore(t *testing.T) {
+	midnight, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	window := engine.OvernightWindow{22 * time.Hour, 2 * time.Hour}
+	reads := generateNightReads(midnight.Add(-2*time.Hour), 100, 0)[:48]
+
+	night := engine.NightOf(reads, midnight, window)
+	if !night.Start.Equal(midnight.Add(-2*time.Hour)) || !night.End.Equal(midnight.Add(2*time.Hour)) || night.ReadCount != 48 {
+		t.Errorf("TestNightOfWindowStartingTheEveningBefore failed: got [%d] reads from [%s] to [%s]", night.ReadCount, night.Start, night.End)
+	}
+}
+
+func TestSummarizeNights(t *testing.T) {
+	nights := []model.Night{
+		model.Night{Class: model.NIGHT_GOOD},
+		model.Night{Class: model.NIGHT_GOOD},
+		model.Night{Class: model.NIGHT_HIGH},
+		model.Night{Class: model.NIGHT_MIXED},
+		model.Night{Excluded: true}}
+
+	summary := engine.SummarizeNights(nights)
+	if summary.Good != 2 || summary.High != 1 || summary.Low != 0 || summary.Mixed != 1 || summary.Excluded != 1 {
+		t.Errorf("TestSummarizeNights failed: got [%v]", summary)
+	}
+}
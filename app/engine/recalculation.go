@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/channel"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+const (
+	// How long a recalculation holds its lock without making progress before another one can take over
+	RECALCULATION_LOCK_TIMEOUT = 10 * time.Minute
+	// Type of the channel message reporting the progress of a recalculation
+	RECALCULATION_PROGRESS_TYPE = "recalculationProgress"
+)
+
+type recalculationProgressMessage struct {
+	Type      string `json:"type"`
+	Days      int    `json:"days"`
+	TotalDays int    `json:"totalDays"`
+	Message   string `json:"message"`
+}
+
+// StartRecalculation recalculates all GlukitScores and A1C estimates of a user for periods ending on or after from. Scores
+// and estimates previously stored for those periods are deleted first so that periods left without data don't keep a
+// stale value. The stats of the days of reads since from are computed again with the user's target range. Only one
+// recalculation runs at a time for a given user, StartRecalculation returns store.ErrRecalculationInProgress if another
+// one is still running.
+func StartRecalculation(context context.Context, glukitUser *model.GlukitUser, from time.Time) (err error) {
+	now := time.Now()
+	recalculation := model.Recalculation{From: from, To: now, StartedOn: now, ExpiresOn: now.Add(RECALCULATION_LOCK_TIMEOUT)}
+	if err := store.AcquireRecalculationLock(context, glukitUser.Email, recalculation); err != nil {
+		return err
+	}
+
+	if err := refreshSince(context, glukitUser, from); err != nil {
+		if releaseErr := store.ReleaseRecalculationLock(context, glukitUser.Email); releaseErr != nil {
+			log.Warningf(context, "Error releasing recalculation lock of user [%s]: %v", glukitUser.Email, releaseErr)
+		}
+		return err
+	}
+
+	// Start one day before so that the first period recalculated is the one ending on from
+	lowerBound := from.AddDate(0, 0, -1)
+	if err := startGlukitScoreBatchFrom(context, glukitUser.Email, lowerBound); err != nil {
+		return err
+	}
+
+	return startA1CCalculationBatchFrom(context, glukitUser.Email, lowerBound)
+}
+
+// refreshSince clears the scores of periods ending on or after from and computes the stats of the days of reads since
+// from again
+func refreshSince(context context.Context, glukitUser *model.GlukitUser, from time.Time) (err error) {
+	if err := clearScoresSince(context, glukitUser, from); err != nil {
+		return err
+	}
+
+	_, err = store.RefreshDaysOfStats(context, glukitUser.Email, from, glukitUser.TargetLow, glukitUser.TargetHigh)
+	return err
+}
+
+// clearScoresSince deletes the GlukitScores and A1C estimates of periods ending on or after from and resets the best
+// and most recent values of the user profile that refer to them
+func clearScoresSince(context context.Context, glukitUser *model.GlukitUser, from time.Time) (err error) {
+	scoreCount, err := store.DeleteGlukitScores(context, glukitUser.Email, from)
+	if err != nil {
+		return err
+	}
+
+	a1cCount, err := store.DeleteA1CEstimates(context, glukitUser.Email, from)
+	if err != nil {
+		return err
+	}
+
+	log.Infof(context, "Cleared [%d] glukit scores and [%d] a1c estimates of user [%s] since [%s]", scoreCount, a1cCount, glukitUser.Email, from)
+
+	if !glukitUser.BestScore.UpperBound.Before(from) {
+		glukitUser.BestScore = model.UNDEFINED_SCORE
+	}
+	if !glukitUser.MostRecentScore.UpperBound.Before(from) {
+		glukitUser.MostRecentScore = model.UNDEFINED_SCORE
+	}
+	if !glukitUser.MostRecentA1C.UpperBound.Before(from) {
+		glukitUser.MostRecentA1C = model.UNDEFINED_A1C_ESTIMATE
+	}
+
+	_, err = store.StoreUserProfile(context, time.Now(), *glukitUser)
+	return err
+}
+
+// reportRecalculationProgress sends the progress of the recalculation in progress, if any, to the user. The lock of the
+// recalculation is renewed as long as it makes progress and released once done.
+func reportRecalculationProgress(context context.Context, userEmail string, upTo time.Time, done bool) {
+	recalculation, err := store.GetRecalculation(context, userEmail)
+	if err != nil {
+		log.Warningf(context, "Error getting recalculation in progress for user [%s]: %v", userEmail, err)
+		return
+	} else if recalculation == nil {
+		return
+	}
+
+	days, totalDays := recalculation.Progress(upTo)
+	if done {
+		days = totalDays
+	}
+
+	message := recalculationProgressMessage{RECALCULATION_PROGRESS_TYPE, days, totalDays, fmt.Sprintf("recalculating %d/%d days", days, totalDays)}
+	if err := channel.SendJSON(context, userEmail, message); err != nil {
+		log.Debugf(context, "Error sending recalculation progress [%v] to user [%s]: %v", message, userEmail, err)
+	}
+
+	if done {
+		err = store.ReleaseRecalculationLock(context, userEmail)
+	} else {
+		err = store.RenewRecalculationLock(context, userEmail, time.Now().Add(RECALCULATION_LOCK_TIMEOUT))
+	}
+
+	if err != nil {
+		log.Warningf(context, "Error updating recalculation lock of user [%s]: %v", userEmail, err)
+	}
+}
@@ -0,0 +1,122 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"testing"
+	"time"
+)
+
+// generateRepeatedReads generates count reads cycling through values
+func generateRepeatedReads(start time.Time, count int, values ...float32) []apimodel.GlucoseRead {
+	repeated := make([]float32, count)
+	for i := range repeated {
+		repeated[i] = values[i%len(values)]
+	}
+
+	return generateReads(start, repeated...)
+}
+
+func TestIndividualReadScoreWeight(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+
+	var tests = []struct {
+		value    float32
+		expected float64
+	}{
+		{83, 0},
+		{100, 34},
+		{183, 200},
+		{73, 10},
+		{40, 43},
+	}
+
+	for _, test := range tests {
+		if weight, err := engine.IndividualReadScoreWeight(generateReads(ct, test.value)[0]); err != nil {
+			t.Errorf("TestIndividualReadScoreWeight failed for [%f]: %v", test.value, err)
+		} else if !isClose(weight, test.expected) {
+			t.Errorf("TestIndividualReadScoreWeight failed for [%f]: got weight of [%f] but expected [%f]", test.value, weight, test.expected)
+		}
+	}
+}
+
+func TestGlukitScoreOfReads(t *testing.T) {
+	lowerBound, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	upperBound := lowerBound.AddDate(0, 0, engine.GLUKIT_SCORE_PERIOD)
+
+	var tests = []struct {
+		name     string
+		reads    []apimodel.GlucoseRead
+		expected int64
+	}{
+		{"perfect", generateRepeatedReads(lowerBound, engine.READS_REQUIREMENT, 83), 0},
+		{"high", generateRepeatedReads(lowerBound, engine.READS_REQUIREMENT, 93), 34560},
+		{"low", generateRepeatedReads(lowerBound, engine.READS_REQUIREMENT, 73), 17280},
+		{"alternating", generateRepeatedReads(lowerBound, engine.READS_REQUIREMENT, 93, 73), 25920},
+		// Only the first READS_REQUIREMENT reads are scored
+		{"extra reads", append(generateRepeatedReads(lowerBound, engine.READS_REQUIREMENT, 93), generateRepeatedReads(upperBound, 100, 300)...), 34560},
+		{"insufficient reads", generateRepeatedReads(lowerBound, engine.READS_REQUIREMENT-1, 93), model.UNDEFINED_SCORE_VALUE},
+	}
+
+	for _, test := range tests {
+		score, err := engine.GlukitScoreOfReads(test.reads, lowerBound, upperBound, engine.DEFAULT_TARGET_LOW, engine.DEFAULT_TARGET_HIGH)
+		if err != nil {
+			t.Errorf("TestGlukitScoreOfReads failed for [%s]: %v", test.name, err)
+		} else if score.Value != test.expected {
+			t.Errorf("TestGlukitScoreOfReads failed for [%s]: got score of [%d] but expected [%d]", test.name, score.Value, test.expected)
+		}
+	}
+}
+
+func TestGlukitScoreOfReadsVariability(t *testing.T) {
+	lowerBound, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	upperBound := lowerBound.AddDate(0, 0, engine.GLUKIT_SCORE_PERIOD)
+
+	score, err := engine.GlukitScoreOfReads(generateRepeatedReads(lowerBound, engine.READS_REQUIREMENT, 100), lowerBound, upperBound,
+		engine.DEFAULT_TARGET_LOW, engine.DEFAULT_TARGET_HIGH)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A flat trace in range has a GVI of 1 and a PGS of 0
+	if !isClose(score.GVI, 1) || !isClose(score.PGS, 0) || !score.LowerBound.Equal(lowerBound) || !score.UpperBound.Equal(upperBound) {
+		t.Errorf("TestGlukitScoreOfReadsVariability failed: got [%v]", score)
+	}
+
+	// Out of a tight range, the same trace has the PGS of its mean
+	if score, _ = engine.GlukitScoreOfReads(generateRepeatedReads(lowerBound, engine.READS_REQUIREMENT, 100), lowerBound, upperBound, 70, 90); !isClose(score.PGS, 100) {
+		t.Errorf("TestGlukitScoreOfReadsVariability failed: expected PGS of [%f] out of range but got [%f]", 100., score.PGS)
+	}
+}
+
+func TestA1CEstimateOfReads(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	formulas := []engine.A1CFormula{engine.ADAGA1CFormula{}, engine.GMIA1CFormula{}}
+
+	var tests = []struct {
+		value float32
+		adag  float64
+		gmi   float64
+	}{
+		{100, 5.1115, 5.702},
+		{154, 6.993, 6.9937},
+		{250, 10.338, 9.29},
+	}
+
+	for _, test := range tests {
+		a1c, err := engine.A1CEstimateOfReads(generateRepeatedReads(ct, 288*91, test.value), formulas)
+		if err != nil {
+			t.Errorf("TestA1CEstimateOfReads failed for [%f]: %v", test.value, err)
+			continue
+		}
+
+		if a1c.Formula != engine.A1C_ADAG_FORMULA || !isClose(a1c.Value, test.adag) || !isClose(a1c.Estimates[1].Value, test.gmi) {
+			t.Errorf("TestA1CEstimateOfReads failed for [%f]: got [%v] but expected adag of [%f] and gmi of [%f]", test.value, a1c.Estimates, test.adag, test.gmi)
+		}
+	}
+
+	if a1c, err := engine.A1CEstimateOfReads(generateRepeatedReads(ct, 288*89, 100), formulas); err == nil {
+		t.Errorf("TestA1CEstimateOfReads failed: expected error with insufficient coverage but got [%v]", a1c)
+	}
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+const (
+	// Default target range, in mg/dL, of time in range calculations
+	DEFAULT_TARGET_LOW  = apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD
+	DEFAULT_TARGET_HIGH = apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD
+)
+
+// CalculateTimeInRange calculates the share of time the user spent below, in and above the target range, in mg/dL,
+// from the reads of the period
+func CalculateTimeInRange(context context.Context, userEmail string, period model.TimeRange, targetLow, targetHigh float32) (timeInRange *model.TimeInRange, err error) {
+	lowerBound, upperBound := period.LowerBound, period.UpperBound
+	log.Debugf(context, "Getting reads for time in range calculation from [%s] to [%s]", lowerBound, upperBound)
+	reads, err := store.GetGlucoseReads(context, userEmail, lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	timeInRange = TimeInRangeOfReads(reads, targetLow, targetHigh)
+	timeInRange.LowerBound, timeInRange.UpperBound = lowerBound, upperBound
+	log.Infof(context, "Time in range of [%s] from [%s] to [%s] is [%f]%% over [%v]", userEmail, lowerBound, upperBound,
+		timeInRange.InRange, timeInRange.Covered)
+	return timeInRange, nil
+}
+
+// TimeInRangeOfReads calculates the share of time spent below, in and above the target range from reads in
+// chronological order. Each read stands for the time until the next read, up to apimodel.MAX_READ_INTERVAL, so that
+// sensor gaps aren't counted. The last read stands for apimodel.DEFAULT_READ_INTERVAL.
+func TimeInRangeOfReads(reads []apimodel.GlucoseRead, targetLow, targetHigh float32) (timeInRange *model.TimeInRange) {
+	timeInRange = &model.TimeInRange{TargetLow: targetLow, TargetHigh: targetHigh}
+
+	var below, inRange, above time.Duration
+	for i, read := range reads {
+		value, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			continue
+		}
+
+		interval := apimodel.DEFAULT_READ_INTERVAL
+		if i+1 < len(reads) {
+			interval = reads[i+1].GetTime().Sub(read.GetTime())
+		}
+		if interval > apimodel.MAX_READ_INTERVAL {
+			interval = apimodel.MAX_READ_INTERVAL
+		}
+
+		if value < targetLow {
+			below += interval
+		} else if value > targetHigh {
+			above += interval
+		} else {
+			inRange += interval
+		}
+	}
+
+	timeInRange.Covered = below + inRange + above
+	if timeInRange.Covered > 0 {
+		timeInRange.Below = 100 * float64(below) / float64(timeInRange.Covered)
+		timeInRange.InRange = 100 * float64(inRange) / float64(timeInRange.Covered)
+		timeInRange.Above = 100 * float64(above) / float64(timeInRange.Covered)
+	}
+
+	return timeInRange
+}
@@ -0,0 +1,63 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"math"
+	"testing"
+	"time"
+)
+
+func generateReads(start time.Time, values ...float32) []apimodel.GlucoseRead {
+	reads := make([]apimodel.GlucoseRead, len(values))
+	for i, value := range values {
+		readTime := start.Add(time.Duration(i*5) * time.Minute)
+		reads[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Los_Angeles"}, apimodel.MG_PER_DL, value}
+	}
+
+	return reads
+}
+
+func TestTimeInRangeOfReads(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := generateReads(ct, 60, 100, 100, 150, 190, 200)
+
+	timeInRange := engine.TimeInRangeOfReads(reads, engine.DEFAULT_TARGET_LOW, engine.DEFAULT_TARGET_HIGH)
+	if timeInRange.Covered != 30*time.Minute {
+		t.Errorf("TestTimeInRangeOfReads failed: got coverage of [%v] but expected [%v]", timeInRange.Covered, 30*time.Minute)
+	}
+
+	if !isClose(timeInRange.Below, 100./6) || !isClose(timeInRange.InRange, 50) || !isClose(timeInRange.Above, 100./3) {
+		t.Errorf("TestTimeInRangeOfReads failed: got [%f]%% below, [%f]%% in range and [%f]%% above", timeInRange.Below, timeInRange.InRange, timeInRange.Above)
+	}
+}
+
+func TestTimeInRangeDoesNotCountSensorGap(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := generateReads(ct, 100, 100, 100, 100)
+	reads = append(reads, generateReads(ct.Add(15*time.Minute+6*time.Hour), 250, 250, 250, 250)...)
+
+	timeInRange := engine.TimeInRangeOfReads(reads, engine.DEFAULT_TARGET_LOW, engine.DEFAULT_TARGET_HIGH)
+
+	// 3 reads of 5 minutes and one standing for at most MAX_READ_INTERVAL before the gap, 4 reads of 5 minutes after
+	expectedInRange := 15*time.Minute + apimodel.MAX_READ_INTERVAL
+	expectedCovered := expectedInRange + 20*time.Minute
+	if timeInRange.Covered != expectedCovered {
+		t.Errorf("TestTimeInRangeDoesNotCountSensorGap failed: got coverage of [%v] but expected [%v]", timeInRange.Covered, expectedCovered)
+	}
+
+	if expected := 100 * float64(expectedInRange) / float64(expectedCovered); !isClose(timeInRange.InRange, expected) {
+		t.Errorf("TestTimeInRangeDoesNotCountSensorGap failed: got [%f]%% in range but expected [%f]%%", timeInRange.InRange, expected)
+	}
+}
+
+func TestTimeInRangeWithoutReads(t *testing.T) {
+	timeInRange := engine.TimeInRangeOfReads(nil, engine.DEFAULT_TARGET_LOW, engine.DEFAULT_TARGET_HIGH)
+	if timeInRange.Covered != 0 || timeInRange.InRange != 0 {
+		t.Errorf("TestTimeInRangeWithoutReads failed: expected nothing covered but got [%v]", timeInRange)
+	}
+}
+
+func isClose(actual, expected float64) bool {
+	return math.Abs(actual-expected) < 0.001
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"time"
+)
+
+const (
+	// Period of the most recent reads the trend is calculated from
+	TREND_WINDOW = 20 * time.Minute
+
+	// Rates of change, in mg/dL/min, above which glucose is rising (or falling when negative) and rising fast
+	TREND_RATE_THRESHOLD      = 1.
+	TREND_FAST_RATE_THRESHOLD = 3.
+)
+
+// CalculateTrend calculates the trend of glucose from reads in chronological order. The rate of change is the slope of
+// the least squares line of the reads of the last TREND_WINDOW, reads before a gap longer than
+// apimodel.MAX_READ_INTERVAL being left out. The trend is unknown if fewer than two reads are left.
+func CalculateTrend(reads []apimodel.GlucoseRead) (trend model.Trend) {
+	trend = model.Trend{Arrow: model.TREND_UNKNOWN}
+	if len(reads) == 0 {
+		return trend
+	}
+
+	last := reads[len(reads)-1].GetTime()
+	start := len(reads) - 1
+	for start > 0 {
+		previous := reads[start-1].GetTime()
+		if last.Sub(previous) > TREND_WINDOW || reads[start].GetTime().Sub(previous) > apimodel.MAX_READ_INTERVAL {
+			break
+		}
+		start--
+	}
+
+	recentReads := reads[start:]
+	trend.ReadCount = len(recentReads)
+	if len(recentReads) < 2 {
+		return trend
+	}
+
+	rateOfChange, ok := slopeOf(recentReads)
+	if !ok {
+		return trend
+	}
+
+	trend.RateOfChange = rateOfChange
+	trend.Arrow = TrendArrowOf(trend.RateOfChange)
+	return trend
+}
+
+// TrendArrowOf returns the arrow of a rate of change, in mg/dL/min. Rates of exactly TREND_RATE_THRESHOLD or
+// TREND_FAST_RATE_THRESHOLD (positive or negative) get the slower arrow.
+func TrendArrowOf(rateOfChange float64) model.TrendArrow {
+	switch {
+	case rateOfChange > TREND_FAST_RATE_THRESHOLD:
+		return model.TREND_DOUBLE_UP
+	case rateOfChange > TREND_RATE_THRESHOLD:
+		return model.TREND_UP
+	case rateOfChange >= -1*TREND_RATE_THRESHOLD:
+		return model.TREND_FLAT
+	case rateOfChange >= -1*TREND_FAST_RATE_THRESHOLD:
+		return model.TREND_DOWN
+	default:
+		return model.TREND_DOUBLE_DOWN
+	}
+}
+
+// slopeOf returns the slope, in mg/dL/min, of the least squares line of reads. It isn't defined for fewer than two
+// distinct read times.
+func slopeOf(reads []apimodel.GlucoseRead) (slope float64, ok bool) {
+	if len(reads) < 2 {
+		return 0, false
+	}
+
+	// Minutes since the first read as x
+	first := reads[0].GetTime()
+	var sumX, sumY, sumXY, sumXX float64
+	for _, read := range reads {
+		x := read.GetTime().Sub(first).Minutes()
+		y := float64(read.GetMgPerDlValue())
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	n := float64(len(reads))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+
+	return (n*sumXY - sumX*sumY) / denominator, true
+}
@@ -0,0 +1,68 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"testing"
+	"time"
+)
+
+func TestTrendArrowOf(t *testing.T) {
+	tests := []struct {
+		rateOfChange  float64
+		expectedArrow model.TrendArrow
+	}{
+		{4, model.TREND_DOUBLE_UP},
+		{3.01, model.TREND_DOUBLE_UP},
+		{3, model.TREND_UP},
+		{1.01, model.TREND_UP},
+		{1, model.TREND_FLAT},
+		{0, model.TREND_FLAT},
+		{-1, model.TREND_FLAT},
+		{-1.01, model.TREND_DOWN},
+		{-3, model.TREND_DOWN},
+		{-3.01, model.TREND_DOUBLE_DOWN},
+		{-5, model.TREND_DOUBLE_DOWN},
+	}
+
+	for _, test := range tests {
+		if arrow := engine.TrendArrowOf(test.rateOfChange); arrow != test.expectedArrow {
+			t.Errorf("TestTrendArrowOf failed: expected [%s] for rate of [%f] but got [%s]", test.expectedArrow, test.rateOfChange, arrow)
+		}
+	}
+}
+
+func TestCalculateTrend(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	tests := []struct {
+		values        []float32
+		expectedRate  float64
+		expectedArrow model.TrendArrow
+		expectedReads int
+	}{
+		// Only the last 20 minutes count
+		{[]float32{300, 100, 110, 120, 130, 140}, 2, model.TREND_UP, 5},
+		{[]float32{100, 100, 100, 100, 100}, 0, model.TREND_FLAT, 5},
+		{[]float32{200, 180, 160, 140, 120}, -4, model.TREND_DOUBLE_DOWN, 5},
+		{[]float32{100}, 0, model.TREND_UNKNOWN, 1},
+		{nil, 0, model.TREND_UNKNOWN, 0},
+	}
+
+	for _, test := range tests {
+		trend := engine.CalculateTrend(generateReads(ct, test.values...))
+		if trend.Arrow != test.expectedArrow || !isClose(trend.RateOfChange, test.expectedRate) || trend.ReadCount != test.expectedReads {
+			t.Errorf("TestCalculateTrend failed: expected [%s] at [%f] from [%d] reads for [%v] but got [%v]", test.expectedArrow,
+				test.expectedRate, test.expectedReads, test.values, trend)
+		}
+	}
+}
+
+func TestCalculateTrendIgnoresReadsBeforeGap(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := generateReads(ct, 200, 190)
+	reads = append(reads, generateReads(ct.Add(25*time.Minute), 100)...)
+
+	if trend := engine.CalculateTrend(reads); trend.Arrow != model.TREND_UNKNOWN {
+		t.Errorf("TestCalculateTrendIgnoresReadsBeforeGap failed: expected unknown trend but got [%v]", trend)
+	}
+}
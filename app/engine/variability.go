@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"math"
+)
+
+// CalculateGVI calculates the Glycemic Variability Index of reads in chronological order. The GVI is the length of the
+// glucose trace, with time in minutes and glucose in mg/dL, divided by the length of a flat trace over the same time.
+// A steady trace has a GVI of 1 and the more glucose varies, the higher the GVI. Consecutive reads more than
+// apimodel.MAX_READ_INTERVAL apart are a gap in the trace and are left out of both lengths.
+func CalculateGVI(reads []apimodel.GlucoseRead) (gvi float64, err error) {
+	lineLength, idealLength := 0., 0.
+	for i := 1; i < len(reads); i++ {
+		interval := reads[i].GetTime().Sub(reads[i-1].GetTime())
+		if interval <= 0 || interval > apimodel.MAX_READ_INTERVAL {
+			continue
+		}
+
+		previous, err := reads[i-1].GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			return 0, err
+		}
+		current, err := reads[i].GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			return 0, err
+		}
+
+		minutes := interval.Minutes()
+		delta := float64(current - previous)
+		lineLength += math.Sqrt(minutes*minutes + delta*delta)
+		idealLength += minutes
+	}
+
+	if idealLength == 0 {
+		return 0, errors.New(fmt.Sprintf("Insufficient reads to calculate the GVI, got [%d] reads without any two consecutive ones less than [%v] apart", len(reads), apimodel.MAX_READ_INTERVAL))
+	}
+
+	return lineLength / idealLength, nil
+}
+
+// CalculatePGS calculates the Patient Glycemic Status of reads in chronological order. The PGS is the GVI multiplied by
+// the mean glucose, in mg/dL, and by the share of time spent out of the target range. Lower is better.
+//
+// This is a deliberate simplification of the published PGS, which multiplies piecewise functions of the GVI, of the mean
+// glucose and of the percent time in range, and weighs in hypoglycemia. It keeps the same inputs and ordering, steady
+// traces in range scoring best, but its values aren't on the published scale and are only meant to be compared with
+// each other and with the GlukitScore.
+func CalculatePGS(reads []apimodel.GlucoseRead, targetLow, targetHigh float32) (pgs float64, err error) {
+	gvi, err := CalculateGVI(reads)
+	if err != nil {
+		return 0, err
+	}
+
+	mean, err := meanGlucose(reads)
+	if err != nil {
+		return 0, err
+	}
+
+	timeInRange := TimeInRangeOfReads(reads, targetLow, targetHigh)
+	return gvi * mean * (1 - timeInRange.InRange/100), nil
+}
+
+// meanGlucose returns the mean value of reads, in mg/dL
+func meanGlucose(reads []apimodel.GlucoseRead) (mean float64, err error) {
+	if len(reads) == 0 {
+		return 0, errors.New("Insufficient reads to calculate the mean glucose, got no reads")
+	}
+
+	sum := 0.
+	for _, read := range reads {
+		value, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			return 0, err
+		}
+		sum += float64(value)
+	}
+
+	return sum / float64(len(reads)), nil
+}
+
+// variabilityOf returns the GVI and PGS, for the target range, of reads or zeroes if there aren't enough reads to
+// calculate them
+func variabilityOf(reads []apimodel.GlucoseRead, targetLow, targetHigh float32) (gvi, pgs float64) {
+	var err error
+	if gvi, err = CalculateGVI(reads); err != nil {
+		return 0, 0
+	}
+
+	if pgs, err = CalculatePGS(reads, targetLow, targetHigh); err != nil {
+		return gvi, 0
+	}
+
+	return gvi, pgs
+}
@@ -0,0 +1,131 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGVIOfSteadyTrace(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := generateReads(ct, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100)
+
+	if gvi, err := engine.CalculateGVI(reads); err != nil {
+		t.Fatal(err)
+	} else if !isClose(gvi, 1) {
+		t.Errorf("TestGVIOfSteadyTrace failed: got a GVI of [%f] but expected [1]", gvi)
+	}
+}
+
+func TestGVIOfVaryingTrace(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+
+	// Every 5 minute segment rises or falls by 5 mg/dL so its length is sqrt(5² + 5²) for an ideal length of 5
+	for _, reads := range [][]float32{{100, 105, 100, 105, 100, 105}, {100, 105, 110, 115, 120, 125}} {
+		if gvi, err := engine.CalculateGVI(generateReads(ct, reads...)); err != nil {
+			t.Fatal(err)
+		} else if !isClose(gvi, math.Sqrt2) {
+			t.Errorf("TestGVIOfVaryingTrace failed: got a GVI of [%f] for %v but expected [%f]", gvi, reads, math.Sqrt2)
+		}
+	}
+}
+
+func TestGVILeavesOutGaps(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := generateReads(ct, 100, 100, 100)
+	reads = append(reads, generateReads(ct.Add(6*time.Hour), 200, 200, 200)...)
+
+	if gvi, err := engine.CalculateGVI(reads); err != nil {
+		t.Fatal(err)
+	} else if !isClose(gvi, 1) {
+		t.Errorf("TestGVILeavesOutGaps failed: got a GVI of [%f] but expected [1]", gvi)
+	}
+}
+
+func TestGVIWithInsufficientReads(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	if gvi, err := engine.CalculateGVI(generateReads(ct, 100)); err == nil {
+		t.Errorf("TestGVIWithInsufficientReads failed: expected an error but got a GVI of [%f]", gvi)
+	}
+}
+
+func TestPGS(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	tests := []struct {
+		reads       []float32
+		expectedPGS float64
+	}{
+		// Steady and in range
+		{[]float32{100, 100, 100, 100}, 0},
+		// Steady but always above range: GVI of 1 × mean of 200 × 100% out of range
+		{[]float32{200, 200, 200, 200}, 200},
+		// GVI of sqrt(2) × mean of 180 × 25% of the time above range
+		{[]float32{175, 180, 185, 180}, math.Sqrt2 * 180 * 0.25},
+	}
+
+	for _, test := range tests {
+		if pgs, err := engine.CalculatePGS(generateReads(ct, test.reads...), engine.DEFAULT_TARGET_LOW, engine.DEFAULT_TARGET_HIGH); err != nil {
+			t.Fatal(err)
+		} else if !isClose(pgs, test.expectedPGS) {
+			t.Errorf("TestPGS failed: got a PGS of [%f] for %v but expected [%f]", pgs, test.reads, test.expectedPGS)
+		}
+	}
+}
+
+func TestGVIOfTraceWithIrregularIntervals(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+
+	// Segments of 3 minutes rising by 4 mg/dL and of 4 minutes falling by 3 mg/dL both have a length of 5, for a line
+	// length of 10 over an ideal length of 7 minutes
+	reads := []apimodel.GlucoseRead{
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct), "UTC"}, apimodel.MG_PER_DL, 100},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(3 * time.Minute)), "UTC"}, apimodel.MG_PER_DL, 104},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(7 * time.Minute)), "UTC"}, apimodel.MG_PER_DL, 101}}
+
+	if gvi, err := engine.CalculateGVI(reads); err != nil {
+		t.Fatal(err)
+	} else if !isClose(gvi, 10./7.) {
+		t.Errorf("TestGVIOfTraceWithIrregularIntervals failed: got a GVI of [%f] but expected [%f]", gvi, 10./7.)
+	}
+}
+
+func TestGVIOfReadsInMmolPerL(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+
+	// Line length is measured in mg/dL so a rise of 0.2775 mmol/L over 5 minutes is the same as one of 5 mg/dL
+	reads := []apimodel.GlucoseRead{
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct), "UTC"}, apimodel.MMOL_PER_L, 5.55},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(5 * time.Minute)), "UTC"}, apimodel.MMOL_PER_L, 5.8275}}
+
+	if gvi, err := engine.CalculateGVI(reads); err != nil {
+		t.Fatal(err)
+	} else if math.Abs(gvi-math.Sqrt2) > 0.01 {
+		t.Errorf("TestGVIOfReadsInMmolPerL failed: got a GVI of [%f] but expected [%f]", gvi, math.Sqrt2)
+	}
+}
+
+func TestPGSRanksSteadierTracesInRangeBetter(t *testing.T) {
+	// From steady and in range to swinging half of the time above range and then swinging above range all the time
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	traces := [][]float32{
+		{120, 120, 120, 120, 120, 120},
+		{170, 185, 170, 185, 170, 185},
+		{160, 200, 160, 200, 160, 200},
+		{200, 240, 200, 240, 200, 240},
+	}
+
+	previous := -1.
+	for _, trace := range traces {
+		pgs, err := engine.CalculatePGS(generateReads(ct, trace...), engine.DEFAULT_TARGET_LOW, engine.DEFAULT_TARGET_HIGH)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pgs <= previous {
+			t.Errorf("TestPGSRanksSteadierTracesInRangeBetter failed: got a PGS of [%f] for %v but expected more than [%f]", pgs, trace, previous)
+		}
+		previous = pgs
+	}
+}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"time"
+)
+
+// StartWeeklySummary queues up the summary of the previous week of the user unless it's already stored. It's meant to
+// be called on every refresh of the user's data so that the summary gets calculated once the week is over.
+func StartWeeklySummary(context context.Context, glukitUser *model.GlukitUser) (err error) {
+	location := UserLocation(glukitUser)
+	weekStart := StartOfWeek(time.Now().In(location)).AddDate(0, 0, -7)
+
+	if summary, err := store.GetWeeklySummary(context, glukitUser.Email, weekStart); err != nil {
+		return err
+	} else if summary != nil {
+		log.Debugf(context, "Weekly summary of [%s] already calculated for user [%s]", weekStart, glukitUser.Email)
+		return nil
+	}
+
+	task, err := RunWeeklySummaryCalculation.Task(glukitUser.Email, weekStart, location.String())
+	if err != nil {
+		log.Criticalf(context, "Couldn't schedule the execution of [%s] for user [%s]: %v", WEEKLY_SUMMARY_FUNCTION_NAME, glukitUser.Email, err)
+		return err
+	}
+	taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME)
+	log.Infof(context, "Queued up weekly summary for user [%s] and week starting [%s]", glukitUser.Email, weekStart.Format(util.TIMEFORMAT))
+
+	return nil
+}
+
+// CalculateWeeklySummary calculates the summary of the week starting at weekStart, in the given timezone, and stores it.
+// Summaries are keyed by the start of their week so running it again for the same week replaces the summary.
+func CalculateWeeklySummary(context context.Context, userEmail string, weekStart time.Time, timezone string) error {
+	location, err := util.GetOrLoadLocationForName(timezone)
+	if err != nil {
+		log.Warningf(context, "Unknown timezone [%s] for weekly summary of user [%s], using UTC: %v", timezone, userEmail, err)
+		location = time.UTC
+	}
+	weekStart = weekStart.In(location)
+	weekEnd := weekStart.AddDate(0, 0, 7)
+
+	_, glukitUser, err := store.GetGlukitUser(context, userEmail)
+	if err != nil {
+		return err
+	}
+
+	reads, err := store.GetGlucoseReads(context, userEmail, weekStart, weekEnd.Add(-1*time.Second))
+	if err != nil {
+		return err
+	}
+
+	summary := WeeklySummaryOfReads(weekStart, reads, glukitUser.TargetLow, glukitUser.TargetHigh)
+	summary.CalculatedOn = time.Now()
+
+	if meals, err := store.GetMeals(context, userEmail, weekStart, weekEnd.Add(-1*time.Second)); err != nil {
+		return err
+	} else {
+		summary.MealCount = len(meals)
+	}
+
+	if injections, err := store.GetInjections(context, userEmail, weekStart, weekEnd.Add(-1*time.Second)); err != nil {
+		return err
+	} else {
+		summary.InjectionCount = len(injections)
+	}
+
+	scores, err := store.GetGlukitScoreHistory(context, userEmail, weekStart.AddDate(0, 0, -7), weekEnd.Add(-1*time.Second))
+	if err != nil {
+		return err
+	}
+	summary.ScoreChange, summary.HasScoreChange = scoreChange(scores, weekStart)
+
+	if _, err := store.StoreWeeklySummary(context, store.GetUserKey(context, userEmail), summary); err != nil {
+		return err
+	}
+	log.Infof(context, "Stored weekly summary [%v] for user [%s]", summary, userEmail)
+	return nil
+}
+
+// WeeklySummaryOfReads calculates the glucose aggregates of the summary of the week starting at weekStart from reads in
+// chronological order. Days are split in the location of weekStart. Hypo events are detected below targetLow.
+func WeeklySummaryOfReads(weekStart time.Time, reads []apimodel.GlucoseRead, targetLow, targetHigh float32) (summary model.WeeklySummary) {
+	summary = model.WeeklySummary{WeekStart: weekStart, WeekEnd: weekStart.AddDate(0, 0, 7), Timezone: weekStart.Location().String()}
+	summary.ReadCount = len(reads)
+	if len(reads) == 0 {
+		return summary
+	}
+
+	summary.MeanGlucose, _ = meanGlucose(reads)
+	summary.TimeInRange = TimeInRangeOfReads(reads, targetLow, targetHigh).InRange
+	summary.HypoCount = len(DetectHypoEvents(reads, targetLow, DEFAULT_HYPO_MIN_DURATION))
+
+	first := true
+	for dayStart := weekStart; dayStart.Before(summary.WeekEnd); dayStart = dayStart.AddDate(0, 0, 1) {
+		dayReads := readsBetween(reads, dayStart, dayStart.AddDate(0, 0, 1))
+		if len(dayReads) == 0 {
+			continue
+		}
+
+		inRange := TimeInRangeOfReads(dayReads, targetLow, targetHigh).InRange
+		if first || inRange > summary.BestDayInRange {
+			summary.BestDay, summary.BestDayInRange = dayStart, inRange
+		}
+		if first || inRange < summary.WorstDayInRange {
+			summary.WorstDay, summary.WorstDayInRange = dayStart, inRange
+		}
+		first = false
+	}
+
+	return summary
+}
+
+// StartOfWeek returns Monday midnight of the week of t, in the location of t
+func StartOfWeek(t time.Time) time.Time {
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, t.Location())
+}
+
+// scoreChange returns the change of the user-facing score from the last score of the prior week to the last score of
+// the week starting at weekStart. Scores are expected in chronological order.
+func scoreChange(scores []model.GlukitScore, weekStart time.Time) (change int64, ok bool) {
+	var previous, current *int64
+	for _, score := range scores {
+		if score.UpperBound.Before(weekStart) {
+			previous = CalculateUserFacingScore(score)
+		} else {
+			current = CalculateUserFacingScore(score)
+		}
+	}
+
+	if previous == nil || current == nil {
+		return 0, false
+	}
+
+	return *current - *previous, true
+}
+
+// readsBetween returns the reads, in chronological order, at or after start and before end
+func readsBetween(reads []apimodel.GlucoseRead, start, end time.Time) []apimodel.GlucoseRead {
+	startIndex := len(reads)
+	for i, read := range reads {
+		if !read.GetTime().Before(start) {
+			startIndex = i
+			break
+		}
+	}
+
+	endIndex := startIndex
+	for endIndex < len(reads) && reads[endIndex].GetTime().Before(end) {
+		endIndex++
+	}
+
+	return reads[startIndex:endIndex]
+}
+
+// UserLocation returns the location of the user's timezone, falling back to the timezone of the user's most recent read
+// and then to UTC
+func UserLocation(glukitUser *model.GlukitUser) *time.Location {
+	for _, timezone := range []string{glukitUser.Timezone, glukitUser.MostRecentRead.Time.TimeZoneId} {
+		if timezone == "" {
+			continue
+		}
+
+		if location, err := util.GetOrLoadLocationForName(timezone); err == nil {
+			return location
+		}
+	}
+
+	return time.UTC
+}
@@ -0,0 +1,71 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/engine"
+	"testing"
+	"time"
+)
+
+func TestStartOfWeek(t *testing.T) {
+	location, _ := time.LoadLocation("America/Montreal")
+	tests := []struct {
+		t        time.Time
+		expected time.Time
+	}{
+		{time.Date(2014, 11, 5, 13, 0, 0, 0, location), time.Date(2014, 11, 3, 0, 0, 0, 0, location)},
+		{time.Date(2014, 11, 3, 0, 0, 0, 0, location), time.Date(2014, 11, 3, 0, 0, 0, 0, location)},
+		{time.Date(2014, 11, 9, 23, 59, 0, 0, location), time.Date(2014, 11, 3, 0, 0, 0, 0, location)},
+		// Monday midnight local is still Sunday in UTC
+		{time.Date(2014, 11, 10, 1, 0, 0, 0, location), time.Date(2014, 11, 10, 0, 0, 0, 0, location)},
+	}
+
+	for _, test := range tests {
+		if startOfWeek := engine.StartOfWeek(test.t); !startOfWeek.Equal(test.expected) {
+			t.Errorf("TestStartOfWeek failed: expected start of week of [%s] to be [%s] but got [%s]", test.t, test.expected, startOfWeek)
+		}
+	}
+}
+
+func TestWeeklySummaryOfReads(t *testing.T) {
+	weekStart := time.Date(2014, 4, 14, 0, 0, 0, 0, time.UTC)
+	reads := generateReads(weekStart.Add(8*time.Hour), 100, 110, 120, 130)
+	reads = append(reads, generateReads(weekStart.AddDate(0, 0, 2).Add(8*time.Hour), 200, 210, 60, 55, 50, 58, 120)...)
+
+	summary := engine.WeeklySummaryOfReads(weekStart, reads, engine.DEFAULT_TARGET_LOW, engine.DEFAULT_TARGET_HIGH)
+	if summary.ReadCount != 11 || summary.HypoCount != 1 {
+		t.Errorf("TestWeeklySummaryOfReads failed: expected 11 reads and 1 hypo but got [%v]", summary)
+	}
+
+	if !summary.BestDay.Equal(weekStart) || summary.BestDayInRange != 100 {
+		t.Errorf("TestWeeklySummaryOfReads failed: expected best day of [%s] fully in range but got [%s] at [%f]", weekStart,
+			summary.BestDay, summary.BestDayInRange)
+	}
+
+	if expected := weekStart.AddDate(0, 0, 2); !summary.WorstDay.Equal(expected) {
+		t.Errorf("TestWeeklySummaryOfReads failed: expected worst day of [%s] but got [%s]", expected, summary.WorstDay)
+	}
+
+	if !summary.WeekEnd.Equal(weekStart.AddDate(0, 0, 7)) {
+		t.Errorf("TestWeeklySummaryOfReads failed: expected week to end on [%s] but got [%s]", weekStart.AddDate(0, 0, 7), summary.WeekEnd)
+	}
+}
+
+func TestWeeklySummaryOfReadsWithTargetRange(t *testing.T) {
+	weekStart := time.Date(2014, 4, 14, 0, 0, 0, 0, time.UTC)
+	reads := generateReads(weekStart.Add(8*time.Hour), 100, 110, 120, 130)
+	reads = append(reads, generateReads(weekStart.AddDate(0, 0, 2).Add(8*time.Hour), 100, 85, 80, 75, 80, 85, 100)...)
+
+	summary := engine.WeeklySummaryOfReads(weekStart, reads, 80, 120)
+	if summary.HypoCount != 0 {
+		t.Errorf("TestWeeklySummaryOfReadsWithTargetRange failed: expected no hypo but got [%d]", summary.HypoCount)
+	}
+
+	if !summary.WorstDay.Equal(weekStart) || !isClose(summary.WorstDayInRange, 75) {
+		t.Errorf("TestWeeklySummaryOfReadsWithTargetRange failed: expected worst day of [%s] at 75%% in range but got [%s] at [%f]", weekStart,
+			summary.WorstDay, summary.WorstDayInRange)
+	}
+
+	if summary := engine.WeeklySummaryOfReads(weekStart, reads, 90, 140); summary.HypoCount != 1 {
+		t.Errorf("TestWeeklySummaryOfReadsWithTargetRange failed: expected 1 hypo below 90 but got [%d]", summary.HypoCount)
+	}
+}
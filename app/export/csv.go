@@ -0,0 +1,154 @@
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind of data exported, each one to its own csv file
+type Kind string
+
+const (
+	KIND_READS      Kind = "reads"
+	KIND_MEALS      Kind = "meals"
+	KIND_INJECTIONS Kind = "injections"
+	KIND_EXERCISES  Kind = "exercises"
+
+	// Layout of the time column, in the timezone of the user
+	TIME_COLUMN_LAYOUT = "2006-01-02 15:04:05"
+
+	CSV_FILE_EXTENSION = ".csv"
+)
+
+// Kinds exported when none is requested, in the order of their files
+var ALL_KINDS = []Kind{KIND_READS, KIND_MEALS, KIND_INJECTIONS, KIND_EXERCISES}
+
+// Header rows of the csv file of each kind. Columns are only ever added at the end so that spreadsheets referring to
+// them keep working. All files start with the time in the timezone of the user followed by the unix timestamp.
+var (
+	READS_COLUMNS      = []string{"time", "unix_time", "glucose", "unit"}
+	MEALS_COLUMNS      = []string{"time", "unix_time", "carbs", "protein", "fat", "saturated_fat", "fiber", "description"}
+	INJECTIONS_COLUMNS = []string{"time", "unix_time", "units", "insulin_name", "insulin_type", "category"}
+	EXERCISES_COLUMNS  = []string{"time", "unix_time", "duration_minutes", "intensity", "description"}
+)
+
+// ParseKinds parses a comma-separated list of kinds, returning ALL_KINDS if value is empty. Duplicates are ignored.
+func ParseKinds(value string) (kinds []Kind, err error) {
+	if len(strings.TrimSpace(value)) == 0 {
+		return ALL_KINDS, nil
+	}
+
+	kinds = make([]Kind, 0)
+	seen := make(map[Kind]bool)
+	for _, name := range strings.Split(value, ",") {
+		kind := Kind(strings.TrimSpace(name))
+		if _, known := kind.columns(); !known {
+			return nil, errors.New(fmt.Sprintf("Unknown kind [%s], should be one of %v", name, ALL_KINDS))
+		}
+
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+
+	return kinds, nil
+}
+
+// FileName returns the name of the csv file of the kind
+func (kind Kind) FileName() string {
+	return string(kind) + CSV_FILE_EXTENSION
+}
+
+// WriteHeader writes the header row of the csv file of the kind
+func (kind Kind) WriteHeader(w *csv.Writer) error {
+	columns, known := kind.columns()
+	if !known {
+		return errors.New(fmt.Sprintf("Unknown kind [%s]", kind))
+	}
+
+	return w.Write(columns)
+}
+
+func (kind Kind) columns() (columns []string, known bool) {
+	switch kind {
+	case KIND_READS:
+		return READS_COLUMNS, true
+	case KIND_MEALS:
+		return MEALS_COLUMNS, true
+	case KIND_INJECTIONS:
+		return INJECTIONS_COLUMNS, true
+	case KIND_EXERCISES:
+		return EXERCISES_COLUMNS, true
+	default:
+		return nil, false
+	}
+}
+
+// WriteGlucoseReads writes a row of READS_COLUMNS for each read, with its value in unit
+func WriteGlucoseReads(w *csv.Writer, reads []apimodel.GlucoseRead, location *time.Location, unit apimodel.GlucoseUnit) error {
+	for _, read := range reads {
+		value, err := read.GetNormalizedValue(unit)
+		if err != nil {
+			return err
+		}
+
+		if err := w.Write(append(timeColumns(read.GetTime(), location), formatFloat(value), string(unit))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteMeals writes a row of MEALS_COLUMNS for each meal
+func WriteMeals(w *csv.Writer, meals []apimodel.Meal, location *time.Location) error {
+	for _, meal := range meals {
+		row := append(timeColumns(meal.GetTime(), location), formatFloat(meal.Carbs), formatFloat(meal.Protein),
+			formatFloat(meal.Fat), formatFloat(meal.SaturatedFat), formatFloat(meal.Fiber), meal.Description)
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteInjections writes a row of INJECTIONS_COLUMNS for each injection
+func WriteInjections(w *csv.Writer, injections []apimodel.Injection, location *time.Location) error {
+	for _, injection := range injections {
+		row := append(timeColumns(injection.GetTime(), location), formatFloat(injection.Units), injection.InsulinName,
+			injection.InsulinType, string(injection.Category))
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteExercises writes a row of EXERCISES_COLUMNS for each exercise
+func WriteExercises(w *csv.Writer, exercises []apimodel.Exercise, location *time.Location) error {
+	for _, exercise := range exercises {
+		row := append(timeColumns(exercise.GetTime(), location), strconv.Itoa(exercise.DurationMinutes),
+			string(exercise.Intensity), exercise.Description)
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func timeColumns(value time.Time, location *time.Location) []string {
+	return []string{value.In(location).Format(TIME_COLUMN_LAYOUT), strconv.FormatInt(value.Unix(), 10)}
+}
+
+func formatFloat(value float32) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 32)
+}
@@ -0,0 +1,72 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/export"
+	"testing"
+	"time"
+)
+
+func TestParseKinds(t *testing.T) {
+	kinds, err := ParseKinds("meals, reads,meals")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(kinds) != 2 || kinds[0] != KIND_MEALS || kinds[1] != KIND_READS {
+		t.Errorf("Expected kinds [meals reads] but got %v", kinds)
+	}
+
+	if kinds, err := ParseKinds(""); err != nil || len(kinds) != len(ALL_KINDS) {
+		t.Errorf("Expected all kinds when none is requested but got %v: %v", kinds, err)
+	}
+
+	if _, err := ParseKinds("reads,basals"); err == nil {
+		t.Errorf("Expected an error for an unknown kind")
+	}
+}
+
+func TestWriteGlucoseReadsInUserTimezoneAndUnit(t *testing.T) {
+	location, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readTime := time.Date(2015, time.March, 7, 14, 30, 0, 0, time.UTC)
+	reads := []apimodel.GlucoseRead{apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, apimodel.MG_PER_DL, 100}}
+
+	var buffer bytes.Buffer
+	w := csv.NewWriter(&buffer)
+	if err := KIND_READS.WriteHeader(w); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteGlucoseReads(w, reads, location, apimodel.MMOL_PER_L); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+
+	expected := "time,unix_time,glucose,unit\n2015-03-07 09:30:00,1425738600,5.55,mmolPerL\n"
+	if buffer.String() != expected {
+		t.Errorf("Expected csv [%s] but got [%s]", expected, buffer.String())
+	}
+}
+
+func TestWriteMealsQuotesDescriptions(t *testing.T) {
+	mealTime := time.Date(2015, time.March, 7, 12, 0, 0, 0, time.UTC)
+	meals := []apimodel.Meal{apimodel.Meal{Time: apimodel.Time{apimodel.GetTimeMillis(mealTime), "UTC"}, Carbs: 45.5, Description: "Pasta, salad"}}
+
+	var buffer bytes.Buffer
+	w := csv.NewWriter(&buffer)
+	if err := WriteMeals(w, meals, time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+
+	expected := "2015-03-07 12:00:00,1425729600,45.5,0,0,0,0,\"Pasta, salad\"\n"
+	if buffer.String() != expected {
+		t.Errorf("Expected csv [%s] but got [%s]", expected, buffer.String())
+	}
+}
@@ -0,0 +1,221 @@
+package export
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"io"
+	"strings"
+	"time"
+)
+
+// Kind of events of a calendar feed
+type CalendarKind string
+
+const (
+	CALENDAR_KIND_MEALS     CalendarKind = "meals"
+	CALENDAR_KIND_EXERCISES CalendarKind = "exercises"
+	CALENDAR_KIND_HYPOS     CalendarKind = "hypos"
+
+	// Layouts of the times of timed events, in their timezone or in UTC, and of the dates of all-day events
+	ICS_LOCAL_TIME_LAYOUT = "20060102T150405"
+	ICS_UTC_TIME_LAYOUT   = "20060102T150405Z"
+	ICS_DATE_LAYOUT       = "20060102"
+
+	// Lines of a calendar are folded so that none is longer than ICS_MAX_LINE_OCTETS, as RFC 5545 requires
+	ICS_MAX_LINE_OCTETS = 75
+
+	ICS_PRODUCT_ID = "-//Glukit//Calendar Feed//EN"
+	ICS_UID_DOMAIN = "glukit.appspot.com"
+)
+
+// Kinds of a calendar feed when none is requested
+var ALL_CALENDAR_KINDS = []CalendarKind{CALENDAR_KIND_MEALS, CALENDAR_KIND_EXERCISES, CALENDAR_KIND_HYPOS}
+
+// CalendarEvent is an event of a calendar feed. Uid must stay the same across feeds so that calendar clients update
+// the event rather than add it again. Timed events start at Start, in its location, and end at End, the same as Start
+// for events that have no duration. All-day events span the days of Start to End, both inclusive.
+type CalendarEvent struct {
+	Uid         string
+	Summary     string
+	Description string
+	Start       time.Time
+	End         time.Time
+	AllDay      bool
+}
+
+// ParseCalendarKinds parses a comma-separated list of calendar kinds, returning ALL_CALENDAR_KINDS if value is empty.
+// Duplicates are ignored.
+func ParseCalendarKinds(value string) (kinds []CalendarKind, err error) {
+	if len(strings.TrimSpace(value)) == 0 {
+		return ALL_CALENDAR_KINDS, nil
+	}
+
+	kinds = make([]CalendarKind, 0)
+	seen := make(map[CalendarKind]bool)
+	for _, name := range strings.Split(value, ",") {
+		kind := CalendarKind(strings.TrimSpace(name))
+		if kind != CALENDAR_KIND_MEALS && kind != CALENDAR_KIND_EXERCISES && kind != CALENDAR_KIND_HYPOS {
+			return nil, errors.New(fmt.Sprintf("Unknown kind [%s], should be one of %v", name, ALL_CALENDAR_KINDS))
+		}
+
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+
+	return kinds, nil
+}
+
+// MealEvents returns an event without duration for each meal that wasn't deleted, in the timezone it was logged in.
+// userId tells apart the uids of events of different users.
+func MealEvents(userId string, meals []apimodel.Meal) []CalendarEvent {
+	events := make([]CalendarEvent, 0, len(meals))
+	for _, meal := range apimodel.MealSlice(meals).WithoutDeleted() {
+		summary := fmt.Sprintf("Meal: %sg carbs", formatFloat(meal.Carbs))
+		if len(meal.Description) > 0 {
+			summary = fmt.Sprintf("%s (%s)", summary, meal.Description)
+		}
+
+		start := meal.GetTime()
+		events = append(events, CalendarEvent{Uid: eventUid(userId, CALENDAR_KIND_MEALS, meal.Id), Summary: summary,
+			Description: meal.Description, Start: start, End: start})
+	}
+
+	return events
+}
+
+// ExerciseEvents returns an event for each exercise that wasn't deleted, in the timezone it was logged in. Exercises
+// logged without a duration only tell the day they happened on so they're all-day events.
+func ExerciseEvents(userId string, exercises []apimodel.Exercise) []CalendarEvent {
+	events := make([]CalendarEvent, 0, len(exercises))
+	for _, exercise := range apimodel.ExerciseSlice(exercises).WithoutDeleted() {
+		summary := "Exercise"
+		if exercise.DurationMinutes > 0 {
+			summary = fmt.Sprintf("Exercise: %d min", exercise.DurationMinutes)
+		}
+		if exercise.Intensity != apimodel.EXERCISE_INTENSITY_UNKNOWN {
+			summary = fmt.Sprintf("%s (%s)", summary, strings.ToLower(string(exercise.Intensity)))
+		}
+
+		start := exercise.GetTime()
+		events = append(events, CalendarEvent{Uid: eventUid(userId, CALENDAR_KIND_EXERCISES, exercise.Id), Summary: summary,
+			Description: exercise.Description, Start: start,
+			End: start.Add(time.Duration(exercise.DurationMinutes) * time.Minute), AllDay: exercise.DurationMinutes == 0})
+	}
+
+	return events
+}
+
+// HypoEvents returns an event spanning each hypo event in location since hypo events aren't stored with a timezone.
+// Their nadir is shown in unit.
+func HypoEvents(userId string, hypos []model.HypoEvent, location *time.Location, unit apimodel.GlucoseUnit) []CalendarEvent {
+	events := make([]CalendarEvent, 0, len(hypos))
+	for _, hypo := range hypos {
+		nadir := fmt.Sprintf("%.0f mg/dL", hypo.Nadir)
+		if unit == apimodel.MMOL_PER_L {
+			value, _ := apimodel.GlucoseRead{Unit: apimodel.MG_PER_DL, Value: hypo.Nadir}.GetNormalizedValue(unit)
+			nadir = fmt.Sprintf("%.1f mmol/L", value)
+		}
+
+		events = append(events, CalendarEvent{Uid: eventUid(userId, CALENDAR_KIND_HYPOS, fmt.Sprintf("%d", hypo.StartTime.Unix())),
+			Summary: fmt.Sprintf("Low: %s", nadir), Start: hypo.StartTime.In(location), End: hypo.EndTime.In(location)})
+	}
+
+	return events
+}
+
+// eventUid returns the uid of the event of the kind with the given id, unique to the user
+func eventUid(userId string, kind CalendarKind, id string) string {
+	return fmt.Sprintf("%s-%s-%s@%s", kind, id, userId, ICS_UID_DOMAIN)
+}
+
+// WriteCalendar writes a calendar of the events named name, stamped with now
+func WriteCalendar(w io.Writer, name string, events []CalendarEvent, now time.Time) error {
+	writer := &icsWriter{w: bufio.NewWriter(w)}
+	writer.line("BEGIN", "VCALENDAR")
+	writer.line("VERSION", "2.0")
+	writer.line("PRODID", ICS_PRODUCT_ID)
+	writer.line("CALSCALE", "GREGORIAN")
+	writer.line("METHOD", "PUBLISH")
+	writer.line("X-WR-CALNAME", escapeText(name))
+
+	stamp := now.UTC().Format(ICS_UTC_TIME_LAYOUT)
+	for _, event := range events {
+		writer.line("BEGIN", "VEVENT")
+		writer.line("UID", event.Uid)
+		writer.line("DTSTAMP", stamp)
+		if event.AllDay {
+			writer.line("DTSTART;VALUE=DATE", event.Start.Format(ICS_DATE_LAYOUT))
+			writer.line("DTEND;VALUE=DATE", event.End.AddDate(0, 0, 1).Format(ICS_DATE_LAYOUT))
+		} else {
+			writer.line(timeProperty("DTSTART", event.Start))
+			if event.End.After(event.Start) {
+				writer.line(timeProperty("DTEND", event.End))
+			}
+		}
+		writer.line("SUMMARY", escapeText(event.Summary))
+		if len(event.Description) > 0 {
+			writer.line("DESCRIPTION", escapeText(event.Description))
+		}
+		writer.line("END", "VEVENT")
+	}
+	writer.line("END", "VCALENDAR")
+
+	if writer.err != nil {
+		return writer.err
+	}
+
+	return writer.w.Flush()
+}
+
+// timeProperty returns the property of a time in its own timezone, or in UTC if it doesn't have a named one
+func timeProperty(name string, value time.Time) (property string, formatted string) {
+	location := value.Location().String()
+	if location == "UTC" || location == "Local" || len(location) == 0 {
+		return name, value.UTC().Format(ICS_UTC_TIME_LAYOUT)
+	}
+
+	return fmt.Sprintf("%s;TZID=%s", name, location), value.Format(ICS_LOCAL_TIME_LAYOUT)
+}
+
+// escapeText escapes the characters that have a meaning in text values
+func escapeText(value string) string {
+	return strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\r\n", "\\n", "\n", "\\n").Replace(value)
+}
+
+// icsWriter writes content lines, keeping the first error
+type icsWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// line writes a content line terminated by CRLF, folding it with a CRLF followed by a space every ICS_MAX_LINE_OCTETS.
+// Lines are only folded between characters so that multi-byte characters aren't split.
+func (writer *icsWriter) line(name string, value string) {
+	if writer.err != nil {
+		return
+	}
+
+	content := name + ":" + value
+	octets := 0
+	for _, character := range content {
+		size := len(string(character))
+		if octets+size > ICS_MAX_LINE_OCTETS {
+			if _, writer.err = writer.w.WriteString("\r\n "); writer.err != nil {
+				return
+			}
+			octets = 1
+		}
+
+		if _, writer.err = writer.w.WriteRune(character); writer.err != nil {
+			return
+		}
+		octets += size
+	}
+
+	_, writer.err = writer.w.WriteString("\r\n")
+}
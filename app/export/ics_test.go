@@ -0,0 +1,115 @@
+package export_test
+
+import (
+	"bytes"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/export"
+	"github.com/alexandre-normand/glukit/app/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCalendarKinds(t *testing.T) {
+	kinds, err := ParseCalendarKinds("hypos, meals,hypos")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(kinds) != 2 || kinds[0] != CALENDAR_KIND_HYPOS || kinds[1] != CALENDAR_KIND_MEALS {
+		t.Errorf("Expected kinds [hypos meals] but got %v", kinds)
+	}
+
+	if kinds, err := ParseCalendarKinds(""); err != nil || len(kinds) != len(ALL_CALENDAR_KINDS) {
+		t.Errorf("Expected all kinds when none is requested but got %v: %v", kinds, err)
+	}
+
+	if _, err := ParseCalendarKinds("meals,reads"); err == nil {
+		t.Errorf("Expected an error for an unknown kind")
+	}
+}
+
+func TestWriteCalendarUsesStoredTimezoneOfTimedEvents(t *testing.T) {
+	mealTime := time.Date(2015, time.March, 7, 14, 30, 0, 0, time.UTC)
+	meals := []apimodel.Meal{apimodel.Meal{Time: apimodel.Time{apimodel.GetTimeMillis(mealTime), "America/Montreal"}, Carbs: 45,
+		Description: "Pizza, salad", Id: "1"}}
+
+	calendar := writeCalendar(t, MealEvents("user", meals))
+
+	for _, expected := range []string{"BEGIN:VCALENDAR\r\n", "UID:meals-1-user@glukit.appspot.com\r\n",
+		"DTSTART;TZID=America/Montreal:20150307T093000\r\n", "SUMMARY:Meal: 45g carbs (Pizza\\, salad)\r\n", "END:VCALENDAR\r\n"} {
+		if !strings.Contains(calendar, expected) {
+			t.Errorf("Expected calendar to contain [%s] but got [%s]", expected, calendar)
+		}
+	}
+
+	if strings.Contains(calendar, "DTEND") {
+		t.Errorf("Expected no end of an event without duration but got [%s]", calendar)
+	}
+}
+
+func TestWriteCalendarHasAllDayExercisesWithoutDuration(t *testing.T) {
+	exerciseTime := time.Date(2015, time.March, 7, 14, 30, 0, 0, time.UTC)
+	exercises := []apimodel.Exercise{
+		apimodel.Exercise{Time: apimodel.Time{apimodel.GetTimeMillis(exerciseTime), "UTC"}, DurationMinutes: 30, Id: "1"},
+		apimodel.Exercise{Time: apimodel.Time{apimodel.GetTimeMillis(exerciseTime), "UTC"}, Id: "2"},
+		apimodel.Exercise{Time: apimodel.Time{apimodel.GetTimeMillis(exerciseTime), "UTC"}, Id: "3", Deleted: true}}
+
+	calendar := writeCalendar(t, ExerciseEvents("user", exercises))
+
+	for _, expected := range []string{"DTSTART:20150307T143000Z\r\n", "DTEND:20150307T150000Z\r\n",
+		"DTSTART;VALUE=DATE:20150307\r\n", "DTEND;VALUE=DATE:20150308\r\n"} {
+		if !strings.Contains(calendar, expected) {
+			t.Errorf("Expected calendar to contain [%s] but got [%s]", expected, calendar)
+		}
+	}
+
+	if strings.Count(calendar, "BEGIN:VEVENT") != 2 {
+		t.Errorf("Expected 2 events without the deleted exercise but got [%s]", calendar)
+	}
+}
+
+func TestHypoEventsInUserTimezoneAndUnit(t *testing.T) {
+	location, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2015, time.March, 7, 6, 0, 0, 0, time.UTC)
+	hypos := []model.HypoEvent{model.HypoEvent{StartTime: start, EndTime: start.Add(time.Duration(45) * time.Minute), Nadir: 54}}
+
+	calendar := writeCalendar(t, HypoEvents("user", hypos, location, apimodel.MMOL_PER_L))
+
+	for _, expected := range []string{"UID:hypos-1425708000-user@glukit.appspot.com\r\n", "DTSTART;TZID=America/Montreal:20150307T010000\r\n",
+		"DTEND;TZID=America/Montreal:20150307T014500\r\n", "SUMMARY:Low: 3.0 mmol/L\r\n"} {
+		if !strings.Contains(calendar, expected) {
+			t.Errorf("Expected calendar to contain [%s] but got [%s]", expected, calendar)
+		}
+	}
+}
+
+func TestWriteCalendarFoldsLongLines(t *testing.T) {
+	eventTime := time.Date(2015, time.March, 7, 14, 30, 0, 0, time.UTC)
+	events := []CalendarEvent{CalendarEvent{Uid: "1", Summary: "Meal", Description: strings.Repeat("é", 100), Start: eventTime, End: eventTime}}
+
+	calendar := writeCalendar(t, events)
+
+	for _, line := range strings.Split(calendar, "\r\n") {
+		if len(line) > ICS_MAX_LINE_OCTETS {
+			t.Errorf("Expected lines of at most [%d] octets but got [%s] of [%d]", ICS_MAX_LINE_OCTETS, line, len(line))
+		}
+	}
+
+	if unfolded := strings.Replace(calendar, "\r\n ", "", -1); !strings.Contains(unfolded, "DESCRIPTION:"+strings.Repeat("é", 100)+"\r\n") {
+		t.Errorf("Expected the unfolded description to be whole but got [%s]", unfolded)
+	}
+}
+
+func writeCalendar(t *testing.T, events []CalendarEvent) string {
+	var buffer bytes.Buffer
+	if err := WriteCalendar(&buffer, "Glukit", events, time.Date(2015, time.March, 8, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.String()
+}
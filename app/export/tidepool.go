@@ -0,0 +1,260 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"net/http"
+	"time"
+)
+
+const (
+	TIDEPOOL_API_URL = "https://api.tidepool.org"
+
+	TIDEPOOL_LOGIN_PATH           = "/auth/login"
+	TIDEPOOL_SESSION_TOKEN_HEADER = "x-tidepool-session-token"
+
+	// Datums are uploaded in batches of at most TIDEPOOL_UPLOAD_BATCH_SIZE to stay under the request size limit
+	TIDEPOOL_UPLOAD_BATCH_SIZE = 1000
+
+	// Layouts of the time of a datum, in UTC, and of its time on the device, in the timezone it was recorded in
+	TIDEPOOL_TIME_LAYOUT        = "2006-01-02T15:04:05.000Z"
+	TIDEPOOL_DEVICE_TIME_LAYOUT = "2006-01-02T15:04:05"
+
+	TIDEPOOL_CLIENT_NAME    = "org.glukit"
+	TIDEPOOL_CLIENT_VERSION = "1.0.0"
+	TIDEPOOL_DEVICE_ID      = "glukit"
+)
+
+// TidepoolSession is the session of a Tidepool user, authenticating the requests of the upload
+type TidepoolSession struct {
+	Token  string
+	UserId string
+}
+
+// TidepoolDatum is a datum of the Tidepool data model, one of a cbg, a food or a bolus. Origin identifies the record
+// it comes from so that Tidepool can tell a datum uploaded again from a new one.
+type TidepoolDatum struct {
+	Type             string             `json:"type"`
+	SubType          string             `json:"subType,omitempty"`
+	Time             string             `json:"time"`
+	DeviceTime       string             `json:"deviceTime"`
+	TimezoneOffset   int                `json:"timezoneOffset"`
+	ConversionOffset int                `json:"conversionOffset"`
+	DeviceId         string             `json:"deviceId"`
+	Units            string             `json:"units,omitempty"`
+	Value            float32            `json:"value,omitempty"`
+	Normal           float32            `json:"normal,omitempty"`
+	Nutrition        *TidepoolNutrition `json:"nutrition,omitempty"`
+	Origin           TidepoolOrigin     `json:"origin"`
+}
+
+type TidepoolNutrition struct {
+	Carbohydrate TidepoolCarbohydrate `json:"carbohydrate"`
+}
+
+type TidepoolCarbohydrate struct {
+	Net   float32 `json:"net"`
+	Units string  `json:"units"`
+}
+
+type TidepoolOrigin struct {
+	Id string `json:"id"`
+}
+
+// tidepoolDataset is the upload session datums are added to, closed once they all were
+type tidepoolDataset struct {
+	Type                string             `json:"type"`
+	DataSetType         string             `json:"dataSetType"`
+	Client              tidepoolClientInfo `json:"client"`
+	DeviceId            string             `json:"deviceId"`
+	DeviceManufacturers []string           `json:"deviceManufacturers"`
+	DeviceModel         string             `json:"deviceModel"`
+	DeviceSerialNumber  string             `json:"deviceSerialNumber"`
+	DeviceTags          []string           `json:"deviceTags"`
+	Time                string             `json:"time"`
+	ComputerTime        string             `json:"computerTime"`
+	TimeProcessing      string             `json:"timeProcessing"`
+	TimezoneOffset      int                `json:"timezoneOffset"`
+	ConversionOffset    int                `json:"conversionOffset"`
+}
+
+type tidepoolClientInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type tidepoolDatasetResponse struct {
+	Data struct {
+		Id       string `json:"id"`
+		UploadId string `json:"uploadId"`
+	} `json:"data"`
+}
+
+type tidepoolLoginResponse struct {
+	UserId string `json:"userid"`
+}
+
+// TidepoolClient uploads datums to the Tidepool api at ApiUrl
+type TidepoolClient struct {
+	Client *http.Client
+	ApiUrl string
+}
+
+// NewTidepoolClient returns the client of the Tidepool api making its requests with client
+func NewTidepoolClient(client *http.Client) *TidepoolClient {
+	return &TidepoolClient{client, TIDEPOOL_API_URL}
+}
+
+// CbgDatums returns the cbg datums of the reads, in mg/dL
+func CbgDatums(reads []apimodel.GlucoseRead) (datums []TidepoolDatum) {
+	datums = make([]TidepoolDatum, 0, len(reads))
+	for _, read := range reads {
+		value, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil || value <= 0 {
+			continue
+		}
+
+		datum := newTidepoolDatum("cbg", read.GetTime(), fmt.Sprintf("cbg-%d", read.Time.Timestamp))
+		datum.Units, datum.Value = "mg/dL", value
+		datums = append(datums, datum)
+	}
+
+	return datums
+}
+
+// FoodDatums returns the food datums of the meals that weren't deleted and have carbs
+func FoodDatums(meals []apimodel.Meal) (datums []TidepoolDatum) {
+	datums = make([]TidepoolDatum, 0, len(meals))
+	for _, meal := range apimodel.MealSlice(meals).WithoutDeleted() {
+		if meal.Carbs <= 0 {
+			continue
+		}
+
+		datum := newTidepoolDatum("food", meal.GetTime(), fmt.Sprintf("food-%s", meal.Id))
+		datum.Nutrition = &TidepoolNutrition{TidepoolCarbohydrate{meal.Carbs, "grams"}}
+		datums = append(datums, datum)
+	}
+
+	return datums
+}
+
+// BolusDatums returns the normal bolus datums of the injections that weren't deleted. Long-acting injections aren't
+// boluses and are left out.
+func BolusDatums(injections []apimodel.Injection) (datums []TidepoolDatum) {
+	datums = make([]TidepoolDatum, 0, len(injections))
+	for _, injection := range apimodel.InjectionSlice(injections).WithoutDeleted() {
+		if injection.Units <= 0 || injection.Category == apimodel.INSULIN_CATEGORY_LONG_ACTING {
+			continue
+		}
+
+		datum := newTidepoolDatum("bolus", injection.GetTime(), fmt.Sprintf("bolus-%s", injection.Id))
+		datum.SubType, datum.Normal = "normal", injection.Units
+		datums = append(datums, datum)
+	}
+
+	return datums
+}
+
+func newTidepoolDatum(datumType string, timeValue time.Time, originId string) TidepoolDatum {
+	_, offset := timeValue.Zone()
+	return TidepoolDatum{Type: datumType, Time: timeValue.UTC().Format(TIDEPOOL_TIME_LAYOUT),
+		DeviceTime: timeValue.Format(TIDEPOOL_DEVICE_TIME_LAYOUT), TimezoneOffset: offset / 60, DeviceId: TIDEPOOL_DEVICE_ID,
+		Origin: TidepoolOrigin{originId}}
+}
+
+// Login authenticates the Tidepool user with the credentials
+func (client *TidepoolClient) Login(username string, password string) (session TidepoolSession, err error) {
+	request, err := http.NewRequest("POST", client.ApiUrl+TIDEPOOL_LOGIN_PATH, nil)
+	if err != nil {
+		return session, err
+	}
+	request.SetBasicAuth(username, password)
+
+	response, err := client.Client.Do(request)
+	if err != nil {
+		return session, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return session, errors.New(fmt.Sprintf("Error logging in to tidepool as [%s], got status [%s]", username, response.Status))
+	}
+
+	var login tidepoolLoginResponse
+	if err := json.NewDecoder(response.Body).Decode(&login); err != nil {
+		return session, err
+	}
+
+	return TidepoolSession{response.Header.Get(TIDEPOOL_SESSION_TOKEN_HEADER), login.UserId}, nil
+}
+
+// Upload uploads the datums in a new upload session of the user, closed once all datums were added. Nothing is
+// uploaded if there are no datums.
+func (client *TidepoolClient) Upload(session TidepoolSession, datums []TidepoolDatum, now time.Time) (err error) {
+	if len(datums) == 0 {
+		return nil
+	}
+
+	_, offset := now.Zone()
+	dataset := tidepoolDataset{Type: "upload", DataSetType: "continuous", Client: tidepoolClientInfo{TIDEPOOL_CLIENT_NAME, TIDEPOOL_CLIENT_VERSION},
+		DeviceId: TIDEPOOL_DEVICE_ID, DeviceManufacturers: []string{"Glukit"}, DeviceModel: "Glukit", DeviceSerialNumber: TIDEPOOL_DEVICE_ID,
+		DeviceTags: []string{"cgm", "insulin-pump"}, Time: now.UTC().Format(TIDEPOOL_TIME_LAYOUT),
+		ComputerTime: now.Format(TIDEPOOL_DEVICE_TIME_LAYOUT), TimeProcessing: "none", TimezoneOffset: offset / 60}
+
+	var created tidepoolDatasetResponse
+	if err = client.call(session, "POST", fmt.Sprintf("/v1/users/%s/datasets", session.UserId), dataset, &created); err != nil {
+		return err
+	}
+
+	datasetId := created.Data.Id
+	if datasetId == "" {
+		datasetId = created.Data.UploadId
+	}
+
+	for start := 0; start < len(datums); start += TIDEPOOL_UPLOAD_BATCH_SIZE {
+		end := start + TIDEPOOL_UPLOAD_BATCH_SIZE
+		if end > len(datums) {
+			end = len(datums)
+		}
+
+		if err = client.call(session, "POST", fmt.Sprintf("/v1/datasets/%s/data", datasetId), datums[start:end], nil); err != nil {
+			return err
+		}
+	}
+
+	return client.call(session, "PUT", fmt.Sprintf("/v1/datasets/%s", datasetId), map[string]string{"dataState": "closed"}, nil)
+}
+
+// call calls the Tidepool api endpoint with body encoded as json and decodes the response into value, if not nil
+func (client *TidepoolClient) call(session TidepoolSession, method string, path string, body interface{}, value interface{}) (err error) {
+	var encoded bytes.Buffer
+	if err = json.NewEncoder(&encoded).Encode(body); err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(method, client.ApiUrl+path, &encoded)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Content-Type", "application/json")
+	request.Header.Add(TIDEPOOL_SESSION_TOKEN_HEADER, session.Token)
+
+	response, err := client.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("Error calling tidepool api [%s %s], got status [%s]", method, path, response.Status))
+	}
+
+	if value == nil {
+		return nil
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
@@ -0,0 +1,92 @@
+package export_test
+
+import (
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/export"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTidepoolDatumsOfRecords(t *testing.T) {
+	recordTime := apimodel.Time{apimodel.GetTimeMillis(time.Date(2016, time.January, 15, 20, 30, 0, 0, time.UTC)), "America/Los_Angeles"}
+
+	cbgs := CbgDatums([]apimodel.GlucoseRead{apimodel.GlucoseRead{recordTime, apimodel.MMOL_PER_L, 6.5}})
+	if len(cbgs) != 1 || cbgs[0].Type != "cbg" || cbgs[0].Units != "mg/dL" || cbgs[0].Value < 116 || cbgs[0].Value > 118 {
+		t.Errorf("Expected a cbg of 117 mg/dL but got %v", cbgs)
+	}
+
+	if cbgs[0].Time != "2016-01-15T20:30:00.000Z" || cbgs[0].DeviceTime != "2016-01-15T12:30:00" || cbgs[0].TimezoneOffset != -480 {
+		t.Errorf("Expected the utc time and the device time in the timezone of the read but got %v", cbgs[0])
+	}
+
+	foods := FoodDatums([]apimodel.Meal{apimodel.Meal{Time: recordTime, Carbs: 45, Id: "1"}, apimodel.Meal{Time: recordTime, Protein: 20, Id: "2"},
+		apimodel.Meal{Time: recordTime, Carbs: 30, Id: "3", Deleted: true}})
+	if len(foods) != 1 || foods[0].Nutrition.Carbohydrate.Net != 45 || foods[0].Origin.Id != "food-1" {
+		t.Errorf("Expected a single food of 45g of carbs but got %v", foods)
+	}
+
+	boluses := BolusDatums([]apimodel.Injection{apimodel.Injection{Time: recordTime, Units: 4.5, Id: "1", Category: apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+		apimodel.Injection{Time: recordTime, Units: 20, Id: "2", Category: apimodel.INSULIN_CATEGORY_LONG_ACTING}})
+	if len(boluses) != 1 || boluses[0].SubType != "normal" || boluses[0].Normal != 4.5 {
+		t.Errorf("Expected a single normal bolus of 4.5 units but got %v", boluses)
+	}
+}
+
+func TestTidepoolUploadSession(t *testing.T) {
+	requests := make([]string, 0)
+	uploaded := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests = append(requests, request.Method+" "+request.URL.Path)
+		switch request.URL.Path {
+		case TIDEPOOL_LOGIN_PATH:
+			if username, password, ok := request.BasicAuth(); !ok || username != "user" || password != "password" {
+				http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			writer.Header().Set(TIDEPOOL_SESSION_TOKEN_HEADER, "token")
+			writer.Write([]byte(`{"userid":"abc"}`))
+		case "/v1/users/abc/datasets":
+			writer.WriteHeader(http.StatusCreated)
+			writer.Write([]byte(`{"data":{"id":"dataset"}}`))
+		case "/v1/datasets/dataset/data":
+			var datums []TidepoolDatum
+			json.NewDecoder(request.Body).Decode(&datums)
+			uploaded += len(datums)
+		}
+
+		if request.URL.Path != TIDEPOOL_LOGIN_PATH && request.Header.Get(TIDEPOOL_SESSION_TOKEN_HEADER) != "token" {
+			t.Errorf("Expected the session token on [%s]", request.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := &TidepoolClient{http.DefaultClient, server.URL}
+	if _, err := client.Login("user", "wrong"); err == nil {
+		t.Errorf("Expected an error logging in with the wrong password")
+	}
+
+	session, err := client.Login("user", "password")
+	if err != nil || session.UserId != "abc" || session.Token != "token" {
+		t.Fatalf("Expected a session of user [abc] but got %v: %v", session, err)
+	}
+
+	datums := make([]TidepoolDatum, TIDEPOOL_UPLOAD_BATCH_SIZE+1)
+	if err := client.Upload(session, datums, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"POST /auth/login", "POST /auth/login", "POST /v1/users/abc/datasets", "POST /v1/datasets/dataset/data",
+		"POST /v1/datasets/dataset/data", "PUT /v1/datasets/dataset"}
+	if len(requests) != len(expected) || uploaded != len(datums) {
+		t.Fatalf("Expected requests %v uploading [%d] datums but got %v uploading [%d]", expected, len(datums), requests, uploaded)
+	}
+
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Errorf("Expected request [%s] but got [%s]", expected[i], requests[i])
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package glukitio
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"sync"
+)
+
+// Pools of the slices of batches shared by streamers and buffered writers. Writers don't retain the batches they're
+// given, see GlucoseReadBatchWriter and CalibrationBatchWriter, so a slice can be reused by the next batch as soon as
+// its write returns instead of being reallocated on every flush.
+var (
+	glucoseReadBatchPool = sync.Pool{
+		New: func() interface{} {
+			return new([]apimodel.GlucoseRead)
+		},
+	}
+
+	calibrationBatchPool = sync.Pool{
+		New: func() interface{} {
+			return new([]apimodel.CalibrationRead)
+		},
+	}
+)
+
+// GetGlucoseReadBatch returns an empty batch of the pool, possibly with the capacity of a previous one. It's given back
+// with PutGlucoseReadBatch once it's written.
+func GetGlucoseReadBatch() *[]apimodel.GlucoseRead {
+	return glucoseReadBatchPool.Get().(*[]apimodel.GlucoseRead)
+}
+
+// PutGlucoseReadBatch gives the batch back to the pool. The batch must not be used anymore once it's put back.
+func PutGlucoseReadBatch(batch *[]apimodel.GlucoseRead) {
+	*batch = (*batch)[:0]
+	glucoseReadBatchPool.Put(batch)
+}
+
+// GetCalibrationBatch returns an empty batch of the pool, possibly with the capacity of a previous one. It's given back
+// with PutCalibrationBatch once it's written.
+func GetCalibrationBatch() *[]apimodel.CalibrationRead {
+	return calibrationBatchPool.Get().(*[]apimodel.CalibrationRead)
+}
+
+// PutCalibrationBatch gives the batch back to the pool. The batch must not be used anymore once it's put back.
+func PutCalibrationBatch(batch *[]apimodel.CalibrationRead) {
+	*batch = (*batch)[:0]
+	calibrationBatchPool.Put(batch)
+}
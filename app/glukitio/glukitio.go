@@ -21,6 +21,10 @@ var ErrShortWrite = errors.New("short write")
 // underlying data stream. It returns the number of batch elements written
 // from p (0 <= n <= len(p)) and any error encountered that caused the
 // write to stop early. Write must return a non-nil error if it returns n < len(p).
+//
+// Implementations must not retain p, nor the elements of the days of p given to
+// WriteCalibrationBatches, once the write returns: streamers and buffered writers reuse them
+// for their next batch, see PutCalibrationBatch. Writers that keep elements around must copy them.
 type CalibrationBatchWriter interface {
 	WriteCalibrationBatch(p []apimodel.CalibrationRead) (w CalibrationBatchWriter, err error)
 	WriteCalibrationBatches(p []apimodel.DayOfCalibrationReads) (w CalibrationBatchWriter, err error)
@@ -39,6 +43,10 @@ type CalibrationBatchWriter interface {
 // underlying data stream. It returns the number of batch elements written
 // from p (0 <= n <= len(p)) and any error encountered that caused the
 // write to stop early. Write must return a non-nil error if it returns n < len(p).
+//
+// Implementations must not retain p, nor the elements of the days of p given to
+// WriteGlucoseReadBatches, once the write returns: streamers and buffered writers reuse them
+// for their next batch, see PutGlucoseReadBatch. Writers that keep elements around must copy them.
 type GlucoseReadBatchWriter interface {
 	WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (w GlucoseReadBatchWriter, err error)
 	WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (w GlucoseReadBatchWriter, err error)
@@ -63,6 +71,24 @@ type InjectionBatchWriter interface {
 	Flush() (w InjectionBatchWriter, err error)
 }
 
+// BasalRateBatchWriter is the interface that wraps the basic
+// WriteBasalRateBatch and WriteBasalRateBatches methods.
+//
+// WriteBasalRateBatch writes len(p) model.BasalRate from p to the
+// underlying data stream. It returns the number of elements written
+// from p (0 <= n <= len(p)) and any error encountered that caused the
+// write to stop early. Write must return a non-nil error if it returns n < len(p).
+//
+// WriteBasalRateBatches writes len(p) model.DayOfBasalRates from p to the
+// underlying data stream. It returns the number of batch elements written
+// from p (0 <= n <= len(p)) and any error encountered that caused the
+// write to stop early. Write must return a non-nil error if it returns n < len(p).
+type BasalRateBatchWriter interface {
+	WriteBasalRateBatch(p []apimodel.BasalRate) (w BasalRateBatchWriter, err error)
+	WriteBasalRateBatches(p []apimodel.DayOfBasalRates) (w BasalRateBatchWriter, err error)
+	Flush() (w BasalRateBatchWriter, err error)
+}
+
 // MealBatchWriter is the interface that wraps the basic
 // WriteMealBatch and WriteMealBatches methods.
 //
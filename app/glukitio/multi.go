@@ -0,0 +1,351 @@
+package glukitio
+
+import (
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"sort"
+	"strings"
+)
+
+// SinkError is returned by the multi writers when some of their writers failed. Errors are keyed by the position of
+// the writer that returned them. The other writers still got the batch.
+type SinkError struct {
+	Errors map[int]error
+}
+
+func (err *SinkError) Error() string {
+	sinks := make([]int, 0, len(err.Errors))
+	for sink := range err.Errors {
+		sinks = append(sinks, sink)
+	}
+	sort.Ints(sinks)
+
+	messages := make([]string, len(sinks))
+	for i, sink := range sinks {
+		messages[i] = fmt.Sprintf("sink [%d]: %v", sink, err.Errors[sink])
+	}
+
+	return fmt.Sprintf("Writing to %d sink(s) failed: %s", len(sinks), strings.Join(messages, ", "))
+}
+
+// add records the error of a sink, creating the SinkError if needed
+func (err *SinkError) add(sink int, sinkErr error) *SinkError {
+	if err == nil {
+		err = &SinkError{make(map[int]error)}
+	}
+	err.Errors[sink] = sinkErr
+
+	return err
+}
+
+// MultiCalibrationBatchWriter forwards every batch and flush to all of its writers so that a single stream of calibrations can
+// be written to multiple sinks. A call fails as soon as it's done if any writer failed, with a SinkError telling which.
+type MultiCalibrationBatchWriter struct {
+	writers []CalibrationBatchWriter
+}
+
+// NewMultiCalibrationBatchWriter returns a writer that forwards to all of the given writers, in order
+func NewMultiCalibrationBatchWriter(writers ...CalibrationBatchWriter) *MultiCalibrationBatchWriter {
+	return &MultiCalibrationBatchWriter{writers}
+}
+
+func (w *MultiCalibrationBatchWriter) WriteCalibrationBatch(p []apimodel.CalibrationRead) (CalibrationBatchWriter, error) {
+	return w.forEach(func(wr CalibrationBatchWriter) (CalibrationBatchWriter, error) {
+		return wr.WriteCalibrationBatch(p)
+	})
+}
+
+func (w *MultiCalibrationBatchWriter) WriteCalibrationBatches(p []apimodel.DayOfCalibrationReads) (CalibrationBatchWriter, error) {
+	return w.forEach(func(wr CalibrationBatchWriter) (CalibrationBatchWriter, error) {
+		return wr.WriteCalibrationBatches(p)
+	})
+}
+
+func (w *MultiCalibrationBatchWriter) Flush() (CalibrationBatchWriter, error) {
+	return w.forEach(func(wr CalibrationBatchWriter) (CalibrationBatchWriter, error) {
+		return wr.Flush()
+	})
+}
+
+// forEach calls op with every writer and returns a multi writer of the writers they returned
+func (w *MultiCalibrationBatchWriter) forEach(op func(wr CalibrationBatchWriter) (CalibrationBatchWriter, error)) (CalibrationBatchWriter, error) {
+	var sinkErr *SinkError
+	writers := make([]CalibrationBatchWriter, len(w.writers))
+	for i, wr := range w.writers {
+		innerWriter, err := op(wr)
+		if err != nil {
+			sinkErr = sinkErr.add(i, err)
+		}
+
+		if innerWriter == nil {
+			innerWriter = wr
+		}
+		writers[i] = innerWriter
+	}
+
+	if sinkErr != nil {
+		return &MultiCalibrationBatchWriter{writers}, sinkErr
+	}
+
+	return &MultiCalibrationBatchWriter{writers}, nil
+}
+
+// MultiGlucoseReadBatchWriter forwards every batch and flush to all of its writers so that a single stream of glucose reads can
+// be written to multiple sinks. A call fails as soon as it's done if any writer failed, with a SinkError telling which.
+type MultiGlucoseReadBatchWriter struct {
+	writers []GlucoseReadBatchWriter
+}
+
+// NewMultiGlucoseReadBatchWriter returns a writer that forwards to all of the given writers, in order
+func NewMultiGlucoseReadBatchWriter(writers ...GlucoseReadBatchWriter) *MultiGlucoseReadBatchWriter {
+	return &MultiGlucoseReadBatchWriter{writers}
+}
+
+func (w *MultiGlucoseReadBatchWriter) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (GlucoseReadBatchWriter, error) {
+	return w.forEach(func(wr GlucoseReadBatchWriter) (GlucoseReadBatchWriter, error) {
+		return wr.WriteGlucoseReadBatch(p)
+	})
+}
+
+func (w *MultiGlucoseReadBatchWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (GlucoseReadBatchWriter, error) {
+	return w.forEach(func(wr GlucoseReadBatchWriter) (GlucoseReadBatchWriter, error) {
+		return wr.WriteGlucoseReadBatches(p)
+	})
+}
+
+func (w *MultiGlucoseReadBatchWriter) Flush() (GlucoseReadBatchWriter, error) {
+	return w.forEach(func(wr GlucoseReadBatchWriter) (GlucoseReadBatchWriter, error) {
+		return wr.Flush()
+	})
+}
+
+// forEach calls op with every writer and returns a multi writer of the writers they returned
+func (w *MultiGlucoseReadBatchWriter) forEach(op func(wr GlucoseReadBatchWriter) (GlucoseReadBatchWriter, error)) (GlucoseReadBatchWriter, error) {
+	var sinkErr *SinkError
+	writers := make([]GlucoseReadBatchWriter, len(w.writers))
+	for i, wr := range w.writers {
+		innerWriter, err := op(wr)
+		if err != nil {
+			sinkErr = sinkErr.add(i, err)
+		}
+
+		if innerWriter == nil {
+			innerWriter = wr
+		}
+		writers[i] = innerWriter
+	}
+
+	if sinkErr != nil {
+		return &MultiGlucoseReadBatchWriter{writers}, sinkErr
+	}
+
+	return &MultiGlucoseReadBatchWriter{writers}, nil
+}
+
+// MultiInjectionBatchWriter forwards every batch and flush to all of its writers so that a single stream of injections can
+// be written to multiple sinks. A call fails as soon as it's done if any writer failed, with a SinkError telling which.
+type MultiInjectionBatchWriter struct {
+	writers []InjectionBatchWriter
+}
+
+// NewMultiInjectionBatchWriter returns a writer that forwards to all of the given writers, in order
+func NewMultiInjectionBatchWriter(writers ...InjectionBatchWriter) *MultiInjectionBatchWriter {
+	return &MultiInjectionBatchWriter{writers}
+}
+
+func (w *MultiInjectionBatchWriter) WriteInjectionBatch(p []apimodel.Injection) (InjectionBatchWriter, error) {
+	return w.forEach(func(wr InjectionBatchWriter) (InjectionBatchWriter, error) {
+		return wr.WriteInjectionBatch(p)
+	})
+}
+
+func (w *MultiInjectionBatchWriter) WriteInjectionBatches(p []apimodel.DayOfInjections) (InjectionBatchWriter, error) {
+	return w.forEach(func(wr InjectionBatchWriter) (InjectionBatchWriter, error) {
+		return wr.WriteInjectionBatches(p)
+	})
+}
+
+func (w *MultiInjectionBatchWriter) Flush() (InjectionBatchWriter, error) {
+	return w.forEach(func(wr InjectionBatchWriter) (InjectionBatchWriter, error) {
+		return wr.Flush()
+	})
+}
+
+// forEach calls op with every writer and returns a multi writer of the writers they returned
+func (w *MultiInjectionBatchWriter) forEach(op func(wr InjectionBatchWriter) (InjectionBatchWriter, error)) (InjectionBatchWriter, error) {
+	var sinkErr *SinkError
+	writers := make([]InjectionBatchWriter, len(w.writers))
+	for i, wr := range w.writers {
+		innerWriter, err := op(wr)
+		if err != nil {
+			sinkErr = sinkErr.add(i, err)
+		}
+
+		if innerWriter == nil {
+			innerWriter = wr
+		}
+		writers[i] = innerWriter
+	}
+
+	if sinkErr != nil {
+		return &MultiInjectionBatchWriter{writers}, sinkErr
+	}
+
+	return &MultiInjectionBatchWriter{writers}, nil
+}
+
+// MultiMealBatchWriter forwards every batch and flush to all of its writers so that a single stream of meals can
+// be written to multiple sinks. A call fails as soon as it's done if any writer failed, with a SinkError telling which.
+type MultiMealBatchWriter struct {
+	writers []MealBatchWriter
+}
+
+// NewMultiMealBatchWriter returns a writer that forwards to all of the given writers, in order
+func NewMultiMealBatchWriter(writers ...MealBatchWriter) *MultiMealBatchWriter {
+	return &MultiMealBatchWriter{writers}
+}
+
+func (w *MultiMealBatchWriter) WriteMealBatch(p []apimodel.Meal) (MealBatchWriter, error) {
+	return w.forEach(func(wr MealBatchWriter) (MealBatchWriter, error) {
+		return wr.WriteMealBatch(p)
+	})
+}
+
+func (w *MultiMealBatchWriter) WriteMealBatches(p []apimodel.DayOfMeals) (MealBatchWriter, error) {
+	return w.forEach(func(wr MealBatchWriter) (MealBatchWriter, error) {
+		return wr.WriteMealBatches(p)
+	})
+}
+
+func (w *MultiMealBatchWriter) Flush() (MealBatchWriter, error) {
+	return w.forEach(func(wr MealBatchWriter) (MealBatchWriter, error) {
+		return wr.Flush()
+	})
+}
+
+// forEach calls op with every writer and returns a multi writer of the writers they returned
+func (w *MultiMealBatchWriter) forEach(op func(wr MealBatchWriter) (MealBatchWriter, error)) (MealBatchWriter, error) {
+	var sinkErr *SinkError
+	writers := make([]MealBatchWriter, len(w.writers))
+	for i, wr := range w.writers {
+		innerWriter, err := op(wr)
+		if err != nil {
+			sinkErr = sinkErr.add(i, err)
+		}
+
+		if innerWriter == nil {
+			innerWriter = wr
+		}
+		writers[i] = innerWriter
+	}
+
+	if sinkErr != nil {
+		return &MultiMealBatchWriter{writers}, sinkErr
+	}
+
+	return &MultiMealBatchWriter{writers}, nil
+}
+
+// MultiExerciseBatchWriter forwards every batch and flush to all of its writers so that a single stream of exercises can
+// be written to multiple sinks. A call fails as soon as it's done if any writer failed, with a SinkError telling which.
+type MultiExerciseBatchWriter struct {
+	writers []ExerciseBatchWriter
+}
+
+// NewMultiExerciseBatchWriter returns a writer that forwards to all of the given writers, in order
+func NewMultiExerciseBatchWriter(writers ...ExerciseBatchWriter) *MultiExerciseBatchWriter {
+	return &MultiExerciseBatchWriter{writers}
+}
+
+func (w *MultiExerciseBatchWriter) WriteExerciseBatch(p []apimodel.Exercise) (ExerciseBatchWriter, error) {
+	return w.forEach(func(wr ExerciseBatchWriter) (ExerciseBatchWriter, error) {
+		return wr.WriteExerciseBatch(p)
+	})
+}
+
+func (w *MultiExerciseBatchWriter) WriteExerciseBatches(p []apimodel.DayOfExercises) (ExerciseBatchWriter, error) {
+	return w.forEach(func(wr ExerciseBatchWriter) (ExerciseBatchWriter, error) {
+		return wr.WriteExerciseBatches(p)
+	})
+}
+
+func (w *MultiExerciseBatchWriter) Flush() (ExerciseBatchWriter, error) {
+	return w.forEach(func(wr ExerciseBatchWriter) (ExerciseBatchWriter, error) {
+		return wr.Flush()
+	})
+}
+
+// forEach calls op with every writer and returns a multi writer of the writers they returned
+func (w *MultiExerciseBatchWriter) forEach(op func(wr ExerciseBatchWriter) (ExerciseBatchWriter, error)) (ExerciseBatchWriter, error) {
+	var sinkErr *SinkError
+	writers := make([]ExerciseBatchWriter, len(w.writers))
+	for i, wr := range w.writers {
+		innerWriter, err := op(wr)
+		if err != nil {
+			sinkErr = sinkErr.add(i, err)
+		}
+
+		if innerWriter == nil {
+			innerWriter = wr
+		}
+		writers[i] = innerWriter
+	}
+
+	if sinkErr != nil {
+		return &MultiExerciseBatchWriter{writers}, sinkErr
+	}
+
+	return &MultiExerciseBatchWriter{writers}, nil
+}
+
+// MultiBasalRateBatchWriter forwards every batch and flush to all of its writers so that a single stream of basal rates can
+// be written to multiple sinks. A call fails as soon as it's done if any writer failed, with a SinkError telling which.
+type MultiBasalRateBatchWriter struct {
+	writers []BasalRateBatchWriter
+}
+
+// NewMultiBasalRateBatchWriter returns a writer that forwards to all of the given writers, in order
+func NewMultiBasalRateBatchWriter(writers ...BasalRateBatchWriter) *MultiBasalRateBatchWriter {
+	return &MultiBasalRateBatchWriter{writers}
+}
+
+func (w *MultiBasalRateBatchWriter) WriteBasalRateBatch(p []apimodel.BasalRate) (BasalRateBatchWriter, error) {
+	return w.forEach(func(wr BasalRateBatchWriter) (BasalRateBatchWriter, error) {
+		return wr.WriteBasalRateBatch(p)
+	})
+}
+
+func (w *MultiBasalRateBatchWriter) WriteBasalRateBatches(p []apimodel.DayOfBasalRates) (BasalRateBatchWriter, error) {
+	return w.forEach(func(wr BasalRateBatchWriter) (BasalRateBatchWriter, error) {
+		return wr.WriteBasalRateBatches(p)
+	})
+}
+
+func (w *MultiBasalRateBatchWriter) Flush() (BasalRateBatchWriter, error) {
+	return w.forEach(func(wr BasalRateBatchWriter) (BasalRateBatchWriter, error) {
+		return wr.Flush()
+	})
+}
+
+// forEach calls op with every writer and returns a multi writer of the writers they returned
+func (w *MultiBasalRateBatchWriter) forEach(op func(wr BasalRateBatchWriter) (BasalRateBatchWriter, error)) (BasalRateBatchWriter, error) {
+	var sinkErr *SinkError
+	writers := make([]BasalRateBatchWriter, len(w.writers))
+	for i, wr := range w.writers {
+		innerWriter, err := op(wr)
+		if err != nil {
+			sinkErr = sinkErr.add(i, err)
+		}
+
+		if innerWriter == nil {
+			innerWriter = wr
+		}
+		writers[i] = innerWriter
+	}
+
+	if sinkErr != nil {
+		return &MultiBasalRateBatchWriter{writers}, sinkErr
+	}
+
+	return &MultiBasalRateBatchWriter{writers}, nil
+}
@@ -0,0 +1,84 @@
+package glukitio_test
+
+import (
+	"errors"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/glukitio"
+	"testing"
+	"time"
+)
+
+var errSinkUnavailable = errors.New("sink unavailable")
+
+// sinkGlucoseReadWriter keeps the reads it gets or fails every write when it's unavailable
+type sinkGlucoseReadWriter struct {
+	unavailable bool
+	reads       []apimodel.GlucoseRead
+	flushes     int
+}
+
+func (w *sinkGlucoseReadWriter) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (GlucoseReadBatchWriter, error) {
+	if w.unavailable {
+		return w, errSinkUnavailable
+	}
+
+	w.reads = append(w.reads, p...)
+	return w, nil
+}
+
+func (w *sinkGlucoseReadWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (GlucoseReadBatchWriter, error) {
+	for _, dayOfReads := range p {
+		if _, err := w.WriteGlucoseReadBatch(dayOfReads.Reads); err != nil {
+			return w, err
+		}
+	}
+
+	return w, nil
+}
+
+func (w *sinkGlucoseReadWriter) Flush() (GlucoseReadBatchWriter, error) {
+	w.flushes++
+	return w, nil
+}
+
+func TestMultiGlucoseReadBatchWriterWritesToAllSinks(t *testing.T) {
+	r := make([]apimodel.GlucoseRead, 25)
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	for i := range r {
+		readTime := ct.Add(time.Duration(i) * time.Hour)
+		r[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, apimodel.MG_PER_DL, 75}
+	}
+
+	failingSink := &sinkGlucoseReadWriter{unavailable: true}
+	sink := new(sinkGlucoseReadWriter)
+	w := NewMultiGlucoseReadBatchWriter(failingSink, sink)
+
+	_, err := w.WriteGlucoseReadBatches([]apimodel.DayOfGlucoseReads{apimodel.NewDayOfGlucoseReads(r)})
+	sinkErr, ok := err.(*SinkError)
+	if !ok {
+		t.Fatalf("Expected a SinkError but got [%v]", err)
+	}
+
+	if len(sinkErr.Errors) != 1 || sinkErr.Errors[0] != errSinkUnavailable {
+		t.Errorf("Expected only sink [0] to fail with [%v] but got [%v]", errSinkUnavailable, sinkErr)
+	}
+
+	if len(sink.reads) != len(r) {
+		t.Errorf("Expected the available sink to get all [%d] reads but got [%d]", len(r), len(sink.reads))
+	}
+}
+
+func TestMultiGlucoseReadBatchWriterFlushesAllSinks(t *testing.T) {
+	sinks := []*sinkGlucoseReadWriter{new(sinkGlucoseReadWriter), new(sinkGlucoseReadWriter)}
+	w := NewMultiGlucoseReadBatchWriter(sinks[0], sinks[1])
+
+	if _, err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, sink := range sinks {
+		if sink.flushes != 1 {
+			t.Errorf("Expected sink [%d] to be flushed once but got [%d] flushes", i, sink.flushes)
+		}
+	}
+}
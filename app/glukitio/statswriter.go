@@ -0,0 +1,92 @@
+package glukitio
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"sort"
+)
+
+// StatsCollectingWriter computes the stats of every day of reads that goes through it to its inner writer so that
+// they don't have to be computed again from the stored reads. Days of reads split in multiple batches are merged into
+// a single DayOfStats.
+type StatsCollectingWriter struct {
+	wr            GlucoseReadBatchWriter
+	lowThreshold  float32
+	highThreshold float32
+	days          map[int64]apimodel.DayOfStats
+}
+
+// NewStatsCollectingWriter returns a writer that collects stats using the given low and high thresholds, in mg/dL
+func NewStatsCollectingWriter(wr GlucoseReadBatchWriter, lowThreshold, highThreshold float32) *StatsCollectingWriter {
+	return &StatsCollectingWriter{wr, lowThreshold, highThreshold, make(map[int64]apimodel.DayOfStats)}
+}
+
+func (w *StatsCollectingWriter) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (GlucoseReadBatchWriter, error) {
+	innerWriter, err := w.wr.WriteGlucoseReadBatch(p)
+	if err != nil {
+		return w, err
+	}
+
+	w.wr = innerWriter
+	w.collect(apimodel.NewDayOfGlucoseReads(p))
+	return w, nil
+}
+
+func (w *StatsCollectingWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (GlucoseReadBatchWriter, error) {
+	innerWriter, err := w.wr.WriteGlucoseReadBatches(p)
+	if err != nil {
+		return w, err
+	}
+
+	w.wr = innerWriter
+	for i := range p {
+		w.collect(p[i])
+	}
+	return w, nil
+}
+
+func (w *StatsCollectingWriter) Flush() (GlucoseReadBatchWriter, error) {
+	innerWriter, err := w.wr.Flush()
+	if err != nil {
+		return w, err
+	}
+
+	w.wr = innerWriter
+	return w, nil
+}
+
+// DaysOfStats returns the stats of all days of reads written so far, in chronological order
+func (w *StatsCollectingWriter) DaysOfStats() []apimodel.DayOfStats {
+	days := make([]apimodel.DayOfStats, 0, len(w.days))
+	for _, day := range w.days {
+		days = append(days, day)
+	}
+	sort.Sort(daysOfStatsByStartTime(days))
+
+	return days
+}
+
+func (w *StatsCollectingWriter) collect(day apimodel.DayOfGlucoseReads) {
+	if len(day.Reads) == 0 {
+		return
+	}
+
+	stats := apimodel.NewDayOfStats(day, w.lowThreshold, w.highThreshold)
+	if existing, ok := w.days[day.StartTime.Unix()]; ok {
+		stats = existing.Merge(stats)
+	}
+	w.days[day.StartTime.Unix()] = stats
+}
+
+type daysOfStatsByStartTime []apimodel.DayOfStats
+
+func (slice daysOfStatsByStartTime) Len() int {
+	return len(slice)
+}
+
+func (slice daysOfStatsByStartTime) Less(i, j int) bool {
+	return slice[i].StartTime.Before(slice[j].StartTime)
+}
+
+func (slice daysOfStatsByStartTime) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
@@ -0,0 +1,59 @@
+package glukitio_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/glukitio"
+	"testing"
+	"time"
+)
+
+func TestStatsCollectingWriterMergesBatchesOfSameDay(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	values := []float32{60, 60, 100, 100, 200, 200}
+	reads := make([]apimodel.GlucoseRead, len(values))
+	for i, value := range values {
+		readTime := ct.Add(time.Duration(i*5) * time.Minute)
+		reads[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, apimodel.MG_PER_DL, value}
+	}
+
+	sink := new(sinkGlucoseReadWriter)
+	w := NewStatsCollectingWriter(sink, apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD, apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD)
+	if _, err := w.WriteGlucoseReadBatch(reads[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteGlucoseReadBatches([]apimodel.DayOfGlucoseReads{apimodel.NewDayOfGlucoseReads(reads[3:])}); err != nil {
+		t.Fatal(err)
+	}
+
+	daysOfStats := w.DaysOfStats()
+	if len(daysOfStats) != 1 {
+		t.Fatalf("Expected a single day of stats but got [%d]", len(daysOfStats))
+	}
+
+	stats := daysOfStats[0]
+	if stats.Count != 6 || stats.Mean != 120 || stats.Min != 60 || stats.Max != 200 {
+		t.Errorf("Expected [6] reads with a mean of [120] between [60] and [200] but got [%v]", stats)
+	}
+
+	if stats.TimeBelow != 10*time.Minute || stats.TimeAbove != 10*time.Minute {
+		t.Errorf("Expected [10m] below and [10m] above but got [%v] below and [%v] above", stats.TimeBelow, stats.TimeAbove)
+	}
+
+	if len(sink.reads) != len(reads) {
+		t.Errorf("Expected all [%d] reads to be written to the inner writer but got [%d]", len(reads), len(sink.reads))
+	}
+}
+
+func TestStatsCollectingWriterSkipsFailedBatches(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := []apimodel.GlucoseRead{apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct), "UTC"}, apimodel.MG_PER_DL, 100}}
+
+	w := NewStatsCollectingWriter(&sinkGlucoseReadWriter{unavailable: true}, apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD, apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD)
+	if _, err := w.WriteGlucoseReadBatch(reads); err != errSinkUnavailable {
+		t.Errorf("Expected error [%v] but got [%v]", errSinkUnavailable, err)
+	}
+
+	if daysOfStats := w.DaysOfStats(); len(daysOfStats) != 0 {
+		t.Errorf("Expected no stats for reads that weren't written but got [%v]", daysOfStats)
+	}
+}
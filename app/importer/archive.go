@@ -0,0 +1,87 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+var ErrNoDataFileInArchive = errors.New("Archive doesn't hold any xml, csv or txt data file")
+
+// DataFile is a file to import along with the name used to pick its parser. Files found inside a zip archive also
+// have the name of their archive entry so that each of them gets its own import log.
+type DataFile struct {
+	Name   string
+	Entry  string
+	Reader io.Reader
+}
+
+// IsDataFileName returns true if the name is the one of a file that can be parsed (Dexcom xml or csv/txt exports)
+func IsDataFileName(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".xml") || strings.HasSuffix(name, ".csv") || strings.HasSuffix(name, ".txt")
+}
+
+// IsArchiveName returns true if the name is the one of a zip or gzip archive
+func IsArchiveName(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".gz")
+}
+
+// IsCsvFileName returns true if the data file should be parsed as csv rather than Dexcom xml
+func IsCsvFileName(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".csv") || strings.HasSuffix(name, ".txt")
+}
+
+// OpenDataFiles returns the data files held by the content of a file given its name. Gzipped content is decompressed
+// as it's read and is named after the file without its .gz extension. Every xml, csv or txt entry of a zip archive is
+// returned in the order of the archive. Zip archives can't be read as a stream so their whole content is read in memory
+// first. Any other content is returned as is.
+func OpenDataFiles(reader io.Reader, name string) (dataFiles []DataFile, err error) {
+	lowerName := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lowerName, ".gz"):
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		return []DataFile{DataFile{name[:len(name)-len(".gz")], "", gzipReader}}, nil
+	case strings.HasSuffix(lowerName, ".zip"):
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		zipReader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range zipReader.File {
+			if entry.FileInfo().IsDir() || !IsDataFileName(entry.Name) {
+				continue
+			}
+
+			entryReader, err := entry.Open()
+			if err != nil {
+				return nil, err
+			}
+			dataFiles = append(dataFiles, DataFile{path.Base(entry.Name), entry.Name, entryReader})
+		}
+
+		if len(dataFiles) == 0 {
+			return nil, ErrNoDataFileInArchive
+		}
+
+		return dataFiles, nil
+	}
+
+	return []DataFile{DataFile{name, "", reader}}, nil
+}
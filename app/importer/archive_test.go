@@ -0,0 +1,90 @@
+package importer_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestOpenDataFilesWithPlainFile(t *testing.T) {
+	dataFiles, err := OpenDataFiles(strings.NewReader(clarityFixture), "export.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dataFiles) != 1 || dataFiles[0].Name != "export.csv" || dataFiles[0].Entry != "" {
+		t.Errorf("Expected the file to be returned as is but got [%v]", dataFiles)
+	}
+}
+
+func TestOpenDataFilesWithGzipFile(t *testing.T) {
+	var content bytes.Buffer
+	writer := gzip.NewWriter(&content)
+	writer.Write([]byte(clarityFixture))
+	writer.Close()
+
+	dataFiles, err := OpenDataFiles(&content, "export.csv.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dataFiles) != 1 || dataFiles[0].Name != "export.csv" {
+		t.Fatalf("Expected a single decompressed export.csv file but got [%v]", dataFiles)
+	}
+
+	if decompressed, err := ioutil.ReadAll(dataFiles[0].Reader); err != nil || string(decompressed) != clarityFixture {
+		t.Errorf("Expected decompressed content to be the original content but got [%s]: %v", decompressed, err)
+	}
+}
+
+func TestOpenDataFilesWithMultiEntryZipFile(t *testing.T) {
+	var content bytes.Buffer
+	writer := zip.NewWriter(&content)
+	for _, entry := range []string{"readme.pdf", "2015/clarity.csv", "dexcom.xml"} {
+		entryWriter, err := writer.Create(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entryWriter.Write([]byte(entry))
+	}
+	writer.Close()
+
+	dataFiles, err := OpenDataFiles(&content, "exports.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dataFiles) != 2 {
+		t.Fatalf("Expected the [2] data files of the archive but got [%v]", dataFiles)
+	}
+
+	if dataFiles[0].Name != "clarity.csv" || dataFiles[0].Entry != "2015/clarity.csv" || dataFiles[1].Entry != "dexcom.xml" {
+		t.Errorf("Expected data files named after their entries but got [%v]", dataFiles)
+	}
+
+	if entryContent, err := ioutil.ReadAll(dataFiles[1].Reader); err != nil || string(entryContent) != "dexcom.xml" {
+		t.Errorf("Expected the content of the dexcom.xml entry but got [%s]: %v", entryContent, err)
+	}
+}
+
+func TestOpenDataFilesWithInvalidArchives(t *testing.T) {
+	if _, err := OpenDataFiles(strings.NewReader("not a zip"), "exports.zip"); !IsDataError(err) {
+		t.Errorf("Expected a data error for an invalid zip file but got [%v]", err)
+	}
+
+	if _, err := OpenDataFiles(strings.NewReader("not a gzip"), "export.xml.gz"); !IsDataError(err) {
+		t.Errorf("Expected a data error for an invalid gzip file but got [%v]", err)
+	}
+
+	var content bytes.Buffer
+	writer := zip.NewWriter(&content)
+	writer.Create("readme.pdf")
+	writer.Close()
+	if _, err := OpenDataFiles(&content, "exports.zip"); err != ErrNoDataFileInArchive {
+		t.Errorf("Expected [%v] for a zip file without data files but got [%v]", ErrNoDataFileInArchive, err)
+	}
+}
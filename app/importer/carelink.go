@@ -0,0 +1,348 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Columns of the Medtronic CareLink csv export
+	CARELINK_DATE_COLUMN                  = "Date"
+	CARELINK_TIME_COLUMN                  = "Time"
+	CARELINK_SENSOR_GLUCOSE_COLUMN_PREFIX = "Sensor Glucose"
+	CARELINK_BG_READING_COLUMN_PREFIX     = "BG Reading"
+	CARELINK_BOLUS_VOLUME_COLUMN          = "Bolus Volume Delivered (U)"
+	CARELINK_BOLUS_TYPE_COLUMN            = "Bolus Type"
+	CARELINK_CARB_INPUT_COLUMN            = "BWZ Carb Input (grams)"
+	CARELINK_BASAL_RATE_COLUMN            = "Basal Rate (U/h)"
+	CARELINK_TEMP_BASAL_AMOUNT_COLUMN     = "Temp Basal Amount"
+	CARELINK_TEMP_BASAL_TYPE_COLUMN       = "Temp Basal Type"
+	CARELINK_TEMP_BASAL_DURATION_COLUMN   = "Temp Basal Duration (h:mm:ss)"
+
+	// Type of temp basals set as a rate in units per hour rather than a percentage of the scheduled rate
+	CARELINK_ABSOLUTE_TEMP_BASAL_TYPE = "Absolute"
+
+	// Longest time a scheduled basal rate is considered to last when the export has no following rate
+	CARELINK_MAX_BASAL_DURATION = 24 * time.Hour
+
+	// Time format of the time column
+	CARELINK_TIMEFORMAT = "15:04:05"
+)
+
+// Date formats found in CareLink exports depending on the locale of the user
+var careLinkDateFormats = []string{"2006/01/02", "01/02/06", "2006-01-02", "02.01.06", "02/01/2006"}
+
+var ErrNotCareLinkExport = errors.New("Content is not a Medtronic CareLink csv export, header row not found")
+
+// careLinkColumns holds the index of each column of interest of a CareLink export. Optional columns
+// that aren't present have an index of -1.
+type careLinkColumns struct {
+	date          int
+	time          int
+	sensorGlucose int
+	bgReading     int
+	bolusVolume   int
+	bolusType     int
+	carbInput     int
+	basalRate     int
+	tempBasal     int
+	tempBasalType int
+	tempDuration  int
+	sensorUnit    apimodel.GlucoseUnit
+	bgReadingUnit apimodel.GlucoseUnit
+}
+
+// IsCareLinkHeader returns true if the record is the header row of a Medtronic CareLink export
+func IsCareLinkHeader(record []string) bool {
+	_, err := newCareLinkColumns(record)
+	return err == nil
+}
+
+func newCareLinkColumns(header []string) (columns *careLinkColumns, err error) {
+	columns = &careLinkColumns{-1, -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, apimodel.MG_PER_DL, apimodel.MG_PER_DL}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == CARELINK_DATE_COLUMN:
+			columns.date = i
+		case name == CARELINK_TIME_COLUMN:
+			columns.time = i
+		case strings.HasPrefix(name, CARELINK_SENSOR_GLUCOSE_COLUMN_PREFIX):
+			columns.sensorGlucose = i
+			columns.sensorUnit = getUnitFromColumnName(name)
+		case strings.HasPrefix(name, CARELINK_BG_READING_COLUMN_PREFIX):
+			columns.bgReading = i
+			columns.bgReadingUnit = getUnitFromColumnName(name)
+		case name == CARELINK_BOLUS_VOLUME_COLUMN:
+			columns.bolusVolume = i
+		case name == CARELINK_BOLUS_TYPE_COLUMN:
+			columns.bolusType = i
+		case name == CARELINK_CARB_INPUT_COLUMN:
+			columns.carbInput = i
+		case name == CARELINK_BASAL_RATE_COLUMN:
+			columns.basalRate = i
+		case name == CARELINK_TEMP_BASAL_AMOUNT_COLUMN:
+			columns.tempBasal = i
+		case name == CARELINK_TEMP_BASAL_TYPE_COLUMN:
+			columns.tempBasalType = i
+		case name == CARELINK_TEMP_BASAL_DURATION_COLUMN:
+			columns.tempDuration = i
+		}
+	}
+
+	if columns.date < 0 || columns.time < 0 || columns.sensorGlucose < 0 {
+		return nil, ErrNotCareLinkExport
+	}
+
+	return columns, nil
+}
+
+// getUnitFromColumnName returns the glucose unit of a column named with its unit (i.e. "Sensor Glucose (mmol/L)")
+func getUnitFromColumnName(name string) apimodel.GlucoseUnit {
+	if strings.Contains(name, "mmol/L") {
+		return apimodel.MMOL_PER_L
+	}
+
+	return apimodel.MG_PER_DL
+}
+
+// value returns the trimmed value of the column at the given index or an empty string if the column isn't present
+func (columns *careLinkColumns) value(record []string, index int) string {
+	if index < 0 || index >= len(record) {
+		return ""
+	}
+
+	return strings.TrimSpace(record[index])
+}
+
+// number parses the numeric value of the column at the given index. Locales that use semicolons as the delimiter
+// use commas as the decimal mark so those are supported as well. Missing values return an error.
+func (columns *careLinkColumns) number(record []string, index int) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(columns.value(record, index), ",", ".", 1), 32)
+}
+
+// duration parses the h:mm:ss duration of the column at the given index
+func (columns *careLinkColumns) duration(record []string, index int) (duration time.Duration, err error) {
+	parts := strings.Split(columns.value(record, index), ":")
+	if len(parts) != 3 {
+		return 0, errors.New(fmt.Sprintf("Invalid duration [%s]", columns.value(record, index)))
+	}
+
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		value, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, err
+		}
+		duration += time.Duration(value) * unit
+	}
+
+	return duration, nil
+}
+
+// recordTime returns the time of the record. CareLink doesn't include any timezone information so times are considered
+// to be UTC.
+func (columns *careLinkColumns) recordTime(record []string) (recordTime time.Time, err error) {
+	location := time.FixedZone("+0000", 0)
+	timeOfDay, err := time.Parse(CARELINK_TIMEFORMAT, columns.value(record, columns.time))
+	if err != nil {
+		return recordTime, err
+	}
+
+	date := columns.value(record, columns.date)
+	for _, format := range careLinkDateFormats {
+		if day, err := time.ParseInLocation(format, date, location); err == nil {
+			return time.Date(day.Year(), day.Month(), day.Day(), timeOfDay.Hour(), timeOfDay.Minute(), timeOfDay.Second(), 0, location), nil
+		}
+	}
+
+	return recordTime, errors.New(fmt.Sprintf("Invalid date [%s]", date))
+}
+
+// IsCareLinkExport returns true if the beginning of the content has the sensor glucose column of a Medtronic CareLink
+// export. The column is usually found after the preamble of patient and device information.
+func IsCareLinkExport(beginning []byte, filename string) bool {
+	return bytes.Contains(beginning, []byte(CARELINK_SENSOR_GLUCOSE_COLUMN_PREFIX))
+}
+
+// parseCareLink is the Parser of the CareLink format
+func parseCareLink(context context.Context, reader io.Reader, since time.Time, streamers *ImportStreamers, progress ProgressHandler) (lastReadTime time.Time, report *ImportReport, err error) {
+	return ParseCareLink(context, reader, since, streamers)
+}
+
+// ParseCareLink reads the content of a Medtronic CareLink export and writes every record more recent than startTime to
+// the streamers. The export lists the most recent rows first so records are buffered and only written, in chronological
+// order, once all rows are read. The preamble (patient and device information) is skipped until the header row is
+// found. The delimiter (comma or semicolon depending on the locale) is detected from the header row. Exports can have
+// multiple sections, each with its own header row so columns are remapped every time a header row is found. Rows that
+// can't be parsed are skipped and added to the report. Glucose values are converted to mg/dL. The streamers are left
+// open for the caller to close.
+func ParseCareLink(context context.Context, reader io.Reader, startTime time.Time, streamers *ImportStreamers) (lastReadTime time.Time, report *ImportReport, err error) {
+	lastReadTime = startTime
+	report = new(ImportReport)
+
+	var columns *careLinkColumns
+	delimiter := ','
+	records := new(recordBuffer)
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if header, lineDelimiter, isHeader := parseCareLinkHeader(line); isHeader {
+			columns, delimiter = header, lineDelimiter
+			continue
+		}
+
+		if columns == nil {
+			continue
+		}
+
+		csvReader := csv.NewReader(strings.NewReader(line))
+		csvReader.Comma = delimiter
+		csvReader.FieldsPerRecord = -1
+		csvReader.LazyQuotes = true
+		record, err := csvReader.Read()
+		if err != nil {
+			log.Warningf(context, "Skipping malformed carelink row [%s]: %v", line, err)
+			report.skip(fmt.Sprintf("line %d", lineNumber), err)
+			continue
+		}
+
+		if err = records.addCareLinkRecord(columns, record, startTime); err != nil {
+			log.Warningf(context, "Skipping carelink row [%s]: %v", line, err)
+			report.skip(fmt.Sprintf("line %d", lineNumber), err)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return lastReadTime, report, err
+	}
+
+	if columns == nil {
+		return lastReadTime, report, ErrNotCareLinkExport
+	}
+	report.Unit = columns.sensorUnit
+
+	sort.Sort(records.reads)
+	scheduledBasalEnd := lastReadTime
+	if len(records.reads) > 0 {
+		scheduledBasalEnd = records.reads[len(records.reads)-1].GetTime()
+	}
+	setScheduledBasalDurations(records.basalRates, scheduledBasalEnd)
+
+	lastReadTime, err = records.write(lastReadTime, streamers, report)
+	return lastReadTime, report, err
+}
+
+// parseCareLinkHeader returns the columns of the line and its delimiter if it's a header row
+func parseCareLinkHeader(line string) (columns *careLinkColumns, delimiter rune, isHeader bool) {
+	for _, delimiter := range []rune{';', ','} {
+		csvReader := csv.NewReader(strings.NewReader(line))
+		csvReader.Comma = delimiter
+		csvReader.FieldsPerRecord = -1
+		csvReader.LazyQuotes = true
+		if record, err := csvReader.Read(); err == nil {
+			if columns, err := newCareLinkColumns(record); err == nil {
+				return columns, delimiter, true
+			}
+		}
+	}
+
+	return nil, ',', false
+}
+
+// setScheduledBasalDurations sets the duration of the scheduled basal rates. CareLink only lists changes of the scheduled
+// rate so each one lasts until the next one, up to CARELINK_MAX_BASAL_DURATION. The last one lasts until end.
+func setScheduledBasalDurations(basalRates apimodel.BasalRateSlice, end time.Time) {
+	sort.Sort(basalRates)
+
+	var previous *apimodel.BasalRate
+	for i := range basalRates {
+		if basalRates[i].Temp {
+			continue
+		}
+
+		if previous != nil {
+			previous.DurationMinutes = scheduledBasalMinutes(previous.GetTime(), basalRates[i].GetTime())
+		}
+		previous = &basalRates[i]
+	}
+
+	if previous != nil {
+		previous.DurationMinutes = scheduledBasalMinutes(previous.GetTime(), end)
+	}
+}
+
+// scheduledBasalMinutes returns the minutes from start to end, capped to CARELINK_MAX_BASAL_DURATION
+func scheduledBasalMinutes(start, end time.Time) int {
+	duration := end.Sub(start)
+	if duration < 0 {
+		return 0
+	}
+
+	if duration > CARELINK_MAX_BASAL_DURATION {
+		duration = CARELINK_MAX_BASAL_DURATION
+	}
+
+	return int(duration / time.Minute)
+}
+
+// add converts the row to the records it holds. A single row can hold a sensor read, a meter read, a bolus, carbs
+// and a basal rate at the same time. Temp basals set as a percentage of the scheduled rate are skipped.
+func (records *recordBuffer) addCareLinkRecord(columns *careLinkColumns, record []string, startTime time.Time) (err error) {
+	recordTime, err := columns.recordTime(record)
+	if err != nil {
+		return err
+	}
+
+	// Skip everything that's before the last import's read time
+	if recordTime.Unix() <= startTime.Unix() {
+		return nil
+	}
+
+	timestamp := apimodel.Time{apimodel.GetTimeMillis(recordTime), recordTime.Location().String()}
+	if value, err := columns.number(record, columns.sensorGlucose); err == nil && value > 0 {
+		records.reads = append(records.reads, apimodel.GlucoseRead{timestamp, apimodel.MG_PER_DL, toMgPerDl(columns.sensorUnit, float32(value))})
+	}
+
+	if value, err := columns.number(record, columns.bgReading); err == nil && value > 0 {
+		records.calibrations = append(records.calibrations, apimodel.CalibrationRead{timestamp, apimodel.MG_PER_DL, toMgPerDl(columns.bgReadingUnit, float32(value))})
+	}
+
+	if units, err := columns.number(record, columns.bolusVolume); err == nil && units > 0 {
+		// Pumps only deliver rapid-acting insulin, the bolus type being how it was delivered
+		records.injections = append(records.injections, apimodel.Injection{timestamp, float32(units), "", columns.value(record, columns.bolusType), apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false})
+	}
+
+	if carbs, err := columns.number(record, columns.carbInput); err == nil && carbs > 0 {
+		records.meals = append(records.meals, apimodel.Meal{timestamp, float32(carbs), 0., 0., 0., 0., "", "", false, false})
+	}
+
+	// Scheduled rates of 0 are valid (i.e. the pump is suspended) so only missing values are skipped
+	if rate, err := columns.number(record, columns.basalRate); err == nil {
+		records.basalRates = append(records.basalRates, apimodel.BasalRate{timestamp, float32(rate), 0, false})
+	}
+
+	if rate, err := columns.number(record, columns.tempBasal); err == nil && columns.value(record, columns.tempBasalType) == CARELINK_ABSOLUTE_TEMP_BASAL_TYPE {
+		duration, err := columns.duration(record, columns.tempDuration)
+		if err != nil {
+			return err
+		}
+		records.basalRates = append(records.basalRates, apimodel.BasalRate{timestamp, float32(rate), int(duration / time.Minute), true})
+	}
+
+	return nil
+}
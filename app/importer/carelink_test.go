@@ -0,0 +1,153 @@
+package importer_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"google.golang.org/appengine/aetest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const careLinkFixture = `Last Name;First Name;Patient ID;Start Date;End Date;;;;;;;
+Normand;Alexandre;;15/01/16 00:00:00;15/01/16 23:59:59;;;;;;;
+Meter:;Contour Next Link;;;;;;;;;;
+;;;;;;;;;;;
+Index;Date;Time;New Device Time;BG Reading (mmol/L);Bolus Type;Bolus Volume Delivered (U);BWZ Carb Input (grams);Sensor Glucose (mmol/L);ISIG Value
+5;2016/01/15;08:20:00;;;;;;7,1;18,5
+4;2016/01/15;08:15:00;;;Normal;3,5;40;;
+3;2016/01/15;08:10:00;;;;;;6,4;17,2
+2;2016/01/15;08:05:00;;6,2;;;;;
+1;2016/01/15;08:00:00;;;;;;5,6;15,1
+`
+
+func parseCareLinkFixture(t *testing.T, startTime time.Time) (*importedRecords, time.Time, int) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	records, streamers := newRecordingStreamers()
+	lastReadTime, report, err := ParseCareLink(c, strings.NewReader(careLinkFixture), startTime, streamers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return records, lastReadTime, report.RecordCount()
+}
+
+func TestParseCareLinkWithPreambleAndDecimalCommas(t *testing.T) {
+	records, lastReadTime, recordCount := parseCareLinkFixture(t, time.Unix(0, 0))
+
+	if recordCount != 6 {
+		t.Errorf("Expected [6] records but got [%d]", recordCount)
+	}
+
+	utc := time.FixedZone("+0000", 0)
+	expectedReads := []apimodel.GlucoseRead{
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(time.Date(2016, 1, 15, 8, 0, 0, 0, utc)), "+0000"}, apimodel.MG_PER_DL, mgPerDl(5.6)},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(time.Date(2016, 1, 15, 8, 10, 0, 0, utc)), "+0000"}, apimodel.MG_PER_DL, mgPerDl(6.4)},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(time.Date(2016, 1, 15, 8, 20, 0, 0, utc)), "+0000"}, apimodel.MG_PER_DL, mgPerDl(7.1)},
+	}
+
+	if len(records.reads) != len(expectedReads) {
+		t.Fatalf("Expected [%d] reads but got [%d]: %v", len(expectedReads), len(records.reads), records.reads)
+	}
+
+	for i := range expectedReads {
+		if records.reads[i] != expectedReads[i] {
+			t.Errorf("Expected read [%v] at index [%d] but got [%v]", expectedReads[i], i, records.reads[i])
+		}
+	}
+
+	if expectedLastReadTime := time.Date(2016, 1, 15, 8, 20, 0, 0, utc); !lastReadTime.Equal(expectedLastReadTime) {
+		t.Errorf("Expected last read time [%v] but got [%v]", expectedLastReadTime, lastReadTime)
+	}
+
+	if len(records.calibrations) != 1 || records.calibrations[0].Value != mgPerDl(6.2) || records.calibrations[0].Unit != apimodel.MG_PER_DL {
+		t.Errorf("Expected a single meter read of [6.2] mmol/L converted to mg/dL but got [%v]", records.calibrations)
+	}
+
+	if len(records.injections) != 1 || records.injections[0].Units != 3.5 || records.injections[0].InsulinType != "Normal" || records.injections[0].Category != apimodel.INSULIN_CATEGORY_RAPID_ACTING {
+		t.Errorf("Expected a single normal bolus of [3.5] units but got [%v]", records.injections)
+	}
+
+	if len(records.meals) != 1 || records.meals[0].Carbs != 40 {
+		t.Errorf("Expected a single meal of [40] grams of carbs but got [%v]", records.meals)
+	}
+}
+
+func TestParseCareLinkSkipsRecordsBeforeStartTime(t *testing.T) {
+	startTime := time.Date(2016, 1, 15, 8, 10, 0, 0, time.UTC)
+	records, lastReadTime, recordCount := parseCareLinkFixture(t, startTime)
+
+	if recordCount != 3 {
+		t.Errorf("Expected [3] records after [%v] but got [%d]", startTime, recordCount)
+	}
+
+	if len(records.reads) != 1 || !records.reads[0].GetTime().Equal(lastReadTime) {
+		t.Errorf("Expected a single read at [%v] but got [%v]", lastReadTime, records.reads)
+	}
+}
+
+func TestParseCareLinkRejectsOtherContent(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, streamers := newRecordingStreamers()
+	if _, _, err := ParseCareLink(c, strings.NewReader("Date,Time,Value\n2016-01-15,08:00,110\n"), time.Unix(0, 0), streamers); err != ErrNotCareLinkExport {
+		t.Errorf("Expected [%v] but got [%v]", ErrNotCareLinkExport, err)
+	}
+}
+
+const careLinkBasalFixture = `Index,Date,Time,Basal Rate (U/h),Temp Basal Amount,Temp Basal Type,Temp Basal Duration (h:mm:ss),Sensor Glucose (mg/dL)
+6,2016/01/15,09:00:00,,,,,110
+5,2016/01/15,08:30:00,,0.2,Percent,0:30:00,
+4,2016/01/15,08:15:00,,0.4,Absolute,0:45:00,
+3,2016/01/15,08:00:00,0.8,,,,105
+2,2016/01/15,04:00:00,1.1,,,,
+1,2016/01/15,00:00:00,0.9,,,,
+`
+
+func TestParseCareLinkBasalRates(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	records, streamers := newRecordingStreamers()
+	if _, _, err := ParseCareLink(c, strings.NewReader(careLinkBasalFixture), time.Unix(0, 0), streamers); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	utc := time.FixedZone("+0000", 0)
+	expectedBasalRates := []apimodel.BasalRate{
+		apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(time.Date(2016, 1, 15, 0, 0, 0, 0, utc)), "+0000"}, 0.9, 240, false},
+		apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(time.Date(2016, 1, 15, 4, 0, 0, 0, utc)), "+0000"}, 1.1, 240, false},
+		apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(time.Date(2016, 1, 15, 8, 0, 0, 0, utc)), "+0000"}, 0.8, 60, false},
+		apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(time.Date(2016, 1, 15, 8, 15, 0, 0, utc)), "+0000"}, 0.4, 45, true},
+	}
+
+	if len(records.basalRates) != len(expectedBasalRates) {
+		t.Fatalf("Expected [%d] basal rates but got [%d]: %v", len(expectedBasalRates), len(records.basalRates), records.basalRates)
+	}
+
+	for i := range expectedBasalRates {
+		if records.basalRates[i] != expectedBasalRates[i] {
+			t.Errorf("Expected basal rate [%v] at index [%d] but got [%v]", expectedBasalRates[i], i, records.basalRates[i])
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Columns of the Dexcom Clarity csv export
+	CLARITY_TIMESTAMP_COLUMN       = "Timestamp (YYYY-MM-DDThh:mm:ss)"
+	CLARITY_EVENT_TYPE_COLUMN      = "Event Type"
+	CLARITY_EVENT_SUBTYPE_COLUMN   = "Event Subtype"
+	CLARITY_GLUCOSE_COLUMN_PREFIX  = "Glucose Value"
+	CLARITY_INSULIN_COLUMN         = "Insulin Value (u)"
+	CLARITY_CARBS_COLUMN           = "Carb Value (grams)"
+	CLARITY_DURATION_COLUMN        = "Duration (hh:mm:ss)"
+	CLARITY_TIMEZONE_OFFSET_COLUMN = "Timezone Offset"
+
+	// Event types of the Dexcom Clarity csv export
+	CLARITY_EGV_EVENT         = "EGV"
+	CLARITY_CALIBRATION_EVENT = "Calibration"
+	CLARITY_CARBS_EVENT       = "Carbs"
+	CLARITY_INSULIN_EVENT     = "Insulin"
+	CLARITY_EXERCISE_EVENT    = "Exercise"
+
+	// Time format of the timestamp column, expressed in the local time of the user
+	CLARITY_TIMEFORMAT = "2006-01-02T15:04:05"
+)
+
+var ErrNotClarityExport = errors.New("Content is not a Dexcom Clarity csv export, header row not found")
+
+// clarityColumns holds the index of each column of interest of a Clarity export. Optional columns
+// that aren't present have an index of -1.
+type clarityColumns struct {
+	timestamp      int
+	eventType      int
+	eventSubtype   int
+	glucose        int
+	insulin        int
+	carbs          int
+	duration       int
+	timezoneOffset int
+	unit           apimodel.GlucoseUnit
+}
+
+// IsClarityHeader returns true if the csv record is the header row of a Dexcom Clarity export
+func IsClarityHeader(record []string) bool {
+	_, err := newClarityColumns(record)
+	return err == nil
+}
+
+func newClarityColumns(header []string) (columns *clarityColumns, err error) {
+	columns = &clarityColumns{-1, -1, -1, -1, -1, -1, -1, -1, apimodel.MG_PER_DL}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == CLARITY_TIMESTAMP_COLUMN:
+			columns.timestamp = i
+		case name == CLARITY_EVENT_TYPE_COLUMN:
+			columns.eventType = i
+		case name == CLARITY_EVENT_SUBTYPE_COLUMN:
+			columns.eventSubtype = i
+		case strings.HasPrefix(name, CLARITY_GLUCOSE_COLUMN_PREFIX):
+			columns.glucose = i
+			if strings.Contains(name, "mmol/L") {
+				columns.unit = apimodel.MMOL_PER_L
+			}
+		case name == CLARITY_INSULIN_COLUMN:
+			columns.insulin = i
+		case name == CLARITY_CARBS_COLUMN:
+			columns.carbs = i
+		case name == CLARITY_DURATION_COLUMN:
+			columns.duration = i
+		case name == CLARITY_TIMEZONE_OFFSET_COLUMN:
+			columns.timezoneOffset = i
+		}
+	}
+
+	if columns.timestamp < 0 || columns.eventType < 0 || columns.glucose < 0 {
+		return nil, ErrNotClarityExport
+	}
+
+	return columns, nil
+}
+
+// value returns the trimmed value of the column at the given index or an empty string if the column isn't present
+func (columns *clarityColumns) value(record []string, index int) string {
+	if index < 0 || index >= len(record) {
+		return ""
+	}
+
+	return strings.TrimSpace(record[index])
+}
+
+// IsClarityExport returns true if the beginning of the content has the timestamp and event type columns of a Dexcom
+// Clarity export
+func IsClarityExport(beginning []byte, filename string) bool {
+	return bytes.Contains(beginning, []byte(CLARITY_TIMESTAMP_COLUMN)) && bytes.Contains(beginning, []byte(CLARITY_EVENT_TYPE_COLUMN))
+}
+
+// parseClarity is the Parser of the Clarity format
+func parseClarity(context context.Context, reader io.Reader, since time.Time, streamers *ImportStreamers, progress ProgressHandler) (lastReadTime time.Time, report *ImportReport, err error) {
+	return ParseClarity(context, reader, since, streamers)
+}
+
+// ParseClarity reads the csv content of a Dexcom Clarity export and writes every record more recent than startTime to the
+// streamers. Rows preceding the header row (some exports have a preamble) are skipped as are rows of event types
+// that have no glukit equivalent (alerts, patient info, etc.). Rows that can't be parsed are skipped and added to the
+// report. Glucose values are converted to mg/dL. The streamers are left open for the caller to close.
+func ParseClarity(context context.Context, reader io.Reader, startTime time.Time, streamers *ImportStreamers) (lastReadTime time.Time, report *ImportReport, err error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+
+	lastReadTime = startTime
+	report = new(ImportReport)
+	var columns *clarityColumns
+	for rowNumber := 1; ; rowNumber++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return lastReadTime, report, err
+		}
+
+		if columns == nil {
+			columns, _ = newClarityColumns(record)
+			continue
+		}
+
+		eventType := columns.value(record, columns.eventType)
+		switch eventType {
+		case CLARITY_EGV_EVENT, CLARITY_CALIBRATION_EVENT, CLARITY_CARBS_EVENT, CLARITY_INSULIN_EVENT, CLARITY_EXERCISE_EVENT:
+		default:
+			continue
+		}
+
+		eventTime, err := columns.eventTime(record)
+		if err != nil {
+			log.Warningf(context, "Skipping [%s] event [%v], bad timestamp: %v", eventType, record, err)
+			report.skip(fmt.Sprintf("row %d", rowNumber), err)
+			continue
+		}
+
+		// Skip everything that's before the last import's read time
+		if eventTime.Unix() <= startTime.Unix() {
+			continue
+		}
+
+		timestamp := apimodel.Time{apimodel.GetTimeMillis(eventTime), eventTime.Location().String()}
+		switch eventType {
+		case CLARITY_EGV_EVENT, CLARITY_CALIBRATION_EVENT:
+			value, err := strconv.ParseFloat(columns.value(record, columns.glucose), 32)
+			// Values like "Low" or "High" can't be used as reads
+			if err != nil || value <= 0 {
+				continue
+			}
+
+			if eventType == CLARITY_EGV_EVENT {
+				streamers.Glucose, err = streamers.Glucose.WriteGlucoseRead(apimodel.GlucoseRead{timestamp, apimodel.MG_PER_DL, toMgPerDl(columns.unit, float32(value))})
+				if dropped, err := report.skipOutOfOrder(fmt.Sprintf("row %d", rowNumber), err); err != nil {
+					return lastReadTime, report, err
+				} else if dropped == 0 {
+					lastReadTime = eventTime
+					report.Reads++
+				}
+			} else {
+				streamers.Calibration, err = streamers.Calibration.WriteCalibration(apimodel.CalibrationRead{timestamp, apimodel.MG_PER_DL, toMgPerDl(columns.unit, float32(value))})
+				if dropped, err := report.skipOutOfOrder(fmt.Sprintf("row %d", rowNumber), err); err != nil {
+					return lastReadTime, report, err
+				} else if dropped == 0 {
+					report.Calibrations++
+				}
+			}
+		case CLARITY_CARBS_EVENT:
+			carbs, err := strconv.ParseFloat(columns.value(record, columns.carbs), 32)
+			if err != nil {
+				log.Warningf(context, "Skipping carbs event [%v], bad carb value: %v", record, err)
+				report.skip(fmt.Sprintf("row %d", rowNumber), err)
+				continue
+			}
+
+			streamers.Meal, err = streamers.Meal.WriteMeal(apimodel.Meal{timestamp, float32(carbs), 0., 0., 0., 0., "", "", false, false})
+			if dropped, err := report.skipOutOfOrder(fmt.Sprintf("row %d", rowNumber), err); err != nil {
+				return lastReadTime, report, err
+			} else if dropped == 0 {
+				report.Meals++
+			}
+		case CLARITY_INSULIN_EVENT:
+			units, err := strconv.ParseFloat(columns.value(record, columns.insulin), 32)
+			if err != nil {
+				log.Warningf(context, "Skipping insulin event [%v], bad insulin value: %v", record, err)
+				report.skip(fmt.Sprintf("row %d", rowNumber), err)
+				continue
+			}
+
+			injection := newInjection(timestamp, float32(units), columns.value(record, columns.eventSubtype))
+			streamers.Injection, err = streamers.Injection.WriteInjection(injection)
+			if dropped, err := report.skipOutOfOrder(fmt.Sprintf("row %d", rowNumber), err); err != nil {
+				return lastReadTime, report, err
+			} else if dropped == 0 {
+				report.Injections++
+			}
+		case CLARITY_EXERCISE_EVENT:
+			duration, err := parseClarityDuration(columns.value(record, columns.duration))
+			if err != nil {
+				log.Warningf(context, "Skipping exercise event [%v], bad duration: %v", record, err)
+				report.skip(fmt.Sprintf("row %d", rowNumber), err)
+				continue
+			}
+
+			exercise, ok := NormalizeExercise(timestamp, columns.value(record, columns.eventSubtype), duration, "")
+			if !ok {
+				log.Debugf(context, "Dropping cancelled exercise event [%v]", record)
+				continue
+			}
+
+			streamers.Exercise, err = streamers.Exercise.WriteExercise(exercise)
+			if dropped, err := report.skipOutOfOrder(fmt.Sprintf("row %d", rowNumber), err); err != nil {
+				return lastReadTime, report, err
+			} else if dropped == 0 {
+				report.Exercises++
+			}
+		}
+	}
+
+	if columns == nil {
+		return lastReadTime, report, ErrNotClarityExport
+	}
+	report.Unit = columns.unit
+
+	return lastReadTime, report, nil
+}
+
+// eventTime returns the time of the record. The timestamp is in the user's local time so the timezone offset column
+// is used to locate it, when present. Timestamps are considered to be UTC otherwise.
+func (columns *clarityColumns) eventTime(record []string) (eventTime time.Time, err error) {
+	location := time.FixedZone("+0000", 0)
+	if offset := columns.value(record, columns.timezoneOffset); offset != "" {
+		if location, err = parseClarityOffset(offset); err != nil {
+			return eventTime, err
+		}
+	}
+
+	return time.ParseInLocation(CLARITY_TIMEFORMAT, columns.value(record, columns.timestamp), location)
+}
+
+// parseClarityOffset parses an offset from UTC in the [+-]hh:mm or [+-]hhmm form into a fixed location named
+// like the ones extrapolated from the Dexcom xml files (i.e. -0700)
+func parseClarityOffset(value string) (location *time.Location, err error) {
+	offsetTime, err := time.Parse("-07:00", value)
+	if err != nil {
+		if offsetTime, err = time.Parse("-0700", value); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid timezone offset [%s]", value))
+		}
+	}
+
+	_, offsetInSeconds := offsetTime.Zone()
+	return time.FixedZone(offsetTime.Format("-0700"), offsetInSeconds), nil
+}
+
+// parseClarityDuration parses a duration in the hh:mm:ss form
+func parseClarityDuration(value string) (duration time.Duration, err error) {
+	var hours, minutes, seconds int
+	if _, err = fmt.Sscanf(value, "%d:%d:%d", &hours, &minutes, &seconds); err != nil {
+		return 0, err
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second, nil
+}
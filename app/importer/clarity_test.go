@@ -0,0 +1,122 @@
+package importer_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"google.golang.org/appengine/aetest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const clarityFixture = `Index,Timestamp (YYYY-MM-DDThh:mm:ss),Event Type,Event Subtype,Patient Info,Device Info,Source Device ID,Glucose Value (mg/dL),Insulin Value (u),Carb Value (grams),Duration (hh:mm:ss),Glucose Rate of Change (mg/dL/min),Transmitter Time (Long Integer),Transmitter ID,Timezone Offset
+1,,FirstName,,Alexandre,,,,,,,,,,
+2,,Device,,,"G5 Mobile App",iPhone G5,,,,,,,,
+3,2016-01-14T22:00:00,EGV,,,,iPhone G5,95,,,,,3740400,4XXXXX,-08:00
+4,2016-01-15T08:00:00,EGV,,,,iPhone G5,110,,,,,3777600,4XXXXX,-08:00
+5,2016-01-15T08:05:00,EGV,,,,iPhone G5,Low,,,,,3777900,4XXXXX,-08:00
+6,2016-01-15T08:10:00,EGV,,,,iPhone G5,121,,,,,3778200,4XXXXX,-08:00
+7,2016-01-15T08:12:00,Carbs,,,,iPhone G5,,,45,,,3778320,4XXXXX,-08:00
+8,2016-01-15T08:15:00,Insulin,Fast-Acting,,,iPhone G5,,4.5,,,,3778500,4XXXXX,-08:00
+9,2016-01-15T12:30:00,Exercise,Medium,,,iPhone G5,,,,00:45:00,,3793800,4XXXXX,-08:00
+10,2016-01-15T12:35:00,Alert,High,,,iPhone G5,250,,,,,3794100,4XXXXX,-08:00
+`
+
+func parseClarityFixture(t *testing.T, startTime time.Time) (*importedRecords, time.Time, int) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	records, streamers := newRecordingStreamers()
+	lastReadTime, report, err := ParseClarity(c, strings.NewReader(clarityFixture), startTime, streamers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return records, lastReadTime, report.RecordCount()
+}
+
+func TestIsClarityHeader(t *testing.T) {
+	header := strings.Split(strings.SplitN(clarityFixture, "\n", 2)[0], ",")
+	if !IsClarityHeader(header) {
+		t.Errorf("Expected clarity header to be detected in [%v]", header)
+	}
+
+	if IsClarityHeader([]string{"Date", "Time", "BG Reading (mg/dL)"}) {
+		t.Errorf("Expected non-clarity header to be rejected")
+	}
+}
+
+func TestParseClarityAllEventTypes(t *testing.T) {
+	records, lastReadTime, recordCount := parseClarityFixture(t, time.Unix(0, 0))
+
+	if recordCount != 6 {
+		t.Errorf("Expected [6] records but got [%d]", recordCount)
+	}
+
+	if len(records.reads) != 3 {
+		t.Fatalf("Expected [3] reads (the Low value skipped) but got [%d]: %v", len(records.reads), records.reads)
+	}
+
+	pst := time.FixedZone("-0800", -8*60*60)
+	expectedRead := apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(time.Date(2016, 1, 15, 8, 0, 0, 0, pst)), "-0800"}, apimodel.MG_PER_DL, 110}
+	if records.reads[1] != expectedRead {
+		t.Errorf("Expected read [%v] but got [%v]", expectedRead, records.reads[1])
+	}
+
+	if expectedLastReadTime := time.Date(2016, 1, 15, 8, 10, 0, 0, pst); !lastReadTime.Equal(expectedLastReadTime) {
+		t.Errorf("Expected last read time [%v] but got [%v]", expectedLastReadTime, lastReadTime)
+	}
+
+	if len(records.meals) != 1 || records.meals[0].Carbs != 45 || records.meals[0].Time.TimeZoneId != "-0800" {
+		t.Errorf("Expected a single meal of [45] grams of carbs at -0800 but got [%v]", records.meals)
+	}
+
+	if len(records.injections) != 1 || records.injections[0].Units != 4.5 || records.injections[0].InsulinType != "Fast-Acting" || records.injections[0].Category != apimodel.INSULIN_CATEGORY_RAPID_ACTING {
+		t.Errorf("Expected a single injection of [4.5] units of fast-acting insulin but got [%v]", records.injections)
+	}
+
+	if len(records.exercises) != 1 || records.exercises[0].DurationMinutes != 45 || records.exercises[0].Intensity != "Medium" {
+		t.Errorf("Expected a single exercise of [45] minutes of medium intensity but got [%v]", records.exercises)
+	}
+
+	if len(records.calibrations) != 0 {
+		t.Errorf("Expected no calibrations but got [%v]", records.calibrations)
+	}
+}
+
+func TestParseClaritySkipsRecordsBeforeStartTime(t *testing.T) {
+	startTime := time.Date(2016, 1, 15, 8, 10, 0, 0, time.FixedZone("-0800", -8*60*60))
+	records, lastReadTime, recordCount := parseClarityFixture(t, startTime)
+
+	if recordCount != 3 {
+		t.Errorf("Expected [3] records after [%v] but got [%d]", startTime, recordCount)
+	}
+
+	if len(records.reads) != 0 {
+		t.Errorf("Expected no reads after [%v] but got [%v]", startTime, records.reads)
+	}
+
+	if !lastReadTime.Equal(startTime) {
+		t.Errorf("Expected last read time to stay at [%v] but got [%v]", startTime, lastReadTime)
+	}
+}
+
+func TestParseClarityRejectsOtherContent(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, streamers := newRecordingStreamers()
+	if _, _, err := ParseClarity(c, strings.NewReader("Date,Time,Value\n2016-01-15,08:00,110\n"), time.Unix(0, 0), streamers); err != ErrNotClarityExport {
+		t.Errorf("Expected [%v] but got [%v]", ErrNotClarityExport, err)
+	}
+}
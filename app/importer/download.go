@@ -0,0 +1,211 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Size of the chunks large files are downloaded in. It keeps every response well under the urlfetch limits.
+	DOWNLOAD_CHUNK_SIZE = 8 * 1024 * 1024
+
+	// Number of attempts at downloading a single chunk before giving up on the file
+	DOWNLOAD_CHUNK_ATTEMPTS = 3
+
+	// Delay before retrying a chunk that failed to download
+	DOWNLOAD_RETRY_DELAY = time.Duration(1) * time.Second
+)
+
+// errTransientDownload wraps failures that are worth retrying (network errors and server errors)
+type errTransientDownload struct {
+	err error
+}
+
+func (e errTransientDownload) Error() string {
+	return e.err.Error()
+}
+
+// rangeReader reads a file as successive Range requests of chunkSize bytes. Each chunk is retried on its own so that a
+// transient failure doesn't restart the whole file. If the server ignores the Range header and returns the full
+// content, the full response is read as is.
+type rangeReader struct {
+	context   context.Context
+	client    http.RoundTripper
+	url       string
+	chunkSize int64
+	offset    int64
+	size      int64
+	body      io.ReadCloser
+	chunkEnd  int64
+	fullBody  bool
+}
+
+// NewRangeReader returns a reader of the content at the url that downloads it in chunks of chunkSize bytes. The first
+// chunk is requested right away so that a file that can't be downloaded fails here rather than on the first read.
+// The caller is responsible for calling Close() when done.
+func NewRangeReader(context context.Context, client http.RoundTripper, url string, chunkSize int64) (reader io.ReadCloser, err error) {
+	r := &rangeReader{context: context, client: client, url: url, chunkSize: chunkSize, size: -1}
+	if err = r.nextChunk(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Read reads from the current chunk and moves on to the next one when it's done. A chunk that is cut short is requested
+// again from the current offset.
+func (r *rangeReader) Read(p []byte) (n int, err error) {
+	failures := 0
+	for {
+		if r.body == nil {
+			if r.size >= 0 && r.offset >= r.size {
+				return 0, io.EOF
+			}
+
+			if err = r.nextChunk(); err != nil {
+				return 0, err
+			}
+
+			if r.body == nil {
+				return 0, io.EOF
+			}
+		}
+
+		n, err = r.body.Read(p)
+		r.offset += int64(n)
+		if n > 0 || err == nil {
+			return n, nil
+		}
+
+		r.body.Close()
+		r.body = nil
+		if r.fullBody {
+			// Without ranges, there's no resuming from the current offset
+			return 0, err
+		}
+
+		if err == io.EOF {
+			if r.offset >= r.chunkEnd {
+				continue
+			}
+			err = io.ErrUnexpectedEOF
+		}
+
+		if failures++; failures >= DOWNLOAD_CHUNK_ATTEMPTS {
+			return 0, err
+		}
+		log.Warningf(r.context, "Error reading chunk of [%s] at offset [%d], requesting it again: %v", r.url, r.offset, err)
+	}
+}
+
+// nextChunk requests the chunk that starts at the current offset, retrying on transient failures
+func (r *rangeReader) nextChunk() (err error) {
+	for attempt := 1; attempt <= DOWNLOAD_CHUNK_ATTEMPTS; attempt++ {
+		if err = r.requestChunk(); err == nil {
+			return nil
+		}
+
+		if _, transient := err.(errTransientDownload); !transient {
+			return err
+		}
+
+		log.Warningf(r.context, "Error downloading chunk of [%s] at offset [%d] (attempt %d of %d): %v", r.url, r.offset,
+			attempt, DOWNLOAD_CHUNK_ATTEMPTS, err)
+		if attempt < DOWNLOAD_CHUNK_ATTEMPTS {
+			time.Sleep(DOWNLOAD_RETRY_DELAY)
+		}
+	}
+
+	return err
+}
+
+func (r *rangeReader) requestChunk() (err error) {
+	request, err := http.NewRequest("GET", r.url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", r.offset, r.offset+r.chunkSize-1))
+	request.Header.Add("User-agent", "glukit")
+
+	response, err := r.client.RoundTrip(request)
+	if err != nil {
+		return errTransientDownload{err}
+	}
+
+	switch {
+	case response.StatusCode == http.StatusPartialContent:
+		start, end, size, err := parseContentRange(response.Header.Get("Content-Range"))
+		if err != nil || start != r.offset {
+			response.Body.Close()
+			return errors.New(fmt.Sprintf("Unexpected content range [%s] for offset [%d]", response.Header.Get("Content-Range"), r.offset))
+		}
+		r.body, r.chunkEnd, r.size = response.Body, end+1, size
+	case response.StatusCode == http.StatusOK && r.offset == 0:
+		// The server ignores ranges, fall back to reading the full content in one go
+		log.Infof(r.context, "Server doesn't support ranges for [%s], downloading the full content", r.url)
+		r.body, r.fullBody = response.Body, true
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The offset is at the end of the content
+		response.Body.Close()
+		r.size = r.offset
+	case response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests:
+		response.Body.Close()
+		return errTransientDownload{errors.New(fmt.Sprintf("Download of [%s] failed with status [%s]", r.url, response.Status))}
+	default:
+		response.Body.Close()
+		return errors.New(fmt.Sprintf("Download of [%s] failed with status [%s]", r.url, response.Status))
+	}
+
+	return nil
+}
+
+// Close closes the body of the current chunk
+func (r *rangeReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// parseContentRange parses the value of a Content-Range header (i.e. "bytes 0-1023/4096"). The size is -1 if the server
+// doesn't know it.
+func parseContentRange(contentRange string) (start, end, size int64, err error) {
+	var byteRange, total string
+	if parts := strings.SplitN(strings.TrimPrefix(contentRange, "bytes "), "/", 2); len(parts) == 2 {
+		byteRange, total = parts[0], parts[1]
+	} else {
+		return 0, 0, 0, errors.New(fmt.Sprintf("Invalid content range [%s]", contentRange))
+	}
+
+	bounds := strings.SplitN(byteRange, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, 0, errors.New(fmt.Sprintf("Invalid content range [%s]", contentRange))
+	}
+
+	if start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+		return 0, 0, 0, err
+	}
+
+	if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil {
+		return 0, 0, 0, err
+	}
+
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	return start, end, size, nil
+}
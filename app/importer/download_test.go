@@ -0,0 +1,101 @@
+package importer_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"google.golang.org/appengine/aetest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const downloadContent = "0123456789abcdefghijklmnopqrstuvwxyz!"
+
+func TestRangeReaderDownloadsInChunks(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var ranges []string
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ranges = append(ranges, request.Header.Get("Range"))
+		// Fail the second chunk once to make sure it's retried on its own
+		if request.Header.Get("Range") == "bytes=10-19" && !failed {
+			failed = true
+			http.Error(writer, "Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(writer, request, "export.xml", time.Now(), strings.NewReader(downloadContent))
+	}))
+	defer server.Close()
+
+	reader, err := NewRangeReader(c, http.DefaultTransport, server.URL, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != downloadContent {
+		t.Errorf("Expected content [%s] but got [%s]", downloadContent, content)
+	}
+
+	expectedRanges := []string{"bytes=0-9", "bytes=10-19", "bytes=10-19", "bytes=20-29", "bytes=30-39"}
+	if strings.Join(ranges, ",") != strings.Join(expectedRanges, ",") {
+		t.Errorf("Expected ranges [%v] but got [%v]", expectedRanges, ranges)
+	}
+}
+
+func TestRangeReaderFallsBackToFullDownload(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		writer.Write([]byte(downloadContent))
+	}))
+	defer server.Close()
+
+	reader, err := NewRangeReader(c, http.DefaultTransport, server.URL, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != downloadContent || requests != 1 {
+		t.Errorf("Expected content [%s] in a single request but got [%s] in [%d] requests", downloadContent, content, requests)
+	}
+}
+
+func TestRangeReaderFailsOnMissingFile(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := NewRangeReader(c, http.DefaultTransport, server.URL, 10); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
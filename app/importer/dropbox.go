@@ -0,0 +1,156 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/lib/drive"
+	"golang.org/x/net/context"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DROPBOX_AUTH_URL    = "https://www.dropbox.com/oauth2/authorize"
+	DROPBOX_TOKEN_URL   = "https://api.dropboxapi.com/oauth2/token"
+	DROPBOX_API_URL     = "https://api.dropboxapi.com/2"
+	DROPBOX_CONTENT_URL = "https://content.dropboxapi.com/2"
+
+	DROPBOX_LIST_FOLDER_PATH          = "/files/list_folder"
+	DROPBOX_LIST_FOLDER_CONTINUE_PATH = "/files/list_folder/continue"
+	DROPBOX_DOWNLOAD_PATH             = "/files/download"
+
+	DROPBOX_FILE_TAG = "file"
+)
+
+// DropboxFileSource is the Dropbox folder of the glukit app of a user. The app only has access to its own folder so the
+// whole folder is listed, data files being recognized by their name.
+type DropboxFileSource struct {
+	Context    context.Context
+	Transport  http.RoundTripper
+	ApiUrl     string
+	ContentUrl string
+}
+
+// NewDropboxFileSource returns the Dropbox folder of the user authorized by transport
+func NewDropboxFileSource(context context.Context, transport http.RoundTripper) *DropboxFileSource {
+	return &DropboxFileSource{context, transport, DROPBOX_API_URL, DROPBOX_CONTENT_URL}
+}
+
+type dropboxListFolderRequest struct {
+	Path      string `json:"path"`
+	Recursive bool   `json:"recursive"`
+}
+
+type dropboxListFolderContinueRequest struct {
+	Cursor string `json:"cursor"`
+}
+
+type dropboxListFolderResponse struct {
+	Entries []dropboxEntry `json:"entries"`
+	Cursor  string         `json:"cursor"`
+	HasMore bool           `json:"has_more"`
+}
+
+type dropboxEntry struct {
+	Tag            string `json:".tag"`
+	Id             string `json:"id"`
+	Name           string `json:"name"`
+	ServerModified string `json:"server_modified"`
+	ContentHash    string `json:"content_hash"`
+}
+
+type dropboxDownloadArgument struct {
+	Path string `json:"path"`
+}
+
+// SearchDataFiles pages through the app folder for data files and archives modified after since. Files are described
+// with their Dropbox id, their content hash as checksum and the url of their download by id.
+func (source *DropboxFileSource) SearchDataFiles(since time.Time) (files []*drive.File, err error) {
+	client := &http.Client{Transport: source.Transport}
+	files = make([]*drive.File, 0)
+
+	var page dropboxListFolderResponse
+	if err = callDropbox(client, source.ApiUrl+DROPBOX_LIST_FOLDER_PATH, dropboxListFolderRequest{"", true}, &page); err != nil {
+		return nil, err
+	}
+
+	for {
+		for _, entry := range page.Entries {
+			if entry.Tag != DROPBOX_FILE_TAG || !(IsDataFileName(entry.Name) || IsArchiveName(entry.Name)) {
+				continue
+			}
+
+			if modified, err := time.Parse(time.RFC3339, entry.ServerModified); err != nil || !modified.After(since) {
+				continue
+			}
+
+			downloadUrl, err := dropboxDownloadUrl(source.ContentUrl, entry.Id)
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, &drive.File{Id: entry.Id, OriginalFilename: entry.Name, ModifiedDate: entry.ServerModified,
+				Md5Checksum: entry.ContentHash, DownloadUrl: downloadUrl})
+		}
+
+		if !page.HasMore {
+			break
+		}
+
+		cursor := page.Cursor
+		page = dropboxListFolderResponse{}
+		if err = callDropbox(client, source.ApiUrl+DROPBOX_LIST_FOLDER_CONTINUE_PATH, dropboxListFolderContinueRequest{cursor}, &page); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// GetFileReader returns the reader of the Dropbox file, downloaded in chunks like Drive files are
+func (source *DropboxFileSource) GetFileReader(file *drive.File) (reader io.ReadCloser, err error) {
+	return GetFileReader(source.Context, source.Transport, file)
+}
+
+// dropboxDownloadUrl returns the url of the download of the file with the given id. The argument is passed in the url,
+// as Dropbox allows, so that the download is a plain GET that can be requested in ranges.
+func dropboxDownloadUrl(contentUrl string, id string) (downloadUrl string, err error) {
+	argument, err := json.Marshal(dropboxDownloadArgument{id})
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("arg", string(argument))
+	return contentUrl + DROPBOX_DOWNLOAD_PATH + "?" + query.Encode(), nil
+}
+
+// callDropbox calls the Dropbox rpc endpoint with body encoded as json and decodes the response into value
+func callDropbox(client *http.Client, endpoint string, body interface{}, value interface{}) (err error) {
+	var encoded bytes.Buffer
+	if err = json.NewEncoder(&encoded).Encode(body); err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", endpoint, &encoded)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Content-Type", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("Error calling dropbox api [%s], got status [%s]", endpoint, response.Status))
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
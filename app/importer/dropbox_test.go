@@ -0,0 +1,87 @@
+package importer_test
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newDropboxServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+			t.Errorf("Expected a json request but got error: %v", err)
+		}
+
+		switch request.URL.Path {
+		case DROPBOX_LIST_FOLDER_PATH:
+			if body["recursive"] != true {
+				t.Errorf("Expected a recursive listing but got %v", body)
+			}
+			fmt.Fprint(writer, `{"entries":[{".tag":"folder","id":"id:folder","name":"dexcom"},
+				{".tag":"file","id":"id:recent","name":"export.csv","server_modified":"2016-01-15T20:30:00Z","content_hash":"a1b2c3"},
+				{".tag":"file","id":"id:old","name":"old.csv","server_modified":"2016-01-01T20:30:00Z","content_hash":"d4e5f6"}],
+				"cursor":"next","has_more":true}`)
+		case DROPBOX_LIST_FOLDER_CONTINUE_PATH:
+			if body["cursor"] != "next" {
+				t.Errorf("Expected the cursor of the first page but got %v", body)
+			}
+			fmt.Fprint(writer, `{"entries":[{".tag":"file","id":"id:photo","name":"photo.jpg","server_modified":"2016-01-15T20:30:00Z"},
+				{".tag":"file","id":"id:archive","name":"clarity.zip","server_modified":"2016-01-16T08:00:00Z","content_hash":"g7h8i9"}],
+				"cursor":"last","has_more":false}`)
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+}
+
+func TestDropboxSearchDataFiles(t *testing.T) {
+	server := newDropboxServer(t)
+	defer server.Close()
+
+	source := &DropboxFileSource{nil, http.DefaultTransport, server.URL, "https://content.example.com"}
+	files, err := source.SearchDataFiles(time.Date(2016, time.January, 10, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 || files[0].Id != "id:recent" || files[1].Id != "id:archive" {
+		t.Fatalf("Expected the recent csv and the archive but got %v", files)
+	}
+
+	if files[0].OriginalFilename != "export.csv" || files[0].Md5Checksum != "a1b2c3" || files[0].ModifiedDate != "2016-01-15T20:30:00Z" {
+		t.Errorf("Expected the name, content hash and modified date of the file but got %v", files[0])
+	}
+
+	downloadUrl, err := url.Parse(files[0].DownloadUrl)
+	if err != nil || downloadUrl.Path != DROPBOX_DOWNLOAD_PATH || downloadUrl.Query().Get("arg") != `{"path":"id:recent"}` {
+		t.Errorf("Expected the download url of the file by id but got [%s]", files[0].DownloadUrl)
+	}
+}
+
+func TestDropboxSearchDataFilesFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	source := &DropboxFileSource{nil, http.DefaultTransport, server.URL, server.URL}
+	if _, err := source.SearchDataFiles(time.Time{}); err == nil {
+		t.Errorf("Expected an error on an unauthorized call")
+	}
+}
+
+func TestFileImportLogIdPrefixesSourcesOtherThanDrive(t *testing.T) {
+	if id := FileImportLogId(FILE_SOURCE_DRIVE, "abc"); id != "abc" {
+		t.Errorf("Expected unprefixed id of drive file but got [%s]", id)
+	}
+
+	if id := FileImportLogId(FILE_SOURCE_DROPBOX, "id:abc"); id != "dropbox:id:abc" {
+		t.Errorf("Expected prefixed id of dropbox file but got [%s]", id)
+	}
+}
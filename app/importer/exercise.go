@@ -0,0 +1,33 @@
+package importer
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"strings"
+	"time"
+)
+
+// Dexcom intensity codes (lower-cased) of receivers and Clarity exports mapped to their canonical intensity
+var dexcomExerciseIntensities = map[string]apimodel.ExerciseIntensity{
+	"light":  apimodel.EXERCISE_INTENSITY_LIGHT,
+	"medium": apimodel.EXERCISE_INTENSITY_MEDIUM,
+	"heavy":  apimodel.EXERCISE_INTENSITY_HEAVY,
+}
+
+// NormalizeExercise returns the exercise with the canonical intensity of the Dexcom intensity code and the duration in
+// whole minutes. Codes that aren't known are kept as the RawIntensity of an exercise of unknown intensity. False is
+// returned for the zero-duration placeholders that Dexcom receivers record when an exercise session is cancelled;
+// those shouldn't be imported.
+func NormalizeExercise(exerciseTime apimodel.Time, intensityCode string, duration time.Duration, description string) (exercise apimodel.Exercise, ok bool) {
+	durationMinutes := int((duration + time.Minute/2) / time.Minute)
+	if durationMinutes <= 0 {
+		return exercise, false
+	}
+
+	intensityCode = strings.TrimSpace(intensityCode)
+	intensity, known := dexcomExerciseIntensities[strings.ToLower(intensityCode)]
+	if !known {
+		return apimodel.Exercise{exerciseTime, durationMinutes, apimodel.EXERCISE_INTENSITY_UNKNOWN, description, intensityCode, "", false, false, apimodel.EXERCISE_SOURCE_DEVICE}, true
+	}
+
+	return apimodel.Exercise{exerciseTime, durationMinutes, intensity, description, "", "", false, false, apimodel.EXERCISE_SOURCE_DEVICE}, true
+}
@@ -0,0 +1,52 @@
+package importer_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"testing"
+	"time"
+)
+
+func TestNormalizeExercise(t *testing.T) {
+	exerciseTime := apimodel.Time{apimodel.GetTimeMillis(time.Date(2016, 1, 15, 7, 0, 0, 0, time.UTC)), "UTC"}
+	tests := []struct {
+		code              string
+		duration          time.Duration
+		expectedIntensity apimodel.ExerciseIntensity
+		expectedRaw       string
+		expectedMinutes   int
+	}{
+		{"Light", 30 * time.Minute, apimodel.EXERCISE_INTENSITY_LIGHT, "", 30},
+		{"Medium", 45 * time.Minute, apimodel.EXERCISE_INTENSITY_MEDIUM, "", 45},
+		{"Heavy", 20 * time.Minute, apimodel.EXERCISE_INTENSITY_HEAVY, "", 20},
+		{"heavy ", 20 * time.Minute, apimodel.EXERCISE_INTENSITY_HEAVY, "", 20},
+		{"LIGHT", 90 * time.Second, apimodel.EXERCISE_INTENSITY_LIGHT, "", 2},
+		{"", 10 * time.Minute, apimodel.EXERCISE_INTENSITY_UNKNOWN, "", 10},
+		{"Extreme", 10 * time.Minute, apimodel.EXERCISE_INTENSITY_UNKNOWN, "Extreme", 10},
+	}
+
+	for _, test := range tests {
+		exercise, ok := NormalizeExercise(exerciseTime, test.code, test.duration, "")
+		if !ok {
+			t.Errorf("Expected exercise [%s] of [%v] to be kept", test.code, test.duration)
+			continue
+		}
+
+		if exercise.Intensity != test.expectedIntensity || exercise.RawIntensity != test.expectedRaw || exercise.DurationMinutes != test.expectedMinutes {
+			t.Errorf("Expected [%s] of [%v] to be [%s] (raw [%s]) for [%d] minutes but got [%v]", test.code, test.duration,
+				test.expectedIntensity, test.expectedRaw, test.expectedMinutes, exercise)
+		}
+
+		if exercise.Time != exerciseTime {
+			t.Errorf("Expected exercise time [%v] but got [%v]", exerciseTime, exercise.Time)
+		}
+	}
+}
+
+func TestNormalizeExerciseDropsCancelledSessions(t *testing.T) {
+	for _, duration := range []time.Duration{0, 20 * time.Second} {
+		if exercise, ok := NormalizeExercise(apimodel.Time{0, "UTC"}, "Light", duration, ""); ok {
+			t.Errorf("Expected exercise of [%v] to be dropped but got [%v]", duration, exercise)
+		}
+	}
+}
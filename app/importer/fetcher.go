@@ -2,6 +2,7 @@ package importer
 
 import (
 	"fmt"
+	"github.com/alexandre-normand/glukit/app/model"
 	"github.com/alexandre-normand/glukit/app/util"
 	"github.com/alexandre-normand/glukit/lib/drive"
 	"golang.org/x/net/context"
@@ -12,23 +13,40 @@ import (
 	"time"
 )
 
-// SearchDataFiles does a search on GoogleDrive for any file that look like it's a Dexcom xml file.
-// The search is restricted to files that have a modified date after the given last update time.
-func SearchDataFiles(client *http.Client, lastUpdate time.Time) (file []*drive.File, err error) {
+const (
+	// Drive search criteria matching the content of Dexcom xml files and of Clarity, CareLink and Libre exports
+	DATA_FILE_CONTENT_QUERY = "(fullText contains \"<Glucose\" and fullText contains \"<Patient Id=\") or (fullText contains \"Event Type\" and fullText contains \"Transmitter ID\") or (fullText contains \"Sensor Glucose\" and fullText contains \"Bolus Volume\") or (fullText contains \"Historic Glucose\" and fullText contains \"Record Type\")"
+
+	// Drive search criteria matching the mime types of xml and csv files along with zip and gzip archives
+	DATA_FILE_MIME_TYPE_QUERY = "mimeType = 'text/xml' or mimeType = 'application/xml' or mimeType = 'text/csv' or mimeType = 'text/comma-separated-values' or mimeType = 'text/tab-separated-values' or mimeType = 'text/plain'"
+	ARCHIVE_MIME_TYPE_QUERY   = "mimeType = 'application/zip' or mimeType = 'application/x-gzip' or mimeType = 'application/gzip'"
+)
+
+// SearchDataFiles does a search on GoogleDrive for any file that look like it's a Dexcom xml file, a Dexcom Clarity csv export,
+// a Medtronic CareLink csv export or an Abbott FreeStyle Libre export. The content of zip and gzip archives isn't indexed
+// so all of them are listed and their data files are only recognized once they're opened (see OpenDataFiles).
+// The search is restricted to files that have a modified date after the given last update time. The files listed carry
+// their Md5Checksum so that those unchanged since their last import can be skipped (see IsUnchanged) without being
+// downloaded. See DataFileQueries for how a folder id changes the search. Files matched by more than one query are only
+// listed once.
+func SearchDataFiles(client *http.Client, lastUpdate time.Time, folderId string) (file []*drive.File, err error) {
 	var files []*drive.File
 
 	if service, err := drive.New(client); err != nil {
 		return nil, err
 	} else {
-		query := fmt.Sprintf("fullText contains \"<Glucose\" and fullText contains \"<Patient Id=\" and trashed=false and modifiedDate > '%s'", lastUpdate.Format(util.DRIVE_TIMEFORMAT))
-		call := service.Files.List().MaxResults(100).Q(query)
-		if filelist, err := call.Do(); err != nil {
-			return nil, err
-		} else {
-			for i := range filelist.Items {
-				file := filelist.Items[i]
-				if strings.HasSuffix(file.OriginalFilename, ".xml") {
-					files = append(files, file)
+		found := make(map[string]bool)
+		for _, query := range DataFileQueries(lastUpdate, folderId) {
+			call := service.Files.List().MaxResults(100).Q(query)
+			if filelist, err := call.Do(); err != nil {
+				return nil, err
+			} else {
+				for i := range filelist.Items {
+					file := filelist.Items[i]
+					if !found[file.Id] && (IsDataFileName(file.OriginalFilename) || IsArchiveName(file.OriginalFilename)) {
+						found[file.Id] = true
+						files = append(files, file)
+					}
 				}
 			}
 		}
@@ -37,7 +55,34 @@ func SearchDataFiles(client *http.Client, lastUpdate time.Time) (file []*drive.F
 	return files, nil
 }
 
-// GetFileReader returns the file reader for the GoogleDrive file. The caller is responsible for calling Close() when done.
+// DataFileQueries returns the Drive queries to search for data files modified after the given last update time. Without
+// a folder id, the whole Drive of the user is searched for files that have the content of a data file. With a folder id,
+// every xml, csv or archive file of that folder is listed, without looking at their content since the user chose the
+// folder, and the files shared with the user (i.e. by a clinic) that have the content of a data file are listed as well.
+func DataFileQueries(lastUpdate time.Time, folderId string) (queries []string) {
+	modifiedAfter := fmt.Sprintf("trashed=false and modifiedDate > '%s'", lastUpdate.Format(util.DRIVE_TIMEFORMAT))
+	if folderId == "" {
+		return []string{fmt.Sprintf("(%s or %s) and %s", DATA_FILE_CONTENT_QUERY, ARCHIVE_MIME_TYPE_QUERY, modifiedAfter)}
+	}
+
+	return []string{
+		fmt.Sprintf("'%s' in parents and (%s or %s) and %s", strings.Replace(folderId, "'", "\\'", -1), DATA_FILE_MIME_TYPE_QUERY,
+			ARCHIVE_MIME_TYPE_QUERY, modifiedAfter),
+		fmt.Sprintf("sharedWithMe and (%s or %s) and %s", DATA_FILE_CONTENT_QUERY, ARCHIVE_MIME_TYPE_QUERY, modifiedAfter),
+	}
+}
+
+// IsUnchanged returns true if the file has the same checksum as when it was last imported and that import either
+// succeeded or failed permanently. Drive reports files as modified on metadata changes alone so this avoids downloading
+// and parsing those again, and a file that can't be imported is only tried again once its content changes.
+func IsUnchanged(file *drive.File, lastImport *model.FileImportLog) bool {
+	return lastImport != nil && (lastImport.Succeeded || lastImport.PermanentlyFailed) && file.Md5Checksum != "" &&
+		file.Md5Checksum == lastImport.Md5Checksum
+}
+
+// GetFileReader returns the file reader for the GoogleDrive file. The file is downloaded in chunks of DOWNLOAD_CHUNK_SIZE
+// bytes as it's read so that large files don't go over the size and deadline limits of a single urlfetch. The caller is
+// responsible for calling Close() when done.
 func GetFileReader(context context.Context, client http.RoundTripper, file *drive.File) (reader io.ReadCloser, err error) {
 	// t parameter should use an oauth.Transport
 	downloadUrl := file.DownloadUrl
@@ -46,20 +91,12 @@ func GetFileReader(context context.Context, client http.RoundTripper, file *driv
 		log.Errorf(context, "An error occurred: File is not downloadable")
 		return nil, nil
 	}
-	req, err := http.NewRequest("GET", downloadUrl, nil)
-	if err != nil {
-		log.Errorf(context, "An error occurred: %v\n", err)
-		return nil, err
-	}
-	// Request for compressed files to make download faster
-	req.Header.Add("Accept-Encoding", "gzip")
-	req.Header.Add("User-agent", "glukit (gzip)")
 
-	resp, err := client.RoundTrip(req)
+	reader, err = NewRangeReader(context, client, downloadUrl, DOWNLOAD_CHUNK_SIZE)
 	if err != nil {
 		log.Errorf(context, "An error occurred: %v\n", err)
 		return nil, err
 	}
 
-	return resp.Body, nil
+	return reader, nil
 }
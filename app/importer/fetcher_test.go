@@ -0,0 +1,75 @@
+package importer_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/lib/drive"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsUnchangedWithMatchingChecksum(t *testing.T) {
+	file := &drive.File{Id: "file", Md5Checksum: "a1b2c3"}
+	if !IsUnchanged(file, &model.FileImportLog{Id: "file", Md5Checksum: "a1b2c3", Succeeded: true}) {
+		t.Errorf("Expected file with the same checksum as its last successful import to be unchanged")
+	}
+
+	if IsUnchanged(file, &model.FileImportLog{Id: "file", Md5Checksum: "a1b2c3", Succeeded: false}) {
+		t.Errorf("Expected file whose last import failed to be imported again")
+	}
+}
+
+func TestIsUnchangedAfterPermanentFailure(t *testing.T) {
+	lastImport := &model.FileImportLog{Id: "file", Md5Checksum: "a1b2c3", Attempts: 5, PermanentlyFailed: true}
+	if !IsUnchanged(&drive.File{Id: "file", Md5Checksum: "a1b2c3"}, lastImport) {
+		t.Errorf("Expected file that failed permanently not to be imported again until it changes")
+	}
+
+	if IsUnchanged(&drive.File{Id: "file", Md5Checksum: "d4e5f6"}, lastImport) {
+		t.Errorf("Expected file that failed permanently to be imported again once its content changed")
+	}
+}
+
+func TestIsUnchangedWithDifferentChecksum(t *testing.T) {
+	file := &drive.File{Id: "file", Md5Checksum: "d4e5f6"}
+	if IsUnchanged(file, &model.FileImportLog{Id: "file", Md5Checksum: "a1b2c3", Succeeded: true}) {
+		t.Errorf("Expected file with a different checksum to be imported again")
+	}
+}
+
+func TestIsUnchangedOnFirstImport(t *testing.T) {
+	if IsUnchanged(&drive.File{Id: "file", Md5Checksum: "a1b2c3"}, nil) {
+		t.Errorf("Expected file never imported to be imported")
+	}
+}
+
+func TestDataFileQueriesWithoutFolder(t *testing.T) {
+	queries := DataFileQueries(time.Date(2015, 3, 1, 0, 0, 0, 0, time.UTC), "")
+	if len(queries) != 1 {
+		t.Fatalf("Expected a single query for the whole drive but got [%v]", queries)
+	}
+
+	if !strings.Contains(queries[0], "fullText contains") || !strings.Contains(queries[0], "modifiedDate > '2015-03-01T00:00:00.000Z'") {
+		t.Errorf("Expected a content query of files modified after the last update but got [%s]", queries[0])
+	}
+
+	if strings.Contains(queries[0], "in parents") || strings.Contains(queries[0], "sharedWithMe") {
+		t.Errorf("Expected the search not to be scoped to a folder but got [%s]", queries[0])
+	}
+}
+
+func TestDataFileQueriesWithFolder(t *testing.T) {
+	queries := DataFileQueries(time.Date(2015, 3, 1, 0, 0, 0, 0, time.UTC), "0B1x2y3z")
+	if len(queries) != 2 {
+		t.Fatalf("Expected a folder query and a shared files query but got [%v]", queries)
+	}
+
+	if !strings.HasPrefix(queries[0], "'0B1x2y3z' in parents") || !strings.Contains(queries[0], "mimeType = 'text/csv'") {
+		t.Errorf("Expected the csv and xml files of the folder to be searched but got [%s]", queries[0])
+	}
+
+	if !strings.HasPrefix(queries[1], "sharedWithMe") || !strings.Contains(queries[1], "fullText contains") {
+		t.Errorf("Expected the shared files with data content to be searched but got [%s]", queries[1])
+	}
+}
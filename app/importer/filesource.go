@@ -0,0 +1,60 @@
+package importer
+
+import (
+	"github.com/alexandre-normand/glukit/lib/drive"
+	"golang.org/x/net/context"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// Names of the sources of data files. Drive files were imported before there were other sources so the ids of
+	// their import logs aren't prefixed, see FileImportLogId.
+	FILE_SOURCE_DRIVE   = ""
+	FILE_SOURCE_DROPBOX = "dropbox"
+)
+
+// FileSource is a place data files of a user are searched for and downloaded from. Files of every source are described
+// by the Drive file metadata imports were first built on: their id, original filename, modified date, checksum and
+// download url.
+type FileSource interface {
+	// SearchDataFiles lists the data files and archives modified after since
+	SearchDataFiles(since time.Time) (files []*drive.File, err error)
+
+	// GetFileReader returns the reader of the content of a file listed by SearchDataFiles. The caller is responsible for
+	// calling Close() when done.
+	GetFileReader(file *drive.File) (reader io.ReadCloser, err error)
+}
+
+// FileImportLogId returns the id of the FileImportLog of a file of the source. Ids of files of sources other than Drive
+// are prefixed with the name of their source so that they can't collide with the ones of Drive files, which are kept
+// unprefixed so that existing logs still apply.
+func FileImportLogId(source string, fileId string) string {
+	if source == FILE_SOURCE_DRIVE {
+		return fileId
+	}
+
+	return source + ":" + fileId
+}
+
+// DriveFileSource is the Google Drive of a user. Files are searched in FolderId, if set, or in the whole Drive
+// otherwise (see DataFileQueries).
+type DriveFileSource struct {
+	Context   context.Context
+	Transport http.RoundTripper
+	FolderId  string
+}
+
+// NewDriveFileSource returns the Drive of the user authorized by transport
+func NewDriveFileSource(context context.Context, transport http.RoundTripper, folderId string) *DriveFileSource {
+	return &DriveFileSource{context, transport, folderId}
+}
+
+func (source *DriveFileSource) SearchDataFiles(since time.Time) (files []*drive.File, err error) {
+	return SearchDataFiles(&http.Client{Transport: source.Transport}, since, source.FolderId)
+}
+
+func (source *DriveFileSource) GetFileReader(file *drive.File) (reader io.ReadCloser, err error) {
+	return GetFileReader(source.Context, source.Transport, file)
+}
@@ -0,0 +1,306 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// OAuth scope of the Fit activity data, requested on top of the profile scope once the user enables Google Fit
+	GOOGLE_FIT_SCOPE = "https://www.googleapis.com/auth/fitness.activity.read"
+
+	// Fit api endpoints, relative to the base url of the api
+	GOOGLE_FIT_API_URL        = "https://www.googleapis.com/fitness/v1/users/me"
+	GOOGLE_FIT_SESSIONS_PATH  = "/sessions"
+	GOOGLE_FIT_AGGREGATE_PATH = "/dataset:aggregate"
+
+	GOOGLE_FIT_CALORIES_DATA_TYPE = "com.google.calories.expended"
+
+	// Sessions shorter than GOOGLE_FIT_MIN_SESSION_DURATION aren't workouts worth importing
+	GOOGLE_FIT_MIN_SESSION_DURATION = 5 * time.Minute
+
+	// Calories burned per minute above which a session is of medium or heavy intensity, regardless of its activity
+	GOOGLE_FIT_MEDIUM_CALORIES_PER_MINUTE = 5.
+	GOOGLE_FIT_HEAVY_CALORIES_PER_MINUTE  = 9.
+)
+
+// Intensity of the Fit activity types that are exercise, used when a session has no calories. Activity types that
+// aren't listed, like sleeping or being in a vehicle, aren't exercise and their sessions are skipped. See
+// https://developers.google.com/fit/rest/v1/reference/activity-types.
+var googleFitActivityIntensities = map[int]apimodel.ExerciseIntensity{
+	1:   apimodel.EXERCISE_INTENSITY_MEDIUM, // Biking
+	7:   apimodel.EXERCISE_INTENSITY_LIGHT,  // Walking
+	8:   apimodel.EXERCISE_INTENSITY_HEAVY,  // Running
+	9:   apimodel.EXERCISE_INTENSITY_MEDIUM, // Aerobics
+	24:  apimodel.EXERCISE_INTENSITY_MEDIUM, // Dancing
+	35:  apimodel.EXERCISE_INTENSITY_LIGHT,  // Hiking
+	80:  apimodel.EXERCISE_INTENSITY_MEDIUM, // Strength training
+	82:  apimodel.EXERCISE_INTENSITY_HEAVY,  // Swimming
+	100: apimodel.EXERCISE_INTENSITY_LIGHT,  // Yoga
+	108: apimodel.EXERCISE_INTENSITY_UNKNOWN,
+	113: apimodel.EXERCISE_INTENSITY_HEAVY, // Crossfit
+	114: apimodel.EXERCISE_INTENSITY_HEAVY, // HIIT
+}
+
+// GoogleFitSession is a session as returned by the Fit sessions api. Calories is set from the aggregate of the
+// calories expended during the session, zero if Fit doesn't have any.
+type GoogleFitSession struct {
+	Id              string  `json:"id"`
+	Name            string  `json:"name"`
+	Description     string  `json:"description"`
+	StartTimeMillis string  `json:"startTimeMillis"`
+	EndTimeMillis   string  `json:"endTimeMillis"`
+	ActivityType    int     `json:"activityType"`
+	Calories        float64 `json:"-"`
+}
+
+type googleFitSessionsResponse struct {
+	Session       []GoogleFitSession `json:"session"`
+	NextPageToken string             `json:"nextPageToken"`
+}
+
+// googleFitAggregateRequest is the request of the calories expended during each session of a time range
+type googleFitAggregateRequest struct {
+	AggregateBy     []googleFitAggregateBy `json:"aggregateBy"`
+	BucketBySession struct {
+		MinDurationMillis int64 `json:"minDurationMillis"`
+	} `json:"bucketBySession"`
+	StartTimeMillis int64 `json:"startTimeMillis"`
+	EndTimeMillis   int64 `json:"endTimeMillis"`
+}
+
+type googleFitAggregateBy struct {
+	DataTypeName string `json:"dataTypeName"`
+}
+
+type googleFitAggregateResponse struct {
+	Bucket []struct {
+		Session GoogleFitSession `json:"session"`
+		Dataset []struct {
+			Point []struct {
+				Value []struct {
+					FpVal float64 `json:"fpVal"`
+				} `json:"value"`
+			} `json:"point"`
+		} `json:"dataset"`
+	} `json:"bucket"`
+}
+
+// ImportGoogleFitSessions fetches the Fit sessions of the user that started after startTime and persists them as
+// exercises. The end of the last session imported is returned to be used as the startTime of the next import.
+func ImportGoogleFitSessions(context context.Context, client *http.Client, parentKey *datastore.Key, startTime time.Time) (lastSessionEnd time.Time, recordCount int, err error) {
+	sessions, err := FetchGoogleFitSessions(client, GOOGLE_FIT_API_URL, startTime, time.Now())
+	if err != nil {
+		return startTime, 0, err
+	}
+
+	exercises := ConvertGoogleFitSessions(sessions, locationOfGoogleFitUser(context, parentKey))
+	lastSessionEnd = startTime
+	for _, exercise := range exercises {
+		if end := exercise.GetTime().Add(time.Duration(exercise.DurationMinutes) * time.Minute); end.After(lastSessionEnd) {
+			lastSessionEnd = end
+		}
+	}
+
+	streamers := newDataStoreImportStreamers(context, parentKey, apimodel.DEFAULT_DEVICE_ID)
+	if streamers.Exercise, err = streamers.Exercise.WriteExercises(exercises); err != nil {
+		return startTime, 0, err
+	}
+
+	if err = streamers.Close(); err != nil {
+		return startTime, 0, err
+	}
+
+	log.Infof(context, "Done importing [%d] google fit sessions out of [%d] starting at [%v]", len(exercises), len(sessions), startTime)
+	return lastSessionEnd, len(exercises), nil
+}
+
+// locationOfGoogleFitUser returns the timezone exercises from Fit are recorded in. Fit times have no timezone so the
+// one of the user's most recent read is used, UTC if the user has no reads.
+func locationOfGoogleFitUser(context context.Context, parentKey *datastore.Key) *time.Location {
+	glukitUser, err := store.GetUserProfileCached(context, parentKey)
+	if err != nil {
+		log.Warningf(context, "Error reading user profile [%s], importing google fit sessions in UTC: %v", parentKey.StringID(), err)
+		return time.UTC
+	}
+
+	return glukitUser.MostRecentRead.GetTime().Location()
+}
+
+// FetchGoogleFitSessions pages through the Fit sessions between startTime (exclusive) and endTime and sets the calories
+// expended during each of them
+func FetchGoogleFitSessions(client *http.Client, apiUrl string, startTime time.Time, endTime time.Time) (sessions []GoogleFitSession, err error) {
+	sessions = make([]GoogleFitSession, 0)
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("startTime", startTime.UTC().Format(time.RFC3339Nano))
+		query.Set("endTime", endTime.UTC().Format(time.RFC3339Nano))
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var page googleFitSessionsResponse
+		if err = callGoogleFit(client, "GET", apiUrl+GOOGLE_FIT_SESSIONS_PATH+"?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+
+		for _, session := range page.Session {
+			if start, err := session.startTime(); err == nil && start.After(startTime) {
+				sessions = append(sessions, session)
+			}
+		}
+
+		if page.NextPageToken == "" || len(page.Session) == 0 {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+
+	if len(sessions) == 0 {
+		return sessions, nil
+	}
+
+	calories, err := fetchGoogleFitCalories(client, apiUrl, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Calories = calories[sessions[i].Id]
+	}
+
+	return sessions, nil
+}
+
+// fetchGoogleFitCalories returns the calories expended during each session between startTime and endTime, keyed by
+// session id
+func fetchGoogleFitCalories(client *http.Client, apiUrl string, startTime time.Time, endTime time.Time) (calories map[string]float64, err error) {
+	request := googleFitAggregateRequest{AggregateBy: []googleFitAggregateBy{googleFitAggregateBy{GOOGLE_FIT_CALORIES_DATA_TYPE}},
+		StartTimeMillis: apimodel.GetTimeMillis(startTime), EndTimeMillis: apimodel.GetTimeMillis(endTime)}
+	request.BucketBySession.MinDurationMillis = int64(GOOGLE_FIT_MIN_SESSION_DURATION / time.Millisecond)
+
+	var response googleFitAggregateResponse
+	if err = callGoogleFit(client, "POST", apiUrl+GOOGLE_FIT_AGGREGATE_PATH, request, &response); err != nil {
+		return nil, err
+	}
+
+	calories = make(map[string]float64)
+	for _, bucket := range response.Bucket {
+		for _, dataset := range bucket.Dataset {
+			for _, point := range dataset.Point {
+				for _, value := range point.Value {
+					calories[bucket.Session.Id] += value.FpVal
+				}
+			}
+		}
+	}
+
+	return calories, nil
+}
+
+// callGoogleFit calls the Fit api endpoint with body encoded as json, if not nil, and decodes the response into value
+func callGoogleFit(client *http.Client, method string, endpoint string, body interface{}, value interface{}) (err error) {
+	var encoded bytes.Buffer
+	if body != nil {
+		if err = json.NewEncoder(&encoded).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequest(method, endpoint, &encoded)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Add("Accept", "application/json")
+	if body != nil {
+		request.Header.Add("Content-Type", "application/json")
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("Error calling google fit api [%s], got status [%s]", endpoint, response.Status))
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// ConvertGoogleFitSessions converts the sessions of exercise activities to exercises in location, sorted
+// chronologically. Sessions of other activities and sessions shorter than GOOGLE_FIT_MIN_SESSION_DURATION are skipped.
+func ConvertGoogleFitSessions(sessions []GoogleFitSession, location *time.Location) (exercises apimodel.ExerciseSlice) {
+	exercises = make(apimodel.ExerciseSlice, 0, len(sessions))
+	for _, session := range sessions {
+		activityIntensity, isExercise := googleFitActivityIntensities[session.ActivityType]
+		start, startErr := session.startTime()
+		end, endErr := session.endTime()
+		if !isExercise || startErr != nil || endErr != nil || end.Sub(start) < GOOGLE_FIT_MIN_SESSION_DURATION {
+			continue
+		}
+
+		durationMinutes := int((end.Sub(start) + time.Minute/2) / time.Minute)
+		intensity := googleFitIntensity(activityIntensity, session.Calories, durationMinutes)
+		description := session.Name
+		if description == "" {
+			description = session.Description
+		}
+
+		exercises = append(exercises, apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(start), location.String()}, durationMinutes,
+			intensity, description, "", "", false, false, apimodel.EXERCISE_SOURCE_GOOGLE_FIT})
+	}
+
+	sort.Sort(exercises)
+	return exercises
+}
+
+// googleFitIntensity returns the intensity of a session from the rate of calories it burned, if known, or from the
+// intensity of its activity otherwise
+func googleFitIntensity(activityIntensity apimodel.ExerciseIntensity, calories float64, durationMinutes int) apimodel.ExerciseIntensity {
+	if calories <= 0 || durationMinutes <= 0 {
+		return activityIntensity
+	}
+
+	switch caloriesPerMinute := calories / float64(durationMinutes); {
+	case caloriesPerMinute >= GOOGLE_FIT_HEAVY_CALORIES_PER_MINUTE:
+		return apimodel.EXERCISE_INTENSITY_HEAVY
+	case caloriesPerMinute >= GOOGLE_FIT_MEDIUM_CALORIES_PER_MINUTE:
+		return apimodel.EXERCISE_INTENSITY_MEDIUM
+	default:
+		return apimodel.EXERCISE_INTENSITY_LIGHT
+	}
+}
+
+func (session GoogleFitSession) startTime() (time.Time, error) {
+	return parseGoogleFitMillis(session.StartTimeMillis)
+}
+
+func (session GoogleFitSession) endTime() (time.Time, error) {
+	return parseGoogleFitMillis(session.EndTimeMillis)
+}
+
+// parseGoogleFitMillis parses the milliseconds since the epoch that Fit sends as strings
+func parseGoogleFitMillis(value string) (time.Time, error) {
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond)), nil
+}
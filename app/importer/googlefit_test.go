@@ -0,0 +1,78 @@
+package importer_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newGoogleFitServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case GOOGLE_FIT_SESSIONS_PATH:
+			// Two pages, the second one with a session that started before the start of the sync
+			if request.URL.Query().Get("pageToken") == "" {
+				fmt.Fprint(writer, `{"session":[{"id":"run","name":"Morning run","startTimeMillis":"1452871800000","endTimeMillis":"1452874500000","activityType":8},
+					{"id":"drive","startTimeMillis":"1452875000000","endTimeMillis":"1452878600000","activityType":0}],"nextPageToken":"next"}`)
+			} else {
+				fmt.Fprint(writer, `{"session":[{"id":"walk","description":"Walk","startTimeMillis":"1452880000000","endTimeMillis":"1452881800000","activityType":7},
+					{"id":"old","startTimeMillis":"1452800000000","endTimeMillis":"1452803600000","activityType":8}]}`)
+			}
+		case GOOGLE_FIT_AGGREGATE_PATH:
+			var body map[string]interface{}
+			if err := json.NewDecoder(request.Body).Decode(&body); err != nil || request.Method != "POST" {
+				t.Errorf("Expected a json aggregate request but got [%s]: %v", request.Method, err)
+			}
+			fmt.Fprint(writer, `{"bucket":[{"session":{"id":"walk"},"dataset":[{"point":[{"value":[{"fpVal":100}]},{"value":[{"fpVal":80}]}]}]}]}`)
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+}
+
+func TestFetchAndConvertGoogleFitSessions(t *testing.T) {
+	server := newGoogleFitServer(t)
+	defer server.Close()
+
+	startTime := time.Unix(1452844800, 0)
+	sessions, err := FetchGoogleFitSessions(http.DefaultClient, server.URL, startTime, startTime.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 3 {
+		t.Fatalf("Expected 3 sessions after the start time but got %v", sessions)
+	}
+
+	exercises := ConvertGoogleFitSessions(sessions, time.UTC)
+	if len(exercises) != 2 {
+		t.Fatalf("Expected 2 exercises without the drive but got %v", exercises)
+	}
+
+	// The run has no calories so its intensity is the one of running, the walk burned 6 calories a minute
+	run, walk := exercises[0], exercises[1]
+	if run.DurationMinutes != 45 || run.Intensity != apimodel.EXERCISE_INTENSITY_HEAVY || run.Description != "Morning run" ||
+		run.Source != apimodel.EXERCISE_SOURCE_GOOGLE_FIT {
+		t.Errorf("Expected a heavy run of 45 minutes from google fit but got %v", run)
+	}
+
+	if walk.DurationMinutes != 30 || walk.Intensity != apimodel.EXERCISE_INTENSITY_MEDIUM || walk.Description != "Walk" {
+		t.Errorf("Expected a medium walk of 30 minutes but got %v", walk)
+	}
+}
+
+func TestFetchGoogleFitSessionsFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	if _, err := FetchGoogleFitSessions(http.DefaultClient, server.URL, time.Unix(1452844800, 0), time.Now()); err == nil {
+		t.Errorf("Expected an error on an unauthorized call")
+	}
+}
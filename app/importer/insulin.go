@@ -0,0 +1,67 @@
+package importer
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"strings"
+)
+
+// insulinKeyword maps a keyword of insulin descriptions to the insulin it identifies. Name is the brand name of the
+// insulin and is empty for keywords that only give its category (i.e. "long-acting").
+type insulinKeyword struct {
+	keyword  string
+	name     string
+	category apimodel.InsulinCategory
+}
+
+// insulinKeywords is the table of keywords, lower-cased, looked for in the insulin descriptions of sources. The first
+// matching keyword wins so keywords of premixed insulins come before the ones of the brands they're mixed from and
+// generic keywords come last. Add new brands here.
+var insulinKeywords = []insulinKeyword{
+	{"humalog mix", "Humalog Mix", apimodel.INSULIN_CATEGORY_MIXED},
+	{"novolog mix", "NovoLog Mix", apimodel.INSULIN_CATEGORY_MIXED},
+	{"novomix", "NovoMix", apimodel.INSULIN_CATEGORY_MIXED},
+	{"humulin 70/30", "Humulin 70/30", apimodel.INSULIN_CATEGORY_MIXED},
+	{"novolin 70/30", "Novolin 70/30", apimodel.INSULIN_CATEGORY_MIXED},
+
+	{"humalog", "Humalog", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+	{"novolog", "NovoLog", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+	{"novorapid", "NovoRapid", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+	{"fiasp", "Fiasp", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+	{"apidra", "Apidra", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+	{"admelog", "Admelog", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+	{"lyumjev", "Lyumjev", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+	{"afrezza", "Afrezza", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+
+	{"lantus", "Lantus", apimodel.INSULIN_CATEGORY_LONG_ACTING},
+	{"levemir", "Levemir", apimodel.INSULIN_CATEGORY_LONG_ACTING},
+	{"tresiba", "Tresiba", apimodel.INSULIN_CATEGORY_LONG_ACTING},
+	{"toujeo", "Toujeo", apimodel.INSULIN_CATEGORY_LONG_ACTING},
+	{"basaglar", "Basaglar", apimodel.INSULIN_CATEGORY_LONG_ACTING},
+	{"semglee", "Semglee", apimodel.INSULIN_CATEGORY_LONG_ACTING},
+
+	{"mix", "", apimodel.INSULIN_CATEGORY_MIXED},
+	{"rapid", "", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+	{"fast", "", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+	{"bolus", "", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+	{"long", "", apimodel.INSULIN_CATEGORY_LONG_ACTING},
+	{"basal", "", apimodel.INSULIN_CATEGORY_LONG_ACTING},
+}
+
+// classifyInsulin returns the brand name and category of the insulin of the given description. The name is empty if
+// the description doesn't have a known brand and the category is INSULIN_CATEGORY_UNKNOWN if it can't be told.
+func classifyInsulin(description string) (name string, category apimodel.InsulinCategory) {
+	lowerCaseDescription := strings.ToLower(description)
+	for _, insulin := range insulinKeywords {
+		if strings.Contains(lowerCaseDescription, insulin.keyword) {
+			return insulin.name, insulin.category
+		}
+	}
+
+	return "", apimodel.INSULIN_CATEGORY_UNKNOWN
+}
+
+// newInjection returns an injection of the insulin of the given description, as stated by its source
+func newInjection(timestamp apimodel.Time, units float32, description string) apimodel.Injection {
+	name, category := classifyInsulin(description)
+	return apimodel.Injection{timestamp, units, name, description, category, "", false, false}
+}
@@ -0,0 +1,30 @@
+package importer
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"testing"
+)
+
+func TestClassifyInsulin(t *testing.T) {
+	var tests = []struct {
+		description      string
+		expectedName     string
+		expectedCategory apimodel.InsulinCategory
+	}{
+		{"Insulin 4.00 units (Humalog)", "Humalog", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+		{"Humalog Mix 75/25", "Humalog Mix", apimodel.INSULIN_CATEGORY_MIXED},
+		{"LANTUS", "Lantus", apimodel.INSULIN_CATEGORY_LONG_ACTING},
+		{"Fast-Acting", "", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+		{LIBRE_LONG_ACTING_INSULIN_TYPE, "", apimodel.INSULIN_CATEGORY_LONG_ACTING},
+		{"Correction Bolus", "", apimodel.INSULIN_CATEGORY_RAPID_ACTING},
+		{"Insulin 4.00 units", "", apimodel.INSULIN_CATEGORY_UNKNOWN},
+		{"", "", apimodel.INSULIN_CATEGORY_UNKNOWN},
+	}
+
+	for _, test := range tests {
+		if name, category := classifyInsulin(test.description); name != test.expectedName || category != test.expectedCategory {
+			t.Errorf("TestClassifyInsulin failed for [%s]: got [%s, %s] but expected [%s, %s]", test.description, name, category,
+				test.expectedName, test.expectedCategory)
+		}
+	}
+}
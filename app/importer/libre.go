@@ -0,0 +1,287 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Columns of the Abbott FreeStyle Libre export. Older exports name the timestamp column "Time" and have the unit
+	// of glucose columns in parentheses.
+	LIBRE_TIMESTAMP_COLUMN              = "Device Timestamp"
+	LIBRE_LEGACY_TIMESTAMP_COLUMN       = "Time"
+	LIBRE_RECORD_TYPE_COLUMN            = "Record Type"
+	LIBRE_HISTORIC_GLUCOSE_COLUMN       = "Historic Glucose"
+	LIBRE_SCAN_GLUCOSE_COLUMN           = "Scan Glucose"
+	LIBRE_RAPID_ACTING_INSULIN_COLUMN   = "Rapid-Acting Insulin (units)"
+	LIBRE_LONG_ACTING_INSULIN_COLUMN    = "Long-Acting Insulin"
+	LIBRE_CARBOHYDRATES_GRAMS_COLUMN    = "Carbohydrates (grams)"
+	LIBRE_UNITS_COLUMN_SUFFIX           = "(units)"
+	LIBRE_MMOL_PER_L_COLUMN_UNIT_MARKER = "mmol/L"
+
+	// Record types of the Abbott FreeStyle Libre export
+	LIBRE_HISTORIC_GLUCOSE_RECORD_TYPE = "0"
+	LIBRE_SCAN_GLUCOSE_RECORD_TYPE     = "1"
+	LIBRE_INSULIN_RECORD_TYPE          = "4"
+	LIBRE_FOOD_RECORD_TYPE             = "5"
+
+	// Insulin types of the injections imported from the insulin columns
+	LIBRE_RAPID_ACTING_INSULIN_TYPE = "Rapid-Acting"
+	LIBRE_LONG_ACTING_INSULIN_TYPE  = "Long-Acting"
+)
+
+// Timestamp formats found in Libre exports depending on the locale and version of the software
+var libreTimeFormats = []string{"01/02/2006 15:04", "01-02-2006 15:04", "01-02-2006 03:04 PM", "2006/01/02 15:04", "2006-01-02 15:04"}
+
+var ErrNotLibreExport = errors.New("Content is not an Abbott FreeStyle Libre export, header row not found")
+
+// LibreOptions controls how the records of a Libre export are imported
+type LibreOptions struct {
+	// ScansAsCalibrations makes scans be imported as calibration reads instead of being merged with the historic reads
+	ScansAsCalibrations bool
+}
+
+// DefaultLibreOptions merges scans with the historic reads since they're both sensor values
+var DefaultLibreOptions = LibreOptions{ScansAsCalibrations: false}
+
+// libreColumns holds the index of each column of interest of a Libre export. Optional columns that aren't present
+// have an index of -1.
+type libreColumns struct {
+	timestamp          int
+	recordType         int
+	historicGlucose    int
+	scanGlucose        int
+	rapidActingInsulin int
+	longActingInsulin  int
+	carbohydrates      int
+	unit               apimodel.GlucoseUnit
+}
+
+// IsLibreHeader returns true if the record is the header row of an Abbott FreeStyle Libre export
+func IsLibreHeader(record []string) bool {
+	_, err := newLibreColumns(record)
+	return err == nil
+}
+
+func newLibreColumns(header []string) (columns *libreColumns, err error) {
+	columns = &libreColumns{-1, -1, -1, -1, -1, -1, -1, apimodel.MG_PER_DL}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == LIBRE_TIMESTAMP_COLUMN || name == LIBRE_LEGACY_TIMESTAMP_COLUMN:
+			columns.timestamp = i
+		case name == LIBRE_RECORD_TYPE_COLUMN:
+			columns.recordType = i
+		case strings.HasPrefix(name, LIBRE_HISTORIC_GLUCOSE_COLUMN):
+			columns.historicGlucose = i
+			if strings.Contains(name, LIBRE_MMOL_PER_L_COLUMN_UNIT_MARKER) {
+				columns.unit = apimodel.MMOL_PER_L
+			}
+		case strings.HasPrefix(name, LIBRE_SCAN_GLUCOSE_COLUMN):
+			columns.scanGlucose = i
+		case name == LIBRE_RAPID_ACTING_INSULIN_COLUMN:
+			columns.rapidActingInsulin = i
+		case strings.HasPrefix(name, LIBRE_LONG_ACTING_INSULIN_COLUMN) && strings.HasSuffix(name, LIBRE_UNITS_COLUMN_SUFFIX):
+			columns.longActingInsulin = i
+		case name == LIBRE_CARBOHYDRATES_GRAMS_COLUMN:
+			columns.carbohydrates = i
+		}
+	}
+
+	if columns.timestamp < 0 || columns.recordType < 0 || columns.historicGlucose < 0 {
+		return nil, ErrNotLibreExport
+	}
+
+	return columns, nil
+}
+
+// value returns the trimmed value of the column at the given index or an empty string if the column isn't present
+func (columns *libreColumns) value(record []string, index int) string {
+	if index < 0 || index >= len(record) {
+		return ""
+	}
+
+	return strings.TrimSpace(record[index])
+}
+
+// number parses the numeric value of the column at the given index. Some locales use commas as the decimal mark so
+// those are supported as well. Missing values return an error.
+func (columns *libreColumns) number(record []string, index int) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(columns.value(record, index), ",", ".", 1), 32)
+}
+
+// glucoseValue returns the value of the glucose column at the given index normalized to mg/dL so that all reads
+// are stored with the same unit regardless of the locale of the export
+func (columns *libreColumns) glucoseValue(record []string, index int) (value float32, err error) {
+	rawValue, err := columns.number(record, index)
+	if err != nil {
+		return 0, err
+	}
+
+	read := apimodel.GlucoseRead{Unit: columns.unit, Value: float32(rawValue)}
+	return read.GetNormalizedValue(apimodel.MG_PER_DL)
+}
+
+// recordTime returns the time of the record. Libre exports don't include any timezone information so times are
+// considered to be UTC.
+func (columns *libreColumns) recordTime(record []string) (recordTime time.Time, err error) {
+	location := time.FixedZone("+0000", 0)
+	value := columns.value(record, columns.timestamp)
+	for _, format := range libreTimeFormats {
+		if recordTime, err = time.ParseInLocation(format, value, location); err == nil {
+			return recordTime, nil
+		}
+	}
+
+	return recordTime, errors.New(fmt.Sprintf("Invalid timestamp [%s]", value))
+}
+
+// IsLibreExport returns true if the beginning of the content has the historic glucose column of an Abbott FreeStyle
+// Libre export
+func IsLibreExport(beginning []byte, filename string) bool {
+	return bytes.Contains(beginning, []byte(LIBRE_HISTORIC_GLUCOSE_COLUMN))
+}
+
+// parseLibre is the Parser of the Libre format. Exports are parsed with the DefaultLibreOptions.
+func parseLibre(context context.Context, reader io.Reader, since time.Time, streamers *ImportStreamers, progress ProgressHandler) (lastReadTime time.Time, report *ImportReport, err error) {
+	return ParseLibre(context, reader, since, DefaultLibreOptions, streamers)
+}
+
+// ParseLibre reads the content of an Abbott FreeStyle Libre export and writes every record more recent than startTime
+// to the streamers. Lines preceding the header row (i.e. the device name line) are skipped. The delimiter (tab or comma)
+// is detected from the header row. Scans are interleaved with historic reads so records are buffered and only written,
+// in chronological order, once all rows are read. Rows that can't be parsed are skipped and added to the report. The
+// streamers are left open for the caller to close.
+func ParseLibre(context context.Context, reader io.Reader, startTime time.Time, options LibreOptions, streamers *ImportStreamers) (lastReadTime time.Time, report *ImportReport, err error) {
+	lastReadTime = startTime
+	report = new(ImportReport)
+
+	var columns *libreColumns
+	delimiter := '\t'
+	records := new(recordBuffer)
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if columns == nil {
+			columns, delimiter = parseLibreHeader(line)
+			continue
+		}
+
+		csvReader := csv.NewReader(strings.NewReader(line))
+		csvReader.Comma = delimiter
+		csvReader.FieldsPerRecord = -1
+		csvReader.LazyQuotes = true
+		record, err := csvReader.Read()
+		if err != nil {
+			log.Warningf(context, "Skipping malformed libre row [%s]: %v", line, err)
+			report.skip(fmt.Sprintf("line %d", lineNumber), err)
+			continue
+		}
+
+		if err = records.addLibreRecord(columns, record, startTime, options); err != nil {
+			log.Warningf(context, "Skipping libre row [%s]: %v", line, err)
+			report.skip(fmt.Sprintf("line %d", lineNumber), err)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return lastReadTime, report, err
+	}
+
+	if columns == nil {
+		return lastReadTime, report, ErrNotLibreExport
+	}
+	report.Unit = columns.unit
+
+	lastReadTime, err = records.write(lastReadTime, streamers, report)
+	return lastReadTime, report, err
+}
+
+// parseLibreHeader returns the columns of the line and its delimiter if it's a header row, nil columns otherwise
+func parseLibreHeader(line string) (columns *libreColumns, delimiter rune) {
+	for _, delimiter := range []rune{'\t', ','} {
+		csvReader := csv.NewReader(strings.NewReader(line))
+		csvReader.Comma = delimiter
+		csvReader.FieldsPerRecord = -1
+		csvReader.LazyQuotes = true
+		if record, err := csvReader.Read(); err == nil {
+			if columns, err := newLibreColumns(record); err == nil {
+				return columns, delimiter
+			}
+		}
+	}
+
+	return nil, '\t'
+}
+
+// addLibreRecord converts the row to the record it holds according to its record type. Record types that have no
+// glukit equivalent (strips, ketones, notes, etc.) are ignored.
+func (records *recordBuffer) addLibreRecord(columns *libreColumns, record []string, startTime time.Time, options LibreOptions) (err error) {
+	recordType := columns.value(record, columns.recordType)
+	switch recordType {
+	case LIBRE_HISTORIC_GLUCOSE_RECORD_TYPE, LIBRE_SCAN_GLUCOSE_RECORD_TYPE, LIBRE_INSULIN_RECORD_TYPE, LIBRE_FOOD_RECORD_TYPE:
+	default:
+		return nil
+	}
+
+	recordTime, err := columns.recordTime(record)
+	if err != nil {
+		return err
+	}
+
+	// Skip everything that's before the last import's read time
+	if recordTime.Unix() <= startTime.Unix() {
+		return nil
+	}
+
+	timestamp := apimodel.Time{apimodel.GetTimeMillis(recordTime), recordTime.Location().String()}
+	switch recordType {
+	case LIBRE_HISTORIC_GLUCOSE_RECORD_TYPE:
+		value, err := columns.glucoseValue(record, columns.historicGlucose)
+		if err != nil {
+			return err
+		}
+		records.reads = append(records.reads, apimodel.GlucoseRead{timestamp, apimodel.MG_PER_DL, value})
+	case LIBRE_SCAN_GLUCOSE_RECORD_TYPE:
+		value, err := columns.glucoseValue(record, columns.scanGlucose)
+		if err != nil {
+			return err
+		}
+
+		if options.ScansAsCalibrations {
+			records.calibrations = append(records.calibrations, apimodel.CalibrationRead{timestamp, apimodel.MG_PER_DL, value})
+		} else {
+			records.reads = append(records.reads, apimodel.GlucoseRead{timestamp, apimodel.MG_PER_DL, value})
+		}
+	case LIBRE_INSULIN_RECORD_TYPE:
+		if units, err := columns.number(record, columns.rapidActingInsulin); err == nil && units > 0 {
+			records.injections = append(records.injections, newInjection(timestamp, float32(units), LIBRE_RAPID_ACTING_INSULIN_TYPE))
+		}
+
+		if units, err := columns.number(record, columns.longActingInsulin); err == nil && units > 0 {
+			records.injections = append(records.injections, newInjection(timestamp, float32(units), LIBRE_LONG_ACTING_INSULIN_TYPE))
+		}
+	case LIBRE_FOOD_RECORD_TYPE:
+		carbs, err := columns.number(record, columns.carbohydrates)
+		if err != nil {
+			return err
+		}
+		records.meals = append(records.meals, apimodel.Meal{timestamp, float32(carbs), 0., 0., 0., 0., "", "", false, false})
+	}
+
+	return nil
+}
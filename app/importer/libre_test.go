@@ -0,0 +1,106 @@
+package importer_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"google.golang.org/appengine/aetest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var libreFixture = strings.Replace(`FreeStyle Libre
+ID|Time|Record Type|Historic Glucose (mmol/L)|Scan Glucose (mmol/L)|Non-numeric Rapid-Acting Insulin|Rapid-Acting Insulin (units)|Non-numeric Food|Carbohydrates (grams)|Non-numeric Long-Acting Insulin|Long-Acting Insulin (units)|Notes
+1|01/02/2023 14:00|0|5.5|||||||||
+2|01/02/2023 14:07|1||6.1||||||||
+3|01/02/2023 14:05|4||||4.5|||||||
+4|01/02/2023 14:06|5||||||45||||
+5|01/02/2023 14:15|0|6.0|||||||||
+6|01/02/2023 22:00|4||||||||12|
+7|01/02/2023 22:05|6||||||||||Strip
+`, "|", "\t", -1)
+
+func parseLibreFixture(t *testing.T, startTime time.Time, options LibreOptions) (*importedRecords, time.Time, int) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	records, streamers := newRecordingStreamers()
+	lastReadTime, report, err := ParseLibre(c, strings.NewReader(libreFixture), startTime, options, streamers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return records, lastReadTime, report.RecordCount()
+}
+
+func TestParseLibreMergesScansWithHistoricReads(t *testing.T) {
+	records, lastReadTime, recordCount := parseLibreFixture(t, time.Unix(0, 0), DefaultLibreOptions)
+
+	if recordCount != 6 {
+		t.Errorf("Expected [6] records but got [%d]", recordCount)
+	}
+
+	utc := time.FixedZone("+0000", 0)
+	expectedReadTimes := []time.Time{time.Date(2023, 1, 2, 14, 0, 0, 0, utc), time.Date(2023, 1, 2, 14, 7, 0, 0, utc), time.Date(2023, 1, 2, 14, 15, 0, 0, utc)}
+	if len(records.reads) != len(expectedReadTimes) {
+		t.Fatalf("Expected [%d] reads but got [%d]: %v", len(expectedReadTimes), len(records.reads), records.reads)
+	}
+
+	for i := range expectedReadTimes {
+		if !records.reads[i].GetTime().Equal(expectedReadTimes[i]) || records.reads[i].Unit != apimodel.MG_PER_DL {
+			t.Errorf("Expected read in mg/dL at [%v] at index [%d] but got [%v]", expectedReadTimes[i], i, records.reads[i])
+		}
+	}
+
+	if records.reads[0].Value < 99 || records.reads[0].Value > 100 {
+		t.Errorf("Expected 5.5 mmol/L to be normalized to about [99] mg/dL but got [%f]", records.reads[0].Value)
+	}
+
+	if !lastReadTime.Equal(expectedReadTimes[2]) {
+		t.Errorf("Expected last read time [%v] but got [%v]", expectedReadTimes[2], lastReadTime)
+	}
+
+	if len(records.injections) != 2 || records.injections[0].InsulinType != LIBRE_RAPID_ACTING_INSULIN_TYPE || records.injections[1].Units != 12 || records.injections[1].InsulinType != LIBRE_LONG_ACTING_INSULIN_TYPE {
+		t.Errorf("Expected a rapid-acting and a long-acting injection but got [%v]", records.injections)
+	}
+
+	if len(records.meals) != 1 || records.meals[0].Carbs != 45 {
+		t.Errorf("Expected a single meal of [45] grams of carbs but got [%v]", records.meals)
+	}
+
+	if len(records.calibrations) != 0 {
+		t.Errorf("Expected no calibrations but got [%v]", records.calibrations)
+	}
+}
+
+func TestParseLibreWithScansAsCalibrations(t *testing.T) {
+	records, _, _ := parseLibreFixture(t, time.Unix(0, 0), LibreOptions{ScansAsCalibrations: true})
+
+	if len(records.reads) != 2 {
+		t.Errorf("Expected only the [2] historic reads but got [%v]", records.reads)
+	}
+
+	if len(records.calibrations) != 1 || records.calibrations[0].Unit != apimodel.MG_PER_DL {
+		t.Errorf("Expected the scan as a single calibration in mg/dL but got [%v]", records.calibrations)
+	}
+}
+
+func TestParseLibreSkipsRecordsBeforeStartTime(t *testing.T) {
+	startTime := time.Date(2023, 1, 2, 14, 6, 0, 0, time.UTC)
+	records, _, recordCount := parseLibreFixture(t, startTime, DefaultLibreOptions)
+
+	if recordCount != 3 {
+		t.Errorf("Expected [3] records after [%v] but got [%d]", startTime, recordCount)
+	}
+
+	if len(records.reads) != 2 || len(records.meals) != 0 {
+		t.Errorf("Expected [2] reads and no meals after [%v] but got [%v] and [%v]", startTime, records.reads, records.meals)
+	}
+}
@@ -0,0 +1,271 @@
+package importer
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Nightscout api endpoints, relative to the base url of the site
+	NIGHTSCOUT_ENTRIES_PATH    = "/api/v1/entries/sgv.json"
+	NIGHTSCOUT_TREATMENTS_PATH = "/api/v1/treatments.json"
+
+	// Nightscout doesn't support cursors so data is fetched one time window at a time. The page size needs
+	// to be large enough to hold all entries of a window (one read per minute for the more frequent devices).
+	NIGHTSCOUT_PAGE_DURATION = time.Duration(7*24) * time.Hour
+	NIGHTSCOUT_PAGE_SIZE     = 7 * 24 * 60
+
+	NIGHTSCOUT_EXERCISE_EVENT_TYPE   = "Exercise"
+	NIGHTSCOUT_TEMP_BASAL_EVENT_TYPE = "Temp Basal"
+)
+
+// NightscoutEntry is a glucose entry as returned by the Nightscout entries api
+type NightscoutEntry struct {
+	Type      string  `json:"type"`
+	Sgv       float32 `json:"sgv"`
+	Date      int64   `json:"date"`
+	UtcOffset *int    `json:"utcOffset"`
+}
+
+// NightscoutTreatment is a treatment as returned by the Nightscout treatments api
+type NightscoutTreatment struct {
+	EventType string   `json:"eventType"`
+	CreatedAt string   `json:"created_at"`
+	Carbs     float32  `json:"carbs"`
+	Protein   float32  `json:"protein"`
+	Fat       float32  `json:"fat"`
+	Insulin   float32  `json:"insulin"`
+	Duration  float32  `json:"duration"`
+	Absolute  *float32 `json:"absolute"`
+	Rate      *float32 `json:"rate"`
+	Notes     string   `json:"notes"`
+	UtcOffset *int     `json:"utcOffset"`
+}
+
+// ImportNightscoutData fetches all entries and treatments more recent than startTime from a Nightscout site and
+// persists them. The time of the last read imported is returned to be used as the startTime of the next import.
+func ImportNightscoutData(context context.Context, client *http.Client, parentKey *datastore.Key, baseUrl string, apiSecret string, startTime time.Time) (lastReadTime time.Time, recordCount int, err error) {
+	streamers := newDataStoreImportStreamers(context, parentKey, apimodel.DEFAULT_DEVICE_ID)
+
+	lastReadTime, recordCount, err = FetchNightscout(context, client, baseUrl, apiSecret, startTime, time.Now(), streamers)
+	if err != nil {
+		return lastReadTime, recordCount, err
+	}
+
+	if err = streamers.Close(); err != nil {
+		return lastReadTime, recordCount, err
+	}
+
+	// Update the most recent read only once all reads have been stored
+	if mostRecentRead := streamers.mostRecentRead(); mostRecentRead != apimodel.UNDEFINED_GLUCOSE_READ {
+		if err = store.UpdateMostRecentRead(context, parentKey, mostRecentRead); err != nil {
+			return lastReadTime, recordCount, err
+		}
+	}
+
+	log.Infof(context, "Done importing [%d] records from nightscout site [%s]", recordCount, baseUrl)
+	return lastReadTime, recordCount, nil
+}
+
+// FetchNightscout pages through the entries and treatments of a Nightscout site between startTime (exclusive) and
+// endTime and writes them to the streamers. Pages are written as they're fetched so the streamers are left open for
+// the caller to close.
+func FetchNightscout(context context.Context, client *http.Client, baseUrl string, apiSecret string, startTime time.Time, endTime time.Time, streamers *ImportStreamers) (lastReadTime time.Time, recordCount int, err error) {
+	lastReadTime = startTime
+	baseUrl = strings.TrimRight(baseUrl, "/")
+
+	for pageStart := startTime; pageStart.Before(endTime); pageStart = pageStart.Add(NIGHTSCOUT_PAGE_DURATION) {
+		pageEnd := pageStart.Add(NIGHTSCOUT_PAGE_DURATION)
+
+		var entries []NightscoutEntry
+		query := url.Values{}
+		query.Set("find[date][$gt]", fmt.Sprintf("%d", apimodel.GetTimeMillis(pageStart)))
+		query.Set("find[date][$lte]", fmt.Sprintf("%d", apimodel.GetTimeMillis(pageEnd)))
+		query.Set("count", fmt.Sprintf("%d", NIGHTSCOUT_PAGE_SIZE))
+		if err = getNightscoutJson(client, baseUrl+NIGHTSCOUT_ENTRIES_PATH, query, apiSecret, &entries); err != nil {
+			return lastReadTime, recordCount, err
+		}
+
+		reads := convertNightscoutEntries(entries)
+		if streamers.Glucose, err = streamers.Glucose.WriteGlucoseReads(reads); err != nil {
+			return lastReadTime, recordCount, err
+		}
+		recordCount += len(reads)
+		if len(reads) > 0 {
+			lastReadTime = reads[len(reads)-1].GetTime()
+		}
+
+		var treatments []NightscoutTreatment
+		query = url.Values{}
+		query.Set("find[created_at][$gt]", pageStart.UTC().Format(time.RFC3339))
+		query.Set("find[created_at][$lte]", pageEnd.UTC().Format(time.RFC3339))
+		query.Set("count", fmt.Sprintf("%d", NIGHTSCOUT_PAGE_SIZE))
+		if err = getNightscoutJson(client, baseUrl+NIGHTSCOUT_TREATMENTS_PATH, query, apiSecret, &treatments); err != nil {
+			return lastReadTime, recordCount, err
+		}
+
+		count, err := writeNightscoutTreatments(context, treatments, streamers)
+		recordCount += count
+		if err != nil {
+			return lastReadTime, recordCount, err
+		}
+
+		log.Debugf(context, "Fetched [%d] entries and [%d] treatments from [%s] for page starting at [%v]", len(entries), len(treatments), baseUrl, pageStart)
+	}
+
+	return lastReadTime, recordCount, nil
+}
+
+// getNightscoutJson gets the json content of the api endpoint and decodes it into value. The api secret is sent hashed as
+// expected by Nightscout.
+func getNightscoutJson(client *http.Client, endpoint string, query url.Values, apiSecret string, value interface{}) (err error) {
+	request, err := http.NewRequest("GET", endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Add("Accept", "application/json")
+	if apiSecret != "" {
+		hash := sha1.Sum([]byte(apiSecret))
+		request.Header.Add("api-secret", hex.EncodeToString(hash[:]))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("Error calling nightscout api [%s], got status [%s]", endpoint, response.Status))
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// nightscoutLocation returns the fixed location for an offset in minutes, named like the ones extrapolated from the
+// Dexcom xml files (i.e. -0700). Records without an offset are considered to be UTC.
+func nightscoutLocation(utcOffset *int) *time.Location {
+	offsetInMinutes := 0
+	if utcOffset != nil {
+		offsetInMinutes = *utcOffset
+	}
+
+	sign := "+"
+	absoluteOffset := offsetInMinutes
+	if offsetInMinutes < 0 {
+		sign = "-"
+		absoluteOffset = -offsetInMinutes
+	}
+
+	return time.FixedZone(fmt.Sprintf("%s%02d%02d", sign, absoluteOffset/60, absoluteOffset%60), offsetInMinutes*60)
+}
+
+// convertNightscoutEntries converts sgv entries to glucose reads sorted chronologically. Nightscout returns the most
+// recent entries first.
+func convertNightscoutEntries(entries []NightscoutEntry) (reads apimodel.GlucoseReadSlice) {
+	reads = make(apimodel.GlucoseReadSlice, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type != "sgv" || entry.Sgv <= 0 {
+			continue
+		}
+
+		location := nightscoutLocation(entry.UtcOffset)
+		reads = append(reads, apimodel.GlucoseRead{apimodel.Time{entry.Date, location.String()}, apimodel.MG_PER_DL, entry.Sgv})
+	}
+
+	sort.Sort(reads)
+	return reads
+}
+
+// writeNightscoutTreatments converts treatments to meals, injections, temp basal rates and exercises and writes them to the streamers. A
+// single treatment can hold both carbs and insulin (i.e. a meal bolus).
+func writeNightscoutTreatments(context context.Context, treatments []NightscoutTreatment, streamers *ImportStreamers) (recordCount int, err error) {
+	meals := make(apimodel.MealSlice, 0)
+	injections := make(apimodel.InjectionSlice, 0)
+	basalRates := make(apimodel.BasalRateSlice, 0)
+	exercises := make(apimodel.ExerciseSlice, 0)
+
+	for _, treatment := range treatments {
+		createdAt, err := time.Parse(time.RFC3339, treatment.CreatedAt)
+		if err != nil {
+			log.Warningf(context, "Skipping nightscout treatment [%v], bad created_at: %v", treatment, err)
+			continue
+		}
+
+		location := nightscoutLocation(treatment.UtcOffset)
+		timestamp := apimodel.Time{apimodel.GetTimeMillis(createdAt), location.String()}
+		if treatment.Carbs > 0 {
+			meals = append(meals, apimodel.Meal{timestamp, treatment.Carbs, treatment.Protein, treatment.Fat, 0., 0., treatment.Notes, "", false, false})
+		}
+
+		if treatment.Insulin > 0 {
+			injections = append(injections, newInjection(timestamp, treatment.Insulin, treatment.EventType))
+		}
+
+		if treatment.EventType == NIGHTSCOUT_TEMP_BASAL_EVENT_TYPE {
+			if rate, ok := treatment.basalRate(); ok {
+				basalRates = append(basalRates, apimodel.BasalRate{timestamp, rate, int(treatment.Duration), true})
+			}
+		}
+
+		if treatment.EventType == NIGHTSCOUT_EXERCISE_EVENT_TYPE {
+			exercises = append(exercises, apimodel.Exercise{timestamp, int(treatment.Duration), apimodel.EXERCISE_INTENSITY_UNKNOWN, treatment.Notes, "", "", false, false, apimodel.EXERCISE_SOURCE_DEVICE})
+		}
+	}
+
+	sort.Sort(meals)
+	sort.Sort(injections)
+	sort.Sort(basalRates)
+	sort.Sort(exercises)
+
+	if streamers.Meal, err = streamers.Meal.WriteMeals(meals); err != nil {
+		return recordCount, err
+	}
+	recordCount += len(meals)
+
+	if streamers.Injection, err = streamers.Injection.WriteInjections(injections); err != nil {
+		return recordCount, err
+	}
+	recordCount += len(injections)
+
+	if streamers.BasalRate, err = streamers.BasalRate.WriteBasalRates(basalRates); err != nil {
+		return recordCount, err
+	}
+	recordCount += len(basalRates)
+
+	if streamers.Exercise, err = streamers.Exercise.WriteExercises(exercises); err != nil {
+		return recordCount, err
+	}
+	recordCount += len(exercises)
+
+	return recordCount, nil
+}
+
+// basalRate returns the rate, in units per hour, of a temp basal treatment. Nightscout sets the absolute rate on most
+// temp basals but some uploaders only set the rate. A temp basal without either is a relative (percent) one that
+// can't be converted without the basal profile.
+func (treatment NightscoutTreatment) basalRate() (rate float32, ok bool) {
+	if treatment.Absolute != nil {
+		return *treatment.Absolute, true
+	}
+
+	if treatment.Rate != nil {
+		return *treatment.Rate, true
+	}
+
+	return 0., false
+}
@@ -0,0 +1,124 @@
+package importer_test
+
+import (
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"google.golang.org/appengine/aetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sha1 of "secret"
+const hashedNightscoutSecret = "e5e9fa1ba31ecd1ae84f75caaa474f3a663f05f4"
+
+func newNightscoutServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("api-secret") != hashedNightscoutSecret {
+			http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch request.URL.Path {
+		case NIGHTSCOUT_ENTRIES_PATH:
+			// Only the first page has entries, most recent first like nightscout does
+			if request.URL.Query().Get("find[date][$gt]") == "1452844800000" {
+				fmt.Fprint(writer, `[{"type":"sgv","sgv":121,"date":1452874200000,"utcOffset":-480},
+					{"type":"mbg","mbg":118,"date":1452873900000},
+					{"type":"sgv","sgv":110,"date":1452873600000,"utcOffset":-480}]`)
+			} else {
+				fmt.Fprint(writer, `[]`)
+			}
+		case NIGHTSCOUT_TREATMENTS_PATH:
+			if request.URL.Query().Get("find[created_at][$gt]") == "2016-01-15T08:00:00Z" {
+				fmt.Fprint(writer, `[{"eventType":"Exercise","created_at":"2016-01-15T20:30:00Z","duration":45,"notes":"Run","utcOffset":-480},
+					{"eventType":"Temp Basal","created_at":"2016-01-15T18:00:00Z","absolute":0.4,"duration":30,"utcOffset":-480},
+					{"eventType":"Temp Basal","created_at":"2016-01-15T17:00:00Z","percent":-50,"duration":30,"utcOffset":-480},
+					{"eventType":"Meal Bolus","created_at":"2016-01-15T16:15:00Z","carbs":45,"insulin":4.5,"notes":"Pizza","utcOffset":-480}]`)
+			} else {
+				fmt.Fprint(writer, `[]`)
+			}
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+}
+
+func TestFetchNightscout(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	server := newNightscoutServer()
+	defer server.Close()
+
+	startTime := time.Unix(1452844800, 0)
+	records, streamers := newRecordingStreamers()
+	lastReadTime, recordCount, err := FetchNightscout(c, http.DefaultClient, server.URL+"/", "secret", startTime, startTime.Add(NIGHTSCOUT_PAGE_DURATION*2), streamers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if recordCount != 6 {
+		t.Errorf("Expected [6] records but got [%d]", recordCount)
+	}
+
+	expectedReads := []apimodel.GlucoseRead{
+		apimodel.GlucoseRead{apimodel.Time{1452873600000, "-0800"}, apimodel.MG_PER_DL, 110},
+		apimodel.GlucoseRead{apimodel.Time{1452874200000, "-0800"}, apimodel.MG_PER_DL, 121},
+	}
+	if len(records.reads) != len(expectedReads) {
+		t.Fatalf("Expected [%d] reads but got [%d]: %v", len(expectedReads), len(records.reads), records.reads)
+	}
+
+	for i := range expectedReads {
+		if records.reads[i] != expectedReads[i] {
+			t.Errorf("Expected read [%v] at index [%d] but got [%v]", expectedReads[i], i, records.reads[i])
+		}
+	}
+
+	if !lastReadTime.Equal(time.Unix(1452874200, 0)) {
+		t.Errorf("Expected last read time of [%v] but got [%v]", time.Unix(1452874200, 0), lastReadTime)
+	}
+
+	if len(records.meals) != 1 || records.meals[0].Carbs != 45 || records.meals[0].Description != "Pizza" {
+		t.Errorf("Expected a single meal of [45] grams of carbs described as [Pizza] but got [%v]", records.meals)
+	}
+
+	if len(records.injections) != 1 || records.injections[0].Units != 4.5 {
+		t.Errorf("Expected a single injection of [4.5] units but got [%v]", records.injections)
+	}
+
+	if len(records.basalRates) != 1 || records.basalRates[0].Rate != 0.4 || records.basalRates[0].DurationMinutes != 30 || !records.basalRates[0].Temp {
+		t.Errorf("Expected a single temp basal of [0.4] units per hour for [30] minutes but got [%v]", records.basalRates)
+	}
+
+	if len(records.exercises) != 1 || records.exercises[0].DurationMinutes != 45 || records.exercises[0].Description != "Run" {
+		t.Errorf("Expected a single exercise of [45] minutes but got [%v]", records.exercises)
+	}
+}
+
+func TestFetchNightscoutWithInvalidSecret(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	server := newNightscoutServer()
+	defer server.Close()
+
+	startTime := time.Unix(1452844800, 0)
+	_, streamers := newRecordingStreamers()
+	if _, _, err = FetchNightscout(c, http.DefaultClient, server.URL, "wrong", startTime, startTime.Add(time.Hour), streamers); err == nil {
+		t.Errorf("Expected an error with an invalid api secret")
+	}
+}
@@ -1,11 +1,14 @@
 package importer
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
+	"github.com/alexandre-normand/glukit/app/analytics"
 	"github.com/alexandre-normand/glukit/app/apimodel"
 	"github.com/alexandre-normand/glukit/app/bufio"
 	"github.com/alexandre-normand/glukit/app/dexcomimporter"
+	"github.com/alexandre-normand/glukit/app/glukitio"
 	"github.com/alexandre-normand/glukit/app/store"
 	"github.com/alexandre-normand/glukit/app/streaming"
 	"github.com/alexandre-normand/glukit/app/util"
@@ -18,38 +21,53 @@ import (
 )
 
 // ParseContent is the big function that parses the Dexcom xml file. It is given a reader to the file and it parses batches of days of GlucoseReads/Events. It streams the content but
-// keeps some in memory until it reaches a full batch of a type. A batch is an array of DayOf[GlucoseReads,Injection,Meals,Exercises]. A batch is flushed to the datastore once it reaches
-// the given batchSize or we reach the end of the file.
-func ParseContent(context context.Context, reader io.Reader, parentKey *datastore.Key, startTime time.Time, readsBatchHandler func(context context.Context, userProfileKey *datastore.Key, meals []apimodel.DayOfGlucoseReads) ([]*datastore.Key, error), mealsBatchHandler func(context context.Context, userProfileKey *datastore.Key, daysOfMeals []apimodel.DayOfMeals) ([]*datastore.Key, error), injectionBatchHandler func(context context.Context, userProfileKey *datastore.Key, daysOfInjections []apimodel.DayOfInjections) ([]*datastore.Key, error), exerciseBatchHandler func(context context.Context, userProfileKey *datastore.Key, daysOfExercises []apimodel.DayOfExercises) ([]*datastore.Key, error)) (lastReadTime time.Time, err error) {
-	decoder := xml.NewDecoder(reader)
-
-	calibrationDataStoreWriter := store.NewDataStoreCalibrationBatchWriter(context, parentKey)
-	calibrationBatchingWriter := bufio.NewCalibrationWriterSize(calibrationDataStoreWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
-	calibrationStreamer := streaming.NewCalibrationReadStreamerDuration(calibrationBatchingWriter, apimodel.DAY_OF_DATA_DURATION)
-
-	glucoseDataStoreWriter := store.NewDataStoreGlucoseReadBatchWriter(context, parentKey)
-	glucoseBatchingWriter := bufio.NewGlucoseReadWriterSize(glucoseDataStoreWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
-	glucoseStreamer := streaming.NewGlucoseStreamerDuration(glucoseBatchingWriter, apimodel.DAY_OF_DATA_DURATION)
+// keeps some in memory until it reaches a full batch of a type. A batch is an array of DayOf[GlucoseReads,CalibrationReads,Injection,Meals,Exercises]. A batch is flushed to the datastore once it reaches
+// the given batchSize or we reach the end of the file. A report of the records imported and skipped is returned along with the time of the last read or calibration,
+// whichever is the most recent, so that the next import picks up from there.
+func ParseContent(context context.Context, reader io.Reader, parentKey *datastore.Key, startTime time.Time, readsBatchHandler func(context context.Context, userProfileKey *datastore.Key, deviceId string, daysOfReads []apimodel.DayOfGlucoseReads) ([]*datastore.Key, apimodel.GlucoseRead, error), calibrationBatchHandler func(context context.Context, userProfileKey *datastore.Key, deviceId string, daysOfCalibrations []apimodel.DayOfCalibrationReads) ([]*datastore.Key, error), mealsBatchHandler func(context context.Context, userProfileKey *datastore.Key, daysOfMeals []apimodel.DayOfMeals) ([]*datastore.Key, error), injectionBatchHandler func(context context.Context, userProfileKey *datastore.Key, daysOfInjections []apimodel.DayOfInjections) ([]*datastore.Key, error), exerciseBatchHandler func(context context.Context, userProfileKey *datastore.Key, daysOfExercises []apimodel.DayOfExercises) ([]*datastore.Key, error), progress ProgressHandler) (lastReadTime time.Time, report *ImportReport, err error) {
+	return ImportContent(context, &Format{FORMAT_DEXCOM_XML, IsDexcomXml, ParserFunc(parseDexcomXml)}, reader, parentKey, startTime, progress)
+}
 
-	injectionDataStoreWriter := store.NewDataStoreInjectionBatchWriter(context, parentKey)
-	injectionBatchingWriter := bufio.NewInjectionWriterSize(injectionDataStoreWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
-	injectionStreamer := streaming.NewInjectionStreamerDuration(injectionBatchingWriter, apimodel.DAY_OF_DATA_DURATION)
+// IsDexcomXml returns true if the beginning of the content has the Patient element of a Dexcom xml export
+func IsDexcomXml(beginning []byte, filename string) bool {
+	return bytes.Contains(beginning, []byte("<Patient"))
+}
 
-	mealDataStoreWriter := store.NewDataStoreMealBatchWriter(context, parentKey)
-	mealBatchingWriter := bufio.NewMealWriterSize(mealDataStoreWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
-	mealStreamer := streaming.NewMealStreamerDuration(mealBatchingWriter, apimodel.DAY_OF_DATA_DURATION)
+// parseDexcomXml is the Parser of the Dexcom xml format. Reads are persisted under the serial number of the receiver.
+func parseDexcomXml(context context.Context, reader io.Reader, since time.Time, streamers *ImportStreamers, progress ProgressHandler) (lastReadTime time.Time, report *ImportReport, err error) {
+	return ParseDexcomXml(context, reader, since, streamers, progress, streamers.useDevice)
+}
 
-	exerciseDataStoreWriter := store.NewDataStoreExerciseBatchWriter(context, parentKey)
-	exerciseBatchingWriter := bufio.NewExerciseWriterSize(exerciseDataStoreWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
-	exerciseStreamer := streaming.NewExerciseStreamerDuration(exerciseBatchingWriter, apimodel.DAY_OF_DATA_DURATION)
+// ParseDexcomXml reads the Dexcom xml content one token at a time and decodes a single Glucose, Meter or Event element
+// at a time. Each record is handed to the streamers as soon as it's decoded so memory usage is bounded by the size
+// of the streamer buffers rather than the size of the file. The device callback is called with the serial number of the
+// receiver, before any read is written, so that the caller can switch the glucose streamer to one scoped to the device.
+// Progress is reported, if a handler is given, every time a record is processed. Records that can't be converted are
+// skipped and added to the report but a malformed document stops the parsing. Reads and calibrations are converted to
+// mg/dL using the unit stated by the document or, if it doesn't state one, the unit detected from the first reads. The
+// streamers are left open for the caller to close.
+func ParseDexcomXml(context context.Context, reader io.Reader, startTime time.Time, streamers *ImportStreamers, progress ProgressHandler, device func(serialNumber string)) (lastReadTime time.Time, report *ImportReport, err error) {
+	decoder := xml.NewDecoder(reader)
+	lastReadTime = startTime
+	report = new(ImportReport)
+	units := newGlucoseUnitDetector()
 
-	var lastRead *apimodel.GlucoseRead
+	reportedCount := 0
+	var lastRecordTime, lastCalibrationTime time.Time
 	for {
+		if recordCount := report.RecordCount(); recordCount != reportedCount {
+			reportProgress(progress, ImportProgress{recordCount, lastRecordTime, decoder.InputOffset()})
+			reportedCount = recordCount
+		}
+
 		// Read tokens from the XML document in a stream.
-		t, _ := decoder.Token()
-		if t == nil {
+		offset := decoder.InputOffset()
+		t, err := decoder.Token()
+		if err == io.EOF {
 			log.Debugf(context, "finished reading file")
 			break
+		} else if err != nil {
+			return lastReadTime, report, err
 		}
 
 		// Inspect the type of the token just read.
@@ -58,40 +76,65 @@ func ParseContent(context context.Context, reader io.Reader, parentKey *datastor
 			// If we just read a StartElement token
 			// ...and its name is "Glucose"
 			switch se.Name.Local {
+			case "Patient":
+				// The patient element precedes all reads and calibrations so we can safely switch to streamers for the device before
+				// anything gets written
+				if serialNumber := dexcomimporter.GetSerialNumber(se); serialNumber != "" && report.Reads == 0 && report.Calibrations == 0 && device != nil {
+					device(serialNumber)
+				}
+				units.declare(getUnitFromAttributes(se.Attr))
+			case "GlucoseReadings":
+				units.declare(getUnitFromAttributes(se.Attr))
 			case "Glucose":
 				var read dexcomimporter.Glucose
 				// decode a whole chunk of following XML into the
-				decoder.DecodeElement(&read, &se)
+				if err = decoder.DecodeElement(&read, &se); err != nil {
+					return lastReadTime, report, err
+				}
+
 				glucoseRead, err := dexcomimporter.ConvertXmlGlucoseRead(read)
 				if err != nil {
-					return lastRead.GetTime(), err
+					log.Warningf(context, "Skipping glucose read [%v]: %v", read, err)
+					report.skip(elementLocation(se, offset), err)
+					continue
 				}
 
 				if glucoseRead != nil && glucoseRead.Value > 0 {
-					glucoseStreamer, err = glucoseStreamer.WriteGlucoseRead(*glucoseRead)
+					if reads := units.add(*glucoseRead); len(reads) > 0 {
+						if err = writeGlucoseReads(streamers, reads, report); err != nil {
+							return lastReadTime, report, err
+						}
 
-					if err != nil {
-						return lastRead.GetTime(), err
+						lastReadTime = reads[len(reads)-1].GetTime()
+						lastRecordTime = lastReadTime
 					}
-
-					lastRead = glucoseRead
 				}
 			case "Event":
 				var event dexcomimporter.Event
-				decoder.DecodeElement(&event, &se)
+				if err = decoder.DecodeElement(&event, &se); err != nil {
+					return lastReadTime, report, err
+				}
+
 				internalEventTime, err := util.GetTimeUTC(event.InternalTime)
 				if err != nil {
 					log.Warningf(context, "Skipping [%s] event [%v], bad internal time [%s]: %v", event.EventType, event, event.InternalTime, err)
+					report.skip(elementLocation(se, offset), err)
 					continue
 				}
 
 				// Skip everything that's before the last import's read time
 				if internalEventTime.Unix() > startTime.Unix() {
-					location := util.GetLocaltimeOffset(event.EventTime, internalEventTime)
+					location, err := util.ParseLocaltimeOffset(event.EventTime, internalEventTime)
+					if err != nil {
+						log.Warningf(context, "Skipping [%s] event [%v], bad event time [%s]: %v", event.EventType, event, event.EventTime, err)
+						report.skip(elementLocation(se, offset), err)
+						continue
+					}
 
 					eventTime, err := util.GetTimeWithImpliedLocation(event.EventTime, location)
 					if err != nil {
 						log.Warningf(context, "Skipping [%s] event [%v], bad event time [%s]: %v", event.EventType, event, event.EventTime, err)
+						report.skip(elementLocation(se, offset), err)
 						continue
 					}
 
@@ -99,11 +142,14 @@ func ParseContent(context context.Context, reader io.Reader, parentKey *datastor
 						var mealQuantityInGrams int
 						fmt.Sscanf(event.Description, "Carbs %d grams", &mealQuantityInGrams)
 
-						meal := apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, float32(mealQuantityInGrams), 0., 0., 0.}
+						meal := apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, float32(mealQuantityInGrams), 0., 0., 0., 0., "", "", false, false}
 
-						mealStreamer, err = mealStreamer.WriteMeal(meal)
-						if err != nil {
-							return lastRead.GetTime(), err
+						streamers.Meal, err = streamers.Meal.WriteMeal(meal)
+						if dropped, err := report.skipOutOfOrder(elementLocation(se, offset), err); err != nil {
+							return lastReadTime, report, err
+						} else if dropped == 0 {
+							lastRecordTime = eventTime
+							report.Meals++
 						}
 
 					} else if event.EventType == "Insulin" {
@@ -111,13 +157,17 @@ func ParseContent(context context.Context, reader io.Reader, parentKey *datastor
 						_, err := fmt.Sscanf(event.Description, "Insulin %f units", &insulinUnits)
 						if err != nil {
 							log.Warningf(context, "Failed to parse event as injection [%s]: %v", event.Description, err)
+							report.skip(elementLocation(se, offset), err)
 						} else {
-							injection := apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, float32(insulinUnits), "", ""}
+							insulinName, insulinCategory := classifyInsulin(event.Description)
+							injection := apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, float32(insulinUnits), insulinName, "", insulinCategory, "", false, false}
 
-							injectionStreamer, err = injectionStreamer.WriteInjection(injection)
-
-							if err != nil {
-								return lastRead.GetTime(), err
+							streamers.Injection, err = streamers.Injection.WriteInjection(injection)
+							if dropped, err := report.skipOutOfOrder(elementLocation(se, offset), err); err != nil {
+								return lastReadTime, report, err
+							} else if dropped == 0 {
+								lastRecordTime = eventTime
+								report.Injections++
 							}
 						}
 					} else if strings.HasPrefix(event.EventType, "Exercise") {
@@ -125,55 +175,104 @@ func ParseContent(context context.Context, reader io.Reader, parentKey *datastor
 						var intensity string
 						fmt.Sscanf(event.Description, "Exercise %s (%d minutes)", &intensity, &duration)
 
-						exercise := apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, duration, intensity, ""}
-						exerciseStreamer, err = exerciseStreamer.WriteExercise(exercise)
-						if err != nil {
-							return lastRead.GetTime(), err
+						exercise, ok := NormalizeExercise(apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, intensity,
+							time.Duration(duration)*time.Minute, "")
+						if !ok {
+							log.Debugf(context, "Dropping cancelled exercise event [%s]", event.Description)
+							continue
+						}
+
+						streamers.Exercise, err = streamers.Exercise.WriteExercise(exercise)
+						if dropped, err := report.skipOutOfOrder(elementLocation(se, offset), err); err != nil {
+							return lastReadTime, report, err
+						} else if dropped == 0 {
+							lastRecordTime = eventTime
+							report.Exercises++
 						}
 					}
 				}
 			case "Meter":
 				var c dexcomimporter.Calibration
-				decoder.DecodeElement(&c, &se)
+				if err = decoder.DecodeElement(&c, &se); err != nil {
+					return lastReadTime, report, err
+				}
 
 				if calibrationRead, err := dexcomimporter.ConvertXmlCalibrationRead(c); err != nil {
-					return lastRead.GetTime(), err
-				} else {
-					calibrationStreamer, err = calibrationStreamer.WriteCalibration(*calibrationRead)
-
-					if err != nil {
-						return lastRead.GetTime(), err
+					log.Warningf(context, "Skipping calibration [%v]: %v", c, err)
+					report.skip(elementLocation(se, offset), err)
+				} else if calibrationTime := calibrationRead.GetTime(); calibrationTime.Unix() > startTime.Unix() {
+					calibrationRead.Unit, calibrationRead.Value = apimodel.MG_PER_DL, units.canonicalValue(calibrationRead.Value)
+					streamers.Calibration, err = streamers.Calibration.WriteCalibration(*calibrationRead)
+					if dropped, err := report.skipOutOfOrder(elementLocation(se, offset), err); err != nil {
+						return lastReadTime, report, err
+					} else if dropped == 0 {
+						lastRecordTime = calibrationTime
+						lastCalibrationTime = calibrationTime
+						report.Calibrations++
 					}
 				}
 			}
 		}
 	}
 
-	// Close the streams and flush anything pending
-	glucoseStreamer, err = glucoseStreamer.Close()
-	if err != nil {
-		return lastRead.GetTime(), err
-	}
-	calibrationStreamer, err = calibrationStreamer.Close()
-	if err != nil {
-		return lastRead.GetTime(), err
+	// Write the reads still held if there weren't enough of them to detect the unit
+	if reads := units.flush(); len(reads) > 0 {
+		if err = writeGlucoseReads(streamers, reads, report); err != nil {
+			return lastReadTime, report, err
+		}
+		lastReadTime = reads[len(reads)-1].GetTime()
 	}
+	report.Unit = units.unit
 
-	injectionStreamer, err = injectionStreamer.Close()
-	if err != nil {
-		return lastRead.GetTime(), err
+	// Meter entries can be more recent than the last read and they must not be skipped by the next import
+	if lastCalibrationTime.After(lastReadTime) {
+		lastReadTime = lastCalibrationTime
 	}
 
-	mealStreamer, err = mealStreamer.Close()
+	return lastReadTime, report, nil
+}
+
+// writeGlucoseReads writes the reads to the glucose streamer and counts them in the report. Reads that the streamer
+// drops for being too far out of order are counted as skipped.
+func writeGlucoseReads(streamers *ImportStreamers, reads []apimodel.GlucoseRead, report *ImportReport) (err error) {
+	streamers.Glucose, err = streamers.Glucose.WriteGlucoseReads(reads)
+	dropped, err := report.skipOutOfOrder("Glucose element", err)
 	if err != nil {
-		return lastRead.GetTime(), err
+		return err
 	}
 
-	exerciseStreamer, err = exerciseStreamer.Close()
-	if err != nil {
-		return lastRead.GetTime(), err
+	report.Reads += len(reads) - dropped
+	return nil
+}
+
+// elementLocation describes where an element is in the document for import reports
+func elementLocation(element xml.StartElement, offset int64) string {
+	return fmt.Sprintf("%s element at offset %d", element.Name.Local, offset)
+}
+
+// newGlucoseStreamer creates the streaming pipeline that persists the reads of the given device in days starting at the
+// batch boundary, teed to the analytics sink if not nil. The datastore writer at the end of the pipeline is returned along with the streamer and the writer
+// collecting the stats of the days of reads.
+func newGlucoseStreamer(context context.Context, parentKey *datastore.Key, deviceId string, analyticsSink *analytics.Sink, batchBoundary streaming.BatchBoundary) (*store.DataStoreGlucoseReadBatchWriter, *glukitio.StatsCollectingWriter, *streaming.GlucoseReadStreamer) {
+	glucoseDataStoreWriter := store.NewDataStoreDeviceGlucoseReadBatchWriter(context, parentKey, deviceId)
+	lowThreshold, highThreshold, _ := importSettings(context, parentKey)
+	statsWriter := glukitio.NewStatsCollectingWriter(glucoseDataStoreWriter, lowThreshold, highThreshold)
+
+	var glucoseWriter glukitio.GlucoseReadBatchWriter = statsWriter
+	if analyticsSink != nil {
+		glucoseWriter = glukitio.NewMultiGlucoseReadBatchWriter(statsWriter, analyticsSink.GlucoseReadWriter())
 	}
+	glucoseBatchingWriter := bufio.NewGlucoseReadWriterSize(glucoseWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
+	return glucoseDataStoreWriter, statsWriter, streaming.NewGlucoseStreamerDuration(glucoseBatchingWriter, apimodel.DAY_OF_DATA_DURATION).WithReorderWindow(IMPORT_REORDER_WINDOW).WithBatchBoundary(batchBoundary)
+}
 
-	log.Infof(context, "Done parsing and storing all data")
-	return lastRead.GetTime(), nil
+// newCalibrationStreamer creates the streaming pipeline that persists the calibrations of the given device in days
+// starting at the batch boundary, teed to the analytics sink if not nil
+func newCalibrationStreamer(context context.Context, parentKey *datastore.Key, deviceId string, analyticsSink *analytics.Sink, batchBoundary streaming.BatchBoundary) *streaming.CalibrationReadStreamer {
+	var calibrationWriter glukitio.CalibrationBatchWriter = store.NewDataStoreDeviceCalibrationBatchWriter(context, parentKey, deviceId)
+	if analyticsSink != nil {
+		calibrationWriter = glukitio.NewMultiCalibrationBatchWriter(calibrationWriter, analyticsSink.CalibrationWriter())
+	}
+	calibrationBatchingWriter := bufio.NewCalibrationWriterSize(calibrationWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
+	return streaming.NewCalibrationReadStreamerDuration(calibrationBatchingWriter, apimodel.DAY_OF_DATA_DURATION).WithReorderWindow(IMPORT_REORDER_WINDOW).WithBatchBoundary(batchBoundary)
 }
@@ -0,0 +1,405 @@
+package importer_test
+
+import (
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/glukitio"
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"google.golang.org/appengine/aetest"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	SYNTHETIC_READ_COUNT = 200000
+
+	// Upper bound of the live heap while parsing the synthetic document. The document itself is about 20MB so
+	// staying under this bound shows that memory doesn't grow with the size of the file.
+	MAX_LIVE_HEAP_BYTES = 8 * 1024 * 1024
+)
+
+// Start of the synthetic reads, at local midnight since their display time is 7 hours behind
+var syntheticStartTime = time.Date(2013, 9, 1, 7, 0, 0, 0, time.UTC)
+
+// syntheticDexcomXml returns a reader that generates a Dexcom document with count reads, one every 5 minutes, as it's
+// read so that the document itself is never held in memory
+func syntheticDexcomXml(count int) io.Reader {
+	reader, writer := io.Pipe()
+	go func() {
+		fmt.Fprint(writer, `<Patient Id="{E1B2FE4C-35F0-40B8-A15A-D3CBCA27BF75}" FirstName="Synthetic"><GlucoseReadings>`)
+		for i := 0; i < count; i++ {
+			internalTime := syntheticStartTime.Add(time.Duration(i*5) * time.Minute)
+			displayTime := internalTime.Add(time.Duration(-7) * time.Hour)
+			fmt.Fprintf(writer, `<Glucose InternalTime="%s" DisplayTime="%s" Value="%d" />`, internalTime.Format("2006-01-02 15:04:05"), displayTime.Format("2006-01-02 15:04:05"), 80+i%100)
+		}
+		fmt.Fprint(writer, `</GlucoseReadings></Patient>`)
+		writer.Close()
+	}()
+
+	return reader
+}
+
+// batchCheckingGlucoseWriter keeps only counts and checks that every day of reads it gets is a single full local day
+type batchCheckingGlucoseWriter struct {
+	t           testing.TB
+	total       int
+	days        int
+	writes      int
+	maxLiveHeap uint64
+}
+
+func (w *batchCheckingGlucoseWriter) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (glukitio.GlucoseReadBatchWriter, error) {
+	return w.WriteGlucoseReadBatches([]apimodel.DayOfGlucoseReads{apimodel.NewDayOfGlucoseReads(p)})
+}
+
+func (w *batchCheckingGlucoseWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (glukitio.GlucoseReadBatchWriter, error) {
+	for _, day := range p {
+		dayStart := ImportBatchBoundary("")(day.Reads[0].GetTime(), apimodel.DAY_OF_DATA_DURATION)
+		if lastRead := day.Reads[len(day.Reads)-1].GetTime(); !lastRead.Before(dayStart.AddDate(0, 0, 1)) {
+			w.t.Errorf("Day of reads starting at [%v] spans more than a day, last read is at [%v]", dayStart, lastRead)
+		}
+
+		// All days are complete except for the last one
+		if len(day.Reads) != 288 && w.total+len(day.Reads) != SYNTHETIC_READ_COUNT {
+			w.t.Errorf("Expected a full day of [288] reads starting at [%v] but got [%d]", dayStart, len(day.Reads))
+		}
+
+		w.total += len(day.Reads)
+		w.days++
+	}
+
+	// Sample the live heap every few batches
+	w.writes++
+	if w.writes%5 == 0 {
+		var memStats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&memStats)
+		if memStats.HeapAlloc > w.maxLiveHeap {
+			w.maxLiveHeap = memStats.HeapAlloc
+		}
+	}
+
+	return w, nil
+}
+
+func (w *batchCheckingGlucoseWriter) Flush() (glukitio.GlucoseReadBatchWriter, error) {
+	return w, nil
+}
+
+func parseSyntheticDexcomXml(t testing.TB) *batchCheckingGlucoseWriter {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	glucoseWriter := &batchCheckingGlucoseWriter{t: t}
+	w := recordsWriter{new(importedRecords)}
+	streamers := NewImportStreamers(ImportBatchBoundary(""), glucoseWriter, &calibrationRecordsWriter{w}, &injectionRecordsWriter{w}, &basalRateRecordsWriter{w}, &mealRecordsWriter{w}, &exerciseRecordsWriter{w})
+
+	lastReadTime, report, err := ParseDexcomXml(c, syntheticDexcomXml(SYNTHETIC_READ_COUNT), syntheticStartTime, streamers, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.RecordCount() != SYNTHETIC_READ_COUNT {
+		t.Errorf("Expected [%d] records but got [%d]", SYNTHETIC_READ_COUNT, report.RecordCount())
+	}
+
+	if expectedLastReadTime := syntheticStartTime.Add(time.Duration((SYNTHETIC_READ_COUNT-1)*5) * time.Minute); !lastReadTime.Equal(expectedLastReadTime) {
+		t.Errorf("Expected last read time [%v] but got [%v]", expectedLastReadTime, lastReadTime)
+	}
+
+	return glucoseWriter
+}
+
+func TestParseDexcomXmlReportsProgress(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, streamers := newRecordingStreamers()
+
+	var reported []ImportProgress
+	progress := func(p ImportProgress) { reported = append(reported, p) }
+	_, report, err := ParseDexcomXml(c, syntheticDexcomXml(1000), syntheticStartTime, streamers, progress, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reported) == 0 {
+		t.Fatal("Expected progress to be reported but got none")
+	}
+
+	for i := 1; i < len(reported); i++ {
+		if reported[i].Records < reported[i-1].Records || reported[i].Bytes < reported[i-1].Bytes || reported[i].At.Before(reported[i-1].At) {
+			t.Errorf("Expected progress to only move forward but got [%v] after [%v]", reported[i], reported[i-1])
+		}
+	}
+
+	if last := reported[len(reported)-1]; last.Records > report.RecordCount() || last.Bytes == 0 {
+		t.Errorf("Expected last progress to have consumed bytes and at most [%d] records but got [%v]", report.RecordCount(), last)
+	}
+}
+
+const dexcomXmlWithBadRecords = `<Patient Id="{E1B2FE4C-35F0-40B8-A15A-D3CBCA27BF75}" FirstName="Bad">
+<GlucoseReadings>
+<Glucose InternalTime="2013-09-01 00:00:00" DisplayTime="2013-08-31 17:00:00" Value="100" />
+<Glucose InternalTime="not a time" DisplayTime="2013-08-31 17:05:00" Value="105" />
+<Glucose InternalTime="2013-09-01 00:10:00" DisplayTime="2013-08-31 17:10:00" Value="110" />
+</GlucoseReadings>
+<EventMarkers>
+<Event InternalTime="2013-09-01 00:15:00" DisplayTime="2013-08-31 17:15:00" EventTime="2013-08-31 17:15:00" EventType="Insulin" Decription="Insulin lots units" />
+<Event InternalTime="2013-09-01 00:20:00" DisplayTime="2013-08-31 17:20:00" EventTime="2013-08-31 17:20:00" EventType="Carbs" Decription="Carbs 20 grams" />
+</EventMarkers>
+</Patient>`
+
+func TestParseDexcomXmlSkipsBadRecords(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	records, streamers := newRecordingStreamers()
+	_, report, err := ParseDexcomXml(c, strings.NewReader(dexcomXmlWithBadRecords), time.Unix(0, 0), streamers, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Reads != 2 || report.Meals != 1 || report.Injections != 0 {
+		t.Errorf("Expected [2] reads and [1] meal imported but got [%s]", report)
+	}
+
+	if len(records.reads) != 2 {
+		t.Errorf("Expected [2] reads written but got [%d]", len(records.reads))
+	}
+
+	if report.Skipped != 2 || len(report.SampleErrors) != 2 {
+		t.Fatalf("Expected [2] skipped records with their errors but got [%s]", report)
+	}
+
+	if location := report.SampleErrors[0].Location; !strings.HasPrefix(location, "Glucose element at offset") {
+		t.Errorf("Expected the location of the bad read to be reported but got [%s]", location)
+	}
+}
+
+func TestParseDexcomXmlFailsOnMalformedDocument(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, streamers := newRecordingStreamers()
+	_, _, err = ParseDexcomXml(c, strings.NewReader(`<Patient><GlucoseReadings><Glucose`), time.Unix(0, 0), streamers, nil, nil)
+	if err == nil || !IsDataError(err) {
+		t.Errorf("Expected a data error for a malformed document but got [%v]", err)
+	}
+}
+
+const mmolDexcomXml = `<Patient Id="{E1B2FE4C-35F0-40B8-A15A-D3CBCA27BF75}" FirstName="Mmol">
+<GlucoseReadings>
+<Glucose InternalTime="2013-09-01 00:00:00" DisplayTime="2013-08-31 17:00:00" Value="5.5" />
+<Glucose InternalTime="2013-09-01 00:05:00" DisplayTime="2013-08-31 17:05:00" Value="6.1" />
+<Glucose InternalTime="2013-09-01 00:10:00" DisplayTime="2013-08-31 17:10:00" Value="12" />
+</GlucoseReadings>
+<MeterReadings>
+<Meter InternalTime="2013-09-01 00:15:00" DisplayTime="2013-08-31 17:15:00" Value="6.0" />
+</MeterReadings>
+</Patient>`
+
+func TestParseDexcomXmlDetectsMmolPerL(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	records, streamers := newRecordingStreamers()
+	_, report, err := ParseDexcomXml(c, strings.NewReader(mmolDexcomXml), time.Unix(0, 0), streamers, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Unit != apimodel.MMOL_PER_L {
+		t.Errorf("Expected unit [%s] to be detected but got [%s]", apimodel.MMOL_PER_L, report.Unit)
+	}
+
+	expectedValues := []float32{mgPerDl(5.5), mgPerDl(6.1), mgPerDl(12)}
+	if len(records.reads) != len(expectedValues) {
+		t.Fatalf("Expected [%d] reads but got [%d]: %v", len(expectedValues), len(records.reads), records.reads)
+	}
+
+	for i, expectedValue := range expectedValues {
+		if records.reads[i].Unit != apimodel.MG_PER_DL || records.reads[i].Value != expectedValue {
+			t.Errorf("Expected read [%d] to be [%f] mg/dL but got [%v]", i, expectedValue, records.reads[i])
+		}
+	}
+
+	if len(records.calibrations) != 1 || records.calibrations[0].Unit != apimodel.MG_PER_DL || records.calibrations[0].Value != mgPerDl(6.0) {
+		t.Errorf("Expected a single calibration of [%f] mg/dL but got [%v]", mgPerDl(6.0), records.calibrations)
+	}
+}
+
+func TestParseDexcomXmlUsesStatedUnit(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// A stated unit wins over the values that would otherwise be mistaken for mmol/L
+	document := strings.Replace(mmolDexcomXml, "<GlucoseReadings>", `<GlucoseReadings Units="mg/dL">`, 1)
+	records, streamers := newRecordingStreamers()
+	_, report, err := ParseDexcomXml(c, strings.NewReader(document), time.Unix(0, 0), streamers, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Unit != apimodel.MG_PER_DL {
+		t.Errorf("Expected stated unit [%s] but got [%s]", apimodel.MG_PER_DL, report.Unit)
+	}
+
+	if len(records.reads) != 3 || records.reads[0].Value != 5.5 {
+		t.Errorf("Expected reads to be kept as is but got [%v]", records.reads)
+	}
+}
+
+func TestParseDexcomXmlIncludesCalibrationsInLastReadTime(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The meter entry comes after the last read so it must be what the next import starts from
+	records, streamers := newRecordingStreamers()
+	lastReadTime, _, err := ParseDexcomXml(c, strings.NewReader(mmolDexcomXml), time.Date(2013, 9, 1, 0, 5, 0, 0, time.UTC), streamers, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := time.Date(2013, 9, 1, 0, 15, 0, 0, time.UTC); !lastReadTime.Equal(expected) {
+		t.Errorf("Expected last read time to be the calibration time [%s] but got [%s]", expected, lastReadTime)
+	}
+
+	if len(records.calibrations) != 1 {
+		t.Errorf("Expected the calibration newer than the start time to be imported but got [%v]", records.calibrations)
+	}
+
+	records, streamers = newRecordingStreamers()
+	if _, _, err = ParseDexcomXml(c, strings.NewReader(mmolDexcomXml), lastReadTime, streamers, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records.calibrations) != 0 {
+		t.Errorf("Expected calibrations already imported to be skipped but got [%v]", records.calibrations)
+	}
+}
+
+// The user flies from Montreal to Vancouver halfway through: the display time goes back 3 hours while the internal
+// time keeps moving forward
+const travelingDexcomXml = `<Patient Id="{E1B2FE4C-35F0-40B8-A15A-D3CBCA27BF75}" FirstName="Traveler">
+<GlucoseReadings>
+<Glucose InternalTime="2014-04-18 20:50:00" DisplayTime="2014-04-18 16:50:00" Value="100" />
+<Glucose InternalTime="2014-04-18 20:55:00" DisplayTime="2014-04-18 16:55:00" Value="105" />
+<Glucose InternalTime="2014-04-18 21:00:00" DisplayTime="2014-04-18 17:00:00" Value="110" />
+<Glucose InternalTime="2014-04-18 21:05:00" DisplayTime="2014-04-18 14:05:00" Value="115" />
+<Glucose InternalTime="2014-04-18 21:10:00" DisplayTime="2014-04-18 14:10:00" Value="120" />
+<Glucose InternalTime="2014-04-18 21:15:00" DisplayTime="2014-04-18 14:15:00" Value="125" />
+</GlucoseReadings>
+</Patient>`
+
+func TestParseDexcomXmlWithTimezoneChange(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	records, streamers := newRecordingStreamers()
+	if _, _, err = ParseDexcomXml(c, strings.NewReader(travelingDexcomXml), time.Unix(0, 0), streamers, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = streamers.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records.reads) != 6 {
+		t.Fatalf("Expected [6] reads but got [%d]: %v", len(records.reads), records.reads)
+	}
+
+	expectedDisplayTimes := []string{"16:50", "16:55", "17:00", "14:05", "14:10", "14:15"}
+	for i, read := range records.reads {
+		if i > 0 && read.Time.Timestamp <= records.reads[i-1].Time.Timestamp {
+			t.Errorf("Expected reads strictly in chronological order but read [%d] is [%v] after [%v]", i, read, records.reads[i-1])
+		}
+
+		if displayTime := read.GetTime().Format("15:04"); displayTime != expectedDisplayTimes[i] {
+			t.Errorf("Expected read [%d] to have display time [%s] but got [%s] with timezone [%s]", i, expectedDisplayTimes[i],
+				displayTime, read.Time.TimeZoneId)
+		}
+	}
+
+	if records.reads[0].Time.TimeZoneId != "-0400" || records.reads[5].Time.TimeZoneId != "-0700" {
+		t.Errorf("Expected timezones [-0400] then [-0700] but got [%s] and [%s]", records.reads[0].Time.TimeZoneId, records.reads[5].Time.TimeZoneId)
+	}
+}
+
+func TestParseDexcomXmlHasBoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping parsing of large synthetic document in short mode")
+	}
+
+	glucoseWriter := parseSyntheticDexcomXml(t)
+
+	if glucoseWriter.total != SYNTHETIC_READ_COUNT {
+		t.Errorf("Expected [%d] reads written but got [%d]", SYNTHETIC_READ_COUNT, glucoseWriter.total)
+	}
+
+	if expectedDays := (SYNTHETIC_READ_COUNT + 287) / 288; glucoseWriter.days != expectedDays {
+		t.Errorf("Expected [%d] days of reads but got [%d]", expectedDays, glucoseWriter.days)
+	}
+
+	if glucoseWriter.maxLiveHeap > MAX_LIVE_HEAP_BYTES {
+		t.Errorf("Expected live heap to stay under [%d] bytes but it reached [%d]", MAX_LIVE_HEAP_BYTES, glucoseWriter.maxLiveHeap)
+	}
+}
+
+func BenchmarkParseDexcomXml(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		parseSyntheticDexcomXml(b)
+	}
+}
@@ -0,0 +1,41 @@
+package importer
+
+import (
+	"time"
+)
+
+// ImportProgress describes how far along an import is
+type ImportProgress struct {
+	// Number of records processed so far
+	Records int
+	// Time of the most recent record processed
+	At time.Time
+	// Number of bytes of the content consumed so far, 0 if unknown
+	Bytes int64
+}
+
+// ProgressHandler is called by the parsers as records are processed
+type ProgressHandler func(progress ImportProgress)
+
+// ThrottleProgress returns a ProgressHandler that only forwards progress to the handler once the interval has elapsed or
+// the given number of records have been processed since the last progress forwarded, whichever comes first
+func ThrottleProgress(handler ProgressHandler, interval time.Duration, records int) ProgressHandler {
+	lastForwarded := time.Now()
+	lastForwardedRecords := 0
+
+	return func(progress ImportProgress) {
+		now := time.Now()
+		if now.Sub(lastForwarded) >= interval || progress.Records-lastForwardedRecords >= records {
+			lastForwarded = now
+			lastForwardedRecords = progress.Records
+			handler(progress)
+		}
+	}
+}
+
+// reportProgress calls the handler, if there's one
+func reportProgress(handler ProgressHandler, progress ImportProgress) {
+	if handler != nil {
+		handler(progress)
+	}
+}
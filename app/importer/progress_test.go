@@ -0,0 +1,38 @@
+package importer_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"testing"
+	"time"
+)
+
+func TestThrottleProgressForwardsEveryRecordsThreshold(t *testing.T) {
+	var forwarded []ImportProgress
+	progress := ThrottleProgress(func(p ImportProgress) { forwarded = append(forwarded, p) }, time.Hour, 100)
+
+	for i := 1; i <= 350; i++ {
+		progress(ImportProgress{i, syntheticStartTime, 0})
+	}
+
+	if len(forwarded) != 3 {
+		t.Fatalf("Expected [3] progress forwarded but got [%d]: %v", len(forwarded), forwarded)
+	}
+
+	for i, p := range forwarded {
+		if expectedRecords := (i + 1) * 100; p.Records != expectedRecords {
+			t.Errorf("Expected progress [%d] to be at [%d] records but got [%d]", i, expectedRecords, p.Records)
+		}
+	}
+}
+
+func TestThrottleProgressForwardsAfterInterval(t *testing.T) {
+	forwarded := 0
+	progress := ThrottleProgress(func(p ImportProgress) { forwarded++ }, time.Duration(0), 1000000)
+
+	progress(ImportProgress{1, syntheticStartTime, 0})
+	progress(ImportProgress{2, syntheticStartTime, 0})
+
+	if forwarded != 2 {
+		t.Errorf("Expected [2] progress forwarded once the interval elapsed but got [%d]", forwarded)
+	}
+}
@@ -0,0 +1,153 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"io"
+	"time"
+)
+
+const (
+	// Size of the beginning of a file that is inspected to detect its format. It needs to be large enough to go past
+	// the preamble of CareLink exports.
+	FORMAT_DETECTION_SIZE = 16 * 1024
+
+	// Size of the beginning of a file reported when its format isn't recognized
+	SIGNATURE_SIZE = 64
+)
+
+// Detector returns true if the beginning of the content of a file, or its name, is the one of its format
+type Detector func(beginning []byte, filename string) bool
+
+// Parser reads content of a format and writes every record more recent than since to the streamers. The streamers
+// are left open for the caller to close. Progress is reported, if a handler is given, as the content is read.
+type Parser interface {
+	Parse(context context.Context, reader io.Reader, since time.Time, streamers *ImportStreamers, progress ProgressHandler) (lastReadTime time.Time, report *ImportReport, err error)
+}
+
+// ParserFunc adapts a function to the Parser interface
+type ParserFunc func(context context.Context, reader io.Reader, since time.Time, streamers *ImportStreamers, progress ProgressHandler) (lastReadTime time.Time, report *ImportReport, err error)
+
+func (f ParserFunc) Parse(context context.Context, reader io.Reader, since time.Time, streamers *ImportStreamers, progress ProgressHandler) (lastReadTime time.Time, report *ImportReport, err error) {
+	return f(context, reader, since, streamers, progress)
+}
+
+// Format is a file format that can be imported
+type Format struct {
+	Name   string
+	Detect Detector
+	Parser Parser
+}
+
+// UnrecognizedFormatError means that no registered format claimed a file. It holds the beginning of the file so that
+// new formats can be identified from the import logs.
+type UnrecognizedFormatError struct {
+	Filename  string
+	Signature string
+}
+
+func (err *UnrecognizedFormatError) Error() string {
+	return fmt.Sprintf("Unrecognized format for file [%s] starting with %s", err.Filename, err.Signature)
+}
+
+// Registry holds the formats that can be imported
+type Registry struct {
+	formats []*Format
+}
+
+// DefaultRegistry holds the Dexcom xml, Dexcom Clarity, Medtronic CareLink and Abbott FreeStyle Libre formats
+var DefaultRegistry = newDefaultRegistry()
+
+func newDefaultRegistry() *Registry {
+	registry := NewRegistry()
+	registry.Register(FORMAT_DEXCOM_XML, IsDexcomXml, ParserFunc(parseDexcomXml))
+	registry.Register(FORMAT_CLARITY, IsClarityExport, ParserFunc(parseClarity))
+	registry.Register(FORMAT_CARELINK, IsCareLinkExport, ParserFunc(parseCareLink))
+	registry.Register(FORMAT_LIBRE, IsLibreExport, ParserFunc(parseLibre))
+
+	return registry
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return new(Registry)
+}
+
+// Register adds a format to the registry. Formats are offered files in the order they're registered.
+func (registry *Registry) Register(name string, detector Detector, parser Parser) {
+	registry.formats = append(registry.formats, &Format{name, detector, parser})
+}
+
+// Formats returns the registered formats
+func (registry *Registry) Formats() []*Format {
+	return registry.formats
+}
+
+// Detect returns the first format that claims the file given the beginning of its content and its name. The content
+// to parse is returned along with the format since the beginning of the reader is consumed to detect the format.
+// Files that no format claims get an UnrecognizedFormatError.
+func (registry *Registry) Detect(reader io.Reader, filename string) (format *Format, content io.Reader, err error) {
+	bufferedReader := bufio.NewReaderSize(reader, FORMAT_DETECTION_SIZE)
+	beginning, err := bufferedReader.Peek(FORMAT_DETECTION_SIZE)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, bufferedReader, err
+	}
+
+	for _, format := range registry.formats {
+		if format.Detect(beginning, filename) {
+			return format, bufferedReader, nil
+		}
+	}
+
+	signature := beginning
+	if len(signature) > SIGNATURE_SIZE {
+		signature = signature[:SIGNATURE_SIZE]
+	}
+
+	return nil, bufferedReader, &UnrecognizedFormatError{filename, fmt.Sprintf("%q", bytes.TrimSpace(signature))}
+}
+
+// ImportContent parses the content with the parser of the format and persists its records under the user profile key.
+// Only the records more recent than startTime are kept. The most recent read of the user is updated once all records
+// are stored. A report of the records imported and skipped is returned along with the time of the last read or
+// calibration, whichever is the most recent, so that the next import picks up from there. A summary of every streamer
+// is logged once done, whether the import succeeded or not.
+func ImportContent(context context.Context, format *Format, reader io.Reader, parentKey *datastore.Key, startTime time.Time, progress ProgressHandler) (lastReadTime time.Time, report *ImportReport, err error) {
+	streamers := newDataStoreImportStreamers(context, parentKey, apimodel.DEFAULT_DEVICE_ID)
+	defer streamers.logStats(context)
+
+	lastReadTime, report, err = format.Parser.Parse(context, reader, startTime, streamers, progress)
+	if err != nil {
+		return lastReadTime, report, err
+	}
+
+	// Close the streams and flush anything pending
+	if err = streamers.Close(); err != nil {
+		return lastReadTime, report, err
+	}
+
+	if err = streamers.storeGaps(); err != nil {
+		return lastReadTime, report, err
+	}
+
+	if err = streamers.storeStats(); err != nil {
+		return lastReadTime, report, err
+	}
+
+	// Update the most recent read only once all reads have been stored
+	if mostRecentRead := streamers.mostRecentRead(); mostRecentRead != apimodel.UNDEFINED_GLUCOSE_READ {
+		if err = store.UpdateMostRecentRead(context, parentKey, mostRecentRead); err != nil {
+			return lastReadTime, report, err
+		}
+	}
+
+	reportProgress(progress, ImportProgress{report.RecordCount(), lastReadTime, 0})
+	log.Infof(context, "Done parsing and storing all %s data: %s", format.Name, report)
+	return lastReadTime, report, nil
+}
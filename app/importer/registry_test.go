@@ -0,0 +1,62 @@
+package importer_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestEachFixtureIsClaimedByExactlyOneFormat(t *testing.T) {
+	fixtures := map[string]string{
+		FORMAT_DEXCOM_XML: mmolDexcomXml,
+		FORMAT_CLARITY:    clarityFixture,
+		FORMAT_CARELINK:   careLinkFixture,
+		FORMAT_LIBRE:      libreFixture,
+	}
+
+	for expectedFormat, fixture := range fixtures {
+		var claimedBy []string
+		for _, format := range DefaultRegistry.Formats() {
+			if format.Detect([]byte(fixture), "export") {
+				claimedBy = append(claimedBy, format.Name)
+			}
+		}
+
+		if len(claimedBy) != 1 || claimedBy[0] != expectedFormat {
+			t.Errorf("Expected [%s] fixture to be claimed by [%s] only but got %v", expectedFormat, expectedFormat, claimedBy)
+		}
+	}
+}
+
+func TestDetectKeepsContentIntact(t *testing.T) {
+	format, content, err := DefaultRegistry.Detect(strings.NewReader(careLinkFixture), "export.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if format.Name != FORMAT_CARELINK {
+		t.Errorf("Expected format [%s] but got [%s]", FORMAT_CARELINK, format.Name)
+	}
+
+	if detectedContent, err := ioutil.ReadAll(content); err != nil {
+		t.Fatal(err)
+	} else if string(detectedContent) != careLinkFixture {
+		t.Errorf("Expected content to be returned as is but got [%s]", detectedContent)
+	}
+}
+
+func TestDetectUnrecognizedFormat(t *testing.T) {
+	_, _, err := DefaultRegistry.Detect(strings.NewReader("Date,Steps\n2016-01-14,10000\n"), "steps.csv")
+	if _, ok := err.(*UnrecognizedFormatError); !ok {
+		t.Fatalf("Expected an UnrecognizedFormatError but got [%v]", err)
+	}
+
+	if !IsDataError(err) {
+		t.Errorf("Expected [%v] to be a data error", err)
+	}
+
+	if !strings.Contains(err.Error(), "Date,Steps") {
+		t.Errorf("Expected the signature of the content in [%v]", err)
+	}
+}
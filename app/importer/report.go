@@ -0,0 +1,109 @@
+package importer
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/streaming"
+	"strings"
+	"time"
+)
+
+const (
+	// Maximum number of errors kept as samples in an import report
+	MAX_SAMPLE_ERRORS = 10
+
+	// Formats of the files that can be imported
+	FORMAT_DEXCOM_XML = "dexcom-xml"
+	FORMAT_CLARITY    = "clarity"
+	FORMAT_CARELINK   = "carelink"
+	FORMAT_LIBRE      = "libre"
+)
+
+// RecordError describes a record that was skipped because it couldn't be parsed
+type RecordError struct {
+	// Where the record is in the content (i.e. "line 12" or "Glucose element at offset 1024")
+	Location string
+	Message  string
+}
+
+// ImportReport summarizes an import: the number of records imported for each kind, the unit of the glucose values of
+// the source and the records that were skipped because they couldn't be parsed along with a sample of the errors. The
+// format and the time range covered by the records are only set when validating content.
+type ImportReport struct {
+	Format          string
+	FirstRecordTime time.Time
+	LastRecordTime  time.Time
+	Unit            apimodel.GlucoseUnit
+	Reads           int
+	Calibrations    int
+	Injections      int
+	BasalRates      int
+	Meals           int
+	Exercises       int
+	Skipped         int
+	SampleErrors    []RecordError
+}
+
+// RecordCount returns the total number of records imported
+func (report *ImportReport) RecordCount() int {
+	return report.Reads + report.Calibrations + report.Injections + report.BasalRates + report.Meals + report.Exercises
+}
+
+// skip counts a skipped record and keeps its error as a sample if we don't have enough samples already
+func (report *ImportReport) skip(location string, err error) {
+	report.Skipped++
+	if len(report.SampleErrors) < MAX_SAMPLE_ERRORS {
+		report.SampleErrors = append(report.SampleErrors, RecordError{location, err.Error()})
+	}
+}
+
+// skipOutOfOrder adds the records that a streamer dropped for being too far out of order to the report and returns the
+// number of them. Those records are skipped rather than failing the import. Any other error is returned as is.
+func (report *ImportReport) skipOutOfOrder(location string, err error) (dropped int, writeErr error) {
+	outOfOrderErr, ok := err.(*streaming.OutOfOrderError)
+	if !ok {
+		return 0, err
+	}
+
+	report.skip(location, err)
+	report.Skipped += outOfOrderErr.Count - 1
+	return outOfOrderErr.Count, nil
+}
+
+// String returns a summary of the report suitable for the import logs
+func (report *ImportReport) String() string {
+	summary := fmt.Sprintf("Imported %d reads, %d calibrations, %d injections, %d basal rates, %d meals and %d exercises", report.Reads,
+		report.Calibrations, report.Injections, report.BasalRates, report.Meals, report.Exercises)
+	if report.Skipped == 0 {
+		return summary
+	}
+
+	samples := make([]string, len(report.SampleErrors))
+	for i, sample := range report.SampleErrors {
+		samples[i] = fmt.Sprintf("%s: %s", sample.Location, sample.Message)
+	}
+
+	return fmt.Sprintf("%s, skipped %d records [%s]", summary, report.Skipped, strings.Join(samples, "; "))
+}
+
+// IsDataError returns true if the error is caused by the content itself (i.e. it's not a supported format or it's
+// malformed) rather than by a failure to read the content or store the records. Importing the same content again would
+// fail the same way so there's no point in retrying.
+func IsDataError(err error) bool {
+	switch err.(type) {
+	case *xml.SyntaxError, xml.UnmarshalError, *csv.ParseError, *UnrecognizedFormatError:
+		return true
+	}
+
+	switch err {
+	case ErrNotClarityExport, ErrNotCareLinkExport, ErrNotLibreExport, ErrNoDataFileInArchive, zip.ErrFormat, zip.ErrAlgorithm,
+		zip.ErrChecksum, gzip.ErrHeader, gzip.ErrChecksum:
+		return true
+	}
+
+	return false
+}
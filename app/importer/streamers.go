@@ -0,0 +1,280 @@
+package importer
+
+import (
+	"github.com/alexandre-normand/glukit/app/analytics"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/bufio"
+	"github.com/alexandre-normand/glukit/app/glukitio"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/streaming"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"sort"
+	"time"
+)
+
+const (
+	// How far out of chronological order records of an import can be. Exports sometimes list records of the same few
+	// minutes out of order; those are reordered by the streamers while older ones are skipped.
+	IMPORT_REORDER_WINDOW = 15 * time.Minute
+
+	// Minimum time between two consecutive reads for them to be recorded as a gap in the reads
+	IMPORT_READ_GAP_THRESHOLD = 30 * time.Minute
+)
+
+// ImportBatchBoundary returns where the streamers of an import for a user in the named location start days of data.
+// Days are aligned on midnight in the location of the user so that they match the user's calendar days whatever the
+// timezone of the records. Days of a user without a location are aligned on the local midnight of the records.
+func ImportBatchBoundary(locationName string) streaming.BatchBoundary {
+	if locationName == "" {
+		return streaming.LocalMidnightBoundary
+	}
+
+	return streaming.MidnightBoundaryIn(locationName)
+}
+
+// ImportStreamers groups the streamers that parsed records are written to. Streamers are immutable so
+// every write replaces the matching field with the streamer returned by the write.
+type ImportStreamers struct {
+	Glucose     *streaming.GlucoseReadStreamer
+	Calibration *streaming.CalibrationReadStreamer
+	Injection   *streaming.InjectionStreamer
+	BasalRate   *streaming.BasalRateStreamer
+	Meal        *streaming.MealStreamer
+	Exercise    *streaming.ExerciseStreamer
+
+	// Only set for streamers that persist to the datastore
+	context                context.Context
+	parentKey              *datastore.Key
+	glucoseDataStoreWriter *store.DataStoreGlucoseReadBatchWriter
+	glucoseStats           *glukitio.StatsCollectingWriter
+	deviceId               string
+	gaps                   []apimodel.ReadGap
+	analyticsSink          *analytics.Sink
+	batchBoundary          streaming.BatchBoundary
+}
+
+// NewImportStreamers returns streamers that batch records by day of data and write them to the given writers. Records
+// are reordered within IMPORT_REORDER_WINDOW and days start at the batch boundary, see ImportBatchBoundary.
+func NewImportStreamers(batchBoundary streaming.BatchBoundary, glucoseWriter glukitio.GlucoseReadBatchWriter, calibrationWriter glukitio.CalibrationBatchWriter, injectionWriter glukitio.InjectionBatchWriter, basalRateWriter glukitio.BasalRateBatchWriter, mealWriter glukitio.MealBatchWriter, exerciseWriter glukitio.ExerciseBatchWriter) *ImportStreamers {
+	s := new(ImportStreamers)
+	s.batchBoundary = batchBoundary
+	s.Glucose = streaming.NewGlucoseStreamerDuration(bufio.NewGlucoseReadWriterSize(glucoseWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE), apimodel.DAY_OF_DATA_DURATION).WithReorderWindow(IMPORT_REORDER_WINDOW).WithBatchBoundary(batchBoundary)
+	s.Calibration = streaming.NewCalibrationReadStreamerDuration(bufio.NewCalibrationWriterSize(calibrationWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE), apimodel.DAY_OF_DATA_DURATION).WithReorderWindow(IMPORT_REORDER_WINDOW).WithBatchBoundary(batchBoundary)
+	s.Injection = streaming.NewInjectionStreamerDuration(bufio.NewInjectionWriterSize(injectionWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE), apimodel.DAY_OF_DATA_DURATION).WithReorderWindow(IMPORT_REORDER_WINDOW).WithBatchBoundary(batchBoundary)
+	s.BasalRate = streaming.NewBasalRateStreamerDuration(bufio.NewBasalRateWriterSize(basalRateWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE), apimodel.DAY_OF_DATA_DURATION).WithReorderWindow(IMPORT_REORDER_WINDOW).WithBatchBoundary(batchBoundary)
+	s.Meal = streaming.NewMealStreamerDuration(bufio.NewMealWriterSize(mealWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE), apimodel.DAY_OF_DATA_DURATION).WithReorderWindow(IMPORT_REORDER_WINDOW).WithBatchBoundary(batchBoundary)
+	s.Exercise = streaming.NewExerciseStreamerDuration(bufio.NewExerciseWriterSize(exerciseWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE), apimodel.DAY_OF_DATA_DURATION).WithReorderWindow(IMPORT_REORDER_WINDOW).WithBatchBoundary(batchBoundary)
+
+	return s
+}
+
+// importSettings returns the target range of the user, in mg/dL, and the name of the user's location so that days of
+// data are aligned on the user's calendar days, see ImportBatchBoundary. The default thresholds and no location are
+// returned if the user profile can't be read.
+func importSettings(context context.Context, parentKey *datastore.Key) (lowThreshold, highThreshold float32, locationName string) {
+	glukitUser, err := store.GetGlukitUserWithKey(context, parentKey)
+	if err != nil {
+		log.Warningf(context, "Error reading user profile with key [%s] for import settings, using defaults: %v", parentKey, err)
+		return apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD, apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD, ""
+	}
+
+	return glukitUser.TargetLow, glukitUser.TargetHigh, glukitUser.Timezone
+}
+
+// newDataStoreImportStreamers returns streamers that persist records under the given user profile key. The glucose
+// datastore writer is kept so that the most recent read can be read once the streamers are closed. The stats of the days
+// of reads and the gaps in the reads are kept to be stored along with them. If the analytics export is enabled, the
+// datastore writers are teed to its sink.
+func newDataStoreImportStreamers(context context.Context, parentKey *datastore.Key, deviceId string) *ImportStreamers {
+	glucoseDataStoreWriter := store.NewDataStoreDeviceGlucoseReadBatchWriter(context, parentKey, deviceId)
+	lowThreshold, highThreshold, locationName := importSettings(context, parentKey)
+	glucoseStats := glukitio.NewStatsCollectingWriter(glucoseDataStoreWriter, lowThreshold, highThreshold)
+
+	var glucoseWriter glukitio.GlucoseReadBatchWriter = glucoseStats
+	var calibrationWriter glukitio.CalibrationBatchWriter = store.NewDataStoreCalibrationBatchWriter(context, parentKey)
+	var injectionWriter glukitio.InjectionBatchWriter = store.NewDataStoreInjectionBatchWriter(context, parentKey)
+	var basalRateWriter glukitio.BasalRateBatchWriter = store.NewDataStoreBasalRateBatchWriter(context, parentKey)
+	var mealWriter glukitio.MealBatchWriter = store.NewDataStoreMealBatchWriter(context, parentKey)
+	var exerciseWriter glukitio.ExerciseBatchWriter = store.NewDataStoreExerciseBatchWriter(context, parentKey)
+
+	analyticsSink := analytics.NewSink(context, parentKey.StringID())
+	if analyticsSink != nil {
+		glucoseWriter = glukitio.NewMultiGlucoseReadBatchWriter(glucoseWriter, analyticsSink.GlucoseReadWriter())
+		calibrationWriter = glukitio.NewMultiCalibrationBatchWriter(calibrationWriter, analyticsSink.CalibrationWriter())
+		injectionWriter = glukitio.NewMultiInjectionBatchWriter(injectionWriter, analyticsSink.InjectionWriter())
+		basalRateWriter = glukitio.NewMultiBasalRateBatchWriter(basalRateWriter, analyticsSink.BasalRateWriter())
+		mealWriter = glukitio.NewMultiMealBatchWriter(mealWriter, analyticsSink.MealWriter())
+		exerciseWriter = glukitio.NewMultiExerciseBatchWriter(exerciseWriter, analyticsSink.ExerciseWriter())
+	}
+
+	s := NewImportStreamers(ImportBatchBoundary(locationName), glucoseWriter, calibrationWriter, injectionWriter, basalRateWriter, mealWriter, exerciseWriter)
+	s.context, s.parentKey, s.glucoseDataStoreWriter = context, parentKey, glucoseDataStoreWriter
+	s.glucoseStats, s.deviceId, s.analyticsSink = glucoseStats, deviceId, analyticsSink
+	s.Glucose = s.Glucose.WithGapDetection(IMPORT_READ_GAP_THRESHOLD, s.addGap)
+
+	return s
+}
+
+// useDevice switches the glucose and calibration streamers to ones that persist the reads and calibrations of the given
+// device. It must be called before any read or calibration is written. Streamers that don't persist to the datastore
+// are left as is.
+func (s *ImportStreamers) useDevice(deviceId string) {
+	if s.parentKey == nil {
+		return
+	}
+
+	log.Infof(s.context, "Importing reads for device [%s]", deviceId)
+	s.glucoseDataStoreWriter, s.glucoseStats, s.Glucose = newGlucoseStreamer(s.context, s.parentKey, deviceId, s.analyticsSink, s.batchBoundary)
+	s.Calibration = newCalibrationStreamer(s.context, s.parentKey, deviceId, s.analyticsSink, s.batchBoundary)
+	s.deviceId = deviceId
+	s.Glucose = s.Glucose.WithGapDetection(IMPORT_READ_GAP_THRESHOLD, s.addGap)
+}
+
+// addGap keeps a gap detected in the reads to store it once the import is done
+func (s *ImportStreamers) addGap(gap apimodel.ReadGap) {
+	s.gaps = append(s.gaps, gap)
+}
+
+// storeGaps stores the gaps detected in the reads if the streamers persist to the datastore
+func (s *ImportStreamers) storeGaps() (err error) {
+	if s.parentKey == nil || len(s.gaps) == 0 {
+		return nil
+	}
+
+	_, err = store.StoreReadGaps(s.context, s.parentKey, s.gaps)
+	return err
+}
+
+// storeStats stores the stats of the days of reads written if the streamers persist to the datastore
+func (s *ImportStreamers) storeStats() (err error) {
+	if s.glucoseStats == nil {
+		return nil
+	}
+
+	if daysOfStats := s.glucoseStats.DaysOfStats(); len(daysOfStats) > 0 {
+		_, err = store.StoreDaysOfStats(s.context, s.parentKey, s.deviceId, daysOfStats)
+	}
+	return err
+}
+
+// mostRecentRead returns the most recent read persisted by the streamers or UNDEFINED_GLUCOSE_READ if they don't
+// persist to the datastore
+func (s *ImportStreamers) mostRecentRead() apimodel.GlucoseRead {
+	if s.glucoseDataStoreWriter == nil {
+		return apimodel.UNDEFINED_GLUCOSE_READ
+	}
+
+	return s.glucoseDataStoreWriter.MostRecentRead()
+}
+
+// logStats logs a summary of the activity of every streamer so that records missing from an import can be traced to
+// the streamer holding them
+func (s *ImportStreamers) logStats(context context.Context) {
+	log.Infof(context, "Glucose streamer: %s", s.Glucose.Stats())
+	log.Infof(context, "Calibration streamer: %s", s.Calibration.Stats())
+	log.Infof(context, "Injection streamer: %s", s.Injection.Stats())
+	log.Infof(context, "Basal rate streamer: %s", s.BasalRate.Stats())
+	log.Infof(context, "Meal streamer: %s", s.Meal.Stats())
+	log.Infof(context, "Exercise streamer: %s", s.Exercise.Stats())
+}
+
+// Close flushes all streamers and their inner writers, then enqueues the remaining rows of the analytics sink, if any.
+// It stops at the first error.
+func (s *ImportStreamers) Close() (err error) {
+	if s.Glucose, err = s.Glucose.Close(); err != nil {
+		return err
+	}
+
+	if s.Calibration, err = s.Calibration.Close(); err != nil {
+		return err
+	}
+
+	if s.Injection, err = s.Injection.Close(); err != nil {
+		return err
+	}
+
+	if s.BasalRate, err = s.BasalRate.Close(); err != nil {
+		return err
+	}
+
+	if s.Meal, err = s.Meal.Close(); err != nil {
+		return err
+	}
+
+	if s.Exercise, err = s.Exercise.Close(); err != nil {
+		return err
+	}
+
+	if s.analyticsSink != nil {
+		s.analyticsSink.Close()
+	}
+
+	return nil
+}
+
+// recordBuffer holds records for formats that don't list them chronologically. Records are accumulated and sorted before
+// being written to the streamers which expect them in chronological order.
+type recordBuffer struct {
+	reads        apimodel.GlucoseReadSlice
+	calibrations apimodel.CalibrationReadSlice
+	injections   apimodel.InjectionSlice
+	basalRates   apimodel.BasalRateSlice
+	meals        apimodel.MealSlice
+	exercises    apimodel.ExerciseSlice
+}
+
+// write sorts the records chronologically, writes them to the streamers and counts them in the report. The time of the
+// most recent read or calibration is returned or startTime if there are neither.
+func (records *recordBuffer) write(startTime time.Time, streamers *ImportStreamers, report *ImportReport) (lastReadTime time.Time, err error) {
+	lastReadTime = startTime
+
+	// Stable sorts keep records of the same millisecond in the order they were read
+	sort.Stable(records.reads)
+	sort.Stable(records.calibrations)
+	sort.Stable(records.injections)
+	sort.Stable(records.basalRates)
+	sort.Stable(records.meals)
+	sort.Stable(records.exercises)
+
+	if streamers.Glucose, err = streamers.Glucose.WriteGlucoseReads(records.reads); err != nil {
+		return lastReadTime, err
+	}
+	report.Reads += len(records.reads)
+	if len(records.reads) > 0 {
+		lastReadTime = records.reads[len(records.reads)-1].GetTime()
+	}
+
+	if streamers.Calibration, err = streamers.Calibration.WriteCalibrations(records.calibrations); err != nil {
+		return lastReadTime, err
+	}
+	report.Calibrations += len(records.calibrations)
+	if len(records.calibrations) > 0 {
+		if lastCalibrationTime := records.calibrations[len(records.calibrations)-1].GetTime(); lastCalibrationTime.After(lastReadTime) {
+			lastReadTime = lastCalibrationTime
+		}
+	}
+
+	if streamers.Injection, err = streamers.Injection.WriteInjections(records.injections); err != nil {
+		return lastReadTime, err
+	}
+	report.Injections += len(records.injections)
+
+	if streamers.BasalRate, err = streamers.BasalRate.WriteBasalRates(records.basalRates); err != nil {
+		return lastReadTime, err
+	}
+	report.BasalRates += len(records.basalRates)
+
+	if streamers.Meal, err = streamers.Meal.WriteMeals(records.meals); err != nil {
+		return lastReadTime, err
+	}
+	report.Meals += len(records.meals)
+
+	if streamers.Exercise, err = streamers.Exercise.WriteExercises(records.exercises); err != nil {
+		return lastReadTime, err
+	}
+	report.Exercises += len(records.exercises)
+
+	return lastReadTime, nil
+}
@@ -0,0 +1,157 @@
+package importer_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/glukitio"
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"testing"
+	"time"
+)
+
+// importedRecords holds everything written to the streamers returned by newRecordingStreamers
+type importedRecords struct {
+	reads        []apimodel.GlucoseRead
+	calibrations []apimodel.CalibrationRead
+	injections   []apimodel.Injection
+	basalRates   []apimodel.BasalRate
+	meals        []apimodel.Meal
+	exercises    []apimodel.Exercise
+}
+
+type recordsWriter struct {
+	records *importedRecords
+}
+
+func (w *recordsWriter) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (glukitio.GlucoseReadBatchWriter, error) {
+	w.records.reads = append(w.records.reads, p...)
+	return w, nil
+}
+
+func (w *recordsWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (glukitio.GlucoseReadBatchWriter, error) {
+	for _, day := range p {
+		w.records.reads = append(w.records.reads, day.Reads...)
+	}
+	return w, nil
+}
+
+func (w *recordsWriter) Flush() (glukitio.GlucoseReadBatchWriter, error) {
+	return w, nil
+}
+
+type calibrationRecordsWriter struct{ recordsWriter }
+
+func (w *calibrationRecordsWriter) WriteCalibrationBatch(p []apimodel.CalibrationRead) (glukitio.CalibrationBatchWriter, error) {
+	w.records.calibrations = append(w.records.calibrations, p...)
+	return w, nil
+}
+
+func (w *calibrationRecordsWriter) WriteCalibrationBatches(p []apimodel.DayOfCalibrationReads) (glukitio.CalibrationBatchWriter, error) {
+	for _, day := range p {
+		w.records.calibrations = append(w.records.calibrations, day.Reads...)
+	}
+	return w, nil
+}
+
+func (w *calibrationRecordsWriter) Flush() (glukitio.CalibrationBatchWriter, error) {
+	return w, nil
+}
+
+type injectionRecordsWriter struct{ recordsWriter }
+
+func (w *injectionRecordsWriter) WriteInjectionBatch(p []apimodel.Injection) (glukitio.InjectionBatchWriter, error) {
+	w.records.injections = append(w.records.injections, p...)
+	return w, nil
+}
+
+func (w *injectionRecordsWriter) WriteInjectionBatches(p []apimodel.DayOfInjections) (glukitio.InjectionBatchWriter, error) {
+	for _, day := range p {
+		w.records.injections = append(w.records.injections, day.Injections...)
+	}
+	return w, nil
+}
+
+func (w *injectionRecordsWriter) Flush() (glukitio.InjectionBatchWriter, error) {
+	return w, nil
+}
+
+type basalRateRecordsWriter struct{ recordsWriter }
+
+func (w *basalRateRecordsWriter) WriteBasalRateBatch(p []apimodel.BasalRate) (glukitio.BasalRateBatchWriter, error) {
+	w.records.basalRates = append(w.records.basalRates, p...)
+	return w, nil
+}
+
+func (w *basalRateRecordsWriter) WriteBasalRateBatches(p []apimodel.DayOfBasalRates) (glukitio.BasalRateBatchWriter, error) {
+	for _, day := range p {
+		w.records.basalRates = append(w.records.basalRates, day.BasalRates...)
+	}
+	return w, nil
+}
+
+func (w *basalRateRecordsWriter) Flush() (glukitio.BasalRateBatchWriter, error) {
+	return w, nil
+}
+
+type mealRecordsWriter struct{ recordsWriter }
+
+func (w *mealRecordsWriter) WriteMealBatch(p []apimodel.Meal) (glukitio.MealBatchWriter, error) {
+	w.records.meals = append(w.records.meals, p...)
+	return w, nil
+}
+
+func (w *mealRecordsWriter) WriteMealBatches(p []apimodel.DayOfMeals) (glukitio.MealBatchWriter, error) {
+	for _, day := range p {
+		w.records.meals = append(w.records.meals, day.Meals...)
+	}
+	return w, nil
+}
+
+func (w *mealRecordsWriter) Flush() (glukitio.MealBatchWriter, error) {
+	return w, nil
+}
+
+type exerciseRecordsWriter struct{ recordsWriter }
+
+func (w *exerciseRecordsWriter) WriteExerciseBatch(p []apimodel.Exercise) (glukitio.ExerciseBatchWriter, error) {
+	w.records.exercises = append(w.records.exercises, p...)
+	return w, nil
+}
+
+func (w *exerciseRecordsWriter) WriteExerciseBatches(p []apimodel.DayOfExercises) (glukitio.ExerciseBatchWriter, error) {
+	for _, day := range p {
+		w.records.exercises = append(w.records.exercises, day.Exercises...)
+	}
+	return w, nil
+}
+
+func (w *exerciseRecordsWriter) Flush() (glukitio.ExerciseBatchWriter, error) {
+	return w, nil
+}
+
+// mgPerDl converts a mmol/L value to mg/dL the same way reads are converted on import
+func mgPerDl(value float32) float32 {
+	return value * 18.0182
+}
+
+func newRecordingStreamers() (*importedRecords, *ImportStreamers) {
+	records := new(importedRecords)
+	w := recordsWriter{records}
+	return records, NewImportStreamers(ImportBatchBoundary(""), &w, &calibrationRecordsWriter{w}, &injectionRecordsWriter{w}, &basalRateRecordsWriter{w}, &mealRecordsWriter{w}, &exerciseRecordsWriter{w})
+}
+
+func TestImportBatchBoundaryIsMidnightInLocationOfUser(t *testing.T) {
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("Timezone data not available: %v", err)
+	}
+
+	// Records in UTC of a user in Los Angeles, 19h00 on March 7th there
+	readTime := time.Date(2015, time.March, 8, 3, 0, 0, 0, time.UTC)
+	if dayStart, expected := ImportBatchBoundary("America/Los_Angeles")(readTime, apimodel.DAY_OF_DATA_DURATION), time.Date(2015, time.March, 7, 0, 0, 0, 0, location); !dayStart.Equal(expected) {
+		t.Errorf("Expected day to start at [%s] but got [%s]", expected, dayStart)
+	}
+
+	if dayStart, expected := ImportBatchBoundary("")(readTime, apimodel.DAY_OF_DATA_DURATION), time.Date(2015, time.March, 8, 0, 0, 0, 0, time.UTC); !dayStart.Equal(expected) {
+		t.Errorf("Expected day of user without a location to start at the local midnight [%s] but got [%s]", expected, dayStart)
+	}
+}
@@ -0,0 +1,60 @@
+package importer
+
+import (
+	"errors"
+	"github.com/alexandre-normand/glukit/app/util"
+	"regexp"
+	"time"
+)
+
+var (
+	// Internal, display and event times of the elements of a Dexcom xml document
+	dexcomTimeAttribute = regexp.MustCompile(`(InternalTime|DisplayTime|EventTime)="([0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2})"`)
+	// Internal time of the glucose reads of a Dexcom xml document
+	dexcomGlucoseInternalTime = regexp.MustCompile(`<Glucose InternalTime="([0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2})"`)
+)
+
+// LatestDexcomGlucoseTime returns the internal time, in UTC, of the latest glucose read of a Dexcom xml document
+func LatestDexcomGlucoseTime(content []byte) (latest time.Time, err error) {
+	matches := dexcomGlucoseInternalTime.FindAllSubmatch(content, -1)
+	if len(matches) == 0 {
+		return latest, errors.New("No glucose read found in Dexcom xml content")
+	}
+
+	for _, match := range matches {
+		readTime, err := time.Parse(util.TIMEFORMAT_NO_TZ, string(match[1]))
+		if err != nil {
+			return latest, err
+		}
+
+		if readTime.After(latest) {
+			latest = readTime
+		}
+	}
+
+	return latest, nil
+}
+
+// ShiftDexcomXmlTimes returns the Dexcom xml document with all of its internal, display and event times shifted by the
+// given duration. Internal and display times move together so the local timezone they imply is preserved, as is the
+// spacing between records.
+func ShiftDexcomXmlTimes(content []byte, shift time.Duration) []byte {
+	return dexcomTimeAttribute.ReplaceAllFunc(content, func(attribute []byte) []byte {
+		match := dexcomTimeAttribute.FindSubmatch(attribute)
+		value, err := time.Parse(util.TIMEFORMAT_NO_TZ, string(match[2]))
+		if err != nil {
+			return attribute
+		}
+
+		return []byte(string(match[1]) + `="` + value.Add(shift).Format(util.TIMEFORMAT_NO_TZ) + `"`)
+	})
+}
+
+// WholeDaysShift returns the shift, in whole days, that moves the date of from to the date of to, both in UTC. Shifting
+// by whole days keeps the time of day of shifted times and yields the same shift all day long.
+func WholeDaysShift(from time.Time, to time.Time) time.Duration {
+	fromDay := time.Date(from.UTC().Year(), from.UTC().Month(), from.UTC().Day(), 0, 0, 0, 0, time.UTC)
+	toDay := time.Date(to.UTC().Year(), to.UTC().Month(), to.UTC().Day(), 0, 0, 0, 0, time.UTC)
+
+	return toDay.Sub(fromDay)
+}
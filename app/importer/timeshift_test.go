@@ -0,0 +1,56 @@
+package importer_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"testing"
+	"time"
+)
+
+const timeShiftContent = `<Patient Id="{E1B2FE4C}"><MeterReadings><Meter InternalTime="2013-09-01 14:45:54" DisplayTime="2013-09-01 07:46:32" Value="3.00" /></MeterReadings>` +
+	`<GlucoseReadings><Glucose InternalTime="2013-12-15 04:43:08" DisplayTime="2013-12-14 20:43:05" Value="3.11" />` +
+	`<Glucose InternalTime="2013-12-15 04:38:08" DisplayTime="2013-12-14 20:38:05" Value="3.05" /></GlucoseReadings>` +
+	`<EventMarkers><Event InternalTime="2013-12-15 02:44:51" DisplayTime="2013-12-14 18:44:48" EventTime="2013-12-14 18:44:00" EventType="Carbs" Decription="Carbs 4 grams" /></EventMarkers></Patient>`
+
+func TestLatestDexcomGlucoseTime(t *testing.T) {
+	latest, err := LatestDexcomGlucoseTime([]byte(timeShiftContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := time.Date(2013, time.December, 15, 4, 43, 8, 0, time.UTC); !latest.Equal(expected) {
+		t.Errorf("Expected latest glucose time [%s] but got [%s]", expected, latest)
+	}
+}
+
+func TestLatestDexcomGlucoseTimeWithoutReads(t *testing.T) {
+	if _, err := LatestDexcomGlucoseTime([]byte(`<Patient Id="{E1B2FE4C}"></Patient>`)); err == nil {
+		t.Errorf("Expected an error for content without glucose reads")
+	}
+}
+
+func TestShiftDexcomXmlTimes(t *testing.T) {
+	shifted := string(ShiftDexcomXmlTimes([]byte(timeShiftContent), time.Duration(2*24)*time.Hour))
+
+	expected := `<Patient Id="{E1B2FE4C}"><MeterReadings><Meter InternalTime="2013-09-03 14:45:54" DisplayTime="2013-09-03 07:46:32" Value="3.00" /></MeterReadings>` +
+		`<GlucoseReadings><Glucose InternalTime="2013-12-17 04:43:08" DisplayTime="2013-12-16 20:43:05" Value="3.11" />` +
+		`<Glucose InternalTime="2013-12-17 04:38:08" DisplayTime="2013-12-16 20:38:05" Value="3.05" /></GlucoseReadings>` +
+		`<EventMarkers><Event InternalTime="2013-12-17 02:44:51" DisplayTime="2013-12-16 18:44:48" EventTime="2013-12-16 18:44:00" EventType="Carbs" Decription="Carbs 4 grams" /></EventMarkers></Patient>`
+	if shifted != expected {
+		t.Errorf("Expected shifted content [%s] but got [%s]", expected, shifted)
+	}
+}
+
+func TestWholeDaysShiftIsStableThroughTheDay(t *testing.T) {
+	latest := time.Date(2013, time.December, 15, 4, 43, 8, 0, time.UTC)
+	morning := time.Date(2015, time.June, 2, 0, 5, 0, 0, time.UTC)
+	evening := time.Date(2015, time.June, 2, 23, 55, 0, 0, time.UTC)
+
+	shift := WholeDaysShift(latest, morning)
+	if shift != WholeDaysShift(latest, evening) {
+		t.Errorf("Expected the same shift all day long but got [%v] and [%v]", shift, WholeDaysShift(latest, evening))
+	}
+
+	if shifted := latest.Add(shift); !shifted.Equal(time.Date(2015, time.June, 2, 4, 43, 8, 0, time.UTC)) {
+		t.Errorf("Expected latest time shifted to [2015-06-02 04:43:08] but got [%s]", shifted)
+	}
+}
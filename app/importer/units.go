@@ -0,0 +1,124 @@
+package importer
+
+import (
+	"encoding/xml"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"sort"
+	"strings"
+)
+
+const (
+	// mg/dL reads start at 39 (lower values are reported as "Low") and mmol/L reads never go above 33.3 so a value
+	// under this threshold can only be in mmol/L
+	MMOL_PER_L_MAX_VALUE = 35.
+
+	// Number of reads looked at to detect the unit of a file that doesn't state it
+	UNIT_DETECTION_SAMPLE_SIZE = 12
+)
+
+// toMgPerDl converts a glucose value to mg/dL, the unit all reads are stored with
+func toMgPerDl(unit apimodel.GlucoseUnit, value float32) float32 {
+	if unit != apimodel.MMOL_PER_L {
+		return value
+	}
+
+	normalizedValue, _ := apimodel.GlucoseRead{Unit: unit, Value: value}.GetNormalizedValue(apimodel.MG_PER_DL)
+	return normalizedValue
+}
+
+// getUnitFromAttributes returns the glucose unit stated by an element of a Dexcom document (i.e. Units="mmol/L") or
+// UNKNOWN_GLUCOSE_MEASUREMENT_UNIT if the element doesn't have one
+func getUnitFromAttributes(attributes []xml.Attr) apimodel.GlucoseUnit {
+	for _, attribute := range attributes {
+		if name := strings.ToLower(attribute.Name.Local); !strings.HasSuffix(name, "unit") && !strings.HasSuffix(name, "units") {
+			continue
+		}
+
+		switch value := strings.ToLower(attribute.Value); {
+		case strings.Contains(value, "mmol"):
+			return apimodel.MMOL_PER_L
+		case strings.Contains(value, "mg"):
+			return apimodel.MG_PER_DL
+		}
+	}
+
+	return apimodel.UNKNOWN_GLUCOSE_MEASUREMENT_UNIT
+}
+
+// getUnitFromValues guesses the unit of a sample of glucose values. The median is used rather than any single value so
+// that an odd value doesn't decide the unit of a whole file.
+func getUnitFromValues(values []float32) apimodel.GlucoseUnit {
+	if len(values) == 0 {
+		return apimodel.UNKNOWN_GLUCOSE_MEASUREMENT_UNIT
+	}
+
+	sorted := make([]float64, len(values))
+	for i, value := range values {
+		sorted[i] = float64(value)
+	}
+	sort.Float64s(sorted)
+
+	if sorted[len(sorted)/2] < MMOL_PER_L_MAX_VALUE {
+		return apimodel.MMOL_PER_L
+	}
+
+	return apimodel.MG_PER_DL
+}
+
+// glucoseUnitDetector detects the unit of the reads of a file. If the file states its unit, reads are converted right
+// away. Otherwise, the first reads are held until there's enough of them to guess the unit from their values.
+type glucoseUnitDetector struct {
+	unit    apimodel.GlucoseUnit
+	pending []apimodel.GlucoseRead
+}
+
+func newGlucoseUnitDetector() *glucoseUnitDetector {
+	return &glucoseUnitDetector{apimodel.UNKNOWN_GLUCOSE_MEASUREMENT_UNIT, nil}
+}
+
+// declare sets the unit stated by the file, if it's known
+func (detector *glucoseUnitDetector) declare(unit apimodel.GlucoseUnit) {
+	if unit != apimodel.UNKNOWN_GLUCOSE_MEASUREMENT_UNIT {
+		detector.unit = unit
+	}
+}
+
+// add takes a read and returns the reads that are ready to be written, converted to mg/dL
+func (detector *glucoseUnitDetector) add(read apimodel.GlucoseRead) []apimodel.GlucoseRead {
+	detector.pending = append(detector.pending, read)
+	if detector.unit == apimodel.UNKNOWN_GLUCOSE_MEASUREMENT_UNIT && len(detector.pending) < UNIT_DETECTION_SAMPLE_SIZE {
+		return nil
+	}
+
+	return detector.flush()
+}
+
+// flush detects the unit from the pending reads if it's still unknown and returns them converted to mg/dL
+func (detector *glucoseUnitDetector) flush() []apimodel.GlucoseRead {
+	if detector.unit == apimodel.UNKNOWN_GLUCOSE_MEASUREMENT_UNIT {
+		values := make([]float32, len(detector.pending))
+		for i, read := range detector.pending {
+			values[i] = read.Value
+		}
+		detector.unit = getUnitFromValues(values)
+	}
+
+	reads := detector.pending
+	for i := range reads {
+		reads[i] = apimodel.GlucoseRead{reads[i].Time, apimodel.MG_PER_DL, toMgPerDl(detector.unit, reads[i].Value)}
+	}
+	detector.pending = nil
+
+	return reads
+}
+
+// canonicalValue converts a value of the file to mg/dL. If the unit of the file isn't known yet, the value alone is
+// used to guess it.
+func (detector *glucoseUnitDetector) canonicalValue(value float32) float32 {
+	unit := detector.unit
+	if unit == apimodel.UNKNOWN_GLUCOSE_MEASUREMENT_UNIT {
+		unit = getUnitFromValues([]float32{value})
+	}
+
+	return toMgPerDl(unit, value)
+}
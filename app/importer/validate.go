@@ -0,0 +1,205 @@
+package importer
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/glukitio"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"io"
+	"time"
+)
+
+// ValidateContent runs the full parse of a file without storing anything and returns the report of what an import
+// would do: the format of the file, the number of records of each kind, the time range they cover, the glucose unit
+// of the file and the records that can't be parsed. Like the import, the format is detected by the DefaultRegistry.
+func ValidateContent(context context.Context, reader io.Reader, filename string) (report *ImportReport, err error) {
+	format, reader, err := DefaultRegistry.Detect(reader, filename)
+	if err != nil {
+		return new(ImportReport), err
+	}
+
+	timeRange := new(recordTimeRange)
+	streamers := NewImportStreamers(ImportBatchBoundary(""), &glucoseReadDiscarder{timeRange}, &calibrationDiscarder{timeRange},
+		&injectionDiscarder{timeRange}, &basalRateDiscarder{timeRange}, &mealDiscarder{timeRange}, &exerciseDiscarder{timeRange})
+
+	_, report, err = format.Parser.Parse(context, reader, util.GLUKIT_EPOCH_TIME, streamers, nil)
+	report.Format = format.Name
+	if err != nil {
+		return report, err
+	}
+
+	if err = streamers.Close(); err != nil {
+		return report, err
+	}
+
+	report.FirstRecordTime, report.LastRecordTime = timeRange.first, timeRange.last
+	log.Infof(context, "Validated %s content [%s]: %s", format.Name, filename, report)
+	return report, nil
+}
+
+// recordTimeRange keeps track of the time of the earliest and latest records written to the discarders
+type recordTimeRange struct {
+	first time.Time
+	last  time.Time
+}
+
+func (timeRange *recordTimeRange) add(recordTime time.Time) {
+	if timeRange.first.IsZero() || recordTime.Before(timeRange.first) {
+		timeRange.first = recordTime
+	}
+
+	if recordTime.After(timeRange.last) {
+		timeRange.last = recordTime
+	}
+}
+
+// glucoseReadDiscarder drops the reads written to it, only keeping track of their time range
+type glucoseReadDiscarder struct {
+	timeRange *recordTimeRange
+}
+
+func (w *glucoseReadDiscarder) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (glukitio.GlucoseReadBatchWriter, error) {
+	for _, read := range p {
+		w.timeRange.add(read.GetTime())
+	}
+
+	return w, nil
+}
+
+func (w *glucoseReadDiscarder) WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (glukitio.GlucoseReadBatchWriter, error) {
+	for _, day := range p {
+		w.WriteGlucoseReadBatch(day.Reads)
+	}
+
+	return w, nil
+}
+
+func (w *glucoseReadDiscarder) Flush() (glukitio.GlucoseReadBatchWriter, error) {
+	return w, nil
+}
+
+// calibrationDiscarder drops the calibrations written to it, only keeping track of their time range
+type calibrationDiscarder struct {
+	timeRange *recordTimeRange
+}
+
+func (w *calibrationDiscarder) WriteCalibrationBatch(p []apimodel.CalibrationRead) (glukitio.CalibrationBatchWriter, error) {
+	for _, calibration := range p {
+		w.timeRange.add(calibration.GetTime())
+	}
+
+	return w, nil
+}
+
+func (w *calibrationDiscarder) WriteCalibrationBatches(p []apimodel.DayOfCalibrationReads) (glukitio.CalibrationBatchWriter, error) {
+	for _, day := range p {
+		w.WriteCalibrationBatch(day.Reads)
+	}
+
+	return w, nil
+}
+
+func (w *calibrationDiscarder) Flush() (glukitio.CalibrationBatchWriter, error) {
+	return w, nil
+}
+
+// injectionDiscarder drops the injections written to it, only keeping track of their time range
+type injectionDiscarder struct {
+	timeRange *recordTimeRange
+}
+
+func (w *injectionDiscarder) WriteInjectionBatch(p []apimodel.Injection) (glukitio.InjectionBatchWriter, error) {
+	for _, injection := range p {
+		w.timeRange.add(injection.GetTime())
+	}
+
+	return w, nil
+}
+
+func (w *injectionDiscarder) WriteInjectionBatches(p []apimodel.DayOfInjections) (glukitio.InjectionBatchWriter, error) {
+	for _, day := range p {
+		w.WriteInjectionBatch(day.Injections)
+	}
+
+	return w, nil
+}
+
+func (w *injectionDiscarder) Flush() (glukitio.InjectionBatchWriter, error) {
+	return w, nil
+}
+
+// basalRateDiscarder drops the basal rates written to it, only keeping track of their time range
+type basalRateDiscarder struct {
+	timeRange *recordTimeRange
+}
+
+func (w *basalRateDiscarder) WriteBasalRateBatch(p []apimodel.BasalRate) (glukitio.BasalRateBatchWriter, error) {
+	for _, basalRate := range p {
+		w.timeRange.add(basalRate.GetTime())
+	}
+
+	return w, nil
+}
+
+func (w *basalRateDiscarder) WriteBasalRateBatches(p []apimodel.DayOfBasalRates) (glukitio.BasalRateBatchWriter, error) {
+	for _, day := range p {
+		w.WriteBasalRateBatch(day.BasalRates)
+	}
+
+	return w, nil
+}
+
+func (w *basalRateDiscarder) Flush() (glukitio.BasalRateBatchWriter, error) {
+	return w, nil
+}
+
+// mealDiscarder drops the meals written to it, only keeping track of their time range
+type mealDiscarder struct {
+	timeRange *recordTimeRange
+}
+
+func (w *mealDiscarder) WriteMealBatch(p []apimodel.Meal) (glukitio.MealBatchWriter, error) {
+	for _, meal := range p {
+		w.timeRange.add(meal.GetTime())
+	}
+
+	return w, nil
+}
+
+func (w *mealDiscarder) WriteMealBatches(p []apimodel.DayOfMeals) (glukitio.MealBatchWriter, error) {
+	for _, day := range p {
+		w.WriteMealBatch(day.Meals)
+	}
+
+	return w, nil
+}
+
+func (w *mealDiscarder) Flush() (glukitio.MealBatchWriter, error) {
+	return w, nil
+}
+
+// exerciseDiscarder drops the exercises written to it, only keeping track of their time range
+type exerciseDiscarder struct {
+	timeRange *recordTimeRange
+}
+
+func (w *exerciseDiscarder) WriteExerciseBatch(p []apimodel.Exercise) (glukitio.ExerciseBatchWriter, error) {
+	for _, exercise := range p {
+		w.timeRange.add(exercise.GetTime())
+	}
+
+	return w, nil
+}
+
+func (w *exerciseDiscarder) WriteExerciseBatches(p []apimodel.DayOfExercises) (glukitio.ExerciseBatchWriter, error) {
+	for _, day := range p {
+		w.WriteExerciseBatch(day.Exercises)
+	}
+
+	return w, nil
+}
+
+func (w *exerciseDiscarder) Flush() (glukitio.ExerciseBatchWriter, error) {
+	return w, nil
+}
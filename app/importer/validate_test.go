@@ -0,0 +1,56 @@
+package importer_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/importer"
+	"google.golang.org/appengine/aetest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateClarityContent(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	report, err := ValidateContent(c, strings.NewReader(clarityFixture), "export.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Format != FORMAT_CLARITY {
+		t.Errorf("Expected format [%s] but got [%s]", FORMAT_CLARITY, report.Format)
+	}
+
+	if report.RecordCount() != 6 {
+		t.Errorf("Expected [6] records but got [%d]", report.RecordCount())
+	}
+
+	location := time.FixedZone("-08:00", -8*60*60)
+	if expected := time.Date(2016, time.January, 14, 22, 0, 0, 0, location); !report.FirstRecordTime.Equal(expected) {
+		t.Errorf("Expected first record time [%s] but got [%s]", expected, report.FirstRecordTime)
+	}
+
+	if expected := time.Date(2016, time.January, 15, 12, 30, 0, 0, location); !report.LastRecordTime.Equal(expected) {
+		t.Errorf("Expected last record time [%s] but got [%s]", expected, report.LastRecordTime)
+	}
+}
+
+func TestValidateMalformedXmlContent(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	report, err := ValidateContent(c, strings.NewReader("<Patient><Glucose"), "export.xml")
+	if err == nil || !IsDataError(err) {
+		t.Errorf("Expected a data error but got [%v]", err)
+	}
+
+	if report.Format != FORMAT_DEXCOM_XML {
+		t.Errorf("Expected format [%s] but got [%s]", FORMAT_DEXCOM_XML, report.Format)
+	}
+}
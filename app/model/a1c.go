@@ -12,13 +12,23 @@ import (
 // the version of the calculation algorithm used to calculate a given estimate
 // It is used to discard/recalculate older versions of glukit
 // scores in the eventuality where we change how we calculate the internal
-// estimation.
+// estimation. The formula and read count keep track of the inputs that produced the estimate. Estimates holds
+// the values of all formulas the estimate was calculated with, the primary one included.
 type A1CEstimate struct {
-	Value          float64   `datastore:"value"`
-	LowerBound     time.Time `datastore:"lowerBound"`
-	UpperBound     time.Time `datastore:"upperBound"`
-	CalculatedOn   time.Time `datastore:"calculatedOn"`
-	ScoringVersion int       `datastore:"scoringVersion`
+	Value          float64           `datastore:"value"`
+	LowerBound     time.Time         `datastore:"lowerBound"`
+	UpperBound     time.Time         `datastore:"upperBound"`
+	CalculatedOn   time.Time         `datastore:"calculatedOn"`
+	ScoringVersion int               `datastore:"scoringVersion`
+	Formula        string            `datastore:"formula,noindex"`
+	ReadCount      int               `datastore:"readCount,noindex"`
+	Estimates      []FormulaEstimate `datastore:"estimates,noindex"`
+}
+
+// FormulaEstimate is the value of an a1c estimate according to a given formula
+type FormulaEstimate struct {
+	Formula string  `datastore:"formula,noindex"`
+	Value   float64 `datastore:"value,noindex"`
 }
 
 const (
@@ -27,3 +37,15 @@ const (
 
 // "Dynamic" constants, those should never be updated
 var UNDEFINED_A1C_ESTIMATE = A1CEstimate{Value: UNDEFINED_A1C_VALUE, LowerBound: util.GLUKIT_EPOCH_TIME, UpperBound: util.GLUKIT_EPOCH_TIME, CalculatedOn: util.GLUKIT_EPOCH_TIME, ScoringVersion: -1}
+
+// Range returns the lowest and highest values of the estimate across all of its formulas. Estimates calculated before
+// formulas were kept side by side have a range of their single value.
+func (a1c A1CEstimate) Range() (low, high float64) {
+	low, high = a1c.Value, a1c.Value
+	for _, estimate := range a1c.Estimates {
+		low = math.Min(low, estimate.Value)
+		high = math.Max(high, estimate.Value)
+	}
+
+	return low, high
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/alexandre-normand/glukit/lib/goauth2/oauth"
+	"google.golang.org/appengine/datastore"
+	"time"
+)
+
+// Links of accounts are confirmed with a token of ACCOUNT_LINK_TOKEN_BYTES random bytes in hex, only the hash of which
+// is stored
+const ACCOUNT_LINK_TOKEN_BYTES = 16
+
+// Represents a Google account linked to the GlukitUser of PrimaryEmail. Signing in with the account gets the user to
+// the primary profile and data files of its Google Drive are imported under it, with the token of the account.
+type AccountAlias struct {
+	Email        string      `datastore:"email,noindex" json:"email"`
+	PrimaryEmail string      `datastore:"primaryEmail" json:"primaryEmail"`
+	Token        oauth.Token `datastore:"token,noindex" json:"-"`
+	LinkedOn     time.Time   `datastore:"linkedOn,noindex" json:"linkedOn"`
+}
+
+// storedAccountAlias has the fields of AccountAlias without its Load and Save methods
+type storedAccountAlias AccountAlias
+
+// Load implements datastore.PropertyLoadSaver. A token that can't be decrypted is dropped, the account has to be linked
+// again for its Google Drive to be searched.
+func (alias *AccountAlias) Load(properties []datastore.Property) error {
+	if err := datastore.LoadStruct((*storedAccountAlias)(alias), properties); err != nil {
+		return err
+	}
+
+	if token, err := openToken("linked account", alias.Token); err != nil {
+		alias.Token = oauth.Token{}
+	} else {
+		alias.Token = token
+	}
+
+	return nil
+}
+
+// Save implements datastore.PropertyLoadSaver. The token is encrypted with TokenKeyRing.
+func (alias *AccountAlias) Save() (properties []datastore.Property, err error) {
+	stored := storedAccountAlias(*alias)
+	if stored.Token, err = sealToken(alias.Token); err != nil {
+		return nil, err
+	}
+
+	return datastore.SaveStruct(&stored)
+}
+
+// HasToken returns true if the alias has a token to search the Google Drive of the account with
+func (alias AccountAlias) HasToken() bool {
+	return len(alias.Token.AccessToken) > 0 || len(alias.Token.RefreshToken) > 0
+}
+
+// Represents the request of a user to link another account to their profile, pending until the user signs in with the
+// other account and confirms it with the token before ExpiresAt. Users have at most one pending request, a new one
+// replacing it.
+type AccountLinkRequest struct {
+	TokenHash   string    `datastore:"tokenHash,noindex"`
+	RequestedOn time.Time `datastore:"requestedOn,noindex"`
+	ExpiresAt   time.Time `datastore:"expiresAt,noindex"`
+}
+
+// NewAccountLinkRequest generates a new random token and returns it along with the AccountLinkRequest to store for it,
+// valid for the given duration
+func NewAccountLinkRequest(now time.Time, validity time.Duration) (token string, request AccountLinkRequest, err error) {
+	secret := make([]byte, ACCOUNT_LINK_TOKEN_BYTES)
+	if _, err := rand.Read(secret); err != nil {
+		return "", request, err
+	}
+
+	token = hex.EncodeToString(secret)
+	return token, AccountLinkRequest{hashAccountLinkToken(token), now, now.Add(validity)}, nil
+}
+
+// IsConfirmedBy returns true if the token is the one of the request and the request hasn't expired as of now
+func (request AccountLinkRequest) IsConfirmedBy(token string, now time.Time) bool {
+	if !now.Before(request.ExpiresAt) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(request.TokenHash), []byte(hashAccountLinkToken(token))) == 1
+}
+
+func hashAccountLinkToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+)
+
+// Deletions of accounts are confirmed with a token of ACCOUNT_DELETION_TOKEN_BYTES random bytes in hex, only the hash of
+// which is stored
+const ACCOUNT_DELETION_TOKEN_BYTES = 16
+
+// Represents the request of a user to delete their account, pending until it's confirmed with the token sent to the
+// user before ExpiresAt. Users have at most one pending request, a new one replacing it.
+type AccountDeletionRequest struct {
+	TokenHash   string    `datastore:"tokenHash,noindex"`
+	RequestedOn time.Time `datastore:"requestedOn,noindex"`
+	ExpiresAt   time.Time `datastore:"expiresAt,noindex"`
+}
+
+// NewAccountDeletionRequest generates a new random token and returns it along with the AccountDeletionRequest to store
+// for it, valid for the given duration
+func NewAccountDeletionRequest(now time.Time, validity time.Duration) (token string, request AccountDeletionRequest, err error) {
+	secret := make([]byte, ACCOUNT_DELETION_TOKEN_BYTES)
+	if _, err := rand.Read(secret); err != nil {
+		return "", request, err
+	}
+
+	token = hex.EncodeToString(secret)
+	return token, AccountDeletionRequest{hashAccountDeletionToken(token), now, now.Add(validity)}, nil
+}
+
+// IsConfirmedBy returns true if the token is the one of the request and the request hasn't expired as of now
+func (request AccountDeletionRequest) IsConfirmedBy(token string, now time.Time) bool {
+	if !now.Before(request.ExpiresAt) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(request.TokenHash), []byte(hashAccountDeletionToken(token))) == 1
+}
+
+func hashAccountDeletionToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountDeletionRequestIsConfirmedByItsTokenOnly(t *testing.T) {
+	now := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	token, request, err := NewAccountDeletionRequest(now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if request.TokenHash == token || len(token) != 2*ACCOUNT_DELETION_TOKEN_BYTES {
+		t.Errorf("Expected a token of [%d] hex characters stored as a hash but got [%s] stored as [%s]",
+			2*ACCOUNT_DELETION_TOKEN_BYTES, token, request.TokenHash)
+	}
+
+	if !request.IsConfirmedBy(token, now.Add(time.Minute)) {
+		t.Errorf("Expected request to be confirmed by its token")
+	}
+
+	if request.IsConfirmedBy(token+"0", now.Add(time.Minute)) {
+		t.Errorf("Expected request not to be confirmed by another token")
+	}
+}
+
+func TestAccountDeletionRequestExpires(t *testing.T) {
+	now := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	token, request, err := NewAccountDeletionRequest(now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if request.IsConfirmedBy(token, now.Add(time.Hour)) {
+		t.Errorf("Expected request not to be confirmed once expired at [%s]", request.ExpiresAt)
+	}
+}
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+)
+
+// AGP is an Ambulatory Glucose Profile: the percentiles of glucose values, in mg/dL, for every time of day bucket
+// over a period. Buckets are in local time of day.
+type AGP struct {
+	LowerBound    time.Time   `json:"lowerBound"`
+	UpperBound    time.Time   `json:"upperBound"`
+	BucketMinutes int         `json:"bucketMinutes"`
+	Buckets       []AGPBucket `json:"buckets"`
+}
+
+// AGPBucket holds the percentiles of a time of day bucket of an AGP. Buckets with too few samples for their
+// percentiles to be meaningful are flagged as insufficient; their percentiles are those of the samples they have,
+// if any.
+type AGPBucket struct {
+	StartMinute  int     `json:"startMinute"`
+	SampleCount  int     `json:"sampleCount"`
+	Insufficient bool    `json:"insufficient"`
+	P10          float64 `json:"p10"`
+	P25          float64 `json:"p25"`
+	Median       float64 `json:"median"`
+	P75          float64 `json:"p75"`
+	P90          float64 `json:"p90"`
+}
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// User ids of exported records are hashed with a salt of ANALYTICS_SALT_BYTES random bytes in hex
+	ANALYTICS_SALT_BYTES = 32
+)
+
+// AnalyticsExportSettings holds the runtime settings of the export of anonymized records to BigQuery. Disabled is the
+// kill switch of the export, checked by every import and every insert. Salt is generated once and kept so that the
+// hashed user ids of all exported records match.
+type AnalyticsExportSettings struct {
+	Disabled  bool      `datastore:"disabled,noindex"`
+	Salt      string    `datastore:"salt,noindex"`
+	UpdatedOn time.Time `datastore:"updatedOn,noindex"`
+}
+
+// NewAnalyticsExportSettings returns enabled settings with a new salt
+func NewAnalyticsExportSettings(now time.Time) (settings AnalyticsExportSettings, err error) {
+	salt := make([]byte, ANALYTICS_SALT_BYTES)
+	if _, err := rand.Read(salt); err != nil {
+		return settings, err
+	}
+
+	return AnalyticsExportSettings{false, hex.EncodeToString(salt), now}, nil
+}
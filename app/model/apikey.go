@@ -0,0 +1,84 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Scopes of the access granted by an api key
+const (
+	API_KEY_SCOPE_READ_GLUCOSE = "read:glucose"
+	API_KEY_SCOPE_READ_EVENTS  = "read:events"
+)
+
+// Known scopes of api keys, in the order they are offered to users
+var ApiKeyScopes = []string{API_KEY_SCOPE_READ_GLUCOSE, API_KEY_SCOPE_READ_EVENTS}
+
+const (
+	// Api keys start with API_KEY_PREFIX, followed by API_KEY_SECRET_BYTES random bytes in hex. The first
+	// API_KEY_DISPLAYED_LENGTH characters of a key are kept so that users can tell their keys apart.
+	API_KEY_PREFIX           = "gk_"
+	API_KEY_SECRET_BYTES     = 24
+	API_KEY_DISPLAYED_LENGTH = 10
+
+	// The last use of a key is recorded at most once per API_KEY_USE_RECORDING_INTERVAL
+	API_KEY_USE_RECORDING_INTERVAL = time.Duration(1) * time.Hour
+)
+
+// Represents a key a user created to give a third-party access to its data without its Google session. Only the
+// hash of the key is stored, the key itself being shown once when created.
+type ApiKey struct {
+	Hash       string    `datastore:"hash" json:"-"`
+	Prefix     string    `datastore:"prefix,noindex" json:"prefix"`
+	Label      string    `datastore:"label,noindex" json:"label"`
+	Scopes     []string  `datastore:"scopes,noindex" json:"scopes"`
+	CreatedAt  time.Time `datastore:"createdAt" json:"createdAt"`
+	LastUsedAt time.Time `datastore:"lastUsedAt,noindex" json:"lastUsedAt"`
+}
+
+// NewApiKey generates a new random key and returns it along with the ApiKey to store for it
+func NewApiKey(label string, scopes []string, now time.Time) (key string, apiKey ApiKey, err error) {
+	secret := make([]byte, API_KEY_SECRET_BYTES)
+	if _, err := rand.Read(secret); err != nil {
+		return "", apiKey, err
+	}
+
+	key = API_KEY_PREFIX + hex.EncodeToString(secret)
+	return key, ApiKey{Hash: HashApiKey(key), Prefix: key[:API_KEY_DISPLAYED_LENGTH], Label: label, Scopes: scopes, CreatedAt: now}, nil
+}
+
+// IsApiKey returns true if the value has the form of an api key, as opposed to an oauth access token
+func IsApiKey(value string) bool {
+	return strings.HasPrefix(value, API_KEY_PREFIX)
+}
+
+// HashApiKey returns the hash an api key is stored and looked up with
+func HashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// ParseApiKeyScope returns the scope of the given value or an error if it isn't a known scope
+func ParseApiKeyScope(value string) (scope string, err error) {
+	return parseEnumValue("api key scope", value, ApiKeyScopes)
+}
+
+// HasScope returns true if the key grants the given scope
+func (apiKey ApiKey) HasScope(scope string) bool {
+	for _, granted := range apiKey.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsUseRecordingDue returns true if the last use of the key wasn't recorded in the API_KEY_USE_RECORDING_INTERVAL
+// before now
+func (apiKey ApiKey) IsUseRecordingDue(now time.Time) bool {
+	return now.Sub(apiKey.LastUsedAt) >= API_KEY_USE_RECORDING_INTERVAL
+}
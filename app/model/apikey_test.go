@@ -0,0 +1,76 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewApiKeyIsStoredByHashOnly(t *testing.T) {
+	now := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	key, apiKey, err := NewApiKey("reports", []string{API_KEY_SCOPE_READ_GLUCOSE}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsApiKey(key) || len(key) != len(API_KEY_PREFIX)+2*API_KEY_SECRET_BYTES {
+		t.Errorf("Expected a key starting with [%s] followed by [%d] hex characters but got [%s]", API_KEY_PREFIX,
+			2*API_KEY_SECRET_BYTES, key)
+	}
+
+	if apiKey.Hash != HashApiKey(key) || strings.Contains(apiKey.Hash, key[len(API_KEY_PREFIX):]) {
+		t.Errorf("Expected api key to be stored with the hash of [%s] but got [%s]", apiKey.Prefix, apiKey.Hash)
+	}
+
+	if apiKey.Prefix != key[:API_KEY_DISPLAYED_LENGTH] || !apiKey.CreatedAt.Equal(now) || !apiKey.LastUsedAt.IsZero() {
+		t.Errorf("Expected api key with prefix [%s] created at [%s] and never used but got [%v]",
+			key[:API_KEY_DISPLAYED_LENGTH], now, apiKey)
+	}
+
+	other, _, err := NewApiKey("reports", []string{API_KEY_SCOPE_READ_GLUCOSE}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other == key || HashApiKey(other) == apiKey.Hash {
+		t.Errorf("Expected keys to be random but got [%s] twice", key)
+	}
+}
+
+func TestHashApiKeyIsStable(t *testing.T) {
+	if HashApiKey("gk_0123456789") != HashApiKey("gk_0123456789") {
+		t.Errorf("Expected the hash of a key to be the same every time")
+	}
+
+	if HashApiKey("gk_0123456789") == HashApiKey("gk_0123456788") {
+		t.Errorf("Expected different keys to have different hashes")
+	}
+}
+
+func TestApiKeyScopes(t *testing.T) {
+	apiKey := ApiKey{Scopes: []string{API_KEY_SCOPE_READ_GLUCOSE}}
+	if !apiKey.HasScope(API_KEY_SCOPE_READ_GLUCOSE) {
+		t.Errorf("Expected api key to grant the [%s] scope", API_KEY_SCOPE_READ_GLUCOSE)
+	}
+
+	if apiKey.HasScope(API_KEY_SCOPE_READ_EVENTS) {
+		t.Errorf("Expected api key not to grant the [%s] scope", API_KEY_SCOPE_READ_EVENTS)
+	}
+
+	if _, err := ParseApiKeyScope("write:events"); err == nil {
+		t.Errorf("Expected [write:events] not to be a scope of api keys")
+	}
+}
+
+func TestApiKeyUseRecordingIsDueHourly(t *testing.T) {
+	lastUsedAt := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	apiKey := ApiKey{LastUsedAt: lastUsedAt}
+
+	if apiKey.IsUseRecordingDue(lastUsedAt.Add(API_KEY_USE_RECORDING_INTERVAL - time.Minute)) {
+		t.Errorf("Expected use recording not to be due within [%s] of the last use", API_KEY_USE_RECORDING_INTERVAL)
+	}
+
+	if !apiKey.IsUseRecordingDue(lastUsedAt.Add(API_KEY_USE_RECORDING_INTERVAL)) {
+		t.Errorf("Expected use recording to be due [%s] after the last use", API_KEY_USE_RECORDING_INTERVAL)
+	}
+}
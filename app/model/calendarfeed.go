@@ -0,0 +1,23 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+const (
+	// Tokens of calendar feeds are signed with a secret of CALENDAR_FEED_SECRET_BYTES random bytes in hex
+	CALENDAR_FEED_SECRET_BYTES = 32
+)
+
+// ResetCalendarFeedSecret generates a new secret for the user's calendar feed so that tokens signed with the previous
+// one, if any, stop working
+func (user *GlukitUser) ResetCalendarFeedSecret() error {
+	secret := make([]byte, CALENDAR_FEED_SECRET_BYTES)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+
+	user.CalendarFeedSecret = hex.EncodeToString(secret)
+	return nil
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+)
+
+// ScoreCheckpoint records the progress of a task of batch calculation of GlukitScores so that a retry of the task
+// resumes where it left off rather than starting over. The task is identified by its lower bound and ScoredUpTo is the
+// end of the last period whose score is stored.
+type ScoreCheckpoint struct {
+	TaskLowerBound time.Time `datastore:"taskLowerBound,noindex"`
+	ScoredUpTo     time.Time `datastore:"scoredUpTo,noindex"`
+	UpdatedOn      time.Time `datastore:"updatedOn,noindex"`
+}
+
+// ResumePoint returns the time from which the task of the given lower bound scores periods. It's the end of the last period
+// scored if the checkpoint is the one of that task and the lower bound otherwise, a nil checkpoint included.
+func (checkpoint *ScoreCheckpoint) ResumePoint(taskLowerBound time.Time) time.Time {
+	if checkpoint != nil && checkpoint.TaskLowerBound.Equal(taskLowerBound) && checkpoint.ScoredUpTo.After(taskLowerBound) {
+		return checkpoint.ScoredUpTo
+	}
+
+	return taskLowerBound
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScoringResumesFromTheCheckpointOfTheSameTask(t *testing.T) {
+	lowerBound := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	checkpoint := &ScoreCheckpoint{TaskLowerBound: lowerBound, ScoredUpTo: lowerBound.AddDate(0, 0, 21)}
+
+	if resumePoint := checkpoint.ResumePoint(lowerBound); !resumePoint.Equal(checkpoint.ScoredUpTo) {
+		t.Errorf("Expected a retry of the task to resume from [%s] but got [%s]", checkpoint.ScoredUpTo, resumePoint)
+	}
+
+	otherLowerBound := lowerBound.AddDate(0, 0, 7)
+	if resumePoint := checkpoint.ResumePoint(otherLowerBound); !resumePoint.Equal(otherLowerBound) {
+		t.Errorf("Expected another task to start from its lower bound [%s] but got [%s]", otherLowerBound, resumePoint)
+	}
+
+	var none *ScoreCheckpoint
+	if resumePoint := none.ResumePoint(lowerBound); !resumePoint.Equal(lowerBound) {
+		t.Errorf("Expected a task without checkpoint to start from its lower bound [%s] but got [%s]", lowerBound, resumePoint)
+	}
+}
@@ -0,0 +1,24 @@
+package model
+
+// Number of days a clinic report can cover, the first being the default
+var ClinicReportDays = []int{14, 30, 90}
+
+// ClinicReport is the printable summary of a period of a user's data brought to clinic visits. Glucose values are in
+// mg/dL. Days are the summaries of each day of the period in the user's timezone, oldest first.
+type ClinicReport struct {
+	Period  TimeRange           `json:"period"`
+	Summary ClinicReportSummary `json:"summary"`
+	AGP     *AGP                `json:"agp"`
+	Days    []DaySummary        `json:"days"`
+}
+
+// ClinicReportSummary holds the statistics of the whole period of a clinic report. GMI is the Glucose Management
+// Indicator, in percent, and Coverage the share of the period covered by reads.
+type ClinicReportSummary struct {
+	ReadCount   int         `json:"readCount"`
+	Coverage    float64     `json:"coverage"`
+	MeanGlucose float64     `json:"meanGlucose"`
+	GMI         float64     `json:"gmi"`
+	TimeInRange TimeInRange `json:"timeInRange"`
+	HypoCount   int         `json:"hypoCount"`
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+)
+
+// PeriodSummary holds the aggregates of a period used to compare it with another one, in mg/dL. Score is the user-facing
+// GlukitScore of the last scoring period ending within the period and is nil if there's none. Coverage is the share,
+// in percent, of the reads expected over the period that were found. FromHistory is true if the aggregates come
+// from stored stats rather than from the reads themselves.
+type PeriodSummary struct {
+	Period        TimeRange `json:"period"`
+	Score         *int64    `json:"score"`
+	MeanGlucose   float64   `json:"meanGlucose"`
+	TimeInRange   float64   `json:"timeInRange"`
+	GVI           float64   `json:"gvi"`
+	ReadCount     int       `json:"readCount"`
+	Coverage      float64   `json:"coverage"`
+	LowConfidence bool      `json:"lowConfidence"`
+	FromHistory   bool      `json:"fromHistory"`
+}
+
+// PeriodDeltas are the changes from one period to another. Score is nil unless both periods have a score.
+type PeriodDeltas struct {
+	Score       *int64  `json:"score"`
+	MeanGlucose float64 `json:"meanGlucose"`
+	TimeInRange float64 `json:"timeInRange"`
+	GVI         float64 `json:"gvi"`
+	Coverage    float64 `json:"coverage"`
+}
+
+// PeriodComparison compares period B with period A, deltas being the changes from A to B. Unit is the unit of the
+// glucose values of the comparison.
+type PeriodComparison struct {
+	A      PeriodSummary        `json:"a"`
+	B      PeriodSummary        `json:"b"`
+	Deltas PeriodDeltas         `json:"deltas"`
+	Unit   apimodel.GlucoseUnit `json:"unit"`
+}
+
+// In returns a copy of the comparison with its glucose values in unit
+func (comparison PeriodComparison) In(unit apimodel.GlucoseUnit) PeriodComparison {
+	comparison.A.MeanGlucose = float64(apimodel.GlucoseValue(comparison.A.MeanGlucose).In(unit))
+	comparison.B.MeanGlucose = float64(apimodel.GlucoseValue(comparison.B.MeanGlucose).In(unit))
+	comparison.Deltas.MeanGlucose = float64(apimodel.GlucoseValue(comparison.Deltas.MeanGlucose).In(unit))
+	comparison.Unit = unit
+
+	return comparison
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"testing"
+)
+
+func TestPeriodComparisonInMmolPerL(t *testing.T) {
+	comparison := PeriodComparison{A: PeriodSummary{MeanGlucose: 180.182, TimeInRange: 60},
+		B: PeriodSummary{MeanGlucose: 90.091, TimeInRange: 75}, Deltas: PeriodDeltas{MeanGlucose: -90.091, TimeInRange: 15},
+		Unit: apimodel.MG_PER_DL}
+
+	converted := comparison.In(apimodel.MMOL_PER_L)
+	if converted.Unit != apimodel.MMOL_PER_L {
+		t.Errorf("Expected comparison in [%s] but got [%s]", apimodel.MMOL_PER_L, converted.Unit)
+	}
+
+	if !closeTo(converted.A.MeanGlucose, 10) || !closeTo(converted.B.MeanGlucose, 5) || !closeTo(converted.Deltas.MeanGlucose, -5) {
+		t.Errorf("Expected means of [10] and [5] mmol/L with a delta of [-5] but got [%v]", converted)
+	}
+
+	if converted.Deltas.TimeInRange != 15 || comparison.A.MeanGlucose != 180.182 {
+		t.Errorf("Expected only glucose values of a copy to be converted but got [%v] from [%v]", converted, comparison)
+	}
+}
+
+func closeTo(value, expected float64) bool {
+	return value-expected < 0.01 && expected-value < 0.01
+}
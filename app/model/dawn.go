@@ -0,0 +1,34 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// DawnAnalysis is the result of looking for the dawn phenomenon, a rise of glucose in the early morning, over the
+// mornings between LowerBound and UpperBound. Mornings with carbs or insulin logged are Excluded. Magnitude is the mean
+// rise, in mg/dL, of the mornings with a rise and Onset is the mean time, from local midnight, at which they started.
+// Frequency is the share, in percent, of analyzed mornings with a rise.
+type DawnAnalysis struct {
+	LowerBound   time.Time     `json:"lowerBound" datastore:"lowerBound,noindex"`
+	UpperBound   time.Time     `json:"upperBound" datastore:"upperBound,noindex"`
+	Analyzed     int           `json:"analyzed" datastore:"analyzed,noindex"`
+	Excluded     int           `json:"excluded" datastore:"excluded,noindex"`
+	RiseCount    int           `json:"riseCount" datastore:"riseCount,noindex"`
+	Frequency    float64       `json:"frequency" datastore:"frequency,noindex"`
+	Magnitude    float64       `json:"magnitude" datastore:"magnitude,noindex"`
+	Onset        time.Duration `json:"onset" datastore:"onset,noindex"`
+	Consistent   bool          `json:"consistent" datastore:"consistent,noindex"`
+	CalculatedOn time.Time     `json:"calculatedOn" datastore:"calculatedOn"`
+}
+
+// Insight describes the dawn rise for the insights page
+func (analysis DawnAnalysis) Insight() string {
+	if !analysis.Consistent {
+		return "No consistent dawn rise"
+	}
+
+	onset := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC).Add(analysis.Onset)
+	return fmt.Sprintf("You rise ~%.0f mg/dL on %.0f%% of mornings starting around %s", analysis.Magnitude, analysis.Frequency,
+		onset.Format("3:04pm"))
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+)
+
+// DaySummary holds the aggregates of the reads of a calendar day in the user's timezone, in mg/dL. Date is the local
+// midnight starting the day. Coverage is the share, in percent, of the day covered by reads and Score ranks days
+// by time in range and variability, higher being better.
+type DaySummary struct {
+	Date         time.Time `json:"date" datastore:"date"`
+	ReadCount    int       `json:"readCount" datastore:"readCount,noindex"`
+	Coverage     float64   `json:"coverage" datastore:"coverage,noindex"`
+	MeanGlucose  float64   `json:"meanGlucose" datastore:"meanGlucose,noindex"`
+	TimeInRange  float64   `json:"timeInRange" datastore:"timeInRange,noindex"`
+	GVI          float64   `json:"gvi" datastore:"gvi,noindex"`
+	Score        float64   `json:"score" datastore:"score,noindex"`
+	CalculatedOn time.Time `json:"calculatedOn" datastore:"calculatedOn,noindex"`
+}
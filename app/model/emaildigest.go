@@ -0,0 +1,36 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// Unsubscribe links of email digests are signed with a secret of EMAIL_DIGEST_SECRET_BYTES random bytes in hex
+	EMAIL_DIGEST_SECRET_BYTES = 32
+)
+
+// EmailDigest records the email digest sent to a user for the week starting at WeekStart. It's stored before the email
+// is sent so that a retried task doesn't send the same week twice. NoData is true if the digest was the nudge sent in
+// weeks without data.
+type EmailDigest struct {
+	WeekStart time.Time `datastore:"weekStart,noindex"`
+	NoData    bool      `datastore:"noData,noindex"`
+	SentOn    time.Time `datastore:"sentOn,noindex"`
+}
+
+// SetEmailDigest sets the email digest preferences of the user. The secret of unsubscribe links is generated the first
+// time the digest is enabled and kept afterwards so that links of digests already sent keep working.
+func (user *GlukitUser) SetEmailDigest(enabled bool, noDataNudge bool) error {
+	if enabled && len(user.EmailDigestSecret) == 0 {
+		secret := make([]byte, EMAIL_DIGEST_SECRET_BYTES)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		user.EmailDigestSecret = hex.EncodeToString(secret)
+	}
+
+	user.EmailDigestEnabled, user.EmailDigestNoDataNudge = enabled, noDataNudge
+	return nil
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"time"
+)
+
+// ExerciseImpact is what an exercise session did to glucose, in mg/dL. DeltaDuring is the change from the baseline
+// before the session to the end of the session and DeltaAfter the change from the end of the session to the lowest
+// value of the hours that follow it. Negative deltas are drops.
+type ExerciseImpact struct {
+	StartTime       time.Time                  `json:"startTime" datastore:"startTime"`
+	DurationMinutes int                        `json:"durationInMinutes" datastore:"durationInMinutes,noindex"`
+	Intensity       apimodel.ExerciseIntensity `json:"intensity" datastore:"intensity,noindex"`
+	Baseline        float32                    `json:"baseline" datastore:"baseline,noindex"`
+	AtEnd           float32                    `json:"atEnd" datastore:"atEnd,noindex"`
+	AfterNadir      float32                    `json:"afterNadir" datastore:"afterNadir,noindex"`
+	DeltaDuring     float32                    `json:"deltaDuring" datastore:"deltaDuring,noindex"`
+	DeltaAfter      float32                    `json:"deltaAfter" datastore:"deltaAfter,noindex"`
+	FollowedByHypo  bool                       `json:"followedByHypo" datastore:"followedByHypo,noindex"`
+	ReadCount       int                        `json:"readCount" datastore:"readCount,noindex"`
+}
+
+// ExerciseImpactGroup is the mean impact of the exercise sessions of an intensity and duration category. An empty
+// duration category stands for sessions of any duration.
+type ExerciseImpactGroup struct {
+	Intensity        apimodel.ExerciseIntensity `json:"intensity"`
+	DurationCategory string                     `json:"durationCategory"`
+	SessionCount     int                        `json:"sessionCount"`
+	MeanDeltaDuring  float64                    `json:"meanDeltaDuring"`
+	MeanDeltaAfter   float64                    `json:"meanDeltaAfter"`
+	HypoCount        int                        `json:"hypoCount"`
+}
+
+// ExerciseImpactSummary holds the impacts of the exercise sessions of a period along with their aggregates by intensity
+// and by intensity and duration category
+type ExerciseImpactSummary struct {
+	LowerBound             time.Time             `json:"lowerBound"`
+	UpperBound             time.Time             `json:"upperBound"`
+	Sessions               []ExerciseImpact      `json:"sessions"`
+	ByIntensity            []ExerciseImpactGroup `json:"byIntensity"`
+	ByIntensityAndDuration []ExerciseImpactGroup `json:"byIntensityAndDuration"`
+}
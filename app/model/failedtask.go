@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+)
+
+// Represents a task that failed on its last attempt and won't be retried. The task can be requeued with its Arguments,
+// encoded as JSON, by the function that rebuilds tasks of its Function. RequeuedOn is zero until the task is requeued.
+type FailedTask struct {
+	Function   string    `datastore:"function" json:"function"`
+	Queue      string    `datastore:"queue,noindex" json:"queue"`
+	UserEmail  string    `datastore:"userEmail" json:"userEmail"`
+	Summary    string    `datastore:"summary,noindex" json:"summary"`
+	Error      string    `datastore:"error,noindex" json:"error"`
+	Attempts   int64     `datastore:"attempts,noindex" json:"attempts"`
+	FailedOn   time.Time `datastore:"failedOn" json:"failedOn"`
+	Arguments  string    `datastore:"arguments,noindex" json:"arguments"`
+	RequeuedOn time.Time `datastore:"requeuedOn,noindex" json:"requeuedOn"`
+}
@@ -1,30 +1,250 @@
 package model
 
 import (
+	"errors"
+	"fmt"
 	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/util"
 	"github.com/alexandre-normand/glukit/lib/goauth2/oauth"
+	"google.golang.org/appengine/datastore"
 	"time"
 )
 
 // TODO: Add most recent A1C estimate
 // Represents a GlukitUser profile
 type GlukitUser struct {
-	Email           string               `datastore:"email"`
-	FirstName       string               `datastore:"firstName,noindex"`
-	LastName        string               `datastore:"lastName,noindex"`
-	DateOfBirth     time.Time            `datastore:"birthdate"`
-	DiabetesType    string               `datastore:"diabetesType"`
-	Timezone        string               `datastore:"timezoneId,noindex"`
-	LastUpdated     time.Time            `datastore:"lastUpdated"`
-	MostRecentRead  apimodel.GlucoseRead `datastore:"mostRecentRead"`
-	Token           oauth.Token          `datastore:"token",noindex`
-	RefreshToken    string               `datastore:"refreshToken",noindex`
-	BestScore       GlukitScore          `datastore:"bestScore"`
-	MostRecentScore GlukitScore          `datastore:"mostRecentScore"`
-	Internal        bool                 `datastore:"internal"`
-	PictureUrl      string               `datastore:"pictureUrl,noindex"`
-	AccountCreated  time.Time            `datastore:"joinedOn"`
-	MostRecentA1C   A1CEstimate          `datastore:"mostRecentA1C"`
+	Email               string               `datastore:"email"`
+	FirstName           string               `datastore:"firstName,noindex"`
+	LastName            string               `datastore:"lastName,noindex"`
+	DateOfBirth         time.Time            `datastore:"birthdate"`
+	DiabetesType        string               `datastore:"diabetesType"`
+	Timezone            string               `datastore:"timezoneId,noindex"`
+	LastUpdated         time.Time            `datastore:"lastUpdated"`
+	MostRecentRead      apimodel.GlucoseRead `datastore:"mostRecentRead"`
+	Token               oauth.Token          `datastore:"token",noindex`
+	RefreshToken        string               `datastore:"refreshToken",noindex`
+	BestScore           GlukitScore          `datastore:"bestScore"`
+	MostRecentScore     GlukitScore          `datastore:"mostRecentScore"`
+	Internal            bool                 `datastore:"internal"`
+	PictureUrl          string               `datastore:"pictureUrl,noindex"`
+	AccountCreated      time.Time            `datastore:"joinedOn"`
+	MostRecentA1C       A1CEstimate          `datastore:"mostRecentA1C"`
+	NightscoutUrl       string               `datastore:"nightscoutUrl,noindex"`
+	NightscoutApiSecret string               `datastore:"nightscoutApiSecret,noindex"`
+	DriveFolderId       string               `datastore:"driveFolderId,noindex"`
+	// Target range, in mg/dL, of the user's time in range and hypo detection
+	TargetLow  float32 `datastore:"targetLow,noindex"`
+	TargetHigh float32 `datastore:"targetHigh,noindex"`
+	// Unit glucose values are shown to the user with, values are always stored in mg/dL
+	GlucoseUnit apimodel.GlucoseUnit `datastore:"glucoseUnit,noindex"`
+	// Clinical profile used to compare users with similar ones, a zero DiagnosedDate meaning unknown
+	DiagnosedDate time.Time `datastore:"diagnosedOn"`
+	TherapyMode   string    `datastore:"therapyMode"`
+	// Schedule of the background refreshes of the user's data, a zero RefreshInterval meaning DEFAULT_REFRESH_INTERVAL.
+	// EmptyRefreshes counts the consecutive refreshes that found no new data and NextRefresh is the time of the next
+	// scheduled refresh, zero if none is.
+	RefreshInterval time.Duration `datastore:"refreshInterval,noindex"`
+	RefreshPaused   bool          `datastore:"refreshPaused,noindex"`
+	EmptyRefreshes  int           `datastore:"emptyRefreshes,noindex"`
+	NextRefresh     time.Time     `datastore:"nextRefresh,noindex"`
+	// Set when Google rejected the user's refresh token as revoked or expired, on TokenRevokedOn. Refreshes stop until
+	// the user authorizes access again.
+	TokenRevoked   bool      `datastore:"tokenRevoked,noindex"`
+	TokenRevokedOn time.Time `datastore:"tokenRevokedOn,noindex"`
+	// Opt-in weekly email digest of the user's WeeklySummary. Weeks without data get a nudge if EmailDigestNoDataNudge
+	// is set and no email otherwise. EmailDigestSecret keys the signature of the unsubscribe links of the digests.
+	EmailDigestEnabled     bool   `datastore:"emailDigestEnabled,noindex"`
+	EmailDigestNoDataNudge bool   `datastore:"emailDigestNoDataNudge,noindex"`
+	EmailDigestSecret      string `datastore:"emailDigestSecret,noindex"`
+	// Key of the signature of the tokens of the user's calendar feed, empty until the user gets a feed link. Resetting
+	// it invalidates all links given out before.
+	CalendarFeedSecret string `datastore:"calendarFeedSecret,noindex"`
+	// Opt-in import of the user's Google Fit sessions as exercises. GoogleFitAuthorized is set once the user granted
+	// the Fit scope, sessions are only imported from then on.
+	GoogleFitEnabled    bool `datastore:"googleFitEnabled,noindex"`
+	GoogleFitAuthorized bool `datastore:"googleFitAuthorized,noindex"`
+	// OAuth token of the user's Dropbox, searched for data files along with Google Drive once the user connects it
+	DropboxToken oauth.Token `datastore:"dropboxToken,noindex"`
+	// Why the user's token was flagged as revoked when it wasn't rejected by Google, like a stored token that can't be
+	// decrypted anymore
+	TokenRevocationReason string `datastore:"tokenRevocationReason,noindex"`
+	// Progress of the onboarding of the user, updated as the first refresh searches for and imports data files
+	Onboarding OnboardingState `datastore:"onboarding"`
+}
+
+// GetOnboardingState returns the onboarding state of the user, ready for profiles stored before onboarding was tracked
+func (user GlukitUser) GetOnboardingState() OnboardingState {
+	if user.Onboarding.Step == "" {
+		return OnboardingState{Step: ONBOARDING_READY}
+	}
+
+	return user.Onboarding
+}
+
+// NeedsGoogleFitAuthorization returns true if the user enabled the Google Fit import but hasn't granted the Fit scope yet
+func (user *GlukitUser) NeedsGoogleFitAuthorization() bool {
+	return user.GoogleFitEnabled && !user.GoogleFitAuthorized
+}
+
+// HasDropbox returns true if the user connected Dropbox as a source of data files
+func (user *GlukitUser) HasDropbox() bool {
+	return len(user.DropboxToken.AccessToken) > 0
+}
+
+// HasImportedData returns true if data was ever imported for the user
+func (user *GlukitUser) HasImportedData() bool {
+	return !util.GLUKIT_EPOCH_TIME.Equal(user.MostRecentRead.GetTime())
+}
+
+// SetDefaultTargetRange sets the target range to the default one if the user doesn't have any, as is the case of profiles
+// stored before the target range was configurable
+func (user *GlukitUser) SetDefaultTargetRange() {
+	if user.TargetLow == 0 && user.TargetHigh == 0 {
+		user.TargetLow, user.TargetHigh = apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD, apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD
+	}
+}
+
+// storedGlukitUser has the fields of GlukitUser without its Load and Save methods so that it can be loaded and saved as a
+// plain struct
+type storedGlukitUser GlukitUser
+
+// Load implements datastore.PropertyLoadSaver. Profiles stored before the type of diabetes and therapy mode were asked
+// are loaded with unknown ones so that existing entities don't need to be migrated. Tokens are decrypted, see
+// OpenTokens.
+func (user *GlukitUser) Load(properties []datastore.Property) error {
+	if err := datastore.LoadStruct((*storedGlukitUser)(user), properties); err != nil {
+		if _, mismatch := err.(*datastore.ErrFieldMismatch); !mismatch {
+			return err
+		}
+	}
+
+	user.SetDefaultClinicalProfile()
+	user.OpenTokens(time.Now())
+	return nil
+}
+
+// OpenTokens decrypts the tokens of the user loaded from the datastore, or sealed with WithSealedTokens. A Google token that can't be decrypted is
+// dropped and flagged as revoked on now, with the TokenDecryptionError as reason, so that the user is asked to authorize
+// access again instead of failing every refresh. A Dropbox token that can't be decrypted is dropped and the user has to
+// connect Dropbox again.
+func (user *GlukitUser) OpenTokens(now time.Time) {
+	token, err := openToken("google", user.Token)
+	if err == nil {
+		user.RefreshToken, err = openTokenValue("google refresh", user.RefreshToken)
+	}
+
+	if err != nil {
+		user.Token, user.RefreshToken = oauth.Token{}, ""
+		if !user.TokenRevoked {
+			user.TokenRevoked, user.TokenRevokedOn = true, now
+		}
+		user.TokenRevocationReason = err.Error()
+	} else {
+		user.Token = token
+	}
+
+	if dropboxToken, err := openToken("dropbox", user.DropboxToken); err != nil {
+		user.DropboxToken = oauth.Token{}
+	} else {
+		user.DropboxToken = dropboxToken
+	}
+}
+
+// SetDefaultClinicalProfile sets the type of diabetes and therapy mode to unknown if the user doesn't have any, as is
+// the case of profiles stored before they were asked
+func (user *GlukitUser) SetDefaultClinicalProfile() {
+	if len(user.DiabetesType) == 0 {
+		user.DiabetesType = DIABETES_TYPE_UNKNOWN
+	}
+
+	if len(user.TherapyMode) == 0 {
+		user.TherapyMode = THERAPY_MODE_UNKNOWN
+	}
+}
+
+// Save implements datastore.PropertyLoadSaver. Tokens are encrypted with TokenKeyRing so that they never get stored in
+// plaintext.
+func (user *GlukitUser) Save() (properties []datastore.Property, err error) {
+	sealed, err := user.WithSealedTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	stored := storedGlukitUser(sealed)
+	return datastore.SaveStruct(&stored)
+}
+
+// WithSealedTokens returns a copy of the user with its tokens encrypted with TokenKeyRing, as they are stored, so that
+// the profile can be kept outside of the datastore without its tokens in plaintext. OpenTokens decrypts them back.
+func (user GlukitUser) WithSealedTokens() (sealed GlukitUser, err error) {
+	sealed = user
+	if sealed.Token, err = sealToken(user.Token); err != nil {
+		return sealed, err
+	}
+
+	if sealed.RefreshToken, err = sealTokenValue(user.RefreshToken); err != nil {
+		return sealed, err
+	}
+
+	sealed.DropboxToken, err = sealToken(user.DropboxToken)
+	return sealed, err
+}
+
+// GetGlucoseUnit returns the unit the user wants to see glucose values with, mg/dL for profiles stored before the
+// unit was configurable
+func (user GlukitUser) GetGlucoseUnit() apimodel.GlucoseUnit {
+	if user.GlucoseUnit == apimodel.MMOL_PER_L {
+		return apimodel.MMOL_PER_L
+	}
+
+	return apimodel.MG_PER_DL
+}
+
+// GetRefreshInterval returns the interval the user wants data refreshed at, DEFAULT_REFRESH_INTERVAL for profiles
+// stored before it was configurable
+func (user GlukitUser) GetRefreshInterval() time.Duration {
+	if user.RefreshInterval == 0 {
+		return DEFAULT_REFRESH_INTERVAL
+	}
+
+	return user.RefreshInterval
+}
+
+// IsRefreshBackedOff returns true if the last MAX_EMPTY_REFRESHES refreshes found no new data, in which case data is
+// refreshed at BACKED_OFF_REFRESH_INTERVAL until new data shows up
+func (user GlukitUser) IsRefreshBackedOff() bool {
+	return user.EmptyRefreshes >= MAX_EMPTY_REFRESHES
+}
+
+// NextRefreshInterval returns the time until the next refresh of the user's data
+func (user GlukitUser) NextRefreshInterval() time.Duration {
+	if interval := user.GetRefreshInterval(); !user.IsRefreshBackedOff() || interval > BACKED_OFF_REFRESH_INTERVAL {
+		return interval
+	}
+
+	return BACKED_OFF_REFRESH_INTERVAL
+}
+
+// IsRefreshOverdue returns true if the user's refreshes aren't paused nor stopped by a revoked token and either none is
+// scheduled or the scheduled one should have run more than REFRESH_OVERDUE_GRACE before now, meaning the user fell out
+// of the chain of refreshes
+func (user GlukitUser) IsRefreshOverdue(now time.Time) bool {
+	if user.RefreshPaused || user.TokenRevoked {
+		return false
+	}
+
+	return user.NextRefresh.IsZero() || now.Sub(user.NextRefresh) > REFRESH_OVERDUE_GRACE
+}
+
+// RecordRefresh records the outcome of a refresh of the user's data. Refreshes that found new data restore the
+// configured refresh interval.
+func (user *GlukitUser) RecordRefresh(outcome RefreshOutcome) {
+	switch outcome {
+	case REFRESH_WITH_NEW_DATA:
+		user.EmptyRefreshes = 0
+	case REFRESH_WITHOUT_NEW_DATA:
+		user.EmptyRefreshes++
+	}
 }
 
 // Represents a GlukitScore value, the lower and upper bounds
@@ -40,14 +260,84 @@ type GlukitScore struct {
 	UpperBound     time.Time `datastore:"upperBound"`
 	CalculatedOn   time.Time `datastore:"calculatedOn"`
 	ScoringVersion int       `datastore:"scoringVersion`
+	// Glycemic Variability Index and Patient Glycemic Status of the same reads, zero if they couldn't be calculated
+	GVI float64 `datastore:"gvi,noindex"`
+	PGS float64 `datastore:"pgs,noindex"`
 }
 
+// Bounds, in mg/dL, of a valid target range
+const (
+	MIN_TARGET_GLUCOSE = 40
+	MAX_TARGET_GLUCOSE = 400
+)
+
+// Bounds and defaults of the interval of background refreshes of the user's data
+const (
+	DEFAULT_REFRESH_INTERVAL = time.Duration(24) * time.Hour
+	MIN_REFRESH_INTERVAL     = time.Duration(1) * time.Hour
+	MAX_REFRESH_INTERVAL     = time.Duration(7*24) * time.Hour
+
+	// Refreshes back off to BACKED_OFF_REFRESH_INTERVAL after MAX_EMPTY_REFRESHES consecutive ones found no new data,
+	// as is the case of users who stopped wearing a sensor
+	MAX_EMPTY_REFRESHES         = 30
+	BACKED_OFF_REFRESH_INTERVAL = time.Duration(7*24) * time.Hour
+
+	// A refresh that hasn't run REFRESH_OVERDUE_GRACE after its scheduled time is considered lost, well beyond the
+	// delays of a backed up refresh queue
+	REFRESH_OVERDUE_GRACE = time.Duration(6) * time.Hour
+)
+
+// RefreshOutcome is the outcome of a refresh of the user's data
+type RefreshOutcome int
+
+const (
+	// The refresh didn't search for new data, either because it was skipped or because the search failed
+	REFRESH_NOT_SEARCHED RefreshOutcome = iota
+	REFRESH_WITHOUT_NEW_DATA
+	REFRESH_WITH_NEW_DATA
+)
+
 // Type of diabetes
 const (
-	DIABETES_TYPE_1 = "T1"
-	DIABETES_TYPE_2 = "T2"
+	DIABETES_TYPE_1       = "T1"
+	DIABETES_TYPE_2       = "T2"
+	DIABETES_TYPE_LADA    = "LADA"
+	DIABETES_TYPE_OTHER   = "Other"
+	DIABETES_TYPE_UNKNOWN = "Unknown"
+)
+
+// Therapy mode, how insulin is delivered
+const (
+	THERAPY_MODE_MDI                = "MDI"
+	THERAPY_MODE_PUMP               = "Pump"
+	THERAPY_MODE_HYBRID_CLOSED_LOOP = "HybridClosedLoop"
+	THERAPY_MODE_UNKNOWN            = "Unknown"
 )
 
+// Known types of diabetes and therapy modes, in the order they are offered to users
+var DiabetesTypes = []string{DIABETES_TYPE_1, DIABETES_TYPE_2, DIABETES_TYPE_LADA, DIABETES_TYPE_OTHER, DIABETES_TYPE_UNKNOWN}
+var TherapyModes = []string{THERAPY_MODE_MDI, THERAPY_MODE_PUMP, THERAPY_MODE_HYBRID_CLOSED_LOOP, THERAPY_MODE_UNKNOWN}
+
+// ParseDiabetesType returns the type of diabetes of the given value or an error if it isn't a known type
+func ParseDiabetesType(value string) (diabetesType string, err error) {
+	return parseEnumValue("diabetes type", value, DiabetesTypes)
+}
+
+// ParseTherapyMode returns the therapy mode of the given value or an error if it isn't a known mode
+func ParseTherapyMode(value string) (therapyMode string, err error) {
+	return parseEnumValue("therapy mode", value, TherapyModes)
+}
+
+func parseEnumValue(name string, value string, values []string) (string, error) {
+	for _, known := range values {
+		if known == value {
+			return known, nil
+		}
+	}
+
+	return "", errors.New(fmt.Sprintf("Unknown %s [%s], expected one of %v", name, value, values))
+}
+
 // Compares one GlukitScore with another and returns true if
 // the score is better than the reference score passed in argument
 // i.e. newValue.IsBetterThan(oldValue) would return true if the
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefreshIsOverdueOnceItMissedItsGracePeriod(t *testing.T) {
+	now := time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		user     GlukitUser
+		expected bool
+	}{
+		{GlukitUser{}, true},
+		{GlukitUser{NextRefresh: now.Add(time.Hour)}, false},
+		{GlukitUser{NextRefresh: now.Add(-REFRESH_OVERDUE_GRACE)}, false},
+		{GlukitUser{NextRefresh: now.Add(-REFRESH_OVERDUE_GRACE - time.Minute)}, true},
+		{GlukitUser{RefreshPaused: true}, false},
+	}
+
+	for _, c := range cases {
+		if overdue := c.user.IsRefreshOverdue(now); overdue != c.expected {
+			t.Errorf("Expected refresh scheduled at [%s] with paused [%t] to be overdue [%t] but got [%t]",
+				c.user.NextRefresh, c.user.RefreshPaused, c.expected, overdue)
+		}
+	}
+}
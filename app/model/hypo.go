@@ -0,0 +1,15 @@
+package model
+
+import (
+	"time"
+)
+
+// HypoEvent is a period spent below a low threshold. StartTime and EndTime are the times of the first and last reads
+// below the threshold and Nadir is the lowest value, in mg/dL, reached during the event.
+type HypoEvent struct {
+	StartTime time.Time     `json:"startTime" datastore:"startTime"`
+	EndTime   time.Time     `json:"endTime" datastore:"endTime,noindex"`
+	Nadir     float32       `json:"nadir" datastore:"nadir,noindex"`
+	Duration  time.Duration `json:"duration" datastore:"duration,noindex"`
+	Threshold float32       `json:"threshold" datastore:"threshold,noindex"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+)
+
+// DailyInsulinTotals holds the insulin, in units, delivered over a calendar day in the user's timezone. Date is the
+// local midnight starting the day. Basal is the insulin of long-acting injections and of pump basal rates while Bolus
+// is the insulin of rapid-acting injections and pump boluses. Mixed and unknown insulin can't be split between the
+// two so they're totaled on their own.
+type DailyInsulinTotals struct {
+	Date    time.Time `json:"date"`
+	Basal   float64   `json:"basal"`
+	Bolus   float64   `json:"bolus"`
+	Mixed   float64   `json:"mixed"`
+	Unknown float64   `json:"unknown"`
+}
+
+// Total returns all the insulin delivered over the day
+func (totals DailyInsulinTotals) Total() float64 {
+	return totals.Basal + totals.Bolus + totals.Mixed + totals.Unknown
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+)
+
+// Represents the result of a check of the days of reads of a user having data between LowerBound and UpperBound. The
+// counts cover all the issues found while Issues only describes the first ones of them. RepairedDays is the number of
+// overlapping days that were merged when the check was run with Repair.
+type IntegrityReport struct {
+	UserEmail           string    `datastore:"userEmail" json:"userEmail"`
+	LowerBound          time.Time `datastore:"lowerBound,noindex" json:"lowerBound"`
+	UpperBound          time.Time `datastore:"upperBound,noindex" json:"upperBound"`
+	CheckedOn           time.Time `datastore:"checkedOn" json:"checkedOn"`
+	Repair              bool      `datastore:"repair,noindex" json:"repair"`
+	DaysChecked         int       `datastore:"daysChecked,noindex" json:"daysChecked"`
+	OverlappingDays     int       `datastore:"overlappingDays,noindex" json:"overlappingDays"`
+	DuplicateReads      int       `datastore:"duplicateReads,noindex" json:"duplicateReads"`
+	ReadsOutOfBounds    int       `datastore:"readsOutOfBounds,noindex" json:"readsOutOfBounds"`
+	MostRecentRead      time.Time `datastore:"mostRecentRead,noindex" json:"mostRecentRead"`
+	LatestStoredRead    time.Time `datastore:"latestStoredRead,noindex" json:"latestStoredRead"`
+	StaleMostRecentRead bool      `datastore:"staleMostRecentRead,noindex" json:"staleMostRecentRead"`
+	FileImportsAhead    int       `datastore:"fileImportsAhead,noindex" json:"fileImportsAhead"`
+	RepairedDays        int       `datastore:"repairedDays,noindex" json:"repairedDays"`
+	Issues              []string  `datastore:"issues,noindex" json:"issues"`
+}
+
+// HasIssues returns true if the check found any issue
+func (report IntegrityReport) HasIssues() bool {
+	return report.OverlappingDays > 0 || report.DuplicateReads > 0 || report.ReadsOutOfBounds > 0 ||
+		report.StaleMostRecentRead || report.FileImportsAhead > 0
+}
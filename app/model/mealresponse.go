@@ -0,0 +1,55 @@
+package model
+
+import (
+	"time"
+)
+
+// MealResponse is the glucose excursion following a meal, in mg/dL. Meals eaten shortly after one another can't be told
+// apart and are merged into a single response, MealCount being the number of meals merged and Carbohydrates and Fat
+// their total, in grams. AreaAboveBaseline is the area
+// under the curve above the baseline, in mg/dL·min.
+type MealResponse struct {
+	MealTime          time.Time     `json:"mealTime" datastore:"mealTime"`
+	Carbohydrates     float32       `json:"carbohydrates" datastore:"carbohydrates,noindex"`
+	Fat               float32       `json:"fat" datastore:"fat,noindex"`
+	MealCount         int           `json:"mealCount" datastore:"mealCount,noindex"`
+	Baseline          float32       `json:"baseline" datastore:"baseline,noindex"`
+	Peak              float32       `json:"peak" datastore:"peak,noindex"`
+	Excursion         float32       `json:"excursion" datastore:"excursion,noindex"`
+	TimeToPeak        time.Duration `json:"timeToPeak" datastore:"timeToPeak,noindex"`
+	AreaAboveBaseline float64       `json:"areaAboveBaseline" datastore:"areaAboveBaseline,noindex"`
+	ReadCount         int           `json:"readCount" datastore:"readCount,noindex"`
+}
+
+// IsConfounded returns true if the response is the one of several meals eaten too close to one another to be told apart
+func (response MealResponse) IsConfounded() bool {
+	return response.MealCount > 1
+}
+
+// HourlyMealResponse is the mean response to the meals eaten during an hour of the day, in local time
+type HourlyMealResponse struct {
+	Hour                  int           `json:"hour"`
+	MealCount             int           `json:"mealCount"`
+	MeanExcursion         float64       `json:"meanExcursion"`
+	MeanTimeToPeak        time.Duration `json:"meanTimeToPeak"`
+	MeanAreaAboveBaseline float64       `json:"meanAreaAboveBaseline"`
+}
+
+// FatMealResponse is the mean response to either the high fat meals or the other ones
+type FatMealResponse struct {
+	HighFat               bool          `json:"highFat"`
+	MealCount             int           `json:"mealCount"`
+	MeanExcursion         float64       `json:"meanExcursion"`
+	MeanTimeToPeak        time.Duration `json:"meanTimeToPeak"`
+	MeanAreaAboveBaseline float64       `json:"meanAreaAboveBaseline"`
+}
+
+// MealResponseAnalysis holds the responses to the meals of a period along with their aggregates by hour of the day and
+// by fat content. Hours and fat groups without meals are left out.
+type MealResponseAnalysis struct {
+	LowerBound time.Time            `json:"lowerBound"`
+	UpperBound time.Time            `json:"upperBound"`
+	Responses  []MealResponse       `json:"responses"`
+	ByHour     []HourlyMealResponse `json:"byHour"`
+	ByFat      []FatMealResponse    `json:"byFat"`
+}
@@ -6,6 +6,7 @@ package model
 import (
 	"github.com/alexandre-normand/glukit/app/apimodel"
 	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine/datastore"
 	"math"
 	"time"
 )
@@ -15,6 +16,9 @@ const (
 	EXERCISE_VALUE_FORMAT   = "%d,%s"
 	UNDEFINED_SCORE_VALUE   = int64(math.MaxInt64)
 	DEFAULT_LOOKBACK_PERIOD = time.Duration(-7*24) * time.Hour
+
+	// ImportResult of the file imports that succeeded before their outcome was recorded in FileImportLog.Succeeded
+	LEGACY_FILE_IMPORT_SUCCESS = "Success"
 )
 
 // "Dynamic" constants, those should never be updated
@@ -31,11 +35,45 @@ type FileImportLog struct {
 	Id                string
 	Md5Checksum       string
 	LastDataProcessed time.Time
-	ImportResult      string
+	ImportResult      string `datastore:",noindex"`
+	ImportedAt        time.Time
+	RecordCount       int
+	SourceUnit        apimodel.GlucoseUnit `datastore:",noindex"`
+	Succeeded         bool
+	Attempts          int
+	PermanentlyFailed bool
+}
+
+// storedFileImportLog has the fields of FileImportLog without its Load and Save methods so that it can be loaded and
+// saved as a plain struct
+type storedFileImportLog FileImportLog
+
+// Load implements datastore.PropertyLoadSaver. Logs stored before the outcome of imports was recorded have no Succeeded
+// property, those are loaded as succeeded if their ImportResult is LEGACY_FILE_IMPORT_SUCCESS and as failed otherwise
+// since failed imports were logged with their error as result.
+func (fileImport *FileImportLog) Load(properties []datastore.Property) error {
+	if err := datastore.LoadStruct((*storedFileImportLog)(fileImport), properties); err != nil {
+		return err
+	}
+
+	for _, property := range properties {
+		if property.Name == "Succeeded" {
+			return nil
+		}
+	}
+
+	fileImport.Succeeded = fileImport.ImportResult == LEGACY_FILE_IMPORT_SUCCESS
+	return nil
+}
+
+// Save implements datastore.PropertyLoadSaver
+func (fileImport *FileImportLog) Save() (properties []datastore.Property, err error) {
+	return datastore.SaveStruct((*storedFileImportLog)(fileImport))
 }
 
 type DataStoreDayOfGlucoseReads apimodel.DayOfGlucoseReads
 type DataStoreDayOfCalibrationReads apimodel.DayOfCalibrationReads
 type DataStoreDayOfInjections apimodel.DayOfInjections
+type DataStoreDayOfBasalRates apimodel.DayOfBasalRates
 type DataStoreDayOfExercises apimodel.DayOfExercises
 type DataStoreDayOfMeals apimodel.DayOfMeals
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"google.golang.org/appengine/datastore"
+	"testing"
+)
+
+func TestLegacyFileImportLogIsLoadedAsSucceeded(t *testing.T) {
+	fileImport := FileImportLog{}
+	if err := fileImport.Load([]datastore.Property{datastore.Property{Name: "Id", Value: "legacy"},
+		datastore.Property{Name: "Md5Checksum", Value: "a1b2c3"}, datastore.Property{Name: "ImportResult", Value: "Success"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !fileImport.Succeeded || fileImport.Id != "legacy" {
+		t.Errorf("Expected successful log stored without an outcome to be loaded as succeeded but got [%v]", fileImport)
+	}
+}
+
+func TestLegacyFailedFileImportLogIsLoadedAsFailed(t *testing.T) {
+	fileImport := FileImportLog{}
+	if err := fileImport.Load([]datastore.Property{datastore.Property{Name: "Id", Value: "legacy"},
+		datastore.Property{Name: "Md5Checksum", Value: "a1b2c3"},
+		datastore.Property{Name: "ImportResult", Value: "XML syntax error on line 12: unexpected EOF"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if fileImport.Succeeded {
+		t.Errorf("Expected failed log stored without an outcome to be loaded as failed but got [%v]", fileImport)
+	}
+}
+
+func TestFailedFileImportLogIsLoadedAsFailed(t *testing.T) {
+	fileImport := FileImportLog{}
+	if err := fileImport.Load([]datastore.Property{datastore.Property{Name: "Id", Value: "failed"},
+		datastore.Property{Name: "Succeeded", Value: false}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if fileImport.Succeeded {
+		t.Errorf("Expected failed log to be loaded as failed but got [%v]", fileImport)
+	}
+}
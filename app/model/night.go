@@ -0,0 +1,61 @@
+package model
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"time"
+)
+
+// NightClass is the classification of a night from the lows and highs it had
+type NightClass string
+
+const (
+	NIGHT_GOOD  NightClass = "good"
+	NIGHT_HIGH  NightClass = "high"
+	NIGHT_LOW   NightClass = "low"
+	NIGHT_MIXED NightClass = "mixed"
+)
+
+// Night holds the aggregates of the reads of a night, in mg/dL. Date is the local midnight of the day the night ends
+// on. Coverage is the share, in percent, of the night covered by reads. Nights with too little coverage to be
+// classified are Excluded and left out of aggregates.
+type Night struct {
+	Date          time.Time  `json:"date" datastore:"date"`
+	Start         time.Time  `json:"start" datastore:"start,noindex"`
+	End           time.Time  `json:"end" datastore:"end,noindex"`
+	ReadCount     int        `json:"readCount" datastore:"readCount,noindex"`
+	Coverage      float64    `json:"coverage" datastore:"coverage,noindex"`
+	Mean          float64    `json:"mean" datastore:"mean,noindex"`
+	Min           float32    `json:"min" datastore:"min,noindex"`
+	Max           float32    `json:"max" datastore:"max,noindex"`
+	HadHypo       bool       `json:"hadHypo" datastore:"hadHypo,noindex"`
+	SustainedHigh bool       `json:"sustainedHigh" datastore:"sustainedHigh,noindex"`
+	Class         NightClass `json:"class" datastore:"class,noindex"`
+	Excluded      bool       `json:"excluded" datastore:"excluded,noindex"`
+}
+
+// OvernightSummary counts nights by class. Excluded nights are only counted as such. Unit is the unit of the glucose
+// values of the nights, mg/dL unless the summary was converted with In.
+type OvernightSummary struct {
+	Nights   []Night              `json:"nights"`
+	Good     int                  `json:"good"`
+	High     int                  `json:"high"`
+	Low      int                  `json:"low"`
+	Mixed    int                  `json:"mixed"`
+	Excluded int                  `json:"excluded"`
+	Unit     apimodel.GlucoseUnit `json:"unit"`
+}
+
+// In returns a copy of the summary with the glucose values of its nights in unit
+func (summary OvernightSummary) In(unit apimodel.GlucoseUnit) OvernightSummary {
+	nights := make([]Night, len(summary.Nights))
+	for i, night := range summary.Nights {
+		night.Mean = float64(apimodel.GlucoseValue(night.Mean).In(unit))
+		night.Min = apimodel.GlucoseValue(night.Min).In(unit)
+		night.Max = apimodel.GlucoseValue(night.Max).In(unit)
+		nights[i] = night
+	}
+
+	summary.Nights = nights
+	summary.Unit = unit
+	return summary
+}
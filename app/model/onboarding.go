@@ -0,0 +1,132 @@
+package model
+
+import (
+	"time"
+)
+
+// Steps of the onboarding of a new user, from authorizing access to having data to look at
+const (
+	ONBOARDING_AUTHORIZED = "authorized"
+	ONBOARDING_SEARCHING  = "searching"
+	ONBOARDING_IMPORTING  = "importing"
+	ONBOARDING_SCORING    = "scoring"
+	ONBOARDING_READY      = "ready"
+
+	// A user is ready as soon as an import brought reads spanning ONBOARDING_READY_READS_SPAN, the dashboard showing
+	// the last day of data, even if older files are still being imported
+	ONBOARDING_READY_READS_SPAN = time.Duration(24) * time.Hour
+)
+
+// Represents the progress of the onboarding of a new user. The user is searching for data files once authorized,
+// importing the FilesFound ones, scoring once they are imported and ready once there's data to look at. Failures are
+// counted in FilesFailed, with the most recent one in LastError, so that onboarding moves on without the failed files.
+// Profiles stored before onboarding was tracked have an empty Step and are considered ready.
+type OnboardingState struct {
+	Step          string    `datastore:"step,noindex" json:"step"`
+	FilesFound    int       `datastore:"filesFound,noindex" json:"filesFound"`
+	FilesImported int       `datastore:"filesImported,noindex" json:"filesImported"`
+	FilesFailed   int       `datastore:"filesFailed,noindex" json:"filesFailed"`
+	LastError     string    `datastore:"lastError,noindex" json:"lastError,omitempty"`
+	UpdatedOn     time.Time `datastore:"updatedOn,noindex" json:"updatedOn"`
+}
+
+// NewOnboardingState returns the onboarding state of a user who just authorized access
+func NewOnboardingState(now time.Time) OnboardingState {
+	return OnboardingState{Step: ONBOARDING_AUTHORIZED, UpdatedOn: now}
+}
+
+// IsOnboarding returns true if the user isn't ready yet
+func (state OnboardingState) IsOnboarding() bool {
+	return state.Step != "" && state.Step != ONBOARDING_READY
+}
+
+// StartSearch moves a user who authorized access to searching for data files. A search that failed is started again
+// with its error cleared. It returns true if the state changed.
+func (state *OnboardingState) StartSearch(now time.Time) bool {
+	switch {
+	case state.Step == ONBOARDING_AUTHORIZED:
+		state.Step = ONBOARDING_SEARCHING
+	case state.Step == ONBOARDING_SEARCHING && state.LastError != "":
+		state.LastError = ""
+	default:
+		return false
+	}
+
+	state.UpdatedOn = now
+	return true
+}
+
+// FinishSearch records the number of data files found by the search, and the error of the search if it failed. Finding
+// files moves the user to importing them. A user without any files is ready, there being nothing to import, while a
+// search that failed without finding any stays in the searching step until the next refresh searches again. It returns
+// true if the state changed.
+func (state *OnboardingState) FinishSearch(filesFound int, err error, now time.Time) bool {
+	if state.Step != ONBOARDING_SEARCHING {
+		return false
+	}
+
+	state.FilesFound += filesFound
+	if err != nil {
+		state.LastError = err.Error()
+	}
+
+	if state.FilesFound > 0 {
+		state.Step = ONBOARDING_IMPORTING
+		state.checkImportsDone()
+	} else if err == nil {
+		state.Step = ONBOARDING_READY
+	}
+
+	state.UpdatedOn = now
+	return true
+}
+
+// RecordFileImport records the import of a data file found by the search, failure being why it failed if it wasn't
+// imported. The user is ready right away if the import brought a day of reads. Otherwise, the user moves on to
+// scoring once all files are done if any of them was imported, or to ready with the failures if none was. Imports
+// that finish before the search recorded the files it found count all the same. It returns true if the state changed.
+func (state *OnboardingState) RecordFileImport(imported bool, failure string, dayOfReads bool, now time.Time) bool {
+	if state.Step != ONBOARDING_SEARCHING && state.Step != ONBOARDING_IMPORTING {
+		return false
+	}
+
+	if imported {
+		state.FilesImported++
+	} else {
+		state.FilesFailed++
+		state.LastError = failure
+	}
+
+	if imported && dayOfReads {
+		state.Step = ONBOARDING_READY
+	} else if state.Step == ONBOARDING_IMPORTING {
+		state.checkImportsDone()
+	}
+
+	state.UpdatedOn = now
+	return true
+}
+
+// FinishScoring moves a user whose data was scored to ready. It returns true if the state changed.
+func (state *OnboardingState) FinishScoring(now time.Time) bool {
+	if state.Step != ONBOARDING_SCORING {
+		return false
+	}
+
+	state.Step = ONBOARDING_READY
+	state.UpdatedOn = now
+	return true
+}
+
+// checkImportsDone moves a user whose files were all imported, or failed, to the next step
+func (state *OnboardingState) checkImportsDone() {
+	if state.FilesImported+state.FilesFailed < state.FilesFound {
+		return
+	}
+
+	if state.FilesImported > 0 {
+		state.Step = ONBOARDING_SCORING
+	} else {
+		state.Step = ONBOARDING_READY
+	}
+}
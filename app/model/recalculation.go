@@ -0,0 +1,35 @@
+package model
+
+import (
+	"math"
+	"time"
+)
+
+// Represents a recalculation of all GlukitScores and A1C estimates of a user from a given date. The recalculation
+// doubles as a lock so that a single one runs at a time for a user. A recalculation that isn't renewed before
+// ExpiresOn is considered abandoned.
+type Recalculation struct {
+	From      time.Time `datastore:"from,noindex"`
+	To        time.Time `datastore:"to,noindex"`
+	StartedOn time.Time `datastore:"startedOn,noindex"`
+	ExpiresOn time.Time `datastore:"expiresOn,noindex"`
+}
+
+// IsExpired returns true if the recalculation wasn't renewed before the given time
+func (recalculation Recalculation) IsExpired(now time.Time) bool {
+	return !now.Before(recalculation.ExpiresOn)
+}
+
+// Progress returns the number of days recalculated when reaching upTo along with the total number of days to recalculate
+func (recalculation Recalculation) Progress(upTo time.Time) (days int, totalDays int) {
+	totalDays = int(math.Ceil(recalculation.To.Sub(recalculation.From).Hours() / 24))
+	days = int(upTo.Sub(recalculation.From).Hours() / 24)
+
+	if days < 0 {
+		days = 0
+	} else if days > totalDays {
+		days = totalDays
+	}
+
+	return days, totalDays
+}
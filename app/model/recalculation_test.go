@@ -0,0 +1,34 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecalculationProgressIsWithinItsDays(t *testing.T) {
+	from := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	recalculation := Recalculation{From: from, To: from.AddDate(0, 0, 180)}
+
+	for upTo, expected := range map[time.Time]int{
+		from.AddDate(0, 0, -1):  0,
+		from.AddDate(0, 0, 34):  34,
+		from.AddDate(0, 0, 200): 180,
+	} {
+		if days, totalDays := recalculation.Progress(upTo); days != expected || totalDays != 180 {
+			t.Errorf("Expected progress of [%d/180] days up to [%s] but got [%d/%d]", expected, upTo, days, totalDays)
+		}
+	}
+}
+
+func TestRecalculationIsExpiredOnceNotRenewed(t *testing.T) {
+	now := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	recalculation := Recalculation{StartedOn: now, ExpiresOn: now.Add(time.Duration(10) * time.Minute)}
+
+	if recalculation.IsExpired(now.Add(time.Minute)) {
+		t.Errorf("Expected recalculation [%v] not to be expired a minute after it started", recalculation)
+	}
+
+	if !recalculation.IsExpired(recalculation.ExpiresOn) {
+		t.Errorf("Expected recalculation [%v] to be expired at [%s]", recalculation, recalculation.ExpiresOn)
+	}
+}
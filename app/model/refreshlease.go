@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+)
+
+// Represents the lease held by the refresh of a user's data so that concurrent refreshes of the same user, from
+// duplicate or overlapping tasks, don't both search for and import new data. A lease that isn't released before
+// ExpiresOn is considered abandoned. File imports hold the same kind of lease on one of a few import slots of the user
+// to limit how many of them run at the same time.
+type RefreshLease struct {
+	Holder     string    `datastore:"holder,noindex"`
+	AcquiredOn time.Time `datastore:"acquiredOn,noindex"`
+	ExpiresOn  time.Time `datastore:"expiresOn,noindex"`
+}
+
+// IsExpired returns true if the lease wasn't released before the given time
+func (lease RefreshLease) IsExpired(now time.Time) bool {
+	return !now.Before(lease.ExpiresOn)
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Permissions granted by sharing data with another user. Grants only ever give access to read endpoints.
+const (
+	SHARE_PERMISSION_READ_ONLY = "read-only"
+)
+
+// Known share permissions, in the order they are offered to users
+var SharePermissions = []string{SHARE_PERMISSION_READ_ONLY}
+
+// Invitations are accepted with a token of SHARE_INVITATION_TOKEN_BYTES random bytes in hex, only the hash of which is
+// stored, within SHARE_INVITATION_VALIDITY of their creation
+const (
+	SHARE_INVITATION_TOKEN_BYTES = 24
+	SHARE_INVITATION_VALIDITY    = time.Duration(7*24) * time.Hour
+)
+
+// Represents an invitation of the owner of the data to the user with InviteeEmail to access it with Permission. The
+// grant created once it's accepted expires at ExpiresAt, if set. The invitation itself can only be accepted until
+// ValidUntil.
+type ShareInvitation struct {
+	TokenHash    string    `datastore:"tokenHash" json:"-"`
+	InviteeEmail string    `datastore:"inviteeEmail" json:"inviteeEmail"`
+	Permission   string    `datastore:"permission,noindex" json:"permission"`
+	ExpiresAt    time.Time `datastore:"expiresAt,noindex" json:"expiresAt"`
+	CreatedAt    time.Time `datastore:"createdAt,noindex" json:"createdAt"`
+}
+
+// Represents the access of the user with GranteeEmail to the data of the user with OwnerEmail, until ExpiresAt if set
+type ShareGrant struct {
+	OwnerEmail   string    `datastore:"ownerEmail,noindex" json:"ownerEmail"`
+	GranteeEmail string    `datastore:"granteeEmail" json:"granteeEmail"`
+	Permission   string    `datastore:"permission,noindex" json:"permission"`
+	ExpiresAt    time.Time `datastore:"expiresAt,noindex" json:"expiresAt"`
+	CreatedAt    time.Time `datastore:"createdAt,noindex" json:"createdAt"`
+}
+
+// NewShareInvitation generates a new random token and returns it along with the ShareInvitation to store for it
+func NewShareInvitation(inviteeEmail string, permission string, expiresAt time.Time, now time.Time) (token string, invitation ShareInvitation, err error) {
+	secret := make([]byte, SHARE_INVITATION_TOKEN_BYTES)
+	if _, err := rand.Read(secret); err != nil {
+		return "", invitation, err
+	}
+
+	token = hex.EncodeToString(secret)
+	return token, ShareInvitation{TokenHash: HashShareInvitationToken(token), InviteeEmail: inviteeEmail, Permission: permission,
+		ExpiresAt: expiresAt, CreatedAt: now}, nil
+}
+
+// ValidUntil returns the time until which the invitation can be accepted, SHARE_INVITATION_VALIDITY after its creation
+func (invitation ShareInvitation) ValidUntil() time.Time {
+	return invitation.CreatedAt.Add(SHARE_INVITATION_VALIDITY)
+}
+
+// IsExpired returns true if the invitation can't be accepted anymore as of now, see ValidUntil
+func (invitation ShareInvitation) IsExpired(now time.Time) bool {
+	return !now.Before(invitation.ValidUntil())
+}
+
+// HashShareInvitationToken returns the hash an invitation token is stored and looked up with
+func HashShareInvitationToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// ParseSharePermission returns the share permission of the given value or an error if it isn't a known permission
+func ParseSharePermission(value string) (permission string, err error) {
+	return parseEnumValue("share permission", value, SharePermissions)
+}
+
+// IsExpired returns true if the grant has an expiry that is before now
+func (grant ShareGrant) IsExpired(now time.Time) bool {
+	return !grant.ExpiresAt.IsZero() && !now.Before(grant.ExpiresAt)
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"time"
+)
+
+const (
+	// Snapshots are found by a token of SNAPSHOT_TOKEN_BYTES random bytes in hex. Unlike api keys, the token is kept
+	// so that the owner can get the link of a snapshot again, snapshots being meant to be public.
+	SNAPSHOT_TOKEN_BYTES = 16
+
+	// Snapshots expire after DEFAULT_SNAPSHOT_EXPIRY unless their owner chose otherwise, cover at most
+	// MAX_SNAPSHOT_RANGE and a user can have at most MAX_SNAPSHOTS_PER_USER of them that haven't expired
+	DEFAULT_SNAPSHOT_EXPIRY = time.Duration(30*24) * time.Hour
+	MAX_SNAPSHOT_RANGE      = time.Duration(7*24) * time.Hour
+	MAX_SNAPSHOTS_PER_USER  = 20
+)
+
+// Represents a copy of the data of a user between LowerBound and UpperBound anyone with its token can see until
+// ExpiresAt. Only the data itself is copied, nothing identifying its owner.
+type Snapshot struct {
+	Token       string               `datastore:"token" json:"token"`
+	Label       string               `datastore:"label,noindex" json:"label"`
+	LowerBound  time.Time            `datastore:"lowerBound,noindex" json:"from"`
+	UpperBound  time.Time            `datastore:"upperBound,noindex" json:"to"`
+	GlucoseUnit apimodel.GlucoseUnit `datastore:"glucoseUnit,noindex" json:"glucoseUnit"`
+	Content     []byte               `datastore:"content,noindex" json:"-"`
+	CreatedAt   time.Time            `datastore:"createdAt" json:"createdAt"`
+	ExpiresAt   time.Time            `datastore:"expiresAt,noindex" json:"expiresAt"`
+}
+
+// SnapshotContent is the data copied in a snapshot, stored as json in its Content
+type SnapshotContent struct {
+	Reads      []apimodel.GlucoseRead `json:"reads"`
+	Injections []apimodel.Injection   `json:"injections"`
+	BasalRates []apimodel.BasalRate   `json:"basalRates"`
+	Meals      []apimodel.Meal        `json:"meals"`
+	Exercises  []apimodel.Exercise    `json:"exercises"`
+}
+
+// NewSnapshot returns a snapshot of the content with a new random token
+func NewSnapshot(label string, lowerBound time.Time, upperBound time.Time, glucoseUnit apimodel.GlucoseUnit, content SnapshotContent,
+	now time.Time, expiresAt time.Time) (snapshot Snapshot, err error) {
+	secret := make([]byte, SNAPSHOT_TOKEN_BYTES)
+	if _, err := rand.Read(secret); err != nil {
+		return snapshot, err
+	}
+
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return snapshot, err
+	}
+
+	return Snapshot{hex.EncodeToString(secret), label, lowerBound, upperBound, glucoseUnit, encoded, now, expiresAt}, nil
+}
+
+// GetContent returns the data copied in the snapshot
+func (snapshot Snapshot) GetContent() (content SnapshotContent, err error) {
+	err = json.Unmarshal(snapshot.Content, &content)
+	return content, err
+}
+
+// IsExpired returns true if the snapshot expired as of now
+func (snapshot Snapshot) IsExpired(now time.Time) bool {
+	return !now.Before(snapshot.ExpiresAt)
+}
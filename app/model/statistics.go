@@ -2,7 +2,6 @@ package model
 
 import (
 	"github.com/alexandre-normand/glukit/app/apimodel"
-	"github.com/alexandre-normand/glukit/app/util"
 )
 
 // Type for a slice of GlucoseReads with comparison based on value rather than time. It is used as read statistics.
@@ -13,11 +12,7 @@ func (slice ReadStatsSlice) Len() int {
 }
 
 func (slice ReadStatsSlice) Get(i int) float64 {
-	value, err := slice[i].GetNormalizedValue(apimodel.MG_PER_DL)
-	if err != nil {
-		util.Propagate(err)
-	}
-	return float64(value)
+	return float64(slice[i].GetMgPerDlValue())
 }
 
 func (slice ReadStatsSlice) Less(i, j int) bool {
@@ -28,12 +23,13 @@ func (slice ReadStatsSlice) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
-// Represents the structure of the dashboard data for a user
+// Represents the structure of the dashboard data for a user, glucose values being in Unit
 type DashboardData struct {
-	Average float64 `json:"average"`
-	Median  float64 `json:"median"`
-	High    float64 `json:"high"`
-	Low     float64 `json:"low"`
+	Average float64              `json:"average"`
+	Median  float64              `json:"median"`
+	High    float64              `json:"high"`
+	Low     float64              `json:"low"`
+	Unit    apimodel.GlucoseUnit `json:"unit"`
 }
 
 type CoordinateSlice []Coordinate
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+)
+
+// Represents the Tidepool account of a user that imported data is exported to. The password is kept encrypted with the
+// credentials encryption key of the app since it's needed to log in for each export. LastExported is the time of the
+// most recent record exported so that only newer ones get exported next. LastExportStatus is the outcome of the last
+// export, as of LastExportOn.
+type TidepoolAccount struct {
+	Username          string    `datastore:"username,noindex" json:"username"`
+	EncryptedPassword []byte    `datastore:"encryptedPassword,noindex" json:"-"`
+	LastExported      time.Time `datastore:"lastExported,noindex" json:"lastExported"`
+	LastExportStatus  string    `datastore:"lastExportStatus,noindex" json:"lastExportStatus"`
+	LastExportOn      time.Time `datastore:"lastExportOn,noindex" json:"lastExportOn"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+)
+
+// TimeInRange is the share of time spent below, in and above a target range, in percent. Shares are of the time covered
+// by reads which doesn't include gaps between reads.
+type TimeInRange struct {
+	LowerBound time.Time     `json:"lowerBound"`
+	UpperBound time.Time     `json:"upperBound"`
+	TargetLow  float32       `json:"targetLow"`
+	TargetHigh float32       `json:"targetHigh"`
+	Below      float64       `json:"below"`
+	InRange    float64       `json:"inRange"`
+	Above      float64       `json:"above"`
+	Covered    time.Duration `json:"covered"`
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Names of the parameters of the bounds of a time range parsed by ParseTimeRange
+	TIME_RANGE_FROM = "from"
+	TIME_RANGE_TO   = "to"
+
+	// Layout of the dates accepted as bounds, a date being the beginning of that day as a lower bound and the end of it
+	// as an upper bound
+	TIME_RANGE_DATE_LAYOUT = "2006-01-02"
+)
+
+// TimeRange is a period of time, both bounds being inclusive
+type TimeRange struct {
+	LowerBound time.Time `json:"lowerBound"`
+	UpperBound time.Time `json:"upperBound"`
+}
+
+// TimeRangeError is the error of a time range that's invalid, Param being the name of the parameter of the bad bound
+type TimeRangeError struct {
+	Param   string
+	Message string
+}
+
+func (err TimeRangeError) Error() string {
+	return err.Message
+}
+
+// ParseTimeRange parses the time range between the from and to values, both required. Values are either RFC3339 times
+// or dates formatted as TIME_RANGE_DATE_LAYOUT in the location, a from date being the beginning of that day and a to
+// date the end of it. A TimeRangeError naming the bad parameter is returned if a value is missing, can't be parsed or
+// if to is before from.
+func ParseTimeRange(from, to string, location *time.Location) (timeRange TimeRange, err error) {
+	return ParseTimeRangeOr(from, to, location, TimeRange{})
+}
+
+// ParseTimeRangeOr parses the time range between the from and to values like ParseTimeRange does except that a missing
+// value takes the bound of defaults instead
+func ParseTimeRangeOr(from, to string, location *time.Location, defaults TimeRange) (timeRange TimeRange, err error) {
+	return ParseNamedTimeRangeOr(TIME_RANGE_FROM, from, TIME_RANGE_TO, to, location, defaults)
+}
+
+// ParseNamedTimeRangeOr parses the time range between the from and to values like ParseTimeRangeOr does for bounds given
+// by parameters named other than TIME_RANGE_FROM and TIME_RANGE_TO. Errors name the fromParam and toParam parameters.
+func ParseNamedTimeRangeOr(fromParam, from, toParam, to string, location *time.Location, defaults TimeRange) (timeRange TimeRange, err error) {
+	timeRange = defaults
+	if len(from) > 0 {
+		if timeRange.LowerBound, err = parseBound(fromParam, from, location, false); err != nil {
+			return timeRange, err
+		}
+	}
+
+	if len(to) > 0 {
+		if timeRange.UpperBound, err = parseBound(toParam, to, location, true); err != nil {
+			return timeRange, err
+		}
+	}
+
+	return timeRange, timeRange.validate(fromParam, toParam, 0)
+}
+
+// Validate returns a TimeRangeError naming the bad parameter if a bound of the range is missing, if the upper bound is
+// before the lower one or if the range spans more than maxSpan. Ranges of any length are valid for a maxSpan of 0.
+func (timeRange TimeRange) Validate(maxSpan time.Duration) error {
+	return timeRange.validate(TIME_RANGE_FROM, TIME_RANGE_TO, maxSpan)
+}
+
+// validate validates the range like Validate does, its bounds being given by the fromParam and toParam parameters
+func (timeRange TimeRange) validate(fromParam, toParam string, maxSpan time.Duration) error {
+	if timeRange.LowerBound.IsZero() {
+		return TimeRangeError{fromParam, fmt.Sprintf("Missing value for %s.", fromParam)}
+	}
+
+	if timeRange.UpperBound.IsZero() {
+		return TimeRangeError{toParam, fmt.Sprintf("Missing value for %s.", toParam)}
+	}
+
+	if timeRange.UpperBound.Before(timeRange.LowerBound) {
+		return TimeRangeError{toParam, fmt.Sprintf("Value of %s must not be before the one of %s.", toParam, fromParam)}
+	}
+
+	if maxSpan > 0 && timeRange.Duration() > maxSpan {
+		return TimeRangeError{toParam, fmt.Sprintf("Range from %s to %s must not span more than [%s].", fromParam, toParam,
+			maxSpan)}
+	}
+
+	return nil
+}
+
+// Duration returns the length of the range
+func (timeRange TimeRange) Duration() time.Duration {
+	return timeRange.UpperBound.Sub(timeRange.LowerBound)
+}
+
+// Days returns the number of calendar days, in the location of the lower bound, the range is on, partial days included
+func (timeRange TimeRange) Days() int {
+	if timeRange.UpperBound.Before(timeRange.LowerBound) {
+		return 0
+	}
+
+	lowerBound := timeRange.LowerBound
+	upperBound := timeRange.UpperBound.In(lowerBound.Location())
+	first := time.Date(lowerBound.Year(), lowerBound.Month(), lowerBound.Day(), 0, 0, 0, 0, time.UTC)
+	last := time.Date(upperBound.Year(), upperBound.Month(), upperBound.Day(), 0, 0, 0, 0, time.UTC)
+
+	return int(last.Sub(first)/(time.Duration(24)*time.Hour)) + 1
+}
+
+// Split splits the range into consecutive ranges of length d, the last one ending with the range and being shorter if
+// the range isn't a multiple of d. The range is returned whole if d isn't positive.
+func (timeRange TimeRange) Split(d time.Duration) (ranges []TimeRange) {
+	if d <= 0 {
+		return []TimeRange{timeRange}
+	}
+
+	ranges = make([]TimeRange, 0, timeRange.Duration()/d+1)
+	for lowerBound := timeRange.LowerBound; !lowerBound.After(timeRange.UpperBound); lowerBound = lowerBound.Add(d) {
+		upperBound := lowerBound.Add(d - time.Nanosecond)
+		if upperBound.After(timeRange.UpperBound) {
+			upperBound = timeRange.UpperBound
+		}
+		ranges = append(ranges, TimeRange{lowerBound, upperBound})
+	}
+
+	return ranges
+}
+
+// parseBound parses the value of the param as an RFC3339 time or as a date in the location, the end of the day being
+// the bound of a date if it's an upper bound
+func parseBound(param, value string, location *time.Location, upper bool) (bound time.Time, err error) {
+	if bound, err = time.Parse(time.RFC3339, value); err == nil {
+		return bound, nil
+	}
+
+	if location == nil {
+		location = time.UTC
+	}
+
+	if bound, err = time.ParseInLocation(TIME_RANGE_DATE_LAYOUT, value, location); err == nil {
+		if upper {
+			bound = bound.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return bound, nil
+	}
+
+	return bound, TimeRangeError{param, fmt.Sprintf("Invalid value for %s: [%s], expected an RFC3339 time or a date formatted as %s.",
+		param, value, TIME_RANGE_DATE_LAYOUT)}
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeRangeOfDates(t *testing.T) {
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("Timezone data not available: %v", err)
+	}
+
+	timeRange, err := ParseTimeRange("2015-03-07", "2015-03-08", location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedLowerBound := time.Date(2015, time.March, 7, 0, 0, 0, 0, location)
+	expectedUpperBound := time.Date(2015, time.March, 9, 0, 0, 0, 0, location).Add(-time.Nanosecond)
+	if !timeRange.LowerBound.Equal(expectedLowerBound) || !timeRange.UpperBound.Equal(expectedUpperBound) {
+		t.Errorf("Expected range from [%s] to [%s] but got [%s] to [%s]", expectedLowerBound, expectedUpperBound,
+			timeRange.LowerBound, timeRange.UpperBound)
+	}
+
+	if days := timeRange.Days(); days != 2 {
+		t.Errorf("Expected range over [2] days but got [%d]", days)
+	}
+}
+
+func TestParseTimeRangeOfRFC3339Times(t *testing.T) {
+	timeRange, err := ParseTimeRange("2015-03-07T10:00:00-08:00", "2015-03-07T20:30:00Z", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if duration := timeRange.Duration(); duration != time.Duration(150)*time.Minute {
+		t.Errorf("Expected range of [2h30m] but got [%s]", duration)
+	}
+}
+
+func TestParseTimeRangeWithDefaults(t *testing.T) {
+	defaults := TimeRange{time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, time.March, 10, 0, 0, 0, 0, time.UTC)}
+	timeRange, err := ParseTimeRangeOr("2015-03-05", "", time.UTC, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeRange.LowerBound.Day() != 5 || !timeRange.UpperBound.Equal(defaults.UpperBound) {
+		t.Errorf("Expected range from the 5th to the default upper bound but got [%v]", timeRange)
+	}
+}
+
+func TestParseTimeRangeErrorsNameTheBadParameter(t *testing.T) {
+	cases := []struct {
+		from          string
+		to            string
+		expectedParam string
+	}{
+		{"yesterday", "2015-03-07", TIME_RANGE_FROM},
+		{"2015-03-07", "2015-13-45", TIME_RANGE_TO},
+		{"", "2015-03-07", TIME_RANGE_FROM},
+		{"2015-03-07", "", TIME_RANGE_TO},
+		{"2015-03-08", "2015-03-07", TIME_RANGE_TO},
+	}
+
+	for _, c := range cases {
+		_, err := ParseTimeRange(c.from, c.to, time.UTC)
+		if timeRangeError, ok := err.(TimeRangeError); !ok || timeRangeError.Param != c.expectedParam {
+			t.Errorf("Expected error for parameter [%s] parsing [%s] to [%s] but got [%v]", c.expectedParam, c.from, c.to, err)
+		}
+	}
+}
+
+func TestParseNamedTimeRangeErrorsNameTheBadParameter(t *testing.T) {
+	_, err := ParseNamedTimeRangeOr("aFrom", "2015-03-08", "aTo", "2015-03-07", time.UTC, TimeRange{})
+	if timeRangeError, ok := err.(TimeRangeError); !ok || timeRangeError.Param != "aTo" {
+		t.Errorf("Expected error for parameter [aTo] but got [%v]", err)
+	}
+
+	defaults := TimeRange{time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, time.March, 10, 0, 0, 0, 0, time.UTC)}
+	if timeRange, err := ParseNamedTimeRangeOr("aFrom", "", "aTo", "", time.UTC, defaults); err != nil || timeRange != defaults {
+		t.Errorf("Expected default range [%v] but got [%v] and [%v]", defaults, timeRange, err)
+	}
+}
+
+func TestValidateMaxSpan(t *testing.T) {
+	timeRange := TimeRange{time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, time.March, 3, 0, 0, 0, 0, time.UTC)}
+	if err := timeRange.Validate(time.Duration(48) * time.Hour); err != nil {
+		t.Errorf("Expected range of [48h] to be valid but got [%v]", err)
+	}
+
+	if err := timeRange.Validate(time.Duration(24) * time.Hour); err == nil {
+		t.Errorf("Expected range of [48h] to be longer than [24h]")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	lowerBound := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	timeRange := TimeRange{lowerBound, lowerBound.Add(time.Duration(150) * time.Minute)}
+
+	ranges := timeRange.Split(time.Hour)
+	if len(ranges) != 3 {
+		t.Fatalf("Expected [3] ranges but got %v", ranges)
+	}
+
+	if !ranges[1].LowerBound.Equal(lowerBound.Add(time.Hour)) || !ranges[1].UpperBound.Equal(lowerBound.Add(time.Duration(2)*time.Hour-time.Nanosecond)) {
+		t.Errorf("Expected second range to be the second hour but got [%v]", ranges[1])
+	}
+
+	if !ranges[2].UpperBound.Equal(timeRange.UpperBound) {
+		t.Errorf("Expected last range to end with the range but got [%v]", ranges[2])
+	}
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/util"
+	"github.com/alexandre-normand/glukit/lib/goauth2/oauth"
+)
+
+// TokenKeyRing seals the OAuth tokens of users when they are stored. It's set from the configuration of the app when it
+// starts, tokens can't be stored without it.
+var TokenKeyRing util.KeyRing
+
+// TokenDecryptionError is the error of a stored token that can't be opened with TokenKeyRing, either because the key it
+// was sealed with was removed from the ring or because it was tampered with
+type TokenDecryptionError struct {
+	Token string
+	Err   error
+}
+
+func (err TokenDecryptionError) Error() string {
+	return fmt.Sprintf("Stored %s token can't be decrypted, access has to be authorized again: %v", err.Token, err.Err)
+}
+
+// sealToken returns the token with its access and refresh tokens sealed with TokenKeyRing
+func sealToken(token oauth.Token) (sealed oauth.Token, err error) {
+	sealed = token
+	if sealed.AccessToken, err = sealTokenValue(token.AccessToken); err != nil {
+		return sealed, err
+	}
+
+	sealed.RefreshToken, err = sealTokenValue(token.RefreshToken)
+	return sealed, err
+}
+
+// sealTokenValue returns the value sealed with TokenKeyRing. Empty values are kept empty.
+func sealTokenValue(value string) (sealed string, err error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+
+	if len(TokenKeyRing.Keys) == 0 {
+		return "", errors.New("No key to encrypt tokens with, TokenKeyRing isn't configured")
+	}
+
+	return TokenKeyRing.Seal(value)
+}
+
+// openToken returns the token with its access and refresh tokens opened with TokenKeyRing. An error is returned, as a
+// TokenDecryptionError of the given name, if either can't be opened.
+func openToken(name string, token oauth.Token) (opened oauth.Token, err error) {
+	opened = token
+	if opened.AccessToken, err = openTokenValue(name, token.AccessToken); err != nil {
+		return oauth.Token{}, err
+	}
+
+	if opened.RefreshToken, err = openTokenValue(name, token.RefreshToken); err != nil {
+		return oauth.Token{}, err
+	}
+
+	return opened, nil
+}
+
+// openTokenValue returns the value opened with TokenKeyRing. Values stored in plaintext, before tokens were sealed, are
+// returned as is until they are stored again.
+func openTokenValue(name string, value string) (opened string, err error) {
+	if !util.IsSealed(value) {
+		return value, nil
+	}
+
+	if opened, err = TokenKeyRing.Open(value); err != nil {
+		return "", TokenDecryptionError{name, err}
+	}
+
+	return opened, nil
+}
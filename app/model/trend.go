@@ -0,0 +1,20 @@
+package model
+
+// TrendArrow is the direction glucose is heading to, as shown by CGMs
+type TrendArrow string
+
+const (
+	TREND_DOUBLE_UP   TrendArrow = "DoubleUp"
+	TREND_UP          TrendArrow = "Up"
+	TREND_FLAT        TrendArrow = "Flat"
+	TREND_DOWN        TrendArrow = "Down"
+	TREND_DOUBLE_DOWN TrendArrow = "DoubleDown"
+	TREND_UNKNOWN     TrendArrow = "Unknown"
+)
+
+// Trend is the rate of change of glucose, in mg/dL per minute, over the most recent reads
+type Trend struct {
+	Arrow        TrendArrow `json:"arrow"`
+	RateOfChange float64    `json:"rateOfChange"`
+	ReadCount    int        `json:"readCount"`
+}
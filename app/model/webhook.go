@@ -0,0 +1,45 @@
+package model
+
+import (
+	"time"
+)
+
+// Events a webhook can be subscribed to
+const (
+	WEBHOOK_EVENT_IMPORT_COMPLETED = "import.completed"
+)
+
+// Known webhook events, in the order they are offered to users
+var WebhookEvents = []string{WEBHOOK_EVENT_IMPORT_COMPLETED}
+
+// Represents the webhook of a user, an url that gets a POST for each of the events it's subscribed to while it's
+// enabled. Payloads are signed with the secret so that the receiver can tell they come from glukit. LastDeliveryStatus
+// is the http status of the last delivery, or the error that prevented it, as of LastDeliveryOn.
+type Webhook struct {
+	Url                string    `datastore:"url,noindex" json:"url"`
+	Secret             string    `datastore:"secret,noindex" json:"-"`
+	Enabled            bool      `datastore:"enabled,noindex" json:"enabled"`
+	Events             []string  `datastore:"events,noindex" json:"events"`
+	LastDeliveryStatus string    `datastore:"lastDeliveryStatus,noindex" json:"lastDeliveryStatus"`
+	LastDeliveryOn     time.Time `datastore:"lastDeliveryOn,noindex" json:"lastDeliveryOn"`
+}
+
+// ParseWebhookEvent returns the webhook event of the given value or an error if it isn't a known event
+func ParseWebhookEvent(value string) (event string, err error) {
+	return parseEnumValue("webhook event", value, WebhookEvents)
+}
+
+// IsSubscribed returns true if the webhook is enabled and subscribed to the event
+func (webhook Webhook) IsSubscribed(event string) bool {
+	if !webhook.Enabled {
+		return false
+	}
+
+	for _, subscribed := range webhook.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+
+	return false
+}
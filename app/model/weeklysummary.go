@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+)
+
+// WeeklySummary is the summary of a week of a user, from Monday midnight to the next in the user's timezone. Glucose
+// values are in mg/dL and time in range in percent. The best and worst days are the days with the highest and lowest
+// time in range. ScoreChange is the change of the user-facing GlukitScore from the end of the prior week and is only
+// set if HasScoreChange is true.
+type WeeklySummary struct {
+	WeekStart       time.Time `json:"weekStart" datastore:"weekStart"`
+	WeekEnd         time.Time `json:"weekEnd" datastore:"weekEnd,noindex"`
+	Timezone        string    `json:"timezone" datastore:"timezone,noindex"`
+	ReadCount       int       `json:"readCount" datastore:"readCount,noindex"`
+	MeanGlucose     float64   `json:"meanGlucose" datastore:"meanGlucose,noindex"`
+	TimeInRange     float64   `json:"timeInRange" datastore:"timeInRange,noindex"`
+	HypoCount       int       `json:"hypoCount" datastore:"hypoCount,noindex"`
+	BestDay         time.Time `json:"bestDay" datastore:"bestDay,noindex"`
+	BestDayInRange  float64   `json:"bestDayInRange" datastore:"bestDayInRange,noindex"`
+	WorstDay        time.Time `json:"worstDay" datastore:"worstDay,noindex"`
+	WorstDayInRange float64   `json:"worstDayInRange" datastore:"worstDayInRange,noindex"`
+	ScoreChange     int64     `json:"scoreChange" datastore:"scoreChange,noindex"`
+	HasScoreChange  bool      `json:"hasScoreChange" datastore:"hasScoreChange,noindex"`
+	MealCount       int       `json:"mealCount" datastore:"mealCount,noindex"`
+	InjectionCount  int       `json:"injectionCount" datastore:"injectionCount,noindex"`
+	CalculatedOn    time.Time `json:"calculatedOn" datastore:"calculatedOn,noindex"`
+}
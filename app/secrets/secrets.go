@@ -1,3 +1,3 @@
 package secrets
 
-//go:generate safekeeper --output=appsecrets.go --keys=LOCAL_CLIENT_ID,LOCAL_CLIENT_SECRET,PROD_CLIENT_ID,PROD_CLIENT_SECRET,TEST_STRIPE_KEY,TEST_STRIPE_PUBLISHABLE_KEY,PROD_STRIPE_KEY,PROD_STRIPE_PUBLISHABLE_KEY,GLUKLOADER_CLIENT_ID,GLUKLOADER_CLIENT_SECRET,GLUKLOADER_SHARE_EDITION_CLIENT_ID,GLUKLOADER_SHARE_EDITION_CLIENT_SECRET,POSTMAN_CLIENT_ID,POSTMAN_CLIENT_SECRET,SIMPLE_CLIENT_ID,SIMPLE_CLIENT_SECRET,CHROMADEX_CLIENT_ID,CHROMADEX_CLIENT_SECRET $GOFILE
+//go:generate safekeeper --output=appsecrets.go --keys=LOCAL_CLIENT_ID,LOCAL_CLIENT_SECRET,PROD_CLIENT_ID,PROD_CLIENT_SECRET,TEST_STRIPE_KEY,TEST_STRIPE_PUBLISHABLE_KEY,PROD_STRIPE_KEY,PROD_STRIPE_PUBLISHABLE_KEY,GLUKLOADER_CLIENT_ID,GLUKLOADER_CLIENT_SECRET,GLUKLOADER_SHARE_EDITION_CLIENT_ID,GLUKLOADER_SHARE_EDITION_CLIENT_SECRET,POSTMAN_CLIENT_ID,POSTMAN_CLIENT_SECRET,SIMPLE_CLIENT_ID,SIMPLE_CLIENT_SECRET,CHROMADEX_CLIENT_ID,CHROMADEX_CLIENT_SECRET,DROPBOX_APP_KEY,DROPBOX_APP_SECRET,CREDENTIALS_ENCRYPTION_KEY,TOKEN_ENCRYPTION_KEY_ID,TOKEN_ENCRYPTION_KEY,PREVIOUS_TOKEN_ENCRYPTION_KEY_ID,PREVIOUS_TOKEN_ENCRYPTION_KEY,IOS_CLIENT_ID,ANDROID_CLIENT_ID $GOFILE
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAccountLinkNotConfirmed is returned when confirming the link of an account without a pending request, with the
+	// wrong token or after the request expired
+	ErrAccountLinkNotConfirmed = StoreError{"store: account link not confirmed", false}
+
+	// ErrAccountAliasNotFound is returned when unlinking an account that isn't linked to the user
+	ErrAccountAliasNotFound = StoreError{"store: account alias not found", false}
+)
+
+// accountAliasKey returns the key of the alias of the account with the given email address. Aliases are root entities
+// so that they can be looked up by email address alone.
+func accountAliasKey(context context.Context, email string) *datastore.Key {
+	return datastore.NewKey(context, "AccountAlias", strings.ToLower(email), 0, nil)
+}
+
+// accountLinkRequestKey returns the key of the pending request of the user to link another account
+func accountLinkRequestKey(context context.Context, userProfileKey *datastore.Key) *datastore.Key {
+	return datastore.NewKey(context, "AccountLinkRequest", "current", 0, userProfileKey)
+}
+
+// ResolveUser returns the email address and key of the GlukitUser the account with the given email address signs in to:
+// the primary account it's linked to, if it's an alias, or the account itself otherwise. Data of either identity is
+// stored under the returned key.
+func ResolveUser(context context.Context, email string) (primaryEmail string, key *datastore.Key, err error) {
+	alias, err := GetAccountAlias(context, email)
+	if err == datastore.ErrNoSuchEntity {
+		return email, GetUserKey(context, email), nil
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	return alias.PrimaryEmail, GetUserKey(context, alias.PrimaryEmail), nil
+}
+
+// GetAccountAlias returns the alias of the account with the given email address, datastore.ErrNoSuchEntity if it isn't
+// linked to another one
+func GetAccountAlias(context context.Context, email string) (alias *model.AccountAlias, err error) {
+	alias = new(model.AccountAlias)
+	if err := get(context, accountAliasKey(context, email), alias); err != nil {
+		return nil, err
+	}
+
+	return alias, nil
+}
+
+// GetAccountAliases returns the accounts linked to the primary account with the given email address
+func GetAccountAliases(context context.Context, primaryEmail string) (aliases []model.AccountAlias, err error) {
+	aliases = make([]model.AccountAlias, 0)
+	if _, err := datastore.NewQuery("AccountAlias").Filter("primaryEmail =", primaryEmail).GetAll(context, &aliases); err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
+
+// StoreAccountAlias links the account of the alias to its primary account
+func StoreAccountAlias(context context.Context, alias model.AccountAlias) (err error) {
+	_, err = put(context, accountAliasKey(context, alias.Email), &alias)
+	return err
+}
+
+// DeleteAccountAlias unlinks the account with the given email address from the primary account. ErrAccountAliasNotFound
+// is returned if it isn't linked to it.
+func DeleteAccountAlias(context context.Context, primaryEmail string, email string) (err error) {
+	return runInTransaction(context, "DeleteAccountAlias", accountAliasDeleter(accountAliasKey(context, email), primaryEmail))
+}
+
+// accountAliasDeleter returns the transaction function that deletes the alias if it's linked to the primary account
+func accountAliasDeleter(key *datastore.Key, primaryEmail string) func(context context.Context) error {
+	return func(context context.Context) error {
+		alias := new(model.AccountAlias)
+		if err := get(context, key, alias); err == datastore.ErrNoSuchEntity {
+			return ErrAccountAliasNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if alias.PrimaryEmail != primaryEmail {
+			return ErrAccountAliasNotFound
+		}
+
+		return deleteMulti(context, []*datastore.Key{key})
+	}
+}
+
+// DeleteAccountAliases unlinks all the accounts linked to the primary account with the given email address
+func DeleteAccountAliases(context context.Context, primaryEmail string) (err error) {
+	keys, err := datastore.NewQuery("AccountAlias").Filter("primaryEmail =", primaryEmail).KeysOnly().GetAll(context, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return deleteMulti(context, keys)
+}
+
+// StoreAccountLinkRequest stores the request of the user to link another account, replacing any pending one
+func StoreAccountLinkRequest(context context.Context, userProfileKey *datastore.Key, request model.AccountLinkRequest) (err error) {
+	_, err = put(context, accountLinkRequestKey(context, userProfileKey), &request)
+	return err
+}
+
+// CheckAccountLinkRequest returns ErrAccountLinkNotConfirmed unless the user has a pending request to link another
+// account that the token confirms as of now
+func CheckAccountLinkRequest(context context.Context, userProfileKey *datastore.Key, token string, now time.Time) (err error) {
+	request := new(model.AccountLinkRequest)
+	if err := get(context, accountLinkRequestKey(context, userProfileKey), request); err == datastore.ErrNoSuchEntity {
+		return ErrAccountLinkNotConfirmed
+	} else if err != nil {
+		return err
+	}
+
+	if !request.IsConfirmedBy(token, now) {
+		return ErrAccountLinkNotConfirmed
+	}
+
+	return nil
+}
+
+// ConsumeAccountLinkRequest consumes the pending request of the user to link another account if the token confirms it
+// as of now. ErrAccountLinkNotConfirmed is returned otherwise.
+func ConsumeAccountLinkRequest(context context.Context, userProfileKey *datastore.Key, token string, now time.Time) (err error) {
+	return runInTransaction(context, "ConsumeAccountLinkRequest", accountLinkRequestConsumer(accountLinkRequestKey(context, userProfileKey),
+		token, now))
+}
+
+// accountLinkRequestConsumer returns the transaction function that deletes the request if the token confirms it
+func accountLinkRequestConsumer(key *datastore.Key, token string, now time.Time) func(context context.Context) error {
+	return func(context context.Context) error {
+		request := new(model.AccountLinkRequest)
+		if err := get(context, key, request); err == datastore.ErrNoSuchEntity {
+			return ErrAccountLinkNotConfirmed
+		} else if err != nil {
+			return err
+		}
+
+		if !request.IsConfirmedBy(token, now) {
+			return ErrAccountLinkNotConfirmed
+		}
+
+		return deleteMulti(context, []*datastore.Key{key})
+	}
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"strings"
+	"time"
+)
+
+// ErrAccountDeletionNotConfirmed is returned when confirming the deletion of an account without a pending request, with
+// the wrong token or after the request expired
+var ErrAccountDeletionNotConfirmed = StoreError{"store: account deletion not confirmed", false}
+
+// accountDeletionRequestKey returns the key of the pending account deletion request of the user
+func accountDeletionRequestKey(context context.Context, userProfileKey *datastore.Key) *datastore.Key {
+	return datastore.NewKey(context, "AccountDeletionRequest", "current", 0, userProfileKey)
+}
+
+// StoreAccountDeletionRequest stores the request of the user to delete their account, replacing any pending one
+func StoreAccountDeletionRequest(context context.Context, userProfileKey *datastore.Key, request model.AccountDeletionRequest) (err error) {
+	_, err = put(context, accountDeletionRequestKey(context, userProfileKey), &request)
+	return err
+}
+
+// HasPendingAccountDeletion returns true if the user requested the deletion of their account and the request hasn't
+// expired as of now
+func HasPendingAccountDeletion(context context.Context, userProfileKey *datastore.Key, now time.Time) (pending bool, err error) {
+	request := new(model.AccountDeletionRequest)
+	if err := get(context, accountDeletionRequestKey(context, userProfileKey), request); err == datastore.ErrNoSuchEntity {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return now.Before(request.ExpiresAt), nil
+}
+
+// ConfirmAccountDeletion consumes the pending account deletion request of the user if the token confirms it as of now.
+// ErrAccountDeletionNotConfirmed is returned otherwise.
+func ConfirmAccountDeletion(context context.Context, userProfileKey *datastore.Key, token string, now time.Time) (err error) {
+	return runInTransaction(context, "ConfirmAccountDeletion", accountDeletionConfirmer(accountDeletionRequestKey(context, userProfileKey),
+		token, now))
+}
+
+// accountDeletionConfirmer returns the transaction function that deletes the request if the token confirms it
+func accountDeletionConfirmer(key *datastore.Key, token string, now time.Time) func(context context.Context) error {
+	return func(context context.Context) error {
+		request := new(model.AccountDeletionRequest)
+		if err := get(context, key, request); err == datastore.ErrNoSuchEntity {
+			return ErrAccountDeletionNotConfirmed
+		} else if err != nil {
+			return err
+		}
+
+		if !request.IsConfirmedBy(token, now) {
+			return ErrAccountDeletionNotConfirmed
+		}
+
+		return deleteMulti(context, []*datastore.Key{key})
+	}
+}
+
+// DeleteUserProfile deletes the GlukitUser of the given email address and its cached copy. The entities stored under
+// the profile are left to DeleteUserData.
+func DeleteUserProfile(context context.Context, email string) (err error) {
+	key := GetUserKey(context, email)
+	invalidateCachedUserProfile(context, key)
+
+	return deleteMulti(context, []*datastore.Key{key})
+}
+
+// DeleteGrantsTo deletes the grants of other users to the grantee, expired or not. The number of grants deleted is
+// returned.
+func DeleteGrantsTo(context context.Context, granteeEmail string) (count int, err error) {
+	query := datastore.NewQuery("ShareGrant").Filter("granteeEmail =", strings.ToLower(granteeEmail)).KeysOnly()
+	keys, err := query.GetAll(context, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	return len(keys), deleteMulti(context, keys)
+}
+
+// DeleteShareInvitationsTo deletes the pending invitations of other users addressed to the invitee. The number of
+// invitations deleted is returned.
+func DeleteShareInvitationsTo(context context.Context, inviteeEmail string) (count int, err error) {
+	query := datastore.NewQuery("ShareInvitation").Filter("inviteeEmail =", strings.ToLower(inviteeEmail)).KeysOnly()
+	keys, err := query.GetAll(context, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	return len(keys), deleteMulti(context, keys)
+}
@@ -0,0 +1,76 @@
+package store_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	. "github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"testing"
+	"time"
+)
+
+func TestAccountDeletionIsConfirmedOnce(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	userProfileKey := GetUserKey(c, "deleted@glukit.com")
+	now := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	if err := ConfirmAccountDeletion(c, userProfileKey, "token", now); err != ErrAccountDeletionNotConfirmed {
+		t.Errorf("Expected [%v] without a pending request but got [%v]", ErrAccountDeletionNotConfirmed, err)
+	}
+
+	token, request, err := model.NewAccountDeletionRequest(now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := StoreAccountDeletionRequest(c, userProfileKey, request); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending, err := HasPendingAccountDeletion(c, userProfileKey, now); err != nil || !pending {
+		t.Errorf("Expected a pending deletion but got [%t] with [%v]", pending, err)
+	}
+
+	if err := ConfirmAccountDeletion(c, userProfileKey, token+"0", now); err != ErrAccountDeletionNotConfirmed {
+		t.Errorf("Expected [%v] with the wrong token but got [%v]", ErrAccountDeletionNotConfirmed, err)
+	}
+
+	if err := ConfirmAccountDeletion(c, userProfileKey, token, now); err != nil {
+		t.Errorf("Expected deletion to be confirmed by its token but got [%v]", err)
+	}
+
+	if err := ConfirmAccountDeletion(c, userProfileKey, token, now); err != ErrAccountDeletionNotConfirmed {
+		t.Errorf("Expected [%v] confirming the deletion twice but got [%v]", ErrAccountDeletionNotConfirmed, err)
+	}
+}
+
+func TestExpiredAccountDeletionIsNotConfirmed(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	userProfileKey := GetUserKey(c, "deleted@glukit.com")
+	requestedOn := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	token, request, err := model.NewAccountDeletionRequest(requestedOn, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := StoreAccountDeletionRequest(c, userProfileKey, request); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := requestedOn.Add(time.Duration(2) * time.Hour)
+	if pending, err := HasPendingAccountDeletion(c, userProfileKey, expired); err != nil || pending {
+		t.Errorf("Expected no pending deletion once expired but got [%t] with [%v]", pending, err)
+	}
+
+	if err := ConfirmAccountDeletion(c, userProfileKey, token, expired); err != ErrAccountDeletionNotConfirmed {
+		t.Errorf("Expected [%v] once expired but got [%v]", ErrAccountDeletionNotConfirmed, err)
+	}
+}
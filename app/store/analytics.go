@@ -0,0 +1,67 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"time"
+)
+
+// analyticsExportSettingsKey returns the key of the single entity of the analytics export settings
+func analyticsExportSettingsKey(context context.Context) *datastore.Key {
+	return datastore.NewKey(context, "AnalyticsExportSettings", "settings", 0, nil)
+}
+
+// GetAnalyticsExportSettings returns the settings of the analytics export, storing defaultSettings first if there
+// aren't any yet
+func GetAnalyticsExportSettings(context context.Context, defaultSettings model.AnalyticsExportSettings) (settings *model.AnalyticsExportSettings, err error) {
+	settings = new(model.AnalyticsExportSettings)
+	if err := get(context, analyticsExportSettingsKey(context), settings); err != datastore.ErrNoSuchEntity {
+		return settings, err
+	}
+
+	err = runInTransaction(context, "GetAnalyticsExportSettings", analyticsExportSettingsInitializer(defaultSettings, settings))
+	return settings, err
+}
+
+// analyticsExportSettingsInitializer returns the transaction function that stores defaultSettings unless settings were
+// stored already. The stored settings are copied to settings.
+func analyticsExportSettingsInitializer(defaultSettings model.AnalyticsExportSettings, settings *model.AnalyticsExportSettings) func(context context.Context) error {
+	return func(context context.Context) error {
+		key := analyticsExportSettingsKey(context)
+		if err := get(context, key, settings); err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		*settings = defaultSettings
+		_, err := put(context, key, settings)
+		return err
+	}
+}
+
+// SetAnalyticsExportDisabled flips the kill switch of the analytics export, storing defaultSettings first if there
+// aren't any yet
+func SetAnalyticsExportDisabled(context context.Context, disabled bool, defaultSettings model.AnalyticsExportSettings,
+	now time.Time) (settings *model.AnalyticsExportSettings, err error) {
+	settings = new(model.AnalyticsExportSettings)
+	err = runInTransaction(context, "SetAnalyticsExportDisabled", analyticsExportSwitcher(disabled, defaultSettings, now, settings))
+	return settings, err
+}
+
+// analyticsExportSwitcher returns the transaction function that sets the kill switch of the analytics export settings.
+// The stored settings are copied to settings.
+func analyticsExportSwitcher(disabled bool, defaultSettings model.AnalyticsExportSettings, now time.Time,
+	settings *model.AnalyticsExportSettings) func(context context.Context) error {
+	return func(context context.Context) error {
+		key := analyticsExportSettingsKey(context)
+		if err := get(context, key, settings); err == datastore.ErrNoSuchEntity {
+			*settings = defaultSettings
+		} else if err != nil {
+			return err
+		}
+
+		settings.Disabled, settings.UpdatedOn = disabled, now
+		_, err := put(context, key, settings)
+		return err
+	}
+}
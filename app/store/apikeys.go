@@ -0,0 +1,154 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+// ErrApiKeyNotFound is returned when an api key to revoke or authenticate with doesn't exist
+var ErrApiKeyNotFound = StoreError{"store: api key not found", false}
+
+// apiKeyIndex is stored at the root of the datastore with the hash of an api key as its key name so that the api
+// key it points to can be found by hash with a strongly consistent get
+type apiKeyIndex struct {
+	ApiKey *datastore.Key `datastore:"apiKey,noindex"`
+}
+
+// getApiKeyIndexKey returns the key of the apiKeyIndex of the api key with the given hash
+func getApiKeyIndexKey(context context.Context, hash string) *datastore.Key {
+	return datastore.NewKey(context, "ApiKeyIndex", hash, 0, nil)
+}
+
+// StoreApiKey stores a new api key of the user along with its index by hash and returns its id
+func StoreApiKey(context context.Context, userProfileKey *datastore.Key, apiKey model.ApiKey) (id int64, err error) {
+	err = runInCrossGroupTransaction(context, "StoreApiKey", func(context context.Context) error {
+		key, err := datastore.Put(context, datastore.NewIncompleteKey(context, "ApiKey", userProfileKey), &apiKey)
+		if err != nil {
+			return err
+		}
+
+		if _, err := datastore.Put(context, getApiKeyIndexKey(context, apiKey.Hash), &apiKeyIndex{key}); err != nil {
+			return err
+		}
+
+		id = key.IntID()
+		return nil
+	})
+
+	return id, err
+}
+
+// GetApiKeys returns the api keys of the user, oldest first, along with their ids
+func GetApiKeys(context context.Context, userProfileKey *datastore.Key) (ids []int64, apiKeys []model.ApiKey, err error) {
+	query := datastore.NewQuery("ApiKey").Ancestor(userProfileKey).Order("createdAt")
+
+	apiKeys = make([]model.ApiKey, 0)
+	keys, err := query.GetAll(context, &apiKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids = make([]int64, len(keys))
+	for i := range keys {
+		ids[i] = keys[i].IntID()
+	}
+
+	return ids, apiKeys, nil
+}
+
+// DeleteApiKey revokes the api key of the user with the given id along with its index so that it can't be found by
+// FindApiKey anymore. ErrApiKeyNotFound is returned if the user has no such key.
+func DeleteApiKey(context context.Context, userProfileKey *datastore.Key, id int64) (err error) {
+	key := datastore.NewKey(context, "ApiKey", "", id, userProfileKey)
+	return runInCrossGroupTransaction(context, "DeleteApiKey", apiKeyDeleter(key))
+}
+
+// apiKeyDeleter returns the transaction function that deletes the api key and its index if the key exists
+func apiKeyDeleter(key *datastore.Key) func(context context.Context) error {
+	return func(context context.Context) error {
+		apiKey := new(model.ApiKey)
+		if err := datastore.Get(context, key, apiKey); err == datastore.ErrNoSuchEntity {
+			return ErrApiKeyNotFound
+		} else if err != nil {
+			return err
+		}
+
+		return datastore.DeleteMulti(context, []*datastore.Key{key, getApiKeyIndexKey(context, apiKey.Hash)})
+	}
+}
+
+// FindApiKey returns the api key with the given hash along with its datastore key, whose parent is the key of the
+// user the api key belongs to. The key is looked up by its index so that a revoked key is never found.
+// ErrApiKeyNotFound is returned if there's no such key.
+func FindApiKey(context context.Context, hash string) (key *datastore.Key, apiKey *model.ApiKey, err error) {
+	index := new(apiKeyIndex)
+	if err := get(context, getApiKeyIndexKey(context, hash), index); err == datastore.ErrNoSuchEntity {
+		return findUnindexedApiKey(context, hash)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	apiKey = new(model.ApiKey)
+	if err := get(context, index.ApiKey, apiKey); err == datastore.ErrNoSuchEntity {
+		return nil, nil, ErrApiKeyNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	return index.ApiKey, apiKey, nil
+}
+
+// findUnindexedApiKey finds an api key stored before keys were indexed by hash and indexes it. The key found by the
+// query is read again since query results may include keys that were revoked.
+func findUnindexedApiKey(context context.Context, hash string) (key *datastore.Key, apiKey *model.ApiKey, err error) {
+	keys, err := datastore.NewQuery("ApiKey").Filter("hash =", hash).KeysOnly().Limit(1).GetAll(context, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, nil, ErrApiKeyNotFound
+	}
+
+	apiKey = new(model.ApiKey)
+	if err := get(context, keys[0], apiKey); err == datastore.ErrNoSuchEntity {
+		return nil, nil, ErrApiKeyNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := put(context, getApiKeyIndexKey(context, hash), &apiKeyIndex{keys[0]}); err != nil {
+		log.Warningf(context, "Error indexing api key [%s]: %v", apiKey.Prefix, err)
+	}
+
+	return keys[0], apiKey, nil
+}
+
+// RecordApiKeyUse records the use of the api key at now unless its last use was recorded less than
+// model.API_KEY_USE_RECORDING_INTERVAL before
+func RecordApiKeyUse(context context.Context, key *datastore.Key, now time.Time) (err error) {
+	return runInTransaction(context, "RecordApiKeyUse", apiKeyUseRecorder(key, now))
+}
+
+// apiKeyUseRecorder returns the transaction function that sets the last use of the api key to now if it's due
+func apiKeyUseRecorder(key *datastore.Key, now time.Time) func(context context.Context) error {
+	return func(context context.Context) error {
+		apiKey := new(model.ApiKey)
+		if err := get(context, key, apiKey); err == datastore.ErrNoSuchEntity {
+			return ErrApiKeyNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if !apiKey.IsUseRecordingDue(now) {
+			return nil
+		}
+
+		apiKey.LastUsedAt = now
+		_, err := put(context, key, apiKey)
+		return err
+	}
+}
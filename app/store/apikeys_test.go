@@ -0,0 +1,111 @@
+package store_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	. "github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"testing"
+	"time"
+)
+
+func TestFindApiKeyByHash(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	userProfileKey := GetUserKey(c, "apikeys@glukit.com")
+	key, apiKey, err := model.NewApiKey("reports", []string{model.API_KEY_SCOPE_READ_GLUCOSE}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := StoreApiKey(c, userProfileKey, apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foundKey, found, err := FindApiKey(c, model.HashApiKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if foundKey.IntID() != id || !foundKey.Parent().Equal(userProfileKey) || found.Label != "reports" {
+		t.Errorf("Expected api key [%d] of [%s] but got [%v] with [%v]", id, userProfileKey.StringID(), foundKey, found)
+	}
+
+	if _, _, err := FindApiKey(c, model.HashApiKey(key+"0")); err != ErrApiKeyNotFound {
+		t.Errorf("Expected [%v] for an unknown key but got [%v]", ErrApiKeyNotFound, err)
+	}
+}
+
+func TestRevokedApiKeyIsNotFound(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	userProfileKey := GetUserKey(c, "apikeys@glukit.com")
+	key, apiKey, err := model.NewApiKey("reports", []string{model.API_KEY_SCOPE_READ_EVENTS}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := StoreApiKey(c, userProfileKey, apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteApiKey(c, userProfileKey, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := FindApiKey(c, model.HashApiKey(key)); err != ErrApiKeyNotFound {
+		t.Errorf("Expected [%v] right after revoking the key but got [%v]", ErrApiKeyNotFound, err)
+	}
+
+	if err := DeleteApiKey(c, userProfileKey, id); err != ErrApiKeyNotFound {
+		t.Errorf("Expected [%v] revoking the key twice but got [%v]", ErrApiKeyNotFound, err)
+	}
+}
+
+func TestRecordApiKeyUse(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	createdAt := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	key, apiKey, err := model.NewApiKey("reports", []string{model.API_KEY_SCOPE_READ_GLUCOSE}, createdAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := StoreApiKey(c, GetUserKey(c, "apikeys@glukit.com"), apiKey); err != nil {
+		t.Fatal(err)
+	}
+
+	datastoreKey, _, err := FindApiKey(c, model.HashApiKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usedAt := createdAt.Add(time.Minute)
+	for _, now := range []time.Time{usedAt, usedAt.Add(time.Minute)} {
+		if err := RecordApiKeyUse(c, datastoreKey, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, used, err := FindApiKey(c, model.HashApiKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !used.LastUsedAt.Equal(usedAt) {
+		t.Errorf("Expected last use to be recorded once at [%s] but got [%s]", usedAt, used.LastUsedAt)
+	}
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"github.com/alexandre-normand/glukit/app/apimodel"
+	"time"
 )
 
 // mergeGlucoseReadArrays merges two arrays of GlucoseRead elements.
@@ -28,6 +29,14 @@ func mergeInjectionArrays(first, second []apimodel.Injection) []apimodel.Injecti
 	return newslice
 }
 
+// mergeBasalRateArrays merges two arrays of BasalRate elements.
+func mergeBasalRateArrays(first, second []apimodel.BasalRate) []apimodel.BasalRate {
+	newslice := make([]apimodel.BasalRate, len(first)+len(second))
+	copy(newslice, first)
+	copy(newslice[len(first):], second)
+	return newslice
+}
+
 // mergeExerciseArrays merges two arrays of Exercise elements.
 func mergeExerciseArrays(first, second []apimodel.Exercise) []apimodel.Exercise {
 	newslice := make([]apimodel.Exercise, len(first)+len(second))
@@ -43,3 +52,102 @@ func mergeCalibrationReadArrays(first, second []apimodel.CalibrationRead) []apim
 	copy(newslice[len(first):], second)
 	return newslice
 }
+
+// latestOf returns the latest of two times
+func latestOf(first, second time.Time) time.Time {
+	if first.After(second) {
+		return first
+	}
+
+	return second
+}
+
+// coalesceDaysOfGlucoseReads merges consecutive days of reads that start at the same time into one. Streamers split a day
+// in multiple batches when it has more elements than they buffer and a day must be stored under a single key.
+func coalesceDaysOfGlucoseReads(days []apimodel.DayOfGlucoseReads) (coalesced []apimodel.DayOfGlucoseReads) {
+	for _, day := range days {
+		if last := len(coalesced) - 1; last >= 0 && coalesced[last].StartTime.Equal(day.StartTime) {
+			coalesced[last] = apimodel.DayOfGlucoseReads{reconcileReads(coalesced[last].Reads, day.Reads), coalesced[last].StartTime,
+				latestOf(coalesced[last].EndTime, day.EndTime), coalesced[last].DeviceId}
+		} else {
+			coalesced = append(coalesced, day)
+		}
+	}
+
+	return coalesced
+}
+
+// coalesceDaysOfCalibrationReads merges consecutive days of calibrations that start at the same time into one. Streamers split a day
+// in multiple batches when it has more elements than they buffer and a day must be stored under a single key.
+func coalesceDaysOfCalibrationReads(days []apimodel.DayOfCalibrationReads) (coalesced []apimodel.DayOfCalibrationReads) {
+	for _, day := range days {
+		if last := len(coalesced) - 1; last >= 0 && coalesced[last].StartTime.Equal(day.StartTime) {
+			coalesced[last] = apimodel.DayOfCalibrationReads{reconcileCalibrations(coalesced[last].Reads, day.Reads), coalesced[last].StartTime,
+				latestOf(coalesced[last].EndTime, day.EndTime), coalesced[last].DeviceId}
+		} else {
+			coalesced = append(coalesced, day)
+		}
+	}
+
+	return coalesced
+}
+
+// coalesceDaysOfInjections merges consecutive days of injections that start at the same time into one. Streamers split a day
+// in multiple batches when it has more elements than they buffer and a day must be stored under a single key.
+func coalesceDaysOfInjections(days []apimodel.DayOfInjections) (coalesced []apimodel.DayOfInjections) {
+	for _, day := range days {
+		if last := len(coalesced) - 1; last >= 0 && coalesced[last].StartTime.Equal(day.StartTime) {
+			coalesced[last] = apimodel.DayOfInjections{reconcileInjections(coalesced[last].Injections, day.Injections), coalesced[last].StartTime,
+				latestOf(coalesced[last].EndTime, day.EndTime)}
+		} else {
+			coalesced = append(coalesced, day)
+		}
+	}
+
+	return coalesced
+}
+
+// coalesceDaysOfMeals merges consecutive days of meals that start at the same time into one. Streamers split a day
+// in multiple batches when it has more elements than they buffer and a day must be stored under a single key.
+func coalesceDaysOfMeals(days []apimodel.DayOfMeals) (coalesced []apimodel.DayOfMeals) {
+	for _, day := range days {
+		if last := len(coalesced) - 1; last >= 0 && coalesced[last].StartTime.Equal(day.StartTime) {
+			coalesced[last] = apimodel.DayOfMeals{reconcileMeals(coalesced[last].Meals, day.Meals), coalesced[last].StartTime,
+				latestOf(coalesced[last].EndTime, day.EndTime)}
+		} else {
+			coalesced = append(coalesced, day)
+		}
+	}
+
+	return coalesced
+}
+
+// coalesceDaysOfExercises merges consecutive days of exercises that start at the same time into one. Streamers split a day
+// in multiple batches when it has more elements than they buffer and a day must be stored under a single key.
+func coalesceDaysOfExercises(days []apimodel.DayOfExercises) (coalesced []apimodel.DayOfExercises) {
+	for _, day := range days {
+		if last := len(coalesced) - 1; last >= 0 && coalesced[last].StartTime.Equal(day.StartTime) {
+			coalesced[last] = apimodel.DayOfExercises{reconcileExercises(coalesced[last].Exercises, day.Exercises), coalesced[last].StartTime,
+				latestOf(coalesced[last].EndTime, day.EndTime)}
+		} else {
+			coalesced = append(coalesced, day)
+		}
+	}
+
+	return coalesced
+}
+
+// coalesceDaysOfBasalRates merges consecutive days of basal rates that start at the same time into one. Streamers split a day
+// in multiple batches when it has more elements than they buffer and a day must be stored under a single key.
+func coalesceDaysOfBasalRates(days []apimodel.DayOfBasalRates) (coalesced []apimodel.DayOfBasalRates) {
+	for _, day := range days {
+		if last := len(coalesced) - 1; last >= 0 && coalesced[last].StartTime.Equal(day.StartTime) {
+			coalesced[last] = apimodel.DayOfBasalRates{reconcileBasalRates(coalesced[last].BasalRates, day.BasalRates), coalesced[last].StartTime,
+				latestOf(coalesced[last].EndTime, day.EndTime)}
+		} else {
+			coalesced = append(coalesced, day)
+		}
+	}
+
+	return coalesced
+}
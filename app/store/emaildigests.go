@@ -0,0 +1,46 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"time"
+)
+
+var (
+	// ErrEmailDigestAlreadySent is returned when claiming the email digest of a week that was already sent to the user
+	ErrEmailDigestAlreadySent = StoreError{"store: email digest already sent", false}
+)
+
+// emailDigestKey returns the key of the email digest of the user for the week starting at weekStart. Digests are keyed
+// by the start of their week so that there's at most one per week.
+func emailDigestKey(context context.Context, userProfileKey *datastore.Key, weekStart time.Time) *datastore.Key {
+	return datastore.NewKey(context, "EmailDigest", "", weekStart.Unix(), userProfileKey)
+}
+
+// ClaimEmailDigest records the email digest of the user for its week before it's sent. ErrEmailDigestAlreadySent is
+// returned if the digest of that week was already claimed, in which case it must not be sent again.
+func ClaimEmailDigest(context context.Context, userProfileKey *datastore.Key, digest model.EmailDigest) (err error) {
+	key := emailDigestKey(context, userProfileKey, digest.WeekStart)
+	return runInTransaction(context, "ClaimEmailDigest", emailDigestClaimer(key, digest))
+}
+
+// emailDigestClaimer returns the transaction function that stores the email digest unless it already exists
+func emailDigestClaimer(key *datastore.Key, digest model.EmailDigest) func(context context.Context) error {
+	return func(context context.Context) error {
+		if err := get(context, key, new(model.EmailDigest)); err == nil {
+			return ErrEmailDigestAlreadySent
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		_, err := put(context, key, &digest)
+		return err
+	}
+}
+
+// ReleaseEmailDigest deletes the claim of the email digest of the user for the week starting at weekStart so that a
+// digest that couldn't be sent is sent by the retry of its task
+func ReleaseEmailDigest(context context.Context, userProfileKey *datastore.Key, weekStart time.Time) (err error) {
+	return deleteMulti(context, []*datastore.Key{emailDigestKey(context, userProfileKey, weekStart)})
+}
@@ -76,6 +76,137 @@ func TestEndToEndMergeOfReadBatches(t *testing.T) {
 	}
 }
 
+func TestEndToEndDeviceScopedReads(t *testing.T) {
+	c, key := setup(t)
+	defer c.Close()
+
+	chunkStart, _ := time.Parse("02/01/2006 15:04", "18/04/2015 01:00")
+	for _, deviceId := range []string{"SM12345678", "LIBRE123"} {
+		s := streaming.NewGlucoseStreamerDuration(bufio.NewGlucoseReadWriterSize(store.NewDataStoreDeviceGlucoseReadBatchWriter(c, key, deviceId), 5), apimodel.DAY_OF_DATA_DURATION)
+		r := make([]apimodel.GlucoseRead, 10)
+		for i := 0; i < 10; i++ {
+			readTime := chunkStart.Add(time.Duration(i) * time.Hour)
+			r[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(i)}
+		}
+		s, _ = s.WriteGlucoseReads(r)
+		s, _ = s.Close()
+	}
+
+	lowerBound, _ := time.Parse("02/01/2006 15:04", "18/04/2015 00:00")
+	upperBound, _ := time.Parse("02/01/2006 15:04", "20/04/2015 02:00")
+	reads, err := store.GetGlucoseReads(c, TEST_USER, lowerBound, upperBound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reads) != 20 {
+		t.Errorf("Expected [20] reads merged across devices but got [%d]", len(reads))
+	}
+
+	reads, err = store.GetGlucoseReadsForDevice(c, TEST_USER, "LIBRE123", lowerBound, upperBound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reads) != 10 {
+		t.Errorf("Expected [10] reads for device [LIBRE123] but got [%d]", len(reads))
+	}
+}
+
+// overlappingFileReads returns the reads of two files that overlap by 5 hours. The reads of the overlap have
+// different values in each file.
+func overlappingFileReads() (first, second []apimodel.GlucoseRead) {
+	firstStart, _ := time.Parse("02/01/2006 15:04", "18/04/2015 01:00")
+	secondStart := firstStart.Add(time.Duration(20) * time.Hour)
+	first = make([]apimodel.GlucoseRead, 25)
+	second = make([]apimodel.GlucoseRead, 25)
+	for i := 0; i < 25; i++ {
+		first[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(firstStart.Add(time.Duration(i) * time.Hour)), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(100 + i)}
+		second[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(secondStart.Add(time.Duration(i) * time.Hour)), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(200 + i)}
+	}
+
+	return first, second
+}
+
+// importInOrder stores the files of reads in the given order, each through its own streamer like separate file
+// imports do, and returns all stored reads
+func importInOrder(t *testing.T, files ...[]apimodel.GlucoseRead) []apimodel.GlucoseRead {
+	c, key := setup(t)
+	defer c.Close()
+
+	for _, reads := range files {
+		s := streaming.NewGlucoseStreamerDuration(bufio.NewGlucoseReadWriterSize(store.NewDataStoreGlucoseReadBatchWriter(c, key), 5), apimodel.DAY_OF_DATA_DURATION)
+		s, _ = s.WriteGlucoseReads(reads)
+		if _, err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lowerBound, _ := time.Parse("02/01/2006 15:04", "18/04/2015 00:00")
+	upperBound, _ := time.Parse("02/01/2006 15:04", "21/04/2015 00:00")
+	reads, err := store.GetGlucoseReads(c, TEST_USER, lowerBound, upperBound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return reads
+}
+
+func TestEndToEndDeviceScopedCalibrations(t *testing.T) {
+	c, key := setup(t)
+	defer c.Close()
+
+	chunkStart, _ := time.Parse("02/01/2006 15:04", "18/04/2015 01:00")
+	for deviceIndex, deviceId := range []string{"SM12345678", "LIBRE123"} {
+		s := streaming.NewCalibrationReadStreamerDuration(bufio.NewCalibrationWriterSize(store.NewDataStoreDeviceCalibrationBatchWriter(c, key, deviceId), 5), apimodel.DAY_OF_DATA_DURATION)
+		r := make([]apimodel.CalibrationRead, 10)
+		for i := 0; i < 10; i++ {
+			readTime := chunkStart.Add(time.Duration(i) * time.Hour)
+			r[i] = apimodel.CalibrationRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(100 + deviceIndex)}
+		}
+		s, _ = s.WriteCalibrations(r)
+		s, _ = s.Close()
+	}
+
+	lowerBound, _ := time.Parse("02/01/2006 15:04", "18/04/2015 00:00")
+	upperBound, _ := time.Parse("02/01/2006 15:04", "20/04/2015 02:00")
+	calibrations, err := store.GetCalibrations(c, TEST_USER, lowerBound, upperBound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(calibrations) != 20 {
+		t.Fatalf("Expected [20] calibrations of both devices but got [%d]: %v", len(calibrations), calibrations)
+	}
+
+	for i := 1; i < len(calibrations); i++ {
+		if calibrations[i].Time.Timestamp < calibrations[i-1].Time.Timestamp {
+			t.Errorf("Expected calibrations of both devices in chronological order but got [%v] after [%v]", calibrations[i], calibrations[i-1])
+		}
+	}
+}
+
+func TestEndToEndOverlappingImportsInEitherOrder(t *testing.T) {
+	first, second := overlappingFileReads()
+
+	inOrder := importInOrder(t, first, second)
+	inReverseOrder := importInOrder(t, second, first)
+
+	if len(inOrder) != 45 {
+		t.Errorf("Expected [45] reads but got [%d]: %v", len(inOrder), inOrder)
+	}
+
+	if len(inOrder) != len(inReverseOrder) {
+		t.Fatalf("Expected the same reads regardless of the order of imports but got [%d] and [%d] reads", len(inOrder), len(inReverseOrder))
+	}
+
+	for i := range inOrder {
+		if inOrder[i] != inReverseOrder[i] {
+			t.Errorf("Expected the same read at index [%d] regardless of the order of imports but got [%v] and [%v]", i, inOrder[i], inReverseOrder[i])
+		}
+	}
+}
+
 func TestEndToEndMergeOfCalibrationBatches(t *testing.T) {
 	c, key := setup(t)
 	defer c.Close()
@@ -156,7 +287,7 @@ func TestEndToEndMergeOfInjectionBatches(t *testing.T) {
 	firstChunkStart, _ := time.Parse("02/01/2006 15:04", "18/04/2015 01:00")
 	for i := 0; i < 25; i++ {
 		readTime := firstChunkStart.Add(time.Duration(i) * time.Hour)
-		r[i] = apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus"}
+		r[i] = apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false}
 	}
 	s, _ = s.WriteInjections(r)
 	s, _ = s.Flush()
@@ -188,7 +319,7 @@ func TestEndToEndMergeOfInjectionBatches(t *testing.T) {
 	r = make([]apimodel.Injection, 25)
 	for i := 0; i < 25; i++ {
 		readTime := secondChunkStart.Add(time.Duration(i) * time.Hour)
-		r[i] = apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus"}
+		r[i] = apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false}
 	}
 	s, _ = s.WriteInjections(r)
 	s, _ = s.Flush()
@@ -223,7 +354,7 @@ func TestEndToEndMergeOfExerciseBatches(t *testing.T) {
 	firstChunkStart, _ := time.Parse("02/01/2006 15:04", "18/04/2015 01:00")
 	for i := 0; i < 25; i++ {
 		readTime := firstChunkStart.Add(time.Duration(i) * time.Hour)
-		r[i] = apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details"}
+		r[i] = apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details", "", "", false, false, ""}
 	}
 	s, _ = s.WriteExercises(r)
 	s, _ = s.Flush()
@@ -255,7 +386,7 @@ func TestEndToEndMergeOfExerciseBatches(t *testing.T) {
 	r = make([]apimodel.Exercise, 25)
 	for i := 0; i < 25; i++ {
 		readTime := secondChunkStart.Add(time.Duration(i) * time.Hour)
-		r[i] = apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details"}
+		r[i] = apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details", "", "", false, false, ""}
 	}
 	s, _ = s.WriteExercises(r)
 	s, _ = s.Flush()
@@ -290,7 +421,7 @@ func TestEndToEndMergeOfMealBatches(t *testing.T) {
 	firstChunkStart, _ := time.Parse("02/01/2006 15:04", "18/04/2015 01:00")
 	for i := 0; i < 25; i++ {
 		readTime := firstChunkStart.Add(time.Duration(i) * time.Hour)
-		r[i] = apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3)}
+		r[i] = apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3), float32(i + 4), "", "", false, false}
 	}
 	s, _ = s.WriteMeals(r)
 	s, _ = s.Flush()
@@ -322,7 +453,7 @@ func TestEndToEndMergeOfMealBatches(t *testing.T) {
 	r = make([]apimodel.Meal, 25)
 	for i := 0; i < 25; i++ {
 		readTime := secondChunkStart.Add(time.Duration(i) * time.Hour)
-		r[i] = apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3)}
+		r[i] = apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3), float32(i + 4), "", "", false, false}
 	}
 	s, _ = s.WriteMeals(r)
 	s, _ = s.Flush()
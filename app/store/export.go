@@ -0,0 +1,171 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	// Kinds of records passed to the sink of ExportUserData
+	EXPORT_KIND_GLUCOSE_READS     = "glucosereads"
+	EXPORT_KIND_CALIBRATIONS      = "calibrations"
+	EXPORT_KIND_INJECTIONS        = "injections"
+	EXPORT_KIND_BASAL_RATES       = "basalrates"
+	EXPORT_KIND_MEALS             = "meals"
+	EXPORT_KIND_EXERCISES         = "exercises"
+	EXPORT_KIND_NOTES             = "notes"
+	EXPORT_KIND_FILE_IMPORT_LOGS  = "fileimports"
+	EXPORT_ENTITIES_PER_QUERY_RUN = 50
+)
+
+// exportedKind describes how to walk a datastore kind and extract the individual records of each of its entities
+type exportedKind struct {
+	name          string
+	datastoreKind string
+	orderProperty string
+	newEntity     func() interface{}
+	records       func(entity interface{}) []interface{}
+}
+
+var exportedKinds = []exportedKind{
+	exportedKind{EXPORT_KIND_GLUCOSE_READS, "DayOfReads", "startTime",
+		func() interface{} { return new(apimodel.DayOfGlucoseReads) },
+		func(entity interface{}) []interface{} {
+			reads := entity.(*apimodel.DayOfGlucoseReads).Reads
+			records := make([]interface{}, len(reads))
+			for i := range reads {
+				records[i] = reads[i]
+			}
+			return records
+		}},
+	exportedKind{EXPORT_KIND_CALIBRATIONS, "DayOfCalibrationReads", "startTime",
+		func() interface{} { return new(apimodel.DayOfCalibrationReads) },
+		func(entity interface{}) []interface{} {
+			calibrations := entity.(*apimodel.DayOfCalibrationReads).Reads
+			records := make([]interface{}, len(calibrations))
+			for i := range calibrations {
+				records[i] = calibrations[i]
+			}
+			return records
+		}},
+	exportedKind{EXPORT_KIND_INJECTIONS, "DayOfInjections", "startTime",
+		func() interface{} { return new(apimodel.DayOfInjections) },
+		func(entity interface{}) []interface{} {
+			injections := entity.(*apimodel.DayOfInjections).Injections
+			records := make([]interface{}, len(injections))
+			for i := range injections {
+				records[i] = injections[i]
+			}
+			return records
+		}},
+	exportedKind{EXPORT_KIND_BASAL_RATES, "DayOfBasalRates", "startTime",
+		func() interface{} { return new(apimodel.DayOfBasalRates) },
+		func(entity interface{}) []interface{} {
+			basalRates := entity.(*apimodel.DayOfBasalRates).BasalRates
+			records := make([]interface{}, len(basalRates))
+			for i := range basalRates {
+				records[i] = basalRates[i]
+			}
+			return records
+		}},
+	exportedKind{EXPORT_KIND_MEALS, "DayOfMeals", "startTime",
+		func() interface{} { return new(apimodel.DayOfMeals) },
+		func(entity interface{}) []interface{} {
+			meals := entity.(*apimodel.DayOfMeals).Meals
+			records := make([]interface{}, len(meals))
+			for i := range meals {
+				records[i] = meals[i]
+			}
+			return records
+		}},
+	exportedKind{EXPORT_KIND_EXERCISES, "DayOfExercises", "startTime",
+		func() interface{} { return new(apimodel.DayOfExercises) },
+		func(entity interface{}) []interface{} {
+			exercises := entity.(*apimodel.DayOfExercises).Exercises
+			records := make([]interface{}, len(exercises))
+			for i := range exercises {
+				records[i] = exercises[i]
+			}
+			return records
+		}},
+	exportedKind{EXPORT_KIND_NOTES, "DayOfNotes", "startTime",
+		func() interface{} { return new(apimodel.DayOfNotes) },
+		func(entity interface{}) []interface{} {
+			notes := entity.(*apimodel.DayOfNotes).Notes
+			records := make([]interface{}, len(notes))
+			for i := range notes {
+				records[i] = notes[i]
+			}
+			return records
+		}},
+	exportedKind{EXPORT_KIND_FILE_IMPORT_LOGS, "FileImportLog", "LastDataProcessed",
+		func() interface{} { return new(model.FileImportLog) },
+		func(entity interface{}) []interface{} {
+			return []interface{}{*entity.(*model.FileImportLog)}
+		}},
+}
+
+// ExportUserData walks all the data of a user, one kind at a time and in chronological order, and streams every record to the
+// sink. Records are read in small batches using cursors so that the data doesn't need to fit in memory. If the sink returns an
+// error, the export stops and returns that error. The number of records exported for each kind is returned when done.
+func ExportUserData(context context.Context, email string, sink func(kind string, record interface{}) error) (recordCounts map[string]int, err error) {
+	key := GetUserKey(context, email)
+	recordCounts = make(map[string]int)
+
+	for _, kind := range exportedKinds {
+		count, err := exportKind(context, key, kind, sink)
+		recordCounts[kind.name] = count
+		if err != nil {
+			log.Warningf(context, "Error exporting [%s] for user [%s] after [%d] records: %v", kind.name, email, count, err)
+			return recordCounts, err
+		}
+
+		log.Infof(context, "Exported [%d] records of [%s] for user [%s]", count, kind.name, email)
+	}
+
+	return recordCounts, nil
+}
+
+func exportKind(context context.Context, userProfileKey *datastore.Key, kind exportedKind, sink func(kind string, record interface{}) error) (count int, err error) {
+	query := datastore.NewQuery(kind.datastoreKind).Ancestor(userProfileKey).Order(kind.orderProperty)
+
+	var cursor *datastore.Cursor
+	for {
+		batchQuery := query.Limit(EXPORT_ENTITIES_PER_QUERY_RUN)
+		if cursor != nil {
+			batchQuery = batchQuery.Start(*cursor)
+		}
+
+		iterator := batchQuery.Run(context)
+		entities := 0
+		entity := kind.newEntity()
+		for _, err = iterator.Next(entity); err == nil; _, err = iterator.Next(entity) {
+			entities++
+			for _, record := range kind.records(entity) {
+				if err := sink(kind.name, record); err != nil {
+					return count, err
+				}
+				count++
+			}
+			entity = kind.newEntity()
+		}
+
+		if err != datastore.Done {
+			return count, err
+		}
+
+		// A partial batch means we've reached the end
+		if entities < EXPORT_ENTITIES_PER_QUERY_RUN {
+			return count, nil
+		}
+
+		nextCursor, err := iterator.Cursor()
+		if err != nil {
+			return count, err
+		}
+		cursor = &nextCursor
+	}
+}
@@ -0,0 +1,77 @@
+package store_test
+
+import (
+	"errors"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/store"
+	"testing"
+	"time"
+)
+
+func TestExportUserDataStreamsAllRecordsInChronologicalOrder(t *testing.T) {
+	c, key := setup(t)
+	defer c.Close()
+
+	// More days than a single query run returns so that the export has to continue from a cursor
+	days := EXPORT_ENTITIES_PER_QUERY_RUN + 10
+	start := time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+	daysOfReads := make([]apimodel.DayOfGlucoseReads, days)
+	for i := range daysOfReads {
+		readTime := start.AddDate(0, 0, i)
+		daysOfReads[i] = apimodel.NewDayOfGlucoseReads([]apimodel.GlucoseRead{
+			apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, apimodel.MG_PER_DL, float32(100 + i)}})
+	}
+
+	if _, _, err := StoreDaysOfReads(c, key, apimodel.DEFAULT_DEVICE_ID, daysOfReads); err != nil {
+		t.Fatal(err)
+	}
+
+	meal := apimodel.Meal{Time: apimodel.Time{apimodel.GetTimeMillis(start), "UTC"}, Carbs: 45}
+	if _, err := StoreDaysOfMeals(c, key, []apimodel.DayOfMeals{apimodel.NewDayOfMeals([]apimodel.Meal{meal})}); err != nil {
+		t.Fatal(err)
+	}
+
+	var lastTimestamp int64
+	counts, err := ExportUserData(c, TEST_USER, func(kind string, record interface{}) error {
+		if read, ok := record.(apimodel.GlucoseRead); ok {
+			if read.Time.Timestamp < lastTimestamp {
+				t.Errorf("Expected reads in chronological order but got [%v] after timestamp [%d]", read, lastTimestamp)
+			}
+			lastTimestamp = read.Time.Timestamp
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if counts[EXPORT_KIND_GLUCOSE_READS] != days || counts[EXPORT_KIND_MEALS] != 1 || counts[EXPORT_KIND_INJECTIONS] != 0 {
+		t.Errorf("Expected [%d] reads, [1] meal and no injections exported but got %v", days, counts)
+	}
+}
+
+func TestExportUserDataStopsAtSinkError(t *testing.T) {
+	c, key := setup(t)
+	defer c.Close()
+
+	readTime := time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+	reads := []apimodel.GlucoseRead{
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, apimodel.MG_PER_DL, 100},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime.Add(5 * time.Minute)), "UTC"}, apimodel.MG_PER_DL, 110}}
+	if _, _, err := StoreDaysOfReads(c, key, apimodel.DEFAULT_DEVICE_ID, []apimodel.DayOfGlucoseReads{apimodel.NewDayOfGlucoseReads(reads)}); err != nil {
+		t.Fatal(err)
+	}
+
+	sinkErr := errors.New("disk full")
+	counts, err := ExportUserData(c, TEST_USER, func(kind string, record interface{}) error {
+		return sinkErr
+	})
+
+	if err != sinkErr {
+		t.Errorf("Expected the error of the sink but got [%v]", err)
+	}
+
+	if _, exported := counts[EXPORT_KIND_MEALS]; exported {
+		t.Errorf("Expected the export to stop at the first kind but got counts %v", counts)
+	}
+}
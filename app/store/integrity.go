@@ -0,0 +1,215 @@
+package store
+
+import (
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+const (
+	// Maximum number of issues described in an integrity report, the counts of issues are always complete
+	MAX_INTEGRITY_ISSUES = 100
+
+	// Maximum number of the most recent file import logs checked against the stored reads
+	INTEGRITY_FILE_IMPORT_LOGS_LIMIT = 500
+)
+
+// CheckReadsIntegrity checks the days of reads of the user having data between lowerBound and upperBound, along with the
+// user's most recent read and file import watermarks, and stores the resulting report. With repair, days of reads of
+// the same device that overlap are merged into the earliest of them the same way StoreDaysOfReads merges days.
+func CheckReadsIntegrity(context context.Context, email string, lowerBound time.Time, upperBound time.Time, repair bool) (report *model.IntegrityReport, err error) {
+	userProfileKey := GetUserKey(context, email)
+	userProfile, err := GetUserProfile(context, userProfileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	scanStart, scanEnd, err := getScanBoundaries(context, userProfileKey, "DayOfReads", lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+	query := datastore.NewQuery("DayOfReads").Ancestor(userProfileKey).Filter("startTime >=", scanStart).Filter("startTime <=", scanEnd).Order("startTime")
+
+	days := make([]apimodel.DayOfGlucoseReads, 0)
+	keys, err := query.GetAll(context, &days)
+	if err != nil {
+		return nil, err
+	}
+
+	report = &model.IntegrityReport{UserEmail: email, LowerBound: lowerBound, UpperBound: upperBound, CheckedOn: time.Now(),
+		Repair: repair, Issues: make([]string, 0)}
+	overlapping := CheckDaysOfReads(days, report)
+
+	report.MostRecentRead = userProfile.MostRecentRead.GetTime()
+	if report.LatestStoredRead.After(report.MostRecentRead) {
+		report.StaleMostRecentRead = true
+		addIntegrityIssue(report, "Most recent read of the profile at [%s] is older than the stored read at [%s]",
+			report.MostRecentRead.Format(util.TIMEFORMAT), report.LatestStoredRead.Format(util.TIMEFORMAT))
+	}
+
+	fileImports, err := GetFileImportLogs(context, userProfileKey, INTEGRITY_FILE_IMPORT_LOGS_LIMIT)
+	if err != nil {
+		return nil, err
+	}
+	CheckFileImportWatermarks(fileImports, report)
+
+	if repair {
+		for _, group := range overlapping {
+			groupKeys := make([]*datastore.Key, len(group))
+			for i, index := range group {
+				groupKeys[i] = keys[index]
+			}
+
+			if err := runInTransaction(context, "RepairOverlappingDaysOfReads", overlappingDaysOfReadsMerger(groupKeys)); err != nil {
+				return nil, err
+			}
+			report.RepairedDays += len(group) - 1
+		}
+	}
+
+	if _, err := put(context, datastore.NewIncompleteKey(context, "IntegrityReport", nil), report); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Checked integrity of [%d] days of reads of user [%s] and repaired [%d]: %v", report.DaysChecked, email,
+		report.RepairedDays, report.HasIssues())
+	return report, nil
+}
+
+// CheckDaysOfReads checks days of reads, sorted by start time, for days of the same device that overlap, reads with
+// the same timestamp as another one of their device and reads outside of the bounds of their day. Issues are added to
+// the report along with the time of the latest read. The indices of days that overlap are returned in groups, each
+// starting with the earliest day the other ones overlap with.
+func CheckDaysOfReads(days []apimodel.DayOfGlucoseReads, report *model.IntegrityReport) (overlapping [][]int) {
+	groups := make([][]int, 0)
+	groupOfDevice := make(map[string]int)
+	groupEndOfDevice := make(map[string]time.Time)
+	timestampsOfDevice := make(map[string]map[int64]bool)
+
+	report.DaysChecked += len(days)
+	for i, day := range days {
+		if group, exists := groupOfDevice[day.DeviceId]; exists && day.StartTime.Before(groupEndOfDevice[day.DeviceId]) {
+			first := days[groups[group][0]]
+			addIntegrityIssue(report, "Day of reads of device [%s] starting at [%s] overlaps with the one from [%s] to [%s]",
+				day.DeviceId, day.StartTime.Format(util.TIMEFORMAT), first.StartTime.Format(util.TIMEFORMAT),
+				groupEndOfDevice[day.DeviceId].Format(util.TIMEFORMAT))
+			report.OverlappingDays++
+			groups[group] = append(groups[group], i)
+			groupEndOfDevice[day.DeviceId] = latestOf(groupEndOfDevice[day.DeviceId], day.EndTime)
+		} else {
+			groupOfDevice[day.DeviceId] = len(groups)
+			groups = append(groups, []int{i})
+			groupEndOfDevice[day.DeviceId] = day.EndTime
+		}
+
+		timestamps, exists := timestampsOfDevice[day.DeviceId]
+		if !exists {
+			timestamps = make(map[int64]bool)
+			timestampsOfDevice[day.DeviceId] = timestamps
+		}
+
+		for _, read := range day.Reads {
+			readTime := read.GetTime()
+			if timestamps[read.Time.Timestamp] {
+				addIntegrityIssue(report, "Duplicate read of device [%s] at [%s]", day.DeviceId, readTime.Format(util.TIMEFORMAT))
+				report.DuplicateReads++
+			}
+			timestamps[read.Time.Timestamp] = true
+
+			if readTime.Before(day.StartTime) || readTime.After(day.EndTime) {
+				addIntegrityIssue(report, "Read of device [%s] at [%s] is outside of its day from [%s] to [%s]", day.DeviceId,
+					readTime.Format(util.TIMEFORMAT), day.StartTime.Format(util.TIMEFORMAT), day.EndTime.Format(util.TIMEFORMAT))
+				report.ReadsOutOfBounds++
+			}
+
+			if readTime.After(report.LatestStoredRead) {
+				report.LatestStoredRead = readTime
+			}
+		}
+	}
+
+	overlapping = make([][]int, 0)
+	for _, group := range groups {
+		if len(group) > 1 {
+			overlapping = append(overlapping, group)
+		}
+	}
+
+	return overlapping
+}
+
+// CheckFileImportWatermarks adds to the report the file imports, within the bounds of the report, that processed data
+// up to a time past the latest stored read of the report
+func CheckFileImportWatermarks(fileImports []model.FileImportLog, report *model.IntegrityReport) {
+	for _, fileImport := range fileImports {
+		watermark := fileImport.LastDataProcessed
+		if watermark.Before(report.LowerBound) || watermark.After(report.UpperBound) || !watermark.After(report.LatestStoredRead) {
+			continue
+		}
+
+		addIntegrityIssue(report, "Import of file [%s] processed data up to [%s], past the latest stored read at [%s]",
+			fileImport.Id, watermark.Format(util.TIMEFORMAT), report.LatestStoredRead.Format(util.TIMEFORMAT))
+		report.FileImportsAhead++
+	}
+}
+
+func addIntegrityIssue(report *model.IntegrityReport, format string, args ...interface{}) {
+	if len(report.Issues) < MAX_INTEGRITY_ISSUES {
+		report.Issues = append(report.Issues, fmt.Sprintf(format, args...))
+	}
+}
+
+// overlappingDaysOfReadsMerger returns the transaction function that merges the days of reads of keys into the first
+// one and deletes the other ones
+func overlappingDaysOfReadsMerger(keys []*datastore.Key) func(context context.Context) error {
+	return func(context context.Context) error {
+		days := make([]apimodel.DayOfGlucoseReads, len(keys))
+		if err := getMulti(context, keys, days); err != nil {
+			return err
+		}
+
+		merged := days[0]
+		for _, day := range days[1:] {
+			merged = apimodel.DayOfGlucoseReads{reconcileReads(merged.Reads, day.Reads), merged.StartTime,
+				latestOf(merged.EndTime, day.EndTime), merged.DeviceId}
+		}
+
+		if _, err := put(context, keys[0], &merged); err != nil {
+			return err
+		}
+
+		if err := recordDataSpan(context, keys[0].Parent(), "DayOfReads", merged.EndTime.Sub(merged.StartTime)); err != nil {
+			return err
+		}
+
+		return deleteMulti(context, keys[1:])
+	}
+}
+
+// GetIntegrityReports returns up to limit of the most recent integrity reports, of the given user if userEmail isn't
+// empty, along with their ids
+func GetIntegrityReports(context context.Context, userEmail string, limit int) (ids []int64, reports []model.IntegrityReport, err error) {
+	query := datastore.NewQuery("IntegrityReport")
+	if len(userEmail) > 0 {
+		query = query.Filter("userEmail =", userEmail)
+	}
+	query = query.Order("-checkedOn").Limit(limit)
+
+	reports = make([]model.IntegrityReport, 0)
+	keys, err := query.GetAll(context, &reports)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids = make([]int64, len(keys))
+	for i := range keys {
+		ids[i] = keys[i].IntID()
+	}
+
+	return ids, reports, nil
+}
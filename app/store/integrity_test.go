@@ -0,0 +1,77 @@
+package store_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	. "github.com/alexandre-normand/glukit/app/store"
+	"testing"
+	"time"
+)
+
+func readAt(t time.Time, value float32) apimodel.GlucoseRead {
+	return apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(t), "UTC"}, apimodel.MG_PER_DL, value}
+}
+
+func TestCheckDaysOfReadsFindsOverlappingDaysOfSameDevice(t *testing.T) {
+	start := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	days := []apimodel.DayOfGlucoseReads{
+		apimodel.DayOfGlucoseReads{[]apimodel.GlucoseRead{readAt(start, 100), readAt(start.Add(20*time.Hour), 110)}, start, start.Add(20 * time.Hour), ""},
+		apimodel.DayOfGlucoseReads{[]apimodel.GlucoseRead{readAt(start.Add(2*time.Hour), 90)}, start.Add(2 * time.Hour), start.Add(2 * time.Hour), "receiver"},
+		apimodel.DayOfGlucoseReads{[]apimodel.GlucoseRead{readAt(start.Add(19*time.Hour), 120), readAt(start.Add(20*time.Hour), 110)}, start.Add(19 * time.Hour), start.Add(30 * time.Hour), ""},
+		apimodel.DayOfGlucoseReads{[]apimodel.GlucoseRead{readAt(start.Add(25*time.Hour), 130)}, start.Add(25 * time.Hour), start.Add(31 * time.Hour), ""},
+	}
+
+	report := model.IntegrityReport{}
+	overlapping := CheckDaysOfReads(days, &report)
+
+	if len(overlapping) != 1 || len(overlapping[0]) != 3 || overlapping[0][0] != 0 || overlapping[0][1] != 2 || overlapping[0][2] != 3 {
+		t.Fatalf("Expected days [0 2 3] to overlap but got [%v]", overlapping)
+	}
+
+	if report.DaysChecked != 4 || report.OverlappingDays != 2 {
+		t.Errorf("Expected [4] days checked and [2] overlapping but got [%d] and [%d]", report.DaysChecked, report.OverlappingDays)
+	}
+
+	if report.DuplicateReads != 1 {
+		t.Errorf("Expected [1] duplicate read but got [%d]", report.DuplicateReads)
+	}
+
+	if !report.LatestStoredRead.Equal(start.Add(25 * time.Hour)) {
+		t.Errorf("Expected latest stored read at [%s] but got [%s]", start.Add(25*time.Hour), report.LatestStoredRead)
+	}
+}
+
+func TestCheckDaysOfReadsFindsReadsOutOfBounds(t *testing.T) {
+	start := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	days := []apimodel.DayOfGlucoseReads{
+		apimodel.DayOfGlucoseReads{[]apimodel.GlucoseRead{readAt(start.Add(-time.Minute), 100), readAt(start, 105), readAt(start.Add(6*time.Hour), 110)}, start, start.Add(5 * time.Hour), ""},
+	}
+
+	report := model.IntegrityReport{}
+	if overlapping := CheckDaysOfReads(days, &report); len(overlapping) != 0 {
+		t.Errorf("Expected no overlapping days but got [%v]", overlapping)
+	}
+
+	if report.ReadsOutOfBounds != 2 || len(report.Issues) != 2 {
+		t.Errorf("Expected [2] reads out of bounds described as issues but got [%d] and issues [%v]", report.ReadsOutOfBounds, report.Issues)
+	}
+
+	if !report.HasIssues() {
+		t.Errorf("Expected report to have issues")
+	}
+}
+
+func TestCheckFileImportWatermarks(t *testing.T) {
+	start := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	report := model.IntegrityReport{LowerBound: start, UpperBound: start.Add(48 * time.Hour), LatestStoredRead: start.Add(24 * time.Hour)}
+	fileImports := []model.FileImportLog{
+		model.FileImportLog{Id: "ahead", LastDataProcessed: start.Add(30 * time.Hour)},
+		model.FileImportLog{Id: "caughtUp", LastDataProcessed: start.Add(24 * time.Hour)},
+		model.FileImportLog{Id: "outOfBounds", LastDataProcessed: start.Add(72 * time.Hour)},
+	}
+
+	CheckFileImportWatermarks(fileImports, &report)
+	if report.FileImportsAhead != 1 {
+		t.Errorf("Expected [1] file import ahead of stored reads but got [%d]: %v", report.FileImportsAhead, report.Issues)
+	}
+}
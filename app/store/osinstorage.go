@@ -121,14 +121,14 @@ func (s *OsinAppEngineStore) addClient(c *osin.Client, context context.Context)
 	log.Debugf(context, "AddClient: %s...\n", c.Id)
 	key := datastore.NewKey(context, "osin.client", c.Id, 0, nil)
 	client := new(oClient)
-	err := datastore.Get(context, key, client)
+	err := get(context, key, client)
 
 	if err == nil || err != datastore.ErrNoSuchEntity {
 		log.Debugf(context, "Client [%s] already stored, skipping.\n", c.Id)
 		return nil
 	}
 
-	_, err = datastore.Put(context, key, newInternalClient(c))
+	_, err = put(context, key, newInternalClient(c))
 
 	if err != nil {
 		log.Warningf(context, "Error storing client [%s]: %v", c.Id, err)
@@ -163,7 +163,7 @@ func (s *OsinAppEngineStore) GetClientWithContext(id string, context context.Con
 	log.Debugf(context, "GetClient: %s\n", id)
 	key := datastore.NewKey(context, "osin.client", id, 0, nil)
 	client := new(oClient)
-	err := datastore.Get(context, key, client)
+	err := get(context, key, client)
 
 	if err != nil {
 		log.Warningf(context, "Error looking up client by id [%s]: [%v]", id, err)
@@ -201,7 +201,7 @@ func (s *OsinAppEngineStore) SaveAuthorize(data *osin.AuthorizeData, r *http.Req
 func (s *OsinAppEngineStore) SaveAuthorizeWithContext(data *osin.AuthorizeData, context context.Context) error {
 	log.Debugf(context, "SaveAuthorize: %s\n", data.Code)
 	key := datastore.NewKey(context, "authorize.data", data.Code, 0, nil)
-	_, err := datastore.Put(context, key, newInternalAuthorizeData(data))
+	_, err := put(context, key, newInternalAuthorizeData(data))
 	if err != nil {
 		log.Warningf(context, "Error saving authorize data [%s]: [%v]", data.Code, err)
 		return err
@@ -219,7 +219,7 @@ func (s *OsinAppEngineStore) LoadAuthorizeWithContext(code string, context conte
 	log.Debugf(context, "LoadAuthorize: %s\n", code)
 	key := datastore.NewKey(context, "authorize.data", code, 0, nil)
 	authorizeData := new(oAuthorizeData)
-	err := datastore.Get(context, key, authorizeData)
+	err := get(context, key, authorizeData)
 	if err != nil {
 		log.Infof(context, "Authorization data not found for code [%s]: %v", code, err)
 		return nil, errors.New("Authorize not found")
@@ -291,14 +291,14 @@ func (s *OsinAppEngineStore) SaveAccessWithContext(data *osin.AccessData, contex
 	log.Debugf(context, "SaveAccess [%s]: [%v]\n", data.AccessToken, data)
 	key := datastore.NewKey(context, "access.data", data.AccessToken, 0, nil)
 	internalAccessData := newInternalAccessData(data)
-	_, err := datastore.Put(context, key, internalAccessData)
+	_, err := put(context, key, internalAccessData)
 	if err != nil {
 		return err
 	}
 
 	if data.RefreshToken != "" {
 		key = datastore.NewKey(context, "access.refresh", data.RefreshToken, 0, nil)
-		_, err := datastore.Put(context, key, internalAccessData)
+		_, err := put(context, key, internalAccessData)
 		if err != nil {
 			return err
 		}
@@ -315,7 +315,7 @@ func (s *OsinAppEngineStore) LoadAccessWithContext(code string, context context.
 	log.Debugf(context, "LoadAccess: %s\n", code)
 	key := datastore.NewKey(context, "access.data", code, 0, nil)
 	accessData := new(oAccessData)
-	err := datastore.Get(context, key, accessData)
+	err := get(context, key, accessData)
 	if err != nil {
 		log.Infof(context, "Access data not found for code [%s]: %v", code, err)
 		return nil, errors.New("Access data not found")
@@ -374,7 +374,7 @@ func (s *OsinAppEngineStore) LoadRefreshWithContext(code string, context context
 	log.Debugf(context, "LoadRefresh: %s\n", code)
 	key := datastore.NewKey(context, "access.refresh", code, 0, nil)
 	accessData := new(oAccessData)
-	err := datastore.Get(context, key, accessData)
+	err := get(context, key, accessData)
 	if err != nil {
 		log.Infof(context, "Refresh data not found for code [%s]: %v", code, err)
 		errors.New("Refresh not found")
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// RetryPolicy defines how many times and how long to wait between attempts of a datastore operation that
+// fails with a transient error. The wait doubles on every attempt (up to MaxBackoff) and is randomized with
+// up to 50% of jitter to avoid having concurrent tasks retry in lockstep.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Operation is a single datastore call that can be attempted multiple times.
+type Operation func() error
+
+var (
+	// DefaultRetryPolicy is the policy used for all datastore calls of the store package
+	DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second}
+
+	// Substrings of appengine API errors that indicate a transient failure of the datastore
+	transientErrorMarkers = []string{"TIMEOUT", "INTERNAL_ERROR", "internal error", "CONCURRENT_TRANSACTION"}
+)
+
+// IsTransientError returns true if the error is one the datastore could recover from if the operation is attempted again
+// (timeout, internal error, concurrent transaction). Permanent errors such as invalid keys or entities are never transient.
+// A MultiError is considered transient if any of its element errors is.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if multiErr, ok := err.(appengine.MultiError); ok {
+		for _, elementErr := range multiErr {
+			if IsTransientError(elementErr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch err {
+	case datastore.ErrNoSuchEntity, datastore.ErrInvalidEntityType, datastore.ErrInvalidKey:
+		return false
+	case datastore.ErrConcurrentTransaction:
+		return true
+	}
+
+	if appengine.IsTimeoutError(err) {
+		return true
+	}
+
+	if operationErr, ok := err.(OperationError); ok {
+		return IsTransientError(operationErr.Err)
+	}
+
+	if storeErr, ok := err.(StoreError); ok {
+		return storeErr.Temporary
+	}
+
+	message := err.Error()
+	for _, marker := range transientErrorMarkers {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RetryWithPolicy runs the operation until it succeeds, fails with a permanent error or the policy's maximum number of
+// attempts is reached. The error of the last attempt is returned.
+func RetryWithPolicy(context context.Context, policy RetryPolicy, description string, op Operation) (err error) {
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err = op()
+		if err == nil {
+			if attempt > 1 {
+				log.Infof(context, "Datastore operation [%s] succeeded after [%d] attempts", description, attempt)
+			}
+			return nil
+		}
+
+		if !IsTransientError(err) {
+			if attempt > 1 {
+				log.Infof(context, "Datastore operation [%s] failed permanently after [%d] attempts: %v", description, attempt, err)
+			}
+			return err
+		}
+
+		if attempt >= policy.MaxAttempts {
+			log.Infof(context, "Datastore operation [%s] failed after exhausting all [%d] attempts: %v", description, attempt, err)
+			return err
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		log.Infof(context, "Transient error on datastore operation [%s] (attempt [%d] of [%d]), retrying in [%v]: %v", description, attempt, policy.MaxAttempts, wait, err)
+		time.Sleep(wait)
+
+		backoff = backoff * 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// withRetry runs the operation with the DefaultRetryPolicy
+func withRetry(context context.Context, description string, op Operation) error {
+	return RetryWithPolicy(context, DefaultRetryPolicy, description, op)
+}
+
+// runInTransaction runs the function in a transaction. The whole transaction is attempted again with the
+// DefaultRetryPolicy if it fails because of concurrent transactions on the same entity group.
+func runInTransaction(context context.Context, description string, f func(context context.Context) error) error {
+	return withRetry(context, description, func() error {
+		return datastore.RunInTransaction(context, f, nil)
+	})
+}
+
+// runInCrossGroupTransaction runs the function in a transaction like runInTransaction does but allows it to span
+// entity groups
+func runInCrossGroupTransaction(context context.Context, description string, f func(context context.Context) error) error {
+	return withRetry(context, description, func() error {
+		return datastore.RunInTransaction(context, f, &datastore.TransactionOptions{XG: true})
+	})
+}
+
+func put(context context.Context, key *datastore.Key, src interface{}) (storedKey *datastore.Key, err error) {
+	err = withRetry(context, "Put", func() (err error) {
+		storedKey, err = datastore.Put(context, key, src)
+		return err
+	})
+
+	return storedKey, err
+}
+
+func get(context context.Context, key *datastore.Key, dst interface{}) (err error) {
+	return withRetry(context, "Get", func() error {
+		return datastore.Get(context, key, dst)
+	})
+}
+
+func putMulti(context context.Context, keys []*datastore.Key, src interface{}) (storedKeys []*datastore.Key, err error) {
+	err = withRetry(context, "PutMulti", func() (err error) {
+		storedKeys, err = datastore.PutMulti(context, keys, src)
+		return err
+	})
+
+	return storedKeys, err
+}
+
+func getMulti(context context.Context, keys []*datastore.Key, dst interface{}) (err error) {
+	return withRetry(context, "GetMulti", func() error {
+		return datastore.GetMulti(context, keys, dst)
+	})
+}
+
+func deleteMulti(context context.Context, keys []*datastore.Key) (err error) {
+	return withRetry(context, "DeleteMulti", func() error {
+		return datastore.DeleteMulti(context, keys)
+	})
+}
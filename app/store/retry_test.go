@@ -0,0 +1,87 @@
+package store_test
+
+import (
+	"errors"
+	. "github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/datastore"
+	"testing"
+)
+
+var noWaitPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: 0, MaxBackoff: 0}
+
+// failingOperation returns an Operation that fails with err for the first failures attempts and then succeeds
+func failingOperation(failures int, err error, attempts *int) Operation {
+	return func() error {
+		*attempts++
+		if *attempts <= failures {
+			return err
+		}
+
+		return nil
+	}
+}
+
+func TestRetrySucceedsAfterTransientErrors(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	attempts := 0
+	err = RetryWithPolicy(c, noWaitPolicy, "test", failingOperation(2, errors.New("API error 5 (datastore_v3: TIMEOUT): The datastore operation timed out"), &attempts))
+	if err != nil {
+		t.Errorf("Expected success after retries but got error: %v", err)
+	}
+
+	if attempts != 3 {
+		t.Errorf("Expected [3] attempts but got [%d]", attempts)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	attempts := 0
+	err = RetryWithPolicy(c, noWaitPolicy, "test", failingOperation(5, datastore.ErrConcurrentTransaction, &attempts))
+	if err != datastore.ErrConcurrentTransaction {
+		t.Errorf("Expected [%v] but got [%v]", datastore.ErrConcurrentTransaction, err)
+	}
+
+	if attempts != 3 {
+		t.Errorf("Expected [3] attempts but got [%d]", attempts)
+	}
+}
+
+func TestRetryPassesThroughPermanentErrors(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	attempts := 0
+	err = RetryWithPolicy(c, noWaitPolicy, "test", failingOperation(5, datastore.ErrInvalidKey, &attempts))
+	if err != datastore.ErrInvalidKey {
+		t.Errorf("Expected [%v] but got [%v]", datastore.ErrInvalidKey, err)
+	}
+
+	if attempts != 1 {
+		t.Errorf("Expected a single attempt but got [%d]", attempts)
+	}
+}
+
+func TestOperationErrorsAreTransientIfTheirCauseIs(t *testing.T) {
+	if !IsTransientError(OperationError{"getting reads", datastore.ErrConcurrentTransaction}) {
+		t.Errorf("Expected an operation that failed with [%v] to be transient", datastore.ErrConcurrentTransaction)
+	}
+
+	if IsTransientError(OperationError{"getting reads", datastore.ErrInvalidKey}) {
+		t.Errorf("Expected an operation that failed with [%v] to be permanent", datastore.ErrInvalidKey)
+	}
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+const (
+	// Minimum time range queries on day of data elements are padded with. Days are normally truncated to
+	// DAY_OF_DATA_DURATION but a merged day can end up covering a bit more when local time shifts across a DST change.
+	// Elements spanning more than that are recorded, see recordDataSpan, so that scans cover them too.
+	MAX_DAY_OF_DATA_SPAN = apimodel.DAY_OF_DATA_DURATION + time.Duration(2)*time.Hour
+)
+
+// ScanWindow defines how much padding to add to the bounds of a range query on day of data elements. Since elements
+// are keyed and queried by their startTime, the scan needs to start early enough to include an element that starts
+// before the lower bound but still has data inside the requested range.
+type ScanWindow struct {
+	MaxEntitySpan time.Duration
+}
+
+// DefaultScanWindow is the ScanWindow of range queries on elements of a kind for which no element longer than
+// MAX_DAY_OF_DATA_SPAN was stored
+var DefaultScanWindow = ScanWindow{MAX_DAY_OF_DATA_SPAN}
+
+// Boundaries returns the inclusive boundaries of startTime values to scan to get all elements having data between the
+// lower and upper bounds. Elements starting after the upper bound can't have data in range so the end isn't padded.
+func (w ScanWindow) Boundaries(lowerBound, upperBound time.Time) (scanStart, scanEnd time.Time) {
+	return lowerBound.Add(-w.MaxEntitySpan), upperBound
+}
+
+// Covers returns true if an element spanning from startTime to endTime is guaranteed to be found by scans using this window
+func (w ScanWindow) Covers(startTime, endTime time.Time) bool {
+	return endTime.Sub(startTime) <= w.MaxEntitySpan
+}
+
+// dataSpan is the longest span, from its start time to its end time, of the day of data elements of a kind stored for
+// a user
+type dataSpan struct {
+	MaxSpan time.Duration `datastore:"maxSpan,noindex"`
+}
+
+// dataSpanKey returns the key of the dataSpan of the elements of the kind stored under the user profile
+func dataSpanKey(context context.Context, userProfileKey *datastore.Key, kind string) *datastore.Key {
+	return datastore.NewKey(context, "DataSpan", kind, 0, userProfileKey)
+}
+
+// GetScanWindow returns the ScanWindow of range queries on the elements of the kind stored under the user profile,
+// padded with the longest span of the elements stored, if longer than the one of the DefaultScanWindow
+func GetScanWindow(context context.Context, userProfileKey *datastore.Key, kind string) (window ScanWindow, err error) {
+	span := new(dataSpan)
+	if err := get(context, dataSpanKey(context, userProfileKey, kind), span); err == datastore.ErrNoSuchEntity {
+		return DefaultScanWindow, nil
+	} else if err != nil {
+		return window, err
+	}
+
+	if span.MaxSpan > DefaultScanWindow.MaxEntitySpan {
+		return ScanWindow{span.MaxSpan}, nil
+	}
+
+	return DefaultScanWindow, nil
+}
+
+// getScanBoundaries returns the boundaries of the scan of the elements of the kind stored under the user profile having
+// data between the lower and upper bounds, see GetScanWindow
+func getScanBoundaries(context context.Context, userProfileKey *datastore.Key, kind string, lowerBound, upperBound time.Time) (scanStart, scanEnd time.Time, err error) {
+	window, err := GetScanWindow(context, userProfileKey, kind)
+	if err != nil {
+		return scanStart, scanEnd, err
+	}
+
+	scanStart, scanEnd = window.Boundaries(lowerBound, upperBound)
+	return scanStart, scanEnd, nil
+}
+
+// recordDataSpan records longest as the span of the longest element of the kind stored under the user profile if the
+// DefaultScanWindow doesn't cover it and it's longer than the one recorded. It's meant to be called in the transaction
+// storing the elements, the dataSpan being in the entity group of the user.
+func recordDataSpan(context context.Context, userProfileKey *datastore.Key, kind string, longest time.Duration) error {
+	if longest <= DefaultScanWindow.MaxEntitySpan {
+		return nil
+	}
+
+	key := dataSpanKey(context, userProfileKey, kind)
+	span := new(dataSpan)
+	if err := get(context, key, span); err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+
+	if span.MaxSpan >= longest {
+		return nil
+	}
+
+	log.Infof(context, "Element of kind [%s] spans [%v], which exceeds the scan window of [%v], recording it as the longest span",
+		kind, longest, DefaultScanWindow.MaxEntitySpan)
+	span.MaxSpan = longest
+	_, err := put(context, key, span)
+	return err
+}
+
+// longestSpan returns the longest of longest and the span from startTime to endTime
+func longestSpan(longest time.Duration, startTime, endTime time.Time) time.Duration {
+	if span := endTime.Sub(startTime); span > longest {
+		return span
+	}
+
+	return longest
+}
+
+// withDataSpan returns the transaction function that runs storer and records longest as the span of the longest
+// element of the kind it stores under the user profile, see recordDataSpan
+func withDataSpan(userProfileKey *datastore.Key, kind string, longest time.Duration, storer func(context context.Context) error) func(context context.Context) error {
+	return func(context context.Context) error {
+		if err := storer(context); err != nil {
+			return err
+		}
+
+		return recordDataSpan(context, userProfileKey, kind, longest)
+	}
+}
@@ -0,0 +1,99 @@
+package store_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"testing"
+	"time"
+)
+
+func TestScanWindowWithElementSpanningDSTChange(t *testing.T) {
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Daylight saving time started on March 8th 2015 at 2:00 in Los Angeles. This element spans 26 hours.
+	startTime := time.Date(2015, time.March, 7, 0, 0, 0, 0, location)
+	endTime := startTime.Add(time.Duration(26) * time.Hour)
+
+	if !DefaultScanWindow.Covers(startTime, endTime) {
+		t.Errorf("Expected default scan window to cover element spanning from [%s] to [%s]", startTime, endTime)
+	}
+
+	// A query for the last hour of the element must still include its start time
+	lowerBound := endTime.Add(time.Duration(-1) * time.Hour)
+	upperBound := endTime.Add(time.Duration(2) * time.Hour)
+	scanStart, scanEnd := DefaultScanWindow.Boundaries(lowerBound, upperBound)
+
+	if scanStart.After(startTime) {
+		t.Errorf("Scan starting at [%s] misses element starting at [%s]", scanStart, startTime)
+	}
+
+	if !scanEnd.Equal(upperBound) {
+		t.Errorf("Expected scan end to be [%s] but got [%s]", upperBound, scanEnd)
+	}
+}
+
+func TestScanWindowWithExplicitSpan(t *testing.T) {
+	w := ScanWindow{time.Duration(2) * time.Hour}
+	lowerBound := time.Date(2015, time.April, 18, 12, 0, 0, 0, time.UTC)
+	upperBound := lowerBound.Add(time.Duration(2) * time.Hour)
+
+	scanStart, scanEnd := w.Boundaries(lowerBound, upperBound)
+	if !scanStart.Equal(lowerBound.Add(time.Duration(-2) * time.Hour)) {
+		t.Errorf("Expected scan start to be padded by [2h] but got [%s]", scanStart)
+	}
+
+	if !scanEnd.Equal(upperBound) {
+		t.Errorf("Expected scan end to be [%s] but got [%s]", upperBound, scanEnd)
+	}
+
+	if w.Covers(lowerBound, lowerBound.Add(time.Duration(24)*time.Hour)) {
+		t.Errorf("Expected [2h] scan window to not cover an element spanning [24h]")
+	}
+}
+
+func TestScanWindowWithStoredElementLongerThanDefault(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	email := "longday@glukit.com"
+	userProfileKey := GetUserKey(c, email)
+
+	// This element spans 30 hours, longer than what the default scan window covers
+	startTime := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	endTime := startTime.Add(time.Duration(30) * time.Hour)
+	dayOfReads := apimodel.DayOfGlucoseReads{Reads: []apimodel.GlucoseRead{readAt(startTime, 100), readAt(endTime, 120)},
+		StartTime: startTime, EndTime: endTime}
+	if _, _, err := StoreDaysOfReads(c, userProfileKey, apimodel.DEFAULT_DEVICE_ID, []apimodel.DayOfGlucoseReads{dayOfReads}); err != nil {
+		t.Fatal(err)
+	}
+
+	window, err := GetScanWindow(c, userProfileKey, "DayOfReads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if window.MaxEntitySpan < endTime.Sub(startTime) {
+		t.Errorf("Expected scan window to cover [%s] but got [%s]", endTime.Sub(startTime), window.MaxEntitySpan)
+	}
+
+	// A query for the last hour of the element must still find its reads
+	reads, err := GetGlucoseReads(c, email, endTime.Add(-time.Hour), endTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reads) != 1 || reads[0].Value != 120 {
+		t.Errorf("Expected the last read of the element but got [%v]", reads)
+	}
+
+	if window, err := GetScanWindow(c, userProfileKey, "DayOfMeals"); err != nil || window != DefaultScanWindow {
+		t.Errorf("Expected default scan window for kind without any long element but got [%v] and [%v]", window, err)
+	}
+}
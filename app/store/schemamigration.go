@@ -0,0 +1,91 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	// Number of entities checked by each call to MigrateDayEntities
+	SCHEMA_MIGRATION_ENTITIES_PER_BATCH = 100
+)
+
+// MigrateDayEntities checks a batch of the day entities of kind of the user, starting at cursor (or the first one if
+// empty), and rewrites the ones stored with a schema version older than the current one. The cursor to continue from
+// is returned, or an empty one when all entities of the kind have been checked, along with the number of entities
+// that were rewritten.
+func MigrateDayEntities(context context.Context, email string, kind string, cursor string) (nextCursor string, migrated int, err error) {
+	query := datastore.NewQuery(kind).Ancestor(GetUserKey(context, email)).KeysOnly().Limit(SCHEMA_MIGRATION_ENTITIES_PER_BATCH)
+	if len(cursor) > 0 {
+		start, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return "", 0, err
+		}
+		query = query.Start(start)
+	}
+
+	iterator := query.Run(context)
+	entities := 0
+	var key *datastore.Key
+	for key, err = iterator.Next(nil); err == nil; key, err = iterator.Next(nil) {
+		entities++
+
+		upgraded := false
+		if err := runInTransaction(context, "MigrateDayEntity", dayEntityMigrator(key, kind, &upgraded)); err != nil {
+			return "", migrated, err
+		}
+
+		if upgraded {
+			migrated++
+		}
+	}
+
+	if err != datastore.Done {
+		return "", migrated, err
+	}
+
+	log.Infof(context, "Migrated [%d] of [%d] entities of [%s] for user [%s]", migrated, entities, kind, email)
+
+	// A partial batch means we've reached the end
+	if entities < SCHEMA_MIGRATION_ENTITIES_PER_BATCH {
+		return "", migrated, nil
+	}
+
+	next, err := iterator.Cursor()
+	if err != nil {
+		return "", migrated, err
+	}
+
+	return next.String(), migrated, nil
+}
+
+// dayEntityMigrator returns the transaction function that rewrites the entity of kind at key with the current schema
+// version if it's stored with an older one. upgraded is set to true if the entity was rewritten.
+func dayEntityMigrator(key *datastore.Key, kind string, upgraded *bool) func(context context.Context) error {
+	return func(context context.Context) error {
+		var properties datastore.PropertyList
+		if err := get(context, key, &properties); err != nil {
+			return err
+		}
+
+		if apimodel.SchemaVersionOf(properties) >= apimodel.CurrentSchemaVersion(kind) {
+			*upgraded = false
+			return nil
+		}
+
+		upgradedProperties, err := apimodel.UpgradeProperties(kind, properties)
+		if err != nil {
+			return err
+		}
+
+		stamped := datastore.PropertyList(apimodel.StampSchemaVersion(kind, upgradedProperties))
+		if _, err := put(context, key, &stamped); err != nil {
+			return err
+		}
+
+		*upgraded = true
+		return nil
+	}
+}
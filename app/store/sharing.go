@@ -0,0 +1,188 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrShareInvitationNotFound is returned when accepting an invitation that doesn't exist, was already accepted or
+	// revoked, expired or is addressed to another user
+	ErrShareInvitationNotFound = StoreError{"store: share invitation not found", false}
+
+	// ErrShareGrantNotFound is returned when a user has no grant, or only an expired one, to the data of another
+	ErrShareGrantNotFound = StoreError{"store: share grant not found", false}
+)
+
+// shareGrantKey returns the key of the grant of the owner to the grantee, grantees having at most one grant per owner
+func shareGrantKey(context context.Context, ownerKey *datastore.Key, granteeEmail string) *datastore.Key {
+	return datastore.NewKey(context, "ShareGrant", strings.ToLower(granteeEmail), 0, ownerKey)
+}
+
+// StoreShareInvitation stores a new invitation of the owner and returns its id
+func StoreShareInvitation(context context.Context, ownerKey *datastore.Key, invitation model.ShareInvitation) (id int64, err error) {
+	key, err := put(context, datastore.NewIncompleteKey(context, "ShareInvitation", ownerKey), &invitation)
+	if err != nil {
+		return 0, err
+	}
+
+	return key.IntID(), nil
+}
+
+// GetShareInvitations returns the invitations of the owner that are pending as of now along with their ids
+func GetShareInvitations(context context.Context, ownerKey *datastore.Key, now time.Time) (ids []int64, invitations []model.ShareInvitation, err error) {
+	all := make([]model.ShareInvitation, 0)
+	keys, err := datastore.NewQuery("ShareInvitation").Ancestor(ownerKey).GetAll(context, &all)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids = make([]int64, 0, len(keys))
+	invitations = make([]model.ShareInvitation, 0, len(keys))
+	for i := range keys {
+		if !all[i].IsExpired(now) {
+			ids = append(ids, keys[i].IntID())
+			invitations = append(invitations, all[i])
+		}
+	}
+
+	return ids, invitations, nil
+}
+
+// DeleteShareInvitation revokes the invitation of the owner with the given id. ErrShareInvitationNotFound is returned
+// if there's no such invitation.
+func DeleteShareInvitation(context context.Context, ownerKey *datastore.Key, id int64) (err error) {
+	key := datastore.NewKey(context, "ShareInvitation", "", id, ownerKey)
+	return runInTransaction(context, "DeleteShareInvitation", shareInvitationDeleter(key))
+}
+
+// shareInvitationDeleter returns the transaction function that deletes the invitation if it exists
+func shareInvitationDeleter(key *datastore.Key) func(context context.Context) error {
+	return func(context context.Context) error {
+		if err := get(context, key, new(model.ShareInvitation)); err == datastore.ErrNoSuchEntity {
+			return ErrShareInvitationNotFound
+		} else if err != nil {
+			return err
+		}
+
+		return deleteMulti(context, []*datastore.Key{key})
+	}
+}
+
+// AcceptShareInvitation accepts the invitation with the given token hash on behalf of the grantee, which must be the
+// invitee, and returns the grant replacing it. ErrShareInvitationNotFound is returned if there's no such invitation
+// for the grantee or if it expired as of now.
+func AcceptShareInvitation(context context.Context, tokenHash string, granteeEmail string, now time.Time) (grant *model.ShareGrant, err error) {
+	keys, err := datastore.NewQuery("ShareInvitation").Filter("tokenHash =", tokenHash).Limit(1).KeysOnly().GetAll(context, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrShareInvitationNotFound
+	}
+
+	grant = new(model.ShareGrant)
+	if err := runInTransaction(context, "AcceptShareInvitation", shareInvitationAcceptor(keys[0], granteeEmail, now, grant)); err != nil {
+		return nil, err
+	}
+
+	return grant, nil
+}
+
+// shareInvitationAcceptor returns the transaction function that replaces the invitation with a grant to the grantee,
+// set on grant
+func shareInvitationAcceptor(key *datastore.Key, granteeEmail string, now time.Time, grant *model.ShareGrant) func(context context.Context) error {
+	return func(context context.Context) error {
+		invitation := new(model.ShareInvitation)
+		if err := get(context, key, invitation); err == datastore.ErrNoSuchEntity {
+			return ErrShareInvitationNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if !strings.EqualFold(invitation.InviteeEmail, granteeEmail) || invitation.IsExpired(now) {
+			return ErrShareInvitationNotFound
+		}
+
+		*grant = model.ShareGrant{OwnerEmail: key.Parent().StringID(), GranteeEmail: strings.ToLower(granteeEmail),
+			Permission: invitation.Permission, ExpiresAt: invitation.ExpiresAt, CreatedAt: now}
+		if _, err := put(context, shareGrantKey(context, key.Parent(), granteeEmail), grant); err != nil {
+			return err
+		}
+
+		return deleteMulti(context, []*datastore.Key{key})
+	}
+}
+
+// GetShareGrants returns the grants of the owner that haven't expired as of now
+func GetShareGrants(context context.Context, ownerKey *datastore.Key, now time.Time) (grants []model.ShareGrant, err error) {
+	grants = make([]model.ShareGrant, 0)
+	if _, err := datastore.NewQuery("ShareGrant").Ancestor(ownerKey).GetAll(context, &grants); err != nil {
+		return nil, err
+	}
+
+	return filterExpiredGrants(grants, now), nil
+}
+
+// GetGrantsTo returns the grants to the grantee that haven't expired as of now
+func GetGrantsTo(context context.Context, granteeEmail string, now time.Time) (grants []model.ShareGrant, err error) {
+	grants = make([]model.ShareGrant, 0)
+	query := datastore.NewQuery("ShareGrant").Filter("granteeEmail =", strings.ToLower(granteeEmail))
+	if _, err := query.GetAll(context, &grants); err != nil {
+		return nil, err
+	}
+
+	return filterExpiredGrants(grants, now), nil
+}
+
+// GetShareGrant returns the grant of the owner to the grantee. ErrShareGrantNotFound is returned if there's no such
+// grant or if it expired as of now.
+func GetShareGrant(context context.Context, ownerKey *datastore.Key, granteeEmail string, now time.Time) (grant *model.ShareGrant, err error) {
+	grant = new(model.ShareGrant)
+	if err := get(context, shareGrantKey(context, ownerKey, granteeEmail), grant); err == datastore.ErrNoSuchEntity {
+		return nil, ErrShareGrantNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if grant.IsExpired(now) {
+		return nil, ErrShareGrantNotFound
+	}
+
+	return grant, nil
+}
+
+// DeleteShareGrant revokes the grant of the owner to the grantee. ErrShareGrantNotFound is returned if there's no
+// such grant.
+func DeleteShareGrant(context context.Context, ownerKey *datastore.Key, granteeEmail string) (err error) {
+	return runInTransaction(context, "DeleteShareGrant", shareGrantDeleter(shareGrantKey(context, ownerKey, granteeEmail)))
+}
+
+// shareGrantDeleter returns the transaction function that deletes the grant if it exists
+func shareGrantDeleter(key *datastore.Key) func(context context.Context) error {
+	return func(context context.Context) error {
+		if err := get(context, key, new(model.ShareGrant)); err == datastore.ErrNoSuchEntity {
+			return ErrShareGrantNotFound
+		} else if err != nil {
+			return err
+		}
+
+		return deleteMulti(context, []*datastore.Key{key})
+	}
+}
+
+// filterExpiredGrants returns the grants that haven't expired as of now
+func filterExpiredGrants(grants []model.ShareGrant, now time.Time) []model.ShareGrant {
+	valid := make([]model.ShareGrant, 0, len(grants))
+	for _, grant := range grants {
+		if !grant.IsExpired(now) {
+			valid = append(valid, grant)
+		}
+	}
+
+	return valid
+}
@@ -0,0 +1,81 @@
+package store_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	. "github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"testing"
+	"time"
+)
+
+func TestShareInvitationCantBeAcceptedOnceExpired(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	now := time.Now()
+	ownerKey := GetUserKey(c, "owner@glukit.com")
+	token, invitation, err := model.NewShareInvitation("invitee@glukit.com", model.SHARE_PERMISSION_READ_ONLY, time.Time{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := StoreShareInvitation(c, ownerKey, invitation); err != nil {
+		t.Fatal(err)
+	}
+
+	expiredAt := now.Add(model.SHARE_INVITATION_VALIDITY)
+	if ids, _, err := GetShareInvitations(c, ownerKey, expiredAt); err != nil || len(ids) != 0 {
+		t.Errorf("Expected no pending invitation once expired but got [%v] and [%v]", ids, err)
+	}
+
+	if _, err := AcceptShareInvitation(c, model.HashShareInvitationToken(token), "invitee@glukit.com", expiredAt); err != ErrShareInvitationNotFound {
+		t.Errorf("Expected expired invitation not to be found but got [%v]", err)
+	}
+
+	if _, err := AcceptShareInvitation(c, model.HashShareInvitationToken(token), "invitee@glukit.com", now); err != nil {
+		t.Errorf("Expected invitation to be accepted before it expires but got [%v]", err)
+	}
+}
+
+func TestRevokedShareInvitationCantBeAccepted(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	now := time.Now()
+	ownerKey := GetUserKey(c, "owner@glukit.com")
+	token, invitation, err := model.NewShareInvitation("invitee@glukit.com", model.SHARE_PERMISSION_READ_ONLY, time.Time{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := StoreShareInvitation(c, ownerKey, invitation); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, invitations, err := GetShareInvitations(c, ownerKey, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 1 || invitations[0].InviteeEmail != "invitee@glukit.com" {
+		t.Fatalf("Expected the pending invitation to [invitee@glukit.com] but got [%v]", invitations)
+	}
+
+	if err := DeleteShareInvitation(c, ownerKey, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteShareInvitation(c, ownerKey, ids[0]); err != ErrShareInvitationNotFound {
+		t.Errorf("Expected revoked invitation not to be found again but got [%v]", err)
+	}
+
+	if _, err := AcceptShareInvitation(c, model.HashShareInvitationToken(token), "invitee@glukit.com", now); err != ErrShareInvitationNotFound {
+		t.Errorf("Expected revoked invitation not to be accepted but got [%v]", err)
+	}
+}
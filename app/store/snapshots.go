@@ -0,0 +1,118 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"time"
+)
+
+var (
+	// ErrSnapshotNotFound is returned when a snapshot to show or revoke doesn't exist or expired
+	ErrSnapshotNotFound = StoreError{"store: snapshot not found", false}
+
+	// ErrSnapshotLimitReached is returned when storing a snapshot of a user who already has the maximum number of them
+	ErrSnapshotLimitReached = StoreError{"store: snapshot limit reached", false}
+)
+
+// StoreSnapshot stores a new snapshot of the user and returns its id. The snapshots of the user that expired as of now
+// are deleted and ErrSnapshotLimitReached is returned if the user still has maxSnapshots of them.
+func StoreSnapshot(context context.Context, userProfileKey *datastore.Key, snapshot model.Snapshot, maxSnapshots int,
+	now time.Time) (id int64, err error) {
+	err = runInTransaction(context, "StoreSnapshot", snapshotStorer(userProfileKey, snapshot, maxSnapshots, now, &id))
+	return id, err
+}
+
+// snapshotStorer returns the transaction function that purges the expired snapshots of the user and stores the new
+// one, setting its id, if the user doesn't have maxSnapshots already
+func snapshotStorer(userProfileKey *datastore.Key, snapshot model.Snapshot, maxSnapshots int, now time.Time, id *int64) func(context context.Context) error {
+	return func(context context.Context) error {
+		snapshots := make([]model.Snapshot, 0)
+		keys, err := datastore.NewQuery("Snapshot").Ancestor(userProfileKey).GetAll(context, &snapshots)
+		if err != nil {
+			return err
+		}
+
+		expired := make([]*datastore.Key, 0)
+		for i := range snapshots {
+			if snapshots[i].IsExpired(now) {
+				expired = append(expired, keys[i])
+			}
+		}
+
+		if len(expired) > 0 {
+			if err := deleteMulti(context, expired); err != nil {
+				return err
+			}
+		}
+
+		if len(keys)-len(expired) >= maxSnapshots {
+			return ErrSnapshotLimitReached
+		}
+
+		key, err := put(context, datastore.NewIncompleteKey(context, "Snapshot", userProfileKey), &snapshot)
+		if err != nil {
+			return err
+		}
+
+		*id = key.IntID()
+		return nil
+	}
+}
+
+// GetSnapshots returns the snapshots of the user that haven't expired as of now, oldest first, along with their ids
+func GetSnapshots(context context.Context, userProfileKey *datastore.Key, now time.Time) (ids []int64, snapshots []model.Snapshot, err error) {
+	query := datastore.NewQuery("Snapshot").Ancestor(userProfileKey).Order("createdAt")
+
+	all := make([]model.Snapshot, 0)
+	keys, err := query.GetAll(context, &all)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids = make([]int64, 0, len(keys))
+	snapshots = make([]model.Snapshot, 0, len(keys))
+	for i := range keys {
+		if !all[i].IsExpired(now) {
+			ids = append(ids, keys[i].IntID())
+			snapshots = append(snapshots, all[i])
+		}
+	}
+
+	return ids, snapshots, nil
+}
+
+// FindSnapshot returns the snapshot with the given token. ErrSnapshotNotFound is returned if there's no such snapshot
+// or if it expired as of now.
+func FindSnapshot(context context.Context, token string, now time.Time) (snapshot *model.Snapshot, err error) {
+	snapshots := make([]model.Snapshot, 0)
+	if _, err := datastore.NewQuery("Snapshot").Filter("token =", token).Limit(1).GetAll(context, &snapshots); err != nil {
+		return nil, err
+	}
+
+	if len(snapshots) == 0 || snapshots[0].IsExpired(now) {
+		return nil, ErrSnapshotNotFound
+	}
+
+	return &snapshots[0], nil
+}
+
+// DeleteSnapshot revokes the snapshot of the user with the given id. ErrSnapshotNotFound is returned if the user has no
+// such snapshot.
+func DeleteSnapshot(context context.Context, userProfileKey *datastore.Key, id int64) (err error) {
+	key := datastore.NewKey(context, "Snapshot", "", id, userProfileKey)
+	return runInTransaction(context, "DeleteSnapshot", snapshotDeleter(key))
+}
+
+// snapshotDeleter returns the transaction function that deletes the snapshot if it exists
+func snapshotDeleter(key *datastore.Key) func(context context.Context) error {
+	return func(context context.Context) error {
+		if err := get(context, key, new(model.Snapshot)); err == datastore.ErrNoSuchEntity {
+			return ErrSnapshotNotFound
+		} else if err != nil {
+			return err
+		}
+
+		return deleteMulti(context, []*datastore.Key{key})
+	}
+}
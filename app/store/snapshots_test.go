@@ -0,0 +1,87 @@
+package store_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	. "github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"testing"
+	"time"
+)
+
+func newTestSnapshot(t *testing.T, now time.Time, expiresAt time.Time) model.Snapshot {
+	snapshot, err := model.NewSnapshot("checkup", now.AddDate(0, 0, -1), now, apimodel.MG_PER_DL, model.SnapshotContent{}, now, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return snapshot
+}
+
+func TestFindSnapshotByToken(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	now := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	snapshot := newTestSnapshot(t, now, now.Add(time.Hour))
+	if _, err := StoreSnapshot(c, GetUserKey(c, "snapshots@glukit.com"), snapshot, model.MAX_SNAPSHOTS_PER_USER, now); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := FindSnapshot(c, snapshot.Token, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Label != snapshot.Label || !found.LowerBound.Equal(snapshot.LowerBound) {
+		t.Errorf("Expected snapshot [%s] but got [%v]", snapshot.Label, found)
+	}
+
+	if _, err := FindSnapshot(c, snapshot.Token+"0", now); err != ErrSnapshotNotFound {
+		t.Errorf("Expected [%v] for an unknown token but got [%v]", ErrSnapshotNotFound, err)
+	}
+
+	if _, err := FindSnapshot(c, snapshot.Token, snapshot.ExpiresAt); err != ErrSnapshotNotFound {
+		t.Errorf("Expected [%v] once the snapshot expired but got [%v]", ErrSnapshotNotFound, err)
+	}
+}
+
+func TestSnapshotsPerUserAreCapped(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	userProfileKey := GetUserKey(c, "snapshots@glukit.com")
+	now := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+
+	// One of the snapshots expires an hour from now, making room for another once it's purged
+	for i := 0; i < model.MAX_SNAPSHOTS_PER_USER; i++ {
+		expiresAt := now.Add(model.DEFAULT_SNAPSHOT_EXPIRY)
+		if i == 0 {
+			expiresAt = now.Add(time.Hour)
+		}
+
+		if _, err := StoreSnapshot(c, userProfileKey, newTestSnapshot(t, now, expiresAt), model.MAX_SNAPSHOTS_PER_USER, now); err != nil {
+			t.Fatalf("Expected snapshot [%d] to be stored but got [%v]", i+1, err)
+		}
+	}
+
+	extra := newTestSnapshot(t, now, now.Add(model.DEFAULT_SNAPSHOT_EXPIRY))
+	if _, err := StoreSnapshot(c, userProfileKey, extra, model.MAX_SNAPSHOTS_PER_USER, now); err != ErrSnapshotLimitReached {
+		t.Errorf("Expected [%v] storing snapshot [%d] but got [%v]", ErrSnapshotLimitReached, model.MAX_SNAPSHOTS_PER_USER+1, err)
+	}
+
+	later := now.Add(time.Hour)
+	if _, err := StoreSnapshot(c, userProfileKey, extra, model.MAX_SNAPSHOTS_PER_USER, later); err != nil {
+		t.Errorf("Expected snapshot to be stored once one expired but got [%v]", err)
+	}
+
+	if ids, _, err := GetSnapshots(c, userProfileKey, later); err != nil || len(ids) != model.MAX_SNAPSHOTS_PER_USER {
+		t.Errorf("Expected [%d] snapshots but got [%d] with [%v]", model.MAX_SNAPSHOTS_PER_USER, len(ids), err)
+	}
+}
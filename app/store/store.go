@@ -2,6 +2,7 @@
 package store
 
 import (
+	"fmt"
 	"github.com/alexandre-normand/glukit/app/apimodel"
 	"github.com/alexandre-normand/glukit/app/container"
 	"github.com/alexandre-normand/glukit/app/model"
@@ -10,6 +11,7 @@ import (
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/memcache"
 	"math"
 	"sort"
 	"time"
@@ -18,6 +20,9 @@ import (
 const (
 	// Number of GlukitScores to batch in a single PutMulti
 	GLUKIT_SCORE_PUT_MULTI_SIZE = 10
+
+	// Number of entities deleted by a single DeleteMulti when deleting all of the data of a user, the datastore limit
+	DELETE_BATCH_SIZE = 500
 )
 
 // Error interface to distinguish between temporary errors from permanent ones
@@ -30,6 +35,28 @@ func (e StoreError) Error() string {
 	return e.msg
 }
 
+// OperationError is the error of a datastore operation along with what it was operating on, the user and range of data
+// or key involved, so that failures surfacing in handlers and tasks say what failed. IsTransientError looks at the
+// error of the operation.
+type OperationError struct {
+	Op  string
+	Err error
+}
+
+func (e OperationError) Error() string {
+	return fmt.Sprintf("store: %s: %v", e.Op, e.Err)
+}
+
+// operationError returns the error wrapped in an OperationError described by the format and its arguments
+func operationError(err error, format string, args ...interface{}) error {
+	return OperationError{fmt.Sprintf(format, args...), err}
+}
+
+// rangeOf describes the range of data of the user operated on in errors
+func rangeOf(email string, lowerBound time.Time, upperBound time.Time) string {
+	return fmt.Sprintf("user [%s] from [%s] to [%s]", email, lowerBound.Format(util.TIMEFORMAT), upperBound.Format(util.TIMEFORMAT))
+}
+
 type ScoreScanQuery struct {
 	Limit *int
 	From  *time.Time
@@ -42,6 +69,19 @@ var (
 
 	// ErrNoSteadySailorMatchFound is returned when the user doesn't have any steady sailor matching his profile
 	ErrNoSteadySailorMatchFound = StoreError{"store: no match for a steady sailor found", true}
+
+	// ErrRecalculationInProgress is returned when a recalculation is requested while another one is still running for the same user
+	ErrRecalculationInProgress = StoreError{"store: a recalculation is already in progress", true}
+
+	// ErrRefreshInProgress is returned when the refresh lease of a user is held by another refresh
+	ErrRefreshInProgress = StoreError{"store: a refresh is already in progress", true}
+	// ErrNoImportSlot is returned when all the import slots of a user are held by other file imports
+	ErrNoImportSlot = StoreError{"store: no import slot available", true}
+
+	// ErrNoteNotFound is returned when a note to update or delete doesn't exist
+	ErrNoteNotFound = StoreError{"store: note not found", false}
+	// ErrEventNotFound is returned when a meal, injection or exercise to update or delete doesn't exist
+	ErrEventNotFound = StoreError{"store: event not found", false}
 )
 
 // GetUserKey returns the GlukitUser datastore key given its email address.
@@ -50,13 +90,19 @@ func GetUserKey(context context.Context, email string) (key *datastore.Key) {
 }
 
 // StoreUserProfile stores a GlukitUser profile to the datastore. If the entry already exists, it is overriden and it is created
-// otherwise
+// otherwise. The cached copy of the profile is invalidated before the write and refreshed after it so that a stale profile
+// (i.e. one with an oauth token that was just refreshed) is never served from the cache.
 func StoreUserProfile(context context.Context, updatedAt time.Time, userProfile model.GlukitUser) (key *datastore.Key, err error) {
-	key, error := datastore.Put(context, GetUserKey(context, userProfile.Email), &userProfile)
-	if error != nil {
-		util.Propagate(error)
+	key = GetUserKey(context, userProfile.Email)
+	invalidateCachedUserProfile(context, key)
+
+	key, err = put(context, key, &userProfile)
+	if err != nil {
+		return nil, operationError(err, "storing profile of user [%s]", userProfile.Email)
 	}
 
+	cacheUserProfile(context, key, &userProfile)
+
 	return key, nil
 }
 
@@ -65,22 +111,87 @@ func StoreUserProfile(context context.Context, updatedAt time.Time, userProfile
 func GetUserProfile(context context.Context, key *datastore.Key) (userProfile *model.GlukitUser, err error) {
 	userProfile = new(model.GlukitUser)
 	log.Infof(context, "Fetching user profile for key: %s", key.String())
-	error := datastore.Get(context, key, userProfile)
+	error := get(context, key, userProfile)
 	if error != nil {
 		return nil, error
 	}
 
+	userProfile.SetDefaultTargetRange()
 	return userProfile, nil
 }
 
+// GetUserProfileCached returns the GlukitUser entry associated with the given datastore key from memcache, if present. On a
+// cache miss, the profile is read from the datastore with GetUserProfile and added to the cache. Cached profiles keep their
+// tokens sealed, like stored ones, and they are only decrypted once read back.
+func GetUserProfileCached(context context.Context, key *datastore.Key) (userProfile *model.GlukitUser, err error) {
+	userProfile = new(model.GlukitUser)
+	if _, err := memcache.Gob.Get(context, userProfileCacheKey(key), userProfile); err == nil {
+		userProfile.OpenTokens(time.Now())
+		userProfile.SetDefaultTargetRange()
+		userProfile.SetDefaultClinicalProfile()
+		return userProfile, nil
+	} else if err != memcache.ErrCacheMiss {
+		log.Warningf(context, "Error reading cached user profile for key [%s], falling back to datastore: %v", key.String(), err)
+	}
+
+	userProfile, err = GetUserProfile(context, key)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheUserProfile(context, key, userProfile)
+	return userProfile, nil
+}
+
+func userProfileCacheKey(key *datastore.Key) string {
+	return "GlukitUser:" + key.Encode()
+}
+
+// cacheUserProfile adds the profile to memcache with its tokens sealed so that they are never cached in plaintext. A
+// profile whose tokens can't be sealed isn't cached.
+func cacheUserProfile(context context.Context, key *datastore.Key, userProfile *model.GlukitUser) {
+	sealed, err := userProfile.WithSealedTokens()
+	if err != nil {
+		log.Warningf(context, "Error sealing the tokens of user profile for key [%s], not caching it: %v", key.String(), err)
+		return
+	}
+
+	item := &memcache.Item{Key: userProfileCacheKey(key), Object: &sealed}
+	if err := memcache.Gob.Set(context, item); err != nil {
+		log.Warningf(context, "Error caching user profile for key [%s]: %v", key.String(), err)
+	}
+}
+
+func invalidateCachedUserProfile(context context.Context, key *datastore.Key) {
+	if err := memcache.Delete(context, userProfileCacheKey(key)); err != nil && err != memcache.ErrCacheMiss {
+		log.Warningf(context, "Error invalidating cached user profile for key [%s]: %v", key.String(), err)
+	}
+}
+
 // GetGlucoseReads returns all GlucoseReads given a user's email address and the time boundaries. Not that the boundaries are both inclusive.
+// Reads from all devices are merged together.
 func GetGlucoseReads(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (reads []apimodel.GlucoseRead, err error) {
+	return getGlucoseReads(context, email, lowerBound, upperBound, func(dayOfReads *apimodel.DayOfGlucoseReads) bool {
+		return true
+	})
+}
+
+// GetGlucoseReadsForDevice returns the GlucoseReads of a single device given a user's email address and the time boundaries. An empty
+// deviceId selects the reads of the default device. Not that the boundaries are both inclusive.
+func GetGlucoseReadsForDevice(context context.Context, email string, deviceId string, lowerBound time.Time, upperBound time.Time) (reads []apimodel.GlucoseRead, err error) {
+	return getGlucoseReads(context, email, lowerBound, upperBound, func(dayOfReads *apimodel.DayOfGlucoseReads) bool {
+		return dayOfReads.DeviceId == deviceId
+	})
+}
+
+func getGlucoseReads(context context.Context, email string, lowerBound time.Time, upperBound time.Time, include func(dayOfReads *apimodel.DayOfGlucoseReads) bool) (reads []apimodel.GlucoseRead, err error) {
 	key := GetUserKey(context, email)
 
-	// Scan start should be one day prior and scan end should be one day later so that we can capture the day using
-	// a single column inequality filter. The scan should actually capture at least one day and a maximum of 3
-	scanStart := lowerBound.Add(time.Duration(-24 * time.Hour))
-	scanEnd := upperBound.Add(time.Duration(24 * time.Hour))
+	// Pad the scan so that we can capture the days overlapping with the bounds using a single column inequality filter
+	scanStart, scanEnd, err := getScanBoundaries(context, key, "DayOfReads", lowerBound, upperBound)
+	if err != nil {
+		return nil, operationError(err, "getting reads of %s", rangeOf(email, lowerBound, upperBound))
+	}
 
 	log.Infof(context, "Scanning for reads between %s and %s to get reads between %s and %s", scanStart, scanEnd, lowerBound, upperBound)
 
@@ -89,74 +200,293 @@ func GetGlucoseReads(context context.Context, email string, lowerBound time.Time
 	readsForPeriod := make([]apimodel.GlucoseRead, 0)
 
 	iterator := query.Run(context)
-	for _, err := iterator.Next(daysOfReads); err == nil; _, err = iterator.Next(daysOfReads) {
-		log.Debugf(context, "Loaded batch of %d reads...", len(daysOfReads.Reads))
-		readsForPeriod = mergeGlucoseReadArrays(readsForPeriod, daysOfReads.Reads)
+	for _, err = iterator.Next(daysOfReads); err == nil; _, err = iterator.Next(daysOfReads) {
+		if include(daysOfReads) {
+			log.Debugf(context, "Loaded batch of %d reads for device [%s]...", len(daysOfReads.Reads), daysOfReads.DeviceId)
+			readsForPeriod = mergeGlucoseReadArrays(readsForPeriod, daysOfReads.Reads)
+		}
 		daysOfReads = new(apimodel.DayOfGlucoseReads)
 	}
 
+	if err != datastore.Done {
+		return nil, operationError(err, "getting reads of %s", rangeOf(email, lowerBound, upperBound))
+	}
+
+	// Days of different devices start at the same time so their reads need to be put back in chronological order
 	readSlice := apimodel.GlucoseReadSlice(readsForPeriod)
+	sort.Stable(readSlice)
 	startIndex, endIndex := apimodel.GetBoundariesOfElementsInRange(readSlice, lowerBound, upperBound)
 	filteredReads := readsForPeriod[startIndex : endIndex+1]
 
-	if err != datastore.Done {
-		util.Propagate(err)
+	return filteredReads, nil
+}
+
+// dayOfDataKey returns the key of a day of data element of the given kind. Data of the default device keeps the day's start
+// time as its numeric id while data of other devices is keyed by the device id and the start time. Only reads, their stats
+// and calibrations are measured by a device. Injections, basal rates, meals and exercises are events of the user that are
+// edited by their day so they're always stored under the default device.
+func dayOfDataKey(context context.Context, kind string, deviceId string, startTime time.Time, userProfileKey *datastore.Key) *datastore.Key {
+	if deviceId == apimodel.DEFAULT_DEVICE_ID {
+		return datastore.NewKey(context, kind, "", startTime.Unix(), userProfileKey)
 	}
 
-	return filteredReads, nil
+	return datastore.NewKey(context, kind, fmt.Sprintf("%s:%d", deviceId, startTime.Unix()), 0, userProfileKey)
+}
+
+// resizeKeys returns a slice of size keys, reusing the backing array of keys if it's large enough
+func resizeKeys(keys []*datastore.Key, size int) []*datastore.Key {
+	if cap(keys) < size {
+		return make([]*datastore.Key, size)
+	}
+
+	return keys[:size]
 }
 
 // StoreDaysOfReads stores a batch of DayOfReads elements. It is a optimized operation in that:
 //    1. One element represents a relatively short-and-wide entry of all reads for a single day.
 //    2. We have multiple DayOfReads elements and we use a PutMulti to make this faster.
 // For details of how a single element of DayOfReads is physically stored, see the implementation of apimodel.Store and apimodel.Load.
-// Also important to note, this store operation also handles updating the GlukitUser entry with the most recent read, if applicable.
-func StoreDaysOfReads(context context.Context, userProfileKey *datastore.Key, daysOfReads []apimodel.DayOfGlucoseReads) (keys []*datastore.Key, err error) {
-	elementKeys := make([]*datastore.Key, len(daysOfReads))
+// Reads are stored separately for each device, deviceId being empty for the default device.
+// The most recent read of the batch is returned so that the caller can update the user's most recent read once all
+// data has been stored (see UpdateMostRecentRead).
+func StoreDaysOfReads(context context.Context, userProfileKey *datastore.Key, deviceId string, daysOfReads []apimodel.DayOfGlucoseReads) (keys []*datastore.Key, mostRecentRead apimodel.GlucoseRead, err error) {
+	return storeDaysOfReads(context, userProfileKey, deviceId, daysOfReads, nil)
+}
+
+// storeDaysOfReads is StoreDaysOfReads with the keys of the days built in keyBuffer so that writers storing many
+// batches can reuse the same keys slice for all of them
+func storeDaysOfReads(context context.Context, userProfileKey *datastore.Key, deviceId string, daysOfReads []apimodel.DayOfGlucoseReads, keyBuffer []*datastore.Key) (keys []*datastore.Key, mostRecentRead apimodel.GlucoseRead, err error) {
+	daysOfReads = coalesceDaysOfGlucoseReads(daysOfReads)
+	mostRecentRead = apimodel.UNDEFINED_GLUCOSE_READ
+	elementKeys := resizeKeys(keyBuffer, len(daysOfReads))
+	var longest time.Duration
+	for i := range daysOfReads {
+		log.Debugf(context, "Storing day of reads with [%d] reads and key [%d] for device [%s]", len(daysOfReads[i].Reads), daysOfReads[i].StartTime.Unix(), deviceId)
+		daysOfReads[i].DeviceId = deviceId
+		elementKeys[i] = dayOfDataKey(context, "DayOfReads", deviceId, daysOfReads[i].StartTime, userProfileKey)
+		longest = longestSpan(longest, daysOfReads[i].StartTime, daysOfReads[i].EndTime)
+	}
+
+	// Merging with the stored days and putting the result is done in a transaction so that concurrent imports of
+	// overlapping data can't overwrite each other's days
+	log.Infof(context, "Emitting a PutMulti with %d keys for all %d days of reads", len(elementKeys), len(daysOfReads))
+	if err = runInTransaction(context, "StoreDaysOfReads", withDataSpan(userProfileKey, "DayOfReads", longest, daysOfReadsReconciler(elementKeys, &daysOfReads))); err != nil {
+		log.Warningf(context, "Error writing %d days of reads with keys [%s]: %v", len(elementKeys), elementKeys, err)
+		return nil, mostRecentRead, err
+	}
+
 	for i := range daysOfReads {
-		log.Debugf(context, "Storing day of reads with [%d] reads and key [%d]", len(daysOfReads[i].Reads), daysOfReads[i].StartTime.Unix())
-		elementKeys[i] = datastore.NewKey(context, "DayOfReads", "", daysOfReads[i].StartTime.Unix(), userProfileKey)
+		reads := daysOfReads[i].Reads
+		if len(reads) > 0 && reads[len(reads)-1].Time.Timestamp > mostRecentRead.Time.Timestamp {
+			mostRecentRead = reads[len(reads)-1]
+		}
+	}
+
+	return elementKeys, mostRecentRead, nil
+}
+
+// daysOfReadsReconciler returns the transaction function that merges the days of reads with the ones already stored and
+// puts the result. The merged days are kept in daysOfReads for the caller.
+func daysOfReadsReconciler(elementKeys []*datastore.Key, daysOfReads *[]apimodel.DayOfGlucoseReads) func(context context.Context) error {
+	freshData := *daysOfReads
+	return func(context context.Context) (err error) {
+		if *daysOfReads, err = reconcileDayOfReadsWithExisting(context, elementKeys, freshData); err != nil {
+			return err
+		}
+
+		_, err = putMulti(context, elementKeys, *daysOfReads)
+		return err
 	}
+}
+
+// UpdateMostRecentRead updates the user's most recent read with the candidate read if it's more recent than the current one.
+// The comparison and update are done in a transaction so that the most recent read can only move forward, regardless of the
+// order in which concurrent imports complete.
+func UpdateMostRecentRead(context context.Context, userProfileKey *datastore.Key, candidate apimodel.GlucoseRead) (err error) {
+	invalidateCachedUserProfile(context, userProfileKey)
 
-	daysOfReads, err = reconcileDayOfReadsWithExisting(context, elementKeys, daysOfReads)
+	err = runInTransaction(context, "UpdateMostRecentRead", mostRecentReadUpdater(userProfileKey, candidate))
 	if err != nil {
-		return nil, err
+		log.Criticalf(context, "Error updating user profile [%s] with most recent read value of %v: %v", userProfileKey, candidate, err)
+		return err
 	}
 
-	log.Infof(context, "Emitting a PutMulti with %d keys for all %d days of reads", len(elementKeys), len(daysOfReads))
-	keys, error := datastore.PutMulti(context, elementKeys, daysOfReads)
-	if error != nil {
-		log.Warningf(context, "Error writing %d days of reads with keys [%s]: %v", len(elementKeys), elementKeys, error)
-		return nil, error
+	// Invalidate again in case the profile was cached while the transaction was running
+	invalidateCachedUserProfile(context, userProfileKey)
+	return nil
+}
+
+// mostRecentReadUpdater returns the transaction function that moves the most recent read of a user forward to the candidate read
+func mostRecentReadUpdater(userProfileKey *datastore.Key, candidate apimodel.GlucoseRead) func(context context.Context) error {
+	return func(context context.Context) error {
+		userProfile := new(model.GlukitUser)
+		if err := get(context, userProfileKey, userProfile); err != nil {
+			return err
+		}
+
+		if !userProfile.MostRecentRead.GetTime().Before(candidate.GetTime()) {
+			log.Debugf(context, "Most recent read of [%s] is already more recent than [%s], skipping update", userProfile.MostRecentRead.GetTime(), candidate.GetTime())
+			return nil
+		}
+
+		log.Infof(context, "Updating most recent read date to %s", candidate.GetTime())
+		userProfile.MostRecentRead = candidate
+		_, err := put(context, userProfileKey, userProfile)
+		return err
+	}
+}
+
+// RecordRefresh records the outcome of a refresh of the data of a user and, if scheduleNext is true, the time of the
+// next refresh which is returned. The next refresh time is zero if the user paused refreshes. It's done in a transaction
+// so that it doesn't overwrite concurrent updates of the profile.
+func RecordRefresh(context context.Context, userProfileKey *datastore.Key, outcome model.RefreshOutcome, scheduleNext bool, now time.Time) (nextRefresh time.Time, err error) {
+	invalidateCachedUserProfile(context, userProfileKey)
+
+	if err = runInTransaction(context, "RecordRefresh", refreshRecorder(userProfileKey, outcome, scheduleNext, now, &nextRefresh)); err != nil {
+		return time.Time{}, err
+	}
+
+	// Invalidate again in case the profile was cached while the transaction was running
+	invalidateCachedUserProfile(context, userProfileKey)
+	return nextRefresh, nil
+}
+
+// refreshRecorder returns the transaction function that records the outcome of a refresh on the user profile and sets
+// nextRefresh to the time of the next one if scheduleNext is true
+func refreshRecorder(userProfileKey *datastore.Key, outcome model.RefreshOutcome, scheduleNext bool, now time.Time, nextRefresh *time.Time) func(context context.Context) error {
+	return func(context context.Context) error {
+		userProfile := new(model.GlukitUser)
+		if err := get(context, userProfileKey, userProfile); err != nil {
+			return err
+		}
+
+		userProfile.RecordRefresh(outcome)
+		if scheduleNext {
+			if userProfile.RefreshPaused {
+				userProfile.NextRefresh = time.Time{}
+			} else {
+				userProfile.NextRefresh = now.Add(userProfile.NextRefreshInterval())
+			}
+		}
+
+		*nextRefresh = userProfile.NextRefresh
+		_, err := put(context, userProfileKey, userProfile)
+		return err
+	}
+}
+
+// MarkTokenRevoked flags the user's refresh token as revoked on now and unschedules the user's refreshes until the user
+// authorizes access again
+func MarkTokenRevoked(context context.Context, userProfileKey *datastore.Key, now time.Time) (err error) {
+	invalidateCachedUserProfile(context, userProfileKey)
+
+	if err = runInTransaction(context, "MarkTokenRevoked", tokenRevocationMarker(userProfileKey, now)); err != nil {
+		return err
+	}
+
+	// Invalidate again in case the profile was cached while the transaction was running
+	invalidateCachedUserProfile(context, userProfileKey)
+	return nil
+}
+
+// tokenRevocationMarker returns the transaction function that flags the refresh token of the user profile as revoked
+// and clears its next refresh
+func tokenRevocationMarker(userProfileKey *datastore.Key, now time.Time) func(context context.Context) error {
+	return func(context context.Context) error {
+		userProfile := new(model.GlukitUser)
+		if err := get(context, userProfileKey, userProfile); err != nil {
+			return err
+		}
+
+		userProfile.TokenRevoked = true
+		userProfile.TokenRevokedOn = now
+		userProfile.NextRefresh = time.Time{}
+
+		_, err := put(context, userProfileKey, userProfile)
+		return err
+	}
+}
+
+// UpdateOnboarding applies the transition to the onboarding state of the user and returns the resulting state along
+// with whether the transition changed it. It's done in a transaction so that concurrent file imports each count.
+func UpdateOnboarding(context context.Context, userProfileKey *datastore.Key, transition func(state *model.OnboardingState) bool) (state model.OnboardingState, changed bool, err error) {
+	invalidateCachedUserProfile(context, userProfileKey)
+
+	if err = runInTransaction(context, "UpdateOnboarding", onboardingUpdater(userProfileKey, transition, &state, &changed)); err != nil {
+		return state, false, err
+	}
+
+	// Invalidate again in case the profile was cached while the transaction was running
+	invalidateCachedUserProfile(context, userProfileKey)
+	return state, changed, nil
+}
+
+// onboardingUpdater returns the transaction function that applies the transition to the onboarding state of the user
+// profile, storing it only if it changed
+func onboardingUpdater(userProfileKey *datastore.Key, transition func(state *model.OnboardingState) bool, state *model.OnboardingState,
+	changed *bool) func(context context.Context) error {
+	return func(context context.Context) error {
+		userProfile := new(model.GlukitUser)
+		if err := get(context, userProfileKey, userProfile); err != nil {
+			return err
+		}
+
+		*changed = transition(&userProfile.Onboarding)
+		*state = userProfile.GetOnboardingState()
+		if !*changed {
+			return nil
+		}
+
+		_, err := put(context, userProfileKey, userProfile)
+		return err
+	}
+}
+
+// SealUserTokens stores the tokens of the user, and the ones of the accounts linked to the user, again so that they are
+// encrypted with the current key of model.TokenKeyRing, whether they were stored in plaintext or encrypted with a
+// previous key
+func SealUserTokens(context context.Context, userProfileKey *datastore.Key) (err error) {
+	invalidateCachedUserProfile(context, userProfileKey)
+
+	if err = runInTransaction(context, "SealUserTokens", tokenSealer(userProfileKey, new(model.GlukitUser))); err != nil {
+		return err
 	}
 
-	// Get the time of the batch's last read and update the most recent read timestamp if necessary
-	userProfile, err := GetGlukitUserWithKey(context, userProfileKey)
+	// Invalidate again in case the profile was cached while the transaction was running
+	invalidateCachedUserProfile(context, userProfileKey)
+
+	aliasKeys, err := datastore.NewQuery("AccountAlias").Filter("primaryEmail =", userProfileKey.StringID()).KeysOnly().GetAll(context, nil)
 	if err != nil {
-		log.Criticalf(context, "Error reading user profile [%s] for its most recent read value: %v", userProfileKey, err)
-		return nil, err
+		return err
 	}
 
-	lastDayOfRead := daysOfReads[len(daysOfReads)-1]
-	lastRead := lastDayOfRead.Reads[len(lastDayOfRead.Reads)-1]
-	if userProfile.MostRecentRead.GetTime().Before(lastRead.GetTime()) {
-		log.Infof(context, "Updating most recent read date to %s", lastRead.GetTime())
-		userProfile.MostRecentRead = lastRead
-		_, err := StoreUserProfile(context, time.Now(), *userProfile)
-		if err != nil {
-			log.Criticalf(context, "Error storing updated user profile [%s] with most recent read value of %s: %v", userProfileKey, userProfile.MostRecentRead, err)
-			return nil, err
+	for _, aliasKey := range aliasKeys {
+		if err = runInTransaction(context, "SealUserTokens", tokenSealer(aliasKey, new(model.AccountAlias))); err != nil {
+			return err
 		}
 	}
 
-	return elementKeys, nil
+	return nil
+}
+
+// tokenSealer returns the transaction function that loads the entity of the key, a user profile or an account alias, and
+// stores it again, which encrypts its tokens with the current key
+func tokenSealer(key *datastore.Key, entity datastore.PropertyLoadSaver) func(context context.Context) error {
+	return func(context context.Context) error {
+		if err := get(context, key, entity); err != nil {
+			return err
+		}
+
+		_, err := put(context, key, entity)
+		return err
+	}
 }
 
 func reconcileDayOfReadsWithExisting(context context.Context, elementKeys []*datastore.Key, freshData []apimodel.DayOfGlucoseReads) (reconciledData []apimodel.DayOfGlucoseReads, err error) {
 	reconciledData = make([]apimodel.DayOfGlucoseReads, len(freshData))
 	// Merge with any pre-existing data
 	existingData := make([]apimodel.DayOfGlucoseReads, len(elementKeys))
-	err = datastore.GetMulti(context, elementKeys, existingData)
+	err = getMulti(context, elementKeys, existingData)
 	// If there's an error and it's not a MultiError, return immediately as something went wrong
 	if multierr, ok := err.(appengine.MultiError); !ok && err != nil {
 		log.Warningf(context, "Got error: %v", err)
@@ -167,7 +497,7 @@ func reconcileDayOfReadsWithExisting(context context.Context, elementKeys []*dat
 				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Reads), len(freshData[i].Reads), i)
 				reconciledReads := reconcileReads(existingData[i].Reads, freshData[i].Reads)
 				log.Debugf(context, "Merged reads ([%d]) is [%v]", len(reconciledReads), reconciledReads)
-				reconciledData[i] = apimodel.DayOfGlucoseReads{reconciledReads, existingData[i].StartTime, freshData[i].EndTime}
+				reconciledData[i] = apimodel.DayOfGlucoseReads{reconciledReads, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime), freshData[i].DeviceId}
 			}
 		}
 
@@ -179,7 +509,7 @@ func reconcileDayOfReadsWithExisting(context context.Context, elementKeys []*dat
 				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Reads), len(freshData[i].Reads), i)
 				reconciledReads := reconcileReads(existingData[i].Reads, freshData[i].Reads)
 				log.Debugf(context, "Merged reads ([%d]) is [%v]", len(reconciledReads), reconciledReads)
-				reconciledData[i] = apimodel.DayOfGlucoseReads{reconciledReads, existingData[i].StartTime, freshData[i].EndTime}
+				reconciledData[i] = apimodel.DayOfGlucoseReads{reconciledReads, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime), freshData[i].DeviceId}
 			}
 		}
 	}
@@ -198,10 +528,15 @@ func reconcileReads(older, recent []apimodel.GlucoseRead) (reconciledReads []api
 
 	for i := range recent {
 		timestamp := recent[i].Time.Timestamp
-		if _, exists := values[timestamp]; !exists {
+		existing, exists := values[timestamp]
+		if !exists {
 			allKeys = append(allKeys, timestamp)
 		}
-		values[timestamp] = recent[i]
+
+		// Keep the greatest of conflicting elements so that the result doesn't depend on the order of imports
+		if !exists || existing.Value < recent[i].Value {
+			values[timestamp] = recent[i]
+		}
 	}
 
 	sort.Sort(container.Int64Slice(allKeys))
@@ -215,13 +550,15 @@ func reconcileReads(older, recent []apimodel.GlucoseRead) (reconciledReads []api
 }
 
 // GetCalibrations returns all Calibration entries given a user's email address and the time boundaries. Not that the boundaries are both inclusive.
+// Calibrations from all devices are merged together.
 func GetCalibrations(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (meals []apimodel.CalibrationRead, err error) {
 	key := GetUserKey(context, email)
 
-	// Scan start should be one day prior and scan end should be one day later so that we can capture the day using
-	// a single column inequality filter. The scan should actually capture at least one day and a maximum of 3
-	scanStart := lowerBound.Add(time.Duration(-24 * time.Hour))
-	scanEnd := upperBound.Add(time.Duration(24 * time.Hour))
+	// Pad the scan so that we can capture the days overlapping with the bounds using a single column inequality filter
+	scanStart, scanEnd, err := getScanBoundaries(context, key, "DayOfCalibrationReads", lowerBound, upperBound)
+	if err != nil {
+		return nil, operationError(err, "getting calibrations of %s", rangeOf(email, lowerBound, upperBound))
+	}
 
 	log.Infof(context, "Scanning for calibrations between %s and %s to get calibrations between %s and %s", scanStart, scanEnd, lowerBound, upperBound)
 
@@ -230,54 +567,75 @@ func GetCalibrations(context context.Context, email string, lowerBound time.Time
 	calibrationsForPeriod := make([]apimodel.CalibrationRead, 0)
 
 	iterator := query.Run(context)
-	for _, err := iterator.Next(daysOfCalibration); err == nil; _, err = iterator.Next(daysOfCalibration) {
+	for _, err = iterator.Next(daysOfCalibration); err == nil; _, err = iterator.Next(daysOfCalibration) {
 		log.Debugf(context, "Loaded batch of %d calibrations...", len(daysOfCalibration.Reads))
 		calibrationsForPeriod = mergeCalibrationReadArrays(calibrationsForPeriod, daysOfCalibration.Reads)
 		daysOfCalibration = new(apimodel.DayOfCalibrationReads)
 	}
 
+	// Days of different devices start at the same time so their calibrations need to be put back in chronological order
 	calibrationSlice := apimodel.CalibrationReadSlice(calibrationsForPeriod)
+	sort.Stable(calibrationSlice)
 	startIndex, endIndex := apimodel.GetBoundariesOfElementsInRange(calibrationSlice, lowerBound, upperBound)
 	filteredCalibrations := calibrationsForPeriod[startIndex : endIndex+1]
 
 	if err != datastore.Done {
-		util.Propagate(err)
+		return nil, operationError(err, "getting calibrations of %s", rangeOf(email, lowerBound, upperBound))
 	}
 
 	return filteredCalibrations, nil
 }
 
-// StoreCalibrationReads stores a batch of DayOfCalibrations elements. It is a optimized operation in that:
+// StoreDaysOfCalibrations stores a batch of DayOfCalibrations elements. It is a optimized operation in that:
 //    1. One element represents a relatively short-and-wide entry of all calibration reads for a single day.
 //    2. We have multiple DayOfReads elements and we use a PutMulti to make this faster.
 // For details of how a single element of DayOfReads is physically stored, see the implementation of apimodel.Store and apimodel.Load.
-func StoreCalibrationReads(context context.Context, userProfileKey *datastore.Key, daysOfCalibrationReads []apimodel.DayOfCalibrationReads) (keys []*datastore.Key, err error) {
-	elementKeys := make([]*datastore.Key, len(daysOfCalibrationReads))
-	for i := range daysOfCalibrationReads {
-		log.Debugf(context, "Storing day of calibration reads with [%d] reads and key [%d]", len(daysOfCalibrationReads[i].Reads), daysOfCalibrationReads[i].StartTime.Unix())
-		elementKeys[i] = datastore.NewKey(context, "DayOfCalibrationReads", "", daysOfCalibrationReads[i].StartTime.Unix(), userProfileKey)
-	}
+// Calibrations are stored separately for each device like reads are, deviceId being empty for the default device.
+func StoreDaysOfCalibrations(context context.Context, userProfileKey *datastore.Key, deviceId string, daysOfCalibrationReads []apimodel.DayOfCalibrationReads) (keys []*datastore.Key, err error) {
+	return storeDaysOfCalibrations(context, userProfileKey, deviceId, daysOfCalibrationReads, nil)
+}
 
-	daysOfCalibrationReads, err = reconcileDayOfCalibrationsWithExisting(context, elementKeys, daysOfCalibrationReads)
-	if err != nil {
-		return nil, err
+// storeDaysOfCalibrations is StoreDaysOfCalibrations with the keys of the days built in keyBuffer so that writers
+// storing many batches can reuse the same keys slice for all of them
+func storeDaysOfCalibrations(context context.Context, userProfileKey *datastore.Key, deviceId string, daysOfCalibrationReads []apimodel.DayOfCalibrationReads, keyBuffer []*datastore.Key) (keys []*datastore.Key, err error) {
+	daysOfCalibrationReads = coalesceDaysOfCalibrationReads(daysOfCalibrationReads)
+	elementKeys := resizeKeys(keyBuffer, len(daysOfCalibrationReads))
+	var longest time.Duration
+	for i := range daysOfCalibrationReads {
+		log.Debugf(context, "Storing day of calibration reads with [%d] reads and key [%d] for device [%s]", len(daysOfCalibrationReads[i].Reads), daysOfCalibrationReads[i].StartTime.Unix(), deviceId)
+		daysOfCalibrationReads[i].DeviceId = deviceId
+		elementKeys[i] = dayOfDataKey(context, "DayOfCalibrationReads", deviceId, daysOfCalibrationReads[i].StartTime, userProfileKey)
+		longest = longestSpan(longest, daysOfCalibrationReads[i].StartTime, daysOfCalibrationReads[i].EndTime)
 	}
 
 	log.Infof(context, "Emitting a PutMulti with %d keys for all %d days of calibration reads", len(elementKeys), len(daysOfCalibrationReads))
-	keys, error := datastore.PutMulti(context, elementKeys, daysOfCalibrationReads)
-	if error != nil {
-		log.Criticalf(context, "Error writing %d days of calibration reads with keys [%s]: %v", len(elementKeys), elementKeys, error)
-		return nil, error
+	if err = runInTransaction(context, "StoreDaysOfCalibrations", withDataSpan(userProfileKey, "DayOfCalibrationReads", longest, daysOfCalibrationsReconciler(elementKeys, daysOfCalibrationReads))); err != nil {
+		log.Criticalf(context, "Error writing %d days of calibration reads with keys [%s]: %v", len(elementKeys), elementKeys, err)
+		return nil, err
 	}
 
 	return elementKeys, nil
 }
 
+// daysOfCalibrationsReconciler returns the transaction function that merges the days of calibration reads with the ones
+// already stored and puts the result
+func daysOfCalibrationsReconciler(elementKeys []*datastore.Key, freshData []apimodel.DayOfCalibrationReads) func(context context.Context) error {
+	return func(context context.Context) error {
+		reconciledData, err := reconcileDayOfCalibrationsWithExisting(context, elementKeys, freshData)
+		if err != nil {
+			return err
+		}
+
+		_, err = putMulti(context, elementKeys, reconciledData)
+		return err
+	}
+}
+
 func reconcileDayOfCalibrationsWithExisting(context context.Context, elementKeys []*datastore.Key, freshData []apimodel.DayOfCalibrationReads) (reconciledData []apimodel.DayOfCalibrationReads, err error) {
 	reconciledData = make([]apimodel.DayOfCalibrationReads, len(freshData))
 	// Merge with any pre-existing data
 	existingData := make([]apimodel.DayOfCalibrationReads, len(elementKeys))
-	err = datastore.GetMulti(context, elementKeys, existingData)
+	err = getMulti(context, elementKeys, existingData)
 	// If there's an error and it's not a MultiError, return immediately as something went wrong
 	if multierr, ok := err.(appengine.MultiError); !ok && err != nil {
 		log.Warningf(context, "Got error: %v", err)
@@ -288,7 +646,7 @@ func reconcileDayOfCalibrationsWithExisting(context context.Context, elementKeys
 				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Reads), len(freshData[i].Reads), i)
 				reconciledReads := reconcileCalibrations(existingData[i].Reads, freshData[i].Reads)
 				log.Debugf(context, "Merged calibrations ([%d]) is [%v]", len(reconciledReads), reconciledReads)
-				reconciledData[i] = apimodel.DayOfCalibrationReads{reconciledReads, existingData[i].StartTime, freshData[i].EndTime}
+				reconciledData[i] = apimodel.DayOfCalibrationReads{reconciledReads, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime), freshData[i].DeviceId}
 			}
 		}
 
@@ -300,7 +658,7 @@ func reconcileDayOfCalibrationsWithExisting(context context.Context, elementKeys
 				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Reads), len(freshData[i].Reads), i)
 				reconciledReads := reconcileCalibrations(existingData[i].Reads, freshData[i].Reads)
 				log.Debugf(context, "Merged calibrations ([%d]) is [%v]", len(reconciledReads), reconciledReads)
-				reconciledData[i] = apimodel.DayOfCalibrationReads{reconciledReads, existingData[i].StartTime, freshData[i].EndTime}
+				reconciledData[i] = apimodel.DayOfCalibrationReads{reconciledReads, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime), freshData[i].DeviceId}
 			}
 		}
 	}
@@ -319,10 +677,15 @@ func reconcileCalibrations(older, recent []apimodel.CalibrationRead) (reconciled
 
 	for i := range recent {
 		timestamp := recent[i].Time.Timestamp
-		if _, exists := values[timestamp]; !exists {
+		existing, exists := values[timestamp]
+		if !exists {
 			allKeys = append(allKeys, timestamp)
 		}
-		values[timestamp] = recent[i]
+
+		// Keep the greatest of conflicting elements so that the result doesn't depend on the order of imports
+		if !exists || existing.Value < recent[i].Value {
+			values[timestamp] = recent[i]
+		}
 	}
 
 	sort.Sort(container.Int64Slice(allKeys))
@@ -339,10 +702,11 @@ func reconcileCalibrations(older, recent []apimodel.CalibrationRead) (reconciled
 func GetInjections(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (meals []apimodel.Injection, err error) {
 	key := GetUserKey(context, email)
 
-	// Scan start should be one day prior and scan end should be one day later so that we can capture the day using
-	// a single column inequality filter. The scan should actually capture at least one day and a maximum of 3
-	scanStart := lowerBound.Add(time.Duration(-24 * time.Hour))
-	scanEnd := upperBound.Add(time.Duration(24 * time.Hour))
+	// Pad the scan so that we can capture the days overlapping with the bounds using a single column inequality filter
+	scanStart, scanEnd, err := getScanBoundaries(context, key, "DayOfInjections", lowerBound, upperBound)
+	if err != nil {
+		return nil, operationError(err, "getting injections of %s", rangeOf(email, lowerBound, upperBound))
+	}
 
 	log.Infof(context, "Scanning for meals between %s and %s to get meals between %s and %s", scanStart, scanEnd, lowerBound, upperBound)
 
@@ -351,8 +715,9 @@ func GetInjections(context context.Context, email string, lowerBound time.Time,
 	mealsForPeriod := make([]apimodel.Injection, 0)
 
 	iterator := query.Run(context)
-	for _, err := iterator.Next(daysOfInjections); err == nil; _, err = iterator.Next(daysOfInjections) {
+	for _, err = iterator.Next(daysOfInjections); err == nil; _, err = iterator.Next(daysOfInjections) {
 		log.Debugf(context, "Loaded batch of %d meals...", len(daysOfInjections.Injections))
+		apimodel.InjectionSlice(daysOfInjections.Injections).AssignIds()
 		mealsForPeriod = mergeInjectionArrays(mealsForPeriod, daysOfInjections.Injections)
 		daysOfInjections = new(apimodel.DayOfInjections)
 	}
@@ -362,10 +727,27 @@ func GetInjections(context context.Context, email string, lowerBound time.Time,
 	filteredInjections := mealsForPeriod[startIndex : endIndex+1]
 
 	if err != datastore.Done {
-		util.Propagate(err)
+		return nil, operationError(err, "getting injections of %s", rangeOf(email, lowerBound, upperBound))
 	}
 
-	return filteredInjections, nil
+	return apimodel.InjectionSlice(filteredInjections).WithoutDeleted(), nil
+}
+
+// GetInjectionsByType returns the injections of insulin of the given category between the lower and upper bounds
+func GetInjectionsByType(context context.Context, email string, lowerBound time.Time, upperBound time.Time, category apimodel.InsulinCategory) (injections []apimodel.Injection, err error) {
+	allInjections, err := GetInjections(context, email, lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	injections = make([]apimodel.Injection, 0)
+	for _, injection := range allInjections {
+		if injection.Category == category {
+			injections = append(injections, injection)
+		}
+	}
+
+	return injections, nil
 }
 
 // StoreDaysOfInjections stores a batch of DayOfInjections elements. It is a optimized operation in that:
@@ -373,31 +755,42 @@ func GetInjections(context context.Context, email string, lowerBound time.Time,
 //    2. We have multiple DayOfInjections elements and we use a PutMulti to make this faster.
 // For details of how a single element of DayOfInjections is physically stored, see the implementation of apimodel.Store and apimodel.Load.
 func StoreDaysOfInjections(context context.Context, userProfileKey *datastore.Key, daysOfInjections []apimodel.DayOfInjections) (keys []*datastore.Key, err error) {
+	daysOfInjections = coalesceDaysOfInjections(daysOfInjections)
 	elementKeys := make([]*datastore.Key, len(daysOfInjections))
+	var longest time.Duration
 	for i := range daysOfInjections {
-		elementKeys[i] = datastore.NewKey(context, "DayOfInjections", "", daysOfInjections[i].StartTime.Unix(), userProfileKey)
-	}
-
-	daysOfInjections, err = reconcileDayOfInjectionsWithExisting(context, elementKeys, daysOfInjections)
-	if err != nil {
-		return nil, err
+		elementKeys[i] = dayOfDataKey(context, "DayOfInjections", apimodel.DEFAULT_DEVICE_ID, daysOfInjections[i].StartTime, userProfileKey)
+		longest = longestSpan(longest, daysOfInjections[i].StartTime, daysOfInjections[i].EndTime)
 	}
 
 	log.Infof(context, "Emitting a PutMulti with %d keys for all %d days of meals", len(elementKeys), len(daysOfInjections))
-	keys, error := datastore.PutMulti(context, elementKeys, daysOfInjections)
-	if error != nil {
-		log.Criticalf(context, "Error writing %d days of meals with keys [%s]: %v", len(elementKeys), elementKeys, error)
-		return nil, error
+	if err = runInTransaction(context, "StoreDaysOfInjections", withDataSpan(userProfileKey, "DayOfInjections", longest, daysOfInjectionsReconciler(elementKeys, daysOfInjections))); err != nil {
+		log.Criticalf(context, "Error writing %d days of meals with keys [%s]: %v", len(elementKeys), elementKeys, err)
+		return nil, err
 	}
 
 	return elementKeys, nil
 }
 
+// daysOfInjectionsReconciler returns the transaction function that merges the days of injections with the ones already
+// stored and puts the result
+func daysOfInjectionsReconciler(elementKeys []*datastore.Key, freshData []apimodel.DayOfInjections) func(context context.Context) error {
+	return func(context context.Context) error {
+		reconciledData, err := reconcileDayOfInjectionsWithExisting(context, elementKeys, freshData)
+		if err != nil {
+			return err
+		}
+
+		_, err = putMulti(context, elementKeys, reconciledData)
+		return err
+	}
+}
+
 func reconcileDayOfInjectionsWithExisting(context context.Context, elementKeys []*datastore.Key, freshData []apimodel.DayOfInjections) (reconciledData []apimodel.DayOfInjections, err error) {
 	reconciledData = make([]apimodel.DayOfInjections, len(freshData))
 	// Merge with any pre-existing data
 	existingData := make([]apimodel.DayOfInjections, len(elementKeys))
-	err = datastore.GetMulti(context, elementKeys, existingData)
+	err = getMulti(context, elementKeys, existingData)
 	// If there's an error and it's not a MultiError, return immediately as something went wrong
 	if multierr, ok := err.(appengine.MultiError); !ok && err != nil {
 		log.Warningf(context, "Got error: %v", err)
@@ -408,7 +801,7 @@ func reconcileDayOfInjectionsWithExisting(context context.Context, elementKeys [
 				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Injections), len(freshData[i].Injections), i)
 				reconciledInjections := reconcileInjections(existingData[i].Injections, freshData[i].Injections)
 				log.Debugf(context, "Merged meals ([%d]) is [%v]", len(reconciledInjections), reconciledInjections)
-				reconciledData[i] = apimodel.DayOfInjections{reconciledInjections, existingData[i].StartTime, freshData[i].EndTime}
+				reconciledData[i] = apimodel.DayOfInjections{reconciledInjections, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime)}
 			}
 		}
 
@@ -420,7 +813,7 @@ func reconcileDayOfInjectionsWithExisting(context context.Context, elementKeys [
 				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Injections), len(freshData[i].Injections), i)
 				reconciledInjections := reconcileInjections(existingData[i].Injections, freshData[i].Injections)
 				log.Debugf(context, "Merged meals ([%d]) is [%v]", len(reconciledInjections), reconciledInjections)
-				reconciledData[i] = apimodel.DayOfInjections{reconciledInjections, existingData[i].StartTime, freshData[i].EndTime}
+				reconciledData[i] = apimodel.DayOfInjections{reconciledInjections, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime)}
 			}
 		}
 	}
@@ -439,10 +832,16 @@ func reconcileInjections(older, recent []apimodel.Injection) (reconciledInjectio
 
 	for i := range recent {
 		timestamp := recent[i].Time.Timestamp
-		if _, exists := values[timestamp]; !exists {
+		existing, exists := values[timestamp]
+		if !exists {
 			allKeys = append(allKeys, timestamp)
 		}
-		values[timestamp] = recent[i]
+
+		// Events edited or deleted by the user win over imported ones. Otherwise, keep the greatest of conflicting
+		// elements so that the result doesn't depend on the order of imports
+		if !exists || !existing.Edited && !existing.Deleted && existing.Units < recent[i].Units {
+			values[timestamp] = recent[i]
+		}
 	}
 
 	sort.Sort(container.Int64Slice(allKeys))
@@ -455,71 +854,81 @@ func reconcileInjections(older, recent []apimodel.Injection) (reconciledInjectio
 	return reconciledInjections
 }
 
-// GetMeals returns all Meal entries given a user's email address and the time boundaries. Not that the boundaries are both inclusive.
-func GetMeals(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (carbs []apimodel.Meal, err error) {
+// GetBasalRates returns all BasalRate entries given a user's email address and the time boundaries. Not that the boundaries are both inclusive.
+func GetBasalRates(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (basalRates []apimodel.BasalRate, err error) {
 	key := GetUserKey(context, email)
 
-	// Scan start should be one day prior and scan end should be one day later so that we can capture the day using
-	// a single column inequality filter. The scan should actually capture at least one day and a maximum of 3
-	scanStart := lowerBound.Add(time.Duration(-24 * time.Hour))
-	scanEnd := upperBound.Add(time.Duration(24 * time.Hour))
+	// Pad the scan so that we can capture the days overlapping with the bounds using a single column inequality filter
+	scanStart, scanEnd, err := getScanBoundaries(context, key, "DayOfBasalRates", lowerBound, upperBound)
+	if err != nil {
+		return nil, operationError(err, "getting basal rates of %s", rangeOf(email, lowerBound, upperBound))
+	}
 
-	log.Infof(context, "Scanning for carbs between %s and %s to get carbs between %s and %s", scanStart, scanEnd, lowerBound, upperBound)
+	log.Infof(context, "Scanning for basal rates between %s and %s to get basal rates between %s and %s", scanStart, scanEnd, lowerBound, upperBound)
 
-	query := datastore.NewQuery("DayOfMeals").Ancestor(key).Filter("startTime >=", scanStart).Filter("startTime <=", scanEnd).Order("startTime")
-	daysOfMeals := new(apimodel.DayOfMeals)
-	mealsForPeriod := make([]apimodel.Meal, 0)
+	query := datastore.NewQuery("DayOfBasalRates").Ancestor(key).Filter("startTime >=", scanStart).Filter("startTime <=", scanEnd).Order("startTime")
+	daysOfBasalRates := new(apimodel.DayOfBasalRates)
+	basalRatesForPeriod := make([]apimodel.BasalRate, 0)
 
 	iterator := query.Run(context)
-	for _, err := iterator.Next(daysOfMeals); err == nil; _, err = iterator.Next(daysOfMeals) {
-		log.Debugf(context, "Loaded batch of %d carbs...", len(daysOfMeals.Meals))
-		mealsForPeriod = mergeMealArrays(mealsForPeriod, daysOfMeals.Meals)
-		daysOfMeals = new(apimodel.DayOfMeals)
+	for _, err = iterator.Next(daysOfBasalRates); err == nil; _, err = iterator.Next(daysOfBasalRates) {
+		log.Debugf(context, "Loaded batch of %d basal rates...", len(daysOfBasalRates.BasalRates))
+		basalRatesForPeriod = mergeBasalRateArrays(basalRatesForPeriod, daysOfBasalRates.BasalRates)
+		daysOfBasalRates = new(apimodel.DayOfBasalRates)
 	}
 
-	log.Debugf(context, "Filtering between [%s] and [%s], %d carbs: %v", lowerBound, upperBound, len(mealsForPeriod), mealsForPeriod)
-	carbSlice := apimodel.MealSlice(mealsForPeriod)
-	startIndex, endIndex := apimodel.GetBoundariesOfElementsInRange(carbSlice, lowerBound, upperBound)
-	filteredMeals := mealsForPeriod[startIndex : endIndex+1]
-	log.Debugf(context, "Finished filtering with %d carbs", len(filteredMeals))
+	basalRateSlice := apimodel.BasalRateSlice(basalRatesForPeriod)
+	startIndex, endIndex := apimodel.GetBoundariesOfElementsInRange(basalRateSlice, lowerBound, upperBound)
+	filteredBasalRates := basalRatesForPeriod[startIndex : endIndex+1]
 
 	if err != datastore.Done {
-		util.Propagate(err)
+		return nil, operationError(err, "getting basal rates of %s", rangeOf(email, lowerBound, upperBound))
 	}
 
-	return filteredMeals, nil
+	return filteredBasalRates, nil
 }
 
-// StoreDaysOfMeals stores a batch of DayOfMeals elements. It is a optimized operation in that:
-//    1. One element represents a relatively short-and-wide entry of all Meals for a single day.
-//    2. We have multiple DayOfMeals elements and we use a PutMulti to make this faster.
-// For details of how a single element of DayOfMeals is physically stored, see the implementation of apimodel.Store and apimodel.Load.
-func StoreDaysOfMeals(context context.Context, userProfileKey *datastore.Key, daysOfMeals []apimodel.DayOfMeals) (keys []*datastore.Key, err error) {
-	elementKeys := make([]*datastore.Key, len(daysOfMeals))
-	for i := range daysOfMeals {
-		elementKeys[i] = datastore.NewKey(context, "DayOfMeals", "", daysOfMeals[i].StartTime.Unix(), userProfileKey)
-	}
-
-	daysOfMeals, err = reconcileDayOfMealsWithExisting(context, elementKeys, daysOfMeals)
-	if err != nil {
+// StoreDaysOfBasalRates stores a batch of DayOfBasalRates elements. It is a optimized operation in that:
+//    1. One element represents a relatively short-and-wide entry of all basal rates for a single day.
+//    2. We have multiple DayOfBasalRates elements and we use a PutMulti to make this faster.
+// For details of how a single element of DayOfBasalRates is physically stored, see the implementation of apimodel.Store and apimodel.Load.
+func StoreDaysOfBasalRates(context context.Context, userProfileKey *datastore.Key, daysOfBasalRates []apimodel.DayOfBasalRates) (keys []*datastore.Key, err error) {
+	daysOfBasalRates = coalesceDaysOfBasalRates(daysOfBasalRates)
+	elementKeys := make([]*datastore.Key, len(daysOfBasalRates))
+	var longest time.Duration
+	for i := range daysOfBasalRates {
+		elementKeys[i] = dayOfDataKey(context, "DayOfBasalRates", apimodel.DEFAULT_DEVICE_ID, daysOfBasalRates[i].StartTime, userProfileKey)
+		longest = longestSpan(longest, daysOfBasalRates[i].StartTime, daysOfBasalRates[i].EndTime)
+	}
+
+	log.Infof(context, "Emitting a PutMulti with %d keys for all %d days of basal rates", len(elementKeys), len(daysOfBasalRates))
+	if err = runInTransaction(context, "StoreDaysOfBasalRates", withDataSpan(userProfileKey, "DayOfBasalRates", longest, daysOfBasalRatesReconciler(elementKeys, daysOfBasalRates))); err != nil {
+		log.Criticalf(context, "Error writing %d days of basal rates with keys [%s]: %v", len(elementKeys), elementKeys, err)
 		return nil, err
 	}
 
-	log.Infof(context, "Emitting a PutMulti with %d keys for all %d days of meals", len(elementKeys), len(daysOfMeals))
-	keys, error := datastore.PutMulti(context, elementKeys, daysOfMeals)
-	if error != nil {
-		log.Criticalf(context, "Error writing %d days of meals with keys [%s]: %v", len(elementKeys), elementKeys, error)
-		return nil, error
-	}
-
 	return elementKeys, nil
 }
 
-func reconcileDayOfMealsWithExisting(context context.Context, elementKeys []*datastore.Key, freshData []apimodel.DayOfMeals) (reconciledData []apimodel.DayOfMeals, err error) {
-	reconciledData = make([]apimodel.DayOfMeals, len(freshData))
+// daysOfBasalRatesReconciler returns the transaction function that merges the days of basal rates with the ones already
+// stored and puts the result
+func daysOfBasalRatesReconciler(elementKeys []*datastore.Key, freshData []apimodel.DayOfBasalRates) func(context context.Context) error {
+	return func(context context.Context) error {
+		reconciledData, err := reconcileDayOfBasalRatesWithExisting(context, elementKeys, freshData)
+		if err != nil {
+			return err
+		}
+
+		_, err = putMulti(context, elementKeys, reconciledData)
+		return err
+	}
+}
+
+func reconcileDayOfBasalRatesWithExisting(context context.Context, elementKeys []*datastore.Key, freshData []apimodel.DayOfBasalRates) (reconciledData []apimodel.DayOfBasalRates, err error) {
+	reconciledData = make([]apimodel.DayOfBasalRates, len(freshData))
 	// Merge with any pre-existing data
-	existingData := make([]apimodel.DayOfMeals, len(elementKeys))
-	err = datastore.GetMulti(context, elementKeys, existingData)
+	existingData := make([]apimodel.DayOfBasalRates, len(elementKeys))
+	err = getMulti(context, elementKeys, existingData)
 	// If there's an error and it's not a MultiError, return immediately as something went wrong
 	if multierr, ok := err.(appengine.MultiError); !ok && err != nil {
 		log.Warningf(context, "Got error: %v", err)
@@ -527,22 +936,22 @@ func reconcileDayOfMealsWithExisting(context context.Context, elementKeys []*dat
 	} else {
 		if err == nil {
 			for i := range existingData {
-				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Meals), len(freshData[i].Meals), i)
-				reconciledMeals := reconcileMeals(existingData[i].Meals, freshData[i].Meals)
-				log.Debugf(context, "Merged meals ([%d]) is [%v]", len(reconciledMeals), reconciledMeals)
-				reconciledData[i] = apimodel.DayOfMeals{reconciledMeals, existingData[i].StartTime, freshData[i].EndTime}
+				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].BasalRates), len(freshData[i].BasalRates), i)
+				reconciledBasalRates := reconcileBasalRates(existingData[i].BasalRates, freshData[i].BasalRates)
+				log.Debugf(context, "Merged basal rates ([%d]) is [%v]", len(reconciledBasalRates), reconciledBasalRates)
+				reconciledData[i] = apimodel.DayOfBasalRates{reconciledBasalRates, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime)}
 			}
 		}
 
 		for i, elementErr := range multierr {
 			if elementErr == datastore.ErrNoSuchEntity {
-				log.Debugf(context, "Keeping day of meals for key [%s] as-is since we have no pre-existing data for it.", elementKeys[i].String())
+				log.Debugf(context, "Keeping day of basal rates for key [%s] as-is since we have no pre-existing data for it.", elementKeys[i].String())
 				reconciledData[i] = freshData[i]
 			} else {
-				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Meals), len(freshData[i].Meals), i)
-				reconciledMeals := reconcileMeals(existingData[i].Meals, freshData[i].Meals)
-				log.Debugf(context, "Merged meals ([%d]) is [%v]", len(reconciledMeals), reconciledMeals)
-				reconciledData[i] = apimodel.DayOfMeals{reconciledMeals, existingData[i].StartTime, freshData[i].EndTime}
+				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].BasalRates), len(freshData[i].BasalRates), i)
+				reconciledBasalRates := reconcileBasalRates(existingData[i].BasalRates, freshData[i].BasalRates)
+				log.Debugf(context, "Merged basal rates ([%d]) is [%v]", len(reconciledBasalRates), reconciledBasalRates)
+				reconciledData[i] = apimodel.DayOfBasalRates{reconciledBasalRates, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime)}
 			}
 		}
 	}
@@ -550,9 +959,9 @@ func reconcileDayOfMealsWithExisting(context context.Context, elementKeys []*dat
 	return reconciledData, nil
 }
 
-func reconcileMeals(older, recent []apimodel.Meal) (reconciledMeals []apimodel.Meal) {
+func reconcileBasalRates(older, recent []apimodel.BasalRate) (reconciledBasalRates []apimodel.BasalRate) {
 	allKeys := make([]int64, 0)
-	values := make(map[int64]apimodel.Meal)
+	values := make(map[int64]apimodel.BasalRate)
 	for i := range older {
 		timestamp := older[i].Time.Timestamp
 		allKeys = append(allKeys, timestamp)
@@ -561,53 +970,201 @@ func reconcileMeals(older, recent []apimodel.Meal) (reconciledMeals []apimodel.M
 
 	for i := range recent {
 		timestamp := recent[i].Time.Timestamp
-		if _, exists := values[timestamp]; !exists {
+		existing, exists := values[timestamp]
+		if !exists {
 			allKeys = append(allKeys, timestamp)
 		}
-		values[timestamp] = recent[i]
+
+		// Keep the greatest of conflicting elements so that the result doesn't depend on the order of imports
+		if !exists || existing.Rate < recent[i].Rate {
+			values[timestamp] = recent[i]
+		}
 	}
 
 	sort.Sort(container.Int64Slice(allKeys))
 
-	reconciledMeals = make([]apimodel.Meal, len(allKeys))
+	reconciledBasalRates = make([]apimodel.BasalRate, len(allKeys))
 	for i := range allKeys {
-		reconciledMeals[i] = values[allKeys[i]]
+		reconciledBasalRates[i] = values[allKeys[i]]
 	}
 
-	return reconciledMeals
+	return reconciledBasalRates
 }
 
-// GetExercises returns all Exercise entries given a user's email address and the time boundaries. Not that the boundaries are both inclusive.
-func GetExercises(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (exercises []apimodel.Exercise, err error) {
+// GetMeals returns all Meal entries given a user's email address and the time boundaries. Not that the boundaries are both inclusive.
+func GetMeals(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (carbs []apimodel.Meal, err error) {
 	key := GetUserKey(context, email)
 
-	// Scan start should be one day prior and scan end should be one day later so that we can capture the day using
-	// a single column inequality filter. The scan should actually capture at least one day and a maximum of 3
-	scanStart := lowerBound.Add(time.Duration(-24 * time.Hour))
-	scanEnd := upperBound.Add(time.Duration(24 * time.Hour))
+	// Pad the scan so that we can capture the days overlapping with the bounds using a single column inequality filter
+	scanStart, scanEnd, err := getScanBoundaries(context, key, "DayOfMeals", lowerBound, upperBound)
+	if err != nil {
+		return nil, operationError(err, "getting meals of %s", rangeOf(email, lowerBound, upperBound))
+	}
 
-	log.Infof(context, "Scanning for exercises between %s and %s to get exercises between %s and %s", scanStart, scanEnd, lowerBound, upperBound)
+	log.Infof(context, "Scanning for carbs between %s and %s to get carbs between %s and %s", scanStart, scanEnd, lowerBound, upperBound)
 
-	query := datastore.NewQuery("DayOfExercises").Ancestor(key).Filter("startTime >=", scanStart).Filter("startTime <=", scanEnd).Order("startTime")
-	daysOfExercises := new(apimodel.DayOfExercises)
-	exercisesForPeriod := make([]apimodel.Exercise, 0)
+	query := datastore.NewQuery("DayOfMeals").Ancestor(key).Filter("startTime >=", scanStart).Filter("startTime <=", scanEnd).Order("startTime")
+	daysOfMeals := new(apimodel.DayOfMeals)
+	mealsForPeriod := make([]apimodel.Meal, 0)
 
 	iterator := query.Run(context)
-	for _, err := iterator.Next(daysOfExercises); err == nil; _, err = iterator.Next(daysOfExercises) {
-		log.Debugf(context, "Loaded batch of %d exercises...", len(daysOfExercises.Exercises))
-		exercisesForPeriod = mergeExerciseArrays(exercisesForPeriod, daysOfExercises.Exercises)
-		daysOfExercises = new(apimodel.DayOfExercises)
-	}
+	for _, err = iterator.Next(daysOfMeals); err == nil; _, err = iterator.Next(daysOfMeals) {
+		log.Debugf(context, "Loaded batch of %d carbs...", len(daysOfMeals.Meals))
+		apimodel.MealSlice(daysOfMeals.Meals).AssignIds()
+		mealsForPeriod = mergeMealArrays(mealsForPeriod, daysOfMeals.Meals)
+		daysOfMeals = new(apimodel.DayOfMeals)
+	}
+
+	log.Debugf(context, "Filtering between [%s] and [%s], %d carbs: %v", lowerBound, upperBound, len(mealsForPeriod), mealsForPeriod)
+	carbSlice := apimodel.MealSlice(mealsForPeriod)
+	startIndex, endIndex := apimodel.GetBoundariesOfElementsInRange(carbSlice, lowerBound, upperBound)
+	filteredMeals := mealsForPeriod[startIndex : endIndex+1]
+	log.Debugf(context, "Finished filtering with %d carbs", len(filteredMeals))
+
+	if err != datastore.Done {
+		return nil, operationError(err, "getting meals of %s", rangeOf(email, lowerBound, upperBound))
+	}
+
+	return apimodel.MealSlice(filteredMeals).WithoutDeleted(), nil
+}
+
+// StoreDaysOfMeals stores a batch of DayOfMeals elements. It is a optimized operation in that:
+//    1. One element represents a relatively short-and-wide entry of all Meals for a single day.
+//    2. We have multiple DayOfMeals elements and we use a PutMulti to make this faster.
+// For details of how a single element of DayOfMeals is physically stored, see the implementation of apimodel.Store and apimodel.Load.
+func StoreDaysOfMeals(context context.Context, userProfileKey *datastore.Key, daysOfMeals []apimodel.DayOfMeals) (keys []*datastore.Key, err error) {
+	daysOfMeals = coalesceDaysOfMeals(daysOfMeals)
+	elementKeys := make([]*datastore.Key, len(daysOfMeals))
+	var longest time.Duration
+	for i := range daysOfMeals {
+		elementKeys[i] = dayOfDataKey(context, "DayOfMeals", apimodel.DEFAULT_DEVICE_ID, daysOfMeals[i].StartTime, userProfileKey)
+		longest = longestSpan(longest, daysOfMeals[i].StartTime, daysOfMeals[i].EndTime)
+	}
+
+	log.Infof(context, "Emitting a PutMulti with %d keys for all %d days of meals", len(elementKeys), len(daysOfMeals))
+	if err = runInTransaction(context, "StoreDaysOfMeals", withDataSpan(userProfileKey, "DayOfMeals", longest, daysOfMealsReconciler(elementKeys, daysOfMeals))); err != nil {
+		log.Criticalf(context, "Error writing %d days of meals with keys [%s]: %v", len(elementKeys), elementKeys, err)
+		return nil, err
+	}
+
+	return elementKeys, nil
+}
+
+// daysOfMealsReconciler returns the transaction function that merges the days of meals with the ones already stored and
+// puts the result
+func daysOfMealsReconciler(elementKeys []*datastore.Key, freshData []apimodel.DayOfMeals) func(context context.Context) error {
+	return func(context context.Context) error {
+		reconciledData, err := reconcileDayOfMealsWithExisting(context, elementKeys, freshData)
+		if err != nil {
+			return err
+		}
+
+		_, err = putMulti(context, elementKeys, reconciledData)
+		return err
+	}
+}
+
+func reconcileDayOfMealsWithExisting(context context.Context, elementKeys []*datastore.Key, freshData []apimodel.DayOfMeals) (reconciledData []apimodel.DayOfMeals, err error) {
+	reconciledData = make([]apimodel.DayOfMeals, len(freshData))
+	// Merge with any pre-existing data
+	existingData := make([]apimodel.DayOfMeals, len(elementKeys))
+	err = getMulti(context, elementKeys, existingData)
+	// If there's an error and it's not a MultiError, return immediately as something went wrong
+	if multierr, ok := err.(appengine.MultiError); !ok && err != nil {
+		log.Warningf(context, "Got error: %v", err)
+		return nil, err
+	} else {
+		if err == nil {
+			for i := range existingData {
+				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Meals), len(freshData[i].Meals), i)
+				reconciledMeals := reconcileMeals(existingData[i].Meals, freshData[i].Meals)
+				log.Debugf(context, "Merged meals ([%d]) is [%v]", len(reconciledMeals), reconciledMeals)
+				reconciledData[i] = apimodel.DayOfMeals{reconciledMeals, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime)}
+			}
+		}
+
+		for i, elementErr := range multierr {
+			if elementErr == datastore.ErrNoSuchEntity {
+				log.Debugf(context, "Keeping day of meals for key [%s] as-is since we have no pre-existing data for it.", elementKeys[i].String())
+				reconciledData[i] = freshData[i]
+			} else {
+				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Meals), len(freshData[i].Meals), i)
+				reconciledMeals := reconcileMeals(existingData[i].Meals, freshData[i].Meals)
+				log.Debugf(context, "Merged meals ([%d]) is [%v]", len(reconciledMeals), reconciledMeals)
+				reconciledData[i] = apimodel.DayOfMeals{reconciledMeals, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime)}
+			}
+		}
+	}
+
+	return reconciledData, nil
+}
+
+func reconcileMeals(older, recent []apimodel.Meal) (reconciledMeals []apimodel.Meal) {
+	allKeys := make([]int64, 0)
+	values := make(map[int64]apimodel.Meal)
+	for i := range older {
+		timestamp := older[i].Time.Timestamp
+		allKeys = append(allKeys, timestamp)
+		values[timestamp] = older[i]
+	}
+
+	for i := range recent {
+		timestamp := recent[i].Time.Timestamp
+		existing, exists := values[timestamp]
+		if !exists {
+			allKeys = append(allKeys, timestamp)
+		}
+
+		// Events edited or deleted by the user win over imported ones. Otherwise, keep the greatest of conflicting
+		// elements so that the result doesn't depend on the order of imports
+		if !exists || !existing.Edited && !existing.Deleted && existing.Carbs < recent[i].Carbs {
+			values[timestamp] = recent[i]
+		}
+	}
+
+	sort.Sort(container.Int64Slice(allKeys))
+
+	reconciledMeals = make([]apimodel.Meal, len(allKeys))
+	for i := range allKeys {
+		reconciledMeals[i] = values[allKeys[i]]
+	}
+
+	return reconciledMeals
+}
+
+// GetExercises returns all Exercise entries given a user's email address and the time boundaries. Not that the boundaries are both inclusive.
+func GetExercises(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (exercises []apimodel.Exercise, err error) {
+	key := GetUserKey(context, email)
+
+	// Pad the scan so that we can capture the days overlapping with the bounds using a single column inequality filter
+	scanStart, scanEnd, err := getScanBoundaries(context, key, "DayOfExercises", lowerBound, upperBound)
+	if err != nil {
+		return nil, operationError(err, "getting exercises of %s", rangeOf(email, lowerBound, upperBound))
+	}
+
+	log.Infof(context, "Scanning for exercises between %s and %s to get exercises between %s and %s", scanStart, scanEnd, lowerBound, upperBound)
+
+	query := datastore.NewQuery("DayOfExercises").Ancestor(key).Filter("startTime >=", scanStart).Filter("startTime <=", scanEnd).Order("startTime")
+	daysOfExercises := new(apimodel.DayOfExercises)
+	exercisesForPeriod := make([]apimodel.Exercise, 0)
+
+	iterator := query.Run(context)
+	for _, err = iterator.Next(daysOfExercises); err == nil; _, err = iterator.Next(daysOfExercises) {
+		log.Debugf(context, "Loaded batch of %d exercises...", len(daysOfExercises.Exercises))
+		apimodel.ExerciseSlice(daysOfExercises.Exercises).AssignIds()
+		exercisesForPeriod = mergeExerciseArrays(exercisesForPeriod, daysOfExercises.Exercises)
+		daysOfExercises = new(apimodel.DayOfExercises)
+	}
 
 	exerciseSlice := apimodel.ExerciseSlice(exercisesForPeriod)
 	startIndex, endIndex := apimodel.GetBoundariesOfElementsInRange(exerciseSlice, lowerBound, upperBound)
 	filteredExercises := exercisesForPeriod[startIndex : endIndex+1]
 
 	if err != datastore.Done {
-		util.Propagate(err)
+		return nil, operationError(err, "getting exercises of %s", rangeOf(email, lowerBound, upperBound))
 	}
 
-	return filteredExercises, nil
+	return apimodel.ExerciseSlice(filteredExercises).WithoutDeleted(), nil
 }
 
 // StoreDaysOfExercises stores a batch of DayOfExercises elements. It is a optimized operation in that:
@@ -615,86 +1172,853 @@ func GetExercises(context context.Context, email string, lowerBound time.Time, u
 //    2. We have multiple DayOfExercises elements and we use a PutMulti to make this faster.
 // For details of how a single element of DayOfExercises is physically stored, see the implementation of apimodel.Store and apimodel.Load.
 func StoreDaysOfExercises(context context.Context, userProfileKey *datastore.Key, daysOfExercises []apimodel.DayOfExercises) (keys []*datastore.Key, err error) {
+	daysOfExercises = coalesceDaysOfExercises(daysOfExercises)
 	elementKeys := make([]*datastore.Key, len(daysOfExercises))
+	var longest time.Duration
 	for i := range daysOfExercises {
-		elementKeys[i] = datastore.NewKey(context, "DayOfExercises", "", daysOfExercises[i].StartTime.Unix(), userProfileKey)
+		elementKeys[i] = dayOfDataKey(context, "DayOfExercises", apimodel.DEFAULT_DEVICE_ID, daysOfExercises[i].StartTime, userProfileKey)
+		longest = longestSpan(longest, daysOfExercises[i].StartTime, daysOfExercises[i].EndTime)
+	}
+
+	log.Infof(context, "Emitting a PutMulti with %d keys for all %d days of exercises", len(elementKeys), len(daysOfExercises))
+	if err = runInTransaction(context, "StoreDaysOfExercises", withDataSpan(userProfileKey, "DayOfExercises", longest, daysOfExercisesReconciler(elementKeys, daysOfExercises))); err != nil {
+		log.Criticalf(context, "Error writing %d days of exercises with keys [%s]: %v", len(elementKeys), elementKeys, err)
+		return nil, err
+	}
+
+	return elementKeys, nil
+}
+
+// daysOfExercisesReconciler returns the transaction function that merges the days of exercises with the ones already
+// stored and puts the result
+func daysOfExercisesReconciler(elementKeys []*datastore.Key, freshData []apimodel.DayOfExercises) func(context context.Context) error {
+	return func(context context.Context) error {
+		reconciledData, err := reconcileDayOfExercisesWithExisting(context, elementKeys, freshData)
+		if err != nil {
+			return err
+		}
+
+		_, err = putMulti(context, elementKeys, reconciledData)
+		return err
+	}
+}
+
+func reconcileDayOfExercisesWithExisting(context context.Context, elementKeys []*datastore.Key, freshData []apimodel.DayOfExercises) (reconciledData []apimodel.DayOfExercises, err error) {
+	reconciledData = make([]apimodel.DayOfExercises, len(freshData))
+	// Merge with any pre-existing data
+	existingData := make([]apimodel.DayOfExercises, len(elementKeys))
+	err = getMulti(context, elementKeys, existingData)
+	// If there's an error and it's not a MultiError, return immediately as something went wrong
+	if multierr, ok := err.(appengine.MultiError); !ok && err != nil {
+		log.Warningf(context, "Got error: %v", err)
+		return nil, err
+	} else {
+		if err == nil {
+			for i := range existingData {
+				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Exercises), len(freshData[i].Exercises), i)
+				reconciledExercises := reconcileExercises(existingData[i].Exercises, freshData[i].Exercises)
+				log.Debugf(context, "Merged exercises ([%d]) is [%v]", len(reconciledExercises), reconciledExercises)
+				reconciledData[i] = apimodel.DayOfExercises{reconciledExercises, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime)}
+			}
+		}
+
+		for i, elementErr := range multierr {
+			if elementErr == datastore.ErrNoSuchEntity {
+				log.Debugf(context, "Keeping day of exercises for key [%s] as-is since we have no pre-existing data for it.", elementKeys[i].String())
+				reconciledData[i] = freshData[i]
+			} else {
+				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Exercises), len(freshData[i].Exercises), i)
+				reconciledExercises := reconcileExercises(existingData[i].Exercises, freshData[i].Exercises)
+				log.Debugf(context, "Merged exercises ([%d]) is [%v]", len(reconciledExercises), reconciledExercises)
+				reconciledData[i] = apimodel.DayOfExercises{reconciledExercises, existingData[i].StartTime, latestOf(existingData[i].EndTime, freshData[i].EndTime)}
+			}
+		}
+	}
+
+	return reconciledData, nil
+}
+
+func reconcileExercises(older, recent []apimodel.Exercise) (reconciledExercises []apimodel.Exercise) {
+	allKeys := make([]int64, 0)
+	values := make(map[int64]apimodel.Exercise)
+	for i := range older {
+		timestamp := older[i].Time.Timestamp
+		allKeys = append(allKeys, timestamp)
+		values[timestamp] = older[i]
+	}
+
+	for i := range recent {
+		timestamp := recent[i].Time.Timestamp
+		existing, exists := values[timestamp]
+		if !exists {
+			allKeys = append(allKeys, timestamp)
+		}
+
+		// Events edited or deleted by the user win over imported ones. Otherwise, keep the greatest of conflicting
+		// elements so that the result doesn't depend on the order of imports
+		if !exists || !existing.Edited && !existing.Deleted && existing.DurationMinutes < recent[i].DurationMinutes {
+			values[timestamp] = recent[i]
+		}
+	}
+
+	sort.Sort(container.Int64Slice(allKeys))
+
+	reconciledExercises = make([]apimodel.Exercise, len(allKeys))
+	for i := range allKeys {
+		reconciledExercises[i] = values[allKeys[i]]
+	}
+
+	return reconciledExercises
+}
+
+// StoreReadGaps stores the gaps in the reads of a user so that periods without data can be found without scanning
+// all reads. Gaps are keyed by their start time so storing a gap again overwrites it.
+func StoreReadGaps(context context.Context, userProfileKey *datastore.Key, gaps []apimodel.ReadGap) (keys []*datastore.Key, err error) {
+	elementKeys := make([]*datastore.Key, len(gaps))
+	for i := range gaps {
+		elementKeys[i] = datastore.NewKey(context, "ReadGap", "", gaps[i].StartTime.Unix(), userProfileKey)
+	}
+
+	log.Infof(context, "Emitting a PutMulti with %d keys for all %d read gaps", len(elementKeys), len(gaps))
+	if _, err = putMulti(context, elementKeys, gaps); err != nil {
+		log.Criticalf(context, "Error writing %d read gaps with keys [%s]: %v", len(elementKeys), elementKeys, err)
+		return nil, err
+	}
+
+	return elementKeys, nil
+}
+
+// GetReadGaps returns the gaps in the reads of a user that overlap with the time boundaries, in chronological order.
+// Note that the boundaries are both inclusive.
+func GetReadGaps(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (gaps []apimodel.ReadGap, err error) {
+	key := GetUserKey(context, email)
+
+	log.Infof(context, "Scanning for read gaps between %s and %s", lowerBound, upperBound)
+
+	query := datastore.NewQuery("ReadGap").Ancestor(key).Filter("endTime >=", lowerBound).Order("endTime")
+	var candidates []apimodel.ReadGap
+	if _, err = query.GetAll(context, &candidates); err != nil {
+		return nil, err
+	}
+
+	gaps = make([]apimodel.ReadGap, 0)
+	for _, gap := range candidates {
+		if !gap.StartTime.After(upperBound) {
+			gaps = append(gaps, gap)
+		}
+	}
+
+	return gaps, nil
+}
+
+// StoreHypoEvents stores hypo events. Events are keyed by their start time so that detecting an event again
+// overwrites it.
+func StoreHypoEvents(context context.Context, userProfileKey *datastore.Key, events []model.HypoEvent) (keys []*datastore.Key, err error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	elementKeys := make([]*datastore.Key, len(events))
+	for i := range events {
+		elementKeys[i] = datastore.NewKey(context, "HypoEvent", "", events[i].StartTime.Unix(), userProfileKey)
 	}
 
-	daysOfExercises, err = reconcileDayOfExercisesWithExisting(context, elementKeys, daysOfExercises)
+	log.Infof(context, "Emitting a PutMulti with [%d] keys for all [%d] hypo events", len(elementKeys), len(events))
+	keys, err = putMulti(context, elementKeys, events)
 	if err != nil {
+		log.Criticalf(context, "Error writing [%d] hypo events with keys [%s]: %v", len(elementKeys), elementKeys, err)
 		return nil, err
 	}
 
-	log.Infof(context, "Emitting a PutMulti with %d keys for all %d days of exercises", len(elementKeys), len(daysOfExercises))
-	keys, error := datastore.PutMulti(context, elementKeys, daysOfExercises)
-	if error != nil {
-		log.Criticalf(context, "Error writing %d days of exercises with keys [%s]: %v", len(elementKeys), elementKeys, error)
-		return nil, error
+	return keys, nil
+}
+
+// GetHypoEvents returns the hypo events of the given email address starting between the lower and upper bounds (both
+// inclusive), in chronological order
+func GetHypoEvents(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (events []model.HypoEvent, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("HypoEvent").Ancestor(key).
+		Filter("startTime >=", lowerBound).
+		Filter("startTime <=", upperBound).
+		Order("startTime")
+
+	events = make([]model.HypoEvent, 0)
+	if _, err = query.GetAll(context, &events); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Found [%d] hypo events between [%s] and [%s].", len(events), lowerBound, upperBound)
+	return events, nil
+}
+
+// GetMostRecentHypoEvent returns the most recent hypo event of the given email address or nil if there isn't any
+func GetMostRecentHypoEvent(context context.Context, email string) (event *model.HypoEvent, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("HypoEvent").Ancestor(key).Order("-startTime").Limit(1)
+
+	events := make([]model.HypoEvent, 0)
+	if _, err = query.GetAll(context, &events); err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	return &events[0], nil
+}
+
+// StoreDaySummaries stores day summaries. Summaries are keyed by their date so that summarizing a day again replaces it.
+func StoreDaySummaries(context context.Context, userProfileKey *datastore.Key, days []model.DaySummary) (keys []*datastore.Key, err error) {
+	if len(days) == 0 {
+		return nil, nil
+	}
+
+	elementKeys := make([]*datastore.Key, len(days))
+	for i := range days {
+		elementKeys[i] = datastore.NewKey(context, "DaySummary", "", days[i].Date.Unix(), userProfileKey)
+	}
+
+	log.Infof(context, "Emitting a PutMulti with [%d] keys for all [%d] day summaries", len(elementKeys), len(days))
+	keys, err = putMulti(context, elementKeys, days)
+	if err != nil {
+		log.Criticalf(context, "Error writing [%d] day summaries with keys [%s]: %v", len(elementKeys), elementKeys, err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetDaySummaries returns the day summaries of the given email address whose date is between the lower and upper bounds
+// (both inclusive), in chronological order
+func GetDaySummaries(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (days []model.DaySummary, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("DaySummary").Ancestor(key).
+		Filter("date >=", lowerBound).
+		Filter("date <=", upperBound).
+		Order("date")
+
+	days = make([]model.DaySummary, 0)
+	if _, err = query.GetAll(context, &days); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Found [%d] day summaries between [%s] and [%s].", len(days), lowerBound, upperBound)
+	return days, nil
+}
+
+// StoreDawnAnalysis stores a dawn analysis. Analyses are keyed by the time they were calculated on so that previous ones
+// are kept.
+func StoreDawnAnalysis(context context.Context, userProfileKey *datastore.Key, analysis model.DawnAnalysis) (key *datastore.Key, err error) {
+	key = datastore.NewKey(context, "DawnAnalysis", "", analysis.CalculatedOn.Unix(), userProfileKey)
+
+	log.Infof(context, "Emitting a Put for dawn analysis with key [%s]", key)
+	if key, err = put(context, key, &analysis); err != nil {
+		log.Criticalf(context, "Error storing dawn analysis with key [%s]: %v", key, err)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// GetMostRecentDawnAnalysis returns the most recent dawn analysis of the given email address or nil if there isn't any
+func GetMostRecentDawnAnalysis(context context.Context, email string) (analysis *model.DawnAnalysis, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("DawnAnalysis").Ancestor(key).Order("-calculatedOn").Limit(1)
+
+	analyses := make([]model.DawnAnalysis, 0)
+	if _, err = query.GetAll(context, &analyses); err != nil {
+		return nil, err
+	}
+
+	if len(analyses) == 0 {
+		return nil, nil
+	}
+
+	return &analyses[0], nil
+}
+
+// StoreMealResponses stores meal responses. Responses are keyed by the time of their meal so that analyzing a meal again
+// overwrites its response.
+func StoreMealResponses(context context.Context, userProfileKey *datastore.Key, responses []model.MealResponse) (keys []*datastore.Key, err error) {
+	if len(responses) == 0 {
+		return nil, nil
+	}
+
+	elementKeys := make([]*datastore.Key, len(responses))
+	for i := range responses {
+		elementKeys[i] = datastore.NewKey(context, "MealResponse", "", responses[i].MealTime.Unix(), userProfileKey)
+	}
+
+	log.Infof(context, "Emitting a PutMulti with [%d] keys for all [%d] meal responses", len(elementKeys), len(responses))
+	keys, err = putMulti(context, elementKeys, responses)
+	if err != nil {
+		log.Criticalf(context, "Error writing [%d] meal responses with keys [%s]: %v", len(elementKeys), elementKeys, err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetMealResponses returns the meal responses of the given email address for meals between the lower and upper bounds
+// (both inclusive), in chronological order
+func GetMealResponses(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (responses []model.MealResponse, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("MealResponse").Ancestor(key).
+		Filter("mealTime >=", lowerBound).
+		Filter("mealTime <=", upperBound).
+		Order("mealTime")
+
+	responses = make([]model.MealResponse, 0)
+	if _, err = query.GetAll(context, &responses); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Found [%d] meal responses between [%s] and [%s].", len(responses), lowerBound, upperBound)
+	return responses, nil
+}
+
+// StoreExerciseImpacts stores exercise impacts. Impacts are keyed by the start time of their session so that analyzing
+// a session again overwrites its impact.
+func StoreExerciseImpacts(context context.Context, userProfileKey *datastore.Key, impacts []model.ExerciseImpact) (keys []*datastore.Key, err error) {
+	if len(impacts) == 0 {
+		return nil, nil
+	}
+
+	elementKeys := make([]*datastore.Key, len(impacts))
+	for i := range impacts {
+		elementKeys[i] = datastore.NewKey(context, "ExerciseImpact", "", impacts[i].StartTime.Unix(), userProfileKey)
+	}
+
+	log.Infof(context, "Emitting a PutMulti with [%d] keys for all [%d] exercise impacts", len(elementKeys), len(impacts))
+	keys, err = putMulti(context, elementKeys, impacts)
+	if err != nil {
+		log.Criticalf(context, "Error writing [%d] exercise impacts with keys [%s]: %v", len(elementKeys), elementKeys, err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetExerciseImpacts returns the exercise impacts of the given email address for sessions starting between the lower and
+// upper bounds (both inclusive), in chronological order
+func GetExerciseImpacts(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (impacts []model.ExerciseImpact, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("ExerciseImpact").Ancestor(key).
+		Filter("startTime >=", lowerBound).
+		Filter("startTime <=", upperBound).
+		Order("startTime")
+
+	impacts = make([]model.ExerciseImpact, 0)
+	if _, err = query.GetAll(context, &impacts); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Found [%d] exercise impacts between [%s] and [%s].", len(impacts), lowerBound, upperBound)
+	return impacts, nil
+}
+
+// StoreWeeklySummary stores a weekly summary. Summaries are keyed by the start of their week so that storing the summary
+// of a week again replaces it.
+func StoreWeeklySummary(context context.Context, userProfileKey *datastore.Key, summary model.WeeklySummary) (key *datastore.Key, err error) {
+	key = datastore.NewKey(context, "WeeklySummary", "", summary.WeekStart.Unix(), userProfileKey)
+
+	log.Infof(context, "Emitting a Put for weekly summary with key [%s]", key)
+	if key, err = put(context, key, &summary); err != nil {
+		log.Criticalf(context, "Error storing weekly summary with key [%s]: %v", key, err)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// GetWeeklySummary returns the summary of the week starting at weekStart of the given email address or nil if there isn't any
+func GetWeeklySummary(context context.Context, email string, weekStart time.Time) (summary *model.WeeklySummary, err error) {
+	key := datastore.NewKey(context, "WeeklySummary", "", weekStart.Unix(), GetUserKey(context, email))
+
+	summary = new(model.WeeklySummary)
+	if err := get(context, key, summary); err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// GetWeeklySummaries returns the weekly summaries of the given email address of the weeks starting between the lower and
+// upper bounds (both inclusive), most recent first
+func GetWeeklySummaries(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (summaries []model.WeeklySummary, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("WeeklySummary").Ancestor(key).
+		Filter("weekStart >=", lowerBound).
+		Filter("weekStart <=", upperBound).
+		Order("-weekStart")
+
+	summaries = make([]model.WeeklySummary, 0)
+	if _, err = query.GetAll(context, &summaries); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Found [%d] weekly summaries between [%s] and [%s].", len(summaries), lowerBound, upperBound)
+	return summaries, nil
+}
+
+// StoreNights stores nights. Nights are keyed by their date so that analyzing a night again replaces it.
+func StoreNights(context context.Context, userProfileKey *datastore.Key, nights []model.Night) (keys []*datastore.Key, err error) {
+	if len(nights) == 0 {
+		return nil, nil
+	}
+
+	elementKeys := make([]*datastore.Key, len(nights))
+	for i := range nights {
+		elementKeys[i] = datastore.NewKey(context, "Night", "", nights[i].Date.Unix(), userProfileKey)
+	}
+
+	log.Infof(context, "Emitting a PutMulti with [%d] keys for all [%d] nights", len(elementKeys), len(nights))
+	keys, err = putMulti(context, elementKeys, nights)
+	if err != nil {
+		log.Criticalf(context, "Error writing [%d] nights with keys [%s]: %v", len(elementKeys), elementKeys, err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetNights returns the nights of the given email address whose date is between the lower and upper bounds (both
+// inclusive), in chronological order
+func GetNights(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (nights []model.Night, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("Night").Ancestor(key).
+		Filter("date >=", lowerBound).
+		Filter("date <=", upperBound).
+		Order("date")
+
+	nights = make([]model.Night, 0)
+	if _, err = query.GetAll(context, &nights); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Found [%d] nights between [%s] and [%s].", len(nights), lowerBound, upperBound)
+	return nights, nil
+}
+
+// dayOfNotesKey returns the key of the day of notes starting at dayStart
+func dayOfNotesKey(context context.Context, userProfileKey *datastore.Key, dayStart time.Time) *datastore.Key {
+	return datastore.NewKey(context, "DayOfNotes", "", dayStart.Unix(), userProfileKey)
+}
+
+// StoreNote adds a note to its day of notes and returns it with the id it was given. Ids are sequential within a day of
+// notes so that they stay stable when other notes of the day are edited or deleted.
+func StoreNote(context context.Context, userProfileKey *datastore.Key, note apimodel.Note) (storedNote apimodel.Note, err error) {
+	dayStart := apimodel.DayOfNoteStart(note.GetTime())
+	key := dayOfNotesKey(context, userProfileKey, dayStart)
+
+	if err = runInTransaction(context, "StoreNote", noteAdder(key, dayStart, &note)); err != nil {
+		log.Criticalf(context, "Error writing note [%v] with key [%s]: %v", note, key, err)
+		return note, err
+	}
+
+	return note, nil
+}
+
+// noteAdder returns the transaction function that adds note to its day of notes, setting the id it was given
+func noteAdder(key *datastore.Key, dayStart time.Time, note *apimodel.Note) func(context context.Context) error {
+	return func(context context.Context) error {
+		dayOfNotes := new(apimodel.DayOfNotes)
+		if err := get(context, key, dayOfNotes); err == datastore.ErrNoSuchEntity {
+			*dayOfNotes = apimodel.NewDayOfNotes(dayStart)
+		} else if err != nil {
+			return err
+		}
+
+		*note = dayOfNotes.Add(*note)
+		_, err := put(context, key, dayOfNotes)
+		return err
+	}
+}
+
+// UpdateNote replaces the text and tag of the note with the same id. The time of a note can't change as it determines
+// the day of notes it's in. ErrNoteNotFound is returned if there's no such note.
+func UpdateNote(context context.Context, userProfileKey *datastore.Key, note apimodel.Note) (err error) {
+	dayStart, err := apimodel.ParseNoteId(note.Id)
+	if err != nil {
+		return err
+	}
+
+	key := dayOfNotesKey(context, userProfileKey, dayStart)
+	return runInTransaction(context, "UpdateNote", noteUpdater(key, note))
+}
+
+// noteUpdater returns the transaction function that replaces the text and tag of the note with the same id
+func noteUpdater(key *datastore.Key, note apimodel.Note) func(context context.Context) error {
+	return func(context context.Context) error {
+		dayOfNotes := new(apimodel.DayOfNotes)
+		if err := get(context, key, dayOfNotes); err == datastore.ErrNoSuchEntity {
+			return ErrNoteNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if !dayOfNotes.Update(note) {
+			return ErrNoteNotFound
+		}
+
+		_, err := put(context, key, dayOfNotes)
+		return err
+	}
+}
+
+// DeleteNote removes the note with the given id. ErrNoteNotFound is returned if there's no such note.
+func DeleteNote(context context.Context, userProfileKey *datastore.Key, id string) (err error) {
+	dayStart, err := apimodel.ParseNoteId(id)
+	if err != nil {
+		return err
+	}
+
+	key := dayOfNotesKey(context, userProfileKey, dayStart)
+	return runInTransaction(context, "DeleteNote", noteDeleter(key, id))
+}
+
+// noteDeleter returns the transaction function that removes the note with the given id from its day of notes. The day
+// of notes is kept even when empty so that ids of deleted notes are never given again.
+func noteDeleter(key *datastore.Key, id string) func(context context.Context) error {
+	return func(context context.Context) error {
+		dayOfNotes := new(apimodel.DayOfNotes)
+		if err := get(context, key, dayOfNotes); err == datastore.ErrNoSuchEntity {
+			return ErrNoteNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if !dayOfNotes.Remove(id) {
+			return ErrNoteNotFound
+		}
+
+		_, err := put(context, key, dayOfNotes)
+		return err
+	}
+}
+
+// UpdateMeal replaces the meal with the same id, keeping its time. ErrEventNotFound is returned if there's no such meal.
+func UpdateMeal(context context.Context, userProfileKey *datastore.Key, meal apimodel.Meal) (err error) {
+	eventTime, err := apimodel.ParseEventId(meal.Id)
+	if err != nil {
+		return err
+	}
+
+	key := dayOfDataKey(context, "DayOfMeals", apimodel.DEFAULT_DEVICE_ID, apimodel.DayOfEventStart(eventTime), userProfileKey)
+	return runInTransaction(context, "UpdateMeal", mealUpdater(key, meal))
+}
+
+// mealUpdater returns the transaction function that replaces the meal with the same id in its day of meals
+func mealUpdater(key *datastore.Key, meal apimodel.Meal) func(context context.Context) error {
+	return func(context context.Context) error {
+		dayOfMeals := new(apimodel.DayOfMeals)
+		if err := get(context, key, dayOfMeals); err == datastore.ErrNoSuchEntity {
+			return ErrEventNotFound
+		} else if err != nil {
+			return err
+		}
+
+		apimodel.MealSlice(dayOfMeals.Meals).AssignIds()
+		if !dayOfMeals.Update(meal) {
+			return ErrEventNotFound
+		}
+
+		_, err := put(context, key, dayOfMeals)
+		return err
+	}
+}
+
+// DeleteMeal flags the meal with the given id as deleted. ErrEventNotFound is returned if there's no such meal.
+func DeleteMeal(context context.Context, userProfileKey *datastore.Key, id string) (err error) {
+	eventTime, err := apimodel.ParseEventId(id)
+	if err != nil {
+		return err
+	}
+
+	key := dayOfDataKey(context, "DayOfMeals", apimodel.DEFAULT_DEVICE_ID, apimodel.DayOfEventStart(eventTime), userProfileKey)
+	return runInTransaction(context, "DeleteMeal", mealDeleter(key, id))
+}
+
+// mealDeleter returns the transaction function that flags the meal with the given id as deleted in its day of meals
+func mealDeleter(key *datastore.Key, id string) func(context context.Context) error {
+	return func(context context.Context) error {
+		dayOfMeals := new(apimodel.DayOfMeals)
+		if err := get(context, key, dayOfMeals); err == datastore.ErrNoSuchEntity {
+			return ErrEventNotFound
+		} else if err != nil {
+			return err
+		}
+
+		apimodel.MealSlice(dayOfMeals.Meals).AssignIds()
+		if !dayOfMeals.Delete(id) {
+			return ErrEventNotFound
+		}
+
+		_, err := put(context, key, dayOfMeals)
+		return err
+	}
+}
+
+// UpdateInjection replaces the injection with the same id, keeping its time. ErrEventNotFound is returned if there's no such injection.
+func UpdateInjection(context context.Context, userProfileKey *datastore.Key, injection apimodel.Injection) (err error) {
+	eventTime, err := apimodel.ParseEventId(injection.Id)
+	if err != nil {
+		return err
+	}
+
+	key := dayOfDataKey(context, "DayOfInjections", apimodel.DEFAULT_DEVICE_ID, apimodel.DayOfEventStart(eventTime), userProfileKey)
+	return runInTransaction(context, "UpdateInjection", injectionUpdater(key, injection))
+}
+
+// injectionUpdater returns the transaction function that replaces the injection with the same id in its day of injections
+func injectionUpdater(key *datastore.Key, injection apimodel.Injection) func(context context.Context) error {
+	return func(context context.Context) error {
+		dayOfInjections := new(apimodel.DayOfInjections)
+		if err := get(context, key, dayOfInjections); err == datastore.ErrNoSuchEntity {
+			return ErrEventNotFound
+		} else if err != nil {
+			return err
+		}
+
+		apimodel.InjectionSlice(dayOfInjections.Injections).AssignIds()
+		if !dayOfInjections.Update(injection) {
+			return ErrEventNotFound
+		}
+
+		_, err := put(context, key, dayOfInjections)
+		return err
+	}
+}
+
+// DeleteInjection flags the injection with the given id as deleted. ErrEventNotFound is returned if there's no such injection.
+func DeleteInjection(context context.Context, userProfileKey *datastore.Key, id string) (err error) {
+	eventTime, err := apimodel.ParseEventId(id)
+	if err != nil {
+		return err
+	}
+
+	key := dayOfDataKey(context, "DayOfInjections", apimodel.DEFAULT_DEVICE_ID, apimodel.DayOfEventStart(eventTime), userProfileKey)
+	return runInTransaction(context, "DeleteInjection", injectionDeleter(key, id))
+}
+
+// injectionDeleter returns the transaction function that flags the injection with the given id as deleted in its day of injections
+func injectionDeleter(key *datastore.Key, id string) func(context context.Context) error {
+	return func(context context.Context) error {
+		dayOfInjections := new(apimodel.DayOfInjections)
+		if err := get(context, key, dayOfInjections); err == datastore.ErrNoSuchEntity {
+			return ErrEventNotFound
+		} else if err != nil {
+			return err
+		}
+
+		apimodel.InjectionSlice(dayOfInjections.Injections).AssignIds()
+		if !dayOfInjections.Delete(id) {
+			return ErrEventNotFound
+		}
+
+		_, err := put(context, key, dayOfInjections)
+		return err
+	}
+}
+
+// UpdateExercise replaces the exercise with the same id, keeping its time. ErrEventNotFound is returned if there's no such exercise.
+func UpdateExercise(context context.Context, userProfileKey *datastore.Key, exercise apimodel.Exercise) (err error) {
+	eventTime, err := apimodel.ParseEventId(exercise.Id)
+	if err != nil {
+		return err
+	}
+
+	key := dayOfDataKey(context, "DayOfExercises", apimodel.DEFAULT_DEVICE_ID, apimodel.DayOfEventStart(eventTime), userProfileKey)
+	return runInTransaction(context, "UpdateExercise", exerciseUpdater(key, exercise))
+}
+
+// exerciseUpdater returns the transaction function that replaces the exercise with the same id in its day of exercises
+func exerciseUpdater(key *datastore.Key, exercise apimodel.Exercise) func(context context.Context) error {
+	return func(context context.Context) error {
+		dayOfExercises := new(apimodel.DayOfExercises)
+		if err := get(context, key, dayOfExercises); err == datastore.ErrNoSuchEntity {
+			return ErrEventNotFound
+		} else if err != nil {
+			return err
+		}
+
+		apimodel.ExerciseSlice(dayOfExercises.Exercises).AssignIds()
+		if !dayOfExercises.Update(exercise) {
+			return ErrEventNotFound
+		}
+
+		_, err := put(context, key, dayOfExercises)
+		return err
+	}
+}
+
+// DeleteExercise flags the exercise with the given id as deleted. ErrEventNotFound is returned if there's no such exercise.
+func DeleteExercise(context context.Context, userProfileKey *datastore.Key, id string) (err error) {
+	eventTime, err := apimodel.ParseEventId(id)
+	if err != nil {
+		return err
+	}
+
+	key := dayOfDataKey(context, "DayOfExercises", apimodel.DEFAULT_DEVICE_ID, apimodel.DayOfEventStart(eventTime), userProfileKey)
+	return runInTransaction(context, "DeleteExercise", exerciseDeleter(key, id))
+}
+
+// exerciseDeleter returns the transaction function that flags the exercise with the given id as deleted in its day of exercises
+func exerciseDeleter(key *datastore.Key, id string) func(context context.Context) error {
+	return func(context context.Context) error {
+		dayOfExercises := new(apimodel.DayOfExercises)
+		if err := get(context, key, dayOfExercises); err == datastore.ErrNoSuchEntity {
+			return ErrEventNotFound
+		} else if err != nil {
+			return err
+		}
+
+		apimodel.ExerciseSlice(dayOfExercises.Exercises).AssignIds()
+		if !dayOfExercises.Delete(id) {
+			return ErrEventNotFound
+		}
+
+		_, err := put(context, key, dayOfExercises)
+		return err
+	}
+}
+
+// GetNotes returns the notes of the given email address between the lower and upper bounds (both inclusive), in
+// chronological order
+func GetNotes(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (notes []apimodel.Note, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("DayOfNotes").Ancestor(key).
+		Filter("startTime >=", apimodel.DayOfNoteStart(lowerBound)).
+		Filter("startTime <=", upperBound).
+		Order("startTime")
+
+	var daysOfNotes []apimodel.DayOfNotes
+	if _, err = query.GetAll(context, &daysOfNotes); err != nil {
+		return nil, err
+	}
+
+	notes = make([]apimodel.Note, 0)
+	for _, dayOfNotes := range daysOfNotes {
+		for _, note := range dayOfNotes.Notes {
+			if !note.GetTime().Before(lowerBound) && !note.GetTime().After(upperBound) {
+				notes = append(notes, note)
+			}
+		}
+	}
+
+	log.Infof(context, "Found [%d] notes between [%s] and [%s].", len(notes), lowerBound, upperBound)
+	return notes, nil
+}
+
+// StoreDaysOfStats stores the stats of days of reads of a device, keyed like the days of reads they're computed from.
+// Stats of reads following the ones already stored for a day are merged with them. Otherwise, the reads were imported
+// again and the stored stats are replaced.
+func StoreDaysOfStats(context context.Context, userProfileKey *datastore.Key, deviceId string, daysOfStats []apimodel.DayOfStats) (keys []*datastore.Key, err error) {
+	elementKeys := make([]*datastore.Key, len(daysOfStats))
+	for i := range daysOfStats {
+		daysOfStats[i].DeviceId = deviceId
+		elementKeys[i] = dayOfDataKey(context, "DayOfStats", deviceId, daysOfStats[i].StartTime, userProfileKey)
+	}
+
+	log.Infof(context, "Emitting a PutMulti with %d keys for all %d days of stats", len(elementKeys), len(daysOfStats))
+	if err = runInTransaction(context, "StoreDaysOfStats", daysOfStatsReconciler(elementKeys, daysOfStats)); err != nil {
+		log.Warningf(context, "Error writing %d days of stats with keys [%s]: %v", len(elementKeys), elementKeys, err)
+		return nil, err
 	}
 
 	return elementKeys, nil
 }
 
-func reconcileDayOfExercisesWithExisting(context context.Context, elementKeys []*datastore.Key, freshData []apimodel.DayOfExercises) (reconciledData []apimodel.DayOfExercises, err error) {
-	reconciledData = make([]apimodel.DayOfExercises, len(freshData))
-	// Merge with any pre-existing data
-	existingData := make([]apimodel.DayOfExercises, len(elementKeys))
-	err = datastore.GetMulti(context, elementKeys, existingData)
-	// If there's an error and it's not a MultiError, return immediately as something went wrong
-	if multierr, ok := err.(appengine.MultiError); !ok && err != nil {
-		log.Warningf(context, "Got error: %v", err)
-		return nil, err
-	} else {
-		if err == nil {
-			for i := range existingData {
-				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Exercises), len(freshData[i].Exercises), i)
-				reconciledExercises := reconcileExercises(existingData[i].Exercises, freshData[i].Exercises)
-				log.Debugf(context, "Merged exercises ([%d]) is [%v]", len(reconciledExercises), reconciledExercises)
-				reconciledData[i] = apimodel.DayOfExercises{reconciledExercises, existingData[i].StartTime, freshData[i].EndTime}
-			}
+// daysOfStatsReconciler returns the transaction function that merges the days of stats with the ones already stored and
+// puts the result
+func daysOfStatsReconciler(elementKeys []*datastore.Key, freshData []apimodel.DayOfStats) func(context context.Context) error {
+	return func(context context.Context) error {
+		existingData := make([]apimodel.DayOfStats, len(elementKeys))
+		err := getMulti(context, elementKeys, existingData)
+		multierr, ok := err.(appengine.MultiError)
+		if !ok && err != nil {
+			return err
 		}
 
-		for i, elementErr := range multierr {
-			if elementErr == datastore.ErrNoSuchEntity {
-				log.Debugf(context, "Keeping day of exercises for key [%s] as-is since we have no pre-existing data for it.", elementKeys[i].String())
-				reconciledData[i] = freshData[i]
-			} else {
-				log.Debugf(context, "Merging old ([%d]) with new ([%d]) at index [%d]", len(existingData[i].Exercises), len(freshData[i].Exercises), i)
-				reconciledExercises := reconcileExercises(existingData[i].Exercises, freshData[i].Exercises)
-				log.Debugf(context, "Merged exercises ([%d]) is [%v]", len(reconciledExercises), reconciledExercises)
-				reconciledData[i] = apimodel.DayOfExercises{reconciledExercises, existingData[i].StartTime, freshData[i].EndTime}
+		reconciledData := make([]apimodel.DayOfStats, len(freshData))
+		for i := range freshData {
+			reconciledData[i] = freshData[i]
+			if (multierr == nil || multierr[i] == nil) && existingData[i].EndTime.Before(freshData[i].FirstReadTime) {
+				reconciledData[i] = existingData[i].Merge(freshData[i])
 			}
 		}
-	}
 
-	return reconciledData, nil
+		_, err = putMulti(context, elementKeys, reconciledData)
+		return err
+	}
 }
 
-func reconcileExercises(older, recent []apimodel.Exercise) (reconciledExercises []apimodel.Exercise) {
-	allKeys := make([]int64, 0)
-	values := make(map[int64]apimodel.Exercise)
-	for i := range older {
-		timestamp := older[i].Time.Timestamp
-		allKeys = append(allKeys, timestamp)
-		values[timestamp] = older[i]
+// RefreshDaysOfStats computes the stats of the days of reads of a user starting on or after since again with the given
+// thresholds, in mg/dL. This replaces stats computed with previous thresholds.
+func RefreshDaysOfStats(context context.Context, email string, since time.Time, lowThreshold, highThreshold float32) (count int, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("DayOfReads").Ancestor(key).Filter("startTime >=", since.Truncate(apimodel.DAY_OF_DATA_DURATION)).Order("startTime")
+	elementKeys := make([]*datastore.Key, 0, GLUKIT_SCORE_PUT_MULTI_SIZE)
+	daysOfStats := make([]apimodel.DayOfStats, 0, GLUKIT_SCORE_PUT_MULTI_SIZE)
+	dayOfReads := new(apimodel.DayOfGlucoseReads)
+
+	iterator := query.Run(context)
+	for _, err = iterator.Next(dayOfReads); err == nil; _, err = iterator.Next(dayOfReads) {
+		elementKeys = append(elementKeys, dayOfDataKey(context, "DayOfStats", dayOfReads.DeviceId, dayOfReads.StartTime, key))
+		daysOfStats = append(daysOfStats, apimodel.NewDayOfStats(*dayOfReads, lowThreshold, highThreshold))
+		dayOfReads = new(apimodel.DayOfGlucoseReads)
+
+		if len(elementKeys) == GLUKIT_SCORE_PUT_MULTI_SIZE {
+			if _, err := putMulti(context, elementKeys, daysOfStats); err != nil {
+				return count, err
+			}
+			count += len(elementKeys)
+			elementKeys, daysOfStats = elementKeys[:0], daysOfStats[:0]
+		}
 	}
 
-	for i := range recent {
-		timestamp := recent[i].Time.Timestamp
-		if _, exists := values[timestamp]; !exists {
-			allKeys = append(allKeys, timestamp)
+	if err != datastore.Done {
+		return count, err
+	}
+
+	if len(elementKeys) > 0 {
+		if _, err := putMulti(context, elementKeys, daysOfStats); err != nil {
+			return count, err
 		}
-		values[timestamp] = recent[i]
+		count += len(elementKeys)
 	}
 
-	sort.Sort(container.Int64Slice(allKeys))
+	log.Infof(context, "Refreshed [%d] days of stats since [%s] with thresholds [%f] and [%f]", count, since, lowThreshold, highThreshold)
+	return count, nil
+}
 
-	reconciledExercises = make([]apimodel.Exercise, len(allKeys))
-	for i := range allKeys {
-		reconciledExercises[i] = values[allKeys[i]]
+// GetDaysOfStats returns the stats of the days of reads of a user starting between the time boundaries, from all
+// devices. Note that the boundaries are both inclusive.
+func GetDaysOfStats(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (daysOfStats []apimodel.DayOfStats, err error) {
+	key := GetUserKey(context, email)
+
+	log.Infof(context, "Scanning for days of stats between %s and %s", lowerBound, upperBound)
+	query := datastore.NewQuery("DayOfStats").Ancestor(key).Filter("startTime >=", lowerBound).Filter("startTime <=", upperBound).Order("startTime")
+
+	daysOfStats = make([]apimodel.DayOfStats, 0)
+	if _, err = query.GetAll(context, &daysOfStats); err != nil {
+		return nil, err
 	}
 
-	return reconciledExercises
+	return daysOfStats, nil
 }
 
 // LogFileImport persist a log of a file import operation. A log entry is actually kept for each distinct file and NOT for every log import
@@ -704,7 +2028,7 @@ func LogFileImport(context context.Context, userProfileKey *datastore.Key, fileI
 	key = datastore.NewKey(context, "FileImportLog", fileImport.Id, 0, userProfileKey)
 
 	log.Infof(context, "Emitting a Put for file import log with key [%s] for file id [%s]", key, fileImport.Id)
-	key, err = datastore.Put(context, key, &fileImport)
+	err = runInTransaction(context, "LogFileImport", fileImportLogger(key, fileImport))
 	if err != nil {
 		log.Criticalf(context, "Error storing file import log with key [%s] for file id [%s]: %v", key, fileImport.Id, err)
 		return nil, err
@@ -713,6 +2037,22 @@ func LogFileImport(context context.Context, userProfileKey *datastore.Key, fileI
 	return key, nil
 }
 
+// fileImportLogger returns the transaction function that stores the file import log. The time of the last data processed
+// only moves forward so that an import of the same file that completes last with older data doesn't move it back.
+func fileImportLogger(key *datastore.Key, fileImport model.FileImportLog) func(context context.Context) error {
+	return func(context context.Context) error {
+		existing := new(model.FileImportLog)
+		if err := get(context, key, existing); err == nil {
+			fileImport.LastDataProcessed = latestOf(existing.LastDataProcessed, fileImport.LastDataProcessed)
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		_, err := put(context, key, &fileImport)
+		return err
+	}
+}
+
 // GetFileImportLog retrieves a FileImportLog entry for a given file id. If it's the first time we import this file id, a zeroed FileImportLog
 // element is returned
 func GetFileImportLog(context context.Context, userProfileKey *datastore.Key, fileId string) (fileImport *model.FileImportLog, err error) {
@@ -720,7 +2060,7 @@ func GetFileImportLog(context context.Context, userProfileKey *datastore.Key, fi
 
 	log.Infof(context, "Reading file import log for file id [%s]", fileId)
 	fileImport = new(model.FileImportLog)
-	error := datastore.Get(context, key, fileImport)
+	error := get(context, key, fileImport)
 	if error != nil {
 		return nil, error
 	}
@@ -728,6 +2068,21 @@ func GetFileImportLog(context context.Context, userProfileKey *datastore.Key, fi
 	return fileImport, nil
 }
 
+// GetFileImportLogs returns the most recent FileImportLog entries of a user, ordered by the time of the last data processed
+// in descending order. At most limit entries are returned.
+func GetFileImportLogs(context context.Context, userProfileKey *datastore.Key, limit int) (fileImports []model.FileImportLog, err error) {
+	log.Infof(context, "Reading up to [%d] file import logs for user [%s]", limit, userProfileKey)
+
+	query := datastore.NewQuery("FileImportLog").Ancestor(userProfileKey).Order("-LastDataProcessed").Limit(limit)
+
+	fileImports = make([]model.FileImportLog, 0)
+	if _, err = query.GetAll(context, &fileImports); err != nil {
+		return nil, err
+	}
+
+	return fileImports, nil
+}
+
 func GetGlukitUser(context context.Context, email string) (key *datastore.Key, userProfile *model.GlukitUser, err error) {
 	key = GetUserKey(context, email)
 	userProfile, err = GetGlukitUserWithKey(context, key)
@@ -735,7 +2090,7 @@ func GetGlukitUser(context context.Context, email string) (key *datastore.Key, u
 }
 
 func GetGlukitUserWithKey(context context.Context, key *datastore.Key) (userProfile *model.GlukitUser, err error) {
-	userProfile, err = GetUserProfile(context, key)
+	userProfile, err = GetUserProfileCached(context, key)
 	if err != nil {
 		return nil, err
 	}
@@ -743,11 +2098,12 @@ func GetGlukitUserWithKey(context context.Context, key *datastore.Key) (userProf
 	return userProfile, nil
 }
 
-// GetUserData returns a GlukitUser entry and the boundaries of its most recent complete reads.
+// GetUserData returns a GlukitUser entry and the boundaries of its most recent complete reads. The upper bound is in
+// the timezone of the user, see userTimezone, so that the day boundaries derived from it are the user's days.
 // If the user doesn't have any imported data yet, GetUserData returns ErrNoImportedDataFound
 func GetUserData(context context.Context, email string) (userProfile *model.GlukitUser, key *datastore.Key, upperBound time.Time, err error) {
 	key = GetUserKey(context, email)
-	userProfile, err = GetUserProfile(context, key)
+	userProfile, err = GetUserProfileCached(context, key)
 	if err != nil {
 		return nil, nil, util.GLUKIT_EPOCH_TIME, err
 	}
@@ -756,8 +2112,87 @@ func GetUserData(context context.Context, email string) (userProfile *model.Gluk
 	if util.GLUKIT_EPOCH_TIME.Equal(userProfile.MostRecentRead.GetTime()) {
 		return userProfile, key, util.GLUKIT_EPOCH_TIME, ErrNoImportedDataFound
 	} else {
-		return userProfile, key, userProfile.MostRecentRead.GetTime(), nil
+		return userProfile, key, userProfile.MostRecentRead.GetTime().In(util.LocationOrUTC(userTimezone(userProfile))), nil
+	}
+}
+
+// userTimezone returns the name of the timezone the user set or, if they didn't, the one of their most recent read
+func userTimezone(userProfile *model.GlukitUser) string {
+	if len(userProfile.Timezone) > 0 {
+		return userProfile.Timezone
+	}
+
+	return userProfile.MostRecentRead.Time.TimeZoneId
+}
+
+// ListUsers returns a page of at most limit user profiles, starting at cursor (or the first one if empty). The cursor
+// of the next page is returned, or an empty one if this is the last page.
+func ListUsers(context context.Context, cursor string, limit int) (users []model.GlukitUser, nextCursor string, err error) {
+	query := datastore.NewQuery("GlukitUser").Limit(limit)
+	if len(cursor) > 0 {
+		start, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Start(start)
+	}
+
+	users = make([]model.GlukitUser, 0, limit)
+	iterator := query.Run(context)
+	for {
+		var user model.GlukitUser
+		_, err := iterator.Next(&user)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			return nil, "", err
+		}
+
+		users = append(users, user)
+	}
+
+	// A partial page means we've reached the end
+	if len(users) < limit {
+		return users, "", nil
+	}
+
+	next, err := iterator.Cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return users, next.String(), nil
+}
+
+// DeleteUserData deletes all the entities stored under the user profile of the given email address, keeping the
+// profile itself. The number of entities deleted is returned.
+func DeleteUserData(context context.Context, email string) (count int, err error) {
+	userProfileKey := GetUserKey(context, email)
+	for {
+		keys, err := datastore.NewQuery("").Ancestor(userProfileKey).KeysOnly().Limit(DELETE_BATCH_SIZE).GetAll(context, nil)
+		if err != nil {
+			return count, err
+		}
+
+		descendants := make([]*datastore.Key, 0, len(keys))
+		for _, key := range keys {
+			if !key.Equal(userProfileKey) {
+				descendants = append(descendants, key)
+			}
+		}
+
+		if len(descendants) == 0 {
+			break
+		}
+
+		log.Infof(context, "Emitting a DeleteMulti with [%d] keys of user [%s]", len(descendants), email)
+		if err := deleteMulti(context, descendants); err != nil {
+			return count, err
+		}
+		count += len(descendants)
 	}
+
+	return count, nil
 }
 
 // FindSteadySailor queries the datastore for others users of the same type of diabetes. It will then select the match that
@@ -820,7 +2255,7 @@ func FindSteadySailor(context context.Context, recipientEmail string) (sailorPro
 		return nil, nil, util.GLUKIT_EPOCH_TIME, ErrNoSteadySailorMatchFound
 	} else {
 		log.Infof(context, "Found a steady sailor match for user [%s]: healthy [%s]", recipientEmail, sailorProfile.Email)
-		upperBound = util.GetEndOfDayBoundaryBefore(sailorProfile.MostRecentRead.GetTime())
+		upperBound = util.EndOfDayBoundaryBeforeIn(sailorProfile.MostRecentRead.GetTime(), userTimezone(sailorProfile))
 		return sailorProfile, GetUserKey(context, sailorProfile.Email), upperBound, nil
 	}
 }
@@ -834,7 +2269,9 @@ func StoreGlukitScoreBatch(context context.Context, userEmail string, glukitScor
 	for chunkStartIndex := 0; chunkStartIndex < len(glukitScores); chunkStartIndex = chunkStartIndex + GLUKIT_SCORE_PUT_MULTI_SIZE {
 		chunkEndIndex := int(math.Min(float64(chunkStartIndex+GLUKIT_SCORE_PUT_MULTI_SIZE), totalBatchSize))
 		glukitScoreChunk := glukitScores[chunkStartIndex:chunkEndIndex]
-		storeGlukitScoreChunk(context, parentKey, glukitScoreChunk)
+		if _, err := storeGlukitScoreChunk(context, parentKey, glukitScoreChunk); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -849,7 +2286,7 @@ func storeGlukitScoreChunk(context context.Context, parentKey *datastore.Key, gl
 	}
 
 	log.Infof(context, "Emitting a PutMulti with [%d] keys for all [%d] glukit scores of chunk", len(elementKeys), len(glukitScoreChunk))
-	keys, error := datastore.PutMulti(context, elementKeys, glukitScoreChunk)
+	keys, error := putMulti(context, elementKeys, glukitScoreChunk)
 	if error != nil {
 		log.Criticalf(context, "Error writing [%d] glukit scores with keys [%s]: %v", len(elementKeys), elementKeys, error)
 		return nil, error
@@ -876,16 +2313,75 @@ func GetGlukitScores(context context.Context, email string, scanQuery ScoreScanQ
 	}
 	query = query.Order("-upperBound")
 
-	_, err = query.GetAll(context, &scores)
-
-	if err != datastore.Done {
-		util.Propagate(err)
+	if _, err = query.GetAll(context, &scores); err != nil {
+		return nil, operationError(err, "getting glukit scores of user [%s]", email)
 	}
 
 	log.Infof(context, "Found [%d] glukit scores.", len(scores))
 	return scores, nil
 }
 
+// GetGlukitScoreHistory returns the history of GlukitScores for the given email address whose period ends between the lower and upper
+// bounds (both inclusive). The scores are sorted in chronological order of their scoring period. There's no separate
+// kind for the history: the GlukitScore entities stored by StoreGlukitScoreBatch already are one entity per scoring
+// period under the user, keyed by the end of the period, so a copy of them would only have to be kept in sync.
+func GetGlukitScoreHistory(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (scores []model.GlukitScore, err error) {
+	key := GetUserKey(context, email)
+
+	log.Infof(context, "Scanning for glukit score history between [%s] and [%s]", lowerBound, upperBound)
+
+	query := datastore.NewQuery("GlukitScore").Ancestor(key).
+		Filter("upperBound >=", lowerBound).
+		Filter("upperBound <=", upperBound).
+		Order("upperBound")
+
+	scores = make([]model.GlukitScore, 0)
+	if _, err = query.GetAll(context, &scores); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Found [%d] glukit scores in history.", len(scores))
+	return scores, nil
+}
+
+func scoreCheckpointKey(context context.Context, email string) *datastore.Key {
+	return datastore.NewKey(context, "ScoreCheckpoint", "glukitScore", 0, GetUserKey(context, email))
+}
+
+// StoreScoreCheckpoint stores the progress of the task of batch calculation of GlukitScores of the given email address
+func StoreScoreCheckpoint(context context.Context, email string, checkpoint model.ScoreCheckpoint) (key *datastore.Key, err error) {
+	key = scoreCheckpointKey(context, email)
+
+	log.Debugf(context, "Emitting a Put for score checkpoint [%v] of user [%s]", checkpoint, email)
+	if key, err = put(context, key, &checkpoint); err != nil {
+		log.Errorf(context, "Error storing score checkpoint [%v] for user [%s]: %v", checkpoint, email, err)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// GetScoreCheckpoint returns the progress of the last task of batch calculation of GlukitScores of the given email
+// address or nil if there isn't any
+func GetScoreCheckpoint(context context.Context, email string) (checkpoint *model.ScoreCheckpoint, err error) {
+	checkpoint = new(model.ScoreCheckpoint)
+	if err := get(context, scoreCheckpointKey(context, email), checkpoint); err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+// ClearScoreCheckpoint removes the progress of the last task of batch calculation of GlukitScores of the given email
+// address so that a new batch doesn't resume from it
+func ClearScoreCheckpoint(context context.Context, email string) (err error) {
+	return withRetry(context, "ClearScoreCheckpoint", func() error {
+		return datastore.Delete(context, scoreCheckpointKey(context, email))
+	})
+}
+
 // StoreA1CBatch stores a batch of A1C calculations. The array could be of any size. A large batch of A1CEstimates
 // will be internally split into multiple PutMultis.
 func StoreA1CBatch(context context.Context, userEmail string, a1cs []model.A1CEstimate) error {
@@ -910,7 +2406,7 @@ func storeA1CChunk(context context.Context, parentKey *datastore.Key, a1cChunk [
 	}
 
 	log.Infof(context, "Emitting a PutMulti with [%d] keys for all [%d] a1cs of chunk", len(elementKeys), len(a1cChunk))
-	keys, error := datastore.PutMulti(context, elementKeys, a1cChunk)
+	keys, error := putMulti(context, elementKeys, a1cChunk)
 	if error != nil {
 		log.Criticalf(context, "Error writing [%d] a1c calculations with keys [%s]: %v", len(elementKeys), elementKeys, error)
 		return nil, error
@@ -937,12 +2433,319 @@ func GetA1CEstimates(context context.Context, email string, scanQuery ScoreScanQ
 	}
 	query = query.Order("-upperBound")
 
-	_, err = query.GetAll(context, &scores)
-
-	if err != datastore.Done {
-		util.Propagate(err)
+	if _, err = query.GetAll(context, &scores); err != nil {
+		return nil, operationError(err, "getting a1c estimates of user [%s]", email)
 	}
 
 	log.Infof(context, "Found [%d] a1c estimates.", len(scores))
 	return scores, nil
 }
+
+// StoreA1CEstimate appends an a1c estimate to the user's history of estimates. Estimates are never overwritten so that
+// it's always possible to look back at what the estimate was at a given time.
+func StoreA1CEstimate(context context.Context, userEmail string, a1c model.A1CEstimate) (key *datastore.Key, err error) {
+	key = datastore.NewIncompleteKey(context, "A1CEstimateHistory", GetUserKey(context, userEmail))
+
+	log.Infof(context, "Emitting a Put for a1c estimate [%v] of user [%s]", a1c, userEmail)
+	key, err = put(context, key, &a1c)
+	if err != nil {
+		log.Criticalf(context, "Error storing a1c estimate [%v] for user [%s]: %v", a1c, userEmail, err)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// GetA1CEstimateHistory returns all a1c estimates calculated since the given time for the given email address, in
+// chronological order of calculation
+func GetA1CEstimateHistory(context context.Context, email string, since time.Time) (a1cs []model.A1CEstimate, err error) {
+	key := GetUserKey(context, email)
+
+	log.Infof(context, "Scanning for a1c estimate history since [%s]", since)
+
+	query := datastore.NewQuery("A1CEstimateHistory").Ancestor(key).
+		Filter("calculatedOn >=", since).
+		Order("calculatedOn")
+
+	a1cs = make([]model.A1CEstimate, 0)
+	if _, err = query.GetAll(context, &a1cs); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Found [%d] a1c estimates in history.", len(a1cs))
+	return a1cs, nil
+}
+
+// DeleteGlukitScores deletes all GlukitScores of the given email address whose period ends at or after since. It returns the
+// number of scores deleted.
+func DeleteGlukitScores(context context.Context, email string, since time.Time) (count int, err error) {
+	return deleteScores(context, "GlukitScore", email, since)
+}
+
+// DeleteA1CEstimates deletes all A1CEstimates of the given email address whose period ends at or after since. The history of
+// estimates is left untouched. It returns the number of estimates deleted.
+func DeleteA1CEstimates(context context.Context, email string, since time.Time) (count int, err error) {
+	return deleteScores(context, "A1CEstimate", email, since)
+}
+
+func deleteScores(context context.Context, kind string, email string, since time.Time) (count int, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery(kind).Ancestor(key).Filter("upperBound >=", since).KeysOnly()
+	keys, err := query.GetAll(context, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Infof(context, "Emitting a DeleteMulti with [%d] keys of kind [%s] since [%s]", len(keys), kind, since)
+	for chunkStartIndex := 0; chunkStartIndex < len(keys); chunkStartIndex = chunkStartIndex + GLUKIT_SCORE_PUT_MULTI_SIZE {
+		chunkEndIndex := int(math.Min(float64(chunkStartIndex+GLUKIT_SCORE_PUT_MULTI_SIZE), float64(len(keys))))
+		if err := deleteMulti(context, keys[chunkStartIndex:chunkEndIndex]); err != nil {
+			return chunkStartIndex, err
+		}
+	}
+
+	return len(keys), nil
+}
+
+// GetFirstReadTime returns the time of the earliest glucose read of the given email address. If the user doesn't have
+// any imported data yet, GetFirstReadTime returns ErrNoImportedDataFound
+func GetFirstReadTime(context context.Context, email string) (firstReadTime time.Time, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("DayOfReads").Ancestor(key).Order("startTime").Limit(1)
+	daysOfReads := make([]apimodel.DayOfGlucoseReads, 0)
+	if _, err = query.GetAll(context, &daysOfReads); err != nil {
+		return util.GLUKIT_EPOCH_TIME, err
+	}
+
+	if len(daysOfReads) == 0 || len(daysOfReads[0].Reads) == 0 {
+		return util.GLUKIT_EPOCH_TIME, ErrNoImportedDataFound
+	}
+
+	return daysOfReads[0].Reads[0].GetTime(), nil
+}
+
+func recalculationKey(context context.Context, email string) *datastore.Key {
+	return datastore.NewKey(context, "Recalculation", "current", 0, GetUserKey(context, email))
+}
+
+// AcquireRecalculationLock stores the recalculation as the one in progress for the given email address. If another
+// recalculation that hasn't expired yet is already in progress, AcquireRecalculationLock returns ErrRecalculationInProgress.
+func AcquireRecalculationLock(context context.Context, email string, recalculation model.Recalculation) (err error) {
+	key := recalculationKey(context, email)
+
+	log.Infof(context, "Acquiring recalculation lock [%v] for user [%s]", recalculation, email)
+	return runInTransaction(context, "AcquireRecalculationLock", recalculationLocker(key, recalculation))
+}
+
+// recalculationLocker returns the transaction function that stores the recalculation unless another one that hasn't
+// expired yet is in progress
+func recalculationLocker(key *datastore.Key, recalculation model.Recalculation) func(context context.Context) error {
+	return func(context context.Context) error {
+		existing := new(model.Recalculation)
+		if err := get(context, key, existing); err == nil {
+			if !existing.IsExpired(recalculation.StartedOn) {
+				return ErrRecalculationInProgress
+			}
+			log.Warningf(context, "Taking over expired recalculation lock [%v] with key [%s]", existing, key)
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		_, err := put(context, key, &recalculation)
+		return err
+	}
+}
+
+// GetRecalculation returns the recalculation in progress for the given email address or nil if there isn't any
+func GetRecalculation(context context.Context, email string) (recalculation *model.Recalculation, err error) {
+	recalculation = new(model.Recalculation)
+	if err := get(context, recalculationKey(context, email), recalculation); err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return recalculation, nil
+}
+
+// RenewRecalculationLock pushes back the expiry of the recalculation in progress for the given email address, if any
+func RenewRecalculationLock(context context.Context, email string, expiresOn time.Time) (err error) {
+	key := recalculationKey(context, email)
+
+	return runInTransaction(context, "RenewRecalculationLock", recalculationRenewer(key, expiresOn))
+}
+
+// recalculationRenewer returns the transaction function that pushes back the expiry of the recalculation, if any
+func recalculationRenewer(key *datastore.Key, expiresOn time.Time) func(context context.Context) error {
+	return func(context context.Context) error {
+		recalculation := new(model.Recalculation)
+		if err := get(context, key, recalculation); err == datastore.ErrNoSuchEntity {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		recalculation.ExpiresOn = expiresOn
+		_, err := put(context, key, recalculation)
+		return err
+	}
+}
+
+// ReleaseRecalculationLock removes the recalculation in progress for the given email address
+func ReleaseRecalculationLock(context context.Context, email string) (err error) {
+	log.Infof(context, "Releasing recalculation lock for user [%s]", email)
+	return withRetry(context, "ReleaseRecalculationLock", func() error {
+		return datastore.Delete(context, recalculationKey(context, email))
+	})
+}
+
+func refreshLeaseKey(context context.Context, email string) *datastore.Key {
+	return datastore.NewKey(context, "RefreshLease", "current", 0, GetUserKey(context, email))
+}
+
+// AcquireRefreshLease stores the lease as the one of the refresh in progress for the given email address. If another
+// refresh holds a lease that hasn't expired yet, AcquireRefreshLease returns ErrRefreshInProgress.
+func AcquireRefreshLease(context context.Context, email string, lease model.RefreshLease) (err error) {
+	key := refreshLeaseKey(context, email)
+
+	log.Debugf(context, "Acquiring refresh lease [%v] for user [%s]", lease, email)
+	return runInTransaction(context, "AcquireRefreshLease", refreshLeaseAcquirer(key, lease))
+}
+
+// refreshLeaseAcquirer returns the transaction function that stores the lease unless another one that hasn't expired
+// yet is held
+func refreshLeaseAcquirer(key *datastore.Key, lease model.RefreshLease) func(context context.Context) error {
+	return func(context context.Context) error {
+		existing := new(model.RefreshLease)
+		if err := get(context, key, existing); err == nil {
+			if !existing.IsExpired(lease.AcquiredOn) {
+				return ErrRefreshInProgress
+			}
+			log.Warningf(context, "Taking over expired refresh lease [%v] with key [%s]", existing, key)
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		_, err := put(context, key, &lease)
+		return err
+	}
+}
+
+// ReleaseRefreshLease removes the refresh lease of the given email address if it's still held by holder. A lease
+// that expired and was taken over by another refresh is left alone.
+func ReleaseRefreshLease(context context.Context, email string, holder string) (err error) {
+	key := refreshLeaseKey(context, email)
+
+	log.Debugf(context, "Releasing refresh lease of [%s] for user [%s]", holder, email)
+	return runInTransaction(context, "ReleaseRefreshLease", refreshLeaseReleaser(key, holder))
+}
+
+// refreshLeaseReleaser returns the transaction function that deletes the lease if it's held by holder
+func refreshLeaseReleaser(key *datastore.Key, holder string) func(context context.Context) error {
+	return func(context context.Context) error {
+		lease := new(model.RefreshLease)
+		if err := get(context, key, lease); err == datastore.ErrNoSuchEntity {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if lease.Holder != holder {
+			log.Warningf(context, "Not releasing refresh lease [%v] now held by another refresh than [%s]", lease, holder)
+			return nil
+		}
+
+		return datastore.Delete(context, key)
+	}
+}
+
+func importSlotKey(context context.Context, email string, slot int) *datastore.Key {
+	return datastore.NewKey(context, "ImportSlot", "", int64(slot+1), GetUserKey(context, email))
+}
+
+// AcquireImportSlot stores the lease in the first of the given number of import slots of the email address that isn't
+// held by another file import, limiting the number of imports of a user running at the same time. The acquired slot is
+// returned or ErrNoImportSlot if all of them are held by leases that haven't expired yet.
+func AcquireImportSlot(context context.Context, email string, slots int, lease model.RefreshLease) (slot int, err error) {
+	for slot = 0; slot < slots; slot++ {
+		err = runInTransaction(context, "AcquireImportSlot", refreshLeaseAcquirer(importSlotKey(context, email, slot), lease))
+		if err == nil {
+			log.Debugf(context, "Acquired import slot [%d] with lease [%v] for user [%s]", slot, lease, email)
+			return slot, nil
+		} else if err != ErrRefreshInProgress {
+			return -1, err
+		}
+	}
+
+	return -1, ErrNoImportSlot
+}
+
+// ReleaseImportSlot releases the import slot of the email address if it's still held by holder
+func ReleaseImportSlot(context context.Context, email string, slot int, holder string) (err error) {
+	log.Debugf(context, "Releasing import slot [%d] of [%s] for user [%s]", slot, holder, email)
+	return runInTransaction(context, "ReleaseImportSlot", refreshLeaseReleaser(importSlotKey(context, email, slot), holder))
+}
+
+func failedTaskKey(context context.Context, id int64) *datastore.Key {
+	return datastore.NewKey(context, "FailedTask", "", id, nil)
+}
+
+// StoreFailedTask stores the failed task and returns its key. Failed tasks are root entities so that they can be
+// listed regardless of their user.
+func StoreFailedTask(context context.Context, failedTask model.FailedTask) (key *datastore.Key, err error) {
+	return put(context, datastore.NewIncompleteKey(context, "FailedTask", nil), &failedTask)
+}
+
+// GetFailedTasks returns up to limit of the most recent failed tasks, of the given user if userEmail isn't empty, along
+// with their ids
+func GetFailedTasks(context context.Context, userEmail string, limit int) (ids []int64, failedTasks []model.FailedTask, err error) {
+	query := datastore.NewQuery("FailedTask")
+	if len(userEmail) > 0 {
+		query = query.Filter("userEmail =", userEmail)
+	}
+	query = query.Order("-failedOn").Limit(limit)
+
+	failedTasks = make([]model.FailedTask, 0)
+	keys, err := query.GetAll(context, &failedTasks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids = make([]int64, len(keys))
+	for i := range keys {
+		ids[i] = keys[i].IntID()
+	}
+
+	return ids, failedTasks, nil
+}
+
+// GetFailedTask returns the failed task with the given id
+func GetFailedTask(context context.Context, id int64) (failedTask *model.FailedTask, err error) {
+	failedTask = new(model.FailedTask)
+	if err := get(context, failedTaskKey(context, id), failedTask); err != nil {
+		return nil, err
+	}
+
+	return failedTask, nil
+}
+
+// MarkFailedTaskRequeued records that the failed task with the given id was requeued at requeuedOn
+func MarkFailedTaskRequeued(context context.Context, id int64, requeuedOn time.Time) (err error) {
+	return runInTransaction(context, "MarkFailedTaskRequeued", failedTaskRequeueMarker(failedTaskKey(context, id), requeuedOn))
+}
+
+// failedTaskRequeueMarker returns the transaction function that sets the time the failed task was requeued at
+func failedTaskRequeueMarker(key *datastore.Key, requeuedOn time.Time) func(context context.Context) error {
+	return func(context context.Context) error {
+		failedTask := new(model.FailedTask)
+		if err := get(context, key, failedTask); err != nil {
+			return err
+		}
+
+		failedTask.RequeuedOn = requeuedOn
+		_, err := put(context, key, failedTask)
+		return err
+	}
+}
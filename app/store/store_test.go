@@ -0,0 +1,338 @@
+package store_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/model"
+	. "github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"github.com/alexandre-normand/glukit/lib/goauth2/oauth"
+	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/memcache"
+	"testing"
+	"time"
+)
+
+func TestCachedUserProfileHasNoPlaintextTokens(t *testing.T) {
+	model.TokenKeyRing = util.KeyRing{"1", map[string]string{"1": "secret"}}
+	defer func() {
+		model.TokenKeyRing = util.KeyRing{}
+	}()
+
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	user := model.GlukitUser{Email: "cached@glukit.com", Token: oauth.Token{AccessToken: "plaintext-access-token",
+		RefreshToken: "plaintext-refresh-token"}, RefreshToken: "plaintext-refresh-token",
+		DropboxToken: oauth.Token{AccessToken: "plaintext-dropbox-token"}}
+	key, err := StoreUserProfile(c, time.Now(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := memcache.Get(c, "GlukitUser:"+key.Encode())
+	if err != nil {
+		t.Fatalf("Expected profile of [%s] to be cached but got [%v]", user.Email, err)
+	}
+
+	if bytes.Contains(item.Value, []byte("plaintext")) {
+		t.Errorf("Expected cached profile of [%s] not to have any plaintext token", user.Email)
+	}
+
+	cached, err := GetUserProfileCached(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cached.Token.AccessToken != user.Token.AccessToken || cached.RefreshToken != user.RefreshToken ||
+		cached.DropboxToken.AccessToken != user.DropboxToken.AccessToken {
+		t.Errorf("Expected cached profile to have the tokens of [%s] decrypted but got [%v]", user.Email, cached.Token)
+	}
+}
+
+func TestCachedUserProfileIsNeverStaleAfterTokenRefresh(t *testing.T) {
+	model.TokenKeyRing = util.KeyRing{"1", map[string]string{"1": "secret"}}
+	defer func() {
+		model.TokenKeyRing = util.KeyRing{}
+	}()
+
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	user := model.GlukitUser{Email: "refreshed@glukit.com", Token: oauth.Token{AccessToken: "expired-access-token"}}
+	key, err := StoreUserProfile(c, time.Now(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetUserProfileCached(c, key); err != nil {
+		t.Fatal(err)
+	}
+
+	user.Token = oauth.Token{AccessToken: "refreshed-access-token"}
+	if _, err := StoreUserProfile(c, time.Now(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := GetUserProfileCached(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cached.Token.AccessToken != "refreshed-access-token" {
+		t.Errorf("Expected cached profile of [%s] to have the refreshed token but got [%s]", user.Email, cached.Token.AccessToken)
+	}
+}
+
+func TestGlukitScoreHistoryIsInChronologicalOrderWithinBounds(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	email := "scores@glukit.com"
+	start := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	scores := make([]model.GlukitScore, 0)
+	for i := 5; i >= 0; i-- {
+		upperBound := start.AddDate(0, 0, 7*i)
+		scores = append(scores, model.GlukitScore{Value: int64(100 + i), LowerBound: upperBound.AddDate(0, 0, -7),
+			UpperBound: upperBound, CalculatedOn: upperBound})
+	}
+
+	if err := StoreGlukitScoreBatch(c, email, scores); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := GetGlukitScoreHistory(c, email, start.AddDate(0, 0, 7), start.AddDate(0, 0, 28))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 4 {
+		t.Fatalf("Expected [4] scores with a period ending within bounds but got [%v]", history)
+	}
+
+	for i, score := range history {
+		if expected := int64(101 + i); score.Value != expected {
+			t.Errorf("Expected score [%d] of history to be [%d] but got [%d]", i, expected, score.Value)
+		}
+	}
+}
+
+func TestA1CEstimatesAreAppendedToHistory(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	email := "a1c@glukit.com"
+	upperBound := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for i, value := range []float64{6.1, 6.4} {
+		a1c := model.A1CEstimate{Value: value, LowerBound: upperBound.AddDate(0, -3, 0), UpperBound: upperBound,
+			CalculatedOn: upperBound.Add(time.Duration(i) * time.Hour), Formula: "nathan", ReadCount: 1000 + i}
+		if _, err := StoreA1CEstimate(c, email, a1c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := DeleteA1CEstimates(c, email, upperBound.AddDate(-1, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := GetA1CEstimateHistory(c, email, upperBound.AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 2 {
+		t.Fatalf("Expected both estimates of the same period to be kept in history but got [%v]", history)
+	}
+
+	if history[0].Value != 6.1 || history[1].Value != 6.4 || history[1].ReadCount != 1001 || history[1].Formula != "nathan" {
+		t.Errorf("Expected estimates with their inputs in order of calculation but got [%v]", history)
+	}
+}
+
+func TestSingleRecalculationRunsAtATime(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	email := "recalculation@glukit.com"
+	now := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	first := model.Recalculation{From: now.AddDate(0, -6, 0), To: now, StartedOn: now, ExpiresOn: now.Add(time.Duration(10) * time.Minute)}
+	if err := AcquireRecalculationLock(c, email, first); err != nil {
+		t.Fatal(err)
+	}
+
+	second := first
+	second.StartedOn = now.Add(time.Minute)
+	if err := AcquireRecalculationLock(c, email, second); err != ErrRecalculationInProgress {
+		t.Errorf("Expected a recalculation to be rejected while another one is in progress but got [%v]", err)
+	}
+
+	abandoned := first
+	abandoned.StartedOn, abandoned.ExpiresOn = first.ExpiresOn, first.ExpiresOn.Add(time.Duration(10)*time.Minute)
+	if err := AcquireRecalculationLock(c, email, abandoned); err != nil {
+		t.Errorf("Expected an expired recalculation to be taken over but got [%v]", err)
+	}
+
+	if err := ReleaseRecalculationLock(c, email); err != nil {
+		t.Fatal(err)
+	}
+
+	if recalculation, err := GetRecalculation(c, email); err != nil || recalculation != nil {
+		t.Errorf("Expected no recalculation in progress once released but got [%v] and [%v]", recalculation, err)
+	}
+}
+
+func TestScoreCheckpointIsClearedForTheNextBatch(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	email := "checkpoint@glukit.com"
+	lowerBound := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	checkpoint := model.ScoreCheckpoint{TaskLowerBound: lowerBound, ScoredUpTo: lowerBound.AddDate(0, 0, 14), UpdatedOn: lowerBound}
+	if _, err := StoreScoreCheckpoint(c, email, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := GetScoreCheckpoint(c, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored == nil || !stored.ResumePoint(lowerBound).Equal(checkpoint.ScoredUpTo) {
+		t.Fatalf("Expected checkpoint [%v] but got [%v]", checkpoint, stored)
+	}
+
+	if err := ClearScoreCheckpoint(c, email); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored, err := GetScoreCheckpoint(c, email); err != nil || stored != nil {
+		t.Errorf("Expected no checkpoint once cleared but got [%v] and [%v]", stored, err)
+	}
+}
+
+func TestRefreshLeaseIsOnlyReleasedByItsHolder(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	email := "refresh@glukit.com"
+	now := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	first := model.RefreshLease{Holder: "first", AcquiredOn: now, ExpiresOn: now.Add(time.Duration(10) * time.Minute)}
+	if err := AcquireRefreshLease(c, email, first); err != nil {
+		t.Fatal(err)
+	}
+
+	second := model.RefreshLease{Holder: "second", AcquiredOn: now.Add(time.Minute), ExpiresOn: now.Add(time.Duration(11) * time.Minute)}
+	if err := AcquireRefreshLease(c, email, second); err != ErrRefreshInProgress {
+		t.Errorf("Expected a refresh to be rejected while another one holds the lease but got [%v]", err)
+	}
+
+	second.AcquiredOn, second.ExpiresOn = first.ExpiresOn, first.ExpiresOn.Add(time.Duration(10)*time.Minute)
+	if err := AcquireRefreshLease(c, email, second); err != nil {
+		t.Fatalf("Expected an expired lease to be taken over but got [%v]", err)
+	}
+
+	if err := ReleaseRefreshLease(c, email, first.Holder); err != nil {
+		t.Fatal(err)
+	}
+
+	third := model.RefreshLease{Holder: "third", AcquiredOn: second.AcquiredOn.Add(time.Minute), ExpiresOn: second.ExpiresOn}
+	if err := AcquireRefreshLease(c, email, third); err != ErrRefreshInProgress {
+		t.Errorf("Expected the lease taken over not to be released by its previous holder but got [%v]", err)
+	}
+
+	if err := ReleaseRefreshLease(c, email, second.Holder); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AcquireRefreshLease(c, email, third); err != nil {
+		t.Errorf("Expected the lease to be acquired once released by its holder but got [%v]", err)
+	}
+}
+
+func TestFailedTasksAreListedMostRecentFirstByUser(t *testing.T) {
+	// Failed tasks are root entities so listing them isn't consistent unless the datastore is
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	failedOn := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for i, email := range []string{"first@glukit.com", "other@glukit.com", "first@glukit.com"} {
+		failedTask := model.FailedTask{Function: "processFile", UserEmail: email, Summary: fmt.Sprintf("Import [%d]", i),
+			FailedOn: failedOn.Add(time.Duration(i) * time.Hour)}
+		if _, err := StoreFailedTask(c, failedTask); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, failedTasks, err := GetFailedTasks(c, "first@glukit.com", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failedTasks) != 2 || failedTasks[0].Summary != "Import [2]" || failedTasks[1].Summary != "Import [0]" {
+		t.Fatalf("Expected the [2] failed tasks of [first@glukit.com], most recent first, but got [%v]", failedTasks)
+	}
+
+	requeuedOn := failedOn.AddDate(0, 0, 1)
+	if err := MarkFailedTaskRequeued(c, ids[0], requeuedOn); err != nil {
+		t.Fatal(err)
+	}
+
+	if failedTask, err := GetFailedTask(c, ids[0]); err != nil || !failedTask.RequeuedOn.Equal(requeuedOn) {
+		t.Errorf("Expected failed task [%d] to be requeued on [%s] but got [%v] and [%v]", ids[0], requeuedOn, failedTask, err)
+	}
+}
+
+func TestFileImportsWaitForAFreeImportSlot(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	email := "imports@glukit.com"
+	now := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	expiresOn := now.Add(time.Duration(10) * time.Minute)
+	for i, holder := range []string{"first", "second"} {
+		lease := model.RefreshLease{Holder: holder, AcquiredOn: now, ExpiresOn: expiresOn}
+		if slot, err := AcquireImportSlot(c, email, 2, lease); err != nil || slot != i {
+			t.Fatalf("Expected import [%s] to get slot [%d] but got [%d] and [%v]", holder, i, slot, err)
+		}
+	}
+
+	third := model.RefreshLease{Holder: "third", AcquiredOn: now.Add(time.Minute), ExpiresOn: expiresOn.Add(time.Minute)}
+	if _, err := AcquireImportSlot(c, email, 2, third); err != ErrNoImportSlot {
+		t.Errorf("Expected no import slot while both are held but got [%v]", err)
+	}
+
+	if err := ReleaseImportSlot(c, email, 1, "second"); err != nil {
+		t.Fatal(err)
+	}
+
+	if slot, err := AcquireImportSlot(c, email, 2, third); err != nil || slot != 1 {
+		t.Errorf("Expected import to get the released slot [1] but got [%d] and [%v]", slot, err)
+	}
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/glukitio"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+type DataStoreBasalRateBatchWriter struct {
+	c context.Context
+	k *datastore.Key
+}
+
+// NewDataStoreBasalRateBatchWriter creates a new BasalRateBatchWriter that persists to the datastore
+func NewDataStoreBasalRateBatchWriter(context context.Context, userProfileKey *datastore.Key) *DataStoreBasalRateBatchWriter {
+	w := new(DataStoreBasalRateBatchWriter)
+	w.c = context
+	w.k = userProfileKey
+	return w
+}
+
+func (w *DataStoreBasalRateBatchWriter) WriteBasalRateBatches(p []apimodel.DayOfBasalRates) (glukitio.BasalRateBatchWriter, error) {
+	if _, err := StoreDaysOfBasalRates(w.c, w.k, p); err != nil {
+		return w, err
+	} else {
+		return w, nil
+	}
+}
+
+func (w *DataStoreBasalRateBatchWriter) WriteBasalRateBatch(p []apimodel.BasalRate) (glukitio.BasalRateBatchWriter, error) {
+	dayOfBasalRates := make([]apimodel.DayOfBasalRates, 1)
+	dayOfBasalRates[0] = apimodel.NewDayOfBasalRates(p)
+	return w.WriteBasalRateBatches(dayOfBasalRates)
+}
+
+func (w *DataStoreBasalRateBatchWriter) Flush() (glukitio.BasalRateBatchWriter, error) {
+	return w, nil
+}
@@ -0,0 +1,56 @@
+package store_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"testing"
+	"time"
+)
+
+func TestSimpleWriteOfSingleBasalRateBatch(t *testing.T) {
+	basalRates := make([]apimodel.BasalRate, 25)
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	for i := 0; i < 25; i++ {
+		readTime := ct.Add(time.Duration(i) * time.Hour)
+		basalRates[i] = apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Los_Angeles"}, float32(i), 60, false}
+	}
+
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	key := GetUserKey(c, "test@glukit.com")
+
+	w := NewDataStoreBasalRateBatchWriter(c, key)
+	if _, err = w.WriteBasalRateBatch(basalRates); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimpleWriteOfBasalRateBatches(t *testing.T) {
+	b := make([]apimodel.DayOfBasalRates, 10)
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+
+	for i := 0; i < 10; i++ {
+		basalRates := make([]apimodel.BasalRate, 24)
+		for j := 0; j < 24; j++ {
+			readTime := ct.Add(time.Duration(i*24+j) * time.Hour)
+			basalRates[j] = apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Los_Angeles"}, float32(1.5), 60, false}
+		}
+		b[i] = apimodel.NewDayOfBasalRates(basalRates)
+	}
+
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	key := GetUserKey(c, "test@glukit.com")
+
+	w := NewDataStoreBasalRateBatchWriter(c, key)
+	if _, err = w.WriteBasalRateBatches(b); err != nil {
+		t.Fatal(err)
+	}
+}
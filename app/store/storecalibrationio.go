@@ -7,30 +7,42 @@ import (
 	"google.golang.org/appengine/datastore"
 )
 
+// DataStoreCalibrationBatchWriter stores batches of calibrations as days of calibrations. It reuses its keys slice
+// across batches and copies the calibrations of WriteCalibrationBatch so that callers can reuse their slice once it
+// returns.
 type DataStoreCalibrationBatchWriter struct {
-	c context.Context
-	k *datastore.Key
+	c    context.Context
+	k    *datastore.Key
+	d    string
+	keys []*datastore.Key
 }
 
 // NewDataStoreCalibrationBatchWriter creates a new CalibrationBatchWriter that persists to the datastore
 func NewDataStoreCalibrationBatchWriter(context context.Context, userProfileKey *datastore.Key) *DataStoreCalibrationBatchWriter {
+	return NewDataStoreDeviceCalibrationBatchWriter(context, userProfileKey, apimodel.DEFAULT_DEVICE_ID)
+}
+
+// NewDataStoreDeviceCalibrationBatchWriter creates a new CalibrationBatchWriter that persists calibrations of the given device to the datastore
+func NewDataStoreDeviceCalibrationBatchWriter(context context.Context, userProfileKey *datastore.Key, deviceId string) *DataStoreCalibrationBatchWriter {
 	w := new(DataStoreCalibrationBatchWriter)
 	w.c = context
 	w.k = userProfileKey
+	w.d = deviceId
 	return w
 }
 
 func (w *DataStoreCalibrationBatchWriter) WriteCalibrationBatches(p []apimodel.DayOfCalibrationReads) (glukitio.CalibrationBatchWriter, error) {
-	if _, err := StoreCalibrationReads(w.c, w.k, p); err != nil {
+	if keys, err := storeDaysOfCalibrations(w.c, w.k, w.d, p, w.keys); err != nil {
 		return w, err
 	} else {
+		w.keys = keys
 		return w, nil
 	}
 }
 
 func (w *DataStoreCalibrationBatchWriter) WriteCalibrationBatch(p []apimodel.CalibrationRead) (glukitio.CalibrationBatchWriter, error) {
-	dayOfCalibrationReads := make([]apimodel.DayOfCalibrationReads, 1)
-	dayOfCalibrationReads[0] = apimodel.NewDayOfCalibrationReads(p)
+	reads := append([]apimodel.CalibrationRead(nil), p...)
+	dayOfCalibrationReads := []apimodel.DayOfCalibrationReads{apimodel.NewDayOfCalibrationReadsForDevice(reads, w.d)}
 	return w.WriteCalibrationBatches(dayOfCalibrationReads)
 }
 
@@ -0,0 +1,38 @@
+package store_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/store"
+	"testing"
+	"time"
+)
+
+func TestFailingDatastoreCallsSurfaceAsErrors(t *testing.T) {
+	c, _ := setup(t)
+	// Calls to the datastore fail once the context is closed
+	c.Close()
+
+	defer func() {
+		if failure := recover(); failure != nil {
+			t.Fatalf("Expected store failures to be returned as errors but got a panic: %v", failure)
+		}
+	}()
+
+	upperBound := time.Now()
+	lowerBound := upperBound.AddDate(0, 0, -7)
+	limit := 10
+	failures := map[string]error{}
+	_, failures["reads"] = GetGlucoseReads(c, TEST_USER, lowerBound, upperBound)
+	_, failures["calibrations"] = GetCalibrations(c, TEST_USER, lowerBound, upperBound)
+	_, failures["injections"] = GetInjections(c, TEST_USER, lowerBound, upperBound)
+	_, failures["basal rates"] = GetBasalRates(c, TEST_USER, lowerBound, upperBound)
+	_, failures["meals"] = GetMeals(c, TEST_USER, lowerBound, upperBound)
+	_, failures["exercises"] = GetExercises(c, TEST_USER, lowerBound, upperBound)
+	_, failures["glukit scores"] = GetGlukitScores(c, TEST_USER, ScoreScanQuery{Limit: &limit})
+	_, failures["a1c estimates"] = GetA1CEstimates(c, TEST_USER, ScoreScanQuery{Limit: &limit})
+
+	for kind, err := range failures {
+		if _, ok := err.(OperationError); !ok {
+			t.Errorf("Expected getting %s to fail with an OperationError but got [%v]", kind, err)
+		}
+	}
+}
@@ -13,7 +13,7 @@ func TestSimpleWriteOfSingleExerciseBatch(t *testing.T) {
 	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i) * time.Hour)
-		exercises[i] = apimodel.Exercise{apimodel.Time{readTime.Unix(), "America/Los_Angeles"}, i, "Light", "details"}
+		exercises[i] = apimodel.Exercise{apimodel.Time{readTime.Unix(), "America/Los_Angeles"}, i, "Light", "details", "", "", false, false, ""}
 	}
 
 	c, err := aetest.NewContext(nil)
@@ -37,7 +37,7 @@ func TestSimpleWriteOfExerciseBatches(t *testing.T) {
 		exercises := make([]apimodel.Exercise, 24)
 		for j := 0; j < 24; j++ {
 			readTime := ct.Add(time.Duration(i*24+j) * time.Hour)
-			exercises[j] = apimodel.Exercise{apimodel.Time{readTime.Unix(), "America/Los_Angeles"}, j, "Light", "details"}
+			exercises[j] = apimodel.Exercise{apimodel.Time{readTime.Unix(), "America/Los_Angeles"}, j, "Light", "details", "", "", false, false, ""}
 		}
 		b[i] = apimodel.NewDayOfExercises(exercises)
 	}
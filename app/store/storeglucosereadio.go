@@ -7,30 +7,51 @@ import (
 	"google.golang.org/appengine/datastore"
 )
 
+// DataStoreGlucoseReadBatchWriter stores batches of reads as days of reads. It reuses its keys slice across batches and
+// copies the reads of WriteGlucoseReadBatch so that callers can reuse their slice once it returns.
 type DataStoreGlucoseReadBatchWriter struct {
-	c context.Context
-	k *datastore.Key
+	c    context.Context
+	k    *datastore.Key
+	d    string
+	r    apimodel.GlucoseRead
+	keys []*datastore.Key
 }
 
 // NewDataStoreGlucoseReadBatchWriter creates a new GlucoseReadBatchWriter that persists to the datastore
 func NewDataStoreGlucoseReadBatchWriter(context context.Context, userProfileKey *datastore.Key) *DataStoreGlucoseReadBatchWriter {
+	return NewDataStoreDeviceGlucoseReadBatchWriter(context, userProfileKey, apimodel.DEFAULT_DEVICE_ID)
+}
+
+// NewDataStoreDeviceGlucoseReadBatchWriter creates a new GlucoseReadBatchWriter that persists reads of the given device to the datastore
+func NewDataStoreDeviceGlucoseReadBatchWriter(context context.Context, userProfileKey *datastore.Key, deviceId string) *DataStoreGlucoseReadBatchWriter {
 	w := new(DataStoreGlucoseReadBatchWriter)
 	w.c = context
 	w.k = userProfileKey
+	w.d = deviceId
+	w.r = apimodel.UNDEFINED_GLUCOSE_READ
 	return w
 }
 
+// MostRecentRead returns the most recent read written by this writer or apimodel.UNDEFINED_GLUCOSE_READ if nothing was written yet
+func (w *DataStoreGlucoseReadBatchWriter) MostRecentRead() apimodel.GlucoseRead {
+	return w.r
+}
+
 func (w *DataStoreGlucoseReadBatchWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (glukitio.GlucoseReadBatchWriter, error) {
-	if _, err := StoreDaysOfReads(w.c, w.k, p); err != nil {
+	if keys, mostRecentRead, err := storeDaysOfReads(w.c, w.k, w.d, p, w.keys); err != nil {
 		return w, err
 	} else {
+		w.keys = keys
+		if mostRecentRead.Time.Timestamp > w.r.Time.Timestamp {
+			w.r = mostRecentRead
+		}
 		return w, nil
 	}
 }
 
 func (w *DataStoreGlucoseReadBatchWriter) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (glukitio.GlucoseReadBatchWriter, error) {
-	dayOfGlucoseReads := make([]apimodel.DayOfGlucoseReads, 1)
-	dayOfGlucoseReads[0] = apimodel.NewDayOfGlucoseReads(p)
+	reads := append([]apimodel.GlucoseRead(nil), p...)
+	dayOfGlucoseReads := []apimodel.DayOfGlucoseReads{apimodel.NewDayOfGlucoseReadsForDevice(reads, w.d)}
 	return w.WriteGlucoseReadBatches(dayOfGlucoseReads)
 }
 
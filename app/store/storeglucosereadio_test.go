@@ -5,7 +5,6 @@ import (
 	"github.com/alexandre-normand/glukit/app/model"
 	. "github.com/alexandre-normand/glukit/app/store"
 	"github.com/alexandre-normand/glukit/app/util"
-	"github.com/alexandre-normand/glukit/lib/goauth2/oauth"
 	"google.golang.org/appengine/aetest"
 	"google.golang.org/appengine/datastore"
 	"log"
@@ -21,10 +20,12 @@ func setup(t *testing.T) (c aetest.Context, key *datastore.Key) {
 		t.Fatal(err)
 	}
 
-	var oauthToken oauth.Token
-	user := model.GlukitUser{TEST_USER, "", "", time.Now(),
-		"", "", util.GLUKIT_EPOCH_TIME, apimodel.UNDEFINED_GLUCOSE_READ, oauthToken, oauthToken.RefreshToken,
-		model.UNDEFINED_SCORE, model.UNDEFINED_SCORE, false, "", time.Now()}
+	user := model.GlukitUser{Email: TEST_USER, DateOfBirth: time.Now(), LastUpdated: util.GLUKIT_EPOCH_TIME,
+		MostRecentRead: apimodel.UNDEFINED_GLUCOSE_READ, BestScore: model.UNDEFINED_SCORE,
+		MostRecentScore: model.UNDEFINED_SCORE, AccountCreated: time.Now(),
+		MostRecentA1C: model.UNDEFINED_A1C_ESTIMATE, TargetLow: apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD,
+		TargetHigh: apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD, GlucoseUnit: apimodel.MG_PER_DL,
+		TherapyMode: model.THERAPY_MODE_UNKNOWN}
 
 	key, err = StoreUserProfile(c, time.Unix(1000, 0), user)
 	if err != nil {
@@ -73,3 +74,28 @@ func TestSimpleWriteOfGlucoseReadBatches(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestMostRecentReadNeverMovesBack(t *testing.T) {
+	c, key := setup(t)
+	defer c.Close()
+
+	readTime := time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+	newer := apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, apimodel.MG_PER_DL, 120}
+	older := apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime.Add(-time.Hour)), "UTC"}, apimodel.MG_PER_DL, 90}
+
+	// The import of the older read completes last, as happens when imports of overlapping files run out of order
+	for _, candidate := range []apimodel.GlucoseRead{newer, older} {
+		if err := UpdateMostRecentRead(c, key, candidate); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	userProfile, err := GetUserProfile(c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userProfile.MostRecentRead.Time.Timestamp != newer.Time.Timestamp || userProfile.MostRecentRead.Value != newer.Value {
+		t.Errorf("Expected most recent read to stay at [%v] but got [%v]", newer, userProfile.MostRecentRead)
+	}
+}
@@ -13,7 +13,7 @@ func TestSimpleWriteOfSingleInjectionBatch(t *testing.T) {
 	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i) * time.Hour)
-		injections[i] = apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Los_Angeles"}, float32(i), "Levemir", "Basal"}
+		injections[i] = apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Los_Angeles"}, float32(i), "Levemir", "Basal", apimodel.INSULIN_CATEGORY_LONG_ACTING, "", false, false}
 	}
 
 	c, err := aetest.NewContext(nil)
@@ -37,7 +37,7 @@ func TestSimpleWriteOfInjectionBatches(t *testing.T) {
 		injections := make([]apimodel.Injection, 24)
 		for j := 0; j < 24; j++ {
 			readTime := ct.Add(time.Duration(i*24+j) * time.Hour)
-			injections[j] = apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Los_Angeles"}, float32(1.5), "Levemir", "Basal"}
+			injections[j] = apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Los_Angeles"}, float32(1.5), "Levemir", "Basal", apimodel.INSULIN_CATEGORY_LONG_ACTING, "", false, false}
 		}
 		b[i] = apimodel.NewDayOfInjections(injections)
 	}
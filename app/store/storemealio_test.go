@@ -13,7 +13,7 @@ func TestSimpleWriteOfSingleMealBatch(t *testing.T) {
 	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i) * time.Hour)
-		meals[i] = apimodel.Meal{apimodel.Time{readTime.Unix(), "America/Los_Angeles"}, float32(i), 0., 0., 0.}
+		meals[i] = apimodel.Meal{apimodel.Time{readTime.Unix(), "America/Los_Angeles"}, float32(i), 0., 0., 0., 0., "", "", false, false}
 	}
 
 	c, err := aetest.NewContext(nil)
@@ -37,7 +37,7 @@ func TestSimpleWriteOfMealBatches(t *testing.T) {
 		meals := make([]apimodel.Meal, 24)
 		for j := 0; j < 24; j++ {
 			readTime := ct.Add(time.Duration(i*24+j) * time.Hour)
-			meals[j] = apimodel.Meal{apimodel.Time{readTime.Unix(), "America/Los_Angeles"}, float32(i*24 + j), 0., 0., 0.}
+			meals[j] = apimodel.Meal{apimodel.Time{readTime.Unix(), "America/Los_Angeles"}, float32(i*24 + j), 0., 0., 0., 0., "", "", false, false}
 		}
 		b[i] = apimodel.NewDayOfMeals(meals)
 	}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"time"
+)
+
+// tidepoolAccountKey returns the key of the Tidepool account of the user, users having at most one
+func tidepoolAccountKey(context context.Context, userProfileKey *datastore.Key) *datastore.Key {
+	return datastore.NewKey(context, "TidepoolAccount", "tidepool", 0, userProfileKey)
+}
+
+// GetTidepoolAccount returns the Tidepool account of the user, datastore.ErrNoSuchEntity if the user never connected one
+func GetTidepoolAccount(context context.Context, userProfileKey *datastore.Key) (account *model.TidepoolAccount, err error) {
+	account = new(model.TidepoolAccount)
+	if err := get(context, tidepoolAccountKey(context, userProfileKey), account); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// StoreTidepoolCredentials stores the username and encrypted password of the Tidepool account of the user. The export
+// watermark is kept if the username is the same, a different account getting everything exported to it from scratch.
+func StoreTidepoolCredentials(context context.Context, userProfileKey *datastore.Key, username string, encryptedPassword []byte) (err error) {
+	return runInTransaction(context, "StoreTidepoolCredentials", tidepoolCredentialsUpdater(tidepoolAccountKey(context, userProfileKey),
+		username, encryptedPassword))
+}
+
+// tidepoolCredentialsUpdater returns the transaction function that replaces the credentials of the Tidepool account,
+// creating it if it doesn't exist
+func tidepoolCredentialsUpdater(key *datastore.Key, username string, encryptedPassword []byte) func(context context.Context) error {
+	return func(context context.Context) error {
+		account := new(model.TidepoolAccount)
+		if err := get(context, key, account); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		if account.Username != username {
+			*account = model.TidepoolAccount{}
+		}
+
+		account.Username, account.EncryptedPassword = username, encryptedPassword
+		_, err := put(context, key, account)
+		return err
+	}
+}
+
+// RecordTidepoolExport records the status of the last export to the Tidepool account of the user along with the time of
+// the most recent record exported so far
+func RecordTidepoolExport(context context.Context, userProfileKey *datastore.Key, lastExported time.Time, status string,
+	exportedOn time.Time) (err error) {
+	return runInTransaction(context, "RecordTidepoolExport", tidepoolExportRecorder(tidepoolAccountKey(context, userProfileKey),
+		lastExported, status, exportedOn))
+}
+
+// tidepoolExportRecorder returns the transaction function that sets the watermark and status of the last export
+func tidepoolExportRecorder(key *datastore.Key, lastExported time.Time, status string, exportedOn time.Time) func(context context.Context) error {
+	return func(context context.Context) error {
+		account := new(model.TidepoolAccount)
+		if err := get(context, key, account); err != nil {
+			return err
+		}
+
+		account.LastExported, account.LastExportStatus, account.LastExportOn = lastExported, status, exportedOn
+		_, err := put(context, key, account)
+		return err
+	}
+}
+
+// DeleteTidepoolAccount deletes the Tidepool account of the user, stopping exports to it
+func DeleteTidepoolAccount(context context.Context, userProfileKey *datastore.Key) (err error) {
+	return withRetry(context, "DeleteTidepoolAccount", func() error {
+		return datastore.Delete(context, tidepoolAccountKey(context, userProfileKey))
+	})
+}
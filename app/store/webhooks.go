@@ -0,0 +1,63 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"time"
+)
+
+// webhookKey returns the key of the webhook of the user, users having at most one
+func webhookKey(context context.Context, userProfileKey *datastore.Key) *datastore.Key {
+	return datastore.NewKey(context, "Webhook", "webhook", 0, userProfileKey)
+}
+
+// GetWebhook returns the webhook of the user, datastore.ErrNoSuchEntity if the user never configured one
+func GetWebhook(context context.Context, userProfileKey *datastore.Key) (webhook *model.Webhook, err error) {
+	webhook = new(model.Webhook)
+	if err := get(context, webhookKey(context, userProfileKey), webhook); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+// StoreWebhookSettings stores the url, secret, enabled flag and events of the webhook of the user, keeping the status
+// of its last delivery
+func StoreWebhookSettings(context context.Context, userProfileKey *datastore.Key, settings model.Webhook) (err error) {
+	return runInTransaction(context, "StoreWebhookSettings", webhookSettingsUpdater(webhookKey(context, userProfileKey), settings))
+}
+
+// webhookSettingsUpdater returns the transaction function that replaces the settings of the webhook, creating it if
+// it doesn't exist
+func webhookSettingsUpdater(key *datastore.Key, settings model.Webhook) func(context context.Context) error {
+	return func(context context.Context) error {
+		webhook := new(model.Webhook)
+		if err := get(context, key, webhook); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		webhook.Url, webhook.Secret, webhook.Enabled, webhook.Events = settings.Url, settings.Secret, settings.Enabled, settings.Events
+		_, err := put(context, key, webhook)
+		return err
+	}
+}
+
+// RecordWebhookDelivery records the status of the last delivery of the webhook of the user
+func RecordWebhookDelivery(context context.Context, userProfileKey *datastore.Key, status string, deliveredOn time.Time) (err error) {
+	return runInTransaction(context, "RecordWebhookDelivery", webhookDeliveryRecorder(webhookKey(context, userProfileKey), status, deliveredOn))
+}
+
+// webhookDeliveryRecorder returns the transaction function that sets the status of the last delivery of the webhook
+func webhookDeliveryRecorder(key *datastore.Key, status string, deliveredOn time.Time) func(context context.Context) error {
+	return func(context context.Context) error {
+		webhook := new(model.Webhook)
+		if err := get(context, key, webhook); err != nil {
+			return err
+		}
+
+		webhook.LastDeliveryStatus, webhook.LastDeliveryOn = status, deliveredOn
+		_, err := put(context, key, webhook)
+		return err
+	}
+}
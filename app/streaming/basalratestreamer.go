@@ -0,0 +1,115 @@
+package streaming
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/container"
+	"github.com/alexandre-normand/glukit/app/glukitio"
+	"time"
+)
+
+// BasalRateStreamer streams basalRates into batches of bufferDuration (i.e. a day of data). It's a thin adapter of the streamer core
+// so see streamer for how buffers are flushed and how errors are handled.
+type BasalRateStreamer struct {
+	core *streamer
+}
+
+// NewBasalRateStreamerDuration returns a new BasalRateStreamer that writes a batch every time the buffer duration elapses or when it
+// holds BUFFER_SIZE basalRates.
+func NewBasalRateStreamerDuration(wr glukitio.BasalRateBatchWriter, bufferDuration time.Duration) *BasalRateStreamer {
+	return NewBasalRateStreamerDurationCount(wr, bufferDuration, BUFFER_SIZE)
+}
+
+// NewBasalRateStreamerDurationCount returns a new BasalRateStreamer that also flushes its buffer as a batch when it holds maxCount
+// elements, even if the buffer duration hasn't elapsed. A day of data can then be split in multiple batches. A
+// maxCount of 0 means there's no limit on the number of elements.
+func NewBasalRateStreamerDurationCount(wr glukitio.BasalRateBatchWriter, bufferDuration time.Duration, maxCount int) *BasalRateStreamer {
+	return &BasalRateStreamer{newStreamer(basalRateBatchWriter{wr}, bufferDuration, maxCount)}
+}
+
+// WithReorderWindow returns a copy of the streamer that tolerates elements written up to window older than the most
+// recent one. They're held back and sorted before being buffered. Elements older than that are dropped and reported
+// as an OutOfOrderError.
+func (b *BasalRateStreamer) WithReorderWindow(window time.Duration) *BasalRateStreamer {
+	return &BasalRateStreamer{b.core.withReorderWindow(window)}
+}
+
+// WithBatchBoundary returns a copy of the streamer that starts batches at the given boundary. LocalMidnightBoundary
+// aligns batches of a day on the calendar days of the user.
+func (b *BasalRateStreamer) WithBatchBoundary(boundary BatchBoundary) *BasalRateStreamer {
+	return &BasalRateStreamer{b.core.withBatchBoundary(boundary)}
+}
+
+// WriteBasalRate writes a single BasalRate into the buffer.
+func (b *BasalRateStreamer) WriteBasalRate(c apimodel.BasalRate) (s *BasalRateStreamer, err error) {
+	return b.WriteBasalRates([]apimodel.BasalRate{c})
+}
+
+// WriteBasalRates writes the contents of p into the buffer. p must be sorted by time (oldest to most recent), within the
+// reorder window of the streamer. If writing a batch fails, the error is returned and every subsequent write fails
+// until Flush succeeds.
+func (b *BasalRateStreamer) WriteBasalRates(p []apimodel.BasalRate) (s *BasalRateStreamer, err error) {
+	elements := make([]timedElement, len(p))
+	for i := range p {
+		elements[i] = p[i]
+	}
+
+	core, err := b.core.write(elements)
+	return &BasalRateStreamer{core}, err
+}
+
+// Flush writes any buffered data to the underlying glukitio.Writer as a batch.
+func (b *BasalRateStreamer) Flush() (s *BasalRateStreamer, err error) {
+	core, err := b.core.flush()
+	return &BasalRateStreamer{core}, err
+}
+
+// Close flushes the buffer and the inner writer to effectively ensure nothing is left
+// unwritten. The streamer can't be written to once closed and closing it again does nothing.
+func (b *BasalRateStreamer) Close() (s *BasalRateStreamer, err error) {
+	core, err := b.core.close()
+	return &BasalRateStreamer{core}, err
+}
+
+// Buffered returns the number of elements written to the streamer that haven't been flushed yet
+func (b *BasalRateStreamer) Buffered() int {
+	return b.core.buffered()
+}
+
+// Stats returns a snapshot of the activity of the streamer
+func (b *BasalRateStreamer) Stats() Stats {
+	return b.core.stats()
+}
+
+// ListToArrayOfBasalRateReads returns the elements of the list, whose head is the most recent one,
+// in chronological order
+func ListToArrayOfBasalRateReads(head *container.ImmutableList) []apimodel.BasalRate {
+	r := make([]apimodel.BasalRate, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.BasalRate)
+	})
+
+	return r
+}
+
+// basalRateBatchWriter adapts a glukitio.BasalRateBatchWriter to the streamer core
+type basalRateBatchWriter struct {
+	wr glukitio.BasalRateBatchWriter
+}
+
+func (w basalRateBatchWriter) writeBatch(head *container.ImmutableList) (batchWriter, error) {
+	innerWriter, err := w.wr.WriteBasalRateBatch(ListToArrayOfBasalRateReads(head))
+	if err != nil {
+		return w, err
+	}
+
+	return basalRateBatchWriter{innerWriter}, nil
+}
+
+func (w basalRateBatchWriter) flush() (batchWriter, error) {
+	innerWriter, err := w.wr.Flush()
+	if err != nil {
+		return w, err
+	}
+
+	return basalRateBatchWriter{innerWriter}, nil
+}
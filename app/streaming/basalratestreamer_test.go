@@ -0,0 +1,280 @@
+package streaming_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/bufio"
+	"github.com/alexandre-normand/glukit/app/glukitio"
+	. "github.com/alexandre-normand/glukit/app/streaming"
+	"log"
+	"testing"
+	"time"
+)
+
+type basalRateWriterState struct {
+	total      int
+	batchCount int
+	writeCount int
+	batches    map[int64][]apimodel.BasalRate
+}
+
+type statsBasalRateReadWriter struct {
+	state *basalRateWriterState
+}
+
+func NewBasalRateWriterState() *basalRateWriterState {
+	s := new(basalRateWriterState)
+	s.batches = make(map[int64][]apimodel.BasalRate)
+
+	return s
+}
+
+func NewStatsBasalRateReadWriter(s *basalRateWriterState) *statsBasalRateReadWriter {
+	w := new(statsBasalRateReadWriter)
+	w.state = s
+
+	return w
+}
+
+func (w *statsBasalRateReadWriter) WriteBasalRateBatch(p []apimodel.BasalRate) (glukitio.BasalRateBatchWriter, error) {
+	log.Printf("WriteBasalRateReadBatch with [%d] elements: %v", len(p), p)
+	dayOfBasalRates := []apimodel.DayOfBasalRates{apimodel.NewDayOfBasalRates(p)}
+
+	return w.WriteBasalRateBatches(dayOfBasalRates)
+}
+
+func (w *statsBasalRateReadWriter) WriteBasalRateBatches(p []apimodel.DayOfBasalRates) (glukitio.BasalRateBatchWriter, error) {
+	log.Printf("WriteBasalRateBatches with [%d] batches: %v", len(p), p)
+	for i := range p {
+		dayOfData := p[i]
+		log.Printf("Persisting batch with start date of [%v]", dayOfData.BasalRates[0].GetTime())
+		w.state.total += len(dayOfData.BasalRates)
+		w.state.batches[dayOfData.BasalRates[0].GetTime().Unix()] = dayOfData.BasalRates
+	}
+
+	log.Printf("WriteBasalRateReadBatches with total of %d", w.state.total)
+	w.state.batchCount += len(p)
+	w.state.writeCount++
+
+	return w, nil
+}
+
+func (w *statsBasalRateReadWriter) Flush() (glukitio.BasalRateBatchWriter, error) {
+	return w, nil
+}
+
+func TestWriteOfDayBasalRateBatch(t *testing.T) {
+	state := NewBasalRateWriterState()
+	w := NewBasalRateStreamerDuration(NewStatsBasalRateReadWriter(state), apimodel.DAY_OF_DATA_DURATION)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+
+	for i := 0; i < 25; i++ {
+		readTime := ct.Add(time.Duration(i) * time.Hour)
+		w, _ = w.WriteBasalRate(apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), 60, false})
+	}
+
+	if state.total != 24 {
+		t.Errorf("TestWriteOfDayBasalRateBatch failed: got a total of %d but expected %d", state.total, 24)
+	}
+
+	if state.batchCount != 1 {
+		t.Errorf("TestWriteOfDayBasalRateBatch failed: got a batchCount of %d but expected %d", state.batchCount, 1)
+	}
+
+	if state.writeCount != 1 {
+		t.Errorf("TestWriteOfDayBasalRateBatch failed: got a writeCount of %d but expected %d", state.writeCount, 1)
+	}
+}
+
+func TestWriteOfDayBasalRateBatchesInSingleCall(t *testing.T) {
+	state := NewBasalRateWriterState()
+	w := NewBasalRateStreamerDuration(NewStatsBasalRateReadWriter(state), apimodel.DAY_OF_DATA_DURATION)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+
+	basalRates := make([]apimodel.BasalRate, 25)
+
+	for i := 0; i < 25; i++ {
+		readTime := ct.Add(time.Duration(i) * time.Hour)
+		basalRates[i] = apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), 60, false}
+	}
+
+	w, _ = w.WriteBasalRates(basalRates)
+	w.Flush()
+
+	if state.total != 25 {
+		t.Errorf("TestWriteOfDayBasalRateBatchesInSingleCall failed: got a total of %d but expected %d", state.total, 25)
+	}
+
+	if state.batchCount != 2 {
+		t.Errorf("TestWriteOfDayBasalRateBatchesInSingleCall failed: got a batchCount of %d but expected %d", state.batchCount, 2)
+	}
+
+	if state.writeCount != 2 {
+		t.Errorf("TestWriteOfDayBasalRateBatchesInSingleCall failed: got a writeCount of %d but expected %d", state.writeCount, 2)
+	}
+}
+
+func TestWriteOfHourlyBasalRateBatch(t *testing.T) {
+	state := NewBasalRateWriterState()
+	w := NewBasalRateStreamerDuration(NewStatsBasalRateReadWriter(state), time.Hour*1)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+
+	for i := 0; i < 13; i++ {
+		readTime := ct.Add(time.Duration(i*5) * time.Minute)
+		w, _ = w.WriteBasalRate(apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), 60, false})
+	}
+
+	if state.total != 12 {
+		t.Errorf("TestWriteOfHourlyBasalRateBatch failed: got a total of %d but expected %d", state.total, 12)
+	}
+
+	if state.batchCount != 1 {
+		t.Errorf("TestWriteOfHourlyBasalRateBatch failed: got a batchCount of %d but expected %d", state.batchCount, 1)
+	}
+
+	if state.writeCount != 1 {
+		t.Errorf("TestWriteOfHourlyBasalRateBatch failed: got a writeCount of %d but expected %d", state.writeCount, 1)
+	}
+
+	// Flushing should trigger the trailing read to be written
+	w, _ = w.Flush()
+
+	if state.total != 13 {
+		t.Errorf("TestWriteOfHourlyBasalRateBatch failed: got a total of %d but expected %d", state.total, 13)
+	}
+
+	if state.batchCount != 2 {
+		t.Errorf("TestWriteOfHourlyBasalRateBatch failed: got a batchCount of %d but expected %d", state.batchCount, 2)
+	}
+
+	if state.writeCount != 2 {
+		t.Errorf("TestWriteOfHourlyBasalRateBatch failed: got a writeCount of %d but expected %d", state.writeCount, 2)
+	}
+}
+
+func TestWriteOfMultipleBasalRateBatches(t *testing.T) {
+	state := NewBasalRateWriterState()
+	w := NewBasalRateStreamerDuration(NewStatsBasalRateReadWriter(state), time.Hour*1)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+
+	for i := 0; i < 25; i++ {
+		readTime := ct.Add(time.Duration(i*5) * time.Minute)
+		w, _ = w.WriteBasalRate(apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), 60, false})
+	}
+
+	if state.total != 24 {
+		t.Errorf("TestWriteOfMultipleBasalRateBatches failed: got a total of %d but expected %d", state.total, 24)
+	}
+
+	if state.batchCount != 2 {
+		t.Errorf("TestWriteOfMultipleBasalRateBatches failed: got a batchCount of %d but expected %d", state.batchCount, 2)
+	}
+
+	if state.writeCount != 2 {
+		t.Errorf("TestWriteOfMultipleBasalRateBatches failed: got a writeCount of %d but expected %d", state.writeCount, 2)
+	}
+
+	// Flushing should trigger the trailing read to be written
+	w, _ = w.Flush()
+
+	if state.total != 25 {
+		t.Errorf("TestWriteOfMultipleBasalRateBatches failed: got a total of %d but expected %d", state.total, 13)
+	}
+
+	if state.batchCount != 3 {
+		t.Errorf("TestWriteOfMultipleBasalRateBatches failed: got a batchCount of %d but expected %d", state.batchCount, 3)
+	}
+
+	if state.writeCount != 3 {
+		t.Errorf("TestWriteOfMultipleBasalRateBatches failed: got a writeCount of %d but expected %d", state.writeCount, 3)
+	}
+}
+
+func TestBasalRateStreamerWithBufferedIO(t *testing.T) {
+	state := NewBasalRateWriterState()
+	bufferedWriter := bufio.NewBasalRateWriterSize(NewStatsBasalRateReadWriter(state), 2)
+	w := NewBasalRateStreamerDuration(bufferedWriter, apimodel.DAY_OF_DATA_DURATION)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+
+	for b := 0; b < 3; b++ {
+		for i := 0; i < 48; i++ {
+			readTime := ct.Add(time.Duration(b*48+i) * 30 * time.Minute)
+			w, _ = w.WriteBasalRate(apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(b*48 + i), 60, false})
+		}
+	}
+
+	w, _ = w.Close()
+
+	firstBatchTime, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	if value, ok := state.batches[firstBatchTime.Unix()]; !ok {
+		t.Errorf("TestBasalRateStreamerWithBufferedIO test failed: count not find first batch starting with a read time of [%v] in batches: [%v]", firstBatchTime.Unix(), state.batches)
+	} else {
+		t.Logf("Value is [%v]", value)
+	}
+
+	secondBatchTime := firstBatchTime.Add(time.Duration(24) * time.Hour)
+	if value, ok := state.batches[secondBatchTime.Unix()]; !ok {
+		t.Errorf("TestBasalRateStreamerWithBufferedIO test failed: count not find second batch starting with a read time of [%v] in batches: [%v]", secondBatchTime.Unix(), state.batches)
+	} else {
+		t.Logf("Value is [%v]", value)
+	}
+
+	thirdBatchTime := firstBatchTime.Add(time.Duration(48) * time.Hour)
+	if value, ok := state.batches[thirdBatchTime.Unix()]; !ok {
+		t.Errorf("TestBasalRateStreamerWithBufferedIO test failed: count not find third batch starting with a read time of [%v] in batches: [%v]", thirdBatchTime.Unix(), state.batches)
+	} else {
+		t.Logf("Value is [%v]", value)
+	}
+}
+
+func TestBasalRateBatchBoundaries(t *testing.T) {
+	state := NewBasalRateWriterState()
+	bufferedWriter := bufio.NewBasalRateWriterSize(NewStatsBasalRateReadWriter(state), 2)
+	w := NewBasalRateStreamerDuration(bufferedWriter, apimodel.DAY_OF_DATA_DURATION)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 01:00")
+
+	for b := 0; b < 3; b++ {
+		for i := 0; i < 48; i++ {
+			readTime := ct.Add(time.Duration(b*48+i) * 30 * time.Minute)
+			w, _ = w.WriteBasalRate(apimodel.BasalRate{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(b*48 + i), 60, false})
+		}
+	}
+
+	w, _ = w.Close()
+
+	// Fist batch still starts with the first read which isn't a day boundary because we're just keeping track of an array of reads and
+	// therefore will have the first read potentially not line up with the data
+	firstBatchTime, _ := time.Parse("02/01/2006 15:04", "18/04/2014 01:00")
+	if value, ok := state.batches[firstBatchTime.Unix()]; !ok {
+		t.Errorf("TestBasalRateBatchBoundaries test failed: count not find first batch starting with a read time of [%v] in batches: [%v]", firstBatchTime.Unix(), state.batches)
+	} else {
+		t.Logf("Value is [%v]", value)
+	}
+
+	// Second batch starts at the truncated day boundary because we have a matching read that starts with it
+	secondBatchTime, _ := time.Parse("02/01/2006 15:04", "19/04/2014 00:00")
+	if value, ok := state.batches[secondBatchTime.Unix()]; !ok {
+		t.Errorf("TestBasalRateBatchBoundaries test failed: count not find second batch starting with a read time of [%v] in batches: [%v]", secondBatchTime.Unix(), state.batches)
+	} else {
+		t.Logf("Value is [%v]", value)
+	}
+
+	// Third batch starts at the truncated day boundary because we have a matching read that starts with it
+	thirdBatchTime, _ := time.Parse("02/01/2006 15:04", "20/04/2014 00:00")
+	if value, ok := state.batches[thirdBatchTime.Unix()]; !ok {
+		t.Errorf("TestBasalRateBatchBoundaries test failed: count not find third batch starting with a read time of [%v] in batches: [%v]", thirdBatchTime.Unix(), state.batches)
+	} else {
+		t.Logf("Value is [%v]", value)
+	}
+
+	// Fourth batch starts at the truncated day boundary because we have a matching read that starts with it
+	fourthBatchTime, _ := time.Parse("02/01/2006 15:04", "21/04/2014 00:00")
+	if _, ok := state.batches[fourthBatchTime.Unix()]; !ok {
+		t.Errorf("TestBasalRateBatchBoundaries test failed: could not find fourth batch starting with a read time of [%v]/ts[%d] in batches: [%v]", fourthBatchTime, fourthBatchTime.Unix(), state.batches)
+	}
+}
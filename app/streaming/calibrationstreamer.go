@@ -7,26 +7,36 @@ import (
 	"time"
 )
 
+// CalibrationReadStreamer streams calibrations into batches of bufferDuration (i.e. a day of data). It's a thin adapter of the streamer core
+// so see streamer for how buffers are flushed and how errors are handled.
 type CalibrationReadStreamer struct {
-	head      *container.ImmutableList
-	startTime *time.Time
-	wr        glukitio.CalibrationBatchWriter
-	d         time.Duration
+	core *streamer
 }
 
-// NewCalibrationReadStreamerDuration returns a new CalibrationReadStreamer whose buffer has the specified size.
+// NewCalibrationReadStreamerDuration returns a new CalibrationReadStreamer that writes a batch every time the buffer duration elapses or when it
+// holds BUFFER_SIZE calibrations.
 func NewCalibrationReadStreamerDuration(wr glukitio.CalibrationBatchWriter, bufferDuration time.Duration) *CalibrationReadStreamer {
-	return newCalibrationStreamerDuration(nil, nil, wr, bufferDuration)
+	return NewCalibrationReadStreamerDurationCount(wr, bufferDuration, BUFFER_SIZE)
 }
 
-func newCalibrationStreamerDuration(head *container.ImmutableList, startTime *time.Time, wr glukitio.CalibrationBatchWriter, bufferDuration time.Duration) *CalibrationReadStreamer {
-	w := new(CalibrationReadStreamer)
-	w.head = head
-	w.startTime = startTime
-	w.wr = wr
-	w.d = bufferDuration
+// NewCalibrationReadStreamerDurationCount returns a new CalibrationReadStreamer that also flushes its buffer as a batch when it holds maxCount
+// elements, even if the buffer duration hasn't elapsed. A day of data can then be split in multiple batches. A
+// maxCount of 0 means there's no limit on the number of elements.
+func NewCalibrationReadStreamerDurationCount(wr glukitio.CalibrationBatchWriter, bufferDuration time.Duration, maxCount int) *CalibrationReadStreamer {
+	return &CalibrationReadStreamer{newStreamer(calibrationBatchWriter{wr}, bufferDuration, maxCount)}
+}
+
+// WithReorderWindow returns a copy of the streamer that tolerates elements written up to window older than the most
+// recent one. They're held back and sorted before being buffered. Elements older than that are dropped and reported
+// as an OutOfOrderError.
+func (b *CalibrationReadStreamer) WithReorderWindow(window time.Duration) *CalibrationReadStreamer {
+	return &CalibrationReadStreamer{b.core.withReorderWindow(window)}
+}
 
-	return w
+// WithBatchBoundary returns a copy of the streamer that starts batches at the given boundary. LocalMidnightBoundary
+// aligns batches of a day on the calendar days of the user.
+func (b *CalibrationReadStreamer) WithBatchBoundary(boundary BatchBoundary) *CalibrationReadStreamer {
+	return &CalibrationReadStreamer{b.core.withBatchBoundary(boundary)}
 }
 
 // WriteCalibration writes a single CalibrationRead into the buffer.
@@ -34,77 +44,87 @@ func (b *CalibrationReadStreamer) WriteCalibration(c apimodel.CalibrationRead) (
 	return b.WriteCalibrations([]apimodel.CalibrationRead{c})
 }
 
-// WriteCalibrations writes the contents of p into the buffer.
-// It returns the number of bytes written.
-// If nn < len(p), it also returns an error explaining
-// why the write is short. p must be sorted by time (oldest to most recent).
+// WriteCalibrations writes the contents of p into the buffer. p must be sorted by time (oldest to most recent), within the
+// reorder window of the streamer. If writing a batch fails, the error is returned and every subsequent write fails
+// until Flush succeeds.
 func (b *CalibrationReadStreamer) WriteCalibrations(p []apimodel.CalibrationRead) (s *CalibrationReadStreamer, err error) {
-	s = newCalibrationStreamerDuration(b.head, b.startTime, b.wr, b.d)
-	if err != nil {
-		return s, err
-	}
-
+	elements := make([]timedElement, len(p))
 	for i := range p {
-		c := p[i]
-		t := c.GetTime()
-		truncatedTime := t.Truncate(s.d)
-
-		if s.head == nil {
-			s = newCalibrationStreamerDuration(container.NewImmutableList(nil, c), &truncatedTime, s.wr, s.d)
-		} else if t.Sub(*s.startTime) >= s.d {
-			s, err = s.Flush()
-			if err != nil {
-				return s, err
-			}
-			s = newCalibrationStreamerDuration(container.NewImmutableList(nil, c), &truncatedTime, s.wr, s.d)
-		} else {
-			s = newCalibrationStreamerDuration(container.NewImmutableList(s.head, c), s.startTime, s.wr, s.d)
-		}
+		elements[i] = p[i]
 	}
 
-	return s, err
+	core, err := b.core.write(elements)
+	return &CalibrationReadStreamer{core}, err
 }
 
 // Flush writes any buffered data to the underlying glukitio.Writer as a batch.
 func (b *CalibrationReadStreamer) Flush() (s *CalibrationReadStreamer, err error) {
-	r, size := b.head.ReverseList()
-	batch := ListToArrayOfCalibrationReads(r, size)
-
-	if len(batch) > 0 {
-		innerWriter, err := b.wr.WriteCalibrationBatch(batch)
-		if err != nil {
-			return nil, err
-		} else {
-			return newCalibrationStreamerDuration(nil, nil, innerWriter, b.d), nil
-		}
-	}
+	core, err := b.core.flush()
+	return &CalibrationReadStreamer{core}, err
+}
+
+// Close flushes the buffer and the inner writer to effectively ensure nothing is left
+// unwritten. The streamer can't be written to once closed and closing it again does nothing.
+func (b *CalibrationReadStreamer) Close() (s *CalibrationReadStreamer, err error) {
+	core, err := b.core.close()
+	return &CalibrationReadStreamer{core}, err
+}
 
-	return newCalibrationStreamerDuration(nil, nil, b.wr, b.d), nil
+// Buffered returns the number of elements written to the streamer that haven't been flushed yet
+func (b *CalibrationReadStreamer) Buffered() int {
+	return b.core.buffered()
 }
 
-func ListToArrayOfCalibrationReads(head *container.ImmutableList, size int) []apimodel.CalibrationRead {
-	r := make([]apimodel.CalibrationRead, size)
-	cursor := head
-	for i := 0; i < size; i++ {
-		r[i] = cursor.Value().(apimodel.CalibrationRead)
-		cursor = cursor.Next()
+// Stats returns a snapshot of the activity of the streamer
+func (b *CalibrationReadStreamer) Stats() Stats {
+	return b.core.stats()
+}
+
+// ListToArrayOfCalibrationReads returns the elements of the list, whose head is the most recent one,
+// in chronological order
+func ListToArrayOfCalibrationReads(head *container.ImmutableList) []apimodel.CalibrationRead {
+	return appendCalibrationReads(nil, head)
+}
+
+// appendCalibrationReads fills r with the elements of the list in chronological order, reusing its backing array
+// if it's large enough
+func appendCalibrationReads(r []apimodel.CalibrationRead, head *container.ImmutableList) []apimodel.CalibrationRead {
+	if size := head.Len(); cap(r) < size {
+		r = make([]apimodel.CalibrationRead, size)
+	} else {
+		r = r[:size]
 	}
 
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.CalibrationRead)
+	})
+
 	return r
 }
 
-// Close flushes the buffer and the inner writer to effectively ensure nothing is left
-// unwritten
-func (b *CalibrationReadStreamer) Close() (s *CalibrationReadStreamer, err error) {
-	g, err := b.Flush()
+// calibrationBatchWriter adapts a glukitio.CalibrationBatchWriter to the streamer core
+type calibrationBatchWriter struct {
+	wr glukitio.CalibrationBatchWriter
+}
+
+func (w calibrationBatchWriter) writeBatch(head *container.ImmutableList) (batchWriter, error) {
+	buffer := glukitio.GetCalibrationBatch()
+	batch := appendCalibrationReads(*buffer, head)
+	innerWriter, err := w.wr.WriteCalibrationBatch(batch)
+	*buffer = batch
+	glukitio.PutCalibrationBatch(buffer)
 	if err != nil {
-		return g, err
+		return w, err
 	}
 
-	innerWriter, err := g.wr.Flush()
+	return calibrationBatchWriter{innerWriter}, nil
+}
+
+func (w calibrationBatchWriter) flush() (batchWriter, error) {
+	innerWriter, err := w.wr.Flush()
 	if err != nil {
-		return newCalibrationStreamerDuration(g.head, g.startTime, innerWriter, b.d), err
+		return w, err
 	}
 
-	return newCalibrationStreamerDuration(nil, nil, innerWriter, g.d), nil
+	return calibrationBatchWriter{innerWriter}, nil
 }
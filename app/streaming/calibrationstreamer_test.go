@@ -37,7 +37,7 @@ func NewStatsCalibrationReadWriter(s *calibrationWriterState) *statsCalibrationR
 
 func (w *statsCalibrationReadWriter) WriteCalibrationBatch(p []apimodel.CalibrationRead) (glukitio.CalibrationBatchWriter, error) {
 	log.Printf("WriteCalibrationReadBatch with [%d] elements: %v", len(p), p)
-	dayOfCalibrationReads := []apimodel.DayOfCalibrationReads{apimodel.NewDayOfCalibrationReads(p)}
+	dayOfCalibrationReads := []apimodel.DayOfCalibrationReads{apimodel.NewDayOfCalibrationReads(append([]apimodel.CalibrationRead(nil), p...))}
 
 	return w.WriteCalibrationBatches(dayOfCalibrationReads)
 }
@@ -280,3 +280,54 @@ func TestCalibrationBatchBoundaries(t *testing.T) {
 		t.Errorf("TestCalibrationBatchBoundaries test failed: could not find fourth batch starting with a read time of [%v]/ts[%d] in batches: [%v]", fourthBatchTime, fourthBatchTime.Unix(), state.batches)
 	}
 }
+
+type countingCalibrationWriter struct {
+	total   int
+	batches int
+}
+
+func (w *countingCalibrationWriter) WriteCalibrationBatch(p []apimodel.CalibrationRead) (glukitio.CalibrationBatchWriter, error) {
+	w.total += len(p)
+	w.batches++
+	return w, nil
+}
+
+func (w *countingCalibrationWriter) WriteCalibrationBatches(p []apimodel.DayOfCalibrationReads) (glukitio.CalibrationBatchWriter, error) {
+	for i := range p {
+		w.WriteCalibrationBatch(p[i].Reads)
+	}
+
+	return w, nil
+}
+
+func (w *countingCalibrationWriter) Flush() (glukitio.CalibrationBatchWriter, error) {
+	return w, nil
+}
+
+func TestWriteCalibrationsOverBufferSizeWithinDuration(t *testing.T) {
+	writer := new(countingCalibrationWriter)
+	w := NewCalibrationReadStreamerDuration(writer, apimodel.DAY_OF_DATA_DURATION)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	calibrations := make([]apimodel.CalibrationRead, 3*BUFFER_SIZE)
+	for i := range calibrations {
+		calibrations[i] = apimodel.CalibrationRead{apimodel.Time{apimodel.GetTimeMillis(ct), "America/Montreal"}, apimodel.MG_PER_DL, float32(i)}
+	}
+
+	w, err := w.WriteCalibrations(calibrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if writer.total != 3*BUFFER_SIZE {
+		t.Errorf("TestWriteCalibrationsOverBufferSizeWithinDuration failed: got a total of %d but expected %d", writer.total, 3*BUFFER_SIZE)
+	}
+
+	if writer.batches != 3 {
+		t.Errorf("TestWriteCalibrationsOverBufferSizeWithinDuration failed: got %d batches but expected %d", writer.batches, 3)
+	}
+}
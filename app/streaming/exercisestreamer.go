@@ -7,26 +7,36 @@ import (
 	"time"
 )
 
+// ExerciseStreamer streams exercises into batches of bufferDuration (i.e. a day of data). It's a thin adapter of the streamer core
+// so see streamer for how buffers are flushed and how errors are handled.
 type ExerciseStreamer struct {
-	head      *container.ImmutableList
-	startTime *time.Time
-	wr        glukitio.ExerciseBatchWriter
-	d         time.Duration
+	core *streamer
 }
 
-// NewExerciseStreamerDuration returns a new ExerciseStreamer whose buffer has the specified size.
+// NewExerciseStreamerDuration returns a new ExerciseStreamer that writes a batch every time the buffer duration elapses or when it
+// holds BUFFER_SIZE exercises.
 func NewExerciseStreamerDuration(wr glukitio.ExerciseBatchWriter, bufferDuration time.Duration) *ExerciseStreamer {
-	return newExerciseStreamerDuration(nil, nil, wr, bufferDuration)
+	return NewExerciseStreamerDurationCount(wr, bufferDuration, BUFFER_SIZE)
 }
 
-func newExerciseStreamerDuration(head *container.ImmutableList, startTime *time.Time, wr glukitio.ExerciseBatchWriter, bufferDuration time.Duration) *ExerciseStreamer {
-	w := new(ExerciseStreamer)
-	w.head = head
-	w.startTime = startTime
-	w.wr = wr
-	w.d = bufferDuration
+// NewExerciseStreamerDurationCount returns a new ExerciseStreamer that also flushes its buffer as a batch when it holds maxCount
+// elements, even if the buffer duration hasn't elapsed. A day of data can then be split in multiple batches. A
+// maxCount of 0 means there's no limit on the number of elements.
+func NewExerciseStreamerDurationCount(wr glukitio.ExerciseBatchWriter, bufferDuration time.Duration, maxCount int) *ExerciseStreamer {
+	return &ExerciseStreamer{newStreamer(exerciseBatchWriter{wr}, bufferDuration, maxCount)}
+}
+
+// WithReorderWindow returns a copy of the streamer that tolerates elements written up to window older than the most
+// recent one. They're held back and sorted before being buffered. Elements older than that are dropped and reported
+// as an OutOfOrderError.
+func (b *ExerciseStreamer) WithReorderWindow(window time.Duration) *ExerciseStreamer {
+	return &ExerciseStreamer{b.core.withReorderWindow(window)}
+}
 
-	return w
+// WithBatchBoundary returns a copy of the streamer that starts batches at the given boundary. LocalMidnightBoundary
+// aligns batches of a day on the calendar days of the user.
+func (b *ExerciseStreamer) WithBatchBoundary(boundary BatchBoundary) *ExerciseStreamer {
+	return &ExerciseStreamer{b.core.withBatchBoundary(boundary)}
 }
 
 // WriteExercise writes a single Exercise into the buffer.
@@ -34,77 +44,72 @@ func (b *ExerciseStreamer) WriteExercise(c apimodel.Exercise) (s *ExerciseStream
 	return b.WriteExercises([]apimodel.Exercise{c})
 }
 
-// WriteExercises writes the contents of p into the buffer.
-// It returns the number of bytes written.
-// If nn < len(p), it also returns an error explaining
-// why the write is short. p must be sorted by time (oldest to most recent).
+// WriteExercises writes the contents of p into the buffer. p must be sorted by time (oldest to most recent), within the
+// reorder window of the streamer. If writing a batch fails, the error is returned and every subsequent write fails
+// until Flush succeeds.
 func (b *ExerciseStreamer) WriteExercises(p []apimodel.Exercise) (s *ExerciseStreamer, err error) {
-	s = newExerciseStreamerDuration(b.head, b.startTime, b.wr, b.d)
-	if err != nil {
-		return s, err
-	}
-
+	elements := make([]timedElement, len(p))
 	for i := range p {
-		c := p[i]
-		t := c.GetTime()
-		truncatedTime := t.Truncate(s.d)
-
-		if s.head == nil {
-			s = newExerciseStreamerDuration(container.NewImmutableList(nil, c), &truncatedTime, s.wr, s.d)
-		} else if t.Sub(*s.startTime) >= s.d {
-			s, err = s.Flush()
-			if err != nil {
-				return s, err
-			}
-			s = newExerciseStreamerDuration(container.NewImmutableList(nil, c), &truncatedTime, s.wr, s.d)
-		} else {
-			s = newExerciseStreamerDuration(container.NewImmutableList(s.head, c), s.startTime, s.wr, s.d)
-		}
+		elements[i] = p[i]
 	}
 
-	return s, err
+	core, err := b.core.write(elements)
+	return &ExerciseStreamer{core}, err
 }
 
 // Flush writes any buffered data to the underlying glukitio.Writer as a batch.
 func (b *ExerciseStreamer) Flush() (s *ExerciseStreamer, err error) {
-	r, size := b.head.ReverseList()
-	batch := ListToArrayOfExerciseReads(r, size)
-
-	if len(batch) > 0 {
-		innerWriter, err := b.wr.WriteExerciseBatch(batch)
-		if err != nil {
-			return nil, err
-		} else {
-			return newExerciseStreamerDuration(nil, nil, innerWriter, b.d), nil
-		}
-	}
+	core, err := b.core.flush()
+	return &ExerciseStreamer{core}, err
+}
 
-	return newExerciseStreamerDuration(nil, nil, b.wr, b.d), nil
+// Close flushes the buffer and the inner writer to effectively ensure nothing is left
+// unwritten. The streamer can't be written to once closed and closing it again does nothing.
+func (b *ExerciseStreamer) Close() (s *ExerciseStreamer, err error) {
+	core, err := b.core.close()
+	return &ExerciseStreamer{core}, err
 }
 
-func ListToArrayOfExerciseReads(head *container.ImmutableList, size int) []apimodel.Exercise {
-	r := make([]apimodel.Exercise, size)
-	cursor := head
-	for i := 0; i < size; i++ {
-		r[i] = cursor.Value().(apimodel.Exercise)
-		cursor = cursor.Next()
-	}
+// Buffered returns the number of elements written to the streamer that haven't been flushed yet
+func (b *ExerciseStreamer) Buffered() int {
+	return b.core.buffered()
+}
+
+// Stats returns a snapshot of the activity of the streamer
+func (b *ExerciseStreamer) Stats() Stats {
+	return b.core.stats()
+}
+
+// ListToArrayOfExerciseReads returns the elements of the list, whose head is the most recent one,
+// in chronological order
+func ListToArrayOfExerciseReads(head *container.ImmutableList) []apimodel.Exercise {
+	r := make([]apimodel.Exercise, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.Exercise)
+	})
 
 	return r
 }
 
-// Close flushes the buffer and the inner writer to effectively ensure nothing is left
-// unwritten
-func (b *ExerciseStreamer) Close() (s *ExerciseStreamer, err error) {
-	g, err := b.Flush()
+// exerciseBatchWriter adapts a glukitio.ExerciseBatchWriter to the streamer core
+type exerciseBatchWriter struct {
+	wr glukitio.ExerciseBatchWriter
+}
+
+func (w exerciseBatchWriter) writeBatch(head *container.ImmutableList) (batchWriter, error) {
+	innerWriter, err := w.wr.WriteExerciseBatch(ListToArrayOfExerciseReads(head))
 	if err != nil {
-		return g, err
+		return w, err
 	}
 
-	innerWriter, err := g.wr.Flush()
+	return exerciseBatchWriter{innerWriter}, nil
+}
+
+func (w exerciseBatchWriter) flush() (batchWriter, error) {
+	innerWriter, err := w.wr.Flush()
 	if err != nil {
-		return newExerciseStreamerDuration(g.head, g.startTime, innerWriter, b.d), err
+		return w, err
 	}
 
-	return newExerciseStreamerDuration(nil, nil, innerWriter, g.d), nil
+	return exerciseBatchWriter{innerWriter}, nil
 }
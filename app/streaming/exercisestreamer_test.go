@@ -70,7 +70,7 @@ func TestWriteOfDayExerciseBatch(t *testing.T) {
 
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i) * time.Hour)
-		w, _ = w.WriteExercise(apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details"})
+		w, _ = w.WriteExercise(apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details", "", "", false, false, ""})
 	}
 
 	if state.total != 24 {
@@ -96,7 +96,7 @@ func TestWriteOfDayExerciseBatchesInSingleCall(t *testing.T) {
 
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i) * time.Hour)
-		exercises[i] = apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details"}
+		exercises[i] = apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details", "", "", false, false, ""}
 	}
 
 	w, _ = w.WriteExercises(exercises)
@@ -123,7 +123,7 @@ func TestWriteOfHourlyExerciseBatch(t *testing.T) {
 
 	for i := 0; i < 13; i++ {
 		readTime := ct.Add(time.Duration(i*5) * time.Minute)
-		w, _ = w.WriteExercise(apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details"})
+		w, _ = w.WriteExercise(apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details", "", "", false, false, ""})
 	}
 
 	if state.total != 12 {
@@ -162,7 +162,7 @@ func TestWriteOfMultipleExerciseBatches(t *testing.T) {
 
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i*5) * time.Minute)
-		w, _ = w.WriteExercise(apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details"})
+		w, _ = w.WriteExercise(apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, i, "Light", "details", "", "", false, false, ""})
 	}
 
 	if state.total != 24 {
@@ -203,7 +203,7 @@ func TestExerciseStreamerWithBufferedIO(t *testing.T) {
 	for b := 0; b < 3; b++ {
 		for i := 0; i < 48; i++ {
 			readTime := ct.Add(time.Duration(b*48+i) * 30 * time.Minute)
-			w, _ = w.WriteExercise(apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, b*48 + i, "Light", "details"})
+			w, _ = w.WriteExercise(apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, b*48 + i, "Light", "details", "", "", false, false, ""})
 		}
 	}
 
@@ -241,7 +241,7 @@ func TestExerciseBatchBoundaries(t *testing.T) {
 	for b := 0; b < 3; b++ {
 		for i := 0; i < 48; i++ {
 			readTime := ct.Add(time.Duration(b*48+i) * 30 * time.Minute)
-			w, _ = w.WriteExercise(apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, b*48 + i, "Light", "details"})
+			w, _ = w.WriteExercise(apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, b*48 + i, "Light", "details", "", "", false, false, ""})
 		}
 	}
 
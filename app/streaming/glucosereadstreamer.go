@@ -7,110 +7,132 @@ import (
 	"time"
 )
 
+// GlucoseReadStreamer streams glucose reads into batches of bufferDuration (i.e. a day of data). It's a thin adapter of the streamer core
+// so see streamer for how buffers are flushed and how errors are handled.
 type GlucoseReadStreamer struct {
-	head      *container.ImmutableList
-	startTime *time.Time
-	wr        glukitio.GlucoseReadBatchWriter
-	d         time.Duration
+	core *streamer
 }
 
-const (
-	BUFFER_SIZE = 86400
-)
+// NewGlucoseStreamerDuration returns a new GlucoseReadStreamer that writes a batch every time the buffer duration elapses or when it
+// holds BUFFER_SIZE glucose reads.
+func NewGlucoseStreamerDuration(wr glukitio.GlucoseReadBatchWriter, bufferDuration time.Duration) *GlucoseReadStreamer {
+	return NewGlucoseStreamerDurationCount(wr, bufferDuration, BUFFER_SIZE)
+}
+
+// NewGlucoseStreamerDurationCount returns a new GlucoseReadStreamer that also flushes its buffer as a batch when it holds maxCount
+// elements, even if the buffer duration hasn't elapsed. A day of data can then be split in multiple batches. A
+// maxCount of 0 means there's no limit on the number of elements.
+func NewGlucoseStreamerDurationCount(wr glukitio.GlucoseReadBatchWriter, bufferDuration time.Duration, maxCount int) *GlucoseReadStreamer {
+	return &GlucoseReadStreamer{newStreamer(glucoseReadBatchWriter{wr}, bufferDuration, maxCount)}
+}
+
+// WithReorderWindow returns a copy of the streamer that tolerates elements written up to window older than the most
+// recent one. They're held back and sorted before being buffered. Elements older than that are dropped and reported
+// as an OutOfOrderError.
+func (b *GlucoseReadStreamer) WithReorderWindow(window time.Duration) *GlucoseReadStreamer {
+	return &GlucoseReadStreamer{b.core.withReorderWindow(window)}
+}
+
+// WithBatchBoundary returns a copy of the streamer that starts batches at the given boundary. LocalMidnightBoundary
+// aligns batches of a day on the calendar days of the user.
+func (b *GlucoseReadStreamer) WithBatchBoundary(boundary BatchBoundary) *GlucoseReadStreamer {
+	return &GlucoseReadStreamer{b.core.withBatchBoundary(boundary)}
+}
+
+// WithGapDetection returns a copy of the streamer that calls onGap with a ReadGap every time two consecutive reads are
+// more than threshold apart. Batches are still written as usual and don't include the gaps.
+func (b *GlucoseReadStreamer) WithGapDetection(threshold time.Duration, onGap func(gap apimodel.ReadGap)) *GlucoseReadStreamer {
+	return &GlucoseReadStreamer{b.core.withGapDetection(threshold, func(previous, next timedElement) {
+		onGap(apimodel.NewReadGap(previous.(apimodel.GlucoseRead), next.(apimodel.GlucoseRead)))
+	})}
+}
 
 // WriteGlucoseRead writes a single GlucoseRead into the buffer.
-func (b *GlucoseReadStreamer) WriteGlucoseRead(c apimodel.GlucoseRead) (g *GlucoseReadStreamer, err error) {
+func (b *GlucoseReadStreamer) WriteGlucoseRead(c apimodel.GlucoseRead) (s *GlucoseReadStreamer, err error) {
 	return b.WriteGlucoseReads([]apimodel.GlucoseRead{c})
 }
 
-// WriteGlucoseReads writes the contents of p into the buffer.
-// It returns the number of bytes written.
-// If nn < len(p), it also returns an error explaining
-// why the write is short. p must be sorted by time (oldest to most recent).
-func (b *GlucoseReadStreamer) WriteGlucoseReads(p []apimodel.GlucoseRead) (g *GlucoseReadStreamer, err error) {
-	g = newGlucoseStreamerDuration(b.head, b.startTime, b.wr, b.d)
-	if err != nil {
-		return g, err
-	}
-
+// WriteGlucoseReads writes the contents of p into the buffer. p must be sorted by time (oldest to most recent), within the
+// reorder window of the streamer. If writing a batch fails, the error is returned and every subsequent write fails
+// until Flush succeeds.
+func (b *GlucoseReadStreamer) WriteGlucoseReads(p []apimodel.GlucoseRead) (s *GlucoseReadStreamer, err error) {
+	elements := make([]timedElement, len(p))
 	for i := range p {
-		c := p[i]
-
-		t := c.GetTime()
-		truncatedTime := t.Truncate(g.d)
-
-		if g.head == nil {
-			g = newGlucoseStreamerDuration(container.NewImmutableList(nil, c), &truncatedTime, g.wr, g.d)
-		} else if t.Sub(*g.startTime) >= g.d {
-			g, err = g.Flush()
-			if err != nil {
-				return g, err
-			}
-
-			g = newGlucoseStreamerDuration(container.NewImmutableList(nil, c), &truncatedTime, g.wr, g.d)
-		} else {
-			g = newGlucoseStreamerDuration(container.NewImmutableList(g.head, c), g.startTime, g.wr, g.d)
-		}
+		elements[i] = p[i]
 	}
 
-	return g, err
+	core, err := b.core.write(elements)
+	return &GlucoseReadStreamer{core}, err
 }
 
-func newGlucoseStreamerDuration(head *container.ImmutableList, startTime *time.Time, wr glukitio.GlucoseReadBatchWriter, bufferDuration time.Duration) *GlucoseReadStreamer {
-	w := new(GlucoseReadStreamer)
-	w.head = head
-	w.startTime = startTime
-	w.wr = wr
-	w.d = bufferDuration
+// Flush writes any buffered data to the underlying glukitio.Writer as a batch.
+func (b *GlucoseReadStreamer) Flush() (s *GlucoseReadStreamer, err error) {
+	core, err := b.core.flush()
+	return &GlucoseReadStreamer{core}, err
+}
 
-	return w
+// Close flushes the buffer and the inner writer to effectively ensure nothing is left
+// unwritten. The streamer can't be written to once closed and closing it again does nothing.
+func (b *GlucoseReadStreamer) Close() (s *GlucoseReadStreamer, err error) {
+	core, err := b.core.close()
+	return &GlucoseReadStreamer{core}, err
 }
 
-// NewGlucoseStreamerDuration returns a new GlucoseReadStreamer whose buffer has the specified size.
-func NewGlucoseStreamerDuration(wr glukitio.GlucoseReadBatchWriter, bufferDuration time.Duration) *GlucoseReadStreamer {
-	return newGlucoseStreamerDuration(nil, nil, wr, bufferDuration)
+// Buffered returns the number of elements written to the streamer that haven't been flushed yet
+func (b *GlucoseReadStreamer) Buffered() int {
+	return b.core.buffered()
 }
 
-// Flush writes any buffered data to the underlying glukitio.Writer as a batch.
-func (b *GlucoseReadStreamer) Flush() (*GlucoseReadStreamer, error) {
-	r, size := b.head.ReverseList()
-	batch := ListToArrayOfGlucoseReads(r, size)
-
-	if len(batch) > 0 {
-		innerWriter, err := b.wr.WriteGlucoseReadBatch(batch)
-		if err != nil {
-			return nil, err
-		} else {
-			return newGlucoseStreamerDuration(nil, nil, innerWriter, b.d), nil
-		}
-	}
+// Stats returns a snapshot of the activity of the streamer
+func (b *GlucoseReadStreamer) Stats() Stats {
+	return b.core.stats()
+}
 
-	return newGlucoseStreamerDuration(nil, nil, b.wr, b.d), nil
+// ListToArrayOfGlucoseReads returns the elements of the list, whose head is the most recent one,
+// in chronological order
+func ListToArrayOfGlucoseReads(head *container.ImmutableList) []apimodel.GlucoseRead {
+	return appendGlucoseReads(nil, head)
 }
 
-func ListToArrayOfGlucoseReads(head *container.ImmutableList, size int) []apimodel.GlucoseRead {
-	r := make([]apimodel.GlucoseRead, size)
-	cursor := head
-	for i := 0; i < size; i++ {
-		r[i] = cursor.Value().(apimodel.GlucoseRead)
-		cursor = cursor.Next()
+// appendGlucoseReads fills r with the elements of the list in chronological order, reusing its backing array
+// if it's large enough
+func appendGlucoseReads(r []apimodel.GlucoseRead, head *container.ImmutableList) []apimodel.GlucoseRead {
+	if size := head.Len(); cap(r) < size {
+		r = make([]apimodel.GlucoseRead, size)
+	} else {
+		r = r[:size]
 	}
 
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.GlucoseRead)
+	})
+
 	return r
 }
 
-// Close flushes the buffer and the inner writer to effectively ensure nothing is left
-// unwritten
-func (b *GlucoseReadStreamer) Close() (*GlucoseReadStreamer, error) {
-	g, err := b.Flush()
+// glucoseReadBatchWriter adapts a glukitio.GlucoseReadBatchWriter to the streamer core
+type glucoseReadBatchWriter struct {
+	wr glukitio.GlucoseReadBatchWriter
+}
+
+func (w glucoseReadBatchWriter) writeBatch(head *container.ImmutableList) (batchWriter, error) {
+	buffer := glukitio.GetGlucoseReadBatch()
+	batch := appendGlucoseReads(*buffer, head)
+	innerWriter, err := w.wr.WriteGlucoseReadBatch(batch)
+	*buffer = batch
+	glukitio.PutGlucoseReadBatch(buffer)
 	if err != nil {
-		return g, err
+		return w, err
 	}
 
-	innerWriter, err := g.wr.Flush()
+	return glucoseReadBatchWriter{innerWriter}, nil
+}
+
+func (w glucoseReadBatchWriter) flush() (batchWriter, error) {
+	innerWriter, err := w.wr.Flush()
 	if err != nil {
-		return newGlucoseStreamerDuration(g.head, g.startTime, innerWriter, b.d), err
+		return w, err
 	}
 
-	return newGlucoseStreamerDuration(nil, nil, innerWriter, g.d), nil
+	return glucoseReadBatchWriter{innerWriter}, nil
 }
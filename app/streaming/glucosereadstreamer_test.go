@@ -1,6 +1,7 @@
 package streaming_test
 
 import (
+	"errors"
 	"github.com/alexandre-normand/glukit/app/apimodel"
 	"github.com/alexandre-normand/glukit/app/bufio"
 	"github.com/alexandre-normand/glukit/app/glukitio"
@@ -14,6 +15,7 @@ type glucoseWriterState struct {
 	total      int
 	batchCount int
 	writeCount int
+	flushCount int
 	batches    map[int64][]apimodel.GlucoseRead
 }
 
@@ -37,7 +39,7 @@ func NewStatsGlucoseReadWriter(s *glucoseWriterState) *statsGlucoseReadWriter {
 
 func (w *statsGlucoseReadWriter) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (glukitio.GlucoseReadBatchWriter, error) {
 	log.Printf("WriteGlucoseReadBatch with [%d] elements: %v", len(p), p)
-	dayOfGlucoseReads := []apimodel.DayOfGlucoseReads{apimodel.NewDayOfGlucoseReads(p)}
+	dayOfGlucoseReads := []apimodel.DayOfGlucoseReads{apimodel.NewDayOfGlucoseReads(append([]apimodel.GlucoseRead(nil), p...))}
 
 	return w.WriteGlucoseReadBatches(dayOfGlucoseReads)
 }
@@ -58,6 +60,7 @@ func (w *statsGlucoseReadWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGluco
 }
 
 func (w *statsGlucoseReadWriter) Flush() (glukitio.GlucoseReadBatchWriter, error) {
+	w.state.flushCount++
 	return w, nil
 }
 
@@ -287,3 +290,353 @@ func BenchmarkStreamerWithBufferedIO(b *testing.B) {
 		w.Close()
 	}
 }
+
+// discardGlucoseReadWriter drops every batch so that benchmarks only measure the streamer
+type discardGlucoseReadWriter struct{}
+
+func (w discardGlucoseReadWriter) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (glukitio.GlucoseReadBatchWriter, error) {
+	return w, nil
+}
+
+func (w discardGlucoseReadWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (glukitio.GlucoseReadBatchWriter, error) {
+	return w, nil
+}
+
+func (w discardGlucoseReadWriter) Flush() (glukitio.GlucoseReadBatchWriter, error) {
+	return w, nil
+}
+
+func BenchmarkStreamerImportOf100000Reads(b *testing.B) {
+	ct, _ := time.Parse("02/01/2006 00:15", "18/04/2014 00:00")
+	reads := make([]apimodel.GlucoseRead, 100000)
+	for i := range reads {
+		readTime := ct.Add(time.Duration(i) * 5 * time.Minute)
+		reads[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, apimodel.MG_PER_DL, float32(i % 400)}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		w := NewGlucoseStreamerDuration(discardGlucoseReadWriter{}, apimodel.DAY_OF_DATA_DURATION)
+		w, _ = w.WriteGlucoseReads(reads)
+		w.Close()
+	}
+}
+
+func BenchmarkStreamerImportOf100000ReadsThroughBufferedWriter(b *testing.B) {
+	ct, _ := time.Parse("02/01/2006 00:15", "18/04/2014 00:00")
+	reads := make([]apimodel.GlucoseRead, 100000)
+	for i := range reads {
+		readTime := ct.Add(time.Duration(i) * 5 * time.Minute)
+		reads[i] = apimodel.GlucoseRead{Time: apimodel.Time{Timestamp: apimodel.GetTimeMillis(readTime), TimeZoneId: "America/Montreal"},
+			Unit: apimodel.MG_PER_DL, Value: float32(i % 400)}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		w := NewGlucoseStreamerDuration(bufio.NewGlucoseReadWriterSize(discardGlucoseReadWriter{}, 200), apimodel.DAY_OF_DATA_DURATION)
+		w, _ = w.WriteGlucoseReads(reads)
+		w.Close()
+	}
+}
+
+// failingGlucoseReadWriter fails every batch until it's told to accept them
+type failingGlucoseReadWriter struct {
+	accept bool
+	total  int
+}
+
+func (w *failingGlucoseReadWriter) WriteGlucoseReadBatch(p []apimodel.GlucoseRead) (glukitio.GlucoseReadBatchWriter, error) {
+	if !w.accept {
+		return w, errors.New("glucose store unavailable")
+	}
+
+	w.total += len(p)
+	return w, nil
+}
+
+func (w *failingGlucoseReadWriter) WriteGlucoseReadBatches(p []apimodel.DayOfGlucoseReads) (glukitio.GlucoseReadBatchWriter, error) {
+	for i := range p {
+		if _, err := w.WriteGlucoseReadBatch(p[i].Reads); err != nil {
+			return w, err
+		}
+	}
+
+	return w, nil
+}
+
+func (w *failingGlucoseReadWriter) Flush() (glukitio.GlucoseReadBatchWriter, error) {
+	return w, nil
+}
+
+func TestGlucoseReadStreamerKeepsReadsOnFailedClose(t *testing.T) {
+	writer := new(failingGlucoseReadWriter)
+	w := NewGlucoseStreamerDuration(writer, apimodel.DAY_OF_DATA_DURATION)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	for i := 0; i < 20; i++ {
+		readTime := ct.Add(time.Duration(i) * time.Hour)
+		w, _ = w.WriteGlucoseRead(apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, apimodel.MG_PER_DL, float32(i)})
+	}
+
+	w, err := w.Close()
+	if err == nil {
+		t.Fatal("TestGlucoseReadStreamerKeepsReadsOnFailedClose failed: expected close to fail")
+	}
+
+	writer.accept = true
+	if _, err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if writer.total != 20 {
+		t.Errorf("TestGlucoseReadStreamerKeepsReadsOnFailedClose failed: got a total of %d but expected %d", writer.total, 20)
+	}
+}
+
+func TestGlucoseReadStreamerReordersReadsWithinWindow(t *testing.T) {
+	state := NewGlucoseWriterState()
+	w := NewGlucoseStreamerDuration(NewStatsGlucoseReadWriter(state), apimodel.DAY_OF_DATA_DURATION).WithReorderWindow(15 * time.Minute)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	offsets := []time.Duration{0, 5 * time.Minute, 10*time.Minute + 30*time.Second, 10 * time.Minute, 15 * time.Minute, 20 * time.Minute}
+	for i, offset := range offsets {
+		readTime := ct.Add(offset)
+		var err error
+		if w, err = w.WriteGlucoseRead(apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, apimodel.MG_PER_DL, float32(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reads := state.batches[ct.Unix()]
+	if len(reads) != len(offsets) {
+		t.Fatalf("TestGlucoseReadStreamerReordersReadsWithinWindow failed: got %d reads but expected %d", len(reads), len(offsets))
+	}
+
+	for i := 1; i < len(reads); i++ {
+		if reads[i].GetTime().Before(reads[i-1].GetTime()) {
+			t.Errorf("TestGlucoseReadStreamerReordersReadsWithinWindow failed: read [%v] written after [%v]", reads[i], reads[i-1])
+		}
+	}
+}
+
+func TestGlucoseReadStreamerOrdersReadsOfTheSameSecond(t *testing.T) {
+	state := NewGlucoseWriterState()
+	w := NewGlucoseStreamerDuration(NewStatsGlucoseReadWriter(state), apimodel.DAY_OF_DATA_DURATION).WithReorderWindow(15 * time.Minute)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	offsets := []time.Duration{0, 700 * time.Millisecond, 200 * time.Millisecond, 5 * time.Minute}
+	for i, offset := range offsets {
+		var err error
+		if w, err = w.WriteGlucoseRead(apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(offset)), "UTC"}, apimodel.MG_PER_DL, float32(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reads := state.batches[ct.Unix()]
+	expectedValues := []float32{0, 2, 1, 3}
+	if len(reads) != len(expectedValues) {
+		t.Fatalf("TestGlucoseReadStreamerOrdersReadsOfTheSameSecond failed: got %d reads but expected %d", len(reads), len(expectedValues))
+	}
+
+	for i := range expectedValues {
+		if reads[i].Value != expectedValues[i] {
+			t.Errorf("TestGlucoseReadStreamerOrdersReadsOfTheSameSecond failed: got read [%v] at index [%d] but expected value [%f]", reads[i], i, expectedValues[i])
+		}
+	}
+
+	if expected := ct.Add(200 * time.Millisecond); !reads[1].GetTime().Equal(expected) {
+		t.Errorf("TestGlucoseReadStreamerOrdersReadsOfTheSameSecond failed: got time [%v] but expected [%v]", reads[1].GetTime(), expected)
+	}
+}
+
+func TestGlucoseReadStreamerDropsReadsOutsideOfWindow(t *testing.T) {
+	state := NewGlucoseWriterState()
+	w := NewGlucoseStreamerDuration(NewStatsGlucoseReadWriter(state), apimodel.DAY_OF_DATA_DURATION).WithReorderWindow(15 * time.Minute)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 02:00")
+	late := ct.Add(-1 * time.Hour)
+	reads := []apimodel.GlucoseRead{
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct), "UTC"}, apimodel.MG_PER_DL, 100},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(late), "UTC"}, apimodel.MG_PER_DL, 101},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(5 * time.Minute)), "UTC"}, apimodel.MG_PER_DL, 102},
+	}
+
+	w, err := w.WriteGlucoseReads(reads)
+	outOfOrderErr, ok := err.(*OutOfOrderError)
+	if !ok {
+		t.Fatalf("TestGlucoseReadStreamerDropsReadsOutsideOfWindow failed: expected an OutOfOrderError but got [%v]", err)
+	}
+
+	if outOfOrderErr.Count != 1 || !outOfOrderErr.Time.Equal(late) || !outOfOrderErr.Latest.Equal(ct) {
+		t.Errorf("TestGlucoseReadStreamerDropsReadsOutsideOfWindow failed: unexpected error [%v]", outOfOrderErr)
+	}
+
+	if _, err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if state.total != 2 {
+		t.Errorf("TestGlucoseReadStreamerDropsReadsOutsideOfWindow failed: got a total of %d but expected %d", state.total, 2)
+	}
+}
+
+func TestGlucoseBatchesOnLocalMidnightAcrossDSTFallBack(t *testing.T) {
+	state := NewGlucoseWriterState()
+	w := NewGlucoseStreamerDuration(NewStatsGlucoseReadWriter(state), apimodel.DAY_OF_DATA_DURATION).WithBatchBoundary(LocalMidnightBoundary)
+
+	location, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clocks go back from 02:00 to 01:00 on November 2nd 2014 in Montreal so that day lasts 25 hours
+	start := time.Date(2014, 11, 1, 22, 0, 0, 0, location)
+	for i := 0; i < 30; i++ {
+		readTime := start.Add(time.Duration(i) * time.Hour)
+		if w, err = w.WriteGlucoseRead(apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, apimodel.MG_PER_DL, float32(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedBatches := map[time.Time]int{
+		time.Date(2014, 11, 1, 22, 0, 0, 0, location): 2,
+		time.Date(2014, 11, 2, 0, 0, 0, 0, location):  25,
+		time.Date(2014, 11, 3, 0, 0, 0, 0, location):  3,
+	}
+
+	if state.batchCount != len(expectedBatches) {
+		t.Errorf("TestGlucoseBatchesOnLocalMidnightAcrossDSTFallBack failed: got a batchCount of %d but expected %d", state.batchCount, len(expectedBatches))
+	}
+
+	for batchStart, expectedSize := range expectedBatches {
+		if actualSize := len(state.batches[batchStart.Unix()]); actualSize != expectedSize {
+			t.Errorf("TestGlucoseBatchesOnLocalMidnightAcrossDSTFallBack failed: got %d reads in batch starting at [%v] but expected %d", actualSize, batchStart, expectedSize)
+		}
+	}
+}
+
+func TestGlucoseBatchesOnMidnightInLocationAcrossDSTSpringForward(t *testing.T) {
+	state := NewGlucoseWriterState()
+	w := NewGlucoseStreamerDuration(NewStatsGlucoseReadWriter(state), apimodel.DAY_OF_DATA_DURATION).WithBatchBoundary(MidnightBoundaryIn("America/Los_Angeles"))
+
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clocks go forward from 02:00 to 03:00 on March 8th 2015 in Los Angeles so that day lasts 23 hours. The device
+	// records reads with a fixed offset that doesn't follow the change.
+	start := time.Date(2015, 3, 7, 22, 0, 0, 0, location)
+	for i := 0; i < 30; i++ {
+		readTime := start.Add(time.Duration(i) * time.Hour)
+		if w, err = w.WriteGlucoseRead(apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "-0800"}, apimodel.MG_PER_DL, float32(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedBatches := map[time.Time]int{
+		time.Date(2015, 3, 7, 22, 0, 0, 0, location): 2,
+		time.Date(2015, 3, 8, 0, 0, 0, 0, location):  23,
+		time.Date(2015, 3, 9, 0, 0, 0, 0, location):  5,
+	}
+
+	if state.batchCount != len(expectedBatches) {
+		t.Errorf("TestGlucoseBatchesOnMidnightInLocationAcrossDSTSpringForward failed: got a batchCount of %d but expected %d", state.batchCount, len(expectedBatches))
+	}
+
+	for batchStart, expectedSize := range expectedBatches {
+		if actualSize := len(state.batches[batchStart.Unix()]); actualSize != expectedSize {
+			t.Errorf("TestGlucoseBatchesOnMidnightInLocationAcrossDSTSpringForward failed: got %d reads in batch starting at [%v] but expected %d", actualSize, batchStart, expectedSize)
+		}
+	}
+}
+
+func TestGlucoseStreamerCloseIsIdempotent(t *testing.T) {
+	state := NewGlucoseWriterState()
+	w := NewGlucoseStreamerDuration(NewStatsGlucoseReadWriter(state), apimodel.DAY_OF_DATA_DURATION)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	for i := 0; i < 10; i++ {
+		readTime := ct.Add(time.Duration(i) * time.Hour)
+		w, _ = w.WriteGlucoseRead(apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, apimodel.MG_PER_DL, float32(i)})
+	}
+
+	w, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w, err = w.Close(); err != nil {
+		t.Errorf("TestGlucoseStreamerCloseIsIdempotent failed: expected second close to do nothing but got [%v]", err)
+	}
+
+	if state.total != 10 || state.writeCount != 1 || state.flushCount != 1 {
+		t.Errorf("TestGlucoseStreamerCloseIsIdempotent failed: got total [%d] with [%d] writes and [%d] flushes but expected [10] with [1] write and [1] flush", state.total, state.writeCount, state.flushCount)
+	}
+
+	readTime := ct.Add(time.Duration(20) * time.Hour)
+	if _, err = w.WriteGlucoseRead(apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, apimodel.MG_PER_DL, 20}); err != ErrClosed {
+		t.Errorf("TestGlucoseStreamerCloseIsIdempotent failed: expected write after close to fail with [%v] but got [%v]", ErrClosed, err)
+	}
+
+	if _, err = w.Flush(); err != ErrClosed {
+		t.Errorf("TestGlucoseStreamerCloseIsIdempotent failed: expected flush after close to fail with [%v] but got [%v]", ErrClosed, err)
+	}
+}
+
+func TestGlucoseReadStreamerReportsGaps(t *testing.T) {
+	state := NewGlucoseWriterState()
+	var gaps []apimodel.ReadGap
+	w := NewGlucoseStreamerDuration(NewStatsGlucoseReadWriter(state), apimodel.DAY_OF_DATA_DURATION).WithGapDetection(time.Hour, func(gap apimodel.ReadGap) {
+		gaps = append(gaps, gap)
+	})
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	var reads []apimodel.GlucoseRead
+	for i := 0; i < 10; i++ {
+		readTime := ct.Add(time.Duration(i*5) * time.Minute)
+		if i >= 5 {
+			readTime = readTime.Add(3 * time.Hour)
+		}
+		reads = append(reads, apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, apimodel.MG_PER_DL, float32(i)})
+	}
+
+	w, err := w.WriteGlucoseReads(reads)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gaps) != 1 {
+		t.Fatalf("TestGlucoseReadStreamerReportsGaps failed: got %d gaps but expected 1", len(gaps))
+	}
+
+	if gaps[0].Start != reads[4].Time || gaps[0].End != reads[5].Time || gaps[0].Duration() != 3*time.Hour+5*time.Minute {
+		t.Errorf("TestGlucoseReadStreamerReportsGaps failed: expected gap between [%v] and [%v] but got [%v]", reads[4].Time, reads[5].Time, gaps[0])
+	}
+
+	if state.total != len(reads) || state.batchCount != 1 {
+		t.Errorf("TestGlucoseReadStreamerReportsGaps failed: got total [%d] in [%d] batches but expected [%d] in [1]", state.total, state.batchCount, len(reads))
+	}
+}
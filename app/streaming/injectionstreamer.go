@@ -7,26 +7,36 @@ import (
 	"time"
 )
 
+// InjectionStreamer streams injections into batches of bufferDuration (i.e. a day of data). It's a thin adapter of the streamer core
+// so see streamer for how buffers are flushed and how errors are handled.
 type InjectionStreamer struct {
-	head      *container.ImmutableList
-	startTime *time.Time
-	wr        glukitio.InjectionBatchWriter
-	d         time.Duration
+	core *streamer
 }
 
-// NewInjectionStreamerDuration returns a new InjectionStreamer whose buffer has the specified size.
+// NewInjectionStreamerDuration returns a new InjectionStreamer that writes a batch every time the buffer duration elapses or when it
+// holds BUFFER_SIZE injections.
 func NewInjectionStreamerDuration(wr glukitio.InjectionBatchWriter, bufferDuration time.Duration) *InjectionStreamer {
-	return newInjectionStreamerDuration(nil, nil, wr, bufferDuration)
+	return NewInjectionStreamerDurationCount(wr, bufferDuration, BUFFER_SIZE)
 }
 
-func newInjectionStreamerDuration(head *container.ImmutableList, startTime *time.Time, wr glukitio.InjectionBatchWriter, bufferDuration time.Duration) *InjectionStreamer {
-	w := new(InjectionStreamer)
-	w.head = head
-	w.startTime = startTime
-	w.wr = wr
-	w.d = bufferDuration
+// NewInjectionStreamerDurationCount returns a new InjectionStreamer that also flushes its buffer as a batch when it holds maxCount
+// elements, even if the buffer duration hasn't elapsed. A day of data can then be split in multiple batches. A
+// maxCount of 0 means there's no limit on the number of elements.
+func NewInjectionStreamerDurationCount(wr glukitio.InjectionBatchWriter, bufferDuration time.Duration, maxCount int) *InjectionStreamer {
+	return &InjectionStreamer{newStreamer(injectionBatchWriter{wr}, bufferDuration, maxCount)}
+}
+
+// WithReorderWindow returns a copy of the streamer that tolerates elements written up to window older than the most
+// recent one. They're held back and sorted before being buffered. Elements older than that are dropped and reported
+// as an OutOfOrderError.
+func (b *InjectionStreamer) WithReorderWindow(window time.Duration) *InjectionStreamer {
+	return &InjectionStreamer{b.core.withReorderWindow(window)}
+}
 
-	return w
+// WithBatchBoundary returns a copy of the streamer that starts batches at the given boundary. LocalMidnightBoundary
+// aligns batches of a day on the calendar days of the user.
+func (b *InjectionStreamer) WithBatchBoundary(boundary BatchBoundary) *InjectionStreamer {
+	return &InjectionStreamer{b.core.withBatchBoundary(boundary)}
 }
 
 // WriteInjection writes a single Injection into the buffer.
@@ -34,77 +44,72 @@ func (b *InjectionStreamer) WriteInjection(c apimodel.Injection) (s *InjectionSt
 	return b.WriteInjections([]apimodel.Injection{c})
 }
 
-// WriteInjections writes the contents of p into the buffer.
-// It returns the number of bytes written.
-// If nn < len(p), it also returns an error explaining
-// why the write is short. p must be sorted by time (oldest to most recent).
+// WriteInjections writes the contents of p into the buffer. p must be sorted by time (oldest to most recent), within the
+// reorder window of the streamer. If writing a batch fails, the error is returned and every subsequent write fails
+// until Flush succeeds.
 func (b *InjectionStreamer) WriteInjections(p []apimodel.Injection) (s *InjectionStreamer, err error) {
-	s = newInjectionStreamerDuration(b.head, b.startTime, b.wr, b.d)
-	if err != nil {
-		return s, err
-	}
-
+	elements := make([]timedElement, len(p))
 	for i := range p {
-		c := p[i]
-		t := c.GetTime()
-		truncatedTime := t.Truncate(s.d)
-
-		if s.head == nil {
-			s = newInjectionStreamerDuration(container.NewImmutableList(nil, c), &truncatedTime, s.wr, s.d)
-		} else if t.Sub(*s.startTime) >= s.d {
-			s, err = s.Flush()
-			if err != nil {
-				return s, err
-			}
-			s = newInjectionStreamerDuration(container.NewImmutableList(nil, c), &truncatedTime, s.wr, s.d)
-		} else {
-			s = newInjectionStreamerDuration(container.NewImmutableList(s.head, c), s.startTime, s.wr, s.d)
-		}
+		elements[i] = p[i]
 	}
 
-	return s, err
+	core, err := b.core.write(elements)
+	return &InjectionStreamer{core}, err
 }
 
 // Flush writes any buffered data to the underlying glukitio.Writer as a batch.
 func (b *InjectionStreamer) Flush() (s *InjectionStreamer, err error) {
-	r, size := b.head.ReverseList()
-	batch := ListToArrayOfInjectionReads(r, size)
-
-	if len(batch) > 0 {
-		innerWriter, err := b.wr.WriteInjectionBatch(batch)
-		if err != nil {
-			return nil, err
-		} else {
-			return newInjectionStreamerDuration(nil, nil, innerWriter, b.d), nil
-		}
-	}
+	core, err := b.core.flush()
+	return &InjectionStreamer{core}, err
+}
 
-	return newInjectionStreamerDuration(nil, nil, b.wr, b.d), nil
+// Close flushes the buffer and the inner writer to effectively ensure nothing is left
+// unwritten. The streamer can't be written to once closed and closing it again does nothing.
+func (b *InjectionStreamer) Close() (s *InjectionStreamer, err error) {
+	core, err := b.core.close()
+	return &InjectionStreamer{core}, err
 }
 
-func ListToArrayOfInjectionReads(head *container.ImmutableList, size int) []apimodel.Injection {
-	r := make([]apimodel.Injection, size)
-	cursor := head
-	for i := 0; i < size; i++ {
-		r[i] = cursor.Value().(apimodel.Injection)
-		cursor = cursor.Next()
-	}
+// Buffered returns the number of elements written to the streamer that haven't been flushed yet
+func (b *InjectionStreamer) Buffered() int {
+	return b.core.buffered()
+}
+
+// Stats returns a snapshot of the activity of the streamer
+func (b *InjectionStreamer) Stats() Stats {
+	return b.core.stats()
+}
+
+// ListToArrayOfInjectionReads returns the elements of the list, whose head is the most recent one,
+// in chronological order
+func ListToArrayOfInjectionReads(head *container.ImmutableList) []apimodel.Injection {
+	r := make([]apimodel.Injection, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.Injection)
+	})
 
 	return r
 }
 
-// Close flushes the buffer and the inner writer to effectively ensure nothing is left
-// unwritten
-func (b *InjectionStreamer) Close() (s *InjectionStreamer, err error) {
-	g, err := b.Flush()
+// injectionBatchWriter adapts a glukitio.InjectionBatchWriter to the streamer core
+type injectionBatchWriter struct {
+	wr glukitio.InjectionBatchWriter
+}
+
+func (w injectionBatchWriter) writeBatch(head *container.ImmutableList) (batchWriter, error) {
+	innerWriter, err := w.wr.WriteInjectionBatch(ListToArrayOfInjectionReads(head))
 	if err != nil {
-		return g, err
+		return w, err
 	}
 
-	innerWriter, err := g.wr.Flush()
+	return injectionBatchWriter{innerWriter}, nil
+}
+
+func (w injectionBatchWriter) flush() (batchWriter, error) {
+	innerWriter, err := w.wr.Flush()
 	if err != nil {
-		return newInjectionStreamerDuration(g.head, g.startTime, innerWriter, b.d), err
+		return w, err
 	}
 
-	return newInjectionStreamerDuration(nil, nil, innerWriter, g.d), nil
+	return injectionBatchWriter{innerWriter}, nil
 }
@@ -70,7 +70,7 @@ func TestWriteOfDayInjectionBatch(t *testing.T) {
 
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i) * time.Hour)
-		w, _ = w.WriteInjection(apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus"})
+		w, _ = w.WriteInjection(apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false})
 	}
 
 	if state.total != 24 {
@@ -96,7 +96,7 @@ func TestWriteOfDayInjectionBatchesInSingleCall(t *testing.T) {
 
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i) * time.Hour)
-		injections[i] = apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus"}
+		injections[i] = apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false}
 	}
 
 	w, _ = w.WriteInjections(injections)
@@ -123,7 +123,7 @@ func TestWriteOfHourlyInjectionBatch(t *testing.T) {
 
 	for i := 0; i < 13; i++ {
 		readTime := ct.Add(time.Duration(i*5) * time.Minute)
-		w, _ = w.WriteInjection(apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus"})
+		w, _ = w.WriteInjection(apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false})
 	}
 
 	if state.total != 12 {
@@ -162,7 +162,7 @@ func TestWriteOfMultipleInjectionBatches(t *testing.T) {
 
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i*5) * time.Minute)
-		w, _ = w.WriteInjection(apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus"})
+		w, _ = w.WriteInjection(apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false})
 	}
 
 	if state.total != 24 {
@@ -203,7 +203,7 @@ func TestInjectionStreamerWithBufferedIO(t *testing.T) {
 	for b := 0; b < 3; b++ {
 		for i := 0; i < 48; i++ {
 			readTime := ct.Add(time.Duration(b*48+i) * 30 * time.Minute)
-			w, _ = w.WriteInjection(apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(b*48 + i), "Humalog", "Bolus"})
+			w, _ = w.WriteInjection(apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(b*48 + i), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false})
 		}
 	}
 
@@ -241,7 +241,7 @@ func TestInjectionBatchBoundaries(t *testing.T) {
 	for b := 0; b < 3; b++ {
 		for i := 0; i < 48; i++ {
 			readTime := ct.Add(time.Duration(b*48+i) * 30 * time.Minute)
-			w, _ = w.WriteInjection(apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(b*48 + i), "Humalog", "Bolus"})
+			w, _ = w.WriteInjection(apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(b*48 + i), "Humalog", "Bolus", apimodel.INSULIN_CATEGORY_RAPID_ACTING, "", false, false})
 		}
 	}
 
@@ -7,26 +7,36 @@ import (
 	"time"
 )
 
+// MealStreamer streams meals into batches of bufferDuration (i.e. a day of data). It's a thin adapter of the streamer core
+// so see streamer for how buffers are flushed and how errors are handled.
 type MealStreamer struct {
-	head      *container.ImmutableList
-	startTime *time.Time
-	wr        glukitio.MealBatchWriter
-	d         time.Duration
+	core *streamer
 }
 
-// NewMealStreamerDuration returns a new MealStreamer whose buffer has the specified size.
+// NewMealStreamerDuration returns a new MealStreamer that writes a batch every time the buffer duration elapses or when it
+// holds BUFFER_SIZE meals.
 func NewMealStreamerDuration(wr glukitio.MealBatchWriter, bufferDuration time.Duration) *MealStreamer {
-	return newMealStreamerDuration(nil, nil, wr, bufferDuration)
+	return NewMealStreamerDurationCount(wr, bufferDuration, BUFFER_SIZE)
 }
 
-func newMealStreamerDuration(head *container.ImmutableList, startTime *time.Time, wr glukitio.MealBatchWriter, bufferDuration time.Duration) *MealStreamer {
-	w := new(MealStreamer)
-	w.head = head
-	w.startTime = startTime
-	w.wr = wr
-	w.d = bufferDuration
+// NewMealStreamerDurationCount returns a new MealStreamer that also flushes its buffer as a batch when it holds maxCount
+// elements, even if the buffer duration hasn't elapsed. A day of data can then be split in multiple batches. A
+// maxCount of 0 means there's no limit on the number of elements.
+func NewMealStreamerDurationCount(wr glukitio.MealBatchWriter, bufferDuration time.Duration, maxCount int) *MealStreamer {
+	return &MealStreamer{newStreamer(mealBatchWriter{wr}, bufferDuration, maxCount)}
+}
+
+// WithReorderWindow returns a copy of the streamer that tolerates elements written up to window older than the most
+// recent one. They're held back and sorted before being buffered. Elements older than that are dropped and reported
+// as an OutOfOrderError.
+func (b *MealStreamer) WithReorderWindow(window time.Duration) *MealStreamer {
+	return &MealStreamer{b.core.withReorderWindow(window)}
+}
 
-	return w
+// WithBatchBoundary returns a copy of the streamer that starts batches at the given boundary. LocalMidnightBoundary
+// aligns batches of a day on the calendar days of the user.
+func (b *MealStreamer) WithBatchBoundary(boundary BatchBoundary) *MealStreamer {
+	return &MealStreamer{b.core.withBatchBoundary(boundary)}
 }
 
 // WriteMeal writes a single Meal into the buffer.
@@ -34,77 +44,72 @@ func (b *MealStreamer) WriteMeal(c apimodel.Meal) (s *MealStreamer, err error) {
 	return b.WriteMeals([]apimodel.Meal{c})
 }
 
-// WriteMeals writes the contents of p into the buffer.
-// It returns the number of bytes written.
-// If nn < len(p), it also returns an error explaining
-// why the write is short. p must be sorted by time (oldest to most recent).
+// WriteMeals writes the contents of p into the buffer. p must be sorted by time (oldest to most recent), within the
+// reorder window of the streamer. If writing a batch fails, the error is returned and every subsequent write fails
+// until Flush succeeds.
 func (b *MealStreamer) WriteMeals(p []apimodel.Meal) (s *MealStreamer, err error) {
-	s = newMealStreamerDuration(b.head, b.startTime, b.wr, b.d)
-	if err != nil {
-		return s, err
-	}
-
+	elements := make([]timedElement, len(p))
 	for i := range p {
-		c := p[i]
-		t := c.GetTime()
-		truncatedTime := t.Truncate(s.d)
-
-		if s.head == nil {
-			s = newMealStreamerDuration(container.NewImmutableList(nil, c), &truncatedTime, s.wr, s.d)
-		} else if t.Sub(*s.startTime) >= s.d {
-			s, err = s.Flush()
-			if err != nil {
-				return s, err
-			}
-			s = newMealStreamerDuration(container.NewImmutableList(nil, c), &truncatedTime, s.wr, s.d)
-		} else {
-			s = newMealStreamerDuration(container.NewImmutableList(s.head, c), s.startTime, s.wr, s.d)
-		}
+		elements[i] = p[i]
 	}
 
-	return s, err
+	core, err := b.core.write(elements)
+	return &MealStreamer{core}, err
 }
 
 // Flush writes any buffered data to the underlying glukitio.Writer as a batch.
 func (b *MealStreamer) Flush() (s *MealStreamer, err error) {
-	r, size := b.head.ReverseList()
-	batch := ListToArrayOfMealReads(r, size)
-
-	if len(batch) > 0 {
-		innerWriter, err := b.wr.WriteMealBatch(batch)
-		if err != nil {
-			return nil, err
-		} else {
-			return newMealStreamerDuration(nil, nil, innerWriter, b.d), nil
-		}
-	}
+	core, err := b.core.flush()
+	return &MealStreamer{core}, err
+}
 
-	return newMealStreamerDuration(nil, nil, b.wr, b.d), nil
+// Close flushes the buffer and the inner writer to effectively ensure nothing is left
+// unwritten. The streamer can't be written to once closed and closing it again does nothing.
+func (b *MealStreamer) Close() (s *MealStreamer, err error) {
+	core, err := b.core.close()
+	return &MealStreamer{core}, err
 }
 
-func ListToArrayOfMealReads(head *container.ImmutableList, size int) []apimodel.Meal {
-	r := make([]apimodel.Meal, size)
-	cursor := head
-	for i := 0; i < size; i++ {
-		r[i] = cursor.Value().(apimodel.Meal)
-		cursor = cursor.Next()
-	}
+// Buffered returns the number of elements written to the streamer that haven't been flushed yet
+func (b *MealStreamer) Buffered() int {
+	return b.core.buffered()
+}
+
+// Stats returns a snapshot of the activity of the streamer
+func (b *MealStreamer) Stats() Stats {
+	return b.core.stats()
+}
+
+// ListToArrayOfMealReads returns the elements of the list, whose head is the most recent one,
+// in chronological order
+func ListToArrayOfMealReads(head *container.ImmutableList) []apimodel.Meal {
+	r := make([]apimodel.Meal, head.Len())
+	head.ForEachIndexed(func(i int, value interface{}) {
+		r[i] = value.(apimodel.Meal)
+	})
 
 	return r
 }
 
-// Close flushes the buffer and the inner writer to effectively ensure nothing is left
-// unwritten
-func (b *MealStreamer) Close() (s *MealStreamer, err error) {
-	g, err := b.Flush()
+// mealBatchWriter adapts a glukitio.MealBatchWriter to the streamer core
+type mealBatchWriter struct {
+	wr glukitio.MealBatchWriter
+}
+
+func (w mealBatchWriter) writeBatch(head *container.ImmutableList) (batchWriter, error) {
+	innerWriter, err := w.wr.WriteMealBatch(ListToArrayOfMealReads(head))
 	if err != nil {
-		return g, err
+		return w, err
 	}
 
-	innerWriter, err := g.wr.Flush()
+	return mealBatchWriter{innerWriter}, nil
+}
+
+func (w mealBatchWriter) flush() (batchWriter, error) {
+	innerWriter, err := w.wr.Flush()
 	if err != nil {
-		return newMealStreamerDuration(g.head, g.startTime, innerWriter, b.d), err
+		return w, err
 	}
 
-	return newMealStreamerDuration(nil, nil, innerWriter, g.d), nil
+	return mealBatchWriter{innerWriter}, nil
 }
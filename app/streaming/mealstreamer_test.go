@@ -1,6 +1,7 @@
 package streaming_test
 
 import (
+	"errors"
 	"github.com/alexandre-normand/glukit/app/apimodel"
 	"github.com/alexandre-normand/glukit/app/bufio"
 	"github.com/alexandre-normand/glukit/app/glukitio"
@@ -14,6 +15,7 @@ type mealWriterState struct {
 	total      int
 	batchCount int
 	writeCount int
+	flushCount int
 	batches    map[int64][]apimodel.Meal
 }
 
@@ -59,6 +61,7 @@ func (w *statsMealReadWriter) WriteMealBatches(p []apimodel.DayOfMeals) (glukiti
 }
 
 func (w *statsMealReadWriter) Flush() (glukitio.MealBatchWriter, error) {
+	w.state.flushCount++
 	return w, nil
 }
 
@@ -70,7 +73,7 @@ func TestWriteOfDayMealBatch(t *testing.T) {
 
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i) * time.Hour)
-		w, _ = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3)})
+		w, _ = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3), float32(i + 4), "", "", false, false})
 	}
 
 	if state.total != 24 {
@@ -96,7 +99,7 @@ func TestWriteOfDayMealBatchesInSingleCall(t *testing.T) {
 
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i) * time.Hour)
-		meals[i] = apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3)}
+		meals[i] = apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3), float32(i + 4), "", "", false, false}
 	}
 
 	w, _ = w.WriteMeals(meals)
@@ -123,7 +126,7 @@ func TestWriteOfHourlyMealBatch(t *testing.T) {
 
 	for i := 0; i < 13; i++ {
 		readTime := ct.Add(time.Duration(i*5) * time.Minute)
-		w, _ = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3)})
+		w, _ = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3), float32(i + 4), "", "", false, false})
 	}
 
 	if state.total != 12 {
@@ -162,7 +165,7 @@ func TestWriteOfMultipleMealBatches(t *testing.T) {
 
 	for i := 0; i < 25; i++ {
 		readTime := ct.Add(time.Duration(i*5) * time.Minute)
-		w, _ = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3)})
+		w, _ = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3), float32(i + 4), "", "", false, false})
 	}
 
 	if state.total != 24 {
@@ -203,7 +206,7 @@ func TestMealStreamerWithBufferedIO(t *testing.T) {
 	for b := 0; b < 3; b++ {
 		for i := 0; i < 48; i++ {
 			readTime := ct.Add(time.Duration(b*48+i) * 30 * time.Minute)
-			w, _ = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3)})
+			w, _ = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3), float32(i + 4), "", "", false, false})
 		}
 	}
 
@@ -241,7 +244,7 @@ func TestMealBatchBoundaries(t *testing.T) {
 	for b := 0; b < 3; b++ {
 		for i := 0; i < 48; i++ {
 			readTime := ct.Add(time.Duration(b*48+i) * 30 * time.Minute)
-			w, _ = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3)})
+			w, _ = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), float32(i + 1), float32(i + 2), float32(i + 3), float32(i + 4), "", "", false, false})
 		}
 	}
 
@@ -278,3 +281,212 @@ func TestMealBatchBoundaries(t *testing.T) {
 		t.Errorf("TestMealBatchBoundaries test failed: could not find fourth batch starting with a read time of [%v]/ts[%d] in batches: [%v]", fourthBatchTime, fourthBatchTime.Unix(), state.batches)
 	}
 }
+
+var errMealStoreUnavailable = errors.New("meal store unavailable")
+
+// failOnceMealWriter fails the first batch written to it and keeps every meal of the following batches
+type failOnceMealWriter struct {
+	failed bool
+	meals  map[int64]int
+}
+
+func (w *failOnceMealWriter) WriteMealBatch(p []apimodel.Meal) (glukitio.MealBatchWriter, error) {
+	if !w.failed {
+		w.failed = true
+		return w, errMealStoreUnavailable
+	}
+
+	for _, meal := range p {
+		w.meals[meal.Time.Timestamp]++
+	}
+
+	return w, nil
+}
+
+func (w *failOnceMealWriter) WriteMealBatches(p []apimodel.DayOfMeals) (glukitio.MealBatchWriter, error) {
+	for i := range p {
+		if _, err := w.WriteMealBatch(p[i].Meals); err != nil {
+			return w, err
+		}
+	}
+
+	return w, nil
+}
+
+func (w *failOnceMealWriter) Flush() (glukitio.MealBatchWriter, error) {
+	return w, nil
+}
+
+func TestMealStreamerRecoversFromFailedFlush(t *testing.T) {
+	writer := &failOnceMealWriter{meals: make(map[int64]int)}
+	w := NewMealStreamerDuration(writer, apimodel.DAY_OF_DATA_DURATION)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	meals := make([]apimodel.Meal, 72)
+	for i := range meals {
+		readTime := ct.Add(time.Duration(i) * time.Hour)
+		meals[i] = apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), 0, 0, 0, 0, "", "", false, false}
+	}
+
+	w, err := w.WriteMeals(meals)
+	if err != errMealStoreUnavailable {
+		t.Fatalf("TestMealStreamerRecoversFromFailedFlush failed: expected error [%v] but got [%v]", errMealStoreUnavailable, err)
+	}
+
+	lateMeal := apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(80) * time.Hour)), "America/Montreal"}, 80, 0, 0, 0, 0, "", "", false, false}
+	if _, err := w.WriteMeal(lateMeal); err != errMealStoreUnavailable {
+		t.Errorf("TestMealStreamerRecoversFromFailedFlush failed: expected writes to fail fast with [%v] but got [%v]", errMealStoreUnavailable, err)
+	}
+
+	if w, err = w.Flush(); err != nil {
+		t.Fatalf("TestMealStreamerRecoversFromFailedFlush failed: expected flush to recover but got [%v]", err)
+	}
+
+	if w, err = w.WriteMeal(lateMeal); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(writer.meals) != len(meals)+1 {
+		t.Errorf("TestMealStreamerRecoversFromFailedFlush failed: got %d distinct meals but expected %d", len(writer.meals), len(meals)+1)
+	}
+
+	for timestamp, count := range writer.meals {
+		if count != 1 {
+			t.Errorf("TestMealStreamerRecoversFromFailedFlush failed: meal at [%d] was written %d times", timestamp, count)
+		}
+	}
+}
+
+func TestMealStreamerFlushesAtMaxCount(t *testing.T) {
+	state := NewMealWriterState()
+	w := NewMealStreamerDurationCount(NewStatsMealReadWriter(state), apimodel.DAY_OF_DATA_DURATION, 30)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	meals := make([]apimodel.Meal, 100)
+	for i := range meals {
+		readTime := ct.Add(time.Duration(i) * time.Minute)
+		meals[i] = apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), 0, 0, 0, 0, "", "", false, false}
+	}
+
+	w, _ = w.WriteMeals(meals)
+	w.Close()
+
+	if state.total != 100 {
+		t.Errorf("TestMealStreamerFlushesAtMaxCount failed: got a total of %d but expected %d", state.total, 100)
+	}
+
+	if state.batchCount != 4 {
+		t.Errorf("TestMealStreamerFlushesAtMaxCount failed: got a batchCount of %d but expected %d", state.batchCount, 4)
+	}
+
+	// Batches are keyed on their first meal so they must be disjoint and ordered
+	for i, expectedSize := range []int{30, 30, 30, 10} {
+		if batch := state.batches[meals[i*30].GetTime().Unix()]; len(batch) != expectedSize {
+			t.Errorf("TestMealStreamerFlushesAtMaxCount failed: got batch %d of size %d but expected %d", i, len(batch), expectedSize)
+		}
+	}
+}
+
+func TestMealStreamerStats(t *testing.T) {
+	writer := &failOnceMealWriter{meals: make(map[int64]int)}
+	w := NewMealStreamerDuration(writer, apimodel.DAY_OF_DATA_DURATION)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	meals := make([]apimodel.Meal, 72)
+	for i := range meals {
+		readTime := ct.Add(time.Duration(i) * time.Hour)
+		meals[i] = apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, float32(i), 0, 0, 0, 0, "", "", false, false}
+	}
+
+	w, _ = w.WriteMeals(meals)
+	stats := w.Stats()
+	if w.Buffered() != len(meals) || stats.Buffered != len(meals) || stats.Written != len(meals) || stats.Flushed != 0 {
+		t.Errorf("TestMealStreamerStats failed: unexpected stats after failed flush [%s]", stats)
+	}
+
+	if !stats.OldestBuffered.Equal(ct) || !stats.NewestBuffered.Equal(ct.Add(71*time.Hour)) {
+		t.Errorf("TestMealStreamerStats failed: expected meals buffered from [%v] to [%v] but got [%s]", ct, ct.Add(71*time.Hour), stats)
+	}
+
+	if stats.LastFlushError != errMealStoreUnavailable {
+		t.Errorf("TestMealStreamerStats failed: expected last flush error [%v] but got [%v]", errMealStoreUnavailable, stats.LastFlushError)
+	}
+
+	w, err := w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats = w.Stats()
+	if w.Buffered() != 0 || stats.Flushed != 3 || !stats.OldestBuffered.IsZero() || stats.LastFlushError != errMealStoreUnavailable {
+		t.Errorf("TestMealStreamerStats failed: unexpected stats after recovery [%s]", stats)
+	}
+}
+
+func TestMealStreamerCloseIsIdempotent(t *testing.T) {
+	state := NewMealWriterState()
+	w := NewMealStreamerDuration(NewStatsMealReadWriter(state), apimodel.DAY_OF_DATA_DURATION)
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	for i := 0; i < 10; i++ {
+		readTime := ct.Add(time.Duration(i) * time.Hour)
+		w, _ = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, float32(i), 0, 0, 0, 0, "", "", false, false})
+	}
+
+	w, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w, err = w.Close(); err != nil {
+		t.Errorf("TestMealStreamerCloseIsIdempotent failed: expected second close to do nothing but got [%v]", err)
+	}
+
+	if state.total != 10 || state.writeCount != 1 || state.flushCount != 1 {
+		t.Errorf("TestMealStreamerCloseIsIdempotent failed: got total [%d] with [%d] writes and [%d] flushes but expected [10] with [1] write and [1] flush", state.total, state.writeCount, state.flushCount)
+	}
+
+	readTime := ct.Add(time.Duration(20) * time.Hour)
+	if _, err = w.WriteMeal(apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "UTC"}, 20, 0, 0, 0, 0, "", "", false, false}); err != ErrClosed {
+		t.Errorf("TestMealStreamerCloseIsIdempotent failed: expected write after close to fail with [%v] but got [%v]", ErrClosed, err)
+	}
+
+	if _, err = w.Flush(); err != ErrClosed {
+		t.Errorf("TestMealStreamerCloseIsIdempotent failed: expected flush after close to fail with [%v] but got [%v]", ErrClosed, err)
+	}
+}
+
+// discardMealWriter drops every batch so that benchmarks only measure the streamer
+type discardMealWriter struct{}
+
+func (w discardMealWriter) WriteMealBatch(p []apimodel.Meal) (glukitio.MealBatchWriter, error) {
+	return w, nil
+}
+
+func (w discardMealWriter) WriteMealBatches(p []apimodel.DayOfMeals) (glukitio.MealBatchWriter, error) {
+	return w, nil
+}
+
+func (w discardMealWriter) Flush() (glukitio.MealBatchWriter, error) {
+	return w, nil
+}
+
+func BenchmarkMealStreamerFlushOf10000Meals(b *testing.B) {
+	ct, _ := time.Parse("02/01/2006 00:15", "18/04/2014 00:00")
+	meals := make([]apimodel.Meal, 10000)
+	for i := range meals {
+		readTime := ct.Add(time.Duration(i) * time.Second)
+		meals[i] = apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Montreal"}, float32(i), 0, 0, 0, 0, "", "", false, false}
+	}
+
+	w, _ := NewMealStreamerDurationCount(discardMealWriter{}, apimodel.DAY_OF_DATA_DURATION, 0).WriteMeals(meals)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		w.Flush()
+	}
+}
@@ -0,0 +1,393 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/container"
+	"github.com/alexandre-normand/glukit/app/util"
+	"time"
+)
+
+const (
+	// Default maximum number of elements a streamer buffers before flushing them as a batch
+	BUFFER_SIZE = 86400
+)
+
+// ErrClosed is returned by writes and flushes of a streamer that was closed
+var ErrClosed = errors.New("Streamer is closed")
+
+// timedElement is a record that can be streamed, all that matters to a streamer is its time
+type timedElement interface {
+	GetTime() time.Time
+}
+
+// batchWriter adapts the typed glukitio writer of a streamer to the streamer core. Like the glukitio writers, it returns
+// the writer to use for subsequent writes.
+type batchWriter interface {
+	// writeBatch writes the elements of the list, most recent first, as a single batch in chronological order
+	writeBatch(head *container.ImmutableList) (batchWriter, error)
+	flush() (batchWriter, error)
+}
+
+// BatchBoundary returns the start of the batch that an element at time t belongs to given the buffer duration of a
+// streamer. A streamer writes its buffer as a batch as soon as an element belongs to a later batch.
+type BatchBoundary func(t time.Time, d time.Duration) time.Time
+
+// TruncatedBoundary starts batches at multiples of the buffer duration since the zero time. For a day, that's UTC
+// midnight whatever the timezone of the elements is. This is the legacy behavior which days of data already stored
+// follow.
+func TruncatedBoundary(t time.Time, d time.Duration) time.Time {
+	return t.Truncate(d)
+}
+
+// LocalMidnightBoundary starts batches at midnight in the timezone of the element so that batches match the calendar
+// days of the user. The buffer duration is ignored and a batch spans 23 or 25 hours on days of DST changes.
+func LocalMidnightBoundary(t time.Time, d time.Duration) time.Time {
+	return util.BeginningOfDay(t)
+}
+
+// MidnightBoundaryIn returns a BatchBoundary that starts batches at midnight in the named location, whatever the
+// timezone of the elements is, so that batches match the calendar days of a user who moved between timezones. An
+// invalid location name falls back to UTC, see util.LocationOrUTC.
+func MidnightBoundaryIn(locationName string) BatchBoundary {
+	return func(t time.Time, d time.Duration) time.Time {
+		return util.BeginningOfDayIn(t, locationName)
+	}
+}
+
+// OutOfOrderError is returned by a write when elements are older than the reorder window of the streamer allows. Those
+// elements are dropped but the other elements of the write are still written. The error describes the first of them.
+type OutOfOrderError struct {
+	Time   time.Time
+	Latest time.Time
+	Window time.Duration
+	Count  int
+}
+
+func (err *OutOfOrderError) Error() string {
+	return fmt.Sprintf("Element at [%s] is more than [%v] older than the most recent element at [%s] (%d element(s) dropped)",
+		err.Time.Format(time.RFC3339), err.Window, err.Latest.Format(time.RFC3339), err.Count)
+}
+
+// Stats is a snapshot of the activity of a streamer
+type Stats struct {
+	// Number of elements written to the streamer, not counting elements dropped for being out of order
+	Written int
+	// Number of batches written to the inner writer
+	Flushed int
+	// Number of elements written but not flushed yet and the time of the oldest and most recent of them. Times are
+	// zero when nothing is buffered.
+	Buffered       int
+	OldestBuffered time.Time
+	NewestBuffered time.Time
+	// Error of the last failed flush, even if a later flush recovered from it
+	LastFlushError error
+}
+
+func (stats Stats) String() string {
+	return fmt.Sprintf("written [%d], flushed [%d] batches, buffered [%d] from [%s] to [%s], last flush error [%v]",
+		stats.Written, stats.Flushed, stats.Buffered, stats.OldestBuffered.Format(time.RFC3339),
+		stats.NewestBuffered.Format(time.RFC3339), stats.LastFlushError)
+}
+
+// streamer is the core shared by all typed streamers. It buffers elements until one falls in a later batch, as decided
+// by its BatchBoundary, or until it holds maxCount of them and then writes the buffer as a batch. Streamers are immutable: every
+// write returns a new streamer. If writing a batch fails, the returned streamer keeps the un-flushed buffer along with
+// the elements that were still to be written and latches the error. Until the caller recovers by calling flush
+// successfully, every write fails with that same error without buffering anything.
+//
+// A streamer can't be used once closed: writes and flushes fail with ErrClosed and closing it again does nothing.
+// A close that failed can be retried though since the streamer only drops its buffer once the batch is written.
+//
+// Elements are expected in chronological order but, with a reorder window, elements within the window of the most
+// recent one are held back and sorted before being committed to the buffer. Elements older than that are rejected.
+type streamer struct {
+	head      *container.ImmutableList
+	startTime *time.Time
+	wr        batchWriter
+	d         time.Duration
+	boundary  BatchBoundary
+	maxCount  int
+
+	// Elements held back until they're older than the reorder window, sorted chronologically, and the time of the most
+	// recent element written
+	window time.Duration
+	held   []timedElement
+	latest *time.Time
+
+	// Set when a flush failed. pending holds the elements that were still to be committed when it happened.
+	err     error
+	pending []timedElement
+
+	closed bool
+
+	// Called with consecutive elements more than gapThreshold apart, if set, along with the last element buffered
+	gapThreshold time.Duration
+	onGap        func(previous, next timedElement)
+	last         timedElement
+
+	// Counters reported by stats
+	written      int
+	flushed      int
+	lastFlushErr error
+}
+
+func newStreamer(wr batchWriter, bufferDuration time.Duration, maxCount int) *streamer {
+	return &streamer{wr: wr, d: bufferDuration, boundary: TruncatedBoundary, maxCount: maxCount}
+}
+
+// withBuffer returns a copy of the streamer with the given buffer and writer
+func (b *streamer) withBuffer(head *container.ImmutableList, startTime *time.Time, wr batchWriter) *streamer {
+	s := *b
+	s.head, s.startTime, s.wr = head, startTime, wr
+	s.err, s.pending = nil, nil
+
+	return &s
+}
+
+// withReorderWindow returns a copy of the streamer that tolerates elements up to window older than the most recent one
+func (b *streamer) withReorderWindow(window time.Duration) *streamer {
+	s := b.withBuffer(b.head, b.startTime, b.wr)
+	s.window = window
+	s.err, s.pending = b.err, b.pending
+
+	return s
+}
+
+// withBatchBoundary returns a copy of the streamer that starts batches at the given boundary
+func (b *streamer) withBatchBoundary(boundary BatchBoundary) *streamer {
+	s := b.withBuffer(b.head, b.startTime, b.wr)
+	s.boundary = boundary
+	s.err, s.pending = b.err, b.pending
+
+	return s
+}
+
+// withGapDetection returns a copy of the streamer that calls onGap with every two consecutive elements more than
+// threshold apart
+func (b *streamer) withGapDetection(threshold time.Duration, onGap func(previous, next timedElement)) *streamer {
+	s := b.withBuffer(b.head, b.startTime, b.wr)
+	s.gapThreshold, s.onGap = threshold, onGap
+	s.err, s.pending = b.err, b.pending
+
+	return s
+}
+
+// write adds the elements of p to the buffer. Elements more recent than the reorder window are held back and the
+// others are committed to the buffer in chronological order. Elements older than the window are dropped and the first
+// of them is returned as an OutOfOrderError once all other elements are written.
+func (b *streamer) write(p []timedElement) (s *streamer, err error) {
+	if b.closed {
+		return b, ErrClosed
+	}
+
+	if b.err != nil {
+		return b, b.err
+	}
+
+	var outOfOrderErr *OutOfOrderError
+	var ready []timedElement
+	s = b.withBuffer(b.head, b.startTime, b.wr)
+	held := append([]timedElement(nil), b.held...)
+	for _, e := range p {
+		t := e.GetTime()
+		if s.latest != nil && s.latest.Sub(t) > s.window {
+			if outOfOrderErr == nil {
+				outOfOrderErr = &OutOfOrderError{t, *s.latest, s.window, 0}
+			}
+			outOfOrderErr.Count++
+			continue
+		}
+
+		if s.latest == nil || t.After(*s.latest) {
+			s.latest = &t
+		}
+
+		// Insert after any element of the same time so that elements keep the order they were written in
+		i := len(held)
+		for i > 0 && held[i-1].GetTime().After(t) {
+			i--
+		}
+		held = append(held, nil)
+		copy(held[i+1:], held[i:])
+		held[i] = e
+
+		// Release the elements that no element within the window can precede anymore
+		released := len(held)
+		if s.window > 0 {
+			released = 0
+			for released < len(held) && s.latest.Sub(held[released].GetTime()) > s.window {
+				released++
+			}
+		}
+
+		ready = append(ready, held[:released]...)
+		held = append(held[:0:0], held[released:]...)
+	}
+
+	s.held = held
+	s.written += len(p)
+	if outOfOrderErr != nil {
+		s.written -= outOfOrderErr.Count
+	}
+
+	if s, err = s.commit(ready); err != nil {
+		return s, err
+	}
+
+	if outOfOrderErr != nil {
+		return s, outOfOrderErr
+	}
+
+	return s, nil
+}
+
+// commit adds elements, sorted chronologically, to the buffer and writes the buffer as a batch every time an element
+// belongs to a later batch or the buffer is full. Gaps are reported once the element after them is buffered so that
+// retrying a failed flush doesn't report them twice.
+func (b *streamer) commit(p []timedElement) (s *streamer, err error) {
+	s = b
+	for i, e := range p {
+		t := e.GetTime()
+		batchStart := s.boundary(t, s.d)
+		previous := s.last
+
+		if s.head == nil {
+			s = s.withBuffer(container.NewImmutableList(nil, e), &batchStart, s.wr)
+		} else if batchStart.After(*s.startTime) || s.isFull() {
+			flushed, err := s.flushBuffer()
+			if err != nil {
+				return s.failed(p[i:], err), err
+			}
+			s = flushed.withBuffer(container.NewImmutableList(nil, e), &batchStart, flushed.wr)
+		} else {
+			s = s.withBuffer(container.NewImmutableList(s.head, e), s.startTime, s.wr)
+		}
+
+		if s.onGap != nil && previous != nil && t.Sub(previous.GetTime()) > s.gapThreshold {
+			s.onGap(previous, e)
+		}
+		s.last = e
+	}
+
+	return s, nil
+}
+
+// flush commits the elements held back by the reorder window and writes the buffer as a batch. If a previous flush
+// failed, this retries it first.
+func (b *streamer) flush() (s *streamer, err error) {
+	if b.closed {
+		return b, ErrClosed
+	}
+
+	s = b
+	if s.err != nil {
+		if s, err = s.flushBuffer(); err != nil {
+			return s, err
+		}
+	}
+
+	if len(s.held) > 0 {
+		held := s.held
+		s = s.withBuffer(s.head, s.startTime, s.wr)
+		s.held = nil
+		if s, err = s.commit(held); err != nil {
+			return s, err
+		}
+	}
+
+	return s.flushBuffer()
+}
+
+// flushBuffer writes the buffer as a batch. If a previous flush failed, this retries it and then commits the elements
+// that were pending.
+func (b *streamer) flushBuffer() (s *streamer, err error) {
+	s = b.withBuffer(nil, nil, b.wr)
+	if b.head != nil {
+		innerWriter, err := b.wr.writeBatch(b.head)
+		if err != nil {
+			return b.failed(b.pending, err), err
+		}
+		s = b.withBuffer(nil, nil, innerWriter)
+		s.flushed++
+	}
+
+	if len(b.pending) > 0 {
+		if s, err = s.commit(b.pending); err != nil {
+			return s, err
+		}
+
+		return s.flushBuffer()
+	}
+
+	return s, nil
+}
+
+// close flushes the buffer and the inner writer to effectively ensure nothing is left unwritten. The buffer is cleared
+// before the inner writer is flushed so that retrying a close after the inner writer failed doesn't write the batch
+// again. Closing a closed streamer does nothing.
+func (b *streamer) close() (s *streamer, err error) {
+	if b.closed {
+		return b, nil
+	}
+
+	if s, err = b.flush(); err != nil {
+		return s, err
+	}
+
+	innerWriter, err := s.wr.flush()
+	if err != nil {
+		return s, err
+	}
+
+	s = s.withBuffer(nil, nil, innerWriter)
+	s.closed = true
+	return s, nil
+}
+
+// isFull returns true if the buffer holds as many elements as it can before it must be flushed
+func (b *streamer) isFull() bool {
+	return b.maxCount > 0 && b.head.Len() >= b.maxCount
+}
+
+// failed returns a copy of the streamer with its buffer intact that latches the error of a flush
+func (b *streamer) failed(pending []timedElement, err error) *streamer {
+	s := b.withBuffer(b.head, b.startTime, b.wr)
+	s.pending = pending
+	s.err = err
+	s.lastFlushErr = err
+
+	return s
+}
+
+// buffered returns the number of elements written to the streamer that haven't been flushed yet
+func (b *streamer) buffered() int {
+	return b.head.Len() + len(b.held) + len(b.pending)
+}
+
+// stats returns a snapshot of the activity of the streamer
+func (b *streamer) stats() Stats {
+	stats := Stats{Written: b.written, Flushed: b.flushed, Buffered: b.buffered(), LastFlushError: b.lastFlushErr}
+
+	track := func(e timedElement) {
+		t := e.GetTime()
+		if stats.OldestBuffered.IsZero() || t.Before(stats.OldestBuffered) {
+			stats.OldestBuffered = t
+		}
+		if stats.NewestBuffered.IsZero() || t.After(stats.NewestBuffered) {
+			stats.NewestBuffered = t
+		}
+	}
+
+	for cursor := b.head; cursor != nil; cursor = cursor.Next() {
+		track(cursor.Value().(timedElement))
+	}
+	for _, e := range b.held {
+		track(e)
+	}
+	for _, e := range b.pending {
+		track(e)
+	}
+
+	return stats
+}
@@ -0,0 +1,143 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Values sealed by a KeyRing start with SEALED_VALUE_PREFIX followed by the id of the key the data key was
+	// encrypted with, the encrypted data key and the ciphertext, in base64, all separated by SEALED_VALUE_SEPARATOR
+	SEALED_VALUE_PREFIX    = "sealed1"
+	SEALED_VALUE_SEPARATOR = ":"
+
+	// Values are encrypted with their own random data key of DATA_KEY_BYTES
+	DATA_KEY_BYTES = 32
+)
+
+// KeyRing seals values with envelope encryption: each value is encrypted with its own random data key, itself encrypted
+// with the current key of the ring. The id of that key is kept in the sealed value so that keys can be rotated, values
+// sealed with any of the Keys of the ring can still be opened.
+type KeyRing struct {
+	CurrentKeyId string
+	Keys         map[string]string
+}
+
+// Encrypt encrypts the plaintext with AES-256-GCM keyed on the SHA-256 of the secret. The random nonce is prepended to
+// the ciphertext so that it can be decrypted with the secret alone, see Decrypt.
+func Encrypt(secret string, plaintext []byte) (ciphertext []byte, err error) {
+	aead, err := newSecretCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt decrypts a ciphertext of Encrypt with the same secret. It fails if the ciphertext was encrypted with another
+// secret or was tampered with.
+func Decrypt(secret string, ciphertext []byte) (plaintext []byte, err error) {
+	aead, err := newSecretCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("Ciphertext is too short to have been encrypted")
+	}
+
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+}
+
+func newSecretCipher(secret string) (aead cipher.AEAD, err error) {
+	if len(secret) == 0 {
+		return nil, errors.New("Can't encrypt without a secret")
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts the value with a new data key encrypted with the current key of the ring
+func (keyRing KeyRing) Seal(value string) (sealed string, err error) {
+	keyId := keyRing.CurrentKeyId
+	secret, ok := keyRing.Keys[keyId]
+	if !ok || len(keyId) == 0 || strings.Contains(keyId, SEALED_VALUE_SEPARATOR) {
+		return "", errors.New(fmt.Sprintf("Invalid current key id [%s] of key ring", keyId))
+	}
+
+	dataKey := make([]byte, DATA_KEY_BYTES)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	encryptedDataKey, err := Encrypt(secret, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := Encrypt(string(dataKey), []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{SEALED_VALUE_PREFIX, keyId, base64.StdEncoding.EncodeToString(encryptedDataKey),
+		base64.StdEncoding.EncodeToString(ciphertext)}, SEALED_VALUE_SEPARATOR), nil
+}
+
+// Open decrypts a value sealed with any key of the ring. It fails if the key isn't in the ring anymore or if the value
+// was tampered with.
+func (keyRing KeyRing) Open(sealed string) (value string, err error) {
+	parts := strings.Split(sealed, SEALED_VALUE_SEPARATOR)
+	if len(parts) != 4 || parts[0] != SEALED_VALUE_PREFIX {
+		return "", errors.New("Value wasn't sealed by a key ring")
+	}
+
+	secret, ok := keyRing.Keys[parts[1]]
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Value was sealed with key [%s] which isn't in the key ring", parts[1]))
+	}
+
+	encryptedDataKey, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := Decrypt(secret, encryptedDataKey)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := Decrypt(string(dataKey), ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// IsSealed returns true if the value looks like one sealed by a KeyRing, as opposed to a value stored in plaintext
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, SEALED_VALUE_PREFIX+SEALED_VALUE_SEPARATOR)
+}
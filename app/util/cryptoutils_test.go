@@ -0,0 +1,88 @@
+package util_test
+
+import (
+	. "github.com/alexandre-normand/glukit/app/util"
+	"testing"
+)
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	ciphertext, err := Encrypt("secret", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plaintext, err := Decrypt("secret", ciphertext); err != nil || string(plaintext) != "password" {
+		t.Errorf("Expected to decrypt [password] but got [%s]: %v", string(plaintext), err)
+	}
+
+	if other, _ := Encrypt("secret", []byte("password")); string(other) == string(ciphertext) {
+		t.Errorf("Expected different ciphertexts of the same plaintext")
+	}
+}
+
+func TestDecryptWithOtherSecretFails(t *testing.T) {
+	ciphertext, err := Encrypt("secret", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decrypt("other", ciphertext); err == nil {
+		t.Errorf("Expected an error decrypting with another secret")
+	}
+
+	if _, err := Decrypt("secret", ciphertext[:4]); err == nil {
+		t.Errorf("Expected an error decrypting a truncated ciphertext")
+	}
+}
+
+func TestKeyRingSealOpenRoundTrip(t *testing.T) {
+	keyRing := KeyRing{"1", map[string]string{"1": "secret"}}
+	sealed, err := keyRing.Seal("1/refresh-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsSealed(sealed) {
+		t.Errorf("Expected [%s] to be sealed", sealed)
+	}
+
+	if value, err := keyRing.Open(sealed); err != nil || value != "1/refresh-token" {
+		t.Errorf("Expected to open [1/refresh-token] but got [%s]: %v", value, err)
+	}
+
+	if IsSealed("1/refresh-token") {
+		t.Errorf("Expected a plaintext token not to be sealed")
+	}
+}
+
+func TestKeyRingOpensValuesSealedWithPreviousKeys(t *testing.T) {
+	sealed, err := KeyRing{"1", map[string]string{"1": "secret"}}.Seal("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := KeyRing{"2", map[string]string{"1": "secret", "2": "new secret"}}
+	if value, err := rotated.Open(sealed); err != nil || value != "token" {
+		t.Errorf("Expected to open [token] with the previous key but got [%s]: %v", value, err)
+	}
+
+	if _, err := (KeyRing{"2", map[string]string{"2": "new secret"}}).Open(sealed); err == nil {
+		t.Errorf("Expected an error opening a value sealed with a key that was removed from the ring")
+	}
+}
+
+func TestKeyRingOpenTamperedValueFails(t *testing.T) {
+	keyRing := KeyRing{"1", map[string]string{"1": "secret"}}
+	sealed, err := keyRing.Seal("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keyRing.Open(sealed[:len(sealed)-4] + "AAA="); err == nil {
+		t.Errorf("Expected an error opening a tampered value")
+	}
+
+	if _, err := (KeyRing{"2", map[string]string{}}).Seal("token"); err == nil {
+		t.Errorf("Expected an error sealing without the current key")
+	}
+}
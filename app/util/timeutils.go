@@ -6,6 +6,7 @@ import (
 	"log"
 	"math"
 	"regexp"
+	"sync"
 	"time"
 )
 
@@ -26,7 +27,7 @@ const (
 	HOUR_OF_END_OF_DAY = 18
 )
 
-var zoneNameRegexp = regexp.MustCompile("[+-](\\d){4}")
+var zoneNameRegexp = regexp.MustCompile("^[+-](\\d){4}$")
 
 // Beginning of time should be unix epoch 0 but, to optimize some processing
 // may iterate overtime starting at this value, we just define the notion
@@ -35,7 +36,13 @@ var zoneNameRegexp = regexp.MustCompile("[+-](\\d){4}")
 // This maps to 01 Jan 2004 00:00:00 GMT.
 var GLUKIT_EPOCH_TIME = time.Unix(1072915200, 0)
 
-var locationCache = make(map[string]*time.Location)
+// Locations loaded by GetOrLoadLocationForName, by name, and names LocationOrUTC fell back to UTC for. Loading a location
+// reads the zoneinfo database which is slow on App Engine.
+var (
+	locationCache        = make(map[string]*time.Location)
+	invalidLocationNames = make(map[string]bool)
+	locationCacheMutex   sync.RWMutex
+)
 
 // ParseGoogleDriveDate parses a Google Drive API time value
 func ParseGoogleDriveDate(value string) (timeValue time.Time, err error) {
@@ -65,15 +72,31 @@ func GetTimeUTC(timeValue string) (time.Time, error) {
 // July 17th 06h00. If the time is July 17th 05h00 PST, the boundary returned is July 16th 06h00.
 // Very important: The timeValue's location must be accurate!
 func GetEndOfDayBoundaryBefore(timeValue time.Time) (latestEndOfDayBoundary time.Time) {
+	year, month, day := timeValue.Date()
 	if timeValue.Hour() < HOUR_OF_END_OF_DAY {
-		// Rewind by one more day
-		previousDay := timeValue.Add(time.Duration(-24 * time.Hour))
-		latestEndOfDayBoundary = time.Date(previousDay.Year(), previousDay.Month(), previousDay.Day(), HOUR_OF_END_OF_DAY, 0, 0, 0, timeValue.Location())
-	} else {
-		latestEndOfDayBoundary = time.Date(timeValue.Year(), timeValue.Month(), timeValue.Day(), HOUR_OF_END_OF_DAY, 0, 0, 0, timeValue.Location())
+		// Rewind by one more calendar day, days of DST changes not being 24 hours long
+		day--
 	}
 
-	return latestEndOfDayBoundary
+	return time.Date(year, month, day, HOUR_OF_END_OF_DAY, 0, 0, 0, timeValue.Location())
+}
+
+// EndOfDayBoundaryBeforeIn returns the boundary of the very last "end of day" before the given time in the named
+// location, see GetEndOfDayBoundaryBefore and LocationOrUTC.
+func EndOfDayBoundaryBeforeIn(timeValue time.Time, locationName string) time.Time {
+	return GetEndOfDayBoundaryBefore(timeValue.In(LocationOrUTC(locationName)))
+}
+
+// BeginningOfDay returns midnight of the calendar day of the given time in its location. On days of DST changes, the
+// day that starts there is 23 or 25 hours long.
+func BeginningOfDay(timeValue time.Time) time.Time {
+	year, month, day := timeValue.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, timeValue.Location())
+}
+
+// BeginningOfDayIn returns midnight of the calendar day of the given time in the named location, see LocationOrUTC
+func BeginningOfDayIn(timeValue time.Time, locationName string) time.Time {
+	return BeginningOfDay(timeValue.In(LocationOrUTC(locationName)))
 }
 
 // GetMidnightUTCBefore returns the boundary of very last occurence of midnight before the given time.
@@ -95,13 +118,15 @@ func GetTimeWithImpliedLocation(localTime string, location *time.Location) (time
 	return time.ParseInLocation(TIMEFORMAT_NO_TZ, localTime, location)
 }
 
-// GetLocaltimeOffset returns the Fixed location extrapolated by calculating the offset
-// of the localtime and the internal time in UTC
-func GetLocaltimeOffset(localTime string, internalTime time.Time) (location *time.Location) {
+// ParseLocaltimeOffset returns the Fixed location extrapolated by calculating the offset of the local time and the
+// internal time in UTC. The offset is rounded to the nearest 15 minutes since the clock of a device drifts from the
+// internal time by a few seconds or minutes. The location is named after its offset (i.e. "-0800") so that it can be
+// loaded back with GetOrLoadLocationForName.
+func ParseLocaltimeOffset(localTime string, internalTime time.Time) (location *time.Location, err error) {
 	// Get the local time as if it was UTC (it's not)
 	localTimeUTC, err := time.Parse(TIMEFORMAT_NO_TZ, localTime)
 	if err != nil {
-		Propagate(err)
+		return nil, err
 	}
 
 	// Get the difference between the internal time (actual UTC) and the local time
@@ -117,37 +142,80 @@ func GetLocaltimeOffset(localTime string, internalTime time.Time) (location *tim
 	}
 
 	minutesOffsetPortion := float64((int64(truncatedDuration) - int64(truncatedDuration.Hours())*int64(time.Hour)) / int64(time.Minute))
-	locationName := fmt.Sprintf("%+03d%02d", int(truncatedDuration.Hours()), int64(math.Abs(minutesOffsetPortion)))
-	return time.FixedZone(locationName, int(durationOffset.Seconds()))
+	sign := "+"
+	if truncatedDuration < 0 {
+		sign = "-"
+	}
+	locationName := fmt.Sprintf("%s%02d%02d", sign, int64(math.Abs(float64(int64(truncatedDuration.Hours())))), int64(math.Abs(minutesOffsetPortion)))
+	return time.FixedZone(locationName, int(truncatedDuration.Seconds())), nil
 }
 
 // GetLocalTimeInProperLocation returns the parsed local time with the location appropriately set as extrapolated
 // by calculating the difference of the internal time vs the local time
-func GetLocalTimeInProperLocation(localTime string, internalTime time.Time) (localTimeWithLocation time.Time) {
-	location := GetLocaltimeOffset(localTime, internalTime)
-	localTimeWithLocation, _ = time.ParseInLocation(TIMEFORMAT_NO_TZ, localTime, location)
-	return
+func GetLocalTimeInProperLocation(localTime string, internalTime time.Time) (localTimeWithLocation time.Time, err error) {
+	location, err := ParseLocaltimeOffset(localTime, internalTime)
+	if err != nil {
+		return localTimeWithLocation, err
+	}
+
+	return time.ParseInLocation(TIMEFORMAT_NO_TZ, localTime, location)
+}
+
+// fixedZoneOffset returns the offset in seconds of a zone named after its offset (i.e. "-0730")
+func fixedZoneOffset(locationName string) int {
+	var hours, minutes int
+	fmt.Sscanf(locationName[1:], "%02d%02d", &hours, &minutes)
+
+	offset := hours*60*60 + minutes*60
+	if locationName[0] == '-' {
+		return -offset
+	}
+
+	return offset
 }
 
+// GetOrLoadLocationForName returns the location of the given name, either a timezone location (i.e. America/Los_Angeles)
+// or a fixed zone named after its offset (i.e. "-0800"). Locations are cached once loaded.
 func GetOrLoadLocationForName(locationName string) (location *time.Location, err error) {
-	if location, ok := locationCache[locationName]; !ok {
-		location, err = time.LoadLocation(locationName)
-		if err != nil {
-			if !zoneNameRegexp.MatchString(locationName) {
-				return nil, errors.New(fmt.Sprintf("Invalid location name, not a valid timezone location [%s]", locationName))
-			} else {
-				var hours, minutes int64
-				fmt.Sscanf(locationName, "%+03d%02d", &hours, &minutes)
-				offsetInMinutes := hours*int64(time.Duration(60)*time.Minute) + minutes
-				offsetInSeconds := offsetInMinutes + minutes*int64(time.Duration(60)*time.Second)
-				location = time.FixedZone(locationName, int(offsetInSeconds))
-				locationCache[locationName] = location
-			}
+	locationCacheMutex.RLock()
+	location, ok := locationCache[locationName]
+	locationCacheMutex.RUnlock()
+	if ok {
+		return location, nil
+	}
+
+	location, err = time.LoadLocation(locationName)
+	if err != nil {
+		if !zoneNameRegexp.MatchString(locationName) {
+			return nil, errors.New(fmt.Sprintf("Invalid location name, not a valid timezone location [%s]", locationName))
 		}
+		location = time.FixedZone(locationName, fixedZoneOffset(locationName))
+	}
 
-		locationCache[locationName] = location
-		return location, nil
-	} else {
-		return location, nil
+	locationCacheMutex.Lock()
+	locationCache[locationName] = location
+	locationCacheMutex.Unlock()
+	return location, nil
+}
+
+// LocationOrUTC returns the location of the given name, see GetOrLoadLocationForName, or UTC if the name is invalid.
+// An empty name is UTC. Invalid names are cached so that they're neither loaded nor logged again.
+func LocationOrUTC(locationName string) *time.Location {
+	locationCacheMutex.RLock()
+	invalid := invalidLocationNames[locationName]
+	locationCacheMutex.RUnlock()
+	if invalid {
+		return time.UTC
 	}
+
+	location, err := GetOrLoadLocationForName(locationName)
+	if err != nil {
+		log.Printf("Warning: falling back to UTC for location [%s]: %v", locationName, err)
+		locationCacheMutex.Lock()
+		invalidLocationNames[locationName] = true
+		locationCacheMutex.Unlock()
+		return time.UTC
+	}
+
+	return location
 }
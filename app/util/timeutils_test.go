@@ -9,7 +9,10 @@ import (
 func TestGetTimezone(t *testing.T) {
 	internalTime, _ := time.Parse(TIMEFORMAT_NO_TZ, "2014-04-18 00:00:00")
 
-	location := GetLocaltimeOffset("2014-04-18 01:28:00", internalTime)
+	location, err := ParseLocaltimeOffset("2014-04-18 01:28:00", internalTime)
+	if err != nil {
+		t.Fatal(err)
+	}
 	t.Logf("Location is [%v]", location)
 
 	if location.String() != "+0130" {
@@ -24,7 +27,10 @@ func TestGetTimezone(t *testing.T) {
 func TestGetTimezoneNegativeOffset(t *testing.T) {
 	internalTime, _ := time.Parse(TIMEFORMAT_NO_TZ, "2014-04-18 09:00:00")
 
-	location := GetLocaltimeOffset("2014-04-18 02:02:00", internalTime)
+	location, err := ParseLocaltimeOffset("2014-04-18 02:02:00", internalTime)
+	if err != nil {
+		t.Fatal(err)
+	}
 	t.Logf("Location is [%v]", location)
 
 	if location.String() != "-0700" {
@@ -36,6 +42,12 @@ func TestGetTimezoneNegativeOffset(t *testing.T) {
 	}
 }
 
+func TestGetLocalTimeInProperLocationOfBadLocalTime(t *testing.T) {
+	if _, err := GetLocalTimeInProperLocation("18/04/2014 01:28", time.Now()); err == nil {
+		t.Errorf("Expected an error for a local time not formatted as [%s]", TIMEFORMAT_NO_TZ)
+	}
+}
+
 func TestKnownLocationLoading(t *testing.T) {
 	location := "America/Montreal"
 	if _, err := GetOrLoadLocationForName(location); err != nil {
@@ -60,3 +72,80 @@ func TestGetTimeWithImpliedLocation(t *testing.T) {
 		t.Errorf("Expected timestamp [%d] but got [%d]", expected, timeValue.Unix())
 	}
 }
+
+func TestFixedLocationLoadingHasOffsetOfName(t *testing.T) {
+	for locationName, expectedOffset := range map[string]int{"-0800": -8 * 60 * 60, "+0530": 5*60*60 + 30*60, "-0330": -(3*60*60 + 30*60)} {
+		location, err := GetOrLoadLocationForName(locationName)
+		if err != nil {
+			t.Fatalf("Should be a valid location [%s] but got error: [%v]", locationName, err)
+		}
+
+		if _, offset := time.Date(2014, 4, 18, 0, 0, 0, 0, location).Zone(); offset != expectedOffset {
+			t.Errorf("Expected offset [%d] for location [%s] but got [%d]", expectedOffset, locationName, offset)
+		}
+	}
+}
+
+func TestParseLocaltimeOffsetWithClockDrift(t *testing.T) {
+	internalTime, _ := time.Parse(TIMEFORMAT_NO_TZ, "2014-04-18 09:00:00")
+
+	location, err := ParseLocaltimeOffset("2014-04-18 01:02:10", internalTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, offset := time.Date(2014, 4, 18, 0, 0, 0, 0, location).Zone(); location.String() != "-0800" || offset != -8*60*60 {
+		t.Errorf("Expected location [-0800] with an offset of [%d] but got [%s] with [%d]", -8*60*60, location, offset)
+	}
+
+	if _, err = ParseLocaltimeOffset("not a time", internalTime); err == nil {
+		t.Errorf("Expected an error for an invalid local time")
+	}
+}
+
+func TestEndOfDayBoundaryBeforeInAcrossDSTChanges(t *testing.T) {
+	location, _ := time.LoadLocation("America/Los_Angeles")
+
+	// Spring forward on March 8th 2015, the day is 23 hours long
+	springForward := time.Date(2015, time.March, 9, 0, 30, 0, 0, location)
+	if boundary, expected := EndOfDayBoundaryBeforeIn(springForward.UTC(), "America/Los_Angeles"), time.Date(2015, time.March, 8, 18, 0, 0, 0, location); !boundary.Equal(expected) {
+		t.Errorf("Expected boundary [%s] but got [%s]", expected, boundary)
+	}
+
+	// Fall back on November 1st 2015, the day is 25 hours long
+	fallBack := time.Date(2015, time.November, 2, 5, 0, 0, 0, location)
+	if boundary, expected := EndOfDayBoundaryBeforeIn(fallBack.UTC(), "America/Los_Angeles"), time.Date(2015, time.November, 1, 18, 0, 0, 0, location); !boundary.Equal(expected) {
+		t.Errorf("Expected boundary [%s] but got [%s]", expected, boundary)
+	}
+}
+
+func TestBeginningOfDayInAcrossDSTChanges(t *testing.T) {
+	location, _ := time.LoadLocation("America/Los_Angeles")
+
+	for _, day := range []time.Time{time.Date(2015, time.March, 8, 0, 0, 0, 0, location), time.Date(2015, time.November, 1, 0, 0, 0, 0, location)} {
+		// Just before midnight of the next day, 23 or 25 hours after the day started
+		lateInTheDay := day.AddDate(0, 0, 1).Add(-time.Minute)
+		if beginning := BeginningOfDayIn(lateInTheDay.UTC(), "America/Los_Angeles"); !beginning.Equal(day) {
+			t.Errorf("Expected day of [%s] to begin at [%s] but got [%s]", lateInTheDay, day, beginning)
+		}
+	}
+}
+
+func TestDayBoundariesInInvalidLocationFallBackToUTC(t *testing.T) {
+	value := time.Date(2015, time.March, 8, 3, 0, 0, 0, time.UTC)
+	if beginning, expected := BeginningOfDayIn(value, "Not/A_Location"), time.Date(2015, time.March, 8, 0, 0, 0, 0, time.UTC); !beginning.Equal(expected) {
+		t.Errorf("Expected day to begin at [%s] in UTC but got [%s]", expected, beginning)
+	}
+}
+
+func TestInvalidLocationIsUTCEveryTime(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		if location := LocationOrUTC("Not/A_Location"); location != time.UTC {
+			t.Errorf("Expected invalid location to be UTC but got [%s]", location)
+		}
+	}
+
+	if _, err := GetOrLoadLocationForName("Not/A_Location"); err == nil {
+		t.Errorf("Expected loading invalid location to still fail once it fell back to UTC")
+	}
+}
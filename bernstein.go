@@ -40,22 +40,30 @@ func initializeGlukitBernstein(writer http.ResponseWriter, reader *http.Request)
 		log.Infof(context, "No data found for glukit bernstein user [%s], creating it", GLUKIT_BERNSTEIN_EMAIL)
 		dummyToken := oauth.Token{"", "", util.GLUKIT_EPOCH_TIME}
 		userProfileKey, err := store.StoreUserProfile(context, time.Now(),
-			model.GlukitUser{GLUKIT_BERNSTEIN_EMAIL, "Glukit", "Bernstein", BERNSTEIN_BIRTH_DATE, model.DIABETES_TYPE_1, "America/New_York", time.Now(),
-				BERNSTEIN_MOST_RECENT_READ, dummyToken, "", model.UNDEFINED_SCORE, model.UNDEFINED_SCORE, true, "", time.Now(), model.UNDEFINED_A1C_ESTIMATE})
+			model.GlukitUser{Email: GLUKIT_BERNSTEIN_EMAIL, FirstName: "Glukit", LastName: "Bernstein",
+				DateOfBirth: BERNSTEIN_BIRTH_DATE, DiabetesType: model.DIABETES_TYPE_1, Timezone: "America/New_York",
+				LastUpdated: time.Now(), MostRecentRead: BERNSTEIN_MOST_RECENT_READ, Token: dummyToken,
+				BestScore: model.UNDEFINED_SCORE, MostRecentScore: model.UNDEFINED_SCORE, Internal: true,
+				AccountCreated: time.Now(), MostRecentA1C: model.UNDEFINED_A1C_ESTIMATE,
+				TargetLow: apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD, TargetHigh: apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD,
+				GlucoseUnit: apimodel.MG_PER_DL, TherapyMode: model.THERAPY_MODE_UNKNOWN})
 		if err != nil {
-			util.Propagate(err)
+			writeError(context, writer, err)
+			return
 		}
 
 		fileReader := generateBernsteinData(context)
-		lastReadTime, err := importer.ParseContent(context, fileReader, userProfileKey, util.GLUKIT_EPOCH_TIME,
-			store.StoreDaysOfReads, store.StoreDaysOfMeals, store.StoreDaysOfInjections, store.StoreDaysOfExercises)
+		lastReadTime, report, err := importer.ParseContent(context, fileReader, userProfileKey, util.GLUKIT_EPOCH_TIME,
+			store.StoreDaysOfReads, store.StoreDaysOfCalibrations, store.StoreDaysOfMeals, store.StoreDaysOfInjections, store.StoreDaysOfExercises, nil)
 
 		if err != nil {
-			util.Propagate(err)
+			writeError(context, writer, err)
+			return
 		}
 
 		store.LogFileImport(context, userProfileKey, model.FileImportLog{Id: "bernstein", Md5Checksum: "dummychecksum",
-			LastDataProcessed: lastReadTime, ImportResult: "Success"})
+			LastDataProcessed: lastReadTime, ImportResult: report.String(), ImportedAt: time.Now(), RecordCount: report.RecordCount(),
+			Succeeded: true})
 
 		if glukitUser, err := store.GetUserProfile(context, userProfileKey); err != nil {
 			log.Warningf(context, "Error getting retrieving GlukitUser [%s], this needs attention: [%v]", GLUKIT_BERNSTEIN_EMAIL, err)
@@ -73,7 +81,8 @@ func initializeGlukitBernstein(writer http.ResponseWriter, reader *http.Request)
 			}
 		}
 	} else if err != nil {
-		util.Propagate(err)
+		writeError(context, writer, err)
+		return
 	} else {
 		log.Infof(context, "Data already stored for user [%s], continuing...", GLUKIT_BERNSTEIN_EMAIL)
 	}
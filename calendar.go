@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/export"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Query parameters of the calendar feed: the signed token of the user, since calendar clients can't log in, and
+	// the kinds of events, all of them by default
+	QUERY_PARAM_CALENDAR_TOKEN = "token"
+	QUERY_PARAM_CALENDAR_KINDS = "kinds"
+
+	// Number of days of events in a calendar feed, ending today in the user's timezone
+	CALENDAR_FEED_DAYS = 90
+
+	CALENDAR_FEED_NAME = "Glukit"
+)
+
+// calendarFeedToken returns the token of the calendar feed of the user: the email, in unpadded url-safe base64, and its
+// HMAC-SHA256 keyed on the user's calendar feed secret, in hex, separated by a dot
+func calendarFeedToken(secret string, userEmail string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(userEmail)) + "." + signCalendarFeed(secret, userEmail)
+}
+
+// signCalendarFeed returns the HMAC-SHA256, in hex, of the email keyed on the user's calendar feed secret
+func signCalendarFeed(secret string, userEmail string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(userEmail))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseCalendarFeedToken returns the email and signature of a calendar feed token, ok being false if the token isn't
+// formatted as one
+func parseCalendarFeedToken(token string) (userEmail string, signature string, ok bool) {
+	separator := strings.LastIndex(token, ".")
+	if separator < 0 {
+		return "", "", false
+	}
+
+	email, err := base64.RawURLEncoding.DecodeString(token[:separator])
+	if err != nil || len(email) == 0 {
+		return "", "", false
+	}
+
+	return string(email), token[separator+1:], true
+}
+
+// isValidCalendarFeedSignature returns true if the signature is the one of the calendar feed of the user. Users who
+// never got a feed link have no secret and no valid signature.
+func isValidCalendarFeedSignature(secret string, userEmail string, signature string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(signCalendarFeed(secret, userEmail)))
+}
+
+// calendarFeedUrl returns the url of the calendar feed of the user, empty if the user never got a feed link
+func calendarFeedUrl(request *http.Request, glukitUser *model.GlukitUser) string {
+	if len(glukitUser.CalendarFeedSecret) == 0 {
+		return ""
+	}
+
+	query := url.Values{}
+	query.Set(QUERY_PARAM_CALENDAR_TOKEN, calendarFeedToken(glukitUser.CalendarFeedSecret, glukitUser.Email))
+
+	return fmt.Sprintf("https://%s/export/calendar.ics?%s", request.Host, query.Encode())
+}
+
+// calendarEventUserId returns the id that tells apart the uids of events of the user without revealing the email
+func calendarEventUserId(userEmail string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(userEmail)))
+}
+
+// resetCalendarFeed is the endpoint to get a new calendar feed link for the logged in user. Links given out before stop
+// working.
+func resetCalendarFeed(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if err := glukitUser.ResetCalendarFeedSecret(); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Reset calendar feed of user [%s]", user.Email)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// exportCalendar is the endpoint of the calendar feed of the user of the signed token. It returns the meals, exercises
+// and hypo events of the last CALENDAR_FEED_DAYS days, or the ones of the kinds parameter, as an iCalendar file. Invalid
+// tokens are rejected the same way as tokens of unknown users.
+func exportCalendar(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+
+	userEmail, signature, ok := parseCalendarFeedToken(request.FormValue(QUERY_PARAM_CALENDAR_TOKEN))
+	if !ok {
+		http.Error(writer, "Invalid calendar feed link.", http.StatusForbidden)
+		return
+	}
+
+	_, glukitUser, err := store.GetGlukitUser(context, userEmail)
+	if err == datastore.ErrNoSuchEntity || (err == nil && !isValidCalendarFeedSignature(glukitUser.CalendarFeedSecret, userEmail, signature)) {
+		http.Error(writer, "Invalid calendar feed link.", http.StatusForbidden)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	kinds, err := export.ParseCalendarKinds(request.FormValue(QUERY_PARAM_CALENDAR_KINDS))
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_CALENDAR_KINDS, err), 400)
+		return
+	}
+
+	now := time.Now()
+	location := engine.UserLocation(glukitUser)
+	localNow := now.In(location)
+	today := time.Date(localNow.Year(), localNow.Month(), localNow.Day(), 0, 0, 0, 0, location)
+	lowerBound, upperBound := today.AddDate(0, 0, 1-CALENDAR_FEED_DAYS), today.AddDate(0, 0, 1).Add(-time.Nanosecond)
+
+	events, err := calendarEvents(context, glukitUser, kinds, lowerBound, upperBound, location)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	// The calendar is written to a buffer first so that a failure doesn't leave clients with a truncated feed
+	var buffer bytes.Buffer
+	if err := export.WriteCalendar(&buffer, CALENDAR_FEED_NAME, events, now); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "text/calendar; charset=utf-8")
+	value.Add("Content-Disposition", "inline; filename=\"calendar.ics\"")
+	buffer.WriteTo(writer)
+}
+
+// calendarEvents returns the events of the kinds of the user between lowerBound and upperBound
+func calendarEvents(context context.Context, glukitUser *model.GlukitUser, kinds []export.CalendarKind, lowerBound time.Time,
+	upperBound time.Time, location *time.Location) (events []export.CalendarEvent, err error) {
+	userId := calendarEventUserId(glukitUser.Email)
+	events = make([]export.CalendarEvent, 0)
+	for _, kind := range kinds {
+		switch kind {
+		case export.CALENDAR_KIND_MEALS:
+			meals, err := store.GetMeals(context, glukitUser.Email, lowerBound, upperBound)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, export.MealEvents(userId, meals)...)
+		case export.CALENDAR_KIND_EXERCISES:
+			exercises, err := store.GetExercises(context, glukitUser.Email, lowerBound, upperBound)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, export.ExerciseEvents(userId, exercises)...)
+		case export.CALENDAR_KIND_HYPOS:
+			hypos, err := store.GetHypoEvents(context, glukitUser.Email, lowerBound, upperBound)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, export.HypoEvents(userId, hypos, location, glukitUser.GetGlucoseUnit())...)
+		}
+	}
+
+	return events, nil
+}
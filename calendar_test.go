@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCalendarFeedToken(t *testing.T) {
+	token := calendarFeedToken("secret", "test@glukit.com")
+
+	userEmail, signature, ok := parseCalendarFeedToken(token)
+	if !ok || userEmail != "test@glukit.com" {
+		t.Fatalf("Expected token [%s] to be of user [test@glukit.com] but got [%s], ok [%t]", token, userEmail, ok)
+	}
+
+	if !isValidCalendarFeedSignature("secret", userEmail, signature) {
+		t.Errorf("Expected signature [%s] to be valid", signature)
+	}
+
+	if isValidCalendarFeedSignature("other", userEmail, signature) {
+		t.Errorf("Expected signature [%s] to be invalid once the secret is reset", signature)
+	}
+
+	if isValidCalendarFeedSignature("", userEmail, signCalendarFeed("", userEmail)) {
+		t.Errorf("Expected no signature to be valid without a secret")
+	}
+}
+
+func TestParseCalendarFeedTokenRejectsMalformedTokens(t *testing.T) {
+	for _, token := range []string{"", "nodot", ".signature", "!!!.signature"} {
+		if _, _, ok := parseCalendarFeedToken(token); ok {
+			t.Errorf("Expected token [%s] to be rejected", token)
+		}
+	}
+}
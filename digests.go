@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/mail"
+	"google.golang.org/appengine/taskqueue"
+	"net/url"
+	"time"
+)
+
+const (
+	SEND_EMAIL_DIGESTS_FUNCTION_NAME = "sendEmailDigests"
+	SEND_EMAIL_DIGEST_FUNCTION_NAME  = "sendEmailDigest"
+	EMAIL_DIGEST_TASK_NAME_PREFIX    = "emailDigest"
+	EMAIL_DIGESTS_USERS_PER_BATCH    = 100
+
+	QUERY_PARAM_DIGEST_EMAIL     = "email"
+	QUERY_PARAM_DIGEST_SIGNATURE = "signature"
+)
+
+// sendEmailDigestsTaskArguments are the arguments of a dispatched batch of email digests, Now being the time the cron
+// started the digests at
+type sendEmailDigestsTaskArguments struct {
+	Cursor string    `json:"cursor"`
+	Now    time.Time `json:"now"`
+}
+
+// sendEmailDigestTaskArguments are the arguments of a dispatched email digest of a user
+type sendEmailDigestTaskArguments struct {
+	UserEmail string    `json:"userEmail"`
+	Now       time.Time `json:"now"`
+}
+
+// EmailDigestVariables are the variables of the email digest template. Glucose values are shown in Unit. Summary is
+// nil for the nudge sent in weeks without data.
+type EmailDigestVariables struct {
+	FirstName      string
+	WeekStart      time.Time
+	LastDay        time.Time
+	Summary        *model.WeeklySummary
+	Unit           apimodel.GlucoseUnit
+	DashboardUrl   string
+	UnsubscribeUrl string
+}
+
+// Glucose returns the glucose value, in mg/dL, formatted in the unit of the digest
+func (variables EmailDigestVariables) Glucose(value float64) string {
+	if variables.Unit == apimodel.MMOL_PER_L {
+		return fmt.Sprintf("%.1f", apimodel.GlucoseValue(value).In(variables.Unit))
+	}
+
+	return fmt.Sprintf("%.0f", value)
+}
+
+// UnitLabel returns the label of the unit of the digest
+func (variables EmailDigestVariables) UnitLabel() string {
+	if variables.Unit == apimodel.MMOL_PER_L {
+		return "mmol/L"
+	}
+
+	return "mg/dL"
+}
+
+func handleSendEmailDigests(context context.Context, payload []byte) error {
+	var arguments sendEmailDigestsTaskArguments
+	if decodeTaskPayload(context, SEND_EMAIL_DIGESTS_FUNCTION_NAME, payload, &arguments) {
+		return sendEmailDigestsBatch(context, arguments.Cursor, arguments.Now)
+	}
+
+	return nil
+}
+
+func handleSendEmailDigest(context context.Context, payload []byte) error {
+	var arguments sendEmailDigestTaskArguments
+	if decodeTaskPayload(context, SEND_EMAIL_DIGEST_FUNCTION_NAME, payload, &arguments) {
+		return sendEmailDigest(context, arguments.UserEmail, arguments.Now)
+	}
+
+	return nil
+}
+
+// sendEmailDigestsBatch enqueues the email digests of a batch of users starting at cursor and schedules itself to
+// continue with the next batch until all users have been seen
+func sendEmailDigestsBatch(context context.Context, cursor string, now time.Time) error {
+	users, nextCursor, err := store.ListUsers(context, cursor, EMAIL_DIGESTS_USERS_PER_BATCH)
+	if err != nil {
+		return err
+	}
+
+	enqueued := 0
+	for _, user := range users {
+		if !user.EmailDigestEnabled {
+			continue
+		}
+
+		if err := enqueueEmailDigest(context, user.Email, now); err != nil {
+			log.Warningf(context, "Error enqueuing the email digest of user [%s]: %v", user.Email, err)
+			continue
+		}
+		enqueued++
+	}
+
+	log.Infof(context, "Enqueued email digests of [%d] of [%d] users", enqueued, len(users))
+
+	if len(nextCursor) == 0 {
+		return nil
+	}
+
+	return enqueueEmailDigests(context, nextCursor, now)
+}
+
+// enqueueEmailDigests enqueues the batch of email digests starting at cursor
+func enqueueEmailDigests(context context.Context, cursor string, now time.Time) error {
+	task, err := newDispatchedTask(SEND_EMAIL_DIGESTS_FUNCTION_NAME, sendEmailDigestsTaskArguments{cursor, now})
+	if err != nil {
+		return err
+	}
+
+	_, err = taskqueue.Add(context, task, REFRESH_QUEUE_NAME)
+	return err
+}
+
+// enqueueEmailDigest enqueues the email digest of the user. The task is named after the user and the day of the cron
+// run so that a retried batch doesn't enqueue it twice.
+func enqueueEmailDigest(context context.Context, userEmail string, now time.Time) error {
+	task, err := newDispatchedTask(SEND_EMAIL_DIGEST_FUNCTION_NAME, sendEmailDigestTaskArguments{userEmail, now})
+	if err != nil {
+		return err
+	}
+
+	task.Name = fmt.Sprintf("%s-%x-%s", EMAIL_DIGEST_TASK_NAME_PREFIX, sha1.Sum([]byte(userEmail)), now.UTC().Format("20060102"))
+	if _, err = taskqueue.Add(context, task, REFRESH_QUEUE_NAME); err != nil && err != taskqueue.ErrTaskAlreadyAdded {
+		return err
+	}
+
+	return nil
+}
+
+// sendEmailDigest sends the user the digest of the last full week before now, in the user's timezone. Weeks without data
+// get a nudge if the user asked for it and no email otherwise. The digest is claimed in the store before it's sent so
+// that a week is never sent twice. A digest that couldn't be sent is released and the error returned for the task to
+// be retried.
+func sendEmailDigest(context context.Context, userEmail string, now time.Time) error {
+	userProfileKey, glukitUser, err := store.GetGlukitUser(context, userEmail)
+	if err != nil {
+		return err
+	}
+
+	if !glukitUser.EmailDigestEnabled {
+		log.Infof(context, "Skipping email digest of user [%s] who unsubscribed", userEmail)
+		return nil
+	}
+
+	location := engine.UserLocation(glukitUser)
+	weekStart := engine.StartOfWeek(now.In(location)).AddDate(0, 0, -7)
+
+	summary, err := store.GetWeeklySummary(context, userEmail, weekStart)
+	if err != nil {
+		return err
+	}
+
+	if summary == nil {
+		log.Infof(context, "Calculating missing weekly summary of [%s] for the email digest of user [%s]", weekStart, userEmail)
+		if err := engine.CalculateWeeklySummary(context, userEmail, weekStart, location.String()); err != nil {
+			return err
+		}
+		if summary, err = store.GetWeeklySummary(context, userEmail, weekStart); err != nil {
+			return err
+		}
+	}
+
+	send, noData := emailDigestKind(summary, glukitUser.EmailDigestNoDataNudge)
+	if !send {
+		log.Infof(context, "No data for the email digest of user [%s] for week [%s], not sending any", userEmail, weekStart)
+		return nil
+	}
+
+	if noData {
+		summary = nil
+	}
+
+	variables := newEmailDigestVariables(glukitUser, weekStart, summary)
+	var body bytes.Buffer
+	if err := emailDigestTemplate.Execute(&body, variables); err != nil {
+		log.Criticalf(context, "Error executing template [%s]", emailDigestTemplate.Name())
+		return err
+	}
+
+	err = store.ClaimEmailDigest(context, userProfileKey, model.EmailDigest{WeekStart: weekStart, NoData: noData, SentOn: time.Now()})
+	if err == store.ErrEmailDigestAlreadySent {
+		log.Infof(context, "Email digest of user [%s] for week [%s] already sent", userEmail, weekStart)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	message := &mail.Message{
+		Sender:   fmt.Sprintf("Glukit <noreply@%s.appspotmail.com>", appengine.AppID(context)),
+		To:       []string{userEmail},
+		Subject:  emailDigestSubject(weekStart, noData),
+		HTMLBody: body.String(),
+		Headers:  map[string][]string{"List-Unsubscribe": []string{fmt.Sprintf("<%s>", variables.UnsubscribeUrl)}},
+	}
+
+	if err := mail.Send(context, message); err != nil {
+		log.Warningf(context, "Error sending email digest of user [%s] for week [%s], releasing it for a retry: %v", userEmail, weekStart, err)
+		if releaseErr := store.ReleaseEmailDigest(context, userProfileKey, weekStart); releaseErr != nil {
+			log.Criticalf(context, "Couldn't release the unsent email digest of user [%s] for week [%s], it won't be sent: %v",
+				userEmail, weekStart, releaseErr)
+		}
+		return err
+	}
+
+	log.Infof(context, "Sent email digest of user [%s] for week [%s], without data [%t]", userEmail, weekStart, noData)
+	return nil
+}
+
+// emailDigestKind returns whether a digest of the weekly summary should be sent and whether it's the nudge of a week
+// without data, summary being nil if there's none
+func emailDigestKind(summary *model.WeeklySummary, noDataNudge bool) (send bool, noData bool) {
+	if summary == nil || summary.ReadCount == 0 {
+		return noDataNudge, true
+	}
+
+	return true, false
+}
+
+// emailDigestSubject returns the subject of the email digest of the week starting at weekStart
+func emailDigestSubject(weekStart time.Time, noData bool) string {
+	if noData {
+		return fmt.Sprintf("No Glukit data for the week of %s", weekStart.Format("Jan 2"))
+	}
+
+	return fmt.Sprintf("Your Glukit week of %s", weekStart.Format("Jan 2"))
+}
+
+// newEmailDigestVariables returns the variables of the email digest of the user's week starting at weekStart
+func newEmailDigestVariables(glukitUser *model.GlukitUser, weekStart time.Time, summary *model.WeeklySummary) EmailDigestVariables {
+	return EmailDigestVariables{FirstName: glukitUser.FirstName, WeekStart: weekStart, LastDay: weekStart.AddDate(0, 0, 6),
+		Summary: summary, Unit: glukitUser.GetGlucoseUnit(), DashboardUrl: appConfig.SSLHost + "/report",
+		UnsubscribeUrl: emailDigestUnsubscribeUrl(glukitUser.Email, glukitUser.EmailDigestSecret)}
+}
+
+// emailDigestUnsubscribeUrl returns the signed link that turns off the email digest of the user without logging in
+func emailDigestUnsubscribeUrl(userEmail string, secret string) string {
+	query := url.Values{}
+	query.Set(QUERY_PARAM_DIGEST_EMAIL, userEmail)
+	query.Set(QUERY_PARAM_DIGEST_SIGNATURE, signEmailDigestUnsubscribe(secret, userEmail))
+
+	return fmt.Sprintf("%s/digest/unsubscribe?%s", appConfig.SSLHost, query.Encode())
+}
+
+// signEmailDigestUnsubscribe returns the HMAC-SHA256, in hex, of the email keyed on the user's email digest secret
+func signEmailDigestUnsubscribe(secret string, userEmail string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(userEmail))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isValidEmailDigestUnsubscribe returns true if the signature is the one of the unsubscribe link of the user. Users
+// who never enabled the digest have no secret and no valid signature.
+func isValidEmailDigestUnsubscribe(secret string, userEmail string, signature string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(signEmailDigestUnsubscribe(secret, userEmail)))
+}
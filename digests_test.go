@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailDigestUnsubscribeSignature(t *testing.T) {
+	signature := signEmailDigestUnsubscribe("secret", "test@glukit.com")
+
+	if !isValidEmailDigestUnsubscribe("secret", "test@glukit.com", signature) {
+		t.Errorf("Expected signature [%s] to be valid", signature)
+	}
+
+	if isValidEmailDigestUnsubscribe("secret", "other@glukit.com", signature) {
+		t.Errorf("Expected signature [%s] to be invalid for another user", signature)
+	}
+
+	if isValidEmailDigestUnsubscribe("", "test@glukit.com", signEmailDigestUnsubscribe("", "test@glukit.com")) {
+		t.Errorf("Expected no signature to be valid without a secret")
+	}
+}
+
+func TestEmailDigestKind(t *testing.T) {
+	withData := &model.WeeklySummary{ReadCount: 2016}
+	empty := &model.WeeklySummary{}
+
+	for _, test := range []struct {
+		summary     *model.WeeklySummary
+		noDataNudge bool
+		send        bool
+		noData      bool
+	}{
+		{withData, false, true, false},
+		{withData, true, true, false},
+		{empty, false, false, true},
+		{empty, true, true, true},
+		{nil, false, false, true},
+		{nil, true, true, true},
+	} {
+		if send, noData := emailDigestKind(test.summary, test.noDataNudge); send != test.send || noData != test.noData {
+			t.Errorf("Expected send [%t] and no data [%t] for summary [%v] and nudge [%t] but got [%t] and [%t]", test.send,
+				test.noData, test.summary, test.noDataNudge, send, noData)
+		}
+	}
+}
+
+func TestEmailDigestTemplate(t *testing.T) {
+	weekStart := time.Date(2014, 4, 14, 0, 0, 0, 0, time.UTC)
+	summary := &model.WeeklySummary{WeekStart: weekStart, ReadCount: 2016, MeanGlucose: 144, TimeInRange: 72.4, HypoCount: 3}
+	variables := EmailDigestVariables{FirstName: "Test", WeekStart: weekStart, LastDay: weekStart.AddDate(0, 0, 6),
+		Summary: summary, Unit: apimodel.MMOL_PER_L, UnsubscribeUrl: "https://glukit/digest/unsubscribe?email=test"}
+
+	var body bytes.Buffer
+	if err := emailDigestTemplate.Execute(&body, variables); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"8.0 mmol/L", "72%", "Mon Apr 14", "digest/unsubscribe?email=test"} {
+		if !strings.Contains(body.String(), expected) {
+			t.Errorf("Expected digest to contain [%s] but got [%s]", expected, body.String())
+		}
+	}
+
+	body.Reset()
+	variables.Summary = nil
+	if err := emailDigestTemplate.Execute(&body, variables); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(body.String(), "didn't get any data") {
+		t.Errorf("Expected the no data nudge but got [%s]", body.String())
+	}
+}
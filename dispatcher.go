@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+)
+
+const (
+	DISPATCH_TASK_FUNCTION_NAME = "dispatchTask"
+)
+
+// taskHandler runs a task given its payload, the JSON encoding of the task's arguments. Returning an error has the task
+// retried by the task queue.
+type taskHandler func(context context.Context, payload []byte) error
+
+// taskHandlers holds the handler of each task by name. It's only populated in init() so that handlers can enqueue
+// tasks, including their own, without creating an initialization loop with dispatchTask.
+var taskHandlers = make(map[string]taskHandler)
+
+var dispatchTask = delay.Func(DISPATCH_TASK_FUNCTION_NAME, dispatch)
+
+func init() {
+	registerTaskHandler(REFRESH_USER_DATA_FUNCTION_NAME, handleRefreshUserData)
+	registerTaskHandler(PROCESS_FILE_FUNCTION_NAME, handleProcessFile)
+	registerTaskHandler(MIGRATE_USER_SCHEMA_FUNCTION_NAME, handleMigrateUserSchema)
+	registerTaskHandler(SCHEDULE_REFRESHES_FUNCTION_NAME, handleScheduleRefreshes)
+	registerTaskHandler(SEND_EMAIL_DIGESTS_FUNCTION_NAME, handleSendEmailDigests)
+	registerTaskHandler(SEND_EMAIL_DIGEST_FUNCTION_NAME, handleSendEmailDigest)
+	registerTaskHandler(SEAL_TOKENS_FUNCTION_NAME, handleSealTokens)
+}
+
+// registerTaskHandler registers the handler of tasks of the given name
+func registerTaskHandler(name string, handler taskHandler) {
+	if _, exists := taskHandlers[name]; exists {
+		panic(fmt.Sprintf("Task handler [%s] registered more than once", name))
+	}
+
+	taskHandlers[name] = handler
+}
+
+// dispatch runs the handler registered for the task name with the payload. A task with no registered handler returns
+// an error so that it gets retried, it's most likely running on an instance of a release that doesn't have it yet.
+func dispatch(context context.Context, name string, payload []byte) error {
+	handler, registered := taskHandlers[name]
+	if !registered {
+		log.Errorf(context, "No handler registered for task [%s], failing it for a retry", name)
+		return errors.New(fmt.Sprintf("No handler registered for task [%s]", name))
+	}
+
+	return handler(context, payload)
+}
+
+// newDispatchedTask returns a task that runs the handler registered under name with the arguments encoded as JSON
+func newDispatchedTask(name string, arguments interface{}) (*taskqueue.Task, error) {
+	payload, err := json.Marshal(arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	return dispatchTask.Task(name, payload)
+}
+
+// decodeTaskPayload decodes the payload of the task into arguments. A payload that can't be decoded won't get any
+// better with retries so it's logged and reported as not decoded for the handler to drop the task.
+func decodeTaskPayload(context context.Context, name string, payload []byte, arguments interface{}) (decoded bool) {
+	if err := json.Unmarshal(payload, arguments); err != nil {
+		log.Criticalf(context, "Dropping task [%s] with payload [%s] that can't be decoded: %v", name, string(payload), err)
+		return false
+	}
+
+	return true
+}
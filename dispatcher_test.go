@@ -0,0 +1,62 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/aetest"
+	"testing"
+)
+
+type testTaskArguments struct {
+	UserEmail string `json:"userEmail"`
+	Attempt   int    `json:"attempt"`
+}
+
+func TestDispatchRunsTheHandlerRegisteredForTheTask(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var received testTaskArguments
+	registerTaskHandler("testTask", func(context context.Context, payload []byte) error {
+		decodeTaskPayload(context, "testTask", payload, &received)
+		return nil
+	})
+	defer delete(taskHandlers, "testTask")
+
+	if err := dispatch(c, "testTask", []byte(`{"userEmail":"test@glukit.com","attempt":2}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if received.UserEmail != "test@glukit.com" || received.Attempt != 2 {
+		t.Errorf("Expected handler to receive the arguments of the task but got [%v]", received)
+	}
+
+	if err := dispatch(c, "unregisteredTask", []byte("{}")); err == nil {
+		t.Errorf("Expected a task without a registered handler to fail for a retry")
+	}
+}
+
+func TestTaskPayloadThatCantBeDecodedIsDropped(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var arguments testTaskArguments
+	if decodeTaskPayload(c, "testTask", []byte("not json"), &arguments) {
+		t.Errorf("Expected a payload that isn't JSON not to be decoded")
+	}
+}
+
+func TestTaskHandlerCantBeRegisteredTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected registering the handler of [%s] a second time to panic", REFRESH_USER_DATA_FUNCTION_NAME)
+		}
+	}()
+
+	registerTaskHandler(REFRESH_USER_DATA_FUNCTION_NAME, handleRefreshUserData)
+}
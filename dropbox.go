@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/importer"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/lib/goauth2/oauth"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+	"net/http"
+	"time"
+)
+
+const (
+	// Cookie holding the state of the Dropbox authorization in progress, checked on the callback so that a Dropbox
+	// account can only be connected by the user who asked for it
+	DROPBOX_STATE_COOKIE  = "dropboxState"
+	DROPBOX_STATE_BYTES   = 16
+	DROPBOX_STATE_MAX_AGE = 10 * 60
+)
+
+// dropboxConfiguration returns the OAuth configuration of the Dropbox app, redirecting to the callback on the host
+func dropboxConfiguration(host string) *oauth.Config {
+	return &oauth.Config{
+		ClientId:     appConfig.DropboxAppKey,
+		ClientSecret: appConfig.DropboxAppSecret,
+		AuthURL:      importer.DROPBOX_AUTH_URL,
+		TokenURL:     importer.DROPBOX_TOKEN_URL,
+		RedirectURL:  fmt.Sprintf("https://%s/settings/dropbox/callback", host),
+	}
+}
+
+// connectDropbox is the endpoint that sends the logged in user to authorize access to the Dropbox app folder. The
+// refresh token Dropbox only gives out for offline access keeps the connection working after the access token expires.
+func connectDropbox(writer http.ResponseWriter, request *http.Request) {
+	stateBytes := make([]byte, DROPBOX_STATE_BYTES)
+	if _, err := rand.Read(stateBytes); err != nil {
+		writeError(appengine.NewContext(request), writer, err)
+		return
+	}
+	state := hex.EncodeToString(stateBytes)
+
+	http.SetCookie(writer, &http.Cookie{Name: DROPBOX_STATE_COOKIE, Value: state, Path: "/settings/dropbox", MaxAge: DROPBOX_STATE_MAX_AGE,
+		Secure: !appengine.IsDevAppServer(), HttpOnly: true})
+	http.Redirect(writer, request, dropboxConfiguration(request.Host).AuthCodeURL(state)+"&token_access_type=offline", http.StatusFound)
+}
+
+// dropboxCallback is the endpoint Dropbox sends the user back to with the authorization code, exchanged for the token
+// stored on the user's profile. The next refresh searches the app folder for data files.
+func dropboxCallback(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	if cookie, err := request.Cookie(DROPBOX_STATE_COOKIE); err != nil || cookie.Value == "" || cookie.Value != request.FormValue("state") {
+		http.Error(writer, "Invalid dropbox authorization, try connecting dropbox again.", http.StatusForbidden)
+		return
+	}
+	http.SetCookie(writer, &http.Cookie{Name: DROPBOX_STATE_COOKIE, Path: "/settings/dropbox", MaxAge: -1})
+
+	if reason := request.FormValue("error"); reason != "" {
+		log.Infof(context, "User [%s] didn't authorize dropbox: [%s]", user.Email, reason)
+		http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+		return
+	}
+
+	transport := &oauth.Transport{
+		Config: dropboxConfiguration(request.Host),
+		Transport: &urlfetch.Transport{
+			Context: context,
+		},
+	}
+
+	token, err := transport.Exchange(context, request.FormValue("code"))
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	glukitUser.DropboxToken = *token
+	if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Connected dropbox of user [%s]", user.Email)
+
+	if err := enqueueRefresh(context, user.Email, false, time.Now(), REFRESH_TRIGGER_MANUAL); err != nil {
+		log.Warningf(context, "Error enqueuing refresh of user [%s] after connecting dropbox: %v", user.Email, err)
+	}
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// disconnectDropbox is the endpoint to stop searching the Dropbox of the logged in user for data files. Data already
+// imported from it is kept.
+func disconnectDropbox(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	glukitUser.DropboxToken = oauth.Token{}
+	if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Disconnected dropbox of user [%s]", user.Email)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
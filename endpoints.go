@@ -1,22 +1,32 @@
 package main
 
 import (
+	"archive/zip"
+	"code.google.com/p/gorilla/mux"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/alexandre-normand/glukit/app/analytics"
 	"github.com/alexandre-normand/glukit/app/apimodel"
 	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/export"
+	"github.com/alexandre-normand/glukit/app/importer"
 	"github.com/alexandre-normand/glukit/app/model"
 	"github.com/alexandre-normand/glukit/app/payment"
 	"github.com/alexandre-normand/glukit/app/store"
 	"github.com/alexandre-normand/glukit/app/util"
 	"github.com/alexandre-normand/glukit/lib/github.com/grd/stat"
+	"golang.org/x/net/context"
 	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/user"
+	"math"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -31,6 +41,10 @@ type DataResponse struct {
 	JoinedOn     time.Time         `json:"joinedOn"`
 	Data         []DataSeries      `json:"data"`
 	Trend        string            `json:"trend"`
+	TrendRate    float64           `json:"trendRate"`
+	// Set when the user's token was revoked so that the user can be asked to authorize access again
+	TokenRevoked   bool      `json:"tokenRevoked"`
+	TokenRevokedOn time.Time `json:"tokenRevokedOn"`
 }
 
 // Represents a generic DataSeries structure with a series of DataPoints
@@ -44,14 +58,157 @@ const (
 	QUERY_PARAM_LIMIT = "limit"
 	QUERY_PARAM_FROM  = "from"
 	QUERY_PARAM_TO    = "to"
+
+	// Bounds of the periods compared by the period comparison endpoint
+	QUERY_PARAM_PERIOD_A_FROM = "aFrom"
+	QUERY_PARAM_PERIOD_A_TO   = "aTo"
+	QUERY_PARAM_PERIOD_B_FROM = "bFrom"
+	QUERY_PARAM_PERIOD_B_TO   = "bTo"
+
+	// Local times, formatted as 15:04, of the start and end of the nights analyzed by the nights endpoint
+	QUERY_PARAM_NIGHT_START = "nightStart"
+	QUERY_PARAM_NIGHT_END   = "nightEnd"
+
+	// Bounds, in mg/dL, of the target range updated by the target range settings endpoint
+	FORM_FIELD_TARGET_LOW  = "targetLow"
+	FORM_FIELD_TARGET_HIGH = "targetHigh"
+
+	// Fields of a note created or updated by the notes endpoint. The time is a unix timestamp and the timezone an IANA
+	// timezone name.
+	FORM_FIELD_NOTE_ID       = "id"
+	FORM_FIELD_NOTE_TIME     = "time"
+	FORM_FIELD_NOTE_TIMEZONE = "timezone"
+	FORM_FIELD_NOTE_TEXT     = "text"
+	FORM_FIELD_NOTE_TAG      = "tag"
+
+	// Form fields of meals
+	FORM_FIELD_MEAL_CARBS         = "carbs"
+	FORM_FIELD_MEAL_PROTEIN       = "protein"
+	FORM_FIELD_MEAL_FAT           = "fat"
+	FORM_FIELD_MEAL_SATURATED_FAT = "saturatedFat"
+	FORM_FIELD_MEAL_FIBER         = "fiber"
+	FORM_FIELD_MEAL_DESCRIPTION   = "description"
+
+	// Form fields of the clinical profile
+	FORM_FIELD_DIABETES_TYPE  = "diabetesType"
+	FORM_FIELD_DIAGNOSED_DATE = "diagnosedDate"
+	FORM_FIELD_THERAPY_MODE   = "therapyMode"
+
+	// Form fields of the refresh settings, the interval is a number of hours and paused a boolean
+	FORM_FIELD_REFRESH_INTERVAL_HOURS = "refreshIntervalHours"
+	FORM_FIELD_REFRESH_PAUSED         = "refreshPaused"
+
+	// Layout of dates of forms
+	FORM_DATE_LAYOUT = "2006-01-02"
+
+	// Form field of the email of the user whose data is migrated or refreshed by the admin endpoints
+	FORM_FIELD_USER_EMAIL = "email"
+
+	// Path variable of the id of an event
+	PATH_VAR_EVENT_ID = "id"
+
+	// Path variable of the id of a failed task and default number of failed tasks listed
+	PATH_VAR_FAILED_TASK_ID    = "id"
+	DEFAULT_FAILED_TASKS_LIMIT = 100
+
+	// Form fields of the bounds and repair mode of an integrity check and default number of integrity reports listed
+	FORM_FIELD_INTEGRITY_FROM       = "from"
+	FORM_FIELD_INTEGRITY_TO         = "to"
+	FORM_FIELD_INTEGRITY_REPAIR     = "repair"
+	DEFAULT_INTEGRITY_REPORTS_LIMIT = 20
+
+	// Default and maximum number of file imports listed
+	DEFAULT_FILE_IMPORTS_LIMIT = 20
+	MAX_FILE_IMPORTS_LIMIT     = 100
+
+	// Name of the form field of the file uploaded for validation
+	FORM_FIELD_FILE = "file"
+
+	// Maximum size of a file uploaded for validation that is kept in memory
+	MAX_VALIDATION_MEMORY = 8 << 20
+
+	// Form fields of an api key created by the api keys endpoint, scope being repeated for each scope granted, and path
+	// variable of the id of a revoked key
+	FORM_FIELD_API_KEY_LABEL = "label"
+	FORM_FIELD_API_KEY_SCOPE = "scope"
+	MAX_API_KEY_LABEL_LENGTH = 100
+	PATH_VAR_API_KEY_ID      = "id"
+
+	// Form fields of the webhook settings, event being repeated for each event subscribed to. An empty secret keeps
+	// the current one.
+	FORM_FIELD_WEBHOOK_URL     = "url"
+	FORM_FIELD_WEBHOOK_SECRET  = "secret"
+	FORM_FIELD_WEBHOOK_ENABLED = "enabled"
+	FORM_FIELD_WEBHOOK_EVENT   = "event"
+	MAX_WEBHOOK_URL_LENGTH     = 2048
+
+	// Query parameter of read endpoints selecting the user whose data is read, honored only if they granted access to
+	// the logged in user
+	QUERY_PARAM_ON_BEHALF_OF = "onBehalfOf"
+
+	// Form fields of a share invitation, the expiry being the last day of access in the owner's timezone, and path
+	// variables of the token of an accepted invitation and of the grantee of a revoked grant
+	FORM_FIELD_SHARE_EMAIL      = "email"
+	FORM_FIELD_SHARE_PERMISSION = "permission"
+	FORM_FIELD_SHARE_EXPIRES    = "expires"
+	PATH_VAR_SHARE_TOKEN        = "token"
+	PATH_VAR_SHARE_GRANTEE      = "grantee"
+	PATH_VAR_SHARE_INVITATION   = "id"
+
+	// Form fields of a snapshot, its bounds and expiry being dates in the user's timezone, and path variables of the id
+	// of a revoked snapshot and of the token of a shown one
+	FORM_FIELD_SNAPSHOT_FROM    = "from"
+	FORM_FIELD_SNAPSHOT_TO      = "to"
+	FORM_FIELD_SNAPSHOT_LABEL   = "label"
+	FORM_FIELD_SNAPSHOT_EXPIRES = "expires"
+	MAX_SNAPSHOT_LABEL_LENGTH   = 100
+	PATH_VAR_SNAPSHOT_ID        = "id"
+	PATH_VAR_SNAPSHOT_TOKEN     = "token"
+
+	// Query parameter of the number of days covered by a clinic report, one of model.ClinicReportDays
+	QUERY_PARAM_REPORT_DAYS = "days"
+
+	// Size of the AGP chart of a clinic report, glucose values above AGP_CHART_MAX_GLUCOSE (in mg/dL) being drawn at
+	// its top
+	AGP_CHART_WIDTH       = 720
+	AGP_CHART_HEIGHT      = 240
+	AGP_CHART_MAX_GLUCOSE = 400
+
+	// Form fields of the email digest settings
+	FORM_FIELD_EMAIL_DIGEST_ENABLED       = "emailDigestEnabled"
+	FORM_FIELD_EMAIL_DIGEST_NO_DATA_NUDGE = "emailDigestNoDataNudge"
+
+	// Form fields of the bounds, as dates in the user's timezone, and of the kinds of data exported as csv. Exports
+	// default to the last DEFAULT_EXPORT_DAYS days and data is read from the store one EXPORT_WINDOW at a time.
+	FORM_FIELD_EXPORT_FROM  = "from"
+	FORM_FIELD_EXPORT_TO    = "to"
+	FORM_FIELD_EXPORT_KINDS = "kinds"
+	DEFAULT_EXPORT_DAYS     = 30
+	EXPORT_WINDOW           = time.Duration(7*24) * time.Hour
+
+	// Form field of the kill switch of the analytics export
+	FORM_FIELD_ANALYTICS_DISABLED = "disabled"
+
+	// Form field of the Google Fit import setting
+	FORM_FIELD_GOOGLE_FIT_ENABLED = "googleFitEnabled"
 )
 
+// ImportValidation is the response of a file validation: the report of what importing the file would do and the error
+// that would make the import fail, if any
+type ImportValidation struct {
+	Report *importer.ImportReport `json:"report"`
+	Error  string                 `json:"error,omitempty"`
+}
+
 // content renders the most recent day's worth of data as json for the active user
 func personalData(writer http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
-	user := user.Current(context)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
 
-	mostRecentWeekAsJson(writer, request, user.Email)
+	mostRecentWeekAsJson(writer, request, email)
 }
 
 // demoContent renders the most recent day's worth of data as json for the demo user
@@ -70,7 +227,8 @@ func mostRecentWeekAsJson(writer http.ResponseWriter, request *http.Request, ema
 		log.Debugf(context, "No imported data found for user [%s]", email)
 		http.Error(writer, err.Error(), 204)
 	} else if err != nil {
-		util.Propagate(err)
+		writeError(context, writer, err)
+		return
 	} else {
 		unitValue, err := resolveGlucoseUnit(email, request)
 		if err != nil {
@@ -80,25 +238,47 @@ func mostRecentWeekAsJson(writer http.ResponseWriter, request *http.Request, ema
 
 		reads, err := store.GetGlucoseReads(context, email, lowerBound, upperBound)
 		if err != nil {
-			util.Propagate(err)
+			writeError(context, writer, err)
+			return
 		}
 		injections, err := store.GetInjections(context, email, lowerBound, upperBound)
 		if err != nil {
-			util.Propagate(err)
+			writeError(context, writer, err)
+			return
+		}
+		basalRates, err := store.GetBasalRates(context, email, lowerBound, upperBound)
+		if err != nil {
+			writeError(context, writer, err)
+			return
+		}
+		notes, err := store.GetNotes(context, email, lowerBound, upperBound)
+		if err != nil {
+			writeError(context, writer, err)
+			return
 		}
 		carbs, err := store.GetMeals(context, email, lowerBound, upperBound)
 		if err != nil {
-			util.Propagate(err)
+			writeError(context, writer, err)
+			return
 		}
 		exercises, err := store.GetExercises(context, email, lowerBound, upperBound)
 		if err != nil {
-			util.Propagate(err)
+			writeError(context, writer, err)
+			return
+		}
+
+		dataSeries, err := generateDataSeriesFromData(reads, injections, basalRates, carbs, exercises, notes, *unitValue)
+		if err != nil {
+			writeError(context, writer, err)
+			return
 		}
 
 		value := writer.Header()
 		value.Add("Content-type", "application/json")
 
-		response := DataResponse{FirstName: glukitUser.FirstName, LastName: glukitUser.LastName, Picture: glukitUser.PictureUrl, LastSync: glukitUser.MostRecentRead.GetTime(), Score: engine.CalculateUserFacingScore(glukitUser.MostRecentScore), ScoreDetails: glukitUser.MostRecentScore, JoinedOn: glukitUser.AccountCreated, Data: generateDataSeriesFromData(reads, injections, carbs, exercises, *unitValue)}
+		trend := engine.CalculateTrend(reads)
+		response := DataResponse{FirstName: glukitUser.FirstName, LastName: glukitUser.LastName, Picture: glukitUser.PictureUrl, LastSync: glukitUser.MostRecentRead.GetTime(), Score: engine.CalculateUserFacingScore(glukitUser.MostRecentScore), ScoreDetails: glukitUser.MostRecentScore, JoinedOn: glukitUser.AccountCreated, Data: dataSeries, Trend: string(trend.Arrow), TrendRate: trend.RateOfChange,
+			TokenRevoked: glukitUser.TokenRevoked, TokenRevokedOn: glukitUser.TokenRevokedOn}
 		writeAsJson(writer, response)
 	}
 }
@@ -106,9 +286,12 @@ func mostRecentWeekAsJson(writer http.ResponseWriter, request *http.Request, ema
 // find the steady sailor and retrieve his most recent day's worth of data.
 func steadySailorData(writer http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
-	user := user.Current(context)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
 
-	steadySailorDataForEmail(writer, request, user.Email)
+	steadySailorDataForEmail(writer, request, email)
 }
 
 // find the steady sailor for the demo user and retrieve his most recent day's worth of data.
@@ -127,7 +310,8 @@ func steadySailorDataForEmail(writer http.ResponseWriter, request *http.Request,
 		log.Debugf(context, "No steady sailor match found for user [%s]", recipientEmail)
 		http.Error(writer, err.Error(), 204)
 	} else if err != nil {
-		util.Propagate(err)
+		writeError(context, writer, err)
+		return
 	} else {
 		unitValue, err := resolveGlucoseUnit(recipientEmail, request)
 		if err != nil {
@@ -137,35 +321,72 @@ func steadySailorDataForEmail(writer http.ResponseWriter, request *http.Request,
 
 		reads, err := store.GetGlucoseReads(context, steadySailor.Email, lowerBound, upperBound)
 		if err != nil {
-			util.Propagate(err)
+			writeError(context, writer, err)
+			return
+		}
+
+		dataSeries, err := generateDataSeriesFromData(reads, nil, nil, nil, nil, nil, *unitValue)
+		if err != nil {
+			writeError(context, writer, err)
+			return
 		}
 
 		value := writer.Header()
 		value.Add("Content-type", "application/json")
 
-		response := DataResponse{FirstName: steadySailor.FirstName, LastName: steadySailor.LastName, Picture: steadySailor.PictureUrl, LastSync: steadySailor.MostRecentRead.GetTime(), Score: engine.CalculateUserFacingScore(steadySailor.MostRecentScore), ScoreDetails: steadySailor.MostRecentScore, JoinedOn: steadySailor.AccountCreated, Data: generateDataSeriesFromData(reads, nil, nil, nil, *unitValue)}
+		response := DataResponse{FirstName: steadySailor.FirstName, LastName: steadySailor.LastName, Picture: steadySailor.PictureUrl, LastSync: steadySailor.MostRecentRead.GetTime(), Score: engine.CalculateUserFacingScore(steadySailor.MostRecentScore), ScoreDetails: steadySailor.MostRecentScore, JoinedOn: steadySailor.AccountCreated, Data: dataSeries}
 		writeAsJson(writer, response)
 	}
 }
 
-// writeAsJson writes a DataResponse with its set of GlucoseReads, Injections, Meals and Exercises as json. This is what is called from the javascript
+// writeAsJson writes a DataResponse with its set of GlucoseReads, Injections, BasalRates, Meals, Exercises and Notes as json. This is what is called from the javascript
 // front-end to get the data.
 func writeAsJson(writer http.ResponseWriter, response DataResponse) {
 	enc := json.NewEncoder(writer)
 	enc.Encode(response)
 }
 
-func generateDataSeriesFromData(reads []apimodel.GlucoseRead, injections []apimodel.Injection, carbs []apimodel.Meal, exercises []apimodel.Exercise, glucoseUnit apimodel.GlucoseUnit) (dataSeries []DataSeries) {
+func generateDataSeriesFromData(reads []apimodel.GlucoseRead, injections []apimodel.Injection, basalRates []apimodel.BasalRate, carbs []apimodel.Meal, exercises []apimodel.Exercise, notes []apimodel.Note, glucoseUnit apimodel.GlucoseUnit) (dataSeries []DataSeries, err error) {
 	data := make([]DataSeries, 1)
 
-	data[0] = DataSeries{"GlucoseReads", apimodel.GlucoseReadSlice(reads).ToDataPointSlice(glucoseUnit), "GlucoseReads"}
+	readDataPoints, err := apimodel.GlucoseReadSlice(reads).ToDataPointSlice(glucoseUnit)
+	if err != nil {
+		return nil, err
+	}
+	data[0] = DataSeries{"GlucoseReads", readDataPoints, "GlucoseReads"}
+
 	var userEvents []apimodel.DataPoint
+	appendEvents := func(dataPoints []apimodel.DataPoint, err error) error {
+		if err != nil {
+			return err
+		}
+
+		userEvents = apimodel.MergeDataPointArrays(userEvents, dataPoints)
+		return nil
+	}
+
 	if injections != nil {
-		userEvents = apimodel.MergeDataPointArrays(userEvents, apimodel.InjectionSlice(injections).ToDataPointSlice(reads, glucoseUnit))
+		if err := appendEvents(apimodel.InjectionSlice(injections).ToDataPointSlice(reads, glucoseUnit)); err != nil {
+			return nil, err
+		}
+	}
+
+	if basalRates != nil {
+		if err := appendEvents(apimodel.BasalRateSlice(basalRates).ToDataPointSlice(reads, glucoseUnit)); err != nil {
+			return nil, err
+		}
 	}
 
 	if carbs != nil {
-		userEvents = apimodel.MergeDataPointArrays(userEvents, apimodel.MealSlice(carbs).ToDataPointSlice(reads, glucoseUnit))
+		if err := appendEvents(apimodel.MealSlice(carbs).ToDataPointSlice(reads, glucoseUnit)); err != nil {
+			return nil, err
+		}
+	}
+
+	if notes != nil {
+		if err := appendEvents(apimodel.NoteSlice(notes).ToDataPointSlice(reads, glucoseUnit)); err != nil {
+			return nil, err
+		}
 	}
 
 	// TODO: clean up exercise from all the app or restore it. We won't be using it at the moment as we don't think the exercise data
@@ -178,15 +399,18 @@ func generateDataSeriesFromData(reads []apimodel.GlucoseRead, injections []apimo
 
 	data = append(data, DataSeries{"UserEvents", userEvents, "UserEvents"})
 
-	return data
+	return data, nil
 }
 
 // dashboard renders the dashboard statistics as json
 func dashboard(writer http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
-	user := user.Current(context)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
 
-	dashboardDataForUser(writer, request, user.Email)
+	dashboardDataForUser(writer, request, email)
 }
 
 // demodashboard renders the dashboard statistics as json for the demo user
@@ -205,30 +429,42 @@ func dashboardDataForUser(writer http.ResponseWriter, request *http.Request, ema
 		log.Debugf(context, "No imported data found for user [%s]", email)
 		http.Error(writer, err.Error(), 204)
 	} else if err != nil {
-		util.Propagate(err)
+		writeError(context, writer, err)
+		return
 	} else {
+		unitValue, err := resolveGlucoseUnit(email, request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		reads, err := store.GetGlucoseReads(context, email, lowerBound, upperBound)
 		if err != nil {
-			util.Propagate(err)
+			writeError(context, writer, err)
+			return
 		}
 
-		writeDashboardDataAsJson(writer, request, reads)
+		writeDashboardDataAsJson(writer, request, reads, *unitValue)
 	}
 }
 
 // writedashboardDataAsJson calculates dashboard statistics from an array of GlucoseReads and writes it
-// as json
-func writeDashboardDataAsJson(writer http.ResponseWriter, request *http.Request, reads []apimodel.GlucoseRead) {
+// as json with values in glucoseUnit
+func writeDashboardDataAsJson(writer http.ResponseWriter, request *http.Request, reads []apimodel.GlucoseRead, glucoseUnit apimodel.GlucoseUnit) {
 	value := writer.Header()
 	value.Add("Content-type", "application/json")
 
-	var dashboardData model.DashboardData
+	dashboardData := model.DashboardData{Unit: glucoseUnit}
 	if len(reads) > 0 {
 		sort.Sort(model.ReadStatsSlice(reads))
 		dashboardData.Average = stat.Mean(model.ReadStatsSlice(reads))
 		dashboardData.High, _ = stat.Max(model.ReadStatsSlice(reads))
 		dashboardData.Low, _ = stat.Min(model.ReadStatsSlice(reads))
 		dashboardData.Median = stat.MedianFromSortedData(model.ReadStatsSlice(reads))
+
+		for _, statistic := range []*float64{&dashboardData.Average, &dashboardData.High, &dashboardData.Low, &dashboardData.Median} {
+			*statistic = float64(apimodel.GlucoseValue(*statistic).In(glucoseUnit))
+		}
 	}
 
 	enc := json.NewEncoder(writer)
@@ -237,9 +473,12 @@ func writeDashboardDataAsJson(writer http.ResponseWriter, request *http.Request,
 
 func glukitScores(writer http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
-	user := user.Current(context)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
 
-	glukitScoresForEmail(writer, request, user.Email)
+	glukitScoresForEmail(writer, request, email)
 }
 
 func glukitScoresForDemo(writer http.ResponseWriter, request *http.Request) {
@@ -257,7 +496,8 @@ func glukitScoresForEmail(writer http.ResponseWriter, request *http.Request, ema
 	}
 	glukitScores, err := store.GetGlukitScores(context, email, *scanQuery)
 	if err != nil {
-		util.Propagate(err)
+		writeError(context, writer, err)
+		return
 	}
 
 	if len(glukitScores) < 1 {
@@ -272,11 +512,75 @@ func glukitScoresForEmail(writer http.ResponseWriter, request *http.Request, ema
 	enc.Encode(glukitScores)
 }
 
+func glukitScoreHistory(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
+
+	glukitScoreHistoryForEmail(writer, request, email)
+}
+
+func glukitScoreHistoryForDemo(writer http.ResponseWriter, request *http.Request) {
+	glukitScoreHistoryForEmail(writer, request, DEMO_EMAIL)
+}
+
+// glukitScoreHistoryForEmail is the endpoint to retrieve the history of glukit scores to chart. The period defaults
+// to the last year if the from/to parameters aren't specified.
+func glukitScoreHistoryForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	serveGlukitScoreHistory(appengine.NewContext(request), writer, request, email)
+}
+
+// serveGlukitScoreHistory writes the history of glukit scores of the user as JSON, see glukitScoreHistoryForEmail
+func serveGlukitScoreHistory(context context.Context, writer http.ResponseWriter, request *http.Request, email string) {
+	upperBound := time.Now()
+	lowerBound := upperBound.AddDate(-1, 0, 0)
+
+	if from := request.FormValue(QUERY_PARAM_FROM); len(from) > 0 {
+		fromValue, err := strconv.ParseInt(from, 10, 64)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_FROM, err), 400)
+			return
+		}
+		lowerBound = time.Unix(fromValue, 0)
+	}
+
+	if to := request.FormValue(QUERY_PARAM_TO); len(to) > 0 {
+		toValue, err := strconv.ParseInt(to, 10, 64)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_TO, err), 400)
+			return
+		}
+		upperBound = time.Unix(toValue, 0)
+	}
+
+	scores, err := store.GetGlukitScoreHistory(context, email, lowerBound, upperBound)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if len(scores) < 1 {
+		http.Error(writer, "No glukit scores calculated yet.", 204)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(scores)
+}
+
 func a1cEstimates(writer http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
-	user := user.Current(context)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
 
-	a1csForEmail(writer, request, user.Email)
+	a1csForEmail(writer, request, email)
 }
 
 func a1cEstimatesForDemo(writer http.ResponseWriter, request *http.Request) {
@@ -295,7 +599,8 @@ func a1csForEmail(writer http.ResponseWriter, request *http.Request, email strin
 
 	a1cs, err := store.GetA1CEstimates(context, email, *scanQuery)
 	if err != nil {
-		util.Propagate(err)
+		writeError(context, writer, err)
+		return
 	}
 
 	if len(a1cs) < 1 {
@@ -310,63 +615,2140 @@ func a1csForEmail(writer http.ResponseWriter, request *http.Request, email strin
 	enc.Encode(a1cs)
 }
 
-func newScanQuery(request *http.Request) (scanQuery *store.ScoreScanQuery, err error) {
-	limit := request.FormValue(QUERY_PARAM_LIMIT)
-	fromTimestamp := request.FormValue(QUERY_PARAM_FROM)
-	toTimestamp := request.FormValue(QUERY_PARAM_TO)
+// comparePeriods is the endpoint to compare two periods of the logged in user. Periods are given with the aFrom/aTo and
+// bFrom/bTo parameters, parsed like the ones of the api, see model.ParseTimeRange, and default to the last 30 days (b)
+// compared with the 30 days before (a).
+func comparePeriods(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
 
-	scanQuery = new(store.ScoreScanQuery)
-	// The request must include at least one of from/to/limit to be considered valid
-	// as leaving it too open would could open the door for costly queries
-	if len(limit) == 0 && len(fromTimestamp) == 0 && len(toTimestamp) == 0 {
-		return nil, errors.New(fmt.Sprintf("Query must specify at least one of: %s, %s or %s.",
-			QUERY_PARAM_LIMIT, QUERY_PARAM_FROM, QUERY_PARAM_TO))
+	now := time.Now()
+	location := userLocation(context, email)
+	periodB, err := model.ParseNamedTimeRangeOr(QUERY_PARAM_PERIOD_B_FROM, request.FormValue(QUERY_PARAM_PERIOD_B_FROM),
+		QUERY_PARAM_PERIOD_B_TO, request.FormValue(QUERY_PARAM_PERIOD_B_TO), location,
+		model.TimeRange{LowerBound: now.AddDate(0, 0, -30), UpperBound: now})
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
 	}
 
-	if len(limit) > 0 {
-		if limitValue, err := strconv.ParseInt(limit, 10, 32); err != nil {
-			return nil, errors.New(fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_LIMIT, err))
-		} else {
-			limitVal := int(limitValue)
-			scanQuery.Limit = &limitVal
-		}
+	periodA, err := model.ParseNamedTimeRangeOr(QUERY_PARAM_PERIOD_A_FROM, request.FormValue(QUERY_PARAM_PERIOD_A_FROM),
+		QUERY_PARAM_PERIOD_A_TO, request.FormValue(QUERY_PARAM_PERIOD_A_TO), location,
+		model.TimeRange{LowerBound: periodB.LowerBound.AddDate(0, 0, -30), UpperBound: periodB.LowerBound})
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
 	}
 
-	if len(fromTimestamp) > 0 {
-		if fromValue, err := strconv.ParseInt(fromTimestamp, 10, 64); err != nil {
-			return nil, errors.New(fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_FROM, err))
-		} else {
-			fromTime := time.Unix(fromValue, 0)
-			scanQuery.From = &fromTime
-		}
+	unitValue, err := resolveGlucoseUnit(email, request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	if len(toTimestamp) > 0 {
-		if toValue, err := strconv.ParseInt(toTimestamp, 10, 64); err != nil {
-			return nil, errors.New(fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_TO, err))
-		} else {
-			toTime := time.Unix(toValue, 0)
-			scanQuery.To = &toTime
+	comparison, err := engine.ComparePeriods(context, email, periodA, periodB)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(comparison.In(*unitValue))
+}
+
+// nights is the endpoint to analyze the nights of the logged in user ending between the from and to parameters, see
+// model.ParseTimeRange, defaulting to the last 30 days. Each night is returned along with its class so that nights can
+// be shown on a calendar.
+func nights(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	window := engine.DEFAULT_OVERNIGHT_WINDOW
+	period, err := model.ParseTimeRangeOr(request.FormValue(QUERY_PARAM_FROM), request.FormValue(QUERY_PARAM_TO),
+		userLocation(context, email), model.TimeRange{LowerBound: now.AddDate(0, 0, -30), UpperBound: now})
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	offsets := []struct {
+		param string
+		value *time.Duration
+	}{
+		{QUERY_PARAM_NIGHT_START, &window.Start},
+		{QUERY_PARAM_NIGHT_END, &window.End},
+	}
+	for _, offset := range offsets {
+		if param := request.FormValue(offset.param); len(param) > 0 {
+			value, err := time.Parse("15:04", param)
+			if err != nil {
+				http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", offset.param, err), 400)
+				return
+			}
+			*offset.value = time.Duration(value.Hour())*time.Hour + time.Duration(value.Minute())*time.Minute
 		}
 	}
 
-	return scanQuery, nil
+	unitValue, err := resolveGlucoseUnit(email, request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	summary, err := engine.AnalyzeNights(context, email, period, window)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(summary.In(*unitValue))
 }
 
-func handleDonation(writer http.ResponseWriter, request *http.Request) {
+// insulinTotals is the endpoint to get the daily basal and bolus totals of the logged in user for the days between the
+// from and to parameters, see model.ParseTimeRange, defaulting to the last 14 days
+func insulinTotals(writer http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
-	user := user.Current(context)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
 
-	request.ParseForm()
-	token := request.FormValue(payment.STRIPE_TOKEN)
-	amountInCentsVal := request.FormValue(payment.DONATION_AMOUNT)
+	now := time.Now()
+	period, err := model.ParseTimeRangeOr(request.FormValue(QUERY_PARAM_FROM), request.FormValue(QUERY_PARAM_TO),
+		userLocation(context, email), model.TimeRange{LowerBound: now.AddDate(0, 0, -14), UpperBound: now})
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
 
-	stripeClient := payment.NewStripeClient(appConfig)
-	err := stripeClient.SubmitDonation(context, token, amountInCentsVal)
+	totals, err := engine.CalculateDailyInsulinTotals(context, email, period)
 	if err != nil {
-		log.Warningf(context, "Error processing donation from [%v] of [%d] cents with token [%s]: [%v]", user, amountInCentsVal, token, err)
-		writer.WriteHeader(502)
-	} else {
-		writer.WriteHeader(200)
+		writeError(context, writer, err)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(totals)
+}
+
+// userLocation returns the location of the user of the email, see engine.UserLocation, or UTC if the user profile
+// can't be read
+func userLocation(context context.Context, email string) *time.Location {
+	_, glukitUser, err := store.GetGlukitUser(context, email)
+	if err != nil {
+		log.Warningf(context, "Error reading user profile of [%s], using UTC: %v", email, err)
+		return time.UTC
+	}
+
+	return engine.UserLocation(glukitUser)
+}
+
+type targetRangeResponse struct {
+	TargetLow  float32              `json:"targetLow"`
+	TargetHigh float32              `json:"targetHigh"`
+	Unit       apimodel.GlucoseUnit `json:"unit"`
+}
+
+// newTargetRangeResponse returns the target range of the user in the unit the user chose to see glucose values with
+func newTargetRangeResponse(glukitUser *model.GlukitUser) targetRangeResponse {
+	glucoseUnit := glukitUser.GetGlucoseUnit()
+	return targetRangeResponse{apimodel.GlucoseValue(glukitUser.TargetLow).In(glucoseUnit),
+		apimodel.GlucoseValue(glukitUser.TargetHigh).In(glucoseUnit), glucoseUnit}
+}
+
+// ProfileVariables are the values of the clinical profile form
+type ProfileVariables struct {
+	DiabetesType  string
+	DiagnosedDate string
+	TherapyMode   string
+	DiabetesTypes []string
+	TherapyModes  []string
+	// Api keys of the user and the full value of the one just created, only ever shown then
+	ApiKeys      []ApiKeyResponse
+	ApiKeyScopes []string
+	NewApiKey    string
+	// Webhook of the user, nil if never configured, and the events it can be subscribed to
+	Webhook       *model.Webhook
+	WebhookEvents []WebhookEventOption
+	// Grants of the user to others, grants of others to the user and the url of the invitation just created, only
+	// ever shown then
+	ShareGrants           []model.ShareGrant
+	SharedWithUser        []model.ShareGrant
+	SharePermissions      []string
+	NewShareInvitationUrl string
+	// Invitations of the user that haven't been accepted yet and haven't expired
+	ShareInvitations []ShareInvitationResponse
+	// Snapshots of the user that haven't expired
+	Snapshots []SnapshotResponse
+	// Email digest preferences of the user
+	EmailDigestEnabled     bool
+	EmailDigestNoDataNudge bool
+	// Url of the calendar feed of the user, empty if the user never got one
+	CalendarFeedUrl string
+	// Google Fit import setting of the user
+	GoogleFitEnabled bool
+	// Whether the user connected Dropbox as a source of data files
+	DropboxConnected bool
+	// Tidepool account of the user that imported data is exported to, nil if never connected
+	TidepoolAccount *model.TidepoolAccount
+	// Google accounts linked to the profile of the user
+	LinkedAccounts []model.AccountAlias
+	// Whether the user asked for the deletion of their account and has yet to confirm it
+	AccountDeletionPending bool
+}
+
+// SnapshotResponse is a snapshot of a user as listed on the profile page, along with its public url
+type SnapshotResponse struct {
+	Id  int64
+	Url string
+	model.Snapshot
+}
+
+// ShareInvitationResponse is a pending invitation of a user, as listed on the profile page and by the invitations
+// endpoint, along with the time until which it can be accepted
+type ShareInvitationResponse struct {
+	Id int64 `json:"id"`
+	model.ShareInvitation
+	ValidUntil time.Time `json:"validUntil"`
+}
+
+// ClinicReportVariables are the variables of the clinic report template. Glucose values are shown in Unit and the
+// bands and median line of the AGP chart are given as svg points.
+type ClinicReportVariables struct {
+	Report      *model.ClinicReport
+	Unit        apimodel.GlucoseUnit
+	Days        int
+	DayOptions  []int
+	OnBehalfOf  string
+	ChartWidth  int
+	ChartHeight int
+	OuterBand   string
+	InnerBand   string
+	MedianLine  string
+	TargetLowY  float64
+	TargetHighY float64
+	TargetRange string
+	LastDay     time.Time
+}
+
+// Glucose formats the value, in mg/dL, in the unit of the report with the precision meters show it with
+func (variables ClinicReportVariables) Glucose(value float64) string {
+	if variables.Unit == apimodel.MMOL_PER_L {
+		return fmt.Sprintf("%.1f", apimodel.GlucoseValue(value).In(variables.Unit))
+	}
+
+	return fmt.Sprintf("%.0f", value)
+}
+
+// SnapshotDataResponse is the data of a snapshot as served to anyone with its link, without anything identifying its
+// owner
+type SnapshotDataResponse struct {
+	Label string       `json:"label"`
+	From  time.Time    `json:"from"`
+	To    time.Time    `json:"to"`
+	Data  []DataSeries `json:"data"`
+}
+
+// WebhookEventOption is an event offered on the webhook form along with whether the webhook is subscribed to it
+type WebhookEventOption struct {
+	Event      string
+	Subscribed bool
+}
+
+// ApiKeyResponse is an api key of a user as listed by the api keys endpoint, without the key itself
+type ApiKeyResponse struct {
+	Id int64 `json:"id"`
+	model.ApiKey
+}
+
+// renderProfile executes the clinical profile form template with the values of the logged in user
+func renderProfile(writer http.ResponseWriter, request *http.Request) {
+	renderProfileWithSecrets(writer, request, "", "")
+}
+
+// renderProfileWithSecrets renders the profile page of the logged in user showing newApiKey and
+// newShareInvitationUrl, if not empty. Neither can be shown again since only their hashes are stored.
+func renderProfileWithSecrets(writer http.ResponseWriter, request *http.Request, newApiKey string, newShareInvitationUrl string) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	userProfileKey, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	apiKeys, err := getApiKeyResponses(context, userProfileKey)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	webhook, err := store.GetWebhook(context, userProfileKey)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		writeError(context, writer, err)
+		return
+	}
+
+	now := time.Now()
+	shareGrants, err := store.GetShareGrants(context, userProfileKey, now)
+	if err != nil {
+		writeError(context, writer, err)
+		return
 	}
+
+	sharedWithUser, err := store.GetGrantsTo(context, user.Email, now)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	shareInvitations, err := getShareInvitationResponses(context, userProfileKey, now)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	snapshotIds, snapshots, err := store.GetSnapshots(context, userProfileKey, now)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	snapshotResponses := make([]SnapshotResponse, len(snapshots))
+	for i := range snapshots {
+		snapshotResponses[i] = SnapshotResponse{snapshotIds[i], snapshotUrl(request, snapshots[i].Token), snapshots[i]}
+	}
+
+	tidepoolAccount, err := store.GetTidepoolAccount(context, userProfileKey)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		writeError(context, writer, err)
+		return
+	}
+
+	linkedAccounts, err := store.GetAccountAliases(context, glukitUser.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	accountDeletionPending, err := store.HasPendingAccountDeletion(context, userProfileKey, now)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	webhookEvents := make([]WebhookEventOption, len(model.WebhookEvents))
+	for i, event := range model.WebhookEvents {
+		webhookEvents[i] = WebhookEventOption{event, webhook != nil && webhook.IsSubscribed(event)}
+	}
+
+	profileVariables := &ProfileVariables{DiabetesType: glukitUser.DiabetesType, TherapyMode: glukitUser.TherapyMode,
+		DiabetesTypes: model.DiabetesTypes, TherapyModes: model.TherapyModes, ApiKeys: apiKeys, ApiKeyScopes: model.ApiKeyScopes,
+		NewApiKey: newApiKey, Webhook: webhook, WebhookEvents: webhookEvents, ShareGrants: shareGrants, SharedWithUser: sharedWithUser,
+		SharePermissions: model.SharePermissions, NewShareInvitationUrl: newShareInvitationUrl, ShareInvitations: shareInvitations,
+		Snapshots: snapshotResponses, EmailDigestEnabled: glukitUser.EmailDigestEnabled, EmailDigestNoDataNudge: glukitUser.EmailDigestNoDataNudge,
+		CalendarFeedUrl: calendarFeedUrl(request, glukitUser), GoogleFitEnabled: glukitUser.GoogleFitEnabled,
+		DropboxConnected: glukitUser.HasDropbox(), TidepoolAccount: tidepoolAccount, LinkedAccounts: linkedAccounts,
+		AccountDeletionPending: accountDeletionPending}
+	if !glukitUser.DiagnosedDate.IsZero() {
+		profileVariables.DiagnosedDate = glukitUser.DiagnosedDate.Format(FORM_DATE_LAYOUT)
+	}
+
+	writer.Header().Set("Content-Security-Policy", CONTENT_SECURITY_POLICY)
+	if err := profileTemplate.Execute(writer, profileVariables); err != nil {
+		log.Criticalf(context, "Error executing template [%s]", profileTemplate.Name())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// updateProfile is the endpoint to update the clinical profile of the logged in user. The type of diabetes and therapy
+// mode must be known values while an empty diagnosis date means it's unknown.
+func updateProfile(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	diabetesType, err := model.ParseDiabetesType(request.FormValue(FORM_FIELD_DIABETES_TYPE))
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_DIABETES_TYPE, err), 400)
+		return
+	}
+
+	therapyMode, err := model.ParseTherapyMode(request.FormValue(FORM_FIELD_THERAPY_MODE))
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_THERAPY_MODE, err), 400)
+		return
+	}
+
+	var diagnosedDate time.Time
+	if param := request.FormValue(FORM_FIELD_DIAGNOSED_DATE); len(param) > 0 {
+		diagnosedDate, err = time.Parse(FORM_DATE_LAYOUT, param)
+		if err != nil || diagnosedDate.After(time.Now()) {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s], expected a past date formatted as %s.", FORM_FIELD_DIAGNOSED_DATE,
+				param, FORM_DATE_LAYOUT), 400)
+			return
+		}
+	}
+
+	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	glukitUser.DiabetesType, glukitUser.DiagnosedDate, glukitUser.TherapyMode = diabetesType, diagnosedDate, therapyMode
+	if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Updated clinical profile of user [%s] to [%s, %s, %s]", user.Email, diabetesType, diagnosedDate, therapyMode)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// updateTargetRange is the endpoint to update the target range of the logged in user. Bounds are given in the unit the
+// user chose to see glucose values with and stored in mg/dL. Stats stored for the previous range aren't used anymore
+// and the recalculation endpoint refreshes them along with scores.
+func updateTargetRange(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	glucoseUnit := glukitUser.GetGlucoseUnit()
+
+	bounds := make(map[string]float32)
+	for _, field := range []string{FORM_FIELD_TARGET_LOW, FORM_FIELD_TARGET_HIGH} {
+		value, err := strconv.ParseFloat(request.FormValue(field), 32)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", field, err), 400)
+			return
+		}
+		bounds[field] = float32(apimodel.GlucoseValueIn(float32(value), glucoseUnit))
+	}
+
+	targetLow, targetHigh := bounds[FORM_FIELD_TARGET_LOW], bounds[FORM_FIELD_TARGET_HIGH]
+	if targetLow < model.MIN_TARGET_GLUCOSE || targetHigh > model.MAX_TARGET_GLUCOSE || targetLow >= targetHigh {
+		http.Error(writer, fmt.Sprintf("Invalid target range [%.1f, %.1f], expected %s < %s between %.1f and %.1f [%s].",
+			apimodel.GlucoseValue(targetLow).In(glucoseUnit), apimodel.GlucoseValue(targetHigh).In(glucoseUnit),
+			FORM_FIELD_TARGET_LOW, FORM_FIELD_TARGET_HIGH, apimodel.GlucoseValue(model.MIN_TARGET_GLUCOSE).In(glucoseUnit),
+			apimodel.GlucoseValue(model.MAX_TARGET_GLUCOSE).In(glucoseUnit), glucoseUnit), 400)
+		return
+	}
+
+	glukitUser.TargetLow, glukitUser.TargetHigh = targetLow, targetHigh
+	if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Updated target range of user [%s] to [%f, %f]", user.Email, targetLow, targetHigh)
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(newTargetRangeResponse(glukitUser))
+}
+
+// updateGlucoseUnit is the endpoint to update the unit the logged in user sees glucose values with. Values are always
+// stored in mg/dL so nothing needs to be recalculated. The target range is returned in the new unit.
+func updateGlucoseUnit(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	glucoseUnit, err := apimodel.ParseGlucoseUnit(request.FormValue(GLUCOSE_UNIT_PARAMETER))
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", GLUCOSE_UNIT_PARAMETER, err), 400)
+		return
+	}
+
+	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	glukitUser.GlucoseUnit = glucoseUnit
+	if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Updated glucose unit of user [%s] to [%s]", user.Email, glucoseUnit)
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(newTargetRangeResponse(glukitUser))
+}
+
+type refreshSettingsResponse struct {
+	IntervalHours int        `json:"intervalHours"`
+	Paused        bool       `json:"paused"`
+	BackedOff     bool       `json:"backedOff"`
+	NextRefresh   *time.Time `json:"nextRefresh,omitempty"`
+}
+
+// newRefreshSettingsResponse returns the refresh settings of the user along with the time of the next refresh, if any
+func newRefreshSettingsResponse(glukitUser *model.GlukitUser) refreshSettingsResponse {
+	response := refreshSettingsResponse{int(glukitUser.GetRefreshInterval() / time.Hour), glukitUser.RefreshPaused,
+		glukitUser.IsRefreshBackedOff(), nil}
+	if !glukitUser.NextRefresh.IsZero() {
+		response.NextRefresh = &glukitUser.NextRefresh
+	}
+
+	return response
+}
+
+// updateRefreshSettings is the endpoint to change the interval of the background refreshes of the logged in user's data
+// and to pause or resume them. Both fields are optional. Resuming refreshes restores the configured interval and
+// schedules a refresh right away if none is pending.
+func updateRefreshSettings(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if value := request.FormValue(FORM_FIELD_REFRESH_INTERVAL_HOURS); len(value) > 0 {
+		hours, err := strconv.Atoi(value)
+		interval := time.Duration(hours) * time.Hour
+		if err != nil || interval < model.MIN_REFRESH_INTERVAL || interval > model.MAX_REFRESH_INTERVAL {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s], expected a number of hours between [%d] and [%d].",
+				FORM_FIELD_REFRESH_INTERVAL_HOURS, value, model.MIN_REFRESH_INTERVAL/time.Hour, model.MAX_REFRESH_INTERVAL/time.Hour), 400)
+			return
+		}
+		glukitUser.RefreshInterval = interval
+	}
+
+	scheduleRefresh := false
+	if value := request.FormValue(FORM_FIELD_REFRESH_PAUSED); len(value) > 0 {
+		paused, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_REFRESH_PAUSED, err), 400)
+			return
+		}
+
+		if glukitUser.RefreshPaused && !paused {
+			glukitUser.EmptyRefreshes = 0
+			// The scheduled refresh of a paused user stops the refreshes when it runs, unless it hasn't run yet
+			if glukitUser.NextRefresh.IsZero() {
+				glukitUser.NextRefresh = time.Now()
+				scheduleRefresh = true
+			}
+		}
+		glukitUser.RefreshPaused = paused
+	}
+
+	if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Updated refresh settings of user [%s] to interval [%s] and paused [%t]", user.Email,
+		glukitUser.GetRefreshInterval(), glukitUser.RefreshPaused)
+
+	if scheduleRefresh {
+		if err := enqueueRefresh(context, user.Email, true, glukitUser.NextRefresh, REFRESH_TRIGGER_MANUAL); err != nil {
+			writeError(context, writer, err)
+			return
+		}
+		log.Infof(context, "Resumed refreshes of user [%s]", user.Email)
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(newRefreshSettingsResponse(glukitUser))
+}
+
+// recalculate is the endpoint to recalculate all glukit scores and a1c estimates of the logged in user for periods ending on or after
+// the from parameter (unix timestamp), defaulting to the beginning of the user's data. The recalculation runs in the background
+// and reports its progress over the user's channel.
+func recalculate(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	glukitUser, _, _, err := store.GetUserData(context, user.Email)
+	if err == store.ErrNoImportedDataFound {
+		http.Error(writer, "No data to recalculate scores from.", 400)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	var from time.Time
+	if fromParam := request.FormValue(QUERY_PARAM_FROM); len(fromParam) > 0 {
+		fromValue, err := strconv.ParseInt(fromParam, 10, 64)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_FROM, err), 400)
+			return
+		}
+		from = time.Unix(fromValue, 0)
+	} else if from, err = store.GetFirstReadTime(context, user.Email); err == store.ErrNoImportedDataFound {
+		http.Error(writer, "No data to recalculate scores from.", 400)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if err := engine.StartRecalculation(context, glukitUser, from); err == store.ErrRecalculationInProgress {
+		http.Error(writer, "A recalculation is already in progress.", http.StatusConflict)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	log.Infof(context, "Started recalculation of scores for user [%s] from [%s]", user.Email, from)
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// startSchemaMigration is the admin endpoint that starts the rewrite of the day entities of a user stored with an older
+// schema version
+func startSchemaMigration(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	userEmail := request.FormValue(FORM_FIELD_USER_EMAIL)
+	if len(userEmail) == 0 {
+		http.Error(writer, fmt.Sprintf("Missing value for %s.", FORM_FIELD_USER_EMAIL), 400)
+		return
+	}
+
+	if _, _, err := store.GetGlukitUser(context, userEmail); err == datastore.ErrNoSuchEntity {
+		http.Error(writer, fmt.Sprintf("No user [%s].", userEmail), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if err := enqueueSchemaMigration(context, userEmail, 0, ""); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	log.Infof(context, "Started schema migration for user [%s]", userEmail)
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// startTokenSealing is the admin endpoint that starts the encryption of the stored tokens of all users with the current
+// token encryption key. It's run once to encrypt the tokens stored in plaintext and again after every key rotation,
+// before the previous key is removed.
+func startTokenSealing(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	if err := enqueueTokenSealing(context, ""); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	log.Infof(context, "Started encryption of stored tokens with key [%s]", appConfig.TokenKeyRing.CurrentKeyId)
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// forceRefresh is the admin endpoint that refreshes the data of a user right away, regardless of scheduled refreshes
+// and of the user pausing them. It doesn't change the schedule of the user's refreshes.
+func forceRefresh(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	userEmail := request.FormValue(FORM_FIELD_USER_EMAIL)
+	if len(userEmail) == 0 {
+		http.Error(writer, fmt.Sprintf("Missing value for %s.", FORM_FIELD_USER_EMAIL), 400)
+		return
+	}
+
+	if _, _, err := store.GetGlukitUser(context, userEmail); err == datastore.ErrNoSuchEntity {
+		http.Error(writer, fmt.Sprintf("No user [%s].", userEmail), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if err := enqueueRefresh(context, userEmail, false, time.Now(), REFRESH_TRIGGER_MANUAL); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	log.Infof(context, "Forced refresh of user [%s]", userEmail)
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// scheduleRefreshes is the daily cron endpoint that starts the scheduling of refreshes of users that fell out of their
+// chain of scheduled refreshes, see scheduleRefreshesBatch
+func scheduleRefreshes(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	if err := enqueueRefreshScheduling(context, "", time.Now()); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	log.Infof(context, "Started scheduling of overdue refreshes")
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// startIntegrityCheck is the admin endpoint that starts the integrity check of the reads of a user between the from and
+// to dates, both inclusive. With repair, overlapping days of reads are merged.
+func startIntegrityCheck(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	userEmail := request.FormValue(FORM_FIELD_USER_EMAIL)
+	if len(userEmail) == 0 {
+		http.Error(writer, fmt.Sprintf("Missing value for %s.", FORM_FIELD_USER_EMAIL), 400)
+		return
+	}
+
+	bounds := make([]time.Time, 2)
+	for i, field := range []string{FORM_FIELD_INTEGRITY_FROM, FORM_FIELD_INTEGRITY_TO} {
+		value, err := time.Parse(FORM_DATE_LAYOUT, request.FormValue(field))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s], expected a date formatted as %s.", field,
+				request.FormValue(field), FORM_DATE_LAYOUT), 400)
+			return
+		}
+		bounds[i] = value
+	}
+	lowerBound, upperBound := bounds[0], bounds[1].Add(time.Duration(24)*time.Hour-time.Nanosecond)
+
+	if upperBound.Before(lowerBound) {
+		http.Error(writer, fmt.Sprintf("Value of %s must not be before the one of %s.", FORM_FIELD_INTEGRITY_TO, FORM_FIELD_INTEGRITY_FROM), 400)
+		return
+	}
+
+	repair := false
+	if param := request.FormValue(FORM_FIELD_INTEGRITY_REPAIR); len(param) > 0 {
+		value, err := strconv.ParseBool(param)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_INTEGRITY_REPAIR, err), 400)
+			return
+		}
+		repair = value
+	}
+
+	if _, _, err := store.GetGlukitUser(context, userEmail); err == datastore.ErrNoSuchEntity {
+		http.Error(writer, fmt.Sprintf("No user [%s].", userEmail), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if err := enqueueIntegrityCheck(context, userEmail, lowerBound, upperBound, repair); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	log.Infof(context, "Started integrity check of user [%s] between [%s] and [%s] with repair [%t]", userEmail,
+		lowerBound.Format(util.TIMEFORMAT), upperBound.Format(util.TIMEFORMAT), repair)
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// integrityReportResponse is an integrity report along with its id
+type integrityReportResponse struct {
+	Id int64 `json:"id"`
+	model.IntegrityReport
+}
+
+// integrityReports is the admin endpoint to list the most recent integrity reports, of all users or of the user of the
+// email parameter
+func integrityReports(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+
+	limit := DEFAULT_INTEGRITY_REPORTS_LIMIT
+	if limitParam := request.FormValue(QUERY_PARAM_LIMIT); len(limitParam) > 0 {
+		limitValue, err := strconv.ParseInt(limitParam, 10, 32)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_LIMIT, err), 400)
+			return
+		}
+		limit = int(limitValue)
+	}
+
+	ids, reports, err := store.GetIntegrityReports(context, request.FormValue(FORM_FIELD_USER_EMAIL), limit)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	response := make([]integrityReportResponse, len(reports))
+	for i := range reports {
+		response[i] = integrityReportResponse{ids[i], reports[i]}
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(response)
+}
+
+// resetDemo is the admin endpoint that wipes the data of the demo user and seeds it again
+func resetDemo(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	if err := enqueueDemoReseed(context, true); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	log.Infof(context, "Started reset of demo user [%s]", DEMO_EMAIL)
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// updateAnalyticsExport is the admin endpoint to flip the kill switch of the analytics export. Imports started after
+// the export is disabled aren't exported and rows waiting to be inserted are dropped.
+func updateAnalyticsExport(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	disabled, err := strconv.ParseBool(request.FormValue(FORM_FIELD_ANALYTICS_DISABLED))
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_ANALYTICS_DISABLED, err), 400)
+		return
+	}
+
+	settings, err := analytics.SetDisabled(context, disabled)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	log.Infof(context, "Set analytics export disabled to [%t]", settings.Disabled)
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(map[string]bool{"disabled": settings.Disabled})
+}
+
+// createAnalyticsTable is the admin endpoint that creates the BigQuery table of the analytics export
+func createAnalyticsTable(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	if err := analytics.CreateTable(context); err != nil {
+		http.Error(writer, fmt.Sprintf("Error creating the analytics table: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	writer.WriteHeader(http.StatusCreated)
+}
+
+// sendEmailDigests is the weekly cron endpoint that starts sending the email digests of the week that just ended to
+// the users who opted in, see sendEmailDigestsBatch
+func sendEmailDigests(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	if err := enqueueEmailDigests(context, "", time.Now()); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	log.Infof(context, "Started sending email digests")
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// refreshDemo is the daily cron endpoint that reseeds the data of the demo user so that it ends yesterday
+func refreshDemo(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	if err := enqueueDemoReseed(context, false); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// failedTaskResponse is a failed task along with the id to requeue it with
+type failedTaskResponse struct {
+	Id int64 `json:"id"`
+	model.FailedTask
+}
+
+// failedTasks is the admin endpoint to list the most recent tasks that failed their last attempt, of all users or of
+// the user of the email parameter
+func failedTasks(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+
+	limit := DEFAULT_FAILED_TASKS_LIMIT
+	if limitParam := request.FormValue(QUERY_PARAM_LIMIT); len(limitParam) > 0 {
+		limitValue, err := strconv.ParseInt(limitParam, 10, 32)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_LIMIT, err), 400)
+			return
+		}
+		limit = int(limitValue)
+	}
+
+	ids, tasks, err := store.GetFailedTasks(context, request.FormValue(FORM_FIELD_USER_EMAIL), limit)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	response := make([]failedTaskResponse, len(tasks))
+	for i := range tasks {
+		response[i] = failedTaskResponse{ids[i], tasks[i]}
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(response)
+}
+
+// requeueTask is the admin endpoint to enqueue the work of a failed task again
+func requeueTask(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+
+	id, err := strconv.ParseInt(mux.Vars(request)[PATH_VAR_FAILED_TASK_ID], 10, 64)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid failed task id: [%v].", err), 400)
+		return
+	}
+
+	failedTask, err := store.GetFailedTask(context, id)
+	if err == datastore.ErrNoSuchEntity {
+		http.Error(writer, fmt.Sprintf("No failed task [%d].", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if err := requeueFailedTask(context, failedTask); err != nil {
+		http.Error(writer, fmt.Sprintf("Error requeuing failed task [%d]: [%v].", id, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := store.MarkFailedTaskRequeued(context, id, time.Now()); err != nil {
+		log.Warningf(context, "Error marking failed task [%d] as requeued: %v", id, err)
+	}
+
+	log.Infof(context, "Requeued failed task [%d] of [%s] for user [%s]", id, failedTask.Function, failedTask.UserEmail)
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// fileImports is the endpoint to list the most recent file imports of the logged in user along with their result. At
+// most MAX_FILE_IMPORTS_LIMIT imports are listed, see parseFileImportsLimit.
+func fileImports(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
+
+	limit, err := parseFileImportsLimit(request)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	imports, err := store.GetFileImportLogs(context, store.GetUserKey(context, email), limit)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(imports)
+}
+
+// parseFileImportsLimit returns the number of file imports to list given by the limit parameter, DEFAULT_FILE_IMPORTS_LIMIT
+// if there's none. Limits over MAX_FILE_IMPORTS_LIMIT are lowered to it and limits that aren't positive numbers are
+// invalid.
+func parseFileImportsLimit(request *http.Request) (limit int, err error) {
+	limitParam := request.FormValue(QUERY_PARAM_LIMIT)
+	if len(limitParam) == 0 {
+		return DEFAULT_FILE_IMPORTS_LIMIT, nil
+	}
+
+	limitValue, err := strconv.ParseInt(limitParam, 10, 32)
+	if err != nil || limitValue < 1 {
+		return 0, errors.New(fmt.Sprintf("Invalid value for %s: [%s], expected a positive number.", QUERY_PARAM_LIMIT, limitParam))
+	}
+
+	if limitValue > MAX_FILE_IMPORTS_LIMIT {
+		return MAX_FILE_IMPORTS_LIMIT, nil
+	}
+
+	return int(limitValue), nil
+}
+
+// validateImport is the endpoint to validate a file without importing it. The file is uploaded as a multipart form
+// and fully parsed but nothing is written to the datastore. Content that can't be imported gets the report of what
+// was parsed along with the error.
+func validateImport(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+
+	if request.Method != "POST" {
+		http.Error(writer, "Upload the file to validate with a POST.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := request.ParseMultipartForm(MAX_VALIDATION_MEMORY); err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid upload: [%v].", err), 400)
+		return
+	}
+
+	file, header, err := request.FormFile(FORM_FIELD_FILE)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Missing file to validate in field %s: [%v].", FORM_FIELD_FILE, err), 400)
+		return
+	}
+	defer file.Close()
+
+	report, err := importer.ValidateContent(context, file, header.Filename)
+	validation := ImportValidation{report, ""}
+	if err != nil {
+		if !importer.IsDataError(err) {
+			writeError(context, writer, err)
+			return
+		}
+		log.Infof(context, "Validation of file [%s] for user [%s] failed: %v", header.Filename, currentGlukitUser(context).Email, err)
+		validation.Error = err.Error()
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(validation)
+}
+
+func newScanQuery(request *http.Request) (scanQuery *store.ScoreScanQuery, err error) {
+	limit := request.FormValue(QUERY_PARAM_LIMIT)
+	fromTimestamp := request.FormValue(QUERY_PARAM_FROM)
+	toTimestamp := request.FormValue(QUERY_PARAM_TO)
+
+	scanQuery = new(store.ScoreScanQuery)
+	// The request must include at least one of from/to/limit to be considered valid
+	// as leaving it too open would could open the door for costly queries
+	if len(limit) == 0 && len(fromTimestamp) == 0 && len(toTimestamp) == 0 {
+		return nil, errors.New(fmt.Sprintf("Query must specify at least one of: %s, %s or %s.",
+			QUERY_PARAM_LIMIT, QUERY_PARAM_FROM, QUERY_PARAM_TO))
+	}
+
+	if len(limit) > 0 {
+		if limitValue, err := strconv.ParseInt(limit, 10, 32); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_LIMIT, err))
+		} else {
+			limitVal := int(limitValue)
+			scanQuery.Limit = &limitVal
+		}
+	}
+
+	if len(fromTimestamp) > 0 {
+		if fromValue, err := strconv.ParseInt(fromTimestamp, 10, 64); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_FROM, err))
+		} else {
+			fromTime := time.Unix(fromValue, 0)
+			scanQuery.From = &fromTime
+		}
+	}
+
+	if len(toTimestamp) > 0 {
+		if toValue, err := strconv.ParseInt(toTimestamp, 10, 64); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_TO, err))
+		} else {
+			toTime := time.Unix(toValue, 0)
+			scanQuery.To = &toTime
+		}
+	}
+
+	return scanQuery, nil
+}
+
+func handleDonation(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	request.ParseForm()
+	token := request.FormValue(payment.STRIPE_TOKEN)
+	amountInCentsVal := request.FormValue(payment.DONATION_AMOUNT)
+
+	stripeClient := payment.NewStripeClient(appConfig)
+	err := stripeClient.SubmitDonation(context, token, amountInCentsVal)
+	if err != nil {
+		log.Warningf(context, "Error processing donation from [%v] of [%d] cents with token [%s]: [%v]", user, amountInCentsVal, token, err)
+		writer.WriteHeader(502)
+	} else {
+		writer.WriteHeader(200)
+	}
+}
+
+// createNote is the endpoint to add a note to the data of the logged in user. The created note is returned with its id.
+func createNote(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	timestamp, err := strconv.ParseInt(request.FormValue(FORM_FIELD_NOTE_TIME), 10, 64)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_NOTE_TIME, err), 400)
+		return
+	}
+
+	timezone := request.FormValue(FORM_FIELD_NOTE_TIMEZONE)
+	if _, err := util.GetOrLoadLocationForName(timezone); err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_NOTE_TIMEZONE, err), 400)
+		return
+	}
+
+	text, tag, err := parseNoteContent(request)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	note := apimodel.Note{"", apimodel.Time{apimodel.GetTimeMillis(time.Unix(timestamp, 0)), timezone}, text, tag}
+	note, err = store.StoreNote(context, store.GetUserKey(context, user.Email), note)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Created note [%v] for user [%s]", note, user.Email)
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(note)
+}
+
+// updateNote is the endpoint to change the text and tag of a note of the logged in user
+func updateNote(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	text, tag, err := parseNoteContent(request)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	note := apimodel.Note{Id: request.FormValue(FORM_FIELD_NOTE_ID), Text: text, Tag: tag}
+	if err := store.UpdateNote(context, store.GetUserKey(context, user.Email), note); err == store.ErrNoteNotFound {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+	log.Infof(context, "Updated note [%s] of user [%s]", note.Id, user.Email)
+}
+
+// deleteNote is the endpoint to delete a note of the logged in user
+func deleteNote(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	id := request.FormValue(FORM_FIELD_NOTE_ID)
+	if err := store.DeleteNote(context, store.GetUserKey(context, user.Email), id); err == store.ErrNoteNotFound {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+	log.Infof(context, "Deleted note [%s] of user [%s]", id, user.Email)
+}
+
+// parseNoteContent returns the text and tag of the note of the request. The text is required while the tag is optional.
+func parseNoteContent(request *http.Request) (text string, tag apimodel.NoteTag, err error) {
+	text = strings.TrimSpace(request.FormValue(FORM_FIELD_NOTE_TEXT))
+	if len(text) == 0 {
+		return "", apimodel.NOTE_TAG_NONE, errors.New(fmt.Sprintf("Missing value for %s.", FORM_FIELD_NOTE_TEXT))
+	}
+
+	tag, err = apimodel.ParseNoteTag(request.FormValue(FORM_FIELD_NOTE_TAG))
+	if err != nil {
+		return "", apimodel.NOTE_TAG_NONE, errors.New(fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_NOTE_TAG, err))
+	}
+
+	return text, tag, nil
+}
+
+// updateMeal is the endpoint to correct a meal of the logged in user. Nutrients are replaced by the ones of the form,
+// missing ones being unknown, while the time of the meal is kept.
+func updateMeal(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	meal, err := parseMealContent(request)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	meal.Id = mux.Vars(request)[PATH_VAR_EVENT_ID]
+	if err := store.UpdateMeal(context, store.GetUserKey(context, user.Email), meal); err == store.ErrEventNotFound {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+	log.Infof(context, "Updated meal [%s] of user [%s]", meal.Id, user.Email)
+}
+
+// deleteMeal is the endpoint to delete a meal of the logged in user
+func deleteMeal(writer http.ResponseWriter, request *http.Request) {
+	deleteEvent(writer, request, "meal", store.DeleteMeal)
+}
+
+// deleteInjection is the endpoint to delete an injection of the logged in user
+func deleteInjection(writer http.ResponseWriter, request *http.Request) {
+	deleteEvent(writer, request, "injection", store.DeleteInjection)
+}
+
+// deleteExercise is the endpoint to delete an exercise of the logged in user
+func deleteExercise(writer http.ResponseWriter, request *http.Request) {
+	deleteEvent(writer, request, "exercise", store.DeleteExercise)
+}
+
+// deleteEvent deletes the event of the logged in user with the id of the request path using the given store function
+func deleteEvent(writer http.ResponseWriter, request *http.Request, kind string,
+	deleter func(context context.Context, userProfileKey *datastore.Key, id string) error) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	id := mux.Vars(request)[PATH_VAR_EVENT_ID]
+	if err := deleter(context, store.GetUserKey(context, user.Email), id); err == store.ErrEventNotFound {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+	log.Infof(context, "Deleted %s [%s] of user [%s]", kind, id, user.Email)
+}
+
+// parseMealContent returns the meal of the request. Carbs are required while other nutrients and the description are
+// optional.
+func parseMealContent(request *http.Request) (meal apimodel.Meal, err error) {
+	nutrients := []struct {
+		field    string
+		value    *float32
+		required bool
+	}{
+		{FORM_FIELD_MEAL_CARBS, &meal.Carbs, true},
+		{FORM_FIELD_MEAL_PROTEIN, &meal.Protein, false},
+		{FORM_FIELD_MEAL_FAT, &meal.Fat, false},
+		{FORM_FIELD_MEAL_SATURATED_FAT, &meal.SaturatedFat, false},
+		{FORM_FIELD_MEAL_FIBER, &meal.Fiber, false},
+	}
+	for _, nutrient := range nutrients {
+		param := request.FormValue(nutrient.field)
+		if len(param) == 0 && !nutrient.required {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(param, 32)
+		if err != nil || value < 0 {
+			return meal, errors.New(fmt.Sprintf("Invalid value for %s: [%s].", nutrient.field, param))
+		}
+		*nutrient.value = float32(value)
+	}
+
+	meal.Description = strings.TrimSpace(request.FormValue(FORM_FIELD_MEAL_DESCRIPTION))
+	return meal, nil
+}
+
+// exportCsv is the endpoint to download the data of the logged in user between the from and to dates, formatted as
+// FORM_DATE_LAYOUT in the user's timezone or as RFC3339 times and both included, defaulting to the last
+// DEFAULT_EXPORT_DAYS days. The
+// kinds parameter is a comma-separated list of the kinds of data exported, all of them by default. The response is a
+// zip archive with a csv file per kind whose header row has these columns:
+//
+//	reads.csv:      time, unix_time, glucose, unit
+//	meals.csv:      time, unix_time, carbs, protein, fat, saturated_fat, fiber, description
+//	injections.csv: time, unix_time, units, insulin_name, insulin_type, category
+//	exercises.csv:  time, unix_time, duration_minutes, intensity, description
+//
+// Times are formatted as export.TIME_COLUMN_LAYOUT in the user's timezone and glucose values are in the unit of the
+// unit parameter, defaulting to the user's. Columns are only ever added at the end of rows. Data is streamed one
+// EXPORT_WINDOW at a time so that exports of many months don't have to fit in memory.
+func exportCsv(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
+
+	_, glukitUser, err := store.GetGlukitUser(context, email)
+	if err == datastore.ErrNoSuchEntity {
+		http.Error(writer, fmt.Sprintf("No data for user [%s].", email), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	location := engine.UserLocation(glukitUser)
+	now := time.Now().In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	period, err := model.ParseTimeRangeOr(request.FormValue(FORM_FIELD_EXPORT_FROM), request.FormValue(FORM_FIELD_EXPORT_TO),
+		location, model.TimeRange{today.AddDate(0, 0, 1-DEFAULT_EXPORT_DAYS), today.AddDate(0, 0, 1).Add(-time.Nanosecond)})
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	kinds, err := export.ParseKinds(request.FormValue(FORM_FIELD_EXPORT_KINDS))
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_EXPORT_KINDS, err), 400)
+		return
+	}
+
+	unitValue, err := resolveGlucoseUnit(email, request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/zip")
+	value.Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"glukit-%s-to-%s.zip\"",
+		period.LowerBound.In(location).Format(FORM_DATE_LAYOUT), period.UpperBound.In(location).Format(FORM_DATE_LAYOUT)))
+
+	archive := zip.NewWriter(writer)
+	for _, kind := range kinds {
+		if err := exportKind(context, archive, writer, kind, email, period, location, *unitValue); err != nil {
+			// Part of the response is already sent, leaving the archive unclosed lets the client know it's incomplete
+			log.Errorf(context, "Error exporting [%s] of user [%s] between [%s] and [%s]: %v", kind, email,
+				period.LowerBound.Format(util.TIMEFORMAT), period.UpperBound.Format(util.TIMEFORMAT), err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Errorf(context, "Error completing the export of user [%s]: %v", email, err)
+	}
+}
+
+// exportKind adds the csv file of a kind of data of the period to the archive, flushing it to the writer after each
+// EXPORT_WINDOW of data
+func exportKind(context context.Context, archive *zip.Writer, writer http.ResponseWriter, kind export.Kind, email string,
+	period model.TimeRange, location *time.Location, unit apimodel.GlucoseUnit) error {
+	file, err := archive.Create(kind.FileName())
+	if err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(file)
+	if err := kind.WriteHeader(csvWriter); err != nil {
+		return err
+	}
+
+	// Both bounds of the store queries are inclusive, as are the ones of the split windows
+	for _, window := range period.Split(EXPORT_WINDOW) {
+		if err := exportWindow(context, csvWriter, kind, email, window.LowerBound, window.UpperBound, location, unit); err != nil {
+			return err
+		}
+
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return err
+		}
+
+		if err := archive.Flush(); err != nil {
+			return err
+		}
+
+		if flusher, ok := writer.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+
+	return nil
+}
+
+// exportWindow writes the rows of a kind of data between lowerBound and upperBound
+func exportWindow(context context.Context, csvWriter *csv.Writer, kind export.Kind, email string, lowerBound time.Time,
+	upperBound time.Time, location *time.Location, unit apimodel.GlucoseUnit) error {
+	switch kind {
+	case export.KIND_READS:
+		reads, err := store.GetGlucoseReads(context, email, lowerBound, upperBound)
+		if err != nil {
+			return err
+		}
+		return export.WriteGlucoseReads(csvWriter, reads, location, unit)
+	case export.KIND_MEALS:
+		meals, err := store.GetMeals(context, email, lowerBound, upperBound)
+		if err != nil {
+			return err
+		}
+		return export.WriteMeals(csvWriter, meals, location)
+	case export.KIND_INJECTIONS:
+		injections, err := store.GetInjections(context, email, lowerBound, upperBound)
+		if err != nil {
+			return err
+		}
+		return export.WriteInjections(csvWriter, injections, location)
+	case export.KIND_EXERCISES:
+		exercises, err := store.GetExercises(context, email, lowerBound, upperBound)
+		if err != nil {
+			return err
+		}
+		return export.WriteExercises(csvWriter, exercises, location)
+	default:
+		return errors.New(fmt.Sprintf("Unknown kind of data [%s]", kind))
+	}
+}
+
+// getApiKeyResponses returns the api keys of the user along with their ids
+func getApiKeyResponses(context context.Context, userProfileKey *datastore.Key) (responses []ApiKeyResponse, err error) {
+	ids, apiKeys, err := store.GetApiKeys(context, userProfileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	responses = make([]ApiKeyResponse, len(apiKeys))
+	for i := range apiKeys {
+		responses[i] = ApiKeyResponse{ids[i], apiKeys[i]}
+	}
+
+	return responses, nil
+}
+
+// apiKeys is the endpoint to list the api keys of the logged in user. Keys themselves are never listed, only the
+// first characters of each to tell them apart.
+func apiKeys(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	responses, err := getApiKeyResponses(context, store.GetUserKey(context, user.Email))
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(responses)
+}
+
+// createApiKey is the endpoint to create an api key of the logged in user with a label and at least one scope. The
+// profile page is rendered with the new key, which is the only time it is shown.
+func createApiKey(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	label := strings.TrimSpace(request.FormValue(FORM_FIELD_API_KEY_LABEL))
+	if len(label) == 0 || len(label) > MAX_API_KEY_LABEL_LENGTH {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s], expected 1 to %d characters.", FORM_FIELD_API_KEY_LABEL, label,
+			MAX_API_KEY_LABEL_LENGTH), 400)
+		return
+	}
+
+	request.ParseForm()
+	scopes := make([]string, 0)
+	for _, param := range request.Form[FORM_FIELD_API_KEY_SCOPE] {
+		scope, err := model.ParseApiKeyScope(param)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_API_KEY_SCOPE, err), 400)
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+
+	if len(scopes) == 0 {
+		http.Error(writer, fmt.Sprintf("Missing value for %s.", FORM_FIELD_API_KEY_SCOPE), 400)
+		return
+	}
+
+	key, apiKey, err := model.NewApiKey(label, scopes, time.Now())
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if _, err := store.StoreApiKey(context, store.GetUserKey(context, user.Email), apiKey); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Created api key [%s] of user [%s] with scopes %v", apiKey.Prefix, user.Email, scopes)
+
+	renderProfileWithSecrets(writer, request, key, "")
+}
+
+// revokeApiKey is the endpoint to revoke an api key of the logged in user, which stops working right away
+func revokeApiKey(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	id, err := strconv.ParseInt(mux.Vars(request)[PATH_VAR_API_KEY_ID], 10, 64)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid api key id [%s].", mux.Vars(request)[PATH_VAR_API_KEY_ID]), 400)
+		return
+	}
+
+	if err := store.DeleteApiKey(context, store.GetUserKey(context, user.Email), id); err == store.ErrApiKeyNotFound {
+		http.Error(writer, fmt.Sprintf("No api key [%d].", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Revoked api key [%d] of user [%s]", id, user.Email)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// updateWebhook is the endpoint to update the webhook settings of the logged in user. The url must be an absolute http
+// or https url and a secret is required to enable the webhook since deliveries are signed with it.
+func updateWebhook(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+	userProfileKey := store.GetUserKey(context, user.Email)
+
+	webhookUrl := strings.TrimSpace(request.FormValue(FORM_FIELD_WEBHOOK_URL))
+	if parsed, err := url.Parse(webhookUrl); err != nil || len(webhookUrl) > MAX_WEBHOOK_URL_LENGTH || !parsed.IsAbs() ||
+		(parsed.Scheme != "http" && parsed.Scheme != "https") {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s], expected an http or https url.", FORM_FIELD_WEBHOOK_URL, webhookUrl), 400)
+		return
+	}
+
+	request.ParseForm()
+	events := make([]string, 0)
+	for _, param := range request.Form[FORM_FIELD_WEBHOOK_EVENT] {
+		event, err := model.ParseWebhookEvent(param)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_WEBHOOK_EVENT, err), 400)
+			return
+		}
+		events = append(events, event)
+	}
+
+	secret := request.FormValue(FORM_FIELD_WEBHOOK_SECRET)
+	if len(secret) == 0 {
+		if webhook, err := store.GetWebhook(context, userProfileKey); err == nil {
+			secret = webhook.Secret
+		} else if err != datastore.ErrNoSuchEntity {
+			writeError(context, writer, err)
+			return
+		}
+	}
+
+	enabled := request.FormValue(FORM_FIELD_WEBHOOK_ENABLED) != ""
+	if enabled && len(secret) == 0 {
+		http.Error(writer, fmt.Sprintf("Missing value for %s, required to enable the webhook.", FORM_FIELD_WEBHOOK_SECRET), 400)
+		return
+	}
+
+	settings := model.Webhook{Url: webhookUrl, Secret: secret, Enabled: enabled, Events: events}
+	if err := store.StoreWebhookSettings(context, userProfileKey, settings); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Updated webhook of user [%s] to [%s], enabled [%t] for events %v", user.Email, webhookUrl, enabled, events)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// updateEmailDigest is the endpoint to update the email digest preferences of the logged in user
+func updateEmailDigest(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	enabled := request.FormValue(FORM_FIELD_EMAIL_DIGEST_ENABLED) != ""
+	noDataNudge := request.FormValue(FORM_FIELD_EMAIL_DIGEST_NO_DATA_NUDGE) != ""
+	if err := glukitUser.SetEmailDigest(enabled, noDataNudge); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Updated email digest of user [%s] to enabled [%t], no data nudge [%t]", user.Email, enabled, noDataNudge)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// updateGoogleFit is the endpoint to turn the import of Google Fit sessions of the logged in user on or off. Users who
+// never granted the Fit scope are sent to authorize it.
+func updateGoogleFit(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	glukitUser.GoogleFitEnabled = request.FormValue(FORM_FIELD_GOOGLE_FIT_ENABLED) != ""
+	if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Updated google fit import of user [%s] to enabled [%t]", user.Email, glukitUser.GoogleFitEnabled)
+
+	if glukitUser.NeedsGoogleFitAuthorization() {
+		http.Redirect(writer, request, "/googleauth", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// unsubscribeEmailDigest is the endpoint of the signed unsubscribe links of email digests. It turns off the digest of
+// the user of the link without requiring a login. Invalid links are rejected the same way as links of unknown users.
+func unsubscribeEmailDigest(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	userEmail := request.FormValue(QUERY_PARAM_DIGEST_EMAIL)
+
+	_, glukitUser, err := store.GetGlukitUser(context, userEmail)
+	if err == datastore.ErrNoSuchEntity || (err == nil &&
+		!isValidEmailDigestUnsubscribe(glukitUser.EmailDigestSecret, userEmail, request.FormValue(QUERY_PARAM_DIGEST_SIGNATURE))) {
+		http.Error(writer, "Invalid unsubscribe link.", http.StatusForbidden)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if glukitUser.EmailDigestEnabled {
+		glukitUser.EmailDigestEnabled = false
+		if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+			writeError(context, writer, err)
+			return
+		}
+		log.Infof(context, "Unsubscribed user [%s] from the email digest", userEmail)
+	}
+
+	writer.Header().Set("Content-type", "text/plain")
+	fmt.Fprintf(writer, "You won't get the weekly Glukit email digest anymore.")
+}
+
+// resolveDataOwner returns the email of the user whose data a read request of the user with the given email is for:
+// that user or the one given by QUERY_PARAM_ON_BEHALF_OF if they granted access to it. store.ErrShareGrantNotFound is
+// returned if there's no valid grant. Write endpoints must never resolve the owner this way.
+func resolveDataOwner(context context.Context, request *http.Request, email string) (ownerEmail string, err error) {
+	onBehalfOf := strings.TrimSpace(request.FormValue(QUERY_PARAM_ON_BEHALF_OF))
+	if len(onBehalfOf) == 0 || strings.EqualFold(onBehalfOf, email) {
+		return email, nil
+	}
+
+	if _, err := store.GetShareGrant(context, store.GetUserKey(context, onBehalfOf), email, time.Now()); err != nil {
+		return "", err
+	}
+
+	return onBehalfOf, nil
+}
+
+// dataOwnerEmail returns the owner of the data a read request of the logged in user is for, see resolveDataOwner. A
+// forbidden error is written and false returned if there's no valid grant.
+func dataOwnerEmail(context context.Context, writer http.ResponseWriter, request *http.Request) (email string, ok bool) {
+	email, err := resolveDataOwner(context, request, currentGlukitUser(context).Email)
+	if err == store.ErrShareGrantNotFound {
+		http.Error(writer, fmt.Sprintf("No access to the data of [%s].", request.FormValue(QUERY_PARAM_ON_BEHALF_OF)),
+			http.StatusForbidden)
+		return "", false
+	} else if err != nil {
+		writeError(context, writer, err)
+		return "", false
+	}
+
+	return email, true
+}
+
+// createShareInvitation is the endpoint to invite a user to access the data of the logged in user with a permission and
+// an optional expiry. The profile page is rendered with the url of the invitation to send to the invitee, which is
+// the only time it is shown.
+func createShareInvitation(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	inviteeEmail := strings.ToLower(strings.TrimSpace(request.FormValue(FORM_FIELD_SHARE_EMAIL)))
+	if !strings.Contains(inviteeEmail, "@") || strings.EqualFold(inviteeEmail, user.Email) {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s], expected the email of another user.", FORM_FIELD_SHARE_EMAIL, inviteeEmail), 400)
+		return
+	}
+
+	permission, err := model.ParseSharePermission(request.FormValue(FORM_FIELD_SHARE_PERMISSION))
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", FORM_FIELD_SHARE_PERMISSION, err), 400)
+		return
+	}
+
+	userProfileKey, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	now := time.Now()
+	var expiresAt time.Time
+	if param := request.FormValue(FORM_FIELD_SHARE_EXPIRES); len(param) > 0 {
+		lastDay, err := time.ParseInLocation(FORM_DATE_LAYOUT, param, engine.UserLocation(glukitUser))
+		expiresAt = lastDay.AddDate(0, 0, 1)
+		if err != nil || !expiresAt.After(now) {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s], expected a date formatted as %s that isn't past.",
+				FORM_FIELD_SHARE_EXPIRES, param, FORM_DATE_LAYOUT), 400)
+			return
+		}
+	}
+
+	token, invitation, err := model.NewShareInvitation(inviteeEmail, permission, expiresAt, now)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	if _, err := store.StoreShareInvitation(context, userProfileKey, invitation); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Created [%s] share invitation of user [%s] for [%s]", permission, user.Email, inviteeEmail)
+
+	renderProfileWithSecrets(writer, request, "", fmt.Sprintf("https://%s/sharing/accept/%s", request.Host, token))
+}
+
+// getShareInvitationResponses returns the invitations of the user that are pending as of now, see
+// store.GetShareInvitations
+func getShareInvitationResponses(context context.Context, userProfileKey *datastore.Key, now time.Time) (responses []ShareInvitationResponse, err error) {
+	ids, invitations, err := store.GetShareInvitations(context, userProfileKey, now)
+	if err != nil {
+		return nil, err
+	}
+
+	responses = make([]ShareInvitationResponse, len(invitations))
+	for i := range invitations {
+		responses[i] = ShareInvitationResponse{Id: ids[i], ShareInvitation: invitations[i], ValidUntil: invitations[i].ValidUntil()}
+	}
+
+	return responses, nil
+}
+
+// shareInvitations is the endpoint to list the invitations of the logged in user that haven't been accepted yet and
+// haven't expired
+func shareInvitations(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	invitations, err := getShareInvitationResponses(context, store.GetUserKey(context, user.Email), time.Now())
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	writer.Header().Add("Content-type", "application/json")
+	json.NewEncoder(writer).Encode(invitations)
+}
+
+// revokeShareInvitation is the endpoint to revoke a pending invitation of the logged in user so that it can't be
+// accepted anymore
+func revokeShareInvitation(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	id, err := strconv.ParseInt(mux.Vars(request)[PATH_VAR_SHARE_INVITATION], 10, 64)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid invitation id [%s].", mux.Vars(request)[PATH_VAR_SHARE_INVITATION]), 400)
+		return
+	}
+
+	if err := store.DeleteShareInvitation(context, store.GetUserKey(context, user.Email), id); err == store.ErrShareInvitationNotFound {
+		http.Error(writer, fmt.Sprintf("No invitation [%d].", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Revoked share invitation [%d] of user [%s]", id, user.Email)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// showShareInvitation is the page the invitee gets to with the link of an invitation, asking to accept it with a POST
+// so that following the link alone never grants access
+func showShareInvitation(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	writer.Header().Set("Content-Security-Policy", CONTENT_SECURITY_POLICY)
+	variables := ShareInvitationVariables{Email: user.Email, Token: mux.Vars(request)[PATH_VAR_SHARE_TOKEN]}
+	if err := shareInvitationTemplate.Execute(writer, variables); err != nil {
+		log.Criticalf(context, "Error executing template [%s]", shareInvitationTemplate.Name())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ShareInvitationVariables are the variables of the page to accept an invitation
+type ShareInvitationVariables struct {
+	Email string
+	Token string
+}
+
+// acceptShareInvitation is the endpoint accepting an invitation on behalf of the logged in user, who must be signed in
+// with the account the invitation was sent to
+func acceptShareInvitation(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	token := mux.Vars(request)[PATH_VAR_SHARE_TOKEN]
+	grant, err := store.AcceptShareInvitation(context, model.HashShareInvitationToken(token), user.Email, time.Now())
+	if err == store.ErrShareInvitationNotFound {
+		http.Error(writer, fmt.Sprintf("No invitation for [%s] with this link, it may have been accepted already, revoked or expired.", user.Email),
+			http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "User [%s] accepted [%s] access to the data of [%s]", user.Email, grant.Permission, grant.OwnerEmail)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// revokeShareGrant is the endpoint to revoke the access of a user to the data of the logged in user
+func revokeShareGrant(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	grantee := mux.Vars(request)[PATH_VAR_SHARE_GRANTEE]
+	if err := store.DeleteShareGrant(context, store.GetUserKey(context, user.Email), grantee); err == store.ErrShareGrantNotFound {
+		http.Error(writer, fmt.Sprintf("No grant to [%s].", grantee), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Revoked access of [%s] to the data of user [%s]", grantee, user.Email)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// snapshotUrl returns the public url of the snapshot with the given token
+func snapshotUrl(request *http.Request, token string) string {
+	return fmt.Sprintf("https://%s/snapshot/%s", request.Host, token)
+}
+
+// createSnapshot is the endpoint to create a snapshot of the data of the logged in user between two days, at most
+// model.MAX_SNAPSHOT_RANGE apart. Snapshots expire after model.DEFAULT_SNAPSHOT_EXPIRY unless a last day is given.
+// The data is copied so that the snapshot stays the same if the user's data changes or is deleted.
+func createSnapshot(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	userProfileKey, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	label := strings.TrimSpace(request.FormValue(FORM_FIELD_SNAPSHOT_LABEL))
+	if len(label) > MAX_SNAPSHOT_LABEL_LENGTH {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s], expected at most %d characters.", FORM_FIELD_SNAPSHOT_LABEL, label,
+			MAX_SNAPSHOT_LABEL_LENGTH), 400)
+		return
+	}
+
+	location := engine.UserLocation(glukitUser)
+	period, err := parseSnapshotRange(request, location)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+	lowerBound, upperBound := period.LowerBound, period.UpperBound
+
+	now := time.Now()
+	expiresAt := now.Add(model.DEFAULT_SNAPSHOT_EXPIRY)
+	if param := request.FormValue(FORM_FIELD_SNAPSHOT_EXPIRES); len(param) > 0 {
+		lastDay, err := time.ParseInLocation(FORM_DATE_LAYOUT, param, location)
+		expiresAt = lastDay.AddDate(0, 0, 1)
+		if err != nil || !expiresAt.After(now) {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s], expected a date formatted as %s that isn't past.",
+				FORM_FIELD_SNAPSHOT_EXPIRES, param, FORM_DATE_LAYOUT), 400)
+			return
+		}
+	}
+
+	var content model.SnapshotContent
+	if content.Reads, err = store.GetGlucoseReads(context, user.Email, lowerBound, upperBound); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	if content.Injections, err = store.GetInjections(context, user.Email, lowerBound, upperBound); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	if content.BasalRates, err = store.GetBasalRates(context, user.Email, lowerBound, upperBound); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	if content.Meals, err = store.GetMeals(context, user.Email, lowerBound, upperBound); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	if content.Exercises, err = store.GetExercises(context, user.Email, lowerBound, upperBound); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	snapshot, err := model.NewSnapshot(label, lowerBound, upperBound, glukitUser.GetGlucoseUnit(), content, now, expiresAt)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	id, err := store.StoreSnapshot(context, userProfileKey, snapshot, model.MAX_SNAPSHOTS_PER_USER, now)
+	if err == store.ErrSnapshotLimitReached {
+		http.Error(writer, fmt.Sprintf("You can have at most %d snapshots, revoke one to create another.", model.MAX_SNAPSHOTS_PER_USER),
+			http.StatusConflict)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Created snapshot [%d] of user [%s] from [%s] to [%s] with [%d] reads", id, user.Email,
+		lowerBound.Format(util.TIMEFORMAT), upperBound.Format(util.TIMEFORMAT), len(content.Reads))
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// parseSnapshotRange returns the range of the request to take a snapshot of, dates being days in the location, which
+// must not span more than model.MAX_SNAPSHOT_RANGE
+func parseSnapshotRange(request *http.Request, location *time.Location) (period model.TimeRange, err error) {
+	period, err = model.ParseTimeRange(request.FormValue(FORM_FIELD_SNAPSHOT_FROM), request.FormValue(FORM_FIELD_SNAPSHOT_TO),
+		location)
+	if err != nil {
+		return period, err
+	}
+
+	return period, period.Validate(model.MAX_SNAPSHOT_RANGE)
+}
+
+// revokeSnapshot is the endpoint to revoke a snapshot of the logged in user, whose link stops working right away
+func revokeSnapshot(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	id, err := strconv.ParseInt(mux.Vars(request)[PATH_VAR_SNAPSHOT_ID], 10, 64)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid snapshot id [%s].", mux.Vars(request)[PATH_VAR_SNAPSHOT_ID]), 400)
+		return
+	}
+
+	if err := store.DeleteSnapshot(context, store.GetUserKey(context, user.Email), id); err == store.ErrSnapshotNotFound {
+		http.Error(writer, fmt.Sprintf("No snapshot [%d].", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Revoked snapshot [%d] of user [%s]", id, user.Email)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// findSnapshot returns the snapshot of the request's token, writing a not found error and returning nil if there's no
+// such snapshot or if it expired
+func findSnapshot(context context.Context, writer http.ResponseWriter, request *http.Request) (snapshot *model.Snapshot) {
+	snapshot, err := store.FindSnapshot(context, mux.Vars(request)[PATH_VAR_SNAPSHOT_TOKEN], time.Now())
+	if err == store.ErrSnapshotNotFound {
+		http.Error(writer, "This snapshot doesn't exist, it may have expired or been revoked.", http.StatusNotFound)
+		return nil
+	} else if err != nil {
+		writeError(context, writer, err)
+		return nil
+	}
+
+	return snapshot
+}
+
+// renderSnapshot is the public page of a snapshot, showing its data to anyone with its link
+func renderSnapshot(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	snapshot := findSnapshot(context, writer, request)
+	if snapshot == nil {
+		return
+	}
+
+	writer.Header().Set("Content-Security-Policy", CONTENT_SECURITY_POLICY)
+	if err := snapshotTemplate.Execute(writer, snapshot); err != nil {
+		log.Criticalf(context, "Error executing template [%s]", snapshotTemplate.Name())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// snapshotData is the public endpoint serving the data of a snapshot as json, in the unit of its owner unless
+// requested otherwise with the unit parameter
+func snapshotData(writer http.ResponseWriter, request *http.Request) {
+	serveSnapshotData(appengine.NewContext(request), writer, request)
+}
+
+// serveSnapshotData writes the data of the snapshot of the request's token, see snapshotData
+func serveSnapshotData(context context.Context, writer http.ResponseWriter, request *http.Request) {
+	snapshot := findSnapshot(context, writer, request)
+	if snapshot == nil {
+		return
+	}
+
+	content, err := snapshot.GetContent()
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	unit := snapshot.GlucoseUnit
+	if requested, err := apimodel.ParseGlucoseUnit(request.FormValue(GLUCOSE_UNIT_PARAMETER)); err == nil {
+		unit = requested
+	}
+
+	dataSeries, err := generateDataSeriesFromData(content.Reads, content.Injections, content.BasalRates, content.Meals, content.Exercises, nil, unit)
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(SnapshotDataResponse{snapshot.Label, snapshot.LowerBound, snapshot.UpperBound, dataSeries})
+}
+
+// clinicReport is the printable report of the last 14, 30 or 90 days of the user brought to clinic visits, in the unit
+// given by the unit parameter or the user's
+func clinicReport(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	email, ok := dataOwnerEmail(context, writer, request)
+	if !ok {
+		return
+	}
+
+	days := model.ClinicReportDays[0]
+	if param := request.FormValue(QUERY_PARAM_REPORT_DAYS); len(param) > 0 {
+		value, err := strconv.Atoi(param)
+		if err != nil || !containsInt(model.ClinicReportDays, value) {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s], expected one of %v.", QUERY_PARAM_REPORT_DAYS, param,
+				model.ClinicReportDays), 400)
+			return
+		}
+		days = value
+	}
+
+	_, glukitUser, err := store.GetGlukitUser(context, email)
+	if err == datastore.ErrNoSuchEntity {
+		http.Error(writer, fmt.Sprintf("No data for user [%s].", email), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	unitValue, err := resolveGlucoseUnit(email, request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report, err := engine.CalculateClinicReport(context, glukitUser, days, time.Now())
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	variables := newClinicReportVariables(report, *unitValue, days, glukitUser.TargetLow, glukitUser.TargetHigh)
+	if email != currentGlukitUser(context).Email {
+		variables.OnBehalfOf = email
+	}
+
+	writer.Header().Set("Content-Security-Policy", CONTENT_SECURITY_POLICY)
+	if err := clinicReportTemplate.Execute(writer, variables); err != nil {
+		log.Criticalf(context, "Error executing template [%s]", clinicReportTemplate.Name())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// newClinicReportVariables returns the template variables of the report, laying out its AGP chart. Buckets without
+// samples are left out of the chart.
+func newClinicReportVariables(report *model.ClinicReport, unit apimodel.GlucoseUnit, days int, targetLow, targetHigh float32) ClinicReportVariables {
+	variables := ClinicReportVariables{Report: report, Unit: unit, Days: days, DayOptions: model.ClinicReportDays,
+		ChartWidth: AGP_CHART_WIDTH, ChartHeight: AGP_CHART_HEIGHT, TargetLowY: agpChartY(float64(targetLow)),
+		TargetHighY: agpChartY(float64(targetHigh)), LastDay: report.Period.UpperBound.AddDate(0, 0, -1)}
+	variables.TargetRange = fmt.Sprintf("%s-%s", variables.Glucose(float64(targetLow)), variables.Glucose(float64(targetHigh)))
+
+	buckets := make([]model.AGPBucket, 0)
+	for _, bucket := range report.AGP.Buckets {
+		if bucket.SampleCount > 0 {
+			buckets = append(buckets, bucket)
+		}
+	}
+
+	minutesPerDay := float64(24 * 60)
+	points := func(percentile func(bucket model.AGPBucket) float64, reversed bool) []string {
+		values := make([]string, len(buckets))
+		for i, bucket := range buckets {
+			x := (float64(bucket.StartMinute) + float64(report.AGP.BucketMinutes)/2) / minutesPerDay * AGP_CHART_WIDTH
+			value := fmt.Sprintf("%.1f,%.1f", x, agpChartY(percentile(bucket)))
+			if reversed {
+				values[len(buckets)-1-i] = value
+			} else {
+				values[i] = value
+			}
+		}
+		return values
+	}
+
+	p10 := func(bucket model.AGPBucket) float64 { return bucket.P10 }
+	p25 := func(bucket model.AGPBucket) float64 { return bucket.P25 }
+	median := func(bucket model.AGPBucket) float64 { return bucket.Median }
+	p75 := func(bucket model.AGPBucket) float64 { return bucket.P75 }
+	p90 := func(bucket model.AGPBucket) float64 { return bucket.P90 }
+
+	variables.OuterBand = strings.Join(append(points(p90, false), points(p10, true)...), " ")
+	variables.InnerBand = strings.Join(append(points(p75, false), points(p25, true)...), " ")
+	variables.MedianLine = strings.Join(points(median, false), " ")
+
+	return variables
+}
+
+// agpChartY returns the vertical position of the glucose value, in mg/dL, on the AGP chart of a clinic report
+func agpChartY(value float64) float64 {
+	return AGP_CHART_HEIGHT * (1 - math.Min(value, AGP_CHART_MAX_GLUCOSE)/AGP_CHART_MAX_GLUCOSE)
+}
+
+// containsInt returns true if value is one of the values
+func containsInt(values []int, value int) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"net/http"
+)
+
+// writeError logs the error that failed the request and writes the status it translates to, see errorStatus
+func writeError(context context.Context, writer http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	log.Errorf(context, "Failing request with status [%d]: %v", status, err)
+	http.Error(writer, err.Error(), status)
+}
+
+// errorStatus returns the http status of a request that failed with the error. Transient store errors are reported as
+// service unavailable since the request could succeed if it's made again, other errors as internal server errors.
+func errorStatus(err error) int {
+	if store.IsTransientError(err) {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
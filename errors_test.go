@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/datastore"
+	"net/http"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	statuses := map[error]int{
+		store.OperationError{"getting reads", datastore.ErrConcurrentTransaction}: http.StatusServiceUnavailable,
+		store.OperationError{"getting reads", datastore.ErrInvalidKey}:            http.StatusInternalServerError,
+		errors.New("template error"):                                              http.StatusInternalServerError,
+	}
+
+	for err, expected := range statuses {
+		if status := errorStatus(err); status != expected {
+			t.Errorf("Expected status [%d] for error [%v] but got [%d]", expected, err, status)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/alexandre-normand/glukit/app/apimodel"
 	"github.com/alexandre-normand/glukit/app/config"
+	"github.com/alexandre-normand/glukit/app/importer"
 	"github.com/alexandre-normand/glukit/app/model"
 	"github.com/alexandre-normand/glukit/app/store"
 	"github.com/alexandre-normand/glukit/app/util"
@@ -13,7 +14,6 @@ import (
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/taskqueue"
 	"google.golang.org/appengine/urlfetch"
 	"google.golang.org/appengine/user"
 	"net/http"
@@ -42,22 +42,48 @@ func configuration() *oauth.Config {
 	return &configuration
 }
 
+// googleFitConfiguration returns the OAuth configuration that also requests the scope of the user's Google Fit activity
+func googleFitConfiguration() *oauth.Config {
+	configuration := configuration()
+	configuration.Scope = configuration.Scope + " " + importer.GOOGLE_FIT_SCOPE
+
+	return configuration
+}
+
 // handleLoggedInUser is responsible for directing the user to the graph page after optionally:
 //   1. Storing the GlukitUser entry if it's the first access
-//   2. Refreshing the glukit oauth token
+//   2. Refreshing the glukit oauth token, or storing a newly authorized one if the previous one was revoked
 //   3. Kick off the processing of background import of files
 //
 // TODO: This is a big function, this should be split up into smaller ones
 func handleLoggedInUser(writer http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
-	user := user.Current(context)
+	signedInEmail := user.Current(context).Email
+	user := currentGlukitUser(context)
+
+	// The token of an account linked to another one is kept on its alias, only the data of the primary account is
+	// refreshed
+	if signedInEmail != user.Email {
+		log.Infof(context, "User [%s] signed in with linked account [%s]", user.Email, signedInEmail)
+		if err := enqueueRefresh(context, user.Email, false, time.Now(), REFRESH_TRIGGER_LOGIN); err != nil {
+			log.Criticalf(context, "Could not schedule execution of the data refresh for user [%s]: %v", user.Email, err)
+		}
+
+		renderRealUser(writer, request)
+		return
+	}
 
 	transport := new(oauth.Transport)
 	var oauthToken oauth.Token
 	glukitUser, _, _, err := store.GetUserData(context, user.Email)
 	scheduleAutoRefresh := false
+	trigger := REFRESH_TRIGGER_LOGIN
 	if err == datastore.ErrNoSuchEntity {
-		oauthToken, transport = getOauthToken(request)
+		oauthToken, transport, err = getOauthToken(request)
+		if err != nil {
+			writeError(context, writer, err)
+			return
+		}
 
 		log.Infof(context, "No data found for user [%s], creating it", user.Email)
 
@@ -65,18 +91,24 @@ func handleLoggedInUser(writer http.ResponseWriter, request *http.Request) {
 		// this is ready
 		// We store the refresh token separately from the rest. This token is long-lived, meaning that if
 		// we have a glukit user with no refresh token, we need to force getting a new one (which is to be avoided)
-		glukitUser = &model.GlukitUser{user.Email, "", "", time.Now(),
-			model.DIABETES_TYPE_1, "", util.GLUKIT_EPOCH_TIME, apimodel.UNDEFINED_GLUCOSE_READ, oauthToken, oauthToken.RefreshToken,
-			model.UNDEFINED_SCORE, model.UNDEFINED_SCORE, false, "", time.Now(), model.UNDEFINED_A1C_ESTIMATE}
+		glukitUser = &model.GlukitUser{Email: user.Email, DateOfBirth: time.Now(),
+			DiabetesType: model.DIABETES_TYPE_UNKNOWN, LastUpdated: util.GLUKIT_EPOCH_TIME,
+			MostRecentRead: apimodel.UNDEFINED_GLUCOSE_READ, Token: oauthToken, RefreshToken: oauthToken.RefreshToken,
+			BestScore: model.UNDEFINED_SCORE, MostRecentScore: model.UNDEFINED_SCORE, AccountCreated: time.Now(),
+			MostRecentA1C: model.UNDEFINED_A1C_ESTIMATE, TargetLow: apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD,
+			TargetHigh: apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD, GlucoseUnit: apimodel.MG_PER_DL,
+			TherapyMode: model.THERAPY_MODE_UNKNOWN, Onboarding: model.NewOnboardingState(time.Now())}
 		_, err = store.StoreUserProfile(context, time.Now(), *glukitUser)
 		if err != nil {
-			util.Propagate(err)
+			writeError(context, writer, err)
+			return
 		}
 		// We only schedule the auto refresh on first access since all subsequent runs of scheduled tasks will also
 		// reschedule themselve a new run
 		scheduleAutoRefresh = true
 	} else if _, ok := err.(store.StoreError); err != nil && !ok {
-		util.Propagate(err)
+		writeError(context, writer, err)
+		return
 	} else {
 		oauthToken = glukitUser.Token
 
@@ -89,12 +121,45 @@ func handleLoggedInUser(writer http.ResponseWriter, request *http.Request) {
 			Token: &oauthToken,
 		}
 
-		if !oauthToken.Expired() && len(glukitUser.RefreshToken) > 0 {
-			log.Debugf(context, "Token [%s] still valid, reusing it...", oauthToken)
+		if glukitUser.TokenRevoked {
+			// The user got redirected to authorize access again, the new token restarts the refreshes that stopped
+			// when the previous one was revoked
+			log.Infof(context, "Token of user [%s] was revoked on [%s], storing the newly authorized one", user.Email,
+				glukitUser.TokenRevokedOn.Format(util.TIMEFORMAT))
+			oauthToken, transport, err = getOauthToken(request)
+			if err != nil {
+				writeError(context, writer, err)
+				return
+			}
+			glukitUser.Token = oauthToken
+			glukitUser.RefreshToken = oauthToken.RefreshToken
+			glukitUser.TokenRevoked = false
+			glukitUser.TokenRevokedOn = time.Time{}
+			glukitUser.TokenRevocationReason = ""
+			glukitUser.LastUpdated = time.Now()
+			scheduleAutoRefresh = true
+			trigger = REFRESH_TRIGGER_MANUAL
+		} else if glukitUser.NeedsGoogleFitAuthorization() {
+			// The user got redirected to grant the Google Fit scope, the new token has it on top of the profile one and
+			// the refresh it kicks off imports the sessions right away
+			log.Infof(context, "User [%s] authorized google fit, storing the new token", user.Email)
+			oauthToken, transport, err = getOauthToken(request)
+			if err != nil {
+				writeError(context, writer, err)
+				return
+			}
+			glukitUser.Token = oauthToken
+			if len(oauthToken.RefreshToken) > 0 {
+				glukitUser.RefreshToken = oauthToken.RefreshToken
+			}
+			glukitUser.GoogleFitAuthorized = true
+			glukitUser.LastUpdated = time.Now()
+			trigger = REFRESH_TRIGGER_MANUAL
+		} else if !oauthToken.Expired() && len(glukitUser.RefreshToken) > 0 {
+			log.Debugf(context, "Token expiring on [%s] still valid, reusing it...", oauthToken.Expiry)
 		} else {
 			if oauthToken.Expired() {
-				log.Infof(context, "Token [%v] expired on [%s], refreshing with refresh token [%s]...",
-					oauthToken, oauthToken.Expiry, glukitUser.RefreshToken)
+				log.Infof(context, "Token expired on [%s], refreshing with refresh token...", oauthToken.Expiry)
 			} else if len(glukitUser.RefreshToken) == 0 {
 				log.Warningf(context, "No refresh token stored, getting a new one and saving it...")
 			}
@@ -103,13 +168,19 @@ func handleLoggedInUser(writer http.ResponseWriter, request *http.Request) {
 			if len(glukitUser.RefreshToken) == 0 {
 				log.Criticalf(context, "We lost the refresh token for user [%s], getting a new one "+
 					"with the force approval.", user.Email)
-				oauthToken, transport = getOauthToken(request)
+				oauthToken, transport, err = getOauthToken(request)
+				if err != nil {
+					writeError(context, writer, err)
+					return
+				}
 				glukitUser.RefreshToken = oauthToken.RefreshToken
 			} else {
 				transport.Token.RefreshToken = glukitUser.RefreshToken
 
-				err := transport.Refresh(context)
-				util.Propagate(err)
+				if err := transport.Refresh(context); err != nil {
+					writeError(context, writer, err)
+					return
+				}
 
 				log.Debugf(context, "Storing new refreshed token [%s] in datastore...", oauthToken)
 				glukitUser.LastUpdated = time.Now()
@@ -120,11 +191,13 @@ func handleLoggedInUser(writer http.ResponseWriter, request *http.Request) {
 
 	// Refresh and store the profile
 	if service, err := oauth2.New(transport.Client()); err != nil {
-		util.Propagate(err)
+		writeError(context, writer, err)
+		return
 	} else {
 		getRequest := service.Userinfo.Get()
 		if userInfo, err := getRequest.Do(); err != nil {
-			util.Propagate(err)
+			writeError(context, writer, err)
+			return
 
 		} else {
 			log.Infof(context, "User profile refreshed to %v", userInfo)
@@ -137,14 +210,18 @@ func handleLoggedInUser(writer http.ResponseWriter, request *http.Request) {
 
 	_, err = store.StoreUserProfile(context, time.Now(), *glukitUser)
 	if err != nil {
-		util.Propagate(err)
+		writeError(context, writer, err)
+		return
 	}
 
-	task, err := refreshUserData.Task(user.Email, scheduleAutoRefresh)
-	if err != nil {
+	// The first refresh starts the chain of scheduled refreshes, the ones kicked off by logging in only run once a day.
+	// Authorizing access after a revoked token restarts the chain right away.
+	if scheduleAutoRefresh && trigger == REFRESH_TRIGGER_LOGIN {
+		trigger = REFRESH_TRIGGER_SCHEDULED
+	}
+	if err := enqueueRefresh(context, user.Email, scheduleAutoRefresh, time.Now(), trigger); err != nil {
 		log.Criticalf(context, "Could not schedule execution of the data refresh for user [%s]: %v", user.Email, err)
 	}
-	taskqueue.Add(context, task, "refresh")
 	log.Infof(context, "Kicked off data update for user [%s]...", user.Email)
 
 	// Render the graph view, it might take some time to show something but it will as soon as a file import
@@ -153,7 +230,7 @@ func handleLoggedInUser(writer http.ResponseWriter, request *http.Request) {
 }
 
 // getOauthToken deals with getting an oauth token from a oauth authorization code
-func getOauthToken(request *http.Request) (oauthToken oauth.Token, transport *oauth.Transport) {
+func getOauthToken(request *http.Request) (oauthToken oauth.Token, transport *oauth.Transport, err error) {
 	context := appengine.NewContext(request)
 
 	// Exchange code for an access token at OAuth provider.
@@ -169,10 +246,13 @@ func getOauthToken(request *http.Request) (oauthToken oauth.Token, transport *oa
 	}
 
 	token, err := t.Exchange(context, code)
-	util.Propagate(err)
+	if err != nil {
+		return oauthToken, nil, err
+	}
 
-	log.Infof(context, "Got brand new oauth token [%v] with refresh token [%s]", token, token.RefreshToken)
-	return *token, t
+	log.Infof(context, "Got brand new oauth token expiring on [%s] with refresh token [%t]", token.Expiry,
+		len(token.RefreshToken) > 0)
+	return *token, t, nil
 }
 
 // buildPerfectBaseline generates an array of reads that represents the target/perfection
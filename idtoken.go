@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/auth"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/urlfetch"
+	"net/http"
+	"time"
+)
+
+const (
+	// Verified id tokens are cached for ID_TOKEN_VERIFICATION_CACHE_DURATION, never past their expiry, so that native
+	// clients sending the same token with every request don't get it verified every time
+	ID_TOKEN_VERIFICATION_CACHE_DURATION = time.Duration(5) * time.Minute
+	GOOGLE_KEYS_CACHE_KEY                = "GoogleIdTokenKeys"
+
+	// Codes of the api errors of id tokens, for clients to get a new token when theirs expired
+	API_ERROR_ID_TOKEN_EXPIRED = "id_token_expired"
+	API_ERROR_ID_TOKEN_INVALID = "id_token_invalid"
+)
+
+// googleKeysError is the error of getting the keys Google signs id tokens with. It says nothing about the id token being
+// verified, which could be valid once the keys can be fetched again.
+type googleKeysError struct {
+	err error
+}
+
+func (e googleKeysError) Error() string {
+	return fmt.Sprintf("Error getting google id token keys: %v", e.err)
+}
+
+// authenticateIdToken returns the email of the GlukitUser authenticated by the Google id token of a native client, the
+// primary account if the one of the token is linked to it. Tokens that expired or are invalid are reported as ApiError
+// values with the API_ERROR_ID_TOKEN_EXPIRED and API_ERROR_ID_TOKEN_INVALID codes. Tokens that can't be verified
+// because the keys of Google can't be fetched are reported as an ApiError with the service unavailable status.
+func authenticateIdToken(context context.Context, token string) (email string, err error) {
+	cacheKey := idTokenCacheKey(token)
+	if item, err := memcache.Get(context, cacheKey); err == nil {
+		email = string(item.Value)
+	} else {
+		if err != memcache.ErrCacheMiss {
+			log.Warningf(context, "Error reading cached verification of id token, verifying it again: %v", err)
+		}
+
+		claims, err := verifyIdToken(context, token)
+		if _, unavailable := err.(googleKeysError); unavailable {
+			log.Errorf(context, "Can't verify id token: %v", err)
+			return "", ApiError{Status: http.StatusServiceUnavailable, Message: "Id tokens can't be verified at the moment, try again later."}
+		} else if err == auth.ErrIdTokenExpired {
+			return "", ApiError{Status: http.StatusUnauthorized, Message: "Id token expired, get a new one.", Code: API_ERROR_ID_TOKEN_EXPIRED}
+		} else if err != nil {
+			log.Infof(context, "Rejected invalid id token: %v", err)
+			return "", ApiError{Status: http.StatusUnauthorized, Message: fmt.Sprintf("Invalid id token: %v", err), Code: API_ERROR_ID_TOKEN_INVALID}
+		}
+
+		email = claims.Email
+		expiration := ID_TOKEN_VERIFICATION_CACHE_DURATION
+		if untilExpiry := claims.GetExpiry().Sub(time.Now()); untilExpiry < expiration {
+			expiration = untilExpiry
+		}
+
+		if expiration > 0 {
+			if err := memcache.Set(context, &memcache.Item{Key: cacheKey, Value: []byte(email), Expiration: expiration}); err != nil {
+				log.Warningf(context, "Error caching verification of id token of [%s]: %v", email, err)
+			}
+		}
+	}
+
+	primaryEmail, _, err := store.ResolveUser(context, email)
+	if err != nil {
+		return "", err
+	}
+
+	return primaryEmail, nil
+}
+
+// verifyIdToken verifies the id token with the keys Google signs them with. Keys are fetched again if the token was
+// signed with one that isn't in the cached ones, Google having rotated its keys since.
+func verifyIdToken(context context.Context, token string) (claims auth.IdTokenClaims, err error) {
+	keys, cached, err := googleIdTokenKeys(context, false)
+	if err != nil {
+		return claims, err
+	}
+
+	claims, err = auth.VerifyIdToken(token, keys, appConfig.IdTokenAudiences, time.Now())
+	if err == auth.ErrIdTokenUnknownKey && cached {
+		if keys, _, err = googleIdTokenKeys(context, true); err != nil {
+			return claims, err
+		}
+
+		claims, err = auth.VerifyIdToken(token, keys, appConfig.IdTokenAudiences, time.Now())
+	}
+
+	return claims, err
+}
+
+// googleIdTokenKeys returns the keys Google signs id tokens with, from memcache unless refresh is set or they aren't
+// cached. Fetched keys are cached for as long as Google says they can be. Keys that can't be fetched or parsed are
+// reported as a googleKeysError.
+func googleIdTokenKeys(context context.Context, refresh bool) (keys map[string]*rsa.PublicKey, cached bool, err error) {
+	if !refresh {
+		if item, err := memcache.Get(context, GOOGLE_KEYS_CACHE_KEY); err == nil {
+			if keys, err := auth.ParseGoogleKeys(item.Value); err == nil {
+				return keys, true, nil
+			}
+		}
+	}
+
+	value, maxAge, err := auth.FetchGoogleKeys(urlfetch.Client(context))
+	if err != nil {
+		return nil, false, googleKeysError{err}
+	}
+
+	if keys, err = auth.ParseGoogleKeys(value); err != nil {
+		return nil, false, googleKeysError{err}
+	}
+
+	if err := memcache.Set(context, &memcache.Item{Key: GOOGLE_KEYS_CACHE_KEY, Value: value, Expiration: maxAge}); err != nil {
+		log.Warningf(context, "Error caching google id token keys: %v", err)
+	}
+
+	return keys, false, nil
+}
+
+// idTokenCacheKey returns the memcache key of the verification of the id token, a hash so that tokens aren't kept
+func idTokenCacheKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return "IdToken:" + hex.EncodeToString(hash[:])
+}
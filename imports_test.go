@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFileImportsLimitIsClampedAndMustBePositive(t *testing.T) {
+	for _, expected := range []struct {
+		query string
+		limit int
+		valid bool
+	}{
+		{"", DEFAULT_FILE_IMPORTS_LIMIT, true},
+		{"?limit=5", 5, true},
+		{"?limit=100000", MAX_FILE_IMPORTS_LIMIT, true},
+		{"?limit=0", 0, false},
+		{"?limit=-3", 0, false},
+		{"?limit=ten", 0, false},
+	} {
+		request, _ := http.NewRequest("GET", "/fileImports"+expected.query, nil)
+		limit, err := parseFileImportsLimit(request)
+		if valid := err == nil; valid != expected.valid || limit != expected.limit {
+			t.Errorf("Expected limit [%d] and valid [%t] for [%s] but got [%d] and [%v]", expected.limit, expected.valid,
+				expected.query, limit, err)
+		}
+	}
+}
@@ -58,6 +58,23 @@ func (oe OAuthError) Error() string {
 	return "OAuthError: " + oe.prefix + ": " + oe.msg
 }
 
+// Error code of the token endpoint for a refresh token that was revoked or expired
+const ERROR_CODE_INVALID_GRANT = "invalid_grant"
+
+// TokenError is returned when the token endpoint rejects a request, Code being the OAuth error code of its response
+// (RFC 6749, section 5.2), if any.
+type TokenError struct {
+	OAuthError
+	Code string
+}
+
+// IsInvalidGrant returns true if err is the rejection of a grant, such as a refresh token, that was revoked or expired
+// and can only be replaced by asking the user to authorize access again
+func IsInvalidGrant(err error) bool {
+	tokenError, ok := err.(TokenError)
+	return ok && tokenError.Code == ERROR_CODE_INVALID_GRANT
+}
+
 // Cache specifies the methods that implement a Token cache.
 type Cache interface {
 	Token() (*Token, error)
@@ -309,7 +326,14 @@ func (t *Transport) updateToken(context context.Context, tok *Token, v url.Value
 	}
 	defer r.Body.Close()
 	if r.StatusCode != 200 {
-		return OAuthError{"updateToken", r.Status}
+		var e struct {
+			Code string `json:"error"`
+		}
+		// The error code is best effort, the status is enough to fail the update
+		if body, err := ioutil.ReadAll(r.Body); err == nil {
+			json.Unmarshal(body, &e)
+		}
+		return TokenError{OAuthError{"updateToken", r.Status}, e.Code}
 	}
 	var b struct {
 		Access    string        `json:"access_token"`
@@ -15,7 +15,6 @@ import (
 	"google.golang.org/appengine/delay"
 	"google.golang.org/appengine/log"
 	"google.golang.org/appengine/taskqueue"
-	"google.golang.org/appengine/user"
 	"html/template"
 	"net/http"
 	"sync"
@@ -25,6 +24,12 @@ import (
 var dataBrowserTemplate = template.Must(template.ParseFiles("view/templates/databrowser.html"))
 var reportTemplate = template.Must(template.ParseFiles("view/templates/report.html"))
 var landingTemplate = template.Must(template.ParseFiles("view/templates/landing.html"))
+var profileTemplate = template.Must(template.ParseFiles("view/templates/profile.html"))
+var snapshotTemplate = template.Must(template.ParseFiles("view/templates/snapshot.html"))
+var clinicReportTemplate = template.Must(template.ParseFiles("view/templates/clinicreport.html"))
+var emailDigestTemplate = template.Must(template.ParseFiles("view/templates/emaildigest.html"))
+var accountLinkTemplate = template.Must(template.ParseFiles("view/templates/linkaccount.html"))
+var shareInvitationTemplate = template.Must(template.ParseFiles("view/templates/acceptinvitation.html"))
 var muxRouter = mux.NewRouter()
 var initOnce sync.Once
 
@@ -46,6 +51,7 @@ type RenderVariables struct {
 // init initializes the routes and global initialization
 func main() {
 	appConfig = config.NewAppConfig()
+	model.TokenKeyRing = appConfig.TokenKeyRing
 
 	http.Handle("/", muxRouter)
 
@@ -56,21 +62,88 @@ func main() {
 	// GAE Json endpoints
 	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"data", demoContent)
 	muxRouter.HandleFunc("/data", personalData)
+	muxRouter.HandleFunc("/data/imports", fileImports)
+	muxRouter.HandleFunc("/data/imports/validate", validateImport)
 	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"steadySailor", demoSteadySailorData)
 	muxRouter.HandleFunc("/steadySailor", steadySailorData)
 	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"dashboard", demoDashboard)
 	muxRouter.HandleFunc("/dashboard", dashboard)
 	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"glukitScores", glukitScoresForDemo)
 	muxRouter.HandleFunc("/glukitScores", glukitScores)
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"glukitScoreHistory", glukitScoreHistoryForDemo)
+	muxRouter.HandleFunc("/glukitScoreHistory", glukitScoreHistory)
 	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"a1cs", a1cEstimatesForDemo)
 	muxRouter.HandleFunc("/a1cs", a1cEstimates)
+	muxRouter.HandleFunc("/engine/recalculate", recalculate).Methods("POST")
+	muxRouter.HandleFunc("/periods/compare", comparePeriods)
+	muxRouter.HandleFunc("/nights", nights)
+	muxRouter.HandleFunc("/insulinTotals", insulinTotals)
+	muxRouter.HandleFunc("/settings/targetRange", updateTargetRange).Methods("POST")
+	muxRouter.HandleFunc("/settings/glucoseUnit", updateGlucoseUnit).Methods("POST")
+	muxRouter.HandleFunc("/settings/refresh", updateRefreshSettings).Methods("POST")
+	muxRouter.HandleFunc("/settings/profile", renderProfile).Methods("GET")
+	muxRouter.HandleFunc("/settings/profile", updateProfile).Methods("POST")
+	muxRouter.HandleFunc("/settings/apiKeys", apiKeys).Methods("GET")
+	muxRouter.HandleFunc("/settings/apiKeys", createApiKey).Methods("POST")
+	muxRouter.HandleFunc("/settings/apiKeys/{id}/revoke", revokeApiKey).Methods("POST")
+	muxRouter.HandleFunc("/settings/webhook", updateWebhook).Methods("POST")
+	muxRouter.HandleFunc("/settings/emailDigest", updateEmailDigest).Methods("POST")
+	muxRouter.HandleFunc("/settings/calendarFeed", resetCalendarFeed).Methods("POST")
+	muxRouter.HandleFunc("/settings/googleFit", updateGoogleFit).Methods("POST")
+	muxRouter.HandleFunc("/settings/dropbox/connect", connectDropbox).Methods("POST")
+	muxRouter.HandleFunc("/settings/dropbox/callback", dropboxCallback).Methods("GET")
+	muxRouter.HandleFunc("/settings/dropbox/disconnect", disconnectDropbox).Methods("POST")
+	muxRouter.HandleFunc("/settings/tidepool", connectTidepool).Methods("POST")
+	muxRouter.HandleFunc("/settings/tidepool/disconnect", disconnectTidepool).Methods("POST")
+	muxRouter.HandleFunc("/settings/accounts/link", requestAccountLink).Methods("POST")
+	muxRouter.HandleFunc("/settings/accounts/link/confirm", showAccountLink).Methods("GET")
+	muxRouter.HandleFunc("/settings/accounts/link/confirm", confirmAccountLink).Methods("POST")
+	muxRouter.HandleFunc("/settings/accounts/{email}/unlink", unlinkAccount).Methods("POST")
+	muxRouter.HandleFunc("/account/delete", requestAccountDeletion).Methods("POST")
+	muxRouter.HandleFunc("/account/delete/confirm", confirmAccountDeletion).Methods("POST")
+	muxRouter.HandleFunc("/digest/unsubscribe", unsubscribeEmailDigest).Methods("GET", "POST")
+	muxRouter.HandleFunc("/settings/sharing/invitations", createShareInvitation).Methods("POST")
+	muxRouter.HandleFunc("/settings/sharing/invitations", shareInvitations).Methods("GET")
+	muxRouter.HandleFunc("/settings/sharing/invitations/{id}/revoke", revokeShareInvitation).Methods("POST")
+	muxRouter.HandleFunc("/settings/sharing/grants/{grantee}/revoke", revokeShareGrant).Methods("POST")
+	muxRouter.HandleFunc("/sharing/accept/{token}", showShareInvitation).Methods("GET")
+	muxRouter.HandleFunc("/sharing/accept/{token}", acceptShareInvitation).Methods("POST")
+	muxRouter.HandleFunc("/settings/snapshots", createSnapshot).Methods("POST")
+	muxRouter.HandleFunc("/settings/snapshots/{id}/revoke", revokeSnapshot).Methods("POST")
+	muxRouter.HandleFunc("/snapshot/{token}", renderSnapshot).Methods("GET")
+	muxRouter.HandleFunc("/snapshot/{token}/data", snapshotData).Methods("GET")
+	muxRouter.HandleFunc("/notes", createNote).Methods("POST")
+	muxRouter.HandleFunc("/notes", updateNote).Methods("PUT")
+	muxRouter.HandleFunc("/notes", deleteNote).Methods("DELETE")
+	muxRouter.HandleFunc("/data/meal/{id}", updateMeal).Methods("PUT")
+	muxRouter.HandleFunc("/data/meal/{id}", deleteMeal).Methods("DELETE")
+	muxRouter.HandleFunc("/data/injection/{id}", deleteInjection).Methods("DELETE")
+	muxRouter.HandleFunc("/data/exercise/{id}", deleteExercise).Methods("DELETE")
+	muxRouter.HandleFunc("/export/csv", exportCsv).Methods("GET")
+	muxRouter.HandleFunc("/export/calendar.ics", exportCalendar).Methods("GET")
 	muxRouter.HandleFunc("/donation", handleDonation)
 
+	// Admin endpoints
+	muxRouter.HandleFunc("/admin/migrateSchema", startSchemaMigration).Methods("POST")
+	muxRouter.HandleFunc("/admin/sealTokens", startTokenSealing).Methods("POST")
+	muxRouter.HandleFunc("/admin/refresh", forceRefresh).Methods("POST")
+	muxRouter.HandleFunc("/admin/failedTasks", failedTasks).Methods("GET")
+	muxRouter.HandleFunc("/admin/failedTasks/{id}/requeue", requeueTask).Methods("POST")
+	muxRouter.HandleFunc("/admin/integrityCheck", startIntegrityCheck).Methods("POST")
+	muxRouter.HandleFunc("/admin/integrityReports", integrityReports).Methods("GET")
+	muxRouter.HandleFunc("/admin/demo/reset", resetDemo).Methods("POST")
+	muxRouter.HandleFunc("/admin/analytics/export", updateAnalyticsExport).Methods("POST")
+	muxRouter.HandleFunc("/admin/analytics/table", createAnalyticsTable).Methods("POST")
+	muxRouter.HandleFunc("/cron/scheduleRefreshes", scheduleRefreshes).Methods("GET")
+	muxRouter.HandleFunc("/cron/refreshDemo", refreshDemo).Methods("GET")
+	muxRouter.HandleFunc("/cron/sendEmailDigests", sendEmailDigests).Methods("GET")
+
 	// "main"-page for both demo and real users
 	muxRouter.HandleFunc("/demo", renderDemo)
 	muxRouter.HandleFunc("/browse", renderRealUser)
 	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"report", demoReport)
 	muxRouter.HandleFunc("/report", report)
+	muxRouter.HandleFunc("/report/clinic", clinicReport).Methods("GET")
 
 	// Static pages
 	muxRouter.HandleFunc("/", landing)
@@ -84,16 +157,24 @@ func main() {
 	muxRouter.HandleFunc("/v1/meals", initializeAndHandleRequest).Methods("POST").Name(MEALS_V1_ROUTE)
 	muxRouter.HandleFunc("/v1/glucosereads", initializeAndHandleRequest).Methods("POST").Name(GLUCOSEREADS_V1_ROUTE)
 	muxRouter.HandleFunc("/v1/exercises", initializeAndHandleRequest).Methods("POST").Name(EXERCISES_V1_ROUTE)
+	muxRouter.HandleFunc("/api/v1/onboarding", initializeAndHandleRequest).Methods("GET").Name(API_V1_ONBOARDING_ROUTE)
+	muxRouter.HandleFunc("/api/v1/{kind}", initializeAndHandleRequest).Methods("GET").Name(API_V1_DATA_ROUTE)
 
 	// Register oauth endpoints to warmup which will initilize the oauth server and replace the routes with the actual oauth handlers
 	muxRouter.HandleFunc("/token", initializeAndHandleRequest).Methods("POST").Name(TOKEN_ROUTE)
 	muxRouter.HandleFunc("/authorize", initializeAndHandleRequest).Methods("GET").Name(AUTHORIZE_ROUTE)
 
+	// Tasks queued before the task dispatcher are keyed by the file and name they were registered with here, keep
+	// these until they've all run
+	delay.Func(REFRESH_USER_DATA_FUNCTION_NAME, updateUserData)
+	delay.Func(PROCESS_FILE_FUNCTION_NAME, processSingleFile)
+	delay.Func(MIGRATE_USER_SCHEMA_FUNCTION_NAME, migrateUserSchemaChunk)
+
 	// Initialize task functions that would otherwise be prone to initialization loops
-	refreshUserData = delay.Func(REFRESH_USER_DATA_FUNCTION_NAME, updateUserData)
-	processFile = delay.Func(PROCESS_FILE_FUNCTION_NAME, processSingleFile)
 	engine.RunGlukitScoreCalculationChunk = delay.Func(engine.GLUKIT_SCORE_BATCH_CALCULATION_FUNCTION_NAME, engine.RunGlukitScoreBatchCalculation)
 	engine.RunA1CCalculationChunk = delay.Func(engine.A1C_BATCH_CALCULATION_FUNCTION_NAME, engine.RunA1CBatchCalculation)
+	engine.RunHypoDetectionChunk = delay.Func(engine.HYPO_DETECTION_FUNCTION_NAME, engine.RunHypoDetectionBatch)
+	retryWebhookDelivery = delay.Func(DELIVER_WEBHOOK_FUNCTION_NAME, deliverWebhook)
 
 	appengine.Main()
 }
@@ -111,6 +192,19 @@ func oauthCallback(writer http.ResponseWriter, request *http.Request) {
 	handleLoggedInUser(writer, request)
 }
 
+// newDemoUserProfile returns the profile of the demo user before any data is imported
+func newDemoUserProfile() model.GlukitUser {
+	dummyToken := oauth.Token{"", "", util.GLUKIT_EPOCH_TIME}
+	// TODO: Populate GlukitUser correctly, this will likely require
+	// getting rid of all data from the store when this is ready
+	return model.GlukitUser{Email: DEMO_EMAIL, FirstName: "Demo", LastName: "OfMe", DateOfBirth: time.Now(),
+		DiabetesType: model.DIABETES_TYPE_1, LastUpdated: time.Now(), MostRecentRead: apimodel.UNDEFINED_GLUCOSE_READ,
+		Token: dummyToken, BestScore: model.UNDEFINED_SCORE, MostRecentScore: model.UNDEFINED_SCORE, Internal: true,
+		PictureUrl: DEMO_PICTURE_URL, AccountCreated: time.Now(), MostRecentA1C: model.UNDEFINED_A1C_ESTIMATE,
+		TargetLow: apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD, TargetHigh: apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD,
+		GlucoseUnit: apimodel.MG_PER_DL, TherapyMode: model.THERAPY_MODE_UNKNOWN}
+}
+
 // renderDemo executes the graph template for the demo user
 func renderDemo(w http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
@@ -118,25 +212,22 @@ func renderDemo(w http.ResponseWriter, request *http.Request) {
 	_, key, _, err := store.GetUserData(context, DEMO_EMAIL)
 	if err == datastore.ErrNoSuchEntity {
 		log.Infof(context, "No data found for demo user [%s], creating it", DEMO_EMAIL)
-		dummyToken := oauth.Token{"", "", util.GLUKIT_EPOCH_TIME}
-		// TODO: Populate GlukitUser correctly, this will likely require
-		// getting rid of all data from the store when this is ready
-		key, err = store.StoreUserProfile(context, time.Now(),
-			model.GlukitUser{DEMO_EMAIL, "Demo", "OfMe", time.Now(), model.DIABETES_TYPE_1, "", time.Now(),
-				apimodel.UNDEFINED_GLUCOSE_READ, dummyToken, "", model.UNDEFINED_SCORE, model.UNDEFINED_SCORE, true, DEMO_PICTURE_URL, time.Now(),
-				model.UNDEFINED_A1C_ESTIMATE})
+		key, err = store.StoreUserProfile(context, time.Now(), newDemoUserProfile())
 		if err != nil {
-			util.Propagate(err)
+			writeError(context, w, err)
+			return
 		}
 
 		task, err := processDemoFile.Task(key)
 		if err != nil {
-			util.Propagate(err)
+			writeError(context, w, err)
+			return
 		}
 		taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
 
 	} else if err != nil {
-		util.Propagate(err)
+		writeError(context, w, err)
+		return
 	} else {
 		log.Infof(context, "Data already stored for demo user [%s], continuing...", DEMO_EMAIL)
 	}
@@ -147,14 +238,14 @@ func renderDemo(w http.ResponseWriter, request *http.Request) {
 // renderRealUser executes the graph page template for a real user
 func renderRealUser(w http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
-	user := user.Current(context)
+	user := currentGlukitUser(context)
 	render(user.Email, "", w, request)
 }
 
 // report executes the report page template
 func demoReport(w http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
-	user := user.Current(context)
+	user := currentGlukitUser(context)
 	unitValue, err := resolveGlucoseUnit(user.Email, request)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -172,7 +263,7 @@ func demoReport(w http.ResponseWriter, request *http.Request) {
 // report executes the report page template
 func report(w http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
-	user := user.Current(context)
+	user := currentGlukitUser(context)
 	unitValue, err := resolveGlucoseUnit(user.Email, request)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -207,6 +298,8 @@ func render(email string, datapath string, w http.ResponseWriter, request *http.
 	}
 }
 
+// resolveGlucoseUnit returns the unit requested with the unit parameter, defaulting to the unit the user chose to see
+// glucose values with
 func resolveGlucoseUnit(email string, request *http.Request) (unit *apimodel.GlucoseUnit, err error) {
 	rawUnitValue := request.FormValue(GLUCOSE_UNIT_PARAMETER)
 	if rawUnitValue != apimodel.MMOL_PER_L && rawUnitValue != apimodel.MG_PER_DL {
@@ -216,7 +309,7 @@ func resolveGlucoseUnit(email string, request *http.Request) (unit *apimodel.Glu
 			return nil, err
 		}
 
-		unitValue := glukitUser.MostRecentRead.Unit
+		unitValue := glukitUser.GetGlucoseUnit()
 		return &unitValue, nil
 	} else {
 		unitValue := apimodel.GlucoseUnit(rawUnitValue)
@@ -227,14 +320,18 @@ func resolveGlucoseUnit(email string, request *http.Request) (unit *apimodel.Glu
 // handleRealUser handles the flow for a real non-demo user. It will redirect to authorization if required
 func handleRealUser(writer http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
-	user := user.Current(context)
+	user := currentGlukitUser(context)
 
 	glukitUser, _, _, err := store.GetUserData(context, user.Email)
-	if _, ok := err.(store.StoreError); err != nil && !ok || len(glukitUser.RefreshToken) == 0 {
+	if _, ok := err.(store.StoreError); err != nil && !ok || len(glukitUser.RefreshToken) == 0 || glukitUser.TokenRevoked ||
+		glukitUser.NeedsGoogleFitAuthorization() {
 		log.Infof(context, "Redirecting [%s], glukitUser [%v] for authorization. Error: [%v]", user.Email, glukitUser, err)
 
 		configuration := configuration()
-		log.Debugf(context, "We don't current have a refresh token (either lost or it's "+
+		if glukitUser != nil && glukitUser.GoogleFitEnabled {
+			configuration = googleFitConfiguration()
+		}
+		log.Debugf(context, "We don't current have a valid refresh token (either lost, revoked or it's "+
 			"the first access). Let's set the ApprovalPrompt to force to get a new one...")
 
 		configuration.ApprovalPrompt = "force"
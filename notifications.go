@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/channel"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/taskqueue"
+	"strconv"
+	"time"
+)
+
+const (
+	REFRESH_MESSAGE_TYPE = "refresh"
+
+	// Refresh messages are sent to a user at most once per REFRESH_NOTIFICATION_INTERVAL. Notifications raised in
+	// between are combined in a single message sent at the end of the interval.
+	REFRESH_NOTIFICATION_INTERVAL            = time.Duration(10) * time.Second
+	FLUSH_REFRESH_NOTIFICATION_FUNCTION_NAME = "flushRefreshNotification"
+	REFRESH_NOTIFICATION_TASK_NAME_PREFIX    = "refreshNotification"
+)
+
+var flushRefreshNotifications = delay.Func(FLUSH_REFRESH_NOTIFICATION_FUNCTION_NAME, flushRefreshNotification)
+
+// refreshMessage is the message sent to the connected client when new data is available. Clients that predate it
+// reload on any message so they keep working.
+type refreshMessage struct {
+	Type          string `json:"type"`
+	FilesImported int    `json:"filesImported"`
+}
+
+func pendingRefreshCacheKey(userEmail string) string {
+	return fmt.Sprintf("refreshNotification.pending.%s", userEmail)
+}
+
+func sentRefreshCacheKey(userEmail string) string {
+	return fmt.Sprintf("refreshNotification.sent.%s", userEmail)
+}
+
+// notifyRefresh notifies the user's connected client that new data is available, filesImported being the number of
+// files whose import added data. The notification is combined with the other ones of the user pending in memcache and
+// sent right away if no message was sent in the last REFRESH_NOTIFICATION_INTERVAL, at the end of the interval
+// otherwise. If memcache isn't available, the notification is sent on its own.
+func notifyRefresh(context context.Context, userEmail string, filesImported int) {
+	if _, err := memcache.Increment(context, pendingRefreshCacheKey(userEmail), int64(filesImported), 0); err != nil {
+		log.Warningf(context, "Error recording pending refresh notification of user [%s], sending it right away: %v", userEmail, err)
+		sendRefreshMessage(context, userEmail, filesImported)
+		return
+	}
+
+	sent := &memcache.Item{Key: sentRefreshCacheKey(userEmail), Value: []byte{}, Expiration: REFRESH_NOTIFICATION_INTERVAL}
+	if err := memcache.Add(context, sent); err == nil {
+		flushRefreshNotification(context, userEmail)
+		return
+	} else if err != memcache.ErrNotStored {
+		log.Warningf(context, "Error checking the last refresh notification of user [%s], sending it right away: %v", userEmail, err)
+		flushRefreshNotification(context, userEmail)
+		return
+	}
+
+	// Notifications of the same interval share the name of the task that flushes them so that only one is enqueued
+	eta := time.Now().Add(REFRESH_NOTIFICATION_INTERVAL)
+	task, err := flushRefreshNotifications.Task(userEmail)
+	if err != nil {
+		log.Warningf(context, "Error creating refresh notification task for user [%s]: %v", userEmail, err)
+		return
+	}
+
+	task.Name = fmt.Sprintf("%s-%x-%d", REFRESH_NOTIFICATION_TASK_NAME_PREFIX, sha1.Sum([]byte(userEmail)),
+		eta.UnixNano()/int64(REFRESH_NOTIFICATION_INTERVAL))
+	task.ETA = eta
+	if _, err := taskqueue.Add(context, task, REFRESH_QUEUE_NAME); err != nil && err != taskqueue.ErrTaskAlreadyAdded {
+		log.Warningf(context, "Error enqueuing refresh notification of user [%s]: %v", userEmail, err)
+	}
+}
+
+// flushRefreshNotification sends a single message for all the refresh notifications of the user pending in memcache,
+// if any
+func flushRefreshNotification(context context.Context, userEmail string) {
+	key := pendingRefreshCacheKey(userEmail)
+	pending, err := memcache.Get(context, key)
+	if err == memcache.ErrCacheMiss {
+		return
+	} else if err != nil {
+		log.Warningf(context, "Error getting pending refresh notifications of user [%s]: %v", userEmail, err)
+		return
+	}
+
+	// Only take the notifications read so that the ones added since get sent by the next flush
+	filesImported, err := strconv.ParseInt(string(pending.Value), 10, 64)
+	if err != nil {
+		log.Warningf(context, "Invalid pending refresh notifications [%s] of user [%s]: %v", string(pending.Value), userEmail, err)
+	}
+
+	if _, err := memcache.Increment(context, key, -filesImported, 0); err != nil {
+		log.Warningf(context, "Error clearing pending refresh notifications of user [%s]: %v", userEmail, err)
+	}
+
+	sendRefreshMessage(context, userEmail, int(filesImported))
+}
+
+// sendRefreshMessage sends a refresh message to the user's connected client. Failures to send are only logged, the
+// client refreshes on its next load anyway.
+func sendRefreshMessage(context context.Context, userEmail string, filesImported int) {
+	message := refreshMessage{REFRESH_MESSAGE_TYPE, filesImported}
+	if err := channel.SendJSON(context, userEmail, message); err != nil {
+		log.Debugf(context, "Error sending refresh message [%v] to user [%s]: %v", message, userEmail, err)
+	}
+}
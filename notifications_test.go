@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/memcache"
+	"testing"
+)
+
+func TestRefreshNotificationsOfAnIntervalAreCoalesced(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	email := "notified@glukit.com"
+	notifyRefresh(c, email, 1)
+	if pending := pendingRefreshNotifications(t, c, email); pending != "0" {
+		t.Errorf("Expected the first notification to be sent right away but got [%s] pending", pending)
+	}
+
+	notifyRefresh(c, email, 1)
+	notifyRefresh(c, email, 1)
+	if pending := pendingRefreshNotifications(t, c, email); pending != "2" {
+		t.Errorf("Expected notifications of the same interval to wait for the flush but got [%s] pending", pending)
+	}
+
+	flushRefreshNotification(c, email)
+	if pending := pendingRefreshNotifications(t, c, email); pending != "0" {
+		t.Errorf("Expected pending notifications to be sent by the flush but got [%s] pending", pending)
+	}
+}
+
+func TestRefreshMessageIsSentAsJson(t *testing.T) {
+	encoded, err := json.Marshal(refreshMessage{REFRESH_MESSAGE_TYPE, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(encoded) != `{"type":"refresh","filesImported":3}` {
+		t.Errorf("Expected refresh message with its type and number of files imported but got [%s]", string(encoded))
+	}
+}
+
+func pendingRefreshNotifications(t *testing.T, c aetest.Context, userEmail string) string {
+	item, err := memcache.Get(c, pendingRefreshCacheKey(userEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(item.Value)
+}
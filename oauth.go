@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/auth"
 	"github.com/alexandre-normand/glukit/app/model"
 	"github.com/alexandre-normand/glukit/app/store"
 	"github.com/alexandre-normand/glukit/app/util"
@@ -11,7 +12,6 @@ import (
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/user"
 	"html/template"
 	"net/http"
 	"strings"
@@ -48,7 +48,7 @@ func initOauthProvider(writer http.ResponseWriter, request *http.Request) {
 	server = osin.NewServer(sconfig, store.NewOsinAppEngineStoreWithRequest(request))
 	muxRouter.Get(AUTHORIZE_ROUTE).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		c := appengine.NewContext(req)
-		user := user.Current(c)
+		user := currentGlukitUser(c)
 		resp := server.NewResponse()
 		req.ParseForm()
 		req.SetBasicAuth(req.Form.Get("client_id"), req.Form.Get("client_secret"))
@@ -62,9 +62,13 @@ func initOauthProvider(writer http.ResponseWriter, request *http.Request) {
 			if err == datastore.ErrNoSuchEntity {
 				log.Debugf(c, "Creating GlukitUser on first oauth access for [%s]: ", user.Email)
 				// If the user doesn't exist already, create it
-				glukitUser := model.GlukitUser{user.Email, "", "", time.Now(),
-					model.DIABETES_TYPE_1, "", util.GLUKIT_EPOCH_TIME, apimodel.UNDEFINED_GLUCOSE_READ, oauth.Token{"", "", util.GLUKIT_EPOCH_TIME}, "",
-					model.UNDEFINED_SCORE, model.UNDEFINED_SCORE, false, "", time.Now(), model.UNDEFINED_A1C_ESTIMATE}
+				glukitUser := model.GlukitUser{Email: user.Email, DateOfBirth: time.Now(),
+					DiabetesType: model.DIABETES_TYPE_UNKNOWN, LastUpdated: util.GLUKIT_EPOCH_TIME,
+					MostRecentRead: apimodel.UNDEFINED_GLUCOSE_READ, Token: oauth.Token{"", "", util.GLUKIT_EPOCH_TIME},
+					BestScore: model.UNDEFINED_SCORE, MostRecentScore: model.UNDEFINED_SCORE, AccountCreated: time.Now(),
+					MostRecentA1C: model.UNDEFINED_A1C_ESTIMATE, TargetLow: apimodel.DEFAULT_LOW_GLUCOSE_THRESHOLD,
+					TargetHigh: apimodel.DEFAULT_HIGH_GLUCOSE_THRESHOLD, GlucoseUnit: apimodel.MG_PER_DL,
+					TherapyMode: model.THERAPY_MODE_UNKNOWN, Onboarding: model.NewOnboardingState(time.Now())}
 				_, err = store.StoreUserProfile(c, time.Now(), glukitUser)
 				if err != nil {
 					resp.SetError(osin.E_SERVER_ERROR, fmt.Sprintf("Fail to initialize user for email [%s]: [%v]", user.Email, err))
@@ -79,10 +83,6 @@ func initOauthProvider(writer http.ResponseWriter, request *http.Request) {
 				return
 			}
 
-			if err != nil {
-				util.Propagate(err)
-			}
-
 			server.FinishAuthorizeRequest(resp, req, ar)
 
 			data := resp.Output
@@ -151,6 +151,23 @@ func (handler *oauthAuthenticatedHandler) ServeHTTP(writer http.ResponseWriter,
 		return
 	}
 
+	// native clients authenticate with the id token of their Google sign-in instead of an access token
+	if auth.IsIdToken(accessCode) {
+		if _, err := authenticateIdToken(c, accessCode); err != nil {
+			apiError, ok := err.(ApiError)
+			if !ok {
+				log.Errorf(c, "Error authenticating id token: %v", err)
+				apiError = ApiError{Status: http.StatusInternalServerError, Message: fmt.Sprintf("Error authenticating request: %v", err)}
+			}
+
+			writeApiError(writer, apiError)
+			return
+		}
+
+		handler.authenticatedHandler.ServeHTTP(writer, request)
+		return
+	}
+
 	var err error
 
 	// load access data
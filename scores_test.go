@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGlukitScoreHistoryIsServedAsJson(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	email := "history@glukit.com"
+	upperBound := time.Date(2015, time.March, 7, 0, 0, 0, 0, time.UTC)
+	score := model.GlukitScore{Value: 42, LowerBound: upperBound.AddDate(0, 0, -7), UpperBound: upperBound, CalculatedOn: upperBound}
+	if err := store.StoreGlukitScoreBatch(c, email, []model.GlukitScore{score}); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", fmt.Sprintf("/glukitScoreHistory?%s=%d&%s=%d", QUERY_PARAM_FROM,
+		upperBound.AddDate(0, 0, -1).Unix(), QUERY_PARAM_TO, upperBound.AddDate(0, 0, 1).Unix()), nil)
+	serveGlukitScoreHistory(c, recorder, request, email)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected [%d] but got [%d]: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+
+	var history []model.GlukitScore
+	if err := json.Unmarshal(recorder.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 1 || history[0].Value != score.Value || !history[0].UpperBound.Equal(upperBound) {
+		t.Errorf("Expected history of score [%v] but got [%v]", score, history)
+	}
+}
+
+func TestGlukitScoreHistoryRejectsInvalidBounds(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/glukitScoreHistory?"+QUERY_PARAM_FROM+"=yesterday", nil)
+	serveGlukitScoreHistory(c, recorder, request, "history@glukit.com")
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected [%d] for an invalid lower bound but got [%d]", http.StatusBadRequest, recorder.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"code.google.com/p/gorilla/mux"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSnapshotDataIsNotServedOnceExpired(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/snapshot/{token}/data", func(writer http.ResponseWriter, request *http.Request) {
+		serveSnapshotData(c, writer, request)
+	})
+
+	now := time.Now()
+	userProfileKey := store.GetUserKey(c, "snapshots@glukit.com")
+	for _, expected := range []struct {
+		expiresAt time.Time
+		status    int
+	}{{now.Add(time.Hour), http.StatusOK}, {now.Add(-time.Hour), http.StatusNotFound}} {
+		snapshot, err := model.NewSnapshot("checkup", now.AddDate(0, 0, -1), now, apimodel.MG_PER_DL, model.SnapshotContent{},
+			now.Add(-time.Duration(2)*time.Hour), expected.expiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.StoreSnapshot(c, userProfileKey, snapshot, model.MAX_SNAPSHOTS_PER_USER, now.Add(-time.Duration(2)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/snapshot/"+snapshot.Token+"/data", nil)
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != expected.status {
+			t.Errorf("Expected [%d] for the data of a snapshot expiring at [%s] but got [%d]", expected.status, expected.expiresAt,
+				recorder.Code)
+		}
+	}
+}
+
+func TestSnapshotsCoverAtMostSevenDays(t *testing.T) {
+	for _, expected := range []struct {
+		to    string
+		valid bool
+	}{{"2015-03-07", true}, {"2015-03-08", false}} {
+		request, _ := http.NewRequest("POST", "/settings/snapshots", nil)
+		request.Form = url.Values{FORM_FIELD_SNAPSHOT_FROM: {"2015-03-01"}, FORM_FIELD_SNAPSHOT_TO: {expected.to}}
+
+		_, err := parseSnapshotRange(request, time.UTC)
+		if valid := err == nil; valid != expected.valid {
+			t.Errorf("Expected snapshot from [2015-03-01] to [%s] to be valid [%t] but got [%v]", expected.to, expected.valid, err)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
 	"github.com/alexandre-normand/glukit/app/engine"
 	"github.com/alexandre-normand/glukit/app/importer"
 	"github.com/alexandre-normand/glukit/app/model"
@@ -16,28 +21,80 @@ import (
 	"google.golang.org/appengine/log"
 	"google.golang.org/appengine/taskqueue"
 	"google.golang.org/appengine/urlfetch"
-	"os"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
 	"time"
 )
 
-var processFile = delay.Func(PROCESS_FILE_FUNCTION_NAME, func(context context.Context, token *oauth.Token, file *drive.File, userEmail string,
-	userProfileKey *datastore.Key) {
-	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
-		"shows up because the function calls a function that calls this one. This implementation defines the same signature as the "+
-		"real one which we define in init() to override this implementation!")
-})
 var processDemoFile = delay.Func("processDemoFile", processStaticDemoFile)
-var refreshUserData = delay.Func(REFRESH_USER_DATA_FUNCTION_NAME, func(context context.Context, userEmail string,
-	autoScheduleNextRun bool) {
-	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
-		"shows up because the function calls itself. This implementation defines the same signature as the "+
-		"real one which we define in init() to override this implementation!")
-})
+var reseedDemo = delay.Func(RESEED_DEMO_FUNCTION_NAME, reseedDemoData)
+var importNightscout = delay.Func(IMPORT_NIGHTSCOUT_FUNCTION_NAME, processNightscoutImport)
+var importGoogleFit = delay.Func(IMPORT_GOOGLE_FIT_FUNCTION_NAME, processGoogleFitImport)
+var checkIntegrity = delay.Func(CHECK_READS_INTEGRITY_FUNCTION_NAME, checkReadsIntegrity)
 
 const (
-	REFRESH_USER_DATA_FUNCTION_NAME = "refreshUserData"
-	PROCESS_FILE_FUNCTION_NAME      = "processSingleFile"
-	DATASTORE_WRITES_QUEUE_NAME     = "datastore-writes"
+	REFRESH_USER_DATA_FUNCTION_NAME     = "refreshUserData"
+	PROCESS_FILE_FUNCTION_NAME          = "processSingleFile"
+	IMPORT_NIGHTSCOUT_FUNCTION_NAME     = "processNightscoutImport"
+	IMPORT_GOOGLE_FIT_FUNCTION_NAME     = "processGoogleFitImport"
+	MIGRATE_USER_SCHEMA_FUNCTION_NAME   = "migrateUserSchema"
+	CHECK_READS_INTEGRITY_FUNCTION_NAME = "checkReadsIntegrity"
+	RESEED_DEMO_FUNCTION_NAME           = "reseedDemoData"
+	DATASTORE_WRITES_QUEUE_NAME         = "datastore-writes"
+	// Number of retries of the tasks of the datastore-writes queue, keep in sync with queue.yml
+	DATASTORE_WRITES_TASK_RETRY_LIMIT = 5
+	REFRESH_QUEUE_NAME                = "refresh"
+
+	// Refresh tasks are named after their trigger, see refreshTaskName
+	REFRESH_TASK_NAME_PREFIX  = "refresh"
+	REFRESH_TRIGGER_SCHEDULED = "scheduled"
+	REFRESH_TRIGGER_LOGIN     = "login"
+	REFRESH_TRIGGER_MANUAL    = "manual"
+
+	// Id of the FileImportLog that keeps the watermark of the Google Fit import of a user and how far back the first
+	// import of a user goes
+	GOOGLE_FIT_IMPORT_LOG_ID     = "googleFit"
+	GOOGLE_FIT_INITIAL_SYNC_DAYS = 30
+
+	// Time a refresh holds the refresh lease of a user for, at most, matching the deadline of push queue tasks
+	REFRESH_LEASE_DURATION = time.Duration(10) * time.Minute
+
+	// The daily scheduling of refreshes checks users in batches and spreads the refreshes of the ones that fell out of
+	// their chain of refreshes over REFRESH_SCHEDULING_SPREAD to go easy on the Drive API
+	SCHEDULE_REFRESHES_FUNCTION_NAME   = "scheduleRefreshes"
+	REFRESH_SCHEDULING_USERS_PER_BATCH = 100
+	REFRESH_SCHEDULING_SPREAD          = time.Duration(4) * time.Hour
+
+	// The encryption of stored tokens with the current key goes through users in batches
+	SEAL_TOKENS_FUNCTION_NAME     = "sealTokens"
+	TOKEN_SEALING_USERS_PER_BATCH = 100
+
+	// Progress of file imports is sent to the connected client at most once per interval or number of records
+	IMPORT_PROGRESS_INTERVAL = time.Duration(5) * time.Second
+	IMPORT_PROGRESS_RECORDS  = 5000
+	IMPORT_PROGRESS_TYPE     = "progress"
+
+	// File imports that fail for reasons other than their content are retried with a delay that grows exponentially,
+	// up to a maximum, until they reach the maximum number of attempts
+	MAX_FILE_IMPORT_ATTEMPTS     = 5
+	FILE_IMPORT_RETRY_BASE_DELAY = time.Duration(1) * time.Hour
+	FILE_IMPORT_RETRY_MAX_DELAY  = time.Duration(48) * time.Hour
+	FILE_IMPORT_RETRY_FACTOR     = 4
+	IMPORT_FAILURE_TYPE          = "importFailure"
+
+	// Static resource of the data of the demo user
+	DEMO_DATA_FILE = "data.xml"
+
+	// Files found by a refresh are imported from the most to the least recently modified, each one enqueued
+	// FILE_IMPORT_STAGGER after the previous one. At most MAX_CONCURRENT_FILE_IMPORTS imports of a user run at the same
+	// time, the other ones are pushed back by FILE_IMPORT_SLOT_WAIT until an import slot frees up.
+	FILE_IMPORT_STAGGER         = time.Duration(30) * time.Second
+	MAX_CONCURRENT_FILE_IMPORTS = 2
+	FILE_IMPORT_SLOT_WAIT       = time.Duration(1) * time.Minute
+	FILE_IMPORT_LEASE_DURATION  = time.Duration(10) * time.Minute
 )
 
 func disabledUpdateUserData(context context.Context, userEmail string, autoScheduleNextRun bool) {
@@ -46,15 +103,36 @@ func disabledUpdateUserData(context context.Context, userEmail string, autoSched
 
 // updateUserData is an async task that searches on Google Drive for dexcom files. It handles some high
 // watermark of the last import to avoid downloading already imported files (unless they've been updated).
-// It also schedules itself to run again the next day unless the token is invalid.
+// It also schedules itself to run again after the user's refresh interval unless the token is invalid or the user
+// paused refreshes. A token Google reports as revoked is flagged on the user so that refreshes stop until the user
+// authorizes access again. Refreshes back off to weekly ones when many consecutive ones found no new data.
 func updateUserData(context context.Context, userEmail string, autoScheduleNextRun bool) {
 	glukitUser, userProfileKey, _, err := store.GetUserData(context, userEmail)
-	if _, ok := err.(store.StoreError); err != nil && !ok {
+	if err == datastore.ErrNoSuchEntity {
+		// Refreshes scheduled before the user deleted their account stop here, without scheduling the next one
+		log.Infof(context, "User [%s] doesn't exist anymore, skipping refresh", userEmail)
+		return
+	} else if _, ok := err.(store.StoreError); err != nil && !ok {
 		log.Errorf(context, "We're trying to run an update data task for user [%s] that doesn't exist. "+
 			"Got error: %v", userEmail, err)
 		return
 	}
 
+	// Scheduled refreshes stop here while paused, resuming refreshes schedules a new one
+	if autoScheduleNextRun && glukitUser.RefreshPaused {
+		if _, err := store.RecordRefresh(context, userProfileKey, model.REFRESH_NOT_SEARCHED, true, time.Now()); err != nil {
+			log.Warningf(context, "Error recording the skipped refresh of user [%s]: %v", userEmail, err)
+		}
+		log.Infof(context, "Refreshes are paused for user [%s], skipping refresh and not scheduling the next one", userEmail)
+		return
+	}
+
+	if glukitUser.TokenRevoked {
+		log.Infof(context, "Token of user [%s] was revoked on [%s], skipping refresh until access is authorized again: [%s]",
+			userEmail, glukitUser.TokenRevokedOn.Format(util.TIMEFORMAT), glukitUser.TokenRevocationReason)
+		return
+	}
+
 	transport := &oauth.Transport{
 		Config: configuration(),
 		Transport: &urlfetch.Transport{
@@ -67,43 +145,73 @@ func updateUserData(context context.Context, userEmail string, autoScheduleNextR
 	if glukitUser.Token.Expired() {
 		transport.Token.RefreshToken = glukitUser.RefreshToken
 		err := transport.Refresh(context)
-		if err != nil {
+		if oauth.IsInvalidGrant(err) {
+			// Retrying is pointless until the user authorizes access again, which clears the flag and resumes refreshes
+			if err := store.MarkTokenRevoked(context, userProfileKey, time.Now()); err != nil {
+				log.Warningf(context, "Error flagging the revoked token of user [%s]: %v", userEmail, err)
+			}
+			log.Warningf(context, "Token of user [%s] was revoked, stopping refreshes until access is authorized again: %v",
+				userEmail, err)
+			return
+		} else if err != nil {
 			log.Errorf(context, "Error updating token for user [%s], let's hope he comes back soon so we can "+
 				"get a fresh token: %v", userEmail, err)
 			return
 		}
 
 		// Update the user with the new token
-		log.Infof(context, "Token refreshed, updating user [%s] with token [%v]", userEmail, glukitUser.Token)
+		log.Infof(context, "Token refreshed, updating user [%s] with token expiring on [%s]", userEmail,
+			glukitUser.Token.Expiry.Format(util.TIMEFORMAT))
 		store.StoreUserProfile(context, time.Now(), *glukitUser)
 	}
 
-	// Next update in one day
-	nextUpdate := time.Now().AddDate(0, 0, 1)
-	files, err := importer.SearchDataFiles(transport.Client(), glukitUser.MostRecentRead.GetTime())
-	if err != nil {
-		log.Warningf(context, "Error while searching for files on google drive for user [%s]: %v", userEmail, err)
+	// Only one refresh of a user searches for and imports new data at a time, the other ones only schedule the next
+	outcome := model.REFRESH_NOT_SEARCHED
+	now := time.Now()
+	lease := model.RefreshLease{strconv.FormatInt(now.UnixNano(), 10), now, now.Add(REFRESH_LEASE_DURATION)}
+	if err := store.AcquireRefreshLease(context, userEmail, lease); err == store.ErrRefreshInProgress {
+		log.Infof(context, "Another refresh of user [%s] is in progress, skipping this one", userEmail)
+	} else if err != nil {
+		log.Warningf(context, "Error acquiring the refresh lease of user [%s], skipping refresh: %v", userEmail, err)
 	} else {
-		switch {
-		case len(files) == 0:
-			log.Infof(context, "No new or updated data found for existing user [%s]", userEmail)
-		case len(files) > 0:
-			log.Infof(context, "Found new data files for user [%s], downloading and storing...", userEmail)
-			processFileSearchResults(&glukitUser.Token, files, context, userEmail, userProfileKey)
+		onboarding := glukitUser.GetOnboardingState().IsOnboarding()
+		if onboarding {
+			engine.AdvanceOnboarding(context, userEmail, func(state *model.OnboardingState) bool {
+				return state.StartSearch(time.Now())
+			})
+		}
+
+		var filesFound int
+		var searchErr error
+		outcome, filesFound, searchErr = importNewUserData(context, glukitUser, userProfileKey, transport)
+		if onboarding {
+			engine.AdvanceOnboarding(context, userEmail, func(state *model.OnboardingState) bool {
+				return state.FinishSearch(filesFound, searchErr, time.Now())
+			})
+		}
+
+		if err := store.ReleaseRefreshLease(context, userEmail, lease.Holder); err != nil {
+			log.Warningf(context, "Error releasing the refresh lease of user [%s], it will expire at [%s]: %v", userEmail,
+				lease.ExpiresOn.Format(util.TIMEFORMAT), err)
 		}
 	}
 
-	engine.StartGlukitScoreBatch(context, glukitUser)
-	engine.StartA1CCalculationBatch(context, glukitUser)
+	nextUpdate, err := store.RecordRefresh(context, userProfileKey, outcome, autoScheduleNextRun, time.Now())
+	if err != nil {
+		log.Warningf(context, "Error recording the refresh of user [%s]: %v", userEmail, err)
+		if !glukitUser.RefreshPaused {
+			nextUpdate = time.Now().Add(glukitUser.NextRefreshInterval())
+		}
+	}
 
-	if autoScheduleNextRun {
-		task, err := refreshUserData.Task(userEmail, autoScheduleNextRun)
-		if err != nil {
+	if autoScheduleNextRun && nextUpdate.IsZero() {
+		log.Infof(context, "Refreshes are paused for user [%s], not scheduling the next one", userEmail)
+	} else if autoScheduleNextRun {
+		if err := enqueueRefresh(context, userEmail, autoScheduleNextRun, nextUpdate, REFRESH_TRIGGER_SCHEDULED); err != nil {
 			log.Criticalf(context, "Couldn't schedule the next execution of the data refresh for user [%s]. "+
 				"This breaks background updating of user data!: %v", userEmail, err)
+			return
 		}
-		task.ETA = nextUpdate
-		taskqueue.Add(context, task, "refresh")
 
 		log.Infof(context, "Scheduled next data update for user [%s] at [%s]", userEmail, nextUpdate.Format(util.TIMEFORMAT))
 	} else {
@@ -111,22 +219,605 @@ func updateUserData(context context.Context, userEmail string, autoScheduleNextR
 	}
 }
 
-// processFileSearchResults reads the list of files detected on google drive and kicks off a new queued task
+// importNewUserData searches Google Drive, and the other sources of data files of the user, for new data files and
+// enqueues their import along with the one of the user's nightscout data and Google Fit sessions, if any. The Google
+// Drive of accounts linked to the user is searched too. The background calculations on the user's data are started
+// as well. The number of files found is returned along with the error of the last search that failed, if any.
+func importNewUserData(context context.Context, glukitUser *model.GlukitUser, userProfileKey *datastore.Key,
+	transport *oauth.Transport) (outcome model.RefreshOutcome, filesFound int, searchErr error) {
+	outcome = model.REFRESH_NOT_SEARCHED
+	for _, source := range fileSourcesOf(glukitUser) {
+		sourceTransport := transport
+		if source != importer.FILE_SOURCE_DRIVE {
+			sourceTransport = &oauth.Transport{
+				Config: fileSourceConfiguration(source),
+				Transport: &urlfetch.Transport{
+					Context: context,
+				},
+				Token: fileSourceToken(glukitUser, source),
+			}
+		}
+
+		found, err := searchDataFiles(context, glukitUser, userProfileKey, source, sourceTransport, glukitUser.DriveFolderId, &outcome)
+		filesFound += found
+		if err != nil {
+			searchErr = err
+		}
+	}
+
+	aliases, err := store.GetAccountAliases(context, glukitUser.Email)
+	if err != nil {
+		log.Warningf(context, "Error getting accounts linked to user [%s], not searching them: %v", glukitUser.Email, err)
+	}
+
+	for i := range aliases {
+		if !aliases[i].HasToken() {
+			log.Warningf(context, "No usable token for account [%s] linked to user [%s], it has to be linked again", aliases[i].Email,
+				glukitUser.Email)
+			continue
+		}
+
+		log.Debugf(context, "Searching google drive of account [%s] linked to user [%s]", aliases[i].Email, glukitUser.Email)
+		aliasTransport := &oauth.Transport{
+			Config: configuration(),
+			Transport: &urlfetch.Transport{
+				Context: context,
+			},
+			Token: &aliases[i].Token,
+		}
+
+		found, err := searchDataFiles(context, glukitUser, userProfileKey, importer.FILE_SOURCE_DRIVE, aliasTransport, "", &outcome)
+		filesFound += found
+		if err != nil {
+			searchErr = err
+		}
+	}
+
+	if glukitUser.NightscoutUrl != "" {
+		if task, err := importNightscout.Task(glukitUser.Email, userProfileKey); err != nil {
+			log.Warningf(context, "Error creating nightscout import task for user [%s]: %v", glukitUser.Email, err)
+		} else if _, err = taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME); err != nil {
+			log.Warningf(context, "Error enqueuing nightscout import for user [%s]: %v", glukitUser.Email, err)
+		}
+	}
+
+	if glukitUser.GoogleFitEnabled && glukitUser.GoogleFitAuthorized {
+		if task, err := importGoogleFit.Task(glukitUser.Email, userProfileKey); err != nil {
+			log.Warningf(context, "Error creating google fit import task for user [%s]: %v", glukitUser.Email, err)
+		} else if _, err = taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME); err != nil {
+			log.Warningf(context, "Error enqueuing google fit import for user [%s]: %v", glukitUser.Email, err)
+		}
+	}
+
+	engine.StartGlukitScoreBatch(context, glukitUser)
+	engine.StartA1CCalculationBatch(context, glukitUser)
+	engine.StartHypoDetectionBatch(context, glukitUser)
+
+	if err := engine.StartWeeklySummary(context, glukitUser); err != nil {
+		log.Warningf(context, "Error starting weekly summary for user [%s]: %v", glukitUser.Email, err)
+	}
+
+	return outcome, filesFound, searchErr
+}
+
+// searchDataFiles searches the source for new data files of the user and enqueues their import. The outcome of the
+// refresh so far is updated with the one of the search and the number of files found is returned along with the error
+// of the search, if it failed.
+func searchDataFiles(context context.Context, glukitUser *model.GlukitUser, userProfileKey *datastore.Key, source string,
+	transport *oauth.Transport, folderId string, outcome *model.RefreshOutcome) (filesFound int, err error) {
+	files, err := newFileSource(context, source, transport, folderId).SearchDataFiles(glukitUser.MostRecentRead.GetTime())
+	if err != nil {
+		log.Warningf(context, "Error while searching for files on %s for user [%s]: %v", fileSourceNames[source], glukitUser.Email, err)
+		return 0, err
+	} else if len(files) == 0 {
+		log.Infof(context, "No new or updated data found on %s for existing user [%s]", fileSourceNames[source], glukitUser.Email)
+		if *outcome == model.REFRESH_NOT_SEARCHED {
+			*outcome = model.REFRESH_WITHOUT_NEW_DATA
+		}
+	} else {
+		log.Infof(context, "Found new data files on %s for user [%s], downloading and storing...", fileSourceNames[source], glukitUser.Email)
+		processFileSearchResults(source, transport.Token, files, context, glukitUser.Email, userProfileKey)
+		*outcome = model.REFRESH_WITH_NEW_DATA
+	}
+
+	return len(files), nil
+}
+
+// refreshTaskName returns the name of a refresh task of the user running at eta. Names are deterministic so that the
+// task queue rejects duplicates: scheduled refreshes are named after the hour of their eta, the shortest refresh
+// interval, and refreshes kicked off by logging in after their day. Manual refreshes, forced by an admin or by resuming
+// refreshes, get a unique name so that they always run.
+func refreshTaskName(userEmail string, eta time.Time, trigger string) string {
+	emailHash := fmt.Sprintf("%x", sha1.Sum([]byte(userEmail)))
+	switch trigger {
+	case REFRESH_TRIGGER_LOGIN:
+		return fmt.Sprintf("%s-%s-%s-%s", REFRESH_TASK_NAME_PREFIX, emailHash, eta.UTC().Format("20060102"), trigger)
+	case REFRESH_TRIGGER_MANUAL:
+		return fmt.Sprintf("%s-%s-%s-%s-%d", REFRESH_TASK_NAME_PREFIX, emailHash, eta.UTC().Format("20060102"), trigger, eta.UnixNano())
+	default:
+		return fmt.Sprintf("%s-%s-%s", REFRESH_TASK_NAME_PREFIX, emailHash, eta.UTC().Format("2006010215"))
+	}
+}
+
+// enqueueRefresh enqueues a refresh of the user's data to run at eta, named by refreshTaskName after its trigger. A
+// refresh that was already added under the same name is a duplicate and isn't an error.
+func enqueueRefresh(context context.Context, userEmail string, autoScheduleNextRun bool, eta time.Time, trigger string) error {
+	task, err := newDispatchedTask(REFRESH_USER_DATA_FUNCTION_NAME, refreshTaskArguments{userEmail, autoScheduleNextRun})
+	if err != nil {
+		return err
+	}
+
+	task.Name = refreshTaskName(userEmail, eta, trigger)
+	task.ETA = eta
+	if _, err = taskqueue.Add(context, task, REFRESH_QUEUE_NAME); err == taskqueue.ErrTaskAlreadyAdded {
+		log.Infof(context, "Refresh [%s] of user [%s] was already added, skipping duplicate", task.Name, userEmail)
+		return nil
+	}
+
+	return err
+}
+
+// scheduleRefreshesBatch checks a batch of users, starting at cursor, and enqueues a refresh of the ones whose refreshes
+// are overdue to restart their chain of scheduled refreshes. Users that are still in their chain are left alone, their
+// next refresh is already queued. It enqueues itself to check the next batch until all users have been checked.
+func scheduleRefreshesBatch(context context.Context, cursor string, start time.Time) error {
+	users, nextCursor, err := store.ListUsers(context, cursor, REFRESH_SCHEDULING_USERS_PER_BATCH)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	scheduled := 0
+	for _, user := range users {
+		if !user.IsRefreshOverdue(now) {
+			continue
+		}
+
+		if err := enqueueRefresh(context, user.Email, true, scheduledRefreshTime(user.Email, start), REFRESH_TRIGGER_SCHEDULED); err != nil {
+			log.Warningf(context, "Error scheduling the overdue refresh of user [%s]: %v", user.Email, err)
+			continue
+		}
+		scheduled++
+	}
+
+	log.Infof(context, "Scheduled overdue refreshes of [%d] of [%d] users", scheduled, len(users))
+
+	if len(nextCursor) == 0 {
+		return nil
+	}
+
+	return enqueueRefreshScheduling(context, nextCursor, start)
+}
+
+// scheduledRefreshTime returns the time, within REFRESH_SCHEDULING_SPREAD of start, of the refresh of the user scheduled
+// by scheduleRefreshesBatch. The time is derived from the email so that a retried batch enqueues refreshes under the
+// same names, which the task queue rejects as duplicates.
+func scheduledRefreshTime(userEmail string, start time.Time) time.Time {
+	hash := fnv.New64a()
+	hash.Write([]byte(userEmail))
+
+	return start.Add(time.Duration(hash.Sum64() % uint64(REFRESH_SCHEDULING_SPREAD)))
+}
+
+// enqueueRefreshScheduling enqueues the batch of refresh scheduling starting at cursor
+func enqueueRefreshScheduling(context context.Context, cursor string, start time.Time) error {
+	task, err := newDispatchedTask(SCHEDULE_REFRESHES_FUNCTION_NAME, scheduleRefreshesTaskArguments{cursor, start})
+	if err != nil {
+		return err
+	}
+
+	_, err = taskqueue.Add(context, task, REFRESH_QUEUE_NAME)
+	return err
+}
+
+// sealTokensBatch stores the tokens of a batch of users, starting at cursor, encrypted with the current key of the
+// token key ring. Tokens stored in plaintext, before they were encrypted, or with a previous key get encrypted again. It
+// enqueues itself to go through the next batch until all users have been.
+func sealTokensBatch(context context.Context, cursor string) error {
+	users, nextCursor, err := store.ListUsers(context, cursor, TOKEN_SEALING_USERS_PER_BATCH)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if err := store.SealUserTokens(context, store.GetUserKey(context, user.Email)); err != nil {
+			return err
+		}
+	}
+
+	log.Infof(context, "Encrypted the tokens of [%d] users", len(users))
+
+	if len(nextCursor) == 0 {
+		return nil
+	}
+
+	return enqueueTokenSealing(context, nextCursor)
+}
+
+// enqueueTokenSealing enqueues the batch of token encryption starting at cursor
+func enqueueTokenSealing(context context.Context, cursor string) error {
+	task, err := newDispatchedTask(SEAL_TOKENS_FUNCTION_NAME, sealTokensTaskArguments{cursor})
+	if err != nil {
+		return err
+	}
+
+	_, err = taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+	return err
+}
+
+// migrateUserSchemaChunk is an async task that rewrites a batch of the day entities of a user stored with an older
+// schema version. It walks the kinds of apimodel.VersionedKinds in order, starting at kindIndex and cursor, and schedules
+// itself to continue with the next batch until all kinds have been checked.
+func migrateUserSchemaChunk(context context.Context, userEmail string, kindIndex int, cursor string) (err error) {
+	defer recordFinalFailure(context, MIGRATE_USER_SCHEMA_FUNCTION_NAME, userEmail, "Schema migration",
+		schemaMigrationArguments{kindIndex, cursor}, &err)
+
+	kinds := apimodel.VersionedKinds()
+	if kindIndex >= len(kinds) {
+		log.Warningf(context, "Schema migration chunk for user [%s] started past the last kind [%d]", userEmail, kindIndex)
+		return nil
+	}
+
+	nextCursor, migrated, err := store.MigrateDayEntities(context, userEmail, kinds[kindIndex], cursor)
+	if err != nil {
+		log.Errorf(context, "Error migrating [%s] for user [%s], failing the chunk for a retry: %v", kinds[kindIndex], userEmail, err)
+		return err
+	}
+
+	if len(nextCursor) == 0 {
+		log.Infof(context, "Done migrating [%s] for user [%s], last batch rewrote [%d] entities", kinds[kindIndex], userEmail, migrated)
+		kindIndex++
+	}
+
+	if kindIndex == len(kinds) {
+		log.Infof(context, "Done with schema migration for user [%s]", userEmail)
+	} else if err := enqueueSchemaMigration(context, userEmail, kindIndex, nextCursor); err != nil {
+		log.Criticalf(context, "Couldn't schedule the next chunk of schema migration for user [%s]. "+
+			"This leaves some of the entities of that user at an older schema version!: %v", userEmail, err)
+	}
+
+	return nil
+}
+
+// enqueueSchemaMigration enqueues the chunk of schema migration of the user starting at kindIndex and cursor
+func enqueueSchemaMigration(context context.Context, userEmail string, kindIndex int, cursor string) error {
+	task, err := newDispatchedTask(MIGRATE_USER_SCHEMA_FUNCTION_NAME, migrateUserSchemaTaskArguments{userEmail, kindIndex, cursor})
+	if err != nil {
+		return err
+	}
+
+	_, err = taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+	return err
+}
+
+// integrityCheckArguments are the arguments a failed integrity check is requeued with
+type integrityCheckArguments struct {
+	LowerBound time.Time `json:"lowerBound"`
+	UpperBound time.Time `json:"upperBound"`
+	Repair     bool      `json:"repair"`
+}
+
+// refreshTaskArguments are the arguments of a dispatched refresh of user data
+type refreshTaskArguments struct {
+	UserEmail           string `json:"userEmail"`
+	AutoScheduleNextRun bool   `json:"autoScheduleNextRun"`
+}
+
+// scheduleRefreshesTaskArguments are the arguments of a dispatched batch of refresh scheduling
+type scheduleRefreshesTaskArguments struct {
+	Cursor string    `json:"cursor"`
+	Start  time.Time `json:"start"`
+}
+
+// sealTokensTaskArguments are the arguments of a dispatched batch of token encryption
+type sealTokensTaskArguments struct {
+	Cursor string `json:"cursor"`
+}
+
+// processFileTaskArguments are the arguments of a dispatched file import. Token is the user's token for the source of
+// the file, Source being empty for Drive files as it is in tasks dispatched before there were other sources.
+type processFileTaskArguments struct {
+	Token          *oauth.Token   `json:"token"`
+	File           *drive.File    `json:"file"`
+	UserEmail      string         `json:"userEmail"`
+	UserProfileKey *datastore.Key `json:"userProfileKey"`
+	Attempt        int            `json:"attempt"`
+	Source         string         `json:"source,omitempty"`
+}
+
+// migrateUserSchemaTaskArguments are the arguments of a dispatched chunk of schema migration
+type migrateUserSchemaTaskArguments struct {
+	UserEmail string `json:"userEmail"`
+	KindIndex int    `json:"kindIndex"`
+	Cursor    string `json:"cursor"`
+}
+
+func handleRefreshUserData(context context.Context, payload []byte) error {
+	var arguments refreshTaskArguments
+	if decodeTaskPayload(context, REFRESH_USER_DATA_FUNCTION_NAME, payload, &arguments) {
+		updateUserData(context, arguments.UserEmail, arguments.AutoScheduleNextRun)
+	}
+
+	return nil
+}
+
+func handleScheduleRefreshes(context context.Context, payload []byte) error {
+	var arguments scheduleRefreshesTaskArguments
+	if decodeTaskPayload(context, SCHEDULE_REFRESHES_FUNCTION_NAME, payload, &arguments) {
+		return scheduleRefreshesBatch(context, arguments.Cursor, arguments.Start)
+	}
+
+	return nil
+}
+
+func handleSealTokens(context context.Context, payload []byte) error {
+	var arguments sealTokensTaskArguments
+	if decodeTaskPayload(context, SEAL_TOKENS_FUNCTION_NAME, payload, &arguments) {
+		return sealTokensBatch(context, arguments.Cursor)
+	}
+
+	return nil
+}
+
+func handleProcessFile(context context.Context, payload []byte) error {
+	var arguments processFileTaskArguments
+	if decodeTaskPayload(context, PROCESS_FILE_FUNCTION_NAME, payload, &arguments) {
+		return processSourceFile(context, arguments.Source, arguments.Token, arguments.File, arguments.UserEmail, arguments.UserProfileKey,
+			arguments.Attempt)
+	}
+
+	return nil
+}
+
+func handleMigrateUserSchema(context context.Context, payload []byte) error {
+	var arguments migrateUserSchemaTaskArguments
+	if decodeTaskPayload(context, MIGRATE_USER_SCHEMA_FUNCTION_NAME, payload, &arguments) {
+		return migrateUserSchemaChunk(context, arguments.UserEmail, arguments.KindIndex, arguments.Cursor)
+	}
+
+	return nil
+}
+
+// fileImportArguments are the arguments a failed file import is requeued with. The token isn't kept, the user's token
+// of the file's source at the time of the requeue is used instead.
+type fileImportArguments struct {
+	File   *drive.File `json:"file"`
+	Source string      `json:"source,omitempty"`
+}
+
+// schemaMigrationArguments are the arguments a failed chunk of schema migration is requeued with
+type schemaMigrationArguments struct {
+	KindIndex int    `json:"kindIndex"`
+	Cursor    string `json:"cursor"`
+}
+
+func fileImportSummary(file *drive.File) string {
+	return fmt.Sprintf("Import of file [%s]-[%s]", file.Id, file.OriginalFilename)
+}
+
+// recordFinalFailure records a FailedTask if the task running in context fails on its last execution, either returning
+// the error err points to or panicking, before letting the failure through. It's deferred by the functions of the tasks
+// of the datastore-writes queue with the arguments to requeue the task with and their named error result since the task
+// queue drops tasks that fail their last retry.
+func recordFinalFailure(context context.Context, function string, userEmail string, summary string, arguments interface{}, err *error) {
+	failure := recover()
+	if failure == nil && *err == nil {
+		return
+	}
+
+	cause := failure
+	if failure == nil {
+		cause = *err
+	}
+
+	// The execution count is the number of previous failed executions of the task
+	if headers, err := delay.RequestHeaders(context); err != nil {
+		log.Warningf(context, "Error reading headers of task [%s] for user [%s], can't tell if it will be retried: %v", function, userEmail, err)
+	} else if headers.TaskExecutionCount >= DATASTORE_WRITES_TASK_RETRY_LIMIT {
+		recordFailedTask(context, function, userEmail, summary, fmt.Sprintf("%v", cause), headers.TaskExecutionCount+1, arguments)
+	}
+
+	if failure != nil {
+		panic(failure)
+	}
+}
+
+// recordFailedTask stores a FailedTask of the function for the user so that an admin can look at it and requeue it
+func recordFailedTask(context context.Context, function string, userEmail string, summary string, failure string, attempts int64,
+	arguments interface{}) {
+	encodedArguments, err := json.Marshal(arguments)
+	if err != nil {
+		log.Warningf(context, "Error encoding arguments [%v] of failed task [%s], it won't be possible to requeue it: %v", arguments, function, err)
+	}
+
+	failedTask := model.FailedTask{function, DATASTORE_WRITES_QUEUE_NAME, userEmail, summary, failure, attempts, time.Now(),
+		string(encodedArguments), time.Time{}}
+	if key, err := store.StoreFailedTask(context, failedTask); err != nil {
+		log.Criticalf(context, "Error recording failed task [%v], its work is lost: %v", failedTask, err)
+	} else {
+		log.Errorf(context, "Recorded failed task [%d] of [%s] for user [%s] after [%d] attempts: %s", key.IntID(), function,
+			userEmail, attempts, failure)
+	}
+}
+
+// requeueFailedTask enqueues the work of the failed task again
+func requeueFailedTask(context context.Context, failedTask *model.FailedTask) (err error) {
+	userProfileKey := store.GetUserKey(context, failedTask.UserEmail)
+
+	switch failedTask.Function {
+	case PROCESS_FILE_FUNCTION_NAME:
+		var arguments fileImportArguments
+		if err = json.Unmarshal([]byte(failedTask.Arguments), &arguments); err != nil {
+			return err
+		}
+
+		glukitUser, err := store.GetUserProfileCached(context, userProfileKey)
+		if err != nil {
+			return err
+		}
+
+		return enqueueFileImport(context, arguments.Source, fileSourceToken(glukitUser, arguments.Source), arguments.File,
+			failedTask.UserEmail, userProfileKey, 1, time.Duration(0))
+	case IMPORT_NIGHTSCOUT_FUNCTION_NAME:
+		task, err := importNightscout.Task(failedTask.UserEmail, userProfileKey)
+		if err != nil {
+			return err
+		}
+
+		_, err = taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+		return err
+	case IMPORT_GOOGLE_FIT_FUNCTION_NAME:
+		task, err := importGoogleFit.Task(failedTask.UserEmail, userProfileKey)
+		if err != nil {
+			return err
+		}
+
+		_, err = taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+		return err
+	case DELETE_ACCOUNT_FUNCTION_NAME:
+		task, err := deleteAccount.Task(failedTask.UserEmail)
+		if err != nil {
+			return err
+		}
+
+		_, err = taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+		return err
+	case EXPORT_TIDEPOOL_FUNCTION_NAME:
+		task, err := exportTidepool.Task(failedTask.UserEmail, userProfileKey)
+		if err != nil {
+			return err
+		}
+
+		_, err = taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+		return err
+	case MIGRATE_USER_SCHEMA_FUNCTION_NAME:
+		var arguments schemaMigrationArguments
+		if err = json.Unmarshal([]byte(failedTask.Arguments), &arguments); err != nil {
+			return err
+		}
+
+		return enqueueSchemaMigration(context, failedTask.UserEmail, arguments.KindIndex, arguments.Cursor)
+	case CHECK_READS_INTEGRITY_FUNCTION_NAME:
+		var arguments integrityCheckArguments
+		if err = json.Unmarshal([]byte(failedTask.Arguments), &arguments); err != nil {
+			return err
+		}
+
+		return enqueueIntegrityCheck(context, failedTask.UserEmail, arguments.LowerBound, arguments.UpperBound, arguments.Repair)
+	default:
+		return errors.New(fmt.Sprintf("Tasks of function [%s] can't be requeued", failedTask.Function))
+	}
+}
+
+// checkReadsIntegrity is an async task that checks the integrity of the reads of the user between lowerBound and
+// upperBound and, with repair, merges days of reads that overlap. The report is stored for admins to look at.
+func checkReadsIntegrity(context context.Context, userEmail string, lowerBound time.Time, upperBound time.Time, repair bool) (err error) {
+	defer recordFinalFailure(context, CHECK_READS_INTEGRITY_FUNCTION_NAME, userEmail, "Integrity check of reads",
+		integrityCheckArguments{lowerBound, upperBound, repair}, &err)
+
+	report, err := store.CheckReadsIntegrity(context, userEmail, lowerBound, upperBound, repair)
+	if err != nil {
+		return err
+	}
+
+	if report.HasIssues() {
+		log.Warningf(context, "Integrity check of reads of user [%s] between [%s] and [%s] found issues: %v", userEmail,
+			lowerBound.Format(util.TIMEFORMAT), upperBound.Format(util.TIMEFORMAT), report.Issues)
+	}
+
+	return nil
+}
+
+// enqueueIntegrityCheck enqueues the integrity check of the reads of the user between lowerBound and upperBound
+func enqueueIntegrityCheck(context context.Context, userEmail string, lowerBound time.Time, upperBound time.Time, repair bool) error {
+	task, err := checkIntegrity.Task(userEmail, lowerBound, upperBound, repair)
+	if err != nil {
+		return err
+	}
+
+	_, err = taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+	return err
+}
+
+// processFileSearchResults reads the list of files detected on the source and kicks off a new queued task
 // to process each one
-func processFileSearchResults(token *oauth.Token, files []*drive.File, context context.Context, userEmail string,
+func processFileSearchResults(source string, token *oauth.Token, files []*drive.File, context context.Context, userEmail string,
 	userProfileKey *datastore.Key) {
 	// TODO : Look at recent file import log for that file and skip to the new data. It would be nice to be able to
 	// use the Http Range header but that's unlikely to be possible since new event/read data is spreadout in the
 	// file
+	// The most recently modified file is imported right away so that the user sees recent data quickly, older ones
+	// follow as a backfill. Imports are merged with stored data the same way regardless of order.
+	sort.Sort(sort.Reverse(filesByModifiedDate(files)))
 	for i := range files {
-		enqueueFileImport(context, token, files[i], userEmail, userProfileKey, time.Duration(0))
+		if err := enqueueFileImport(context, source, token, files[i], userEmail, userProfileKey, 1, time.Duration(i)*FILE_IMPORT_STAGGER); err != nil {
+			log.Warningf(context, "Error enqueuing import of file [%s]-[%s] for user [%s]: %v", files[i].Id,
+				files[i].OriginalFilename, userEmail, err)
+		}
 	}
 }
 
-func enqueueFileImport(context context.Context, token *oauth.Token, file *drive.File, userEmail string, userKey *datastore.Key, delay time.Duration) error {
-	log.Debugf(context, "Enqueuing import of file [%v] in %v", file, delay)
+// Names of the sources of data files in logs
+var fileSourceNames = map[string]string{importer.FILE_SOURCE_DRIVE: "google drive", importer.FILE_SOURCE_DROPBOX: "dropbox"}
+
+// fileSourcesOf returns the sources of data files of the user, Google Drive always being one of them
+func fileSourcesOf(glukitUser *model.GlukitUser) (sources []string) {
+	sources = []string{importer.FILE_SOURCE_DRIVE}
+	if glukitUser.HasDropbox() {
+		sources = append(sources, importer.FILE_SOURCE_DROPBOX)
+	}
+
+	return sources
+}
+
+// fileSourceToken returns the token of the user for the source
+func fileSourceToken(glukitUser *model.GlukitUser, source string) *oauth.Token {
+	if source == importer.FILE_SOURCE_DROPBOX {
+		return &glukitUser.DropboxToken
+	}
+
+	return &glukitUser.Token
+}
+
+// fileSourceConfiguration returns the OAuth configuration tokens of the source are refreshed with
+func fileSourceConfiguration(source string) *oauth.Config {
+	if source == importer.FILE_SOURCE_DROPBOX {
+		return dropboxConfiguration(appConfig.Host)
+	}
+
+	return configuration()
+}
+
+// newFileSource returns the source authorized by transport. The folder id only applies to Google Drive.
+func newFileSource(context context.Context, source string, transport http.RoundTripper, folderId string) importer.FileSource {
+	if source == importer.FILE_SOURCE_DROPBOX {
+		return importer.NewDropboxFileSource(context, transport)
+	}
+
+	return importer.NewDriveFileSource(context, transport, folderId)
+}
+
+// filesByModifiedDate sorts files from the least to the most recently modified
+type filesByModifiedDate []*drive.File
+
+func (files filesByModifiedDate) Len() int {
+	return len(files)
+}
+
+func (files filesByModifiedDate) Less(i, j int) bool {
+	return files[i].ModifiedDate < files[j].ModifiedDate
+}
+
+func (files filesByModifiedDate) Swap(i, j int) {
+	files[i], files[j] = files[j], files[i]
+}
 
-	task, err := processFile.Task(token, file, userEmail, userKey)
+// enqueueFileImport enqueues the given attempt at importing the file of the source to run after the delay
+func enqueueFileImport(context context.Context, source string, token *oauth.Token, file *drive.File, userEmail string,
+	userKey *datastore.Key, attempt int, delay time.Duration) error {
+	log.Debugf(context, "Enqueuing import attempt [%d] of file [%v] in %v", attempt, file, delay)
+
+	task, err := newDispatchedTask(PROCESS_FILE_FUNCTION_NAME, processFileTaskArguments{token, file, userEmail, userKey, attempt, source})
 	if err != nil {
 		return err
 	}
@@ -137,50 +828,151 @@ func enqueueFileImport(context context.Context, token *oauth.Token, file *drive.
 	return err
 }
 
-// processSingleFile handles the import of a single file. It deals with:
-//    1. Logging the file import operation
-//    2. Calculating and updating the new GlukitScore
-//    3. Sending a "refresh" message to any connected client
+// fileImportRetryDelay returns the delay before retrying a file import that failed on the given attempt: 1h after
+// the first attempt, 4h after the second, 16h after the third and so on until FILE_IMPORT_RETRY_MAX_DELAY.
+func fileImportRetryDelay(attempt int) time.Duration {
+	delay := FILE_IMPORT_RETRY_BASE_DELAY
+	for i := 1; i < attempt && delay < FILE_IMPORT_RETRY_MAX_DELAY; i++ {
+		delay = delay * FILE_IMPORT_RETRY_FACTOR
+	}
+
+	if delay > FILE_IMPORT_RETRY_MAX_DELAY {
+		return FILE_IMPORT_RETRY_MAX_DELAY
+	}
+
+	return delay
+}
+
+// processSingleFile handles the import of a single file. Files that are unchanged since their last successful import
+// aren't downloaded again. Otherwise, it deals with:
+//  1. Logging the file import operation and retrying it with a backoff if it failed for a reason other than the
+//     content of the file. The user is notified once it's given up on after MAX_FILE_IMPORT_ATTEMPTS.
+//  2. Calculating and updating the new GlukitScore
+//  3. Notifying any connected client of new data, see notifyRefresh
+//
+// Only MAX_CONCURRENT_FILE_IMPORTS imports of a user run at the same time, an import that doesn't get an import slot is
+// pushed back.
 func processSingleFile(context context.Context, token *oauth.Token, file *drive.File, userEmail string,
-	userProfileKey *datastore.Key) {
+	userProfileKey *datastore.Key, attempt int) error {
+	return processSourceFile(context, importer.FILE_SOURCE_DRIVE, token, file, userEmail, userProfileKey, attempt)
+}
+
+// processSourceFile handles the import of a single file of the source the same way processSingleFile does for Drive
+// files. The import logs of the file are kept under ids prefixed by the source, see importer.FileImportLogId. Store
+// failures before the import starts are returned for the task queue to retry the task.
+func processSourceFile(context context.Context, source string, token *oauth.Token, file *drive.File, userEmail string,
+	userProfileKey *datastore.Key, attempt int) (err error) {
+	defer recordFinalFailure(context, PROCESS_FILE_FUNCTION_NAME, userEmail, fileImportSummary(file), fileImportArguments{file, source}, &err)
+
+	// Data imported after the user deleted their account would be left behind without a profile
+	if _, err := store.GetUserProfileCached(context, userProfileKey); err == datastore.ErrNoSuchEntity {
+		log.Infof(context, "User [%s] doesn't exist anymore, dropping import of file [%s]-[%s]", userEmail, file.Id, file.OriginalFilename)
+		return nil
+	}
+
+	now := time.Now()
+	lease := model.RefreshLease{strconv.FormatInt(now.UnixNano(), 10), now, now.Add(FILE_IMPORT_LEASE_DURATION)}
+	slot, err := store.AcquireImportSlot(context, userEmail, MAX_CONCURRENT_FILE_IMPORTS, lease)
+	if err == store.ErrNoImportSlot {
+		log.Infof(context, "All import slots of user [%s] are taken, pushing back import of file [%s]-[%s]", userEmail,
+			file.Id, file.OriginalFilename)
+		return enqueueFileImport(context, source, token, file, userEmail, userProfileKey, attempt, FILE_IMPORT_SLOT_WAIT)
+	} else if err != nil {
+		return err
+	}
+	defer func() {
+		if err := store.ReleaseImportSlot(context, userEmail, slot, lease.Holder); err != nil {
+			log.Warningf(context, "Error releasing import slot [%d] of user [%s], it will expire at [%s]: %v", slot, userEmail,
+				lease.ExpiresOn.Format(util.TIMEFORMAT), err)
+		}
+	}()
+
 	t := &oauth.Transport{
-		Config: configuration(),
+		Config: fileSourceConfiguration(source),
 		Transport: &urlfetch.Transport{
 			Context: context,
 		},
 		Token: token,
 	}
 
-	reader, err := importer.GetFileReader(context, t, file)
+	logId := importer.FileImportLogId(source, file.Id)
+	if lastFileImportLog, err := store.GetFileImportLog(context, userProfileKey, logId); err == nil && importer.IsUnchanged(file, lastFileImportLog) {
+		if !lastFileImportLog.Succeeded {
+			log.Infof(context, "File [%s]-[%s] failed permanently after [%d] attempts and is unchanged with checksum [%s], skipping",
+				file.Id, file.OriginalFilename, lastFileImportLog.Attempts, file.Md5Checksum)
+			return nil
+		}
+
+		log.Infof(context, "File [%s]-[%s] is unchanged since its last import with checksum [%s], skipping", file.Id,
+			file.OriginalFilename, file.Md5Checksum)
+		notifyRefresh(context, userEmail, 0)
+		recordOnboardingImport(context, userEmail, file, true, nil, nil)
+		return nil
+	} else if err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+
+	filesImported := 0
+	reader, err := newFileSource(context, source, t, "").GetFileReader(file)
 	if err != nil {
 		log.Infof(context, "Error reading file %s, skipping: [%v]", file.OriginalFilename, err)
+		recordOnboardingImport(context, userEmail, file, false, err, nil)
 	} else {
-		// Default to beginning of time
-		startTime := util.GLUKIT_EPOCH_TIME
-		if lastFileImportLog, err := store.GetFileImportLog(context, userProfileKey, file.Id); err == nil {
-			startTime = lastFileImportLog.LastDataProcessed
-			log.Infof(context, "Reloading data from file [%s]-[%s] starting at date [%s]...", file.Id,
-				file.OriginalFilename, startTime.Format(util.TIMEFORMAT))
-		} else if err == datastore.ErrNoSuchEntity {
-			log.Debugf(context, "First import of file [%s]-[%s]...", file.Id, file.OriginalFilename)
-		} else if err != nil {
-			util.Propagate(err)
-		}
-
-		lastReadTime, err := importer.ParseContent(context, reader, userProfileKey, startTime,
-			store.StoreDaysOfReads, store.StoreDaysOfMeals, store.StoreDaysOfInjections, store.StoreDaysOfExercises)
-		errMessage := "Success"
+		imported := false
+		reports := make([]*importer.ImportReport, 0)
+		var retryErr, importErr error
+		dataFiles, err := importer.OpenDataFiles(reader, file.OriginalFilename)
 		if err != nil {
-			enqueueFileImport(context, token, file, userEmail, userProfileKey, time.Duration(1)*time.Hour)
-			errMessage = err.Error()
+			importErr = err
+			log.Warningf(context, "Error opening file [%s]-[%s]: %v", file.Id, file.OriginalFilename, err)
+			if !importer.IsDataError(err) {
+				retryErr = err
+			}
+			store.LogFileImport(context, userProfileKey, model.FileImportLog{Id: logId, Md5Checksum: file.Md5Checksum,
+				ImportResult: err.Error(), ImportedAt: time.Now(), Attempts: attempt,
+				PermanentlyFailed: retryErr == nil || attempt >= MAX_FILE_IMPORT_ATTEMPTS})
 		}
 
-		store.LogFileImport(context, userProfileKey, model.FileImportLog{Id: file.Id, Md5Checksum: file.Md5Checksum,
-			LastDataProcessed: lastReadTime, ImportResult: errMessage})
+		for _, dataFile := range dataFiles {
+			fileImportId := logId
+			if dataFile.Entry != "" {
+				fileImportId = fmt.Sprintf("%s#%s", logId, dataFile.Entry)
+			}
+
+			report, err := importDataFile(context, file, fileImportId, dataFile, userEmail, userProfileKey, attempt)
+			imported = imported || err == nil
+			if err == nil && report != nil {
+				reports = append(reports, report)
+			}
+			if err != nil {
+				importErr = err
+			}
+			if err != nil && !importer.IsDataError(err) {
+				retryErr = err
+			}
+		}
 		reader.Close()
 
-		if err == nil {
-			if glukitUser, err := store.GetUserProfile(context, userProfileKey); err != nil {
+		// Retrying only makes sense if the failure wasn't caused by the content of the file itself
+		if retryErr != nil {
+			if attempt < MAX_FILE_IMPORT_ATTEMPTS {
+				enqueueFileImport(context, source, token, file, userEmail, userProfileKey, attempt+1, fileImportRetryDelay(attempt))
+			} else {
+				log.Errorf(context, "Giving up on import of file [%s]-[%s] for user [%s] after [%d] attempts: %v", file.Id,
+					file.OriginalFilename, userEmail, attempt, retryErr)
+				recordFailedTask(context, PROCESS_FILE_FUNCTION_NAME, userEmail, fileImportSummary(file), retryErr.Error(),
+					int64(attempt), fileImportArguments{file, source})
+				message := importFailureMessage{IMPORT_FAILURE_TYPE, file.OriginalFilename, attempt,
+					fmt.Sprintf("Import of %s failed %d times and won't be retried: %v", file.OriginalFilename, attempt, retryErr)}
+				if err := channel.SendJSON(context, userEmail, message); err != nil {
+					log.Warningf(context, "Error sending import failure [%v] to user [%s]: %v", message, userEmail, err)
+				}
+			}
+		}
+
+		if imported {
+			filesImported = 1
+			if glukitUser, err := store.GetUserProfileCached(context, userProfileKey); err != nil {
 				log.Warningf(context, "Error getting retrieving GlukitUser [%s], this needs attention: [%v]", userEmail, err)
 			} else {
 				// Calculate Glukit Score batch here for the newly imported data
@@ -193,38 +985,313 @@ func processSingleFile(context context.Context, token *oauth.Token, file *drive.
 				if err != nil {
 					log.Warningf(context, "Error starting a1c calculation batch for user [%s]: %v", userEmail, err)
 				}
+
+				err = engine.StartHypoDetectionBatch(context, glukitUser)
+				if err != nil {
+					log.Warningf(context, "Error starting hypo detection batch for user [%s]: %v", userEmail, err)
+				}
 			}
+
+			fireImportCompletedWebhook(context, userEmail, userProfileKey, file, reports)
+			enqueueTidepoolExport(context, userEmail, userProfileKey)
+		}
+
+		// Imports that are retried count once they're done
+		if imported || retryErr == nil || attempt >= MAX_FILE_IMPORT_ATTEMPTS {
+			recordOnboardingImport(context, userEmail, file, imported, importErr, reports)
+		}
+	}
+	notifyRefresh(context, userEmail, filesImported)
+
+	return nil
+}
+
+// recordOnboardingImport records the import of the file in the onboarding of the user, with why it failed if it wasn't
+// imported. The user is ready right away if one of the reports has reads spanning model.ONBOARDING_READY_READS_SPAN.
+func recordOnboardingImport(context context.Context, userEmail string, file *drive.File, imported bool, importErr error,
+	reports []*importer.ImportReport) {
+	failure := ""
+	if !imported && importErr != nil {
+		failure = fmt.Sprintf("Import of %s failed: %v", file.OriginalFilename, importErr)
+	} else if !imported {
+		failure = fmt.Sprintf("No data found in %s", file.OriginalFilename)
+	}
+
+	dayOfReads := false
+	for _, report := range reports {
+		if report.Reads > 0 && report.LastRecordTime.Sub(report.FirstRecordTime) >= model.ONBOARDING_READY_READS_SPAN {
+			dayOfReads = true
 		}
 	}
-	channel.Send(context, userEmail, "Refresh")
+
+	engine.AdvanceOnboarding(context, userEmail, func(state *model.OnboardingState) bool {
+		return state.RecordFileImport(imported, failure, dayOfReads, time.Now())
+	})
 }
 
-// processStaticDemoFile imports the static resource included with the app for the demo user
-func processStaticDemoFile(context context.Context, userProfileKey *datastore.Key) {
+// importDataFile imports a single data file starting where the last import with the same id left off and logs the
+// import under that id. Files inside a zip archive are imported with an id of "fileImportLogId#entryName" so that each
+// of them is tracked separately. The report of the import is returned unless the file was unchanged or couldn't be
+// read.
+func importDataFile(context context.Context, file *drive.File, fileImportId string, dataFile importer.DataFile, userEmail string,
+	userProfileKey *datastore.Key, attempt int) (report *importer.ImportReport, err error) {
+	// Default to beginning of time
+	startTime := util.GLUKIT_EPOCH_TIME
+	if lastFileImportLog, err := store.GetFileImportLog(context, userProfileKey, fileImportId); err == nil {
+		if importer.IsUnchanged(file, lastFileImportLog) {
+			log.Infof(context, "File [%s]-[%s] is unchanged since its last import, skipping", fileImportId, dataFile.Name)
+			return nil, nil
+		}
+
+		startTime = lastFileImportLog.LastDataProcessed
+		log.Infof(context, "Reloading data from file [%s]-[%s] starting at date [%s]...", fileImportId,
+			dataFile.Name, startTime.Format(util.TIMEFORMAT))
+	} else if err == datastore.ErrNoSuchEntity {
+		log.Debugf(context, "First import of file [%s]-[%s]...", fileImportId, dataFile.Name)
+	} else {
+		return nil, err
+	}
+
+	format, content, err := importer.DefaultRegistry.Detect(dataFile.Reader, dataFile.Name)
+	if err != nil {
+		log.Warningf(context, "Can't import file [%s]-[%s]: %v", fileImportId, dataFile.Name, err)
+		store.LogFileImport(context, userProfileKey, model.FileImportLog{Id: fileImportId, Md5Checksum: file.Md5Checksum,
+			LastDataProcessed: startTime, ImportResult: err.Error(), ImportedAt: time.Now(), Attempts: attempt,
+			PermanentlyFailed: importer.IsDataError(err) || attempt >= MAX_FILE_IMPORT_ATTEMPTS})
+		return nil, err
+	}
 
-	// open input file
-	fi, err := os.Open("data.xml")
+	log.Infof(context, "Importing file [%s]-[%s] as %s content", fileImportId, dataFile.Name, format.Name)
+	progress := importer.ThrottleProgress(importProgressSender(context, userEmail, dataFile.Name),
+		IMPORT_PROGRESS_INTERVAL, IMPORT_PROGRESS_RECORDS)
+	lastReadTime, report, err := importer.ImportContent(context, format, content, userProfileKey, startTime, progress)
+	importResult := report.String()
 	if err != nil {
-		panic(err)
+		importResult = fmt.Sprintf("%s: %s", err.Error(), importResult)
 	}
-	// close fi on exit and check for its returned error
-	defer func() {
-		if fi.Close() != nil {
-			panic(err)
+
+	store.LogFileImport(context, userProfileKey, model.FileImportLog{Id: fileImportId, Md5Checksum: file.Md5Checksum,
+		LastDataProcessed: lastReadTime, ImportResult: importResult, ImportedAt: time.Now(), RecordCount: report.RecordCount(),
+		SourceUnit: report.Unit, Succeeded: err == nil, Attempts: attempt,
+		PermanentlyFailed: err != nil && (importer.IsDataError(err) || attempt >= MAX_FILE_IMPORT_ATTEMPTS)})
+
+	return report, err
+}
+
+// processNightscoutImport imports the new entries and treatments of the user's Nightscout site. The import starts
+// at the watermark of the last import of the site, which is kept in a FileImportLog keyed on the site url, or at the
+// user's most recent read if the site has never been imported.
+func processNightscoutImport(context context.Context, userEmail string, userProfileKey *datastore.Key) (err error) {
+	defer recordFinalFailure(context, IMPORT_NIGHTSCOUT_FUNCTION_NAME, userEmail, "Import of nightscout data", nil, &err)
+
+	glukitUser, err := store.GetUserProfileCached(context, userProfileKey)
+	if err == datastore.ErrNoSuchEntity {
+		log.Infof(context, "User [%s] doesn't exist anymore, dropping nightscout import", userEmail)
+		return nil
+	} else if err != nil {
+		log.Warningf(context, "Error getting retrieving GlukitUser [%s] for nightscout import: [%v]", userEmail, err)
+		return err
+	}
+
+	startTime := glukitUser.MostRecentRead.GetTime()
+	if lastImportLog, err := store.GetFileImportLog(context, userProfileKey, glukitUser.NightscoutUrl); err == nil {
+		startTime = lastImportLog.LastDataProcessed
+	} else if err != datastore.ErrNoSuchEntity {
+		log.Warningf(context, "Error reading nightscout import log for user [%s]: %v", userEmail, err)
+		return err
+	}
+
+	log.Infof(context, "Importing nightscout data from [%s] for user [%s] starting at [%s]", glukitUser.NightscoutUrl, userEmail,
+		startTime.Format(util.TIMEFORMAT))
+	lastReadTime, recordCount, err := importer.ImportNightscoutData(context, urlfetch.Client(context), userProfileKey,
+		glukitUser.NightscoutUrl, glukitUser.NightscoutApiSecret, startTime)
+	errMessage := "Success"
+	if err != nil {
+		log.Warningf(context, "Error importing nightscout data for user [%s]: %v", userEmail, err)
+		errMessage = err.Error()
+	}
+
+	store.LogFileImport(context, userProfileKey, model.FileImportLog{Id: glukitUser.NightscoutUrl, LastDataProcessed: lastReadTime,
+		ImportResult: errMessage, ImportedAt: time.Now(), RecordCount: recordCount, Succeeded: err == nil})
+
+	if err == nil && recordCount > 0 {
+		if err := engine.StartGlukitScoreBatch(context, glukitUser); err != nil {
+			log.Warningf(context, "Error starting batch calculation of GlukitScores for [%s], this needs attention: [%v]", userEmail, err)
 		}
-	}()
-	// make a read buffer
-	reader := bufio.NewReader(fi)
 
-	lastReadTime, err := importer.ParseContent(context, reader, userProfileKey, util.GLUKIT_EPOCH_TIME,
-		store.StoreDaysOfReads, store.StoreDaysOfMeals, store.StoreDaysOfInjections, store.StoreDaysOfExercises)
+		if err := engine.StartA1CCalculationBatch(context, glukitUser); err != nil {
+			log.Warningf(context, "Error starting a1c calculation batch for user [%s]: %v", userEmail, err)
+		}
+
+		if err := engine.StartHypoDetectionBatch(context, glukitUser); err != nil {
+			log.Warningf(context, "Error starting hypo detection batch for user [%s]: %v", userEmail, err)
+		}
+
+		enqueueTidepoolExport(context, userEmail, userProfileKey)
+		notifyRefresh(context, userEmail, 0)
+	}
+
+	// Failures of the site are recorded in the import log, the next refresh retrying the import
+	return nil
+}
+
+// processGoogleFitImport imports the Google Fit sessions of the user as exercises. The import starts at the end of the
+// last session imported, which is kept in the FileImportLog of id GOOGLE_FIT_IMPORT_LOG_ID, or GOOGLE_FIT_INITIAL_SYNC_DAYS
+// ago if the user's sessions have never been imported.
+func processGoogleFitImport(context context.Context, userEmail string, userProfileKey *datastore.Key) (err error) {
+	defer recordFinalFailure(context, IMPORT_GOOGLE_FIT_FUNCTION_NAME, userEmail, "Import of google fit sessions", nil, &err)
+
+	glukitUser, err := store.GetUserProfileCached(context, userProfileKey)
+	if err == datastore.ErrNoSuchEntity {
+		log.Infof(context, "User [%s] doesn't exist anymore, dropping google fit import", userEmail)
+		return nil
+	} else if err != nil {
+		log.Warningf(context, "Error getting retrieving GlukitUser [%s] for google fit import: [%v]", userEmail, err)
+		return err
+	}
+
+	if !glukitUser.GoogleFitEnabled || !glukitUser.GoogleFitAuthorized || glukitUser.TokenRevoked {
+		log.Infof(context, "Google fit import isn't enabled and authorized for user [%s], skipping", userEmail)
+		return nil
+	}
+
+	startTime := time.Now().AddDate(0, 0, -1*GOOGLE_FIT_INITIAL_SYNC_DAYS)
+	if lastImportLog, err := store.GetFileImportLog(context, userProfileKey, GOOGLE_FIT_IMPORT_LOG_ID); err == nil {
+		startTime = lastImportLog.LastDataProcessed
+	} else if err != datastore.ErrNoSuchEntity {
+		log.Warningf(context, "Error reading google fit import log for user [%s]: %v", userEmail, err)
+		return err
+	}
+
+	transport := &oauth.Transport{
+		Config: googleFitConfiguration(),
+		Transport: &urlfetch.Transport{
+			Context: context,
+		},
+		Token: &glukitUser.Token,
+	}
+
+	if glukitUser.Token.Expired() {
+		transport.Token.RefreshToken = glukitUser.RefreshToken
+		if err := transport.Refresh(context); err != nil {
+			log.Warningf(context, "Error refreshing token of user [%s] for google fit import: %v", userEmail, err)
+			return nil
+		}
+	}
+
+	log.Infof(context, "Importing google fit sessions for user [%s] starting at [%s]", userEmail, startTime.Format(util.TIMEFORMAT))
+	lastSessionEnd, recordCount, err := importer.ImportGoogleFitSessions(context, transport.Client(), userProfileKey, startTime)
+	errMessage := "Success"
+	if err != nil {
+		log.Warningf(context, "Error importing google fit sessions for user [%s]: %v", userEmail, err)
+		errMessage = err.Error()
+	}
+
+	store.LogFileImport(context, userProfileKey, model.FileImportLog{Id: GOOGLE_FIT_IMPORT_LOG_ID, LastDataProcessed: lastSessionEnd,
+		ImportResult: errMessage, ImportedAt: time.Now(), RecordCount: recordCount, Succeeded: err == nil})
+
+	if err == nil && recordCount > 0 {
+		notifyRefresh(context, userEmail, 0)
+	}
+
+	// Failures of google fit are recorded in the import log, the next refresh retrying the import
+	return nil
+}
+
+// importProgressMessage is the message sent to the connected client to report the progress of a file import
+type importProgressMessage struct {
+	Type    string    `json:"type"`
+	File    string    `json:"file"`
+	Records int       `json:"records"`
+	At      time.Time `json:"at"`
+	Bytes   int64     `json:"bytes,omitempty"`
+}
+
+// importFailureMessage is the message sent to the connected client when the import of a file is given up on
+type importFailureMessage struct {
+	Type     string `json:"type"`
+	File     string `json:"file"`
+	Attempts int    `json:"attempts"`
+	Message  string `json:"message"`
+}
+
+// importProgressSender returns a ProgressHandler that sends the progress of the import of a file to the user's connected
+// client. Failures to send are only logged since progress is purely informational.
+func importProgressSender(context context.Context, userEmail string, filename string) importer.ProgressHandler {
+	return func(progress importer.ImportProgress) {
+		message := importProgressMessage{IMPORT_PROGRESS_TYPE, filename, progress.Records, progress.At.UTC(), progress.Bytes}
+		if err := channel.SendJSON(context, userEmail, message); err != nil {
+			log.Debugf(context, "Error sending import progress [%v] to user [%s]: %v", message, userEmail, err)
+		}
+	}
+}
+
+// reseedDemoData wipes the data of the demo user and imports the demo data again, shifted to end yesterday. Unless
+// forced, the demo data is left as is if it already ends yesterday.
+func reseedDemoData(context context.Context, force bool) error {
+	userProfileKey := store.GetUserKey(context, DEMO_EMAIL)
+	if demoUser, err := store.GetUserProfile(context, userProfileKey); err == nil && !force {
+		yesterday := time.Now().AddDate(0, 0, -1)
+		if importer.WholeDaysShift(demoUser.MostRecentRead.GetTime(), yesterday) == 0 {
+			log.Infof(context, "Demo data already ends yesterday at [%s], not reseeding it", demoUser.MostRecentRead.GetTime().Format(util.TIMEFORMAT))
+			return nil
+		}
+	} else if err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+
+	count, err := store.DeleteUserData(context, DEMO_EMAIL)
+	if err != nil {
+		return err
+	}
+	log.Infof(context, "Deleted [%d] entities of demo user [%s], reseeding its data", count, DEMO_EMAIL)
+
+	if userProfileKey, err = store.StoreUserProfile(context, time.Now(), newDemoUserProfile()); err != nil {
+		return err
+	}
+
+	return processStaticDemoFile(context, userProfileKey)
+}
+
+// enqueueDemoReseed enqueues the reseeding of the demo data, see reseedDemoData
+func enqueueDemoReseed(context context.Context, force bool) error {
+	task, err := reseedDemo.Task(force)
+	if err != nil {
+		return err
+	}
 
+	_, err = taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+	return err
+}
+
+// processStaticDemoFile imports the static resource included with the app for the demo user. All of its times are
+// shifted by whole days so that the latest read is yesterday and the demo always shows recent data. The shift only
+// changes once a day so imports of the same day store the same data.
+func processStaticDemoFile(context context.Context, userProfileKey *datastore.Key) error {
+	content, err := ioutil.ReadFile(DEMO_DATA_FILE)
 	if err != nil {
-		util.Propagate(err)
+		return err
+	}
+
+	latestRead, err := importer.LatestDexcomGlucoseTime(content)
+	if err != nil {
+		return err
+	}
+
+	shift := importer.WholeDaysShift(latestRead, time.Now().AddDate(0, 0, -1))
+	log.Infof(context, "Shifting demo data with latest read at [%s] by [%v]", latestRead.Format(util.TIMEFORMAT), shift)
+	reader := bytes.NewReader(importer.ShiftDexcomXmlTimes(content, shift))
+
+	lastReadTime, report, err := importer.ParseContent(context, reader, userProfileKey, util.GLUKIT_EPOCH_TIME,
+		store.StoreDaysOfReads, store.StoreDaysOfCalibrations, store.StoreDaysOfMeals, store.StoreDaysOfInjections, store.StoreDaysOfExercises, nil)
+
+	if err != nil {
+		return err
 	}
 
 	store.LogFileImport(context, userProfileKey, model.FileImportLog{Id: "demo", Md5Checksum: "dummychecksum",
-		LastDataProcessed: lastReadTime, ImportResult: "Success"})
+		LastDataProcessed: lastReadTime, ImportResult: report.String(), ImportedAt: time.Now(), RecordCount: report.RecordCount(),
+		Succeeded: true})
 
 	if userProfile, err := store.GetUserProfile(context, userProfileKey); err != nil {
 		log.Warningf(context, "Error while persisting score for %s: %v", DEMO_EMAIL, err)
@@ -237,7 +1304,14 @@ func processStaticDemoFile(context context.Context, userProfileKey *datastore.Ke
 		if err != nil {
 			log.Warningf(context, "Error starting a1c calculation batch for user [%s]: %v", DEMO_EMAIL, err)
 		}
+
+		err = engine.StartHypoDetectionBatch(context, userProfile)
+		if err != nil {
+			log.Warningf(context, "Error starting hypo detection batch for user [%s]: %v", DEMO_EMAIL, err)
+		}
 	}
 
-	channel.Send(context, DEMO_EMAIL, "Refresh")
+	sendRefreshMessage(context, DEMO_EMAIL, 1)
+
+	return nil
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/alexandre-normand/glukit/app/importer"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"google.golang.org/appengine/aetest"
+	"testing"
+	"time"
+)
+
+func TestFileImportRetryDelayBacksOffExponentiallyUpToCeiling(t *testing.T) {
+	for attempt, expected := range map[int]time.Duration{
+		1: time.Duration(1) * time.Hour,
+		2: time.Duration(4) * time.Hour,
+		3: time.Duration(16) * time.Hour,
+		4: FILE_IMPORT_RETRY_MAX_DELAY,
+		5: FILE_IMPORT_RETRY_MAX_DELAY,
+	} {
+		if delay := fileImportRetryDelay(attempt); delay != expected {
+			t.Errorf("Expected retry delay of [%s] after attempt [%d] but got [%s]", expected, attempt, delay)
+		}
+	}
+}
+
+func TestOnlyFailuresOtherThanTheContentOfFilesAreRetried(t *testing.T) {
+	if importer.IsDataError(errors.New("API error 503: Backend Error")) {
+		t.Errorf("Expected a failure of the Drive API to be retried")
+	}
+
+	if !importer.IsDataError(&xml.SyntaxError{Msg: "unexpected EOF", Line: 12}) {
+		t.Errorf("Expected a malformed file not to be retried")
+	}
+}
+
+func TestRefreshTaskNamesDeduplicateRefreshesOfTheSameTrigger(t *testing.T) {
+	email := "test@glukit.com"
+	eta := time.Date(2015, time.March, 1, 10, 5, 0, 0, time.UTC)
+
+	if refreshTaskName(email, eta, REFRESH_TRIGGER_SCHEDULED) != refreshTaskName(email, eta.Add(time.Duration(50)*time.Minute), REFRESH_TRIGGER_SCHEDULED) {
+		t.Errorf("Expected scheduled refreshes of the same hour to have the same name")
+	}
+
+	if refreshTaskName(email, eta, REFRESH_TRIGGER_LOGIN) != refreshTaskName(email, eta.Add(time.Duration(10)*time.Hour), REFRESH_TRIGGER_LOGIN) {
+		t.Errorf("Expected refreshes on login of the same day to have the same name")
+	}
+
+	if refreshTaskName(email, eta, REFRESH_TRIGGER_MANUAL) == refreshTaskName(email, eta.Add(time.Second), REFRESH_TRIGGER_MANUAL) {
+		t.Errorf("Expected every manual refresh to have its own name")
+	}
+
+	if refreshTaskName(email, eta, REFRESH_TRIGGER_SCHEDULED) == refreshTaskName("other@glukit.com", eta, REFRESH_TRIGGER_SCHEDULED) {
+		t.Errorf("Expected refreshes of different users to have different names")
+	}
+}
+
+func TestFailedTasksAreRecordedWithTheArgumentsToRequeueThem(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	email := "failed@glukit.com"
+	recordFailedTask(c, MIGRATE_USER_SCHEMA_FUNCTION_NAME, email, "Schema migration", "datastore timeout", 6,
+		schemaMigrationArguments{2, "cursor"})
+
+	_, failedTasks, err := store.GetFailedTasks(c, email, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failedTasks) != 1 || failedTasks[0].Attempts != 6 || failedTasks[0].Queue != DATASTORE_WRITES_QUEUE_NAME {
+		t.Fatalf("Expected a failed schema migration after [6] attempts but got [%v]", failedTasks)
+	}
+
+	var arguments schemaMigrationArguments
+	if err := json.Unmarshal([]byte(failedTasks[0].Arguments), &arguments); err != nil || arguments.KindIndex != 2 ||
+		arguments.Cursor != "cursor" {
+		t.Errorf("Expected arguments of the failed chunk to be kept but got [%s]", failedTasks[0].Arguments)
+	}
+
+	unknown := model.FailedTask{Function: "unknownFunction", UserEmail: email}
+	if err := requeueFailedTask(c, &unknown); err == nil {
+		t.Errorf("Expected tasks of an unknown function not to be requeued")
+	}
+}
+
+func TestScheduledRefreshesAreSpreadDeterministically(t *testing.T) {
+	start := time.Date(2015, time.March, 1, 3, 0, 0, 0, time.UTC)
+
+	times := make(map[time.Time]bool)
+	for _, email := range []string{"first@glukit.com", "second@glukit.com", "third@glukit.com"} {
+		refreshTime := scheduledRefreshTime(email, start)
+		if refreshTime.Before(start) || !refreshTime.Before(start.Add(REFRESH_SCHEDULING_SPREAD)) {
+			t.Errorf("Expected refresh of [%s] within [%s] of [%s] but got [%s]", email, REFRESH_SCHEDULING_SPREAD, start, refreshTime)
+		}
+
+		if !refreshTime.Equal(scheduledRefreshTime(email, start)) {
+			t.Errorf("Expected refresh of [%s] to be scheduled at the same time when its batch is retried", email)
+		}
+		times[refreshTime] = true
+	}
+
+	if len(times) != 3 {
+		t.Errorf("Expected refreshes of different users to be spread but got %v", times)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/export"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/urlfetch"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	EXPORT_TIDEPOOL_FUNCTION_NAME = "processTidepoolExport"
+
+	// How far back the first export to a Tidepool account goes
+	TIDEPOOL_INITIAL_EXPORT_DAYS = 30
+
+	// Form fields of the Tidepool credentials
+	FORM_FIELD_TIDEPOOL_USERNAME = "tidepoolUsername"
+	FORM_FIELD_TIDEPOOL_PASSWORD = "tidepoolPassword"
+)
+
+var exportTidepool = delay.Func(EXPORT_TIDEPOOL_FUNCTION_NAME, processTidepoolExport)
+
+// enqueueTidepoolExport enqueues the export of the new data of the user to their Tidepool account, if they connected
+// one. The export runs in its own task so that its failures never affect the import that triggered it.
+func enqueueTidepoolExport(context context.Context, userEmail string, userProfileKey *datastore.Key) {
+	if _, err := store.GetTidepoolAccount(context, userProfileKey); err == datastore.ErrNoSuchEntity {
+		return
+	} else if err != nil {
+		log.Warningf(context, "Error getting tidepool account of user [%s], not exporting: %v", userEmail, err)
+		return
+	}
+
+	task, err := exportTidepool.Task(userEmail, userProfileKey)
+	if err != nil {
+		log.Warningf(context, "Error creating tidepool export task for user [%s]: %v", userEmail, err)
+		return
+	}
+
+	if _, err := taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME); err != nil {
+		log.Warningf(context, "Error enqueuing tidepool export for user [%s]: %v", userEmail, err)
+	}
+}
+
+// processTidepoolExport exports the reads, meals and injections of the user that are more recent than the last ones
+// exported to their Tidepool account, or TIDEPOOL_INITIAL_EXPORT_DAYS old at most if nothing was ever exported. A failed
+// export isn't retried, the next import exporting everything since the last successful one again. Failing to read the
+// tidepool account fails the task for the task queue to retry it.
+func processTidepoolExport(context context.Context, userEmail string, userProfileKey *datastore.Key) (err error) {
+	defer recordFinalFailure(context, EXPORT_TIDEPOOL_FUNCTION_NAME, userEmail, "Export to tidepool", nil, &err)
+
+	account, err := store.GetTidepoolAccount(context, userProfileKey)
+	if err == datastore.ErrNoSuchEntity {
+		log.Infof(context, "User [%s] disconnected tidepool, skipping export", userEmail)
+		return nil
+	} else if err != nil {
+		log.Warningf(context, "Error getting tidepool account of user [%s] for export: %v", userEmail, err)
+		return err
+	}
+
+	now := time.Now()
+	lowerBound := now.AddDate(0, 0, -1*TIDEPOOL_INITIAL_EXPORT_DAYS)
+	if !account.LastExported.IsZero() {
+		// Bounds of reads are inclusive, skip the last record exported
+		lowerBound = account.LastExported.Add(time.Millisecond)
+	}
+
+	lastExported, datumCount, err := exportToTidepool(context, userEmail, account.Username, account.EncryptedPassword, lowerBound, now)
+	status := fmt.Sprintf("Exported %d records", datumCount)
+	if err != nil {
+		log.Warningf(context, "Error exporting data of user [%s] to tidepool: %v", userEmail, err)
+		status, lastExported = err.Error(), account.LastExported
+	} else if lastExported.IsZero() {
+		lastExported = account.LastExported
+	}
+
+	log.Infof(context, "Export of data of user [%s] to tidepool since [%s]: [%s]", userEmail, lowerBound.Format(util.TIMEFORMAT), status)
+	if err := store.RecordTidepoolExport(context, userProfileKey, lastExported, status, now); err != nil {
+		log.Warningf(context, "Error recording tidepool export status [%s] of user [%s]: %v", status, userEmail, err)
+	}
+
+	return nil
+}
+
+// exportToTidepool uploads the records of the user between the bounds to the Tidepool account and returns the time of
+// the most recent one, the zero time if there weren't any
+func exportToTidepool(context context.Context, userEmail string, username string, encryptedPassword []byte, lowerBound time.Time,
+	upperBound time.Time) (lastExported time.Time, datumCount int, err error) {
+	password, err := util.Decrypt(appConfig.CredentialsEncryptionKey, encryptedPassword)
+	if err != nil {
+		return lastExported, 0, err
+	}
+
+	reads, err := store.GetGlucoseReads(context, userEmail, lowerBound, upperBound)
+	if err != nil {
+		return lastExported, 0, err
+	}
+
+	meals, err := store.GetMeals(context, userEmail, lowerBound, upperBound)
+	if err != nil {
+		return lastExported, 0, err
+	}
+
+	injections, err := store.GetInjections(context, userEmail, lowerBound, upperBound)
+	if err != nil {
+		return lastExported, 0, err
+	}
+
+	datums := export.CbgDatums(reads)
+	datums = append(datums, export.FoodDatums(meals)...)
+	datums = append(datums, export.BolusDatums(injections)...)
+	if len(datums) == 0 {
+		return lastExported, 0, nil
+	}
+
+	client := export.NewTidepoolClient(urlfetch.Client(context))
+	session, err := client.Login(username, string(password))
+	if err != nil {
+		return lastExported, 0, err
+	}
+
+	if err := client.Upload(session, datums, upperBound); err != nil {
+		return lastExported, 0, err
+	}
+
+	return latestRecordTime(reads, meals, injections), len(datums), nil
+}
+
+// latestRecordTime returns the time of the most recent of the records
+func latestRecordTime(reads []apimodel.GlucoseRead, meals []apimodel.Meal, injections []apimodel.Injection) (latest time.Time) {
+	for _, read := range reads {
+		if read.GetTime().After(latest) {
+			latest = read.GetTime()
+		}
+	}
+
+	for _, meal := range meals {
+		if meal.GetTime().After(latest) {
+			latest = meal.GetTime()
+		}
+	}
+
+	for _, injection := range injections {
+		if injection.GetTime().After(latest) {
+			latest = injection.GetTime()
+		}
+	}
+
+	return latest
+}
+
+// connectTidepool is the endpoint to connect the Tidepool account data gets exported to. The credentials are checked by
+// logging in before the password is stored encrypted. An export of the last TIDEPOOL_INITIAL_EXPORT_DAYS is enqueued
+// right away.
+func connectTidepool(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	username := strings.TrimSpace(request.FormValue(FORM_FIELD_TIDEPOOL_USERNAME))
+	password := request.FormValue(FORM_FIELD_TIDEPOOL_PASSWORD)
+	if username == "" || password == "" {
+		http.Error(writer, fmt.Sprintf("Missing value for %s or %s.", FORM_FIELD_TIDEPOOL_USERNAME, FORM_FIELD_TIDEPOOL_PASSWORD), 400)
+		return
+	}
+
+	if _, err := export.NewTidepoolClient(urlfetch.Client(context)).Login(username, password); err != nil {
+		log.Infof(context, "User [%s] couldn't log in to tidepool as [%s]: %v", user.Email, username, err)
+		http.Error(writer, "Couldn't log in to Tidepool with these credentials.", 400)
+		return
+	}
+
+	encryptedPassword, err := util.Encrypt(appConfig.CredentialsEncryptionKey, []byte(password))
+	if err != nil {
+		writeError(context, writer, err)
+		return
+	}
+
+	userProfileKey := store.GetUserKey(context, user.Email)
+	if err := store.StoreTidepoolCredentials(context, userProfileKey, username, encryptedPassword); err != nil {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Connected tidepool account [%s] of user [%s]", username, user.Email)
+
+	enqueueTidepoolExport(context, user.Email, userProfileKey)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
+
+// disconnectTidepool is the endpoint to stop exporting data to the Tidepool account of the logged in user. Its
+// credentials are deleted, data already exported stays in Tidepool.
+func disconnectTidepool(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := currentGlukitUser(context)
+
+	if err := store.DeleteTidepoolAccount(context, store.GetUserKey(context, user.Email)); err != nil && err != datastore.ErrNoSuchEntity {
+		writeError(context, writer, err)
+		return
+	}
+	log.Infof(context, "Disconnected tidepool of user [%s]", user.Email)
+
+	http.Redirect(writer, request, "/settings/profile", http.StatusSeeOther)
+}
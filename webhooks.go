@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/importer"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/lib/drive"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/urlfetch"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DELIVER_WEBHOOK_FUNCTION_NAME = "deliverWebhook"
+
+	// Deliveries are signed with the HMAC-SHA256 of the body keyed on the secret of the webhook, sent in hex as
+	// "sha256=<signature>"
+	WEBHOOK_SIGNATURE_HEADER        = "X-Glukit-Signature"
+	WEBHOOK_SIGNATURE_PREFIX        = "sha256="
+	WEBHOOK_EVENT_HEADER            = "X-Glukit-Event"
+	WEBHOOK_DELIVERY_ATTEMPT_HEADER = "X-Glukit-Delivery-Attempt"
+
+	// A failed delivery is retried after WEBHOOK_RETRY_BASE_DELAY, doubling after each attempt, until it's been
+	// attempted MAX_WEBHOOK_ATTEMPTS times
+	MAX_WEBHOOK_ATTEMPTS     = 3
+	WEBHOOK_RETRY_BASE_DELAY = time.Duration(1) * time.Minute
+)
+
+// retryWebhookDelivery is the task of deliverWebhook, initialized in init since it enqueues itself
+var retryWebhookDelivery *delay.Function
+
+// importCompletedEvent is the body of the WEBHOOK_EVENT_IMPORT_COMPLETED event
+type importCompletedEvent struct {
+	Event          string        `json:"event"`
+	OccurredOn     time.Time     `json:"occurredOn"`
+	File           webhookFile   `json:"file"`
+	Counts         webhookCounts `json:"counts"`
+	MostRecentRead time.Time     `json:"mostRecentRead"`
+}
+
+type webhookFile struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Modified string `json:"modified"`
+}
+
+// webhookCounts is the number of records imported, per kind
+type webhookCounts struct {
+	Reads        int `json:"reads"`
+	Calibrations int `json:"calibrations"`
+	Injections   int `json:"injections"`
+	BasalRates   int `json:"basalRates"`
+	Meals        int `json:"meals"`
+	Exercises    int `json:"exercises"`
+}
+
+// add adds the counts of the import report to the counts
+func (counts *webhookCounts) add(report *importer.ImportReport) {
+	counts.Reads += report.Reads
+	counts.Calibrations += report.Calibrations
+	counts.Injections += report.Injections
+	counts.BasalRates += report.BasalRates
+	counts.Meals += report.Meals
+	counts.Exercises += report.Exercises
+}
+
+// signWebhookBody returns the value of the WEBHOOK_SIGNATURE_HEADER of a delivery of the body
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return WEBHOOK_SIGNATURE_PREFIX + hex.EncodeToString(mac.Sum(nil))
+}
+
+// fireImportCompletedWebhook delivers the WEBHOOK_EVENT_IMPORT_COMPLETED event of the file to the webhook of the user,
+// if it's subscribed to it. The counts are the sum of the reports of the data files of the file.
+func fireImportCompletedWebhook(context context.Context, userEmail string, userProfileKey *datastore.Key, file *drive.File,
+	reports []*importer.ImportReport) {
+	webhook, err := store.GetWebhook(context, userProfileKey)
+	if err == datastore.ErrNoSuchEntity {
+		return
+	} else if err != nil {
+		log.Warningf(context, "Error getting webhook of user [%s], not notifying import of file [%s]: %v", userEmail, file.Id, err)
+		return
+	}
+
+	if !webhook.IsSubscribed(model.WEBHOOK_EVENT_IMPORT_COMPLETED) {
+		return
+	}
+
+	event := importCompletedEvent{Event: model.WEBHOOK_EVENT_IMPORT_COMPLETED, OccurredOn: time.Now(),
+		File: webhookFile{file.Id, file.OriginalFilename, file.ModifiedDate}}
+	for _, report := range reports {
+		event.Counts.add(report)
+	}
+
+	// The profile is read from the datastore, the cached one might not have the reads just imported yet
+	if glukitUser, err := store.GetUserProfile(context, userProfileKey); err != nil {
+		log.Warningf(context, "Error getting profile of user [%s] for import webhook, sending it without the most recent read: %v",
+			userEmail, err)
+	} else {
+		event.MostRecentRead = glukitUser.MostRecentRead.GetTime()
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf(context, "Error encoding import webhook of file [%s] for user [%s]: %v", file.Id, userEmail, err)
+		return
+	}
+
+	postWebhook(context, userEmail, userProfileKey, webhook, model.WEBHOOK_EVENT_IMPORT_COMPLETED, body, 1)
+}
+
+// deliverWebhook is the task retrying a failed delivery of the event. The webhook is read again so that a retry
+// doesn't go to a webhook that's been disabled or changed since.
+func deliverWebhook(context context.Context, userEmail string, event string, body []byte, attempt int) {
+	userProfileKey := store.GetUserKey(context, userEmail)
+	webhook, err := store.GetWebhook(context, userProfileKey)
+	if err == datastore.ErrNoSuchEntity {
+		return
+	} else if err != nil {
+		log.Warningf(context, "Error getting webhook of user [%s], giving up on delivery of [%s]: %v", userEmail, event, err)
+		return
+	}
+
+	if !webhook.IsSubscribed(event) {
+		log.Infof(context, "Webhook of user [%s] isn't subscribed to [%s] anymore, dropping delivery", userEmail, event)
+		return
+	}
+
+	postWebhook(context, userEmail, userProfileKey, webhook, event, body, attempt)
+}
+
+// postWebhook posts the signed body of the event to the webhook and records the status of the delivery. A failed
+// delivery is retried with a backoff until MAX_WEBHOOK_ATTEMPTS.
+func postWebhook(context context.Context, userEmail string, userProfileKey *datastore.Key, webhook *model.Webhook, event string,
+	body []byte, attempt int) {
+	status, delivered := "", false
+	request, err := http.NewRequest("POST", webhook.Url, bytes.NewReader(body))
+	if err == nil {
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(WEBHOOK_EVENT_HEADER, event)
+		request.Header.Set(WEBHOOK_DELIVERY_ATTEMPT_HEADER, strconv.Itoa(attempt))
+		request.Header.Set(WEBHOOK_SIGNATURE_HEADER, signWebhookBody(webhook.Secret, body))
+
+		var response *http.Response
+		if response, err = urlfetch.Client(context).Do(request); err == nil {
+			response.Body.Close()
+			status, delivered = response.Status, response.StatusCode >= 200 && response.StatusCode < 300
+		}
+	}
+
+	if err != nil {
+		status = err.Error()
+	}
+
+	log.Infof(context, "Delivery attempt [%d] of [%s] to webhook of user [%s]: [%s]", attempt, event, userEmail, status)
+	if err := store.RecordWebhookDelivery(context, userProfileKey, status, time.Now()); err != nil {
+		log.Warningf(context, "Error recording webhook delivery status [%s] of user [%s]: %v", status, userEmail, err)
+	}
+
+	if delivered {
+		return
+	}
+
+	if attempt >= MAX_WEBHOOK_ATTEMPTS {
+		log.Warningf(context, "Giving up on delivery of [%s] to webhook of user [%s] after [%d] attempts", event, userEmail, attempt)
+		return
+	}
+
+	task, err := retryWebhookDelivery.Task(userEmail, event, body, attempt+1)
+	if err != nil {
+		log.Warningf(context, "Error creating webhook delivery task for user [%s]: %v", userEmail, err)
+		return
+	}
+
+	task.ETA = time.Now().Add(WEBHOOK_RETRY_BASE_DELAY << uint(attempt-1))
+	if _, err := taskqueue.Add(context, task, REFRESH_QUEUE_NAME); err != nil {
+		log.Warningf(context, "Error enqueuing retry of [%s] to webhook of user [%s]: %v", event, userEmail, err)
+	}
+}
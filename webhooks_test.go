@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/alexandre-normand/glukit/app/importer"
+	"testing"
+)
+
+func TestWebhookBodyIsSignedWithHmacSha256(t *testing.T) {
+	signature := signWebhookBody("key", []byte("The quick brown fox jumps over the lazy dog"))
+
+	expected := WEBHOOK_SIGNATURE_PREFIX + "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if signature != expected {
+		t.Errorf("Expected signature [%s] but got [%s]", expected, signature)
+	}
+}
+
+func TestWebhookCountsSumReports(t *testing.T) {
+	var counts webhookCounts
+	counts.add(&importer.ImportReport{Reads: 10, Calibrations: 1, Meals: 2})
+	counts.add(&importer.ImportReport{Reads: 5, Injections: 3, BasalRates: 4, Exercises: 1})
+
+	expected := webhookCounts{15, 1, 3, 4, 2, 1}
+	if counts != expected {
+		t.Errorf("Expected counts [%v] but got [%v]", expected, counts)
+	}
+}